@@ -0,0 +1,274 @@
+// Package audit periodically verifies the integrity of the blobs stored by a blossy server,
+// protecting operators against silent data corruption (e.g. bit rot or a faulty disk).
+//
+// An [Auditor] loads the blobs from a [Source] (typically [index.Index.Blobs]), re-reads every blob
+// with a [FetchFunc] (for example [FromStore]), recomputes its SHA-256 and reports the blobs that are
+// corrupted or missing with a user provided [ReportFunc]. The results are recorded in the metrics of the server, if configured.
+//
+// Example:
+//
+//	auditor := audit.New(idx.Blobs, audit.FromStore(store),
+//	    func(ctx context.Context, f audit.Finding) {
+//	        slog.Error("blob integrity", "hash", f.Hash, "problem", f.Problem)
+//	    },
+//	    audit.WithInterval(24*time.Hour),
+//	    audit.WithMetrics(m),
+//	)
+//	auditor.Bind(server) // runs every day while the server is serving
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/index"
+	"github.com/pippellia-btc/blossy/metrics"
+)
+
+// Source returns the blobs to verify, for example [index.Index.Blobs].
+type Source func(ctx context.Context) ([]index.Blob, error)
+
+// FetchFunc returns the content of the stored blob with the hash, or [blossy.ErrBlobNotFound] if it's missing.
+type FetchFunc func(ctx context.Context, hash blossom.Hash) (io.ReadCloser, error)
+
+// FromStore returns a [FetchFunc] that reads the blobs from the store.
+func FromStore(store blossy.Store) FetchFunc {
+	return func(ctx context.Context, hash blossom.Hash) (io.ReadCloser, error) {
+		return store.Get(ctx, hash)
+	}
+}
+
+// Problem is the kind of integrity problem of a blob.
+type Problem string
+
+const (
+	// Corrupted means that the SHA-256 of the stored content doesn't match the hash of the blob.
+	Corrupted Problem = "corrupted"
+
+	// Missing means that the blob is in the source, but not in the storage.
+	Missing Problem = "missing"
+)
+
+// Finding is a blob that failed the audit.
+type Finding struct {
+	Hash    blossom.Hash
+	Problem Problem
+
+	// Actual is the SHA-256 of the stored content. It's zero if the blob is missing.
+	Actual blossom.Hash
+
+	// Size is the number of bytes read from the storage.
+	Size int64
+}
+
+// ReportFunc is called for every blob that failed the audit, for example to alert the operator,
+// restore the blob from a replica or delete it.
+type ReportFunc func(ctx context.Context, f Finding)
+
+// Report summarizes a run of an [Auditor].
+type Report struct {
+	Verified  int   // number of blobs whose content matches their hash
+	Corrupted int   // number of blobs whose content doesn't match their hash
+	Missing   int   // number of blobs not found in the storage
+	Failed    int   // number of blobs that couldn't be read
+	Bytes     int64 // total number of bytes read
+}
+
+// Auditor verifies the integrity of the blobs. Create one with [New].
+type Auditor struct {
+	source Source
+	fetch  FetchFunc
+	report ReportFunc
+
+	// evict removes the blobs of the findings from the blob cache of the bound server, if any. See [blossy.Server.Evict].
+	evict func(hash blossom.Hash)
+
+	interval time.Duration
+	pause    time.Duration
+	metrics  *metrics.Metrics
+	log      *slog.Logger
+}
+
+type Option func(*Auditor)
+
+// WithInterval sets how often the audit runs when started with [Auditor.Run] or [Auditor.Bind]. By default, it's one day.
+func WithInterval(d time.Duration) Option {
+	return func(a *Auditor) {
+		a.interval = d
+	}
+}
+
+// WithPause sets the wait between two blobs, to limit the load that the audit puts on the storage.
+// By default, there is no pause.
+func WithPause(d time.Duration) Option {
+	return func(a *Auditor) {
+		a.pause = d
+	}
+}
+
+// WithMetrics records the result of every verified blob in the metrics.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(a *Auditor) {
+		a.metrics = m
+	}
+}
+
+// WithLogger sets the logger of the auditor. By default, it's [slog.Default].
+func WithLogger(l *slog.Logger) Option {
+	return func(a *Auditor) {
+		a.log = l
+	}
+}
+
+// New returns an [Auditor] that verifies the blobs of the source, reading them with fetch, and calls report
+// for the ones that are corrupted or missing.
+// It panics if the source, the fetch or the report function is nil, or the options are invalid.
+func New(source Source, fetch FetchFunc, report ReportFunc, opts ...Option) *Auditor {
+	if source == nil {
+		panic("audit.New: source must not be nil")
+	}
+	if fetch == nil {
+		panic("audit.New: fetch function must not be nil")
+	}
+	if report == nil {
+		panic("audit.New: report function must not be nil")
+	}
+
+	a := &Auditor{
+		source:   source,
+		fetch:    fetch,
+		report:   report,
+		interval: 24 * time.Hour,
+		log:      slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	if a.interval <= 0 {
+		panic("audit.New: interval must be positive")
+	}
+	if a.pause < 0 {
+		panic("audit.New: pause must not be negative")
+	}
+	if a.log == nil {
+		panic("audit.New: logger must not be nil")
+	}
+	return a
+}
+
+// Bind runs the audit in the background of the server while it's serving (see [blossy.Server.Background]).
+// The blobs of the findings are evicted from the blob cache of the server after being reported,
+// so that the cache doesn't keep serving the blobs that the report function deleted or restored.
+func (a *Auditor) Bind(s *blossy.Server) {
+	a.evict = s.Evict
+	s.Background(a.Run)
+}
+
+// Run audits the blobs every interval, until the context is cancelled.
+// The first run happens after one interval, so that restarts don't trigger it.
+func (a *Auditor) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			report, err := a.RunOnce(ctx)
+			if err != nil {
+				a.log.Error("audit: run failed", "error", err)
+			}
+			a.log.Info("audit: run completed", "verified", report.Verified, "corrupted", report.Corrupted,
+				"missing", report.Missing, "failed", report.Failed, "bytes", report.Bytes)
+		}
+	}
+}
+
+// RunOnce audits the blobs once, returning the results.
+// Blobs that can't be read don't stop the run, and their errors are returned joined.
+func (a *Auditor) RunOnce(ctx context.Context) (Report, error) {
+	blobs, err := a.source(ctx)
+	if err != nil {
+		return Report{}, fmt.Errorf("audit: failed to load the blobs: %w", err)
+	}
+
+	var report Report
+	var errs []error
+
+	for i, b := range blobs {
+		if i > 0 && a.pause > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(a.pause):
+			}
+		}
+		if ctx.Err() != nil {
+			return report, errors.Join(append(errs, ctx.Err())...)
+		}
+
+		finding, err := a.verify(ctx, b.Hash)
+		report.Bytes += finding.Size
+
+		switch {
+		case err != nil:
+			report.Failed++
+			a.metrics.ObserveAudit("failed")
+			errs = append(errs, fmt.Errorf("audit: blob %s: %w", b.Hash.Hex(), err))
+
+		case finding.Problem == "":
+			report.Verified++
+			a.metrics.ObserveAudit("ok")
+
+		default:
+			if finding.Problem == Corrupted {
+				report.Corrupted++
+			} else {
+				report.Missing++
+			}
+			a.metrics.ObserveAudit(string(finding.Problem))
+			a.report(ctx, finding)
+			if a.evict != nil {
+				a.evict(finding.Hash)
+			}
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// verify reads the blob and compares the SHA-256 of its content with its hash.
+// The problem of the returned finding is empty if the blob is intact.
+func (a *Auditor) verify(ctx context.Context, hash blossom.Hash) (Finding, error) {
+	finding := Finding{Hash: hash}
+
+	data, err := a.fetch(ctx, hash)
+	if errors.Is(err, blossy.ErrBlobNotFound) {
+		finding.Problem = Missing
+		return finding, nil
+	}
+	if err != nil {
+		return finding, err
+	}
+	defer data.Close()
+
+	h := sha256.New()
+	finding.Size, err = io.Copy(h, data)
+	if err != nil {
+		return finding, err
+	}
+
+	copy(finding.Actual[:], h.Sum(nil))
+	if finding.Actual != hash {
+		finding.Problem = Corrupted
+	}
+	return finding, nil
+}
@@ -0,0 +1,178 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/cache"
+	"github.com/pippellia-btc/blossy/index"
+	"github.com/pippellia-btc/blossy/metrics"
+	"github.com/pippellia-btc/blossy/stores/memory"
+)
+
+var (
+	intact    = []byte("intact")
+	corrupted = []byte("corrupted")
+	missing   = []byte("missing")
+	broken    = []byte("broken")
+)
+
+// fakeStorage holds the content of the blobs by hash.
+type fakeStorage map[blossom.Hash][]byte
+
+func (s fakeStorage) fetch(ctx context.Context, hash blossom.Hash) (io.ReadCloser, error) {
+	data, ok := s[hash]
+	if !ok {
+		return nil, blossy.ErrBlobNotFound
+	}
+	if bytes.Equal(data, broken) {
+		return nil, errors.New("input/output error")
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func source(data ...[]byte) Source {
+	return func(ctx context.Context) ([]index.Blob, error) {
+		blobs := make([]index.Blob, len(data))
+		for i, d := range data {
+			blobs[i] = index.Blob{Hash: blossom.ComputeHash(d), Size: int64(len(d))}
+		}
+		return blobs, nil
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	storage := fakeStorage{
+		blossom.ComputeHash(intact):    intact,
+		blossom.ComputeHash(corrupted): []byte("corrupteD"),
+		blossom.ComputeHash(broken):    broken,
+	}
+
+	var findings []Finding
+	report := func(ctx context.Context, f Finding) { findings = append(findings, f) }
+
+	m := metrics.New()
+	auditor := New(source(intact, corrupted, missing, broken), storage.fetch, report, WithMetrics(m))
+
+	r, err := auditor.RunOnce(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "input/output error") {
+		t.Errorf("expected the error of the broken blob, got %v", err)
+	}
+
+	expected := Report{Verified: 1, Corrupted: 1, Missing: 1, Failed: 1, Bytes: int64(len(intact) + len(corrupted))}
+	if r != expected {
+		t.Errorf("expected report %+v, got %+v", expected, r)
+	}
+
+	if len(findings) != 2 {
+		t.Fatalf("expected 2 findings, got %d", len(findings))
+	}
+	if f := findings[0]; f.Hash != blossom.ComputeHash(corrupted) || f.Problem != Corrupted || f.Actual != blossom.ComputeHash([]byte("corrupteD")) {
+		t.Errorf("unexpected finding of the corrupted blob: %+v", f)
+	}
+	if f := findings[1]; f.Hash != blossom.ComputeHash(missing) || f.Problem != Missing || !f.Actual.IsZero() {
+		t.Errorf("unexpected finding of the missing blob: %+v", f)
+	}
+
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatalf("failed to write the metrics: %v", err)
+	}
+	for _, line := range []string{
+		`blossy_audited_blobs_total{result="ok"} 1`,
+		`blossy_audited_blobs_total{result="corrupted"} 1`,
+		`blossy_audited_blobs_total{result="missing"} 1`,
+		`blossy_audited_blobs_total{result="failed"} 1`,
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("expected the metrics to contain %q", line)
+		}
+	}
+}
+
+func TestRunOnceCancelled(t *testing.T) {
+	storage := fakeStorage{blossom.ComputeHash(intact): intact}
+	auditor := New(source(intact, intact, intact), storage.fetch, func(context.Context, Finding) {}, WithPause(time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	r, err := auditor.RunOnce(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to stop the run, got %v", err)
+	}
+	if r.Verified != 1 {
+		t.Errorf("expected 1 verified blob before the pause, got %d", r.Verified)
+	}
+}
+
+func TestFromStore(t *testing.T) {
+	store := memory.New()
+	if _, err := store.Save(context.Background(), "", blossy.UploadHints{}, bytes.NewReader(intact)); err != nil {
+		t.Fatalf("failed to save the blob: %v", err)
+	}
+
+	var findings []Finding
+	auditor := New(source(intact, missing), FromStore(store), func(ctx context.Context, f Finding) { findings = append(findings, f) })
+
+	r, err := auditor.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.Verified != 1 || r.Missing != 1 {
+		t.Errorf("expected 1 verified and 1 missing blob, got %+v", r)
+	}
+	if len(findings) != 1 || findings[0].Problem != Missing {
+		t.Errorf("expected the missing blob to be reported, got %+v", findings)
+	}
+}
+
+func TestBindEvict(t *testing.T) {
+	server, err := blossy.NewServer(blossy.WithBlobCache(cache.NewMemory()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := blossom.ComputeHash(corrupted)
+	storage := fakeStorage{hash: corrupted}
+	server.On.Download = func(r blossy.Request, h blossom.Hash, ext string) (blossy.BlobDelivery, *blossom.Error) {
+		data, ok := storage[h]
+		if !ok {
+			return nil, blossom.ErrNotFound("blob not found")
+		}
+		return blossy.Serve(blossom.BlobFromBytes(data)), nil
+	}
+
+	// the report function deletes the corrupted blobs
+	report := func(ctx context.Context, f Finding) { delete(storage, f.Hash) }
+	auditor := New(source(corrupted), storage.fetch, report)
+	auditor.Bind(server)
+
+	download := func() int {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+hash.Hex(), nil))
+		return w.Code
+	}
+
+	// the first download caches the blob, which is then corrupted in the storage
+	if code := download(); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	storage[hash] = []byte("corrupteD")
+
+	if _, err := auditor.RunOnce(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if code := download(); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for the deleted blob, got %d", code)
+	}
+}
@@ -34,6 +34,8 @@ var (
 
 // Authenticate validates the authorization event against the provided hostname and hash,
 // and returns the pubkey of the signed event if valid.
+// The event can be a Blossom authorization event (kind 24242) or a Nostr Web Token (kind 27519).
+// The hash is matched against the 'x' tags of Blossom authorization events and the 'x' claims of Nostr Web Tokens.
 // If the "Authorization" header is missing, it returns an empty pubkey.
 // If the "Authorization" header is present but the event is invalid, it returns an error.
 //
@@ -41,6 +43,11 @@ var (
 // The distinction is important because a GET might require the hash 000...000,
 // while an upload might not have a hash at all in the Content-Digest header.
 func Authenticate(r *http.Request, hostname string, hash *blossom.Hash) (pubkey string, err error) {
+	return AuthenticateWith(r, hostname, hash, Options{})
+}
+
+// AuthenticateWith is like [Authenticate], but it validates the time bounds of the event with the provided [Options].
+func AuthenticateWith(r *http.Request, hostname string, hash *blossom.Hash, opts Options) (pubkey string, err error) {
 	event, err := ExtractEvent(r)
 	if errors.Is(err, ErrMissingHeader) {
 		return "", nil
@@ -71,12 +78,20 @@ func Authenticate(r *http.Request, hostname string, hash *blossom.Hash) (pubkey
 		if err != nil {
 			return "", fmt.Errorf("auth failed: %w", err)
 		}
-		if err := auth.Validate(action, hash, hostname); err != nil {
+		if err := auth.ValidateWith(action, hash, hostname, opts); err != nil {
 			return "", fmt.Errorf("auth failed: %w", err)
 		}
 		return auth.Pubkey, nil
 
-	// TODO: Add NWT support
+	case KindNWT:
+		token, err := ParseNWT(event)
+		if err != nil {
+			return "", fmt.Errorf("auth failed: %w", err)
+		}
+		if err := token.ValidateWith(action, hash, hostname, opts); err != nil {
+			return "", fmt.Errorf("auth failed: %w", err)
+		}
+		return token.Pubkey, nil
 
 	default:
 		return "", fmt.Errorf("auth failed: unsupported event kind: %d", event.Kind)
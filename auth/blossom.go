@@ -17,6 +17,53 @@ const (
 	MaxTags          = 512
 )
 
+// Options configures the validation of the time bounds of authorization events.
+// The zero value uses the [DefaultClockSkew] and accepts events of any age.
+type Options struct {
+	// ClockSkew is the tolerance applied to the time bounds of the events, to account for
+	// clocks that are not in sync. If zero, [DefaultClockSkew] is used.
+	ClockSkew time.Duration
+
+	// MaxAge is the maximum time elapsed since the creation of the event.
+	// If zero, events are accepted until they expire.
+	MaxAge time.Duration
+
+	// Aliases are the other hostnames of the server. Events bound to any of them are accepted,
+	// as well as the ones bound to the validated hostname.
+	Aliases []string
+}
+
+// matchesHost reports whether the claimed hostnames contain the hostname or one of the aliases.
+func (o Options) matchesHost(claimed []string, hostname string) bool {
+	return slices.Contains(claimed, hostname) || slices.ContainsFunc(o.Aliases, func(alias string) bool {
+		return slices.Contains(claimed, alias)
+	})
+}
+
+func (o Options) clockSkew() time.Duration {
+	if o.ClockSkew == 0 {
+		return DefaultClockSkew
+	}
+	return o.ClockSkew
+}
+
+// validateTimes checks that an event created at the provided time and with the provided expiration is valid now.
+func (o Options) validateTimes(createdAt, expiration time.Time) error {
+	now := time.Now()
+	min := now.Add(-o.clockSkew())
+	max := now.Add(o.clockSkew())
+	if createdAt.After(max) {
+		return errors.New("event created at is in the future")
+	}
+	if expiration.Before(min) {
+		return errors.New("event expiration is in the past")
+	}
+	if o.MaxAge > 0 && createdAt.Before(min.Add(-o.MaxAge)) {
+		return fmt.Errorf("event is older than %s", o.MaxAge)
+	}
+	return nil
+}
+
 // BlossomAuth represents a parsed Blossom authorization event.
 type BlossomAuth struct {
 	Pubkey     string
@@ -28,23 +75,27 @@ type BlossomAuth struct {
 }
 
 // Validate validates the Blossom authorization event time bounds and
-// against the expected action, hash and server hostname.
+// against the expected action, hash and server hostname, using the default [Options].
 // A nil hash means no hash was provided to match against (e.g. upload without Content-Digest).
 func (a *BlossomAuth) Validate(action Action, hash *blossom.Hash, hostname string) error {
-	now := time.Now()
-	min := now.Add(-DefaultClockSkew)
-	max := now.Add(DefaultClockSkew)
-	if a.CreatedAt.After(max) {
-		return errors.New("event created at is in the future")
-	}
-	if a.Expiration.Before(min) {
-		return errors.New("event expiration is in the past")
+	return a.ValidateWith(action, hash, hostname, Options{})
+}
+
+// ValidateWith is like [BlossomAuth.Validate], but it validates the time bounds with the provided [Options].
+func (a *BlossomAuth) ValidateWith(action Action, hash *blossom.Hash, hostname string, opts Options) error {
+	if err := opts.validateTimes(a.CreatedAt, a.Expiration); err != nil {
+		return err
 	}
 
 	if a.Action != action {
 		return fmt.Errorf("expected action %s, got %s", action, a.Action)
 	}
 
+	if action == ActionDelete && len(a.Hashes) == 0 {
+		// a delete event without x tags would be valid for all blobs.
+		return errors.New("delete auth event must have at least one 'x' tag")
+	}
+
 	if len(a.Hashes) > 0 {
 		// no x tags means the event is considered valid for all blobs.
 		// If there are x tags, the hash must be provided to match against.
@@ -58,7 +109,7 @@ func (a *BlossomAuth) Validate(action Action, hash *blossom.Hash, hostname strin
 
 	// no server tags means the event is considered valid for all servers
 	if len(a.Hostnames) > 0 {
-		if !slices.Contains(a.Hostnames, hostname) {
+		if !opts.matchesHost(a.Hostnames, hostname) {
 			return fmt.Errorf("expected server hostname %s, got %s", hostname, a.Hostnames)
 		}
 	}
@@ -293,6 +293,29 @@ func TestBlossomAuth_Validate(t *testing.T) {
 			hostname: "cdn.example.com",
 			isValid:  true,
 		},
+		{
+			name: "delete without hashes",
+			auth: BlossomAuth{
+				CreatedAt:  time.Now(),
+				Expiration: time.Now().Add(5 * time.Minute),
+				Action:     ActionDelete,
+			},
+			action:  ActionDelete,
+			hash:    &testHash,
+			isValid: false,
+		},
+		{
+			name: "delete wrong hash",
+			auth: BlossomAuth{
+				CreatedAt:  time.Now(),
+				Expiration: time.Now().Add(5 * time.Minute),
+				Action:     ActionDelete,
+				Hashes:     []blossom.Hash{otherHash},
+			},
+			action:  ActionDelete,
+			hash:    &testHash,
+			isValid: false,
+		},
 		{
 			name: "created_at future",
 			auth: BlossomAuth{
@@ -401,3 +424,70 @@ func TestBlossomAuth_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestBlossomAuth_ValidateWith(t *testing.T) {
+	tests := []struct {
+		name    string
+		auth    BlossomAuth
+		opts    Options
+		isValid bool
+	}{
+		{
+			name: "within clock skew",
+			auth: BlossomAuth{
+				CreatedAt:  time.Now().Add(time.Minute),
+				Expiration: time.Now().Add(5 * time.Minute),
+				Action:     ActionGet,
+			},
+			opts:    Options{ClockSkew: 2 * time.Minute},
+			isValid: true,
+		},
+		{
+			name: "beyond clock skew",
+			auth: BlossomAuth{
+				CreatedAt:  time.Now().Add(time.Minute),
+				Expiration: time.Now().Add(5 * time.Minute),
+				Action:     ActionGet,
+			},
+			opts:    Options{ClockSkew: 30 * time.Second},
+			isValid: false,
+		},
+		{
+			name: "within max age",
+			auth: BlossomAuth{
+				CreatedAt:  time.Now().Add(-time.Minute),
+				Expiration: time.Now().Add(time.Hour),
+				Action:     ActionGet,
+			},
+			opts:    Options{MaxAge: 5 * time.Minute},
+			isValid: true,
+		},
+		{
+			name: "older than max age",
+			auth: BlossomAuth{
+				CreatedAt:  time.Now().Add(-10 * time.Minute),
+				Expiration: time.Now().Add(time.Hour),
+				Action:     ActionGet,
+			},
+			opts:    Options{MaxAge: 5 * time.Minute},
+			isValid: false,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			err := test.auth.ValidateWith(ActionGet, nil, "", test.opts)
+
+			if !test.isValid {
+				if err == nil {
+					t.Fatalf("expected error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
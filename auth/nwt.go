@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+)
+
+// KindNWT is the kind of Nostr Web Tokens.
+const KindNWT = 27519
+
+// NWT represents a parsed Nostr Web Token, a signed nostr event whose tags are claims,
+// modelled after the registered claims of JSON Web Tokens (RFC 7519).
+//
+// The supported claims are:
+//   - ["exp", <unix>]: the expiration time (required).
+//   - ["nbf", <unix>]: the time before which the token must not be accepted.
+//   - ["aud", <hostname or URL>]: the servers the token is intended for (required). Can be repeated.
+//   - ["scope", <actions>]: the space separated actions the token authorizes (e.g. "upload delete"). Can be repeated.
+//   - ["x", <sha256>]: the blobs the token is bound to. Can be repeated, and it's required to authorize deletes.
+//
+// The issuer is the pubkey of the event, and the issued at time is its created_at.
+type NWT struct {
+	Pubkey     string
+	IssuedAt   time.Time
+	NotBefore  time.Time // zero if not set
+	Expiration time.Time
+	Audience   []string
+	Scopes     []Action
+	Hashes     []blossom.Hash
+}
+
+// Validate validates the token time bounds, and that it's intended for the server hostname,
+// authorizes the expected action and is bound to the hash if it has 'x' claims, using the default [Options].
+// A nil hash means no hash was provided to match against (e.g. upload without Content-Digest).
+func (t *NWT) Validate(action Action, hash *blossom.Hash, hostname string) error {
+	return t.ValidateWith(action, hash, hostname, Options{})
+}
+
+// ValidateWith is like [NWT.Validate], but it validates the time bounds with the provided [Options].
+func (t *NWT) ValidateWith(action Action, hash *blossom.Hash, hostname string, opts Options) error {
+	if err := opts.validateTimes(t.IssuedAt, t.Expiration); err != nil {
+		return err
+	}
+	if !t.NotBefore.IsZero() && t.NotBefore.After(time.Now().Add(opts.clockSkew())) {
+		return errors.New("token is not valid yet")
+	}
+
+	if !slices.Contains(t.Scopes, action) {
+		return fmt.Errorf("expected scope %s, got %s", action, t.Scopes)
+	}
+
+	if action == ActionDelete && len(t.Hashes) == 0 {
+		// a delete token without x claims would be valid for all blobs.
+		return errors.New("delete token must have at least one 'x' claim")
+	}
+
+	if len(t.Hashes) > 0 {
+		if hash == nil {
+			return ErrMissingHash
+		}
+		if !slices.Contains(t.Hashes, *hash) {
+			return fmt.Errorf("expected hash %s, got %s", *hash, t.Hashes)
+		}
+	}
+
+	// tokens are long-lived bearer credentials, so unlike Blossom authorization events
+	// they must always name the servers they are intended for.
+	if len(t.Audience) == 0 {
+		return errors.New("token must have at least one 'aud' claim")
+	}
+	if !opts.matchesHost(t.Audience, hostname) {
+		return fmt.Errorf("expected audience %s, got %s", hostname, t.Audience)
+	}
+	return nil
+}
+
+// ParseNWT parses the Nostr Web Token from the provided Nostr event.
+// It returns an error if the event is structurally invalid, but doesn't validate the token
+// against the expected claims. Scopes that are not blossom actions are ignored.
+func ParseNWT(e *nostr.Event) (*NWT, error) {
+	if e == nil {
+		return nil, errors.New("event is nil")
+	}
+	if e.Kind != KindNWT {
+		return nil, fmt.Errorf("event kind is not %d", KindNWT)
+	}
+	if len(e.Tags) > MaxTags {
+		return nil, errors.New("event has too many tags")
+	}
+
+	token := &NWT{
+		Pubkey:   e.PubKey,
+		IssuedAt: e.CreatedAt.Time(),
+	}
+
+	foundExp := false
+	foundNbf := false
+
+	for _, tag := range e.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+
+		switch tag[0] {
+		case "exp":
+			if foundExp {
+				return nil, errors.New("'exp' tag appears multiple times")
+			}
+			foundExp = true
+
+			unix, err := strconv.ParseInt(tag[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("'exp' tag is not a valid unix time: %w", err)
+			}
+			token.Expiration = time.Unix(unix, 0).UTC()
+
+		case "nbf":
+			if foundNbf {
+				return nil, errors.New("'nbf' tag appears multiple times")
+			}
+			foundNbf = true
+
+			unix, err := strconv.ParseInt(tag[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("'nbf' tag is not a valid unix time: %w", err)
+			}
+			token.NotBefore = time.Unix(unix, 0).UTC()
+
+		case "aud":
+			token.Audience = append(token.Audience, audienceHost(tag[1]))
+
+		case "x":
+			hash, err := blossom.ParseHash(tag[1])
+			if err == nil {
+				token.Hashes = append(token.Hashes, hash)
+			}
+
+		case "scope":
+			for scope := range strings.FieldsSeq(tag[1]) {
+				if action := Action(scope); slices.Contains(validActions, action) {
+					token.Scopes = append(token.Scopes, action)
+				}
+			}
+		}
+	}
+
+	if !foundExp {
+		return nil, errors.New("'exp' tag is missing")
+	}
+	return token, nil
+}
+
+// audienceHost returns the hostname of an audience, which can be a hostname or a URL.
+func audienceHost(aud string) string {
+	if u, err := url.Parse(aud); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	return aud
+}
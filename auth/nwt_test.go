@@ -0,0 +1,314 @@
+package auth
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+)
+
+func TestParseNWT(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   *nostr.Event
+		isValid bool
+	}{
+		{
+			name: "valid",
+			event: &nostr.Event{
+				Kind:      KindNWT,
+				PubKey:    testPubkey,
+				CreatedAt: nostr.Timestamp(time.Now().Unix()),
+				Tags: nostr.Tags{
+					{"exp", futureExp},
+					{"aud", "cdn.example.com"},
+					{"scope", "upload delete"},
+				},
+			},
+			isValid: true,
+		},
+		{
+			name: "no audience nor scope",
+			event: &nostr.Event{
+				Kind:      KindNWT,
+				PubKey:    testPubkey,
+				CreatedAt: nostr.Timestamp(time.Now().Unix()),
+				Tags:      nostr.Tags{{"exp", futureExp}},
+			},
+			isValid: true,
+		},
+		{
+			name: "wrong kind",
+			event: &nostr.Event{
+				Kind:      KindBlossomAuth,
+				PubKey:    testPubkey,
+				CreatedAt: nostr.Timestamp(time.Now().Unix()),
+				Tags:      nostr.Tags{{"exp", futureExp}},
+			},
+			isValid: false,
+		},
+		{
+			name: "missing exp",
+			event: &nostr.Event{
+				Kind:      KindNWT,
+				PubKey:    testPubkey,
+				CreatedAt: nostr.Timestamp(time.Now().Unix()),
+				Tags:      nostr.Tags{{"scope", "upload"}},
+			},
+			isValid: false,
+		},
+		{
+			name: "duplicate exp",
+			event: &nostr.Event{
+				Kind:      KindNWT,
+				PubKey:    testPubkey,
+				CreatedAt: nostr.Timestamp(time.Now().Unix()),
+				Tags:      nostr.Tags{{"exp", futureExp}, {"exp", futureExp}},
+			},
+			isValid: false,
+		},
+		{
+			name: "invalid nbf",
+			event: &nostr.Event{
+				Kind:      KindNWT,
+				PubKey:    testPubkey,
+				CreatedAt: nostr.Timestamp(time.Now().Unix()),
+				Tags:      nostr.Tags{{"exp", futureExp}, {"nbf", "tomorrow"}},
+			},
+			isValid: false,
+		},
+		{
+			name:    "nil event",
+			event:   nil,
+			isValid: false,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			_, err := ParseNWT(test.event)
+
+			if !test.isValid {
+				if err == nil {
+					t.Fatalf("expected error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseNWT_Fields(t *testing.T) {
+	nbf := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	event := &nostr.Event{
+		Kind:      KindNWT,
+		PubKey:    testPubkey,
+		CreatedAt: nostr.Timestamp(time.Now().Unix()),
+		Tags: nostr.Tags{
+			{"exp", futureExp},
+			{"nbf", nbf},
+			{"aud", "https://cdn.example.com/upload"},
+			{"aud", "blossom.example.com"},
+			{"scope", "upload profile:read"},
+			{"scope", "list"},
+			{"x", testHash.Hex()},
+			{"x", "not a hash"},
+		},
+	}
+
+	token, err := ParseNWT(event)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if token.Pubkey != testPubkey {
+		t.Errorf("expected pubkey %s, got %s", testPubkey, token.Pubkey)
+	}
+	if token.NotBefore.IsZero() {
+		t.Errorf("expected not before to be set")
+	}
+	if fmt.Sprint(token.Audience) != "[cdn.example.com blossom.example.com]" {
+		t.Errorf("expected audience [cdn.example.com blossom.example.com], got %v", token.Audience)
+	}
+	if fmt.Sprint(token.Scopes) != "[upload list]" {
+		t.Errorf("expected scopes [upload list], got %v", token.Scopes)
+	}
+	if len(token.Hashes) != 1 || token.Hashes[0] != testHash {
+		t.Errorf("expected hashes [%s], got %v", testHash, token.Hashes)
+	}
+}
+
+func TestNWT_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    NWT
+		action   Action
+		hash     *blossom.Hash
+		hostname string
+		isValid  bool
+	}{
+		{
+			name: "valid",
+			token: NWT{
+				IssuedAt:   time.Now(),
+				Expiration: time.Now().Add(5 * time.Minute),
+				Audience:   []string{"cdn.example.com"},
+				Scopes:     []Action{ActionUpload},
+			},
+			action:   ActionUpload,
+			hostname: "cdn.example.com",
+			isValid:  true,
+		},
+		{
+			name: "no audience",
+			token: NWT{
+				IssuedAt:   time.Now(),
+				Expiration: time.Now().Add(5 * time.Minute),
+				Scopes:     []Action{ActionList},
+			},
+			action:   ActionList,
+			hostname: "cdn.example.com",
+			isValid:  false,
+		},
+		{
+			name: "delete with matching hash",
+			token: NWT{
+				IssuedAt:   time.Now(),
+				Expiration: time.Now().Add(5 * time.Minute),
+				Audience:   []string{"cdn.example.com"},
+				Scopes:     []Action{ActionDelete},
+				Hashes:     []blossom.Hash{testHash},
+			},
+			action:   ActionDelete,
+			hash:     &testHash,
+			hostname: "cdn.example.com",
+			isValid:  true,
+		},
+		{
+			name: "delete without hash",
+			token: NWT{
+				IssuedAt:   time.Now(),
+				Expiration: time.Now().Add(5 * time.Minute),
+				Audience:   []string{"cdn.example.com"},
+				Scopes:     []Action{ActionDelete},
+			},
+			action:   ActionDelete,
+			hash:     &testHash,
+			hostname: "cdn.example.com",
+			isValid:  false,
+		},
+		{
+			name: "delete of another blob",
+			token: NWT{
+				IssuedAt:   time.Now(),
+				Expiration: time.Now().Add(5 * time.Minute),
+				Audience:   []string{"cdn.example.com"},
+				Scopes:     []Action{ActionDelete},
+				Hashes:     []blossom.Hash{testHash},
+			},
+			action:   ActionDelete,
+			hash:     &blossom.Hash{},
+			hostname: "cdn.example.com",
+			isValid:  false,
+		},
+		{
+			name: "upload bound to a hash, no hash provided",
+			token: NWT{
+				IssuedAt:   time.Now(),
+				Expiration: time.Now().Add(5 * time.Minute),
+				Audience:   []string{"cdn.example.com"},
+				Scopes:     []Action{ActionUpload},
+				Hashes:     []blossom.Hash{testHash},
+			},
+			action:   ActionUpload,
+			hostname: "cdn.example.com",
+			isValid:  false,
+		},
+		{
+			name: "wrong audience",
+			token: NWT{
+				IssuedAt:   time.Now(),
+				Expiration: time.Now().Add(5 * time.Minute),
+				Audience:   []string{"other.example.com"},
+				Scopes:     []Action{ActionUpload},
+			},
+			action:   ActionUpload,
+			hostname: "cdn.example.com",
+			isValid:  false,
+		},
+		{
+			name: "wrong scope",
+			token: NWT{
+				IssuedAt:   time.Now(),
+				Expiration: time.Now().Add(5 * time.Minute),
+				Scopes:     []Action{ActionGet},
+			},
+			action:  ActionDelete,
+			isValid: false,
+		},
+		{
+			name: "no scopes",
+			token: NWT{
+				IssuedAt:   time.Now(),
+				Expiration: time.Now().Add(5 * time.Minute),
+			},
+			action:  ActionGet,
+			isValid: false,
+		},
+		{
+			name: "expired",
+			token: NWT{
+				IssuedAt:   time.Now().Add(-time.Hour),
+				Expiration: time.Now().Add(-time.Minute),
+				Scopes:     []Action{ActionGet},
+			},
+			action:  ActionGet,
+			isValid: false,
+		},
+		{
+			name: "not valid yet",
+			token: NWT{
+				IssuedAt:   time.Now(),
+				NotBefore:  time.Now().Add(time.Minute),
+				Expiration: time.Now().Add(5 * time.Minute),
+				Scopes:     []Action{ActionGet},
+			},
+			action:  ActionGet,
+			isValid: false,
+		},
+		{
+			name: "issued in the future",
+			token: NWT{
+				IssuedAt:   time.Now().Add(time.Minute),
+				Expiration: time.Now().Add(5 * time.Minute),
+				Scopes:     []Action{ActionGet},
+			},
+			action:  ActionGet,
+			isValid: false,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			err := test.token.Validate(test.action, test.hash, test.hostname)
+
+			if !test.isValid {
+				if err == nil {
+					t.Fatalf("expected error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip46"
+	"github.com/pippellia-btc/blossom"
+)
+
+// Signer signs nostr events on behalf of a pubkey. It matches [nostr.Signer],
+// so any of its implementations can be used, in addition to [KeySigner] and [RemoteSigner].
+type Signer interface {
+	// GetPublicKey returns the hex encoded public key of the signer.
+	GetPublicKey(ctx context.Context) (string, error)
+
+	// SignEvent signs the event, setting its ID, PubKey and Sig fields.
+	SignEvent(ctx context.Context, e *nostr.Event) error
+}
+
+// KeySigner is a [Signer] holding the private key in memory. Create one with [NewKeySigner].
+type KeySigner struct {
+	sk string
+	pk string
+}
+
+// NewKeySigner returns a [KeySigner] for the hex encoded private key.
+func NewKeySigner(sk string) (*KeySigner, error) {
+	if !nostr.IsValid32ByteHex(sk) {
+		return nil, errors.New("private key must be 64 hex characters")
+	}
+
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	return &KeySigner{sk: sk, pk: pk}, nil
+}
+
+func (s *KeySigner) GetPublicKey(ctx context.Context) (string, error) {
+	return s.pk, nil
+}
+
+func (s *KeySigner) SignEvent(ctx context.Context, e *nostr.Event) error {
+	return e.Sign(s.sk)
+}
+
+// RemoteSigner is a [Signer] that asks a NIP-46 remote signer (bunker) to sign the events.
+// Create one with [NewRemoteSigner].
+// Learn more here: https://github.com/nostr-protocol/nips/blob/master/46.md
+type RemoteSigner struct {
+	bunker *nip46.BunkerClient
+}
+
+// NewRemoteSigner connects to the remote signer of the bunker URL (bunker://<pubkey>?relay=...&secret=...),
+// or of the NIP-05 identifier, using the client private key to encrypt the communication.
+// If the client key is empty, a new one is generated.
+// The connection to the relays lasts until the context is done.
+func NewRemoteSigner(ctx context.Context, bunkerURL, clientKey string) (*RemoteSigner, error) {
+	if clientKey == "" {
+		clientKey = nostr.GeneratePrivateKey()
+	}
+
+	bunker, err := nip46.ConnectBunker(ctx, clientKey, bunkerURL, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the remote signer: %w", err)
+	}
+	return &RemoteSigner{bunker: bunker}, nil
+}
+
+func (s *RemoteSigner) GetPublicKey(ctx context.Context) (string, error) {
+	return s.bunker.GetPublicKey(ctx)
+}
+
+func (s *RemoteSigner) SignEvent(ctx context.Context, e *nostr.Event) error {
+	return s.bunker.SignEvent(ctx, e)
+}
+
+// BuildBlossomAuth returns an unsigned Blossom authorization event (kind 24242) for the action,
+// valid until the expiration. The event is bound to the hashes and to the server hostnames, if any:
+// without hashes it's valid for all blobs, and without servers it's valid for all servers.
+// Delete events should always be bound to the hashes, as servers reject them otherwise.
+//
+// Sign the event with a [Signer], and send it with [Header].
+func BuildBlossomAuth(action Action, hashes []blossom.Hash, servers []string, expiration time.Time) *nostr.Event {
+	tags := make(nostr.Tags, 0, 2+len(hashes)+len(servers))
+	tags = append(tags,
+		nostr.Tag{"t", string(action)},
+		nostr.Tag{"expiration", strconv.FormatInt(expiration.Unix(), 10)},
+	)
+	for _, hash := range hashes {
+		tags = append(tags, nostr.Tag{"x", hash.Hex()})
+	}
+	for _, server := range servers {
+		tags = append(tags, nostr.Tag{"server", server})
+	}
+
+	return &nostr.Event{
+		Kind:      KindBlossomAuth,
+		CreatedAt: nostr.Now(),
+		Tags:      tags,
+		Content:   authContent(action),
+	}
+}
+
+// authContent returns the human readable description of the action, shown by signers to the user.
+func authContent(action Action) string {
+	switch action {
+	case ActionGet:
+		return "Get blobs"
+	case ActionUpload:
+		return "Upload blobs"
+	case ActionList:
+		return "List blobs"
+	case ActionDelete:
+		return "Delete blobs"
+	default:
+		return "Authorize " + string(action)
+	}
+}
+
+// SignBlossomAuth builds the Blossom authorization event with [BuildBlossomAuth], signs it with the signer,
+// and returns the value of the 'Authorization' header that carries it.
+func SignBlossomAuth(ctx context.Context, signer Signer, action Action, hashes []blossom.Hash, servers []string, expiration time.Time) (string, error) {
+	event := BuildBlossomAuth(action, hashes, servers, expiration)
+	if err := signer.SignEvent(ctx, event); err != nil {
+		return "", fmt.Errorf("failed to sign the authorization event: %w", err)
+	}
+	return Header(event)
+}
+
+// Header returns the value of the 'Authorization' header that carries the signed event,
+// in the form 'Nostr <base64_event>'. It's the inverse of [ExtractEvent].
+func Header(e *nostr.Event) (string, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode the event: %w", err)
+	}
+	return "Nostr " + base64.StdEncoding.EncodeToString(data), nil
+}
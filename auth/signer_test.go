@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+)
+
+func TestNewKeySigner(t *testing.T) {
+	if _, err := NewKeySigner("not a key"); err == nil {
+		t.Error("expected error for an invalid key, got nil")
+	}
+
+	sk := nostr.GeneratePrivateKey()
+	signer, err := NewKeySigner(sk)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected, _ := nostr.GetPublicKey(sk)
+	if pk, _ := signer.GetPublicKey(context.Background()); pk != expected {
+		t.Errorf("expected pubkey %s, got %s", expected, pk)
+	}
+}
+
+func TestSignBlossomAuth(t *testing.T) {
+	signer, _ := NewKeySigner(nostr.GeneratePrivateKey())
+	pubkey, _ := signer.GetPublicKey(context.Background())
+
+	header, err := SignBlossomAuth(context.Background(), signer, ActionUpload, []blossom.Hash{testHash}, []string{"cdn.example.com"}, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r := httptest.NewRequest("PUT", "/upload", nil)
+	r.Header.Set("Authorization", header)
+
+	got, err := Authenticate(r, "cdn.example.com", &testHash)
+	if err != nil {
+		t.Fatalf("expected the event to be valid, got %v", err)
+	}
+	if got != pubkey {
+		t.Errorf("expected pubkey %s, got %s", pubkey, got)
+	}
+
+	if _, err := Authenticate(r, "other.example.com", &testHash); err == nil {
+		t.Error("expected error for another server, got nil")
+	}
+}
+
+func TestBuildBlossomAuth(t *testing.T) {
+	expiration := time.Now().Add(time.Hour).Truncate(time.Second)
+	event := BuildBlossomAuth(ActionDelete, []blossom.Hash{testHash}, nil, expiration)
+
+	auth, err := ParseBlossomAuth(event)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if auth.Action != ActionDelete || !auth.Expiration.Equal(expiration) {
+		t.Errorf("unexpected auth: %+v", auth)
+	}
+	if len(auth.Hashes) != 1 || auth.Hashes[0] != testHash || len(auth.Hostnames) != 0 {
+		t.Errorf("expected the hash and no hostnames, got %v and %v", auth.Hashes, auth.Hostnames)
+	}
+	if event.Content == "" {
+		t.Error("expected a human readable content")
+	}
+}
@@ -0,0 +1,299 @@
+// Package blobsync synchronizes the blobs of a set of pubkeys between a remote blossom server and a local [blossy.Store],
+// for example to keep a backup server up to date, or to migrate blobs from another server.
+//
+// A [Syncer] lists the blobs of every pubkey on both sides, downloads the ones missing locally, verifying their hash,
+// and optionally uploads the ones missing remotely. Transfers run concurrently, up to a configurable limit.
+//
+// Example:
+//
+//	remote, err := client.New("https://cdn.example.com", client.WithSigner(signer))
+//	if err != nil {
+//	    panic(err)
+//	}
+//
+//	syncer := blobsync.New(remote, store, pubkeys,
+//	    blobsync.WithConcurrency(4),
+//	    blobsync.WithPush(),
+//	)
+//	syncer.Bind(server) // runs every hour while the server is serving
+package blobsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/client"
+)
+
+// Report summarizes a run of a [Syncer].
+type Report struct {
+	Pulled int   // number of blobs downloaded from the remote server
+	Pushed int   // number of blobs uploaded to the remote server
+	Bytes  int64 // total size of the transferred blobs
+	Failed int   // number of blobs whose transfer failed
+}
+
+// Syncer synchronizes the blobs of the pubkeys between the remote server and the local store. Create one with [New].
+type Syncer struct {
+	remote  *client.Client
+	local   blossy.Store
+	pubkeys []string
+
+	push        bool
+	concurrency int
+	interval    time.Duration
+	log         *slog.Logger
+}
+
+type Option func(*Syncer)
+
+// WithPush makes the sync bidirectional: the local blobs missing on the remote server are uploaded to it.
+// The uploaded blobs are owned by the signer of the client on the remote server.
+func WithPush() Option {
+	return func(s *Syncer) {
+		s.push = true
+	}
+}
+
+// WithConcurrency sets how many blobs are transferred at the same time. By default, it's 4.
+func WithConcurrency(n int) Option {
+	return func(s *Syncer) {
+		s.concurrency = n
+	}
+}
+
+// WithInterval sets how often the sync runs when started with [Syncer.Run] or [Syncer.Bind]. By default, it's one hour.
+func WithInterval(d time.Duration) Option {
+	return func(s *Syncer) {
+		s.interval = d
+	}
+}
+
+// WithLogger sets the logger of the syncer. By default, it's [slog.Default].
+func WithLogger(l *slog.Logger) Option {
+	return func(s *Syncer) {
+		s.log = l
+	}
+}
+
+// New returns a [Syncer] that downloads the blobs of the pubkeys from the remote server to the local store.
+// It panics if the remote client or the local store is nil, or the options are invalid.
+func New(remote *client.Client, local blossy.Store, pubkeys []string, opts ...Option) *Syncer {
+	if remote == nil {
+		panic("blobsync.New: remote client must not be nil")
+	}
+	if local == nil {
+		panic("blobsync.New: local store must not be nil")
+	}
+
+	s := &Syncer{
+		remote:      remote,
+		local:       local,
+		pubkeys:     pubkeys,
+		concurrency: 4,
+		interval:    time.Hour,
+		log:         slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.concurrency <= 0 {
+		panic("blobsync.New: concurrency must be positive")
+	}
+	if s.interval <= 0 {
+		panic("blobsync.New: interval must be positive")
+	}
+	if s.log == nil {
+		panic("blobsync.New: logger must not be nil")
+	}
+	return s
+}
+
+// Bind runs the sync in the background of the server while it's serving (see [blossy.Server.Background]).
+func (s *Syncer) Bind(server *blossy.Server) {
+	server.Background(s.Run)
+}
+
+// Run synchronizes the blobs every interval, until the context is cancelled.
+// The first run happens immediately, so that a new backup server catches up right away.
+func (s *Syncer) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := s.RunOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			s.log.Error("blobsync: run failed", "error", err)
+		}
+		if report.Pulled > 0 || report.Pushed > 0 || report.Failed > 0 {
+			s.log.Info("blobsync: run completed", "pulled", report.Pulled, "pushed", report.Pushed,
+				"bytes", report.Bytes, "failed", report.Failed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// transfer is a blob to copy from one side to the other.
+type transfer struct {
+	pubkey string
+	desc   blossom.BlobDescriptor
+	push   bool
+}
+
+// RunOnce synchronizes the blobs once, returning what it transferred.
+// Errors of the listings and of the transfers don't stop the run, and are returned joined.
+func (s *Syncer) RunOnce(ctx context.Context) (Report, error) {
+	var transfers []transfer
+	var errs []error
+
+	for _, pubkey := range s.pubkeys {
+		missing, err := s.diff(ctx, pubkey)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		transfers = append(transfers, missing...)
+	}
+
+	var report Report
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, s.concurrency)
+
+	for _, t := range transfers {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return report, errors.Join(append(errs, ctx.Err())...)
+		case slots <- struct{}{}:
+		}
+
+		wg.Go(func() {
+			defer func() { <-slots }()
+
+			var err error
+			if t.push {
+				err = s.pushBlob(ctx, t)
+			} else {
+				err = s.pullBlob(ctx, t)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				report.Failed++
+				errs = append(errs, fmt.Errorf("blobsync: blob %s: %w", t.desc.Hash.Hex(), err))
+			case t.push:
+				report.Pushed++
+				report.Bytes += t.desc.Size
+			default:
+				report.Pulled++
+				report.Bytes += t.desc.Size
+			}
+		})
+	}
+
+	wg.Wait()
+	return report, errors.Join(errs...)
+}
+
+// diff returns the blobs of the pubkey that are missing locally and, if pushing, the ones missing remotely.
+func (s *Syncer) diff(ctx context.Context, pubkey string) ([]transfer, error) {
+	remote, err := s.remote.List(ctx, pubkey, blossy.ListQuery{})
+	if err != nil {
+		return nil, fmt.Errorf("blobsync: failed to list the remote blobs of %s: %w", pubkey, err)
+	}
+
+	local, err := s.local.List(ctx, pubkey, blossy.ListQuery{})
+	if err != nil {
+		return nil, fmt.Errorf("blobsync: failed to list the local blobs of %s: %w", pubkey, err)
+	}
+
+	remoteSet := make(map[blossom.Hash]bool, len(remote))
+	for _, desc := range remote {
+		remoteSet[desc.Hash] = true
+	}
+	localSet := make(map[blossom.Hash]bool, len(local))
+	for _, desc := range local {
+		localSet[desc.Hash] = true
+	}
+
+	var missing []transfer
+	for _, desc := range remote {
+		if !localSet[desc.Hash] {
+			missing = append(missing, transfer{pubkey: pubkey, desc: desc})
+		}
+	}
+
+	if s.push {
+		for _, desc := range local {
+			if !remoteSet[desc.Hash] {
+				missing = append(missing, transfer{pubkey: pubkey, desc: desc, push: true})
+			}
+		}
+	}
+	return missing, nil
+}
+
+// pullBlob downloads the blob from the remote server and saves it in the local store on behalf of the pubkey.
+// The hash of the blob is verified while it's read, so a corrupted blob fails to be saved.
+func (s *Syncer) pullBlob(ctx context.Context, t transfer) error {
+	blob, err := s.remote.Get(ctx, t.desc.Hash)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	hints := blossy.UploadHints{Hash: &t.desc.Hash, Type: blob.Type(), Size: blob.Size()}
+	_, err = s.local.Save(ctx, t.pubkey, hints, blob)
+	return err
+}
+
+// pushBlob uploads the blob from the local store to the remote server.
+// Since uploads read the blob twice, blobs that are not seekable are first copied to a temporary file.
+func (s *Syncer) pushBlob(ctx context.Context, t transfer) error {
+	blob, err := s.local.Get(ctx, t.desc.Hash)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	body, ok := blob.(io.ReadSeeker)
+	if !ok {
+		tmp, err := os.CreateTemp("", "blobsync-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		if _, err := io.Copy(tmp, blob); err != nil {
+			return err
+		}
+		body = tmp
+	}
+
+	desc, err := s.remote.Upload(ctx, body, blob.Type())
+	if err != nil {
+		return err
+	}
+	if desc.Hash != t.desc.Hash {
+		return fmt.Errorf("the remote server stored the blob with hash %s", desc.Hash.Hex())
+	}
+	return nil
+}
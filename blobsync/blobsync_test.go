@@ -0,0 +1,53 @@
+package blobsync
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/blossytest"
+	"github.com/pippellia-btc/blossy/stores/memory"
+)
+
+var ctx = context.Background()
+
+func TestRunOnce(t *testing.T) {
+	remote := blossytest.NewTestServer(t)
+	signer := blossytest.NewSigner(t)
+	pubkey := blossytest.Pubkey(t, signer)
+	client := remote.Client(t, signer)
+
+	for _, data := range []string{"first blob", "second blob"} {
+		if _, err := client.Upload(ctx, strings.NewReader(data), "text/plain"); err != nil {
+			t.Fatalf("failed to upload: %v", err)
+		}
+	}
+
+	local := memory.New()
+	if _, err := local.Save(ctx, pubkey, blossy.UploadHints{Size: -1}, strings.NewReader("local blob")); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	syncer := New(client, local, []string{pubkey}, WithPush(), WithConcurrency(2))
+	report, err := syncer.RunOnce(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := Report{Pulled: 2, Pushed: 1, Bytes: int64(len("first blob") + len("second blob") + len("local blob"))}
+	if report != expected {
+		t.Errorf("expected report %+v, got %+v", expected, report)
+	}
+	if local.Len() != 3 || remote.Store.Len() != 3 {
+		t.Errorf("expected 3 blobs on both sides, got %d locally and %d remotely", local.Len(), remote.Store.Len())
+	}
+
+	report, err = syncer.RunOnce(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report != (Report{}) {
+		t.Errorf("expected nothing to transfer once in sync, got %+v", report)
+	}
+}
@@ -0,0 +1,80 @@
+package blossy
+
+import (
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+// Blocklist reports whether blobs are blocked, so that they never transit the server. See [WithBlocklist].
+// The blossy/blocklist package provides an implementation loaded from files or URLs, reloaded periodically.
+//
+// Implementations must be safe for concurrent use, and fast, as they are consulted on every request.
+type Blocklist interface {
+	Contains(hash blossom.Hash) bool
+}
+
+// errBlobBlocked is returned by [blockReader] instead of [io.EOF] when the blob is blocked.
+var errBlobBlocked = errors.New("blob is blocked")
+
+func errBlocked() *blossom.Error {
+	return ErrBlocked("the blob is blocked")
+}
+
+// checkBlocklist rejects the blob if it's in the blocklist configured with [WithBlocklist].
+func (s *Server) checkBlocklist(e Endpoint, hash blossom.Hash) *blossom.Error {
+	list := s.live.Load().blocklist
+	if list == nil || !list.Contains(hash) {
+		return nil
+	}
+
+	err := errBlocked()
+	s.observeRejection(e, err)
+	return err
+}
+
+// blockReader computes the sha256 of an upload body while it's read, and returns [errBlobBlocked]
+// instead of [io.EOF] if the blob is in the blocklist, so that the Upload and Media hooks fail to store it.
+type blockReader struct {
+	r    io.Reader
+	h    hash.Hash
+	list Blocklist
+
+	blocked bool
+}
+
+func newBlockReader(r io.Reader, list Blocklist) *blockReader {
+	return &blockReader{r: r, h: sha256.New(), list: list}
+}
+
+func (b *blockReader) Read(p []byte) (int, error) {
+	if b.blocked {
+		return 0, errBlobBlocked
+	}
+
+	n, err := b.r.Read(p)
+	b.h.Write(p[:n])
+
+	if errors.Is(err, io.EOF) {
+		var sum blossom.Hash
+		copy(sum[:], b.h.Sum(nil))
+		if b.list.Contains(sum) {
+			b.blocked = true
+			return n, errBlobBlocked
+		}
+	}
+	return n, err
+}
+
+// wrapUpload wraps the body of an upload with a [blockReader], if a blocklist is configured.
+func (s *Server) wrapUpload(data io.Reader) (io.Reader, *blockReader) {
+	list := s.live.Load().blocklist
+	if list == nil {
+		return data, nil
+	}
+	reader := newBlockReader(data, list)
+	return reader, reader
+}
@@ -0,0 +1,245 @@
+// Package blocklist provides a set of blocked blob hashes for [blossy.WithBlocklist],
+// loaded from files or URLs and reloaded periodically, so that lists of known-bad content can be updated
+// without restarting the server.
+//
+// Lists contain one hex encoded sha256 per line. Empty lines and lines starting with '#' are ignored,
+// as well as anything after the hash on the same line, which can be used for comments.
+//
+// The set is exact: a bloom filter would be smaller, but its false positives would block legitimate blobs.
+//
+// Example:
+//
+//	list, err := blocklist.New(ctx, []blocklist.Source{
+//	    blocklist.File("blocked.txt"),
+//	    blocklist.URL("https://example.com/known-bad.txt"),
+//	})
+//	if err != nil {
+//	    panic(err)
+//	}
+//
+//	server, err := blossy.NewServer(blossy.WithBlocklist(list))
+//	...
+//	server.Background(list.Run) // reloads the sources every 10 minutes while serving
+package blocklist
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+// Source loads a list of blocked hashes.
+type Source func(ctx context.Context) ([]blossom.Hash, error)
+
+// File returns a [Source] that reads the list from the file at the path.
+func File(path string) Source {
+	return func(ctx context.Context) ([]blossom.Hash, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("blocklist: %w", err)
+		}
+		defer f.Close()
+
+		hashes, err := Parse(f)
+		if err != nil {
+			return nil, fmt.Errorf("blocklist: file %s: %w", path, err)
+		}
+		return hashes, nil
+	}
+}
+
+// client is the http client used by [URL].
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// URL returns a [Source] that downloads the list from the URL.
+func URL(url string) Source {
+	return func(ctx context.Context) ([]blossom.Hash, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("blocklist: %w", err)
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("blocklist: failed to fetch %s: %w", url, err)
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("blocklist: failed to fetch %s: status %d", url, res.StatusCode)
+		}
+
+		hashes, err := Parse(res.Body)
+		if err != nil {
+			return nil, fmt.Errorf("blocklist: url %s: %w", url, err)
+		}
+		return hashes, nil
+	}
+}
+
+// Parse reads a list of hashes, one per line. It fails on the first invalid line.
+func Parse(r io.Reader) ([]blossom.Hash, error) {
+	var hashes []blossom.Hash
+	scanner := bufio.NewScanner(r)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		field, _, _ := strings.Cut(line, " ")
+		hash, err := blossom.ParseHash(strings.ToLower(field))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+type set = map[blossom.Hash]struct{}
+
+// Blocklist is a set of blocked hashes, loaded from sources. Create one with [New].
+// It implements [blossy.Blocklist], and it's safe for concurrent use.
+type Blocklist struct {
+	sources []Source
+	loaded  atomic.Pointer[set]
+
+	mu    sync.RWMutex
+	added set
+
+	interval time.Duration
+	log      *slog.Logger
+}
+
+type Option func(*Blocklist)
+
+// WithReloadInterval sets how often [Blocklist.Run] reloads the sources. By default, it's 10 minutes.
+func WithReloadInterval(d time.Duration) Option {
+	return func(b *Blocklist) {
+		b.interval = d
+	}
+}
+
+// WithLogger sets the logger of the blocklist. By default, it's [slog.Default].
+func WithLogger(l *slog.Logger) Option {
+	return func(b *Blocklist) {
+		b.log = l
+	}
+}
+
+// New returns a [Blocklist] with the hashes of the sources, which are loaded before returning.
+// It returns an error if any of the sources fails to load, or the options are invalid.
+func New(ctx context.Context, sources []Source, opts ...Option) (*Blocklist, error) {
+	b := &Blocklist{
+		sources:  sources,
+		added:    make(set),
+		interval: 10 * time.Minute,
+		log:      slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	if b.interval <= 0 {
+		return nil, errors.New("blocklist: reload interval must be positive")
+	}
+	if b.log == nil {
+		return nil, errors.New("blocklist: logger must not be nil")
+	}
+
+	if err := b.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Contains reports whether the hash is blocked.
+func (b *Blocklist) Contains(hash blossom.Hash) bool {
+	if _, ok := (*b.loaded.Load())[hash]; ok {
+		return true
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	_, ok := b.added[hash]
+	return ok
+}
+
+// Len returns the number of blocked hashes.
+func (b *Blocklist) Len() int {
+	loaded := *b.loaded.Load()
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	n := len(loaded)
+	for hash := range b.added {
+		if _, ok := loaded[hash]; !ok {
+			n++
+		}
+	}
+	return n
+}
+
+// Add blocks the hashes, in addition to the ones of the sources. They are kept across reloads.
+func (b *Blocklist) Add(hashes ...blossom.Hash) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, hash := range hashes {
+		b.added[hash] = struct{}{}
+	}
+}
+
+// Reload loads the sources again, replacing their hashes at once.
+// If any of the sources fails, the previous hashes are kept and the error is returned.
+func (b *Blocklist) Reload(ctx context.Context) error {
+	loaded := make(set)
+	for _, source := range b.sources {
+		hashes, err := source(ctx)
+		if err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			loaded[hash] = struct{}{}
+		}
+	}
+
+	b.loaded.Store(&loaded)
+	return nil
+}
+
+// Run reloads the sources every interval, until the context is cancelled.
+// Failed reloads are logged, and the previous hashes are kept.
+func (b *Blocklist) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			if err := b.Reload(ctx); err != nil {
+				b.log.Error("blocklist: failed to reload", "error", err)
+			}
+		}
+	}
+}
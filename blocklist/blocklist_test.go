@@ -0,0 +1,98 @@
+package blocklist
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+var (
+	hash1 = blossom.ComputeHash([]byte("one"))
+	hash2 = blossom.ComputeHash([]byte("two"))
+	hash3 = blossom.ComputeHash([]byte("three"))
+)
+
+func TestParse(t *testing.T) {
+	list := "# known bad\n\n" + hash1.Hex() + "\n  " + strings.ToUpper(hash2.Hex()) + " spam campaign\n"
+	hashes, err := Parse(strings.NewReader(list))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(hashes) != 2 || hashes[0] != hash1 || hashes[1] != hash2 {
+		t.Errorf("expected hash1 and hash2, got %v", hashes)
+	}
+
+	if _, err := Parse(strings.NewReader(hash1.Hex() + "\nnot a hash\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error on line 2, got %v", err)
+	}
+}
+
+func TestSources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocked.txt")
+	if err := os.WriteFile(path, []byte(hash1.Hex()+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(hash2.Hex() + "\n"))
+	}))
+	defer server.Close()
+
+	list, err := New(t.Context(), []Source{File(path), URL(server.URL)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !list.Contains(hash1) || !list.Contains(hash2) || list.Contains(hash3) {
+		t.Error("expected hash1 and hash2 to be blocked, and hash3 not")
+	}
+
+	list.Add(hash3, hash1)
+	if !list.Contains(hash3) || list.Len() != 3 {
+		t.Errorf("expected 3 blocked hashes after adding hash3, got %d", list.Len())
+	}
+
+	if err := os.WriteFile(path, []byte(hash2.Hex()+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := list.Reload(t.Context()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !list.Contains(hash1) || !list.Contains(hash3) {
+		t.Error("expected the added hashes to be kept across reloads")
+	}
+}
+
+func TestReloadFailure(t *testing.T) {
+	fail := false
+	source := func(ctx context.Context) ([]blossom.Hash, error) {
+		if fail {
+			return nil, errors.New("unreachable")
+		}
+		return []blossom.Hash{hash1}, nil
+	}
+
+	list, err := New(t.Context(), []Source{source})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fail = true
+	if err := list.Reload(t.Context()); err == nil {
+		t.Fatal("expected the error of the source")
+	}
+	if !list.Contains(hash1) {
+		t.Error("expected the previous hashes to be kept after a failed reload")
+	}
+
+	if _, err := New(t.Context(), []Source{File(filepath.Join(t.TempDir(), "missing.txt"))}); err == nil {
+		t.Error("expected New to fail when a source fails")
+	}
+}
@@ -0,0 +1,156 @@
+// Package blossytest provides utilities for testing blossy servers and their hooks:
+// a test server backed by an in-memory store, helpers for making signed requests,
+// and a conformance suite that checks a server against the Blossom specification (see [RunConformance]).
+//
+// Example:
+//
+//	func TestUpload(t *testing.T) {
+//	    server := blossytest.NewTestServer(t, blossy.WithMaxUploadSize(1<<20))
+//	    server.Blossy.Reject.Upload.Append(myPolicy)
+//
+//	    signer := blossytest.NewSigner(t)
+//	    desc, err := server.Client(t, signer).Upload(ctx, strings.NewReader("hello"), "text/plain")
+//	    ...
+//	}
+package blossytest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/auth"
+	"github.com/pippellia-btc/blossy/client"
+	"github.com/pippellia-btc/blossy/stores/memory"
+)
+
+// Hostname is the hostname of the servers started by [NewTestServer] and [Serve].
+const Hostname = "localhost"
+
+// Server is a blossy server listening on a local address, started by [NewTestServer] or [Serve].
+type Server struct {
+	*httptest.Server
+
+	// Blossy is the server under test. Its hooks can be modified during the test.
+	Blossy *blossy.Server
+
+	// Store is the in-memory store bound to the server by [NewTestServer], or nil if started by [Serve].
+	Store *memory.Store
+}
+
+// NewTestServer starts a blossy server bound to an in-memory store (see [blossy.BindStore]),
+// with the hostname set to [Hostname]. The options are applied after the hostname, so they can override it.
+// The server is closed when the test ends.
+func NewTestServer(t testing.TB, opts ...blossy.Option) *Server {
+	t.Helper()
+
+	opts = append([]blossy.Option{blossy.WithHostname(Hostname)}, opts...)
+	b, err := blossy.NewServer(opts...)
+	if err != nil {
+		t.Fatalf("blossytest: failed to create the server: %v", err)
+	}
+
+	store := memory.New()
+	blossy.BindStore(b, store)
+
+	s := Serve(t, b)
+	s.Store = store
+	return s
+}
+
+// Serve starts listening with the provided blossy server on a local address, reachable at [Hostname].
+// The server is closed when the test ends.
+func Serve(t testing.TB, b *blossy.Server) *Server {
+	t.Helper()
+
+	ts := httptest.NewServer(b)
+	t.Cleanup(ts.Close)
+
+	// the authorization events are bound to the hostname of the server
+	ts.URL = strings.Replace(ts.URL, "127.0.0.1", Hostname, 1)
+	return &Server{Server: ts, Blossy: b}
+}
+
+// NewSigner returns a signer with a random private key.
+func NewSigner(t testing.TB) *auth.KeySigner {
+	t.Helper()
+
+	signer, err := auth.NewKeySigner(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatalf("blossytest: failed to create the signer: %v", err)
+	}
+	return signer
+}
+
+// Pubkey returns the pubkey of the signer.
+func Pubkey(t testing.TB, signer auth.Signer) string {
+	t.Helper()
+
+	pubkey, err := signer.GetPublicKey(context.Background())
+	if err != nil {
+		t.Fatalf("blossytest: failed to get the pubkey: %v", err)
+	}
+	return pubkey
+}
+
+// Client returns a [client.Client] for the server that signs with the signer, without retries.
+// If the signer is nil, the client is anonymous.
+func (s *Server) Client(t testing.TB, signer auth.Signer) *client.Client {
+	t.Helper()
+
+	opts := []client.Option{client.WithRetries(0, 0)}
+	if signer != nil {
+		opts = append(opts, client.WithSigner(signer))
+	}
+
+	c, err := client.New(s.URL, opts...)
+	if err != nil {
+		t.Fatalf("blossytest: failed to create the client: %v", err)
+	}
+	return c
+}
+
+// NewRequest returns a request to the path of the server.
+func (s *Server) NewRequest(t testing.TB, method, path string, body io.Reader) *http.Request {
+	t.Helper()
+
+	r, err := http.NewRequest(method, s.URL+path, body)
+	if err != nil {
+		t.Fatalf("blossytest: failed to create the request: %v", err)
+	}
+	return r
+}
+
+// Do sends the request, failing the test if the server can't be reached.
+// The body of the response is closed when the test ends.
+func (s *Server) Do(t testing.TB, r *http.Request) *http.Response {
+	t.Helper()
+
+	res, err := s.Server.Client().Do(r)
+	if err != nil {
+		t.Fatalf("blossytest: request failed: %v", err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+// Authorize sets the 'Authorization' header of the request to a Blossom authorization event (kind 24242)
+// for the action, signed by the signer and bound to the hashes and to the hostname of the request URL.
+// The event expires after one minute.
+func Authorize(t testing.TB, r *http.Request, signer auth.Signer, action auth.Action, hashes ...blossom.Hash) {
+	t.Helper()
+
+	expiration := time.Now().Add(time.Minute)
+	header, err := auth.SignBlossomAuth(r.Context(), signer, action, hashes, []string{r.URL.Hostname()}, expiration)
+	if err != nil {
+		t.Fatalf("blossytest: failed to authorize the request: %v", err)
+	}
+	r.Header.Set("Authorization", header)
+}
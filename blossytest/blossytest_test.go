@@ -0,0 +1,2340 @@
+package blossytest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/auth"
+	"github.com/pippellia-btc/blossy/blocklist"
+	"github.com/pippellia-btc/blossy/cache"
+	"github.com/pippellia-btc/blossy/client"
+	"github.com/pippellia-btc/blossy/metrics"
+	"github.com/pippellia-btc/blossy/sessions"
+	"github.com/pippellia-btc/blossy/stores/memory"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestConformance(t *testing.T) {
+	RunConformance(t, func(t *testing.T) *blossy.Server {
+		server, err := blossy.NewServer(blossy.WithHostname(Hostname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		store := memory.New()
+		blossy.BindStore(server, store)
+
+		server.On.Media = func(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+			desc, err := store.Save(r.Context(), r.Pubkey(), hints, data)
+			if err != nil {
+				return blossom.BlobDescriptor{}, blossom.ErrInternal(err.Error())
+			}
+			return desc, nil
+		}
+		server.On.Mirror = func(r blossy.Request, url *url.URL) (blossom.BlobDescriptor, *blossom.Error) {
+			return blossom.BlobDescriptor{}, blossom.ErrUnavailable("mirroring is disabled in tests")
+		}
+
+		server.On.Report = func(r blossy.Request, report blossy.Report) *blossom.Error { return nil }
+		server.On.NIP94 = func(r blossy.Request, desc blossom.BlobDescriptor) *blossy.FileMetadata {
+			return &blossy.FileMetadata{Alt: "conformance"}
+		}
+		return server
+	})
+}
+
+func TestNewTestServer(t *testing.T) {
+	server := NewTestServer(t)
+	signer := NewSigner(t)
+
+	desc, err := server.Client(t, signer).Upload(t.Context(), strings.NewReader("hello"), "text/plain")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if server.Store.Len() != 1 {
+		t.Errorf("expected the blob in the store, got %d blobs", server.Store.Len())
+	}
+
+	r := server.NewRequest(t, http.MethodDelete, "/"+desc.Hash.Hex(), nil)
+	res := server.Do(t, r)
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 without authorization, got %d", res.StatusCode)
+	}
+
+	r = server.NewRequest(t, http.MethodDelete, "/"+desc.Hash.Hex(), nil)
+	Authorize(t, r, signer, auth.ActionDelete, desc.Hash)
+	res = server.Do(t, r)
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("expected 204 with authorization, got %d (%s)", res.StatusCode, res.Header.Get("X-Reason"))
+	}
+}
+
+func TestBlocked(t *testing.T) {
+	m := metrics.New()
+	server := NewTestServer(t, blossy.WithMetrics(m))
+	server.Blossy.On.Download = func(r blossy.Request, hash blossom.Hash, ext string) (blossy.BlobDelivery, *blossom.Error) {
+		return blossy.Blocked("Taken down by court order"), nil
+	}
+
+	hash := blossom.ComputeHash([]byte("hello"))
+	res := server.Do(t, server.NewRequest(t, http.MethodGet, "/"+hash.Hex(), nil))
+	if res.StatusCode != http.StatusUnavailableForLegalReasons {
+		t.Fatalf("expected 451, got %d", res.StatusCode)
+	}
+	if reason := res.Header.Get("X-Reason"); reason != "Taken down by court order" {
+		t.Errorf("expected the reason in X-Reason, got %q", reason)
+	}
+
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `blossy_blocked_total{endpoint="download"} 1`) {
+		t.Errorf("expected the blocked request in the metrics, got\n%s", b.String())
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	data := []byte("blocked content")
+	hash := blossom.ComputeHash(data)
+
+	list, err := blocklist.New(t.Context(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list.Add(hash)
+
+	server := NewTestServer(t, blossy.WithBlocklist(list))
+	signer := NewSigner(t)
+
+	upload := func(declare bool) *http.Response {
+		r := server.NewRequest(t, http.MethodPut, "/upload", bytes.NewReader(data))
+		if declare {
+			r.Header.Set("Content-Digest", hash.Hex())
+			Authorize(t, r, signer, auth.ActionUpload, hash)
+		} else {
+			Authorize(t, r, signer, auth.ActionUpload)
+		}
+		return server.Do(t, r)
+	}
+
+	if res := upload(true); res.StatusCode != http.StatusUnavailableForLegalReasons {
+		t.Errorf("expected 451 for an upload declaring a blocked hash, got %d", res.StatusCode)
+	}
+	if res := upload(false); res.StatusCode != http.StatusUnavailableForLegalReasons {
+		t.Errorf("expected 451 for an upload of a blocked blob, got %d (%s)", res.StatusCode, res.Header.Get("X-Reason"))
+	}
+	if server.Store.Len() != 0 {
+		t.Errorf("expected the blocked blob not to be stored, got %d blobs", server.Store.Len())
+	}
+
+	res := server.Do(t, server.NewRequest(t, http.MethodGet, "/"+hash.Hex(), nil))
+	if res.StatusCode != http.StatusUnavailableForLegalReasons {
+		t.Errorf("expected 451 for the download of a blocked blob, got %d", res.StatusCode)
+	}
+
+	server.Blossy.On.Mirror = func(r blossy.Request, url *url.URL) (blossom.BlobDescriptor, *blossom.Error) {
+		t.Error("the Mirror hook must not be called for a blocked blob")
+		return blossom.BlobDescriptor{}, blossom.ErrInternal("unreachable")
+	}
+
+	body := strings.NewReader(`{"url": "https://example.com/` + hash.Hex() + `"}`)
+	r := server.NewRequest(t, http.MethodPut, "/mirror", body)
+	Authorize(t, r, signer, auth.ActionUpload, hash)
+	if res := server.Do(t, r); res.StatusCode != http.StatusUnavailableForLegalReasons {
+		t.Errorf("expected 451 for the mirror of a blocked blob, got %d", res.StatusCode)
+	}
+}
+
+type fakeResolver map[string]blossy.GeoInfo
+
+func (f fakeResolver) Resolve(ip blossy.IP) (blossy.GeoInfo, error) {
+	return f[ip.String()], nil
+}
+
+func TestIPPolicy(t *testing.T) {
+	server := NewTestServer(t, blossy.WithIPPolicy(blossy.IPPolicy{
+		Deny:     []string{"203.0.113.0/24"},
+		Resolver: fakeResolver{"198.51.100.9": {Country: "xx"}},
+		Geo:      blossy.DenyCountries("XX"),
+		Ban:      &blossy.BanPolicy{Threshold: 2, Window: time.Minute, Duration: time.Hour},
+	}))
+	hash := blossom.ComputeHash([]byte("hello"))
+
+	request := func(ip, method string) *http.Response {
+		r := server.NewRequest(t, method, "/"+hash.Hex(), nil)
+		r.Header.Set("X-Real-IP", ip)
+		return server.Do(t, r)
+	}
+
+	if res := request("203.0.113.7", http.MethodGet); res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 for a denied network, got %d", res.StatusCode)
+	}
+	if res := request("198.51.100.9", http.MethodGet); res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 for a denied country, got %d", res.StatusCode)
+	}
+	if res := request("192.0.2.1", http.MethodGet); res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for an allowed address, got %d", res.StatusCode)
+	}
+
+	for range 2 {
+		if res := request("192.0.2.1", http.MethodDelete); res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected 401 without authorization, got %d", res.StatusCode)
+		}
+	}
+
+	res := request("192.0.2.1", http.MethodGet)
+	if res.StatusCode != http.StatusForbidden || res.Header.Get("Retry-After") == "" {
+		t.Errorf("expected 403 with Retry-After after repeated rejections, got %d", res.StatusCode)
+	}
+	if res := request("192.0.2.2", http.MethodGet); res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected other addresses not to be banned, got %d", res.StatusCode)
+	}
+}
+
+func TestIPGrouping(t *testing.T) {
+	server := NewTestServer(t, blossy.WithIPGrouping(24, 48))
+
+	var groups []string
+	server.Blossy.After.Download.Append(func(r blossy.Request, res blossy.Response) {
+		groups = append(groups, r.IP().Group())
+	})
+
+	hash := blossom.ComputeHash([]byte("hello"))
+	for _, ip := range []string{"192.0.2.77", "2001:db8:1:2:3::1"} {
+		r := server.NewRequest(t, http.MethodGet, "/"+hash.Hex(), nil)
+		r.Header.Set("X-Real-IP", ip)
+		server.Do(t, r)
+	}
+
+	expected := []string{"192.0.2.0", "2001:db8:1::"}
+	if len(groups) != 2 || groups[0] != expected[0] || groups[1] != expected[1] {
+		t.Errorf("expected groups %v, got %v", expected, groups)
+	}
+
+	ip := blossy.IP{Raw: net.ParseIP("192.0.2.77")}
+	if ip.Group() != "192.0.2.77" {
+		t.Errorf("expected IPv4 addresses not to be grouped by default, got %s", ip.Group())
+	}
+	if a, b := ip.HashGroup([]byte("key")), ip.WithGrouping(24, 64).HashGroup([]byte("key")); a == b || len(a) != 32 {
+		t.Errorf("expected different hashes of 32 hex characters, got %s and %s", a, b)
+	}
+}
+
+// recorder is a [trace.TracerProvider] that records the ended spans.
+type recorder struct {
+	embedded.TracerProvider
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (r *recorder) Tracer(name string, opts ...trace.TracerOption) trace.Tracer {
+	return recordingTracer{r: r}
+}
+
+type recordingTracer struct {
+	embedded.Tracer
+	r *recorder
+}
+
+func (t recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	span := &recordedSpan{r: t.r, name: name, attrs: map[attribute.Key]attribute.Value{}}
+	if parent, ok := trace.SpanFromContext(ctx).(*recordedSpan); ok {
+		span.parent = parent.name
+	}
+	config := trace.NewSpanStartConfig(opts...)
+	span.SetAttributes(config.Attributes()...)
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+type recordedSpan struct {
+	noop.Span
+	r      *recorder
+	name   string
+	parent string
+	attrs  map[attribute.Key]attribute.Value
+}
+
+func (s *recordedSpan) SetAttributes(kv ...attribute.KeyValue) {
+	for _, attr := range kv {
+		s.attrs[attr.Key] = attr.Value
+	}
+}
+
+func (s *recordedSpan) End(...trace.SpanEndOption) {
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	s.r.spans = append(s.r.spans, s)
+}
+
+func (r *recorder) find(name string) *recordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, span := range r.spans {
+		if span.name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	rec := &recorder{}
+	server := NewTestServer(t, blossy.WithTracerProvider(rec))
+	signer := NewSigner(t)
+
+	desc, err := server.Client(t, signer).Upload(t.Context(), strings.NewReader("hello"), "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload := rec.find("blossy.upload")
+	if upload == nil {
+		t.Fatal("expected a span for the upload request")
+	}
+	if status := upload.attrs["http.response.status_code"].AsInt64(); status != http.StatusOK {
+		t.Errorf("expected the status code in the span, got %d", status)
+	}
+	if prefix := upload.attrs["blossy.pubkey_prefix"].AsString(); prefix != Pubkey(t, signer)[:8] {
+		t.Errorf("expected the pubkey prefix in the span, got %q", prefix)
+	}
+
+	if hook := rec.find("blossy.hook.upload"); hook == nil || hook.parent != "blossy.upload" {
+		t.Errorf("expected the hook span to be a child of the request span, got %+v", hook)
+	}
+	if save := rec.find("blossy.store.Save"); save == nil || save.parent != "blossy.hook.upload" {
+		t.Errorf("expected the store span to be a child of the hook span, got %+v", save)
+	}
+
+	server.Do(t, server.NewRequest(t, http.MethodGet, "/"+desc.Hash.Hex(), nil))
+	download := rec.find("blossy.download")
+	if download == nil || download.attrs["blossom.hash"].AsString() != desc.Hash.Hex() {
+		t.Errorf("expected a download span with the hash of the blob, got %+v", download)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	server := NewTestServer(t,
+		blossy.WithInfoEndpoint(""),
+		blossy.WithMaxUploadSize(1<<20),
+		blossy.WithAllowedTypes([]string{"image/*"}),
+		blossy.WithRequiredAuth(blossy.EndpointUpload),
+	)
+	server.Blossy.ExtendInfo(func(info *blossy.Info) {
+		info.Payment = &blossy.PaymentInfo{Endpoints: []blossy.Endpoint{blossy.EndpointUpload}, Methods: []string{"lightning"}}
+	})
+
+	res := server.Do(t, server.NewRequest(t, http.MethodGet, blossy.DefaultInfoPath, nil))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", res.StatusCode)
+	}
+
+	var info blossy.Info
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(info.BUDs, []string{"01", "02", "06", "07"}) {
+		t.Errorf("expected BUDs 01, 02, 06 and 07, got %v", info.BUDs)
+	}
+	if info.MaxUploadSize != 1<<20 || !slices.Equal(info.AllowedTypes, []string{"image/*"}) {
+		t.Errorf("expected the upload limits in the info, got %+v", info)
+	}
+	if !slices.Equal(info.RequiredAuth, []blossy.Endpoint{blossy.EndpointUpload}) {
+		t.Errorf("expected the upload to require auth, got %v", info.RequiredAuth)
+	}
+	if info.Payment == nil || info.Payment.Methods[0] != "lightning" {
+		t.Errorf("expected the payment requirements in the info, got %+v", info.Payment)
+	}
+}
+
+func TestWrapError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{err: fmt.Errorf("disk: %w", fs.ErrNotExist), code: http.StatusNotFound},
+		{err: blossy.ErrBlobNotFound, code: http.StatusNotFound},
+		{err: fmt.Errorf("s3: %w", context.DeadlineExceeded), code: http.StatusGatewayTimeout},
+		{err: blossy.ErrBlobTooLarge, code: http.StatusRequestEntityTooLarge},
+		{err: blossy.ErrRateLimited("slow down"), code: http.StatusTooManyRequests},
+		{err: errors.New("boom"), code: http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		err := blossy.WrapError(test.err)
+		if err == nil || err.Code != test.code {
+			t.Errorf("%v: expected code %d, got %v", test.err, test.code, err)
+		}
+		if !blossy.IsCode(fmt.Errorf("wrapped: %w", err), test.code) {
+			t.Errorf("%v: expected IsCode to match the wrapped error", test.err)
+		}
+	}
+
+	if blossy.WrapError(nil) != nil {
+		t.Error("expected a nil error to stay nil")
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	m := metrics.New()
+	server := NewTestServer(t, blossy.WithMetrics(m))
+	server.Blossy.On.Download = func(r blossy.Request, hash blossom.Hash, ext string) (blossy.BlobDelivery, *blossom.Error) {
+		panic("boom")
+	}
+
+	hash := blossom.ComputeHash([]byte("hello"))
+	res := server.Do(t, server.NewRequest(t, http.MethodGet, "/"+hash.Hex(), nil))
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", res.StatusCode)
+	}
+	if reason := res.Header.Get("X-Reason"); reason != "internal error" {
+		t.Errorf("expected X-Reason %q, got %q", "internal error", reason)
+	}
+
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`blossy_panics_total{endpoint="download"} 1`,
+		`blossy_requests_total{endpoint="download",method="GET",code="500"} 1`,
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("expected %q in the metrics, got\n%s", line, b.String())
+		}
+	}
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	server := NewTestServer(t, blossy.WithHandlerTimeout(50*time.Millisecond))
+	server.Blossy.On.Download = func(r blossy.Request, hash blossom.Hash, ext string) (blossy.BlobDelivery, *blossom.Error) {
+		<-r.Context().Done()
+		return nil, blossom.ErrInternal(r.Context().Err().Error())
+	}
+
+	hash := blossom.ComputeHash([]byte("hello"))
+	res := server.Do(t, server.NewRequest(t, http.MethodGet, "/"+hash.Hex(), nil))
+	if res.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("expected 504 after the deadline, got %d", res.StatusCode)
+	}
+}
+
+func TestMaxConcurrentUploads(t *testing.T) {
+	server := NewTestServer(t, blossy.WithMaxConcurrentUploads(2, 0), blossy.WithMaxConcurrentUploadsPerIP(1))
+	signer := NewSigner(t)
+
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	server.Blossy.On.Upload = func(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+		started <- struct{}{}
+		<-unblock
+		return blossom.BlobDescriptor{}, blossom.ErrInternal("not stored")
+	}
+
+	upload := func(ip string) *http.Response {
+		r := server.NewRequest(t, http.MethodPut, "/upload", strings.NewReader("hello"))
+		r.Header.Set("X-Real-IP", ip)
+		Authorize(t, r, signer, auth.ActionUpload)
+		return server.Do(t, r)
+	}
+
+	var wg sync.WaitGroup
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+		wg.Go(func() { upload(ip) })
+		<-started
+	}
+
+	res := upload("192.0.2.1")
+	if res.StatusCode != http.StatusTooManyRequests || res.Header.Get("Retry-After") == "" {
+		t.Errorf("expected 429 with Retry-After for a second upload from the same IP, got %d", res.StatusCode)
+	}
+
+	res = upload("192.0.2.3")
+	if res.StatusCode != http.StatusServiceUnavailable || res.Header.Get("Retry-After") == "" {
+		t.Errorf("expected 503 with Retry-After when all the slots are taken, got %d", res.StatusCode)
+	}
+
+	close(unblock)
+	wg.Wait()
+
+	go func() { <-started }()
+	if res := upload("192.0.2.3"); res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected the upload to reach the hook once the slots are released, got %d", res.StatusCode)
+	}
+}
+
+func TestDownloadRateLimit(t *testing.T) {
+	const rate = 64 * 1024
+	server := NewTestServer(t, blossy.WithPerConnectionRateLimit(rate))
+
+	data := bytes.Repeat([]byte("a"), rate+rate/2)
+	server.Blossy.On.Download = func(r blossy.Request, hash blossom.Hash, ext string) (blossy.BlobDelivery, *blossom.Error) {
+		return blossy.Serve(blossom.BlobFromBytes(data)), nil
+	}
+
+	start := time.Now()
+	res := server.Do(t, server.NewRequest(t, http.MethodGet, "/"+blossom.ComputeHash(data).Hex(), nil))
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("failed to read the body: %v", err)
+	}
+	elapsed := time.Since(start)
+
+	if !bytes.Equal(body, data) {
+		t.Fatalf("expected the blob of %d bytes, got %d bytes", len(data), len(body))
+	}
+	// the first second of transfer is served as a burst, the remaining half second is throttled
+	if elapsed < 400*time.Millisecond {
+		t.Errorf("expected the download to be throttled, took %v", elapsed)
+	}
+
+	_, err = blossy.NewServer(blossy.WithDownloadRateLimit(-1))
+	if err == nil {
+		t.Error("expected a negative rate limit to be rejected")
+	}
+}
+
+func TestServeFile(t *testing.T) {
+	data := []byte("hello from a file")
+	path := t.TempDir() + "/blob"
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("failed to write the file: %v", err)
+	}
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("failed to set the modification time: %v", err)
+	}
+
+	onDownload := func(r blossy.Request, hash blossom.Hash, ext string) (blossy.BlobDelivery, *blossom.Error) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, blossom.ErrInternal(err.Error())
+		}
+		blob, err := blossy.BlobFromFile(file)
+		if err != nil {
+			return nil, blossom.ErrInternal(err.Error())
+		}
+		return blossy.Serve(blob), nil
+	}
+	hash := blossom.ComputeHash(data)
+
+	tests := []struct {
+		name   string
+		opts   []blossy.Option
+		status int
+		body   string
+	}{
+		{name: "without range support", status: http.StatusOK, body: string(data)},
+		{name: "with range support", opts: []blossy.Option{blossy.WithRangeSupport()}, status: http.StatusPartialContent, body: "hello"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := NewTestServer(t, test.opts...)
+			server.Blossy.On.Download = onDownload
+
+			r := server.NewRequest(t, http.MethodGet, "/"+hash.Hex(), nil)
+			r.Header.Set("Range", "bytes=0-4")
+			res := server.Do(t, r)
+
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("failed to read the body: %v", err)
+			}
+			if res.StatusCode != test.status || string(body) != test.body {
+				t.Errorf("expected %d %q, got %d %q", test.status, test.body, res.StatusCode, body)
+			}
+			if lm := res.Header.Get("Last-Modified"); lm != modTime.Format(http.TimeFormat) {
+				t.Errorf("expected the modification time of the file as Last-Modified, got %q", lm)
+			}
+			if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+				t.Errorf("expected the detected content type, got %q", ct)
+			}
+		})
+	}
+}
+
+func TestUploadContent(t *testing.T) {
+	server := NewTestServer(t, blossy.WithInspectionSize(4))
+	signer := NewSigner(t)
+
+	var inspected []byte
+	server.Blossy.Reject.UploadContent.Append(func(r blossy.Request, hints blossy.UploadHints, head []byte) *blossom.Error {
+		inspected = slices.Clone(head)
+		if bytes.HasPrefix(head, []byte("%PDF")) {
+			return blossom.ErrUnsupportedMedia("PDFs are not accepted")
+		}
+		return nil
+	})
+
+	var read int
+	var readErr error
+	server.Blossy.On.Upload = func(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+		b, err := io.ReadAll(data)
+		read, readErr = len(b), err
+		if err != nil {
+			return blossom.BlobDescriptor{}, blossom.ErrInternal(err.Error())
+		}
+		return blossom.BlobDescriptor{Hash: blossom.ComputeHash(b), Size: int64(len(b)), Type: "text/plain"}, nil
+	}
+
+	upload := func(body string) *http.Response {
+		r := server.NewRequest(t, http.MethodPut, "/upload", strings.NewReader(body))
+		Authorize(t, r, signer, auth.ActionUpload)
+		return server.Do(t, r)
+	}
+
+	pdf := "%PDF-1.7 " + strings.Repeat("x", 1000)
+	res := upload(pdf)
+	if res.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415 for a PDF, got %d", res.StatusCode)
+	}
+	if string(inspected) != "%PDF" {
+		t.Errorf("expected the first 4 bytes to be inspected, got %q", inspected)
+	}
+	if readErr == nil || read > 4 {
+		t.Errorf("expected the hook to stop reading after the inspected bytes, read %d bytes with error %v", read, readErr)
+	}
+	if !res.Close {
+		t.Error("expected the connection to be closed after the rejection")
+	}
+
+	res = upload("hello world")
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 for a text, got %d", res.StatusCode)
+	}
+	if readErr != nil || read != len("hello world") {
+		t.Errorf("expected the hook to read the whole blob, read %d bytes with error %v", read, readErr)
+	}
+
+	res = upload("hi")
+	if res.StatusCode != http.StatusOK || string(inspected) != "hi" {
+		t.Errorf("expected blobs smaller than the inspection size to be inspected whole, got %d and %q", res.StatusCode, inspected)
+	}
+}
+
+func TestResumableUpload(t *testing.T) {
+	store, err := sessions.NewDisk(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create the session store: %v", err)
+	}
+
+	server := NewTestServer(t, blossy.WithResumableUploads(store, time.Hour))
+	signer := NewSigner(t)
+
+	create := func(hash blossom.Hash, size int) string {
+		r := server.NewRequest(t, http.MethodPost, "/upload", nil)
+		r.Header.Set("X-SHA-256", hash.Hex())
+		r.Header.Set("X-Content-Type", "text/plain")
+		r.Header.Set("X-Content-Length", fmt.Sprint(size))
+		Authorize(t, r, signer, auth.ActionUpload, hash)
+
+		res := server.Do(t, r)
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("expected 201 when creating the session, got %d", res.StatusCode)
+		}
+		return res.Header.Get("Location")
+	}
+
+	patch := func(location string, offset int, chunk string) *http.Response {
+		r := server.NewRequest(t, http.MethodPatch, location, strings.NewReader(chunk))
+		r.Header.Set("Content-Type", "application/offset+octet-stream")
+		r.Header.Set("Upload-Offset", fmt.Sprint(offset))
+		return server.Do(t, r)
+	}
+
+	data := "hello resumable world"
+	hash := blossom.ComputeHash([]byte(data))
+	location := create(hash, len(data))
+
+	if res := patch(location, 0, data[:6]); res.StatusCode != http.StatusNoContent || res.Header.Get("Upload-Offset") != "6" {
+		t.Fatalf("expected 204 with offset 6, got %d with offset %q", res.StatusCode, res.Header.Get("Upload-Offset"))
+	}
+	if res := patch(location, 0, data); res.StatusCode != http.StatusConflict {
+		t.Errorf("expected 409 for a stale offset, got %d", res.StatusCode)
+	}
+
+	res := server.Do(t, server.NewRequest(t, http.MethodHead, location, nil))
+	if res.StatusCode != http.StatusOK || res.Header.Get("Upload-Offset") != "6" {
+		t.Errorf("expected the session to resume from offset 6, got %d with offset %q", res.StatusCode, res.Header.Get("Upload-Offset"))
+	}
+
+	res = patch(location, 6, data[6:])
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 when completing the upload, got %d", res.StatusCode)
+	}
+	var desc blossom.BlobDescriptor
+	if err := json.NewDecoder(res.Body).Decode(&desc); err != nil {
+		t.Fatalf("failed to decode the descriptor: %v", err)
+	}
+	if desc.Hash != hash || server.Store.Len() != 1 {
+		t.Errorf("expected the blob %s to be stored, got %s", hash, desc.Hash)
+	}
+
+	if res := server.Do(t, server.NewRequest(t, http.MethodHead, location, nil)); res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the completed session to be deleted, got %d", res.StatusCode)
+	}
+
+	// the declared hash doesn't match the uploaded data
+	location = create(blossom.ComputeHash([]byte("something else")), len(data))
+	if res := patch(location, 0, data); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for a hash mismatch, got %d", res.StatusCode)
+	}
+	if server.Store.Len() != 1 {
+		t.Errorf("expected the mismatching blob not to be stored, got %d blobs", server.Store.Len())
+	}
+}
+
+// mutableBlocklist is a blocklist whose hashes can be added while the server is running.
+type mutableBlocklist struct {
+	mu     sync.Mutex
+	hashes []blossom.Hash
+}
+
+func (b *mutableBlocklist) Add(hash blossom.Hash) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.hashes = append(b.hashes, hash)
+}
+
+func (b *mutableBlocklist) Contains(hash blossom.Hash) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Contains(b.hashes, hash)
+}
+
+func TestResumableUploadPolicies(t *testing.T) {
+	store, err := sessions.NewDisk(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create the session store: %v", err)
+	}
+
+	var denied atomic.Bool
+	policy := func(pubkey string) bool { return !denied.Load() }
+	blocklist := &mutableBlocklist{}
+
+	server := NewTestServer(t,
+		blossy.WithResumableUploads(store, time.Hour),
+		blossy.WithPubkeyPolicy(policy, blossy.EndpointUpload),
+		blossy.WithBlocklist(blocklist),
+	)
+	signer := NewSigner(t)
+
+	create := func(data string) string {
+		hash := blossom.ComputeHash([]byte(data))
+		r := server.NewRequest(t, http.MethodPost, "/upload", nil)
+		r.Header.Set("X-SHA-256", hash.Hex())
+		r.Header.Set("X-Content-Type", "text/plain")
+		r.Header.Set("X-Content-Length", fmt.Sprint(len(data)))
+		Authorize(t, r, signer, auth.ActionUpload, hash)
+
+		res := server.Do(t, r)
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("expected 201 when creating the session, got %d", res.StatusCode)
+		}
+		return res.Header.Get("Location")
+	}
+
+	patch := func(location string, chunk string) *http.Response {
+		r := server.NewRequest(t, http.MethodPatch, location, strings.NewReader(chunk))
+		r.Header.Set("Content-Type", "application/offset+octet-stream")
+		r.Header.Set("Upload-Offset", "0")
+		return server.Do(t, r)
+	}
+
+	// the pubkey is denied after creating the session
+	data := "hello denied pubkey"
+	location := create(data)
+	denied.Store(true)
+	if res := patch(location, data); res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 for a denied pubkey, got %d", res.StatusCode)
+	}
+	denied.Store(false)
+	if res := patch(location, data); res.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 once the pubkey is allowed again, got %d", res.StatusCode)
+	}
+
+	// the blob is blocked after creating the session
+	data = "hello blocked blob"
+	location = create(data)
+	blocklist.Add(blossom.ComputeHash([]byte(data)))
+	if res := patch(location, data); res.StatusCode != http.StatusUnavailableForLegalReasons {
+		t.Errorf("expected 451 for a blocked blob, got %d", res.StatusCode)
+	}
+	if server.Store.Len() != 1 {
+		t.Errorf("expected the blocked blob not to be stored, got %d blobs", server.Store.Len())
+	}
+	if res := server.Do(t, server.NewRequest(t, http.MethodHead, location, nil)); res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the rejected session to be deleted, got %d", res.StatusCode)
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	server := NewTestServer(t, blossy.WithMultipartUploads())
+	signer := NewSigner(t)
+
+	var hints blossy.UploadHints
+	var received string
+	server.Blossy.On.Upload = func(r blossy.Request, h blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+		b, err := io.ReadAll(data)
+		if err != nil {
+			return blossom.BlobDescriptor{}, blossom.ErrInternal(err.Error())
+		}
+		hints, received = h, string(b)
+		return blossom.BlobDescriptor{Hash: blossom.ComputeHash(b), Size: int64(len(b)), Type: h.Type}, nil
+	}
+
+	form := func(filename, contentType, content string) (*bytes.Buffer, string) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("caption", "a cat")
+
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, filename))
+		if contentType != "" {
+			header.Set("Content-Type", contentType)
+		}
+		part, _ := writer.CreatePart(header)
+		part.Write([]byte(content))
+		writer.Close()
+		return body, writer.FormDataContentType()
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		filename    string
+		contentType string
+		expected    string
+	}{
+		{name: "declared type", method: http.MethodPut, filename: "cat.jpg", contentType: "image/png", expected: "image/png"},
+		{name: "type from extension", method: http.MethodPost, filename: "notes.txt", contentType: "application/octet-stream", expected: "text/plain; charset=utf-8"},
+		{name: "unknown type", method: http.MethodPost, filename: "blob"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, contentType := form(test.filename, test.contentType, "file content")
+			r := server.NewRequest(t, test.method, "/upload", body)
+			r.Header.Set("Content-Type", contentType)
+			Authorize(t, r, signer, auth.ActionUpload)
+
+			res := server.Do(t, r)
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("expected 200, got %d (%s)", res.StatusCode, res.Header.Get("X-Reason"))
+			}
+			if received != "file content" {
+				t.Errorf("expected the hook to receive the file, got %q", received)
+			}
+			if hints.Type != test.expected || hints.Size != -1 {
+				t.Errorf("expected type %q and unknown size, got %q and %d", test.expected, hints.Type, hints.Size)
+			}
+		})
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("caption", "no file")
+	writer.Close()
+
+	r := server.NewRequest(t, http.MethodPut, "/upload", body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	Authorize(t, r, signer, auth.ActionUpload)
+	if res := server.Do(t, r); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for a form without files, got %d", res.StatusCode)
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	admin := NewSigner(t)
+	server := NewTestServer(t, blossy.WithEventStream("", Pubkey(t, admin)))
+	user := NewSigner(t)
+
+	subscribe := func(signer auth.Signer) *http.Response {
+		r := server.NewRequest(t, http.MethodGet, blossy.DefaultEventsPath, nil)
+		if signer != nil {
+			Authorize(t, r, signer, auth.ActionGet)
+		}
+		return server.Do(t, r)
+	}
+
+	if res := subscribe(nil); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 without authorization, got %d", res.StatusCode)
+	}
+	if res := subscribe(user); res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 for a pubkey that is not an admin, got %d", res.StatusCode)
+	}
+
+	res := subscribe(admin)
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected 200 with an event stream, got %d %q", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	events := make(chan blossy.Event, 10)
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var event blossy.Event
+			if err := json.Unmarshal([]byte(data), &event); err == nil {
+				events <- event
+			}
+		}
+	}()
+
+	next := func() blossy.Event {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the event")
+			return blossy.Event{}
+		}
+	}
+
+	data := []byte("hello events")
+	hash := blossom.ComputeHash(data)
+	if _, err := server.Client(t, user).Upload(context.Background(), bytes.NewReader(data), "text/plain"); err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+
+	event := next()
+	if event.Type != blossy.EventUpload || event.Hash == nil || *event.Hash != hash || event.Pubkey != Pubkey(t, user) {
+		t.Errorf("expected an upload event of %s by the user, got %+v", hash, event)
+	}
+
+	r := server.NewRequest(t, http.MethodDelete, "/"+hash.Hex(), nil)
+	server.Do(t, r)
+
+	event = next()
+	if event.Type != blossy.EventReject || event.Status != http.StatusUnauthorized || event.Endpoint != blossy.EndpointDelete {
+		t.Errorf("expected a reject event of the unauthorized deletion, got %+v", event)
+	}
+
+	r = server.NewRequest(t, http.MethodDelete, "/"+hash.Hex(), nil)
+	Authorize(t, r, user, auth.ActionDelete, hash)
+	server.Do(t, r)
+
+	event = next()
+	if event.Type != blossy.EventDelete || *event.Hash != hash {
+		t.Errorf("expected a delete event of %s, got %+v", hash, event)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	const secret = "webhook secret"
+	type delivery struct {
+		header http.Header
+		event  blossy.Event
+		err    error
+	}
+
+	deliveries := make(chan delivery, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		d := delivery{header: r.Header, err: blossy.VerifyWebhook(r, body, secret, time.Minute)}
+		json.Unmarshal(body, &d.event)
+		deliveries <- d
+	}))
+	t.Cleanup(receiver.Close)
+
+	server := NewTestServer(t, blossy.WithWebhooks(receiver.URL, secret, blossy.EventUpload, blossy.EventQuotaExceeded))
+	server.Blossy.Reject.Upload.Append(func(r blossy.Request, hints blossy.UploadHints) *blossom.Error {
+		if hints.Type == "video/mp4" {
+			return blossy.ErrQuotaExceeded("0 bytes remaining")
+		}
+		return nil
+	})
+	user := NewSigner(t)
+
+	next := func() delivery {
+		select {
+		case d := <-deliveries:
+			return d
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the delivery")
+			return delivery{}
+		}
+	}
+
+	data := []byte("hello webhooks")
+	hash := blossom.ComputeHash(data)
+	if _, err := server.Client(t, user).Upload(context.Background(), bytes.NewReader(data), "text/plain"); err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+
+	d := next()
+	if d.err != nil {
+		t.Errorf("expected a valid signature, got %v", d.err)
+	}
+	if d.header.Get("X-Blossy-Event") != string(blossy.EventUpload) || d.event.Type != blossy.EventUpload || *d.event.Hash != hash {
+		t.Errorf("expected an upload event of %s, got %+v", hash, d.event)
+	}
+
+	if _, err := server.Client(t, user).Upload(context.Background(), bytes.NewReader([]byte("a video")), "video/mp4"); err == nil {
+		t.Fatal("expected the upload to exceed the quota")
+	}
+
+	d = next()
+	if d.event.Type != blossy.EventQuotaExceeded || d.event.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected a quota exceeded event, got %+v", d.event)
+	}
+
+	// events of other types are not delivered
+	r := server.NewRequest(t, http.MethodDelete, "/"+hash.Hex(), nil)
+	server.Do(t, r)
+
+	select {
+	case d := <-deliveries:
+		t.Errorf("expected no delivery of the rejected deletion, got %+v", d.event)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	forged := httptest.NewRequest(http.MethodPost, "/", nil)
+	forged.Header = d.header.Clone()
+	if err := blossy.VerifyWebhook(forged, []byte(`{"type":"delete"}`), secret, time.Minute); err == nil {
+		t.Error("expected a forged body to fail the verification")
+	}
+}
+
+func TestReload(t *testing.T) {
+	user := NewSigner(t)
+	other := NewSigner(t)
+
+	allowed := []string{Pubkey(t, user)}
+	server := NewTestServer(t, blossy.WithReloader(func(ctx context.Context) ([]blossy.Option, error) {
+		return []blossy.Option{blossy.WithAllowedPubkeys(allowed)}, nil
+	}))
+
+	if err := server.Blossy.Reload(t.Context()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	upload := func(signer auth.Signer) int {
+		r := server.NewRequest(t, http.MethodPut, "/upload", strings.NewReader("hello reload"))
+		Authorize(t, r, signer, auth.ActionUpload)
+		return server.Do(t, r).StatusCode
+	}
+
+	if status := upload(other); status != http.StatusForbidden {
+		t.Errorf("expected 403 for a pubkey that is not allowed, got %d", status)
+	}
+
+	allowed = []string{Pubkey(t, user), Pubkey(t, other)}
+	if err := server.Blossy.Reload(t.Context()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status := upload(other); status != http.StatusOK {
+		t.Errorf("expected 200 after allowing the pubkey, got %d", status)
+	}
+
+	allowed = []string{"not a pubkey"}
+	if err := server.Blossy.Reload(t.Context()); err == nil {
+		t.Error("expected the reload of an invalid pubkey to fail")
+	}
+	if status := upload(other); status != http.StatusOK {
+		t.Errorf("expected a failed reload to keep the previous settings, got %d", status)
+	}
+
+	if err := NewTestServer(t).Blossy.Reload(t.Context()); err == nil {
+		t.Error("expected the reload of a server without a reloader to fail")
+	}
+}
+
+func TestPathPrefix(t *testing.T) {
+	server := NewTestServer(t, blossy.WithPathPrefix("/blossom/"))
+	signer := NewSigner(t)
+
+	data := []byte("hello prefix")
+	hash := blossom.ComputeHash(data)
+
+	r := server.NewRequest(t, http.MethodPut, "/blossom/upload", bytes.NewReader(data))
+	Authorize(t, r, signer, auth.ActionUpload)
+	res := server.Do(t, r)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d (%s)", res.StatusCode, res.Header.Get("X-Reason"))
+	}
+
+	var desc blossom.BlobDescriptor
+	if err := json.NewDecoder(res.Body).Decode(&desc); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(desc.URL, "/blossom/"+hash.Hex()) {
+		t.Errorf("expected the URL to include the prefix, got %s", desc.URL)
+	}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{path: "/blossom/" + hash.Hex(), status: http.StatusOK},
+		{path: "/" + hash.Hex(), status: http.StatusNotFound},
+		{path: "/blossomx/" + hash.Hex(), status: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		res := server.Do(t, server.NewRequest(t, http.MethodGet, test.path, nil))
+		if res.StatusCode != test.status {
+			t.Errorf("GET %s: expected %d, got %d", test.path, test.status, res.StatusCode)
+		}
+	}
+}
+
+func TestLandingPage(t *testing.T) {
+	server := NewTestServer(t, blossy.WithLandingPage(""), blossy.WithInfoEndpoint(""))
+
+	res := server.Do(t, server.NewRequest(t, http.MethodGet, "/", nil))
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK || !strings.HasPrefix(res.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("expected 200 with an HTML page, got %d %q", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), `href="`+blossy.DefaultInfoPath+`"`) {
+		t.Errorf("expected the page to link the info endpoint, got\n%s", body)
+	}
+
+	if res := server.Do(t, server.NewRequest(t, http.MethodGet, "/favicon.ico", nil)); res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown path, got %d", res.StatusCode)
+	}
+
+	hash := blossom.ComputeHash([]byte("missing"))
+	res = server.Do(t, server.NewRequest(t, http.MethodGet, "/"+hash.Hex()+".png", nil))
+	if res.StatusCode != http.StatusNotFound || strings.HasPrefix(res.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("expected blob paths to be handled as downloads, got %d %q", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	custom := NewTestServer(t, blossy.WithRootHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://example.com", http.StatusFound)
+	})))
+	r := custom.NewRequest(t, http.MethodGet, "/", nil)
+	res, err := http.DefaultTransport.RoundTrip(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Errorf("expected the root handler to redirect, got %d", res.StatusCode)
+	}
+}
+
+func TestNIP05(t *testing.T) {
+	bob := Pubkey(t, NewSigner(t))
+	server := NewTestServer(t, blossy.WithPathPrefix("/blossom"), blossy.WithNIP05Names(map[string]string{"Bob": bob}))
+
+	lookup := func(name string) map[string]string {
+		res := server.Do(t, server.NewRequest(t, http.MethodGet, blossy.NIP05Path+"?name="+name, nil))
+		if res.StatusCode != http.StatusOK || res.Header.Get("Access-Control-Allow-Origin") != "*" {
+			t.Fatalf("expected 200 with CORS, got %d %q", res.StatusCode, res.Header.Get("Access-Control-Allow-Origin"))
+		}
+
+		var doc struct {
+			Names map[string]string `json:"names"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+			t.Fatal(err)
+		}
+		return doc.Names
+	}
+
+	if names := lookup("BOB"); names["bob"] != bob {
+		t.Errorf("expected the pubkey of bob, got %v", names)
+	}
+	if names := lookup("alice"); len(names) != 0 {
+		t.Errorf("expected no names for an unknown name, got %v", names)
+	}
+
+	_, err := blossy.NewServer(blossy.WithNIP05Names(map[string]string{"bob smith": bob}))
+	if err == nil {
+		t.Error("expected an invalid name to be rejected")
+	}
+}
+
+func TestURLBuilder(t *testing.T) {
+	server := NewTestServer(t, blossy.WithURLBuilder(func(desc blossom.BlobDescriptor) string {
+		return "https://" + desc.Hash.Hex()[:2] + ".cdn.example.com/" + desc.Hash.Hex()
+	}))
+	signer := NewSigner(t)
+
+	data := []byte("hello cdn")
+	hash := blossom.ComputeHash(data)
+	desc, err := server.Client(t, signer).Upload(context.Background(), bytes.NewReader(data), "text/plain")
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+
+	expected := "https://" + hash.Hex()[:2] + ".cdn.example.com/" + hash.Hex()
+	if desc.URL != expected {
+		t.Errorf("expected the URL %s, got %s", expected, desc.URL)
+	}
+
+	descs, err := server.Client(t, signer).List(context.Background(), Pubkey(t, signer), blossy.ListQuery{})
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	if len(descs) != 1 || descs[0].URL != expected {
+		t.Errorf("expected the listed blob to have the URL %s, got %+v", expected, descs)
+	}
+}
+
+func TestHostnames(t *testing.T) {
+	server := NewTestServer(t, blossy.WithHostnames(Hostname, "cdn.example.com"))
+	signer := NewSigner(t)
+
+	tests := []struct {
+		host     string
+		bound    string
+		status   int
+		expected string
+	}{
+		{host: "cdn.example.com", bound: "cdn.example.com", status: http.StatusOK, expected: "https://cdn.example.com/"},
+		{host: "CDN.example.com:443", bound: Hostname, status: http.StatusOK, expected: "https://cdn.example.com/"},
+		{host: "other.example.com", bound: Hostname, status: http.StatusOK, expected: "https://" + Hostname + "/"},
+		{host: "cdn.example.com", bound: "other.example.com", status: http.StatusUnauthorized},
+	}
+
+	for i, test := range tests {
+		data := []byte(fmt.Sprintf("hello virtual host %d", i))
+		r := server.NewRequest(t, http.MethodPut, "/upload", bytes.NewReader(data))
+		r.Host = test.host
+
+		header, err := auth.SignBlossomAuth(r.Context(), signer, auth.ActionUpload, nil, []string{test.bound}, time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Authorization", header)
+
+		res := server.Do(t, r)
+		if res.StatusCode != test.status {
+			t.Fatalf("host %s bound to %s: expected %d, got %d (%s)", test.host, test.bound, test.status, res.StatusCode, res.Header.Get("X-Reason"))
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		var desc blossom.BlobDescriptor
+		if err := json.NewDecoder(res.Body).Decode(&desc); err != nil {
+			t.Fatal(err)
+		}
+		if expected := test.expected + blossom.ComputeHash(data).Hex() + ".txt"; desc.URL != expected {
+			t.Errorf("host %s: expected the URL %s, got %s", test.host, expected, desc.URL)
+		}
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "blossy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := dir + "/blossy.sock"
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	server, err := blossy.NewServer(blossy.WithHostname(Hostname), blossy.WithUnixSocketMode(0o660))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.StartAndServe(ctx, blossy.UnixPrefix+path) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+
+	var res *http.Response
+	for range 50 {
+		if res, err = client.Get("http://" + Hostname + "/" + blossom.ComputeHash([]byte("missing")).Hex()); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("failed to reach the server: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", res.StatusCode)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o660 {
+		t.Errorf("expected the socket mode 0660, got %v", info.Mode().Perm())
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("failed to stop the server: %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the socket file to be removed, got %v", err)
+	}
+}
+
+func TestH2C(t *testing.T) {
+	server, err := blossy.NewServer(
+		blossy.WithHostname(Hostname),
+		blossy.WithH2C(),
+		blossy.WithHTTP2Config(&http.HTTP2Config{MaxConcurrentStreams: 10}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx, listener)
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+
+	res, err := client.Get("http://" + listener.Addr().String() + "/" + blossom.ComputeHash([]byte("missing")).Hex())
+	if err != nil {
+		t.Fatalf("failed to reach the server: %v", err)
+	}
+	res.Body.Close()
+
+	if res.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2, got %s", res.Proto)
+	}
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", res.StatusCode)
+	}
+}
+
+func TestRejected(t *testing.T) {
+	server := NewTestServer(t)
+	server.Blossy.Reject.Download.Append(func(r blossy.Request, hash blossom.Hash, ext string) *blossom.Error {
+		return blossy.Rejected(r, blossy.Rejection{
+			Status:    http.StatusPaymentRequired,
+			Code:      "payment_required",
+			Retryable: true,
+			Details:   map[string]any{"amount": 21},
+		})
+	})
+
+	res := server.Do(t, server.NewRequest(t, http.MethodGet, "/"+blossom.ComputeHash([]byte("paid")).Hex(), nil))
+	if res.StatusCode != http.StatusPaymentRequired {
+		t.Fatalf("expected 402, got %d", res.StatusCode)
+	}
+	if reason := res.Header.Get("X-Reason"); reason != "payment_required" {
+		t.Errorf("expected the code as the reason, got %q", reason)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected a JSON body, got %q", ct)
+	}
+
+	var rejection blossy.Rejection
+	if err := json.NewDecoder(res.Body).Decode(&rejection); err != nil {
+		t.Fatal(err)
+	}
+	if rejection.Code != "payment_required" || !rejection.Retryable || rejection.Details["amount"] != float64(21) {
+		t.Errorf("unexpected rejection %+v", rejection)
+	}
+
+	// requests that were not routed by the server only get the error
+	err := blossy.Rejected(blossy.NewTestRequest(), blossy.Rejection{Status: http.StatusTooManyRequests, Code: "slow_down", Reason: "wait"})
+	if err.Code != http.StatusTooManyRequests || err.Reason != "wait" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestHookRegistration(t *testing.T) {
+	server := NewTestServer(t)
+	var calls []string
+	hook := func(name string) func(blossy.Request, blossom.Hash, string) *blossom.Error {
+		return func(blossy.Request, blossom.Hash, string) *blossom.Error {
+			calls = append(calls, name)
+			return nil
+		}
+	}
+
+	hooks := &server.Blossy.Reject.Download
+	hooks.Append(hook("append"))
+	hooks.Register("late", 10, hook("late"))
+	hooks.Register("early", -10, hook("early"))
+	hooks.Register("same", 0, hook("same"))
+	hooks.Prepend(hook("prepend"))
+	hooks.Register("late", 5, hook("late v2"))
+
+	expected := []blossy.HookInfo{
+		{Priority: -10},
+		{Name: "early", Priority: -10},
+		{Priority: 0},
+		{Name: "same", Priority: 0},
+		{Name: "late", Priority: 5},
+	}
+	if list := hooks.List(); !slices.Equal(list, expected) {
+		t.Fatalf("expected the hooks %v, got %v", expected, list)
+	}
+
+	server.Do(t, server.NewRequest(t, http.MethodGet, "/"+blossom.ComputeHash([]byte("hooks")).Hex(), nil))
+	if want := []string{"prepend", "early", "append", "same", "late v2"}; !slices.Equal(calls, want) {
+		t.Errorf("expected the calls %v, got %v", want, calls)
+	}
+
+	if !hooks.Remove("same") || hooks.Remove("same") || hooks.Remove("") {
+		t.Error("expected only the registered name to be removed once")
+	}
+	if hooks.Len() != 4 {
+		t.Errorf("expected 4 hooks, got %d", hooks.Len())
+	}
+}
+
+func TestInstall(t *testing.T) {
+	server := NewTestServer(t)
+	limit := blossy.NewPlugin("limit", func(s *blossy.Server) error {
+		s.Reject.Upload.Register("limit", 0, func(r blossy.Request, hints blossy.UploadHints) *blossom.Error {
+			return blossom.ErrTooLarge("too large")
+		})
+		return nil
+	})
+	broken := blossy.NewPlugin("broken", func(s *blossy.Server) error {
+		return errors.New("missing backend")
+	})
+
+	if err := server.Blossy.Install(limit); err != nil {
+		t.Fatalf("failed to install: %v", err)
+	}
+	if err := server.Blossy.Install(limit); err == nil {
+		t.Error("expected the plugin to be installed once")
+	}
+	if err := server.Blossy.Install(broken); err == nil || !strings.Contains(err.Error(), "missing backend") {
+		t.Errorf("expected the error of the setup, got %v", err)
+	}
+	if plugins := server.Blossy.Plugins(); !slices.Equal(plugins, []string{"limit"}) {
+		t.Errorf("expected the installed plugins [limit], got %v", plugins)
+	}
+
+	r := server.NewRequest(t, http.MethodPut, "/upload", strings.NewReader("hello plugin"))
+	Authorize(t, r, NewSigner(t), auth.ActionUpload)
+	if res := server.Do(t, r); res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected the hook of the plugin to reject the upload, got %d", res.StatusCode)
+	}
+}
+
+func TestRequestValues(t *testing.T) {
+	type tierKey struct{}
+	server := NewTestServer(t)
+
+	server.Blossy.Reject.Upload.Append(func(r blossy.Request, hints blossy.UploadHints) *blossom.Error {
+		r.Set(tierKey{}, "gold")
+		return nil
+	})
+
+	var tiers []any
+	upload := server.Blossy.On.Upload
+	server.Blossy.On.Upload = func(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+		tiers = append(tiers, r.Get(tierKey{}))
+		return upload(r, hints, data)
+	}
+	server.Blossy.After.Upload.Append(func(r blossy.Request, res blossy.Response) {
+		tiers = append(tiers, r.Get(tierKey{}))
+	})
+
+	r := server.NewRequest(t, http.MethodPut, "/upload", strings.NewReader("hello values"))
+	Authorize(t, r, NewSigner(t), auth.ActionUpload)
+	if res := server.Do(t, r); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d (%s)", res.StatusCode, res.Header.Get("X-Reason"))
+	}
+	if !slices.Equal(tiers, []any{"gold", "gold"}) {
+		t.Errorf("expected the On and After hooks to see the tier, got %v", tiers)
+	}
+
+	req := blossy.NewTestRequest(blossy.RequestValue(tierKey{}, "silver"))
+	if tier := req.Get(tierKey{}); tier != "silver" {
+		t.Errorf("expected the test request to carry the tier, got %v", tier)
+	}
+	if value := req.Get("missing"); value != nil {
+		t.Errorf("expected nil for a missing key, got %v", value)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	server, err := blossy.NewServer(
+		blossy.WithHostname(Hostname),
+		blossy.WithWorkers(2),
+		blossy.WithJobQueueSize(10),
+		blossy.WithJobRetries(3, time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var done []string
+	record := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		done = append(done, name)
+	}
+
+	attempts := 0
+	flaky := func(ctx context.Context) error {
+		if attempts++; attempts < 3 {
+			return errors.New("not yet")
+		}
+		record("flaky")
+		return nil
+	}
+	slow := func(ctx context.Context) error {
+		time.Sleep(50 * time.Millisecond)
+		record("slow")
+		return nil
+	}
+
+	if err := server.Enqueue("flaky", flaky); err != nil {
+		t.Fatalf("failed to enqueue: %v", err)
+	}
+	for range 3 {
+		if err := server.Enqueue("slow", slow); err != nil {
+			t.Fatalf("failed to enqueue: %v", err)
+		}
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exited := make(chan error, 1)
+	go func() { exited <- server.Serve(ctx, listener) }()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-exited; err != nil {
+		t.Fatalf("failed to stop the server: %v", err)
+	}
+
+	// the queue is drained before Serve returns
+	if len(done) != 4 || !slices.Contains(done, "flaky") {
+		t.Errorf("expected all the jobs to be done, got %v", done)
+	}
+
+	noWorkers, err := blossy.NewServer(blossy.WithHostname(Hostname))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := noWorkers.Enqueue("job", slow); !errors.Is(err, blossy.ErrNoWorkers) {
+		t.Errorf("expected ErrNoWorkers, got %v", err)
+	}
+}
+
+func TestWebhooksShutdown(t *testing.T) {
+	const secret = "webhook secret"
+	var received atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond) // a slow receiver
+		received.Add(1)
+	}))
+	t.Cleanup(receiver.Close)
+
+	server, err := blossy.NewServer(
+		blossy.WithHostname(Hostname),
+		blossy.WithWebhooks(receiver.URL, secret, blossy.EventUpload),
+		blossy.WithShutdownTimeout(5*time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blossy.BindStore(server, memory.New())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := strings.Replace("http://"+listener.Addr().String(), "127.0.0.1", Hostname, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exited := make(chan error, 1)
+	go func() { exited <- server.Serve(ctx, listener) }()
+
+	c, err := client.New(url, client.WithSigner(NewSigner(t)), client.WithRetries(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Upload(context.Background(), strings.NewReader("hello shutdown"), "text/plain"); err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+
+	cancel()
+	if err := <-exited; err != nil {
+		t.Fatalf("failed to stop the server: %v", err)
+	}
+
+	// the delivery in flight completes before Serve returns
+	if n := received.Load(); n != 1 {
+		t.Errorf("expected the delivery to complete before the server stopped, got %d deliveries", n)
+	}
+}
+
+func TestBlobCacheDelivery(t *testing.T) {
+	server := NewTestServer(t, blossy.WithBlobCache(cache.NewMemory()))
+	desc, err := server.Client(t, NewSigner(t)).Upload(context.Background(), strings.NewReader("hello cached headers"), "text/plain")
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var calls atomic.Int32
+	download := server.Blossy.On.Download
+	server.Blossy.On.Download = func(r blossy.Request, hash blossom.Hash, ext string) (blossy.BlobDelivery, *blossom.Error) {
+		calls.Add(1)
+		blob, err := server.Store.Get(r.Context(), hash)
+		if err != nil {
+			return download(r, hash, ext)
+		}
+		return blossy.Serve(blob,
+			blossy.LastModified(modified),
+			blossy.Attachment("hello.txt"),
+			blossy.CacheControl("public, max-age=60"),
+		), nil
+	}
+
+	headers := []string{"Last-Modified", "Content-Disposition", "Cache-Control"}
+	first := server.Do(t, server.NewRequest(t, http.MethodGet, "/"+desc.Hash.Hex(), nil))
+	first.Body.Close()
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		res := server.Do(t, server.NewRequest(t, method, "/"+desc.Hash.Hex(), nil))
+		res.Body.Close()
+		for _, h := range headers {
+			if got, expected := res.Header.Get(h), first.Header.Get(h); got != expected || got == "" {
+				t.Errorf("%s from the cache: expected %s %q, got %q", method, h, expected, got)
+			}
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected the blob to be served from the cache, got %d calls of the hook", n)
+	}
+}
+
+func TestSingleflight(t *testing.T) {
+	server := NewTestServer(t, blossy.WithBlobCache(cache.NewMemory()), blossy.WithSingleflight())
+	signer := NewSigner(t)
+
+	data := []byte("hello cold blob")
+	hash := blossom.ComputeHash(data)
+	if _, err := server.Client(t, signer).Upload(context.Background(), bytes.NewReader(data), "text/plain"); err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	download := server.Blossy.On.Download
+	server.Blossy.On.Download = func(r blossy.Request, hash blossom.Hash, ext string) (blossy.BlobDelivery, *blossom.Error) {
+		calls.Add(1)
+		<-release
+		return download(r, hash, ext)
+	}
+
+	const requests = 10
+	var wg sync.WaitGroup
+	bodies := make([][]byte, requests)
+	for i := range requests {
+		wg.Go(func() {
+			res, err := server.Server.Client().Get(server.URL + "/" + hash.Hex())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer res.Body.Close()
+			bodies[i], _ = io.ReadAll(res.Body)
+		})
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected the Download hook to be called once, got %d", n)
+	}
+	for i, body := range bodies {
+		if !bytes.Equal(body, data) {
+			t.Errorf("request %d: expected the blob, got %q", i, body)
+		}
+	}
+
+	if _, err := blossy.NewServer(blossy.WithSingleflight()); err == nil {
+		t.Error("expected singleflight without a cache to be rejected")
+	}
+}
+
+func TestSingleflightError(t *testing.T) {
+	server := NewTestServer(t, blossy.WithBlobCache(cache.NewMemory()), blossy.WithSingleflight())
+	signer := NewSigner(t)
+
+	data := []byte("hello failing leader")
+	hash := blossom.ComputeHash(data)
+	if _, err := server.Client(t, signer).Upload(context.Background(), bytes.NewReader(data), "text/plain"); err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+
+	// the first request fails, and its error must not be shared with the others
+	var calls atomic.Int32
+	release := make(chan struct{})
+	download := server.Blossy.On.Download
+	server.Blossy.On.Download = func(r blossy.Request, hash blossom.Hash, ext string) (blossy.BlobDelivery, *blossom.Error) {
+		if calls.Add(1) == 1 {
+			<-release
+			return nil, blossom.ErrUnauthorized("upstream rejected the request")
+		}
+		return download(r, hash, ext)
+	}
+
+	const requests = 5
+	var wg sync.WaitGroup
+	statuses := make([]int, requests)
+	for i := range requests {
+		wg.Go(func() {
+			res, err := server.Server.Client().Get(server.URL + "/" + hash.Hex())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			res.Body.Close()
+			statuses[i] = res.StatusCode
+		})
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	failed := 0
+	for _, status := range statuses {
+		switch status {
+		case http.StatusOK:
+		case http.StatusUnauthorized:
+			failed++
+		default:
+			t.Errorf("unexpected status %d", status)
+		}
+	}
+	if failed != 1 {
+		t.Errorf("expected only the first request to fail, got %d failures: %v", failed, statuses)
+	}
+}
+
+func TestDeliveryHeaders(t *testing.T) {
+	server := NewTestServer(t, blossy.WithContentDisposition("attachment"))
+	signer := NewSigner(t)
+
+	data := []byte("hello disposition")
+	hash := blossom.ComputeHash(data)
+	if _, err := server.Client(t, signer).Upload(context.Background(), bytes.NewReader(data), "text/plain"); err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+
+	res := server.Do(t, server.NewRequest(t, http.MethodGet, "/"+hash.Hex(), nil))
+	if cd := res.Header.Get("Content-Disposition"); cd != `attachment; filename=`+hash.Hex()+`.txt` {
+		t.Errorf("expected the default disposition, got %q", cd)
+	}
+
+	uploaded := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	server.Blossy.On.Download = func(r blossy.Request, hash blossom.Hash, ext string) (blossy.BlobDelivery, *blossom.Error) {
+		blob, err := server.Store.Get(r.Context(), hash)
+		if err != nil {
+			return nil, blossy.WrapError(err)
+		}
+		return blossy.Serve(blob, blossy.Inline("my notes.txt"), blossy.LastModified(uploaded)), nil
+	}
+
+	res = server.Do(t, server.NewRequest(t, http.MethodGet, "/"+hash.Hex()+".txt", nil))
+	if cd := res.Header.Get("Content-Disposition"); cd != `inline; filename="my notes.txt"` {
+		t.Errorf("expected the disposition of the hook, got %q", cd)
+	}
+	if lm := res.Header.Get("Last-Modified"); lm != uploaded.Format(http.TimeFormat) {
+		t.Errorf("expected the Last-Modified of the hook, got %q", lm)
+	}
+
+	r := server.NewRequest(t, http.MethodGet, "/"+hash.Hex(), nil)
+	r.Header.Set("If-Modified-Since", uploaded.Add(time.Hour).Format(http.TimeFormat))
+	if res := server.Do(t, r); res.StatusCode != http.StatusNotModified {
+		t.Errorf("expected 304, got %d", res.StatusCode)
+	}
+
+	if _, err := blossy.NewServer(blossy.WithContentDisposition("download")); err == nil {
+		t.Error("expected an invalid disposition to be rejected")
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	server := NewTestServer(t, blossy.WithSecurityHeaders(blossy.SecurityPolicy{CrossOriginResourcePolicy: "same-site"}))
+	client := server.Client(t, NewSigner(t))
+
+	svg, err := client.Upload(context.Background(), strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`), "image/svg+xml")
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	text, err := client.Upload(context.Background(), strings.NewReader("hello security"), "text/plain")
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+
+	res := server.Do(t, server.NewRequest(t, http.MethodGet, "/"+svg.Hash.Hex(), nil))
+	if csp := res.Header.Get("Content-Security-Policy"); csp != blossy.DefaultContentSecurityPolicy {
+		t.Errorf("expected the default CSP for an SVG of type %q, got %q", res.Header.Get("Content-Type"), csp)
+	}
+	if nosniff := res.Header.Get("X-Content-Type-Options"); nosniff != "nosniff" {
+		t.Errorf("expected nosniff, got %q", nosniff)
+	}
+	if corp := res.Header.Get("Cross-Origin-Resource-Policy"); corp != "same-site" {
+		t.Errorf("expected the configured CORP, got %q", corp)
+	}
+
+	res = server.Do(t, server.NewRequest(t, http.MethodGet, "/"+text.Hash.Hex(), nil))
+	if csp := res.Header.Get("Content-Security-Policy"); csp != "" {
+		t.Errorf("expected no CSP for plain text, got %q", csp)
+	}
+
+	if _, err := blossy.NewServer(blossy.WithSecurityHeaders(blossy.SecurityPolicy{CrossOriginResourcePolicy: "anyone"})); err == nil {
+		t.Error("expected an invalid CORP to be rejected")
+	}
+}
+
+func TestContentPolicy(t *testing.T) {
+	server := NewTestServer(t, blossy.WithContentPolicy(map[string]blossy.ContentAction{
+		"text/html":     blossy.ContentPlainText,
+		"image/*":       blossy.ContentAttachment,
+		"image/svg+xml": blossy.ContentAttachment,
+		"image/png":     blossy.ContentServe,
+	}))
+	client := server.Client(t, NewSigner(t))
+
+	upload := func(body, mime string) blossom.Hash {
+		desc, err := client.Upload(context.Background(), strings.NewReader(body), mime)
+		if err != nil {
+			t.Fatalf("failed to upload: %v", err)
+		}
+		return desc.Hash
+	}
+	html := upload("<html><script>alert(1)</script></html>", "text/html")
+	svg := upload(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`, "image/svg+xml")
+
+	tests := []struct {
+		method      string
+		hash        blossom.Hash
+		contentType string
+		disposition string
+	}{
+		{method: http.MethodGet, hash: html, contentType: "text/plain; charset=utf-8"},
+		{method: http.MethodHead, hash: html, contentType: "text/plain; charset=utf-8"},
+		{method: http.MethodGet, hash: svg, contentType: "image/svg+xml", disposition: "attachment; filename=" + svg.Hex() + ".svg"},
+		{method: http.MethodHead, hash: svg, contentType: "image/svg+xml", disposition: "attachment; filename=" + svg.Hex() + ".svg"},
+	}
+
+	for _, test := range tests {
+		res := server.Do(t, server.NewRequest(t, test.method, "/"+test.hash.Hex(), nil))
+		if ct := res.Header.Get("Content-Type"); ct != test.contentType {
+			t.Errorf("%s %s: expected the type %q, got %q", test.method, test.hash, test.contentType, ct)
+		}
+		if cd := res.Header.Get("Content-Disposition"); cd != test.disposition {
+			t.Errorf("%s %s: expected the disposition %q, got %q", test.method, test.hash, test.disposition, cd)
+		}
+	}
+
+	if _, err := blossy.NewServer(blossy.WithContentPolicy(map[string]blossy.ContentAction{"text/html": "hide"})); err == nil {
+		t.Error("expected an unknown action to be rejected")
+	}
+}
+
+func TestMimeTypes(t *testing.T) {
+	tests := []struct {
+		ext  string
+		mime string
+	}{
+		{ext: "avif", mime: "image/avif"},
+		{ext: ".HEIC", mime: "image/heic"},
+		{ext: "tar.gz", mime: "application/x-compressed-tar"},
+		{ext: "min.js", mime: "text/javascript"},
+		{ext: "unknown", mime: "application/octet-stream"},
+	}
+	for _, test := range tests {
+		if mime := blossy.MimeForExt(test.ext); mime != test.mime {
+			t.Errorf("MimeForExt(%q): expected %q, got %q", test.ext, test.mime, mime)
+		}
+	}
+	if ext := blossy.ExtForMime("application/x-compressed-tar"); ext != "tar.gz" {
+		t.Errorf("expected the extension tar.gz, got %q", ext)
+	}
+
+	server := NewTestServer(t,
+		blossy.WithContentDisposition("inline"),
+		blossy.WithMimeTypes(map[string]string{
+			".cbz":  "application/vnd.comicbook+zip",
+			"comic": "application/vnd.comicbook+zip",
+		}),
+	)
+	if mime := server.Blossy.MimeForExt("CBZ"); mime != "application/vnd.comicbook+zip" {
+		t.Errorf("expected the configured type, got %q", mime)
+	}
+	if ext := server.Blossy.ExtForMime("application/vnd.comicbook+zip"); ext != "cbz" {
+		t.Errorf("expected the shortest extension, got %q", ext)
+	}
+	if ext := server.Blossy.ExtForMime("image/png"); ext != "png" {
+		t.Errorf("expected the default extension, got %q", ext)
+	}
+
+	desc, err := server.Client(t, NewSigner(t)).Upload(context.Background(), strings.NewReader("PK comic"), "application/vnd.comicbook+zip")
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	if !strings.HasSuffix(desc.URL, desc.Hash.Hex()+".cbz") {
+		t.Errorf("expected the URL to have the configured extension, got %q", desc.URL)
+	}
+
+	res := server.Do(t, server.NewRequest(t, http.MethodGet, "/"+desc.Hash.Hex(), nil))
+	if cd := res.Header.Get("Content-Disposition"); !strings.Contains(cd, desc.Hash.Hex()+".cbz") {
+		t.Errorf("expected the filename to have the configured extension, got %q", cd)
+	}
+
+	if _, err := blossy.NewServer(blossy.WithMimeTypes(map[string]string{"": "text/plain"})); err == nil {
+		t.Error("expected an empty extension to be rejected")
+	}
+}
+
+func TestDerive(t *testing.T) {
+	server := NewTestServer(t, blossy.WithBlobCache(cache.NewMemory()))
+	desc, err := server.Client(t, NewSigner(t)).Upload(context.Background(), strings.NewReader("hello derived"), "text/plain")
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+
+	var calls atomic.Int32
+	server.Blossy.Derive("upper", blossy.Derivation{
+		Download: func(r blossy.Request, hash blossom.Hash) (blossy.BlobDelivery, *blossom.Error) {
+			calls.Add(1)
+			blob, err := server.Store.Get(r.Context(), hash)
+			if err != nil {
+				return nil, blossom.ErrNotFound("Blob not found")
+			}
+			defer blob.Close()
+			data, _ := io.ReadAll(blob)
+			upper := strings.ToUpper(string(data))
+			return blossy.Serve(blossom.BlobFromStream(io.NopCloser(strings.NewReader(upper)), int64(len(upper)), "text/x-upper")), nil
+		},
+	})
+
+	get := func(path string) (*http.Response, string) {
+		res := server.Do(t, server.NewRequest(t, http.MethodGet, path, nil))
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return res, string(body)
+	}
+
+	// the blob is cached, but the derivation must still be served
+	if _, body := get("/" + desc.Hash.Hex()); body != "hello derived" {
+		t.Fatalf("expected the blob, got %q", body)
+	}
+	res, body := get("/" + desc.Hash.Hex() + ".UPPER")
+	if res.StatusCode != http.StatusOK || body != "HELLO DERIVED" {
+		t.Fatalf("expected the derivation, got %d %q", res.StatusCode, body)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "text/x-upper" {
+		t.Errorf("expected the type of the derivation, got %q", ct)
+	}
+	if _, body := get("/" + desc.Hash.Hex() + ".txt"); body != "hello derived" {
+		t.Errorf("expected the derivation not to be cached as the blob, got %q", body)
+	}
+
+	res = server.Do(t, server.NewRequest(t, http.MethodHead, "/"+desc.Hash.Hex()+".upper", nil))
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/x-upper" || res.ContentLength != int64(len("HELLO DERIVED")) {
+		t.Errorf("expected the metadata of the derivation, got %d %q %d", res.StatusCode, res.Header.Get("Content-Type"), res.ContentLength)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected the derivation to be called twice, got %d", calls.Load())
+	}
+
+	res, _ = get("/" + blossom.ComputeHash([]byte("missing")).Hex() + ".upper")
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing blob, got %d", res.StatusCode)
+	}
+
+	// HEAD requests use the Check function of the derivation, if set
+	server.Blossy.Derive("size", blossy.Derivation{
+		Download: func(r blossy.Request, hash blossom.Hash) (blossy.BlobDelivery, *blossom.Error) {
+			t.Error("expected the Download function not to be called")
+			return nil, blossom.ErrInternal("unexpected download")
+		},
+		Check: func(r blossy.Request, hash blossom.Hash) (blossy.MetaDelivery, *blossom.Error) {
+			return blossy.Found("text/x-size", 42), nil
+		},
+	})
+	res = server.Do(t, server.NewRequest(t, http.MethodHead, "/"+desc.Hash.Hex()+".size", nil))
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/x-size" || res.ContentLength != 42 {
+		t.Errorf("expected the metadata of the check, got %d %q %d", res.StatusCode, res.Header.Get("Content-Type"), res.ContentLength)
+	}
+}
+
+func TestMirrorSigner(t *testing.T) {
+	upstream := NewTestServer(t, blossy.WithRequiredAuth(blossy.EndpointDownload))
+	desc, err := upstream.Store.Save(context.Background(), "", blossy.UploadHints{Type: "text/plain", Size: -1}, strings.NewReader("private blob"))
+	if err != nil {
+		t.Fatalf("failed to save the blob: %v", err)
+	}
+
+	u, err := url.Parse(upstream.URL + "/" + desc.Hash.Hex() + ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blossy.MirrorFetch(context.Background(), u, 0); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("expected the unauthorized fetch to fail with 401, got %v", err)
+	}
+
+	server, err := blossy.NewServer(blossy.WithHostname("mirror.example.com"), blossy.WithMirrorSigner(NewSigner(t)))
+	if err != nil {
+		t.Fatalf("failed to create the server: %v", err)
+	}
+	blob, err := server.MirrorFetch(context.Background(), u, 0)
+	if err != nil {
+		t.Fatalf("expected the signed fetch to succeed, got %v", err)
+	}
+	defer blob.Close()
+
+	data, err := io.ReadAll(blob)
+	if err != nil || string(data) != "private blob" {
+		t.Errorf("expected the blob, got %q %v", data, err)
+	}
+}
+
+func TestMirrorType(t *testing.T) {
+	data := []byte("comic book")
+	hash := blossom.ComputeHash(data)
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+	}))
+	defer remote.Close()
+
+	u, err := url.Parse(remote.URL + "/" + hash.Hex() + ".cbz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := blossy.NewServer(
+		blossy.WithHostname("mirror.example.com"),
+		blossy.WithMimeTypes(map[string]string{"cbz": "application/x-comic"}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create the server: %v", err)
+	}
+
+	blob, err := server.MirrorFetch(context.Background(), u, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	blob.Close()
+	if blob.Type != "application/x-comic" {
+		t.Errorf("expected the type of the server MIME table, got %q", blob.Type)
+	}
+
+	blob, err = blossy.MirrorFetch(context.Background(), u, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	blob.Close()
+	if blob.Type != "application/octet-stream" {
+		t.Errorf("expected the generic type without the server MIME table, got %q", blob.Type)
+	}
+}
+
+func TestListPagination(t *testing.T) {
+	server := NewTestServer(t)
+	signer := NewSigner(t)
+	pubkey := Pubkey(t, signer)
+
+	client := server.Client(t, signer)
+	for i, mime := range []string{"image/png", "text/plain", "image/jpeg", "image/gif", "text/plain"} {
+		if _, err := client.Upload(context.Background(), strings.NewReader(fmt.Sprintf("blob %d", i)), mime); err != nil {
+			t.Fatalf("failed to upload: %v", err)
+		}
+	}
+
+	list := func(path string) (*http.Response, []blossom.BlobDescriptor) {
+		t.Helper()
+		res := server.Do(t, server.NewRequest(t, http.MethodGet, path, nil))
+		defer res.Body.Close()
+
+		var descs []blossom.BlobDescriptor
+		if res.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(&descs); err != nil {
+				t.Fatalf("invalid list response: %v", err)
+			}
+		}
+		return res, descs
+	}
+
+	seen := make(map[blossom.Hash]bool)
+	next := "/list/" + pubkey + "?limit=2&type=image/*,text/plain"
+	for pages := 1; ; pages++ {
+		res, descs := list(next)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("page %d: expected 200, got %d", pages, res.StatusCode)
+		}
+		for _, desc := range descs {
+			if seen[desc.Hash] {
+				t.Errorf("page %d: blob %s listed twice", pages, desc.Hash.Hex())
+			}
+			seen[desc.Hash] = true
+		}
+
+		link := res.Header.Get("Link")
+		if link == "" {
+			if pages != 3 || len(descs) != 1 {
+				t.Errorf("expected 3 pages ending with a single blob, got %d pages and %d blobs", pages, len(descs))
+			}
+			break
+		}
+		if !strings.HasSuffix(link, `>; rel="next"`) || !strings.Contains(link, "cursor="+descs[len(descs)-1].Hash.Hex()) {
+			t.Fatalf("unexpected Link header %q", link)
+		}
+		next = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+	}
+	if len(seen) != 5 {
+		t.Errorf("expected all the blobs to be listed, got %d", len(seen))
+	}
+
+	_, descs := list("/list/" + pubkey + "?type=image/*&order=asc")
+	if len(descs) != 3 {
+		t.Fatalf("expected the 3 images, got %d", len(descs))
+	}
+	for _, desc := range descs {
+		if !strings.HasPrefix(desc.Type, "image/") {
+			t.Errorf("expected only images, got %q", desc.Type)
+		}
+	}
+
+	for _, query := range []string{"limit=0", "limit=abc", "cursor=xyz", "order=random", "type=image"} {
+		if res, _ := list("/list/" + pubkey + "?" + query); res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, res.StatusCode)
+		}
+	}
+}
+
+func TestListStats(t *testing.T) {
+	server := NewTestServer(t)
+	pubkey := Pubkey(t, NewSigner(t))
+	path := "/list/" + pubkey + "/stats"
+
+	res := server.Do(t, server.NewRequest(t, http.MethodGet, path, nil))
+	if res.StatusCode != http.StatusNotImplemented {
+		t.Errorf("expected 501 without the Stats hook, got %d", res.StatusCode)
+	}
+
+	server.Blossy.On.Stats = func(r blossy.Request, pk string) (blossy.ListStats, *blossom.Error) {
+		if pk != pubkey {
+			t.Errorf("expected the pubkey %s, got %s", pubkey, pk)
+		}
+		return blossy.ListStats{Blobs: 2, Bytes: 42, LastUpload: 1700000000}, nil
+	}
+
+	res = server.Do(t, server.NewRequest(t, http.MethodGet, path, nil))
+	defer res.Body.Close()
+	var stats blossy.ListStats
+	if err := json.NewDecoder(res.Body).Decode(&stats); err != nil {
+		t.Fatalf("invalid stats response: %v", err)
+	}
+	if stats != (blossy.ListStats{Blobs: 2, Bytes: 42, LastUpload: 1700000000}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	server.Blossy.Reject.List.Append(func(r blossy.Request, pubkey string, query blossy.ListQuery) *blossom.Error {
+		return blossom.ErrForbidden("listing is disabled")
+	})
+	if res := server.Do(t, server.NewRequest(t, http.MethodGet, path, nil)); res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected the Reject hooks of the List endpoint to apply, got %d", res.StatusCode)
+	}
+
+	if res := server.Do(t, server.NewRequest(t, http.MethodGet, "/list/invalid/stats", nil)); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid pubkey, got %d", res.StatusCode)
+	}
+}
+
+// upperEncoder is a test content encoding that upper-cases the data.
+type upperEncoder struct{ w io.Writer }
+
+func (u upperEncoder) Write(p []byte) (int, error) { return u.w.Write(bytes.ToUpper(p)) }
+func (u upperEncoder) Close() error                { return nil }
+
+func TestCompression(t *testing.T) {
+	server := NewTestServer(t,
+		blossy.WithCompression(1024),
+		blossy.WithRangeSupport(),
+		blossy.WithCompressionEncoder("x-upper", func(w io.Writer) (io.WriteCloser, error) { return upperEncoder{w}, nil }),
+	)
+	client := server.Client(t, NewSigner(t))
+
+	data := strings.Repeat("hello compression ", 100)
+	desc, err := client.Upload(context.Background(), strings.NewReader(data), "text/plain")
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	small, err := client.Upload(context.Background(), strings.NewReader("hello small"), "text/plain")
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+
+	get := func(method, path, accept string, header ...string) (*http.Response, []byte) {
+		r := server.NewRequest(t, method, path, nil)
+		r.Header.Set("Accept-Encoding", accept)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		res := server.Do(t, r)
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return res, body
+	}
+
+	t.Run("gzip", func(t *testing.T) {
+		res, body := get(http.MethodGet, "/"+desc.Hash.Hex(), "gzip")
+		if enc := res.Header.Get("Content-Encoding"); enc != "gzip" {
+			t.Fatalf("expected gzip, got %q", enc)
+		}
+		if vary := res.Header.Values("Vary"); !slices.Contains(vary, "Accept-Encoding") {
+			t.Errorf("expected 'Vary: Accept-Encoding', got %v", vary)
+		}
+		if etag := res.Header.Get("ETag"); etag != `W/"`+desc.Hash.Hex()+`"` {
+			t.Errorf("expected a weak ETag, got %q", etag)
+		}
+
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to read the gzip body: %v", err)
+		}
+		if decoded, _ := io.ReadAll(gz); string(decoded) != data {
+			t.Errorf("expected the blob, got %q", decoded)
+		}
+	})
+
+	t.Run("explicit q=0 over wildcard", func(t *testing.T) {
+		res, body := get(http.MethodGet, "/"+desc.Hash.Hex(), "*;q=1, gzip;q=0, x-upper;q=0")
+		if enc := res.Header.Get("Content-Encoding"); enc != "" || string(body) != data {
+			t.Errorf("expected the blob uncompressed, got %q", enc)
+		}
+		if etag := res.Header.Get("ETag"); etag != `"`+desc.Hash.Hex()+`"` {
+			t.Errorf("expected a strong ETag, got %q", etag)
+		}
+	})
+
+	t.Run("custom encoder", func(t *testing.T) {
+		res, body := get(http.MethodGet, "/"+desc.Hash.Hex(), "gzip, x-upper")
+		if enc := res.Header.Get("Content-Encoding"); enc != "x-upper" || string(body) != strings.ToUpper(data) {
+			t.Errorf("expected the custom encoding to be preferred, got %q", enc)
+		}
+
+		res, _ = get(http.MethodGet, "/"+desc.Hash.Hex(), "gzip;q=1, x-upper;q=0.5")
+		if enc := res.Header.Get("Content-Encoding"); enc != "gzip" {
+			t.Errorf("expected the client preference to win, got %q", enc)
+		}
+	})
+
+	t.Run("min size", func(t *testing.T) {
+		res, body := get(http.MethodGet, "/"+small.Hash.Hex(), "gzip")
+		if enc := res.Header.Get("Content-Encoding"); enc != "" || string(body) != "hello small" {
+			t.Errorf("expected the small blob uncompressed, got %q %q", enc, body)
+		}
+	})
+
+	t.Run("HEAD", func(t *testing.T) {
+		res, _ := get(http.MethodHead, "/"+desc.Hash.Hex(), "gzip")
+		if enc := res.Header.Get("Content-Encoding"); enc != "" {
+			t.Errorf("expected HEAD not to be compressed, got %q", enc)
+		}
+		if res.ContentLength != int64(len(data)) {
+			t.Errorf("expected the size of the blob, got %d", res.ContentLength)
+		}
+	})
+
+	t.Run("range", func(t *testing.T) {
+		// range requests need a seekable blob
+		path := filepath.Join(t.TempDir(), "blob")
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		download := server.Blossy.On.Download
+		server.Blossy.On.Download = func(r blossy.Request, hash blossom.Hash, ext string) (blossy.BlobDelivery, *blossom.Error) {
+			file, err := os.Open(path)
+			if err != nil {
+				return nil, blossom.ErrInternal(err.Error())
+			}
+			blob, err := blossy.BlobFromFile(file)
+			if err != nil {
+				return nil, blossom.ErrInternal(err.Error())
+			}
+			return blossy.Serve(blob), nil
+		}
+		defer func() { server.Blossy.On.Download = download }()
+
+		res, body := get(http.MethodGet, "/"+desc.Hash.Hex(), "gzip", "Range", "bytes=0-4")
+		if res.StatusCode != http.StatusPartialContent {
+			t.Fatalf("expected 206, got %d", res.StatusCode)
+		}
+		if enc := res.Header.Get("Content-Encoding"); enc != "" || string(body) != "hello" {
+			t.Errorf("expected the partial content uncompressed, got %q %q", enc, body)
+		}
+	})
+
+	t.Run("already encoded", func(t *testing.T) {
+		download := server.Blossy.On.Download
+		server.Blossy.On.Download = func(r blossy.Request, hash blossom.Hash, ext string) (blossy.BlobDelivery, *blossom.Error) {
+			blossy.ResponseHeader(r).Set("Content-Encoding", "identity")
+			return download(r, hash, ext)
+		}
+		defer func() { server.Blossy.On.Download = download }()
+
+		res, body := get(http.MethodGet, "/"+desc.Hash.Hex(), "gzip")
+		if enc := res.Header.Get("Content-Encoding"); enc != "identity" || string(body) != data {
+			t.Errorf("expected the encoded response not to be compressed again, got %q", enc)
+		}
+	})
+}
@@ -0,0 +1,460 @@
+package blossytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/auth"
+)
+
+// RunConformance checks that the servers returned by newServer comply with the Blossom specification,
+// from BUD-01 to BUD-09, running a subtest for each requirement.
+// A new server is created for every subtest, so that they don't interfere with each other.
+//
+// The servers must accept the authorized uploads of small text blobs from any pubkey, and implement
+// at least the Download, Check and Upload hooks. Subtests for optional endpoints (delete, list, mirror,
+// media and report) are skipped when the server responds with 501 (Not Implemented).
+//
+// Example:
+//
+//	func TestConformance(t *testing.T) {
+//	    blossytest.RunConformance(t, func(t *testing.T) *blossy.Server {
+//	        server, err := blossy.NewServer(blossy.WithHostname("localhost"))
+//	        if err != nil {
+//	            t.Fatal(err)
+//	        }
+//	        blossy.BindStore(server, myStore(t))
+//	        return server
+//	    })
+//	}
+func RunConformance(t *testing.T, newServer func(t *testing.T) *blossy.Server) {
+	tests := []struct {
+		name string
+		run  func(c *conformance)
+	}{
+		{"BUD-01/download", testDownload},
+		{"BUD-01/download with extension", testDownloadExtension},
+		{"BUD-01/missing blob", testMissingBlob},
+		{"BUD-01/invalid hash", testInvalidHash},
+		{"BUD-01/cors", testCORS},
+		{"BUD-02/upload", testUpload},
+		{"BUD-02/upload requires valid authorization", testUploadExpiredAuth},
+		{"BUD-02/delete", testDelete},
+		{"BUD-02/list", testList},
+		{"BUD-04/mirror rejects invalid URLs", testMirrorInvalidURL},
+		{"BUD-05/media", testMedia},
+		{"BUD-06/upload requirements", testUploadCheck},
+		{"BUD-06/upload requirements without hash", testUploadCheckMissingHash},
+		{"BUD-08/nip94 tags", testNIP94},
+		{"BUD-09/report", testReport},
+		{"BUD-09/report rejects other kinds", testReportInvalidKind},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &conformance{
+				t:      t,
+				server: Serve(t, newServer(t)),
+				signer: NewSigner(t),
+			}
+			test.run(c)
+		})
+	}
+}
+
+// conformance holds the state of a conformance subtest.
+type conformance struct {
+	t      *testing.T
+	server *Server
+	signer auth.Signer
+}
+
+var conformanceBlob = []byte("blossom conformance blob")
+
+// request sends the request, authorized for the action and hashes if action is not empty.
+func (c *conformance) request(method, path string, body []byte, header http.Header, action auth.Action, hashes ...blossom.Hash) *http.Response {
+	c.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	r := c.server.NewRequest(c.t, method, path, reader)
+	for key, values := range header {
+		r.Header[key] = values
+	}
+	if action != "" {
+		Authorize(c.t, r, c.signer, action, hashes...)
+	}
+	return c.server.Do(c.t, r)
+}
+
+// upload uploads the blob with PUT /upload, failing the test if it's not accepted.
+func (c *conformance) upload(data []byte) (blossom.BlobDescriptor, map[string]json.RawMessage) {
+	c.t.Helper()
+
+	hash := blossom.ComputeHash(data)
+	header := http.Header{}
+	header.Set("Content-Type", "text/plain")
+	header.Set("Content-Digest", hash.Hex())
+	header.Set("X-SHA-256", hash.Hex())
+
+	res := c.request(http.MethodPut, "/upload", data, header, auth.ActionUpload, hash)
+	c.expectStatus(res, http.StatusOK, http.StatusCreated)
+	return c.decodeDescriptor(res)
+}
+
+func (c *conformance) decodeDescriptor(res *http.Response) (blossom.BlobDescriptor, map[string]json.RawMessage) {
+	c.t.Helper()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		c.t.Fatalf("failed to read the response: %v", err)
+	}
+
+	var desc blossom.BlobDescriptor
+	if err := json.Unmarshal(body, &desc); err != nil {
+		c.t.Fatalf("invalid blob descriptor %s: %v", body, err)
+	}
+
+	var fields map[string]json.RawMessage
+	json.Unmarshal(body, &fields)
+	return desc, fields
+}
+
+// expectStatus fails the test if the status of the response is not one of the expected.
+func (c *conformance) expectStatus(res *http.Response, expected ...int) {
+	c.t.Helper()
+
+	for _, status := range expected {
+		if res.StatusCode == status {
+			return
+		}
+	}
+	c.t.Fatalf("%s %s: expected status %v, got %d (X-Reason: %q)",
+		res.Request.Method, res.Request.URL.Path, expected, res.StatusCode, res.Header.Get("X-Reason"))
+}
+
+// expectReason fails the test if the error response has no 'X-Reason' header.
+func (c *conformance) expectReason(res *http.Response) {
+	c.t.Helper()
+
+	if res.Header.Get("X-Reason") == "" {
+		c.t.Errorf("%s %s: expected the 'X-Reason' header in the %d response", res.Request.Method, res.Request.URL.Path, res.StatusCode)
+	}
+}
+
+// skipUnimplemented skips the test if the response is 501 (Not Implemented).
+func (c *conformance) skipUnimplemented(res *http.Response) {
+	c.t.Helper()
+
+	if res.StatusCode == http.StatusNotImplemented {
+		c.t.Skipf("%s %s is not implemented", res.Request.Method, res.Request.URL.Path)
+	}
+}
+
+func testDownload(c *conformance) {
+	desc, _ := c.upload(conformanceBlob)
+
+	res := c.request(http.MethodGet, "/"+desc.Hash.Hex(), nil, nil, "")
+	c.expectStatus(res, http.StatusOK)
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		c.t.Fatalf("failed to read the blob: %v", err)
+	}
+	if !bytes.Equal(body, conformanceBlob) {
+		c.t.Errorf("expected the blob %q, got %q", conformanceBlob, body)
+	}
+	if res.Header.Get("Content-Type") == "" {
+		c.t.Error("expected the 'Content-Type' header")
+	}
+
+	res = c.request(http.MethodHead, "/"+desc.Hash.Hex(), nil, nil, "")
+	c.expectStatus(res, http.StatusOK)
+	if res.ContentLength != int64(len(conformanceBlob)) {
+		c.t.Errorf("expected 'Content-Length' %d, got %d", len(conformanceBlob), res.ContentLength)
+	}
+}
+
+func testDownloadExtension(c *conformance) {
+	desc, _ := c.upload(conformanceBlob)
+
+	res := c.request(http.MethodGet, "/"+desc.Hash.Hex()+".txt", nil, nil, "")
+	c.expectStatus(res, http.StatusOK)
+
+	res = c.request(http.MethodHead, "/"+desc.Hash.Hex()+".txt", nil, nil, "")
+	c.expectStatus(res, http.StatusOK)
+}
+
+func testMissingBlob(c *conformance) {
+	missing := blossom.ComputeHash([]byte("this blob was never uploaded")).Hex()
+
+	res := c.request(http.MethodGet, "/"+missing, nil, nil, "")
+	c.expectStatus(res, http.StatusNotFound)
+	c.expectReason(res)
+
+	res = c.request(http.MethodHead, "/"+missing, nil, nil, "")
+	c.expectStatus(res, http.StatusNotFound)
+}
+
+func testInvalidHash(c *conformance) {
+	res := c.request(http.MethodGet, "/not-a-hash", nil, nil, "")
+	c.expectStatus(res, http.StatusBadRequest, http.StatusNotFound)
+	c.expectReason(res)
+}
+
+func testCORS(c *conformance) {
+	desc, _ := c.upload(conformanceBlob)
+
+	header := http.Header{}
+	header.Set("Origin", "https://example.com")
+
+	res := c.request(http.MethodGet, "/"+desc.Hash.Hex(), nil, header, "")
+	c.expectStatus(res, http.StatusOK)
+	if res.Header.Get("Access-Control-Allow-Origin") == "" {
+		c.t.Error("expected the 'Access-Control-Allow-Origin' header")
+	}
+
+	header.Set("Access-Control-Request-Method", http.MethodPut)
+	header.Set("Access-Control-Request-Headers", "Authorization, Content-Type")
+
+	res = c.request(http.MethodOptions, "/upload", nil, header, "")
+	c.expectStatus(res, http.StatusOK, http.StatusNoContent)
+	if res.Header.Get("Access-Control-Allow-Methods") == "" {
+		c.t.Error("expected the 'Access-Control-Allow-Methods' header in the preflight response")
+	}
+}
+
+func testUpload(c *conformance) {
+	desc, fields := c.upload(conformanceBlob)
+
+	hash := blossom.ComputeHash(conformanceBlob)
+	if desc.Hash != hash {
+		c.t.Errorf("expected sha256 %s, got %s", hash, desc.Hash)
+	}
+	if desc.Size != int64(len(conformanceBlob)) {
+		c.t.Errorf("expected size %d, got %d", len(conformanceBlob), desc.Size)
+	}
+	if desc.Type == "" {
+		c.t.Error("expected the type of the blob")
+	}
+	if desc.Uploaded <= 0 {
+		c.t.Errorf("expected a positive upload time, got %d", desc.Uploaded)
+	}
+
+	path := desc.URL
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		path = path[i+1:]
+	}
+	if !strings.HasPrefix(path, hash.Hex()) {
+		c.t.Errorf("expected the URL to end with the sha256, got %q", desc.URL)
+	}
+
+	for _, field := range []string{"url", "sha256", "size", "type", "uploaded"} {
+		if _, ok := fields[field]; !ok {
+			c.t.Errorf("expected the %q field in the blob descriptor", field)
+		}
+	}
+}
+
+func testUploadExpiredAuth(c *conformance) {
+	hash := blossom.ComputeHash(conformanceBlob)
+	event := auth.BuildBlossomAuth(auth.ActionUpload, []blossom.Hash{hash}, nil, time.Now().Add(-time.Hour))
+	event.CreatedAt = nostr.Timestamp(time.Now().Add(-2 * time.Hour).Unix())
+
+	if err := c.signer.SignEvent(c.t.Context(), event); err != nil {
+		c.t.Fatalf("failed to sign the event: %v", err)
+	}
+	header, err := auth.Header(event)
+	if err != nil {
+		c.t.Fatalf("failed to encode the event: %v", err)
+	}
+
+	h := http.Header{}
+	h.Set("Authorization", header)
+	h.Set("Content-Type", "text/plain")
+	h.Set("Content-Digest", hash.Hex())
+
+	res := c.request(http.MethodPut, "/upload", conformanceBlob, h, "")
+	c.expectStatus(res, http.StatusUnauthorized)
+	c.expectReason(res)
+}
+
+func testDelete(c *conformance) {
+	desc, _ := c.upload(conformanceBlob)
+
+	res := c.request(http.MethodDelete, "/"+desc.Hash.Hex(), nil, nil, auth.ActionDelete, desc.Hash)
+	c.skipUnimplemented(res)
+	c.expectStatus(res, http.StatusOK, http.StatusNoContent)
+
+	res = c.request(http.MethodGet, "/"+desc.Hash.Hex(), nil, nil, "")
+	c.expectStatus(res, http.StatusNotFound)
+}
+
+func testList(c *conformance) {
+	desc, _ := c.upload(conformanceBlob)
+	pubkey := Pubkey(c.t, c.signer)
+
+	res := c.request(http.MethodGet, "/list/"+pubkey, nil, nil, auth.ActionList)
+	c.skipUnimplemented(res)
+	c.expectStatus(res, http.StatusOK)
+
+	var descs []blossom.BlobDescriptor
+	if err := json.NewDecoder(res.Body).Decode(&descs); err != nil {
+		c.t.Fatalf("invalid list response: %v", err)
+	}
+
+	found := false
+	for _, d := range descs {
+		if d.Hash == desc.Hash {
+			found = true
+		}
+	}
+	if !found {
+		c.t.Errorf("expected the uploaded blob %s in the list, got %v", desc.Hash, descs)
+	}
+
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	res = c.request(http.MethodGet, "/list/"+pubkey+"?since="+future, nil, nil, auth.ActionList)
+	c.expectStatus(res, http.StatusOK)
+
+	descs = nil
+	if err := json.NewDecoder(res.Body).Decode(&descs); err != nil {
+		c.t.Fatalf("invalid list response: %v", err)
+	}
+	if len(descs) != 0 {
+		c.t.Errorf("expected no blobs uploaded in the future, got %v", descs)
+	}
+}
+
+func testMirrorInvalidURL(c *conformance) {
+	hash := blossom.ComputeHash(conformanceBlob)
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+
+	res := c.request(http.MethodPut, "/mirror", []byte(`{"url":"not a url"}`), header, auth.ActionUpload, hash)
+	c.skipUnimplemented(res)
+	c.expectStatus(res, http.StatusBadRequest)
+	c.expectReason(res)
+
+	res = c.request(http.MethodPut, "/mirror", []byte(`{"url":"https://example.com/not-a-hash"}`), header, auth.ActionUpload, hash)
+	c.expectStatus(res, http.StatusBadRequest)
+	c.expectReason(res)
+}
+
+func testMedia(c *conformance) {
+	hash := blossom.ComputeHash(conformanceBlob)
+	header := http.Header{}
+	header.Set("Content-Type", "text/plain")
+	header.Set("Content-Digest", hash.Hex())
+	header.Set("X-SHA-256", hash.Hex())
+
+	res := c.request(http.MethodPut, "/media", conformanceBlob, header, auth.ActionUpload, hash)
+	c.skipUnimplemented(res)
+
+	if res.StatusCode >= 400 {
+		// servers can refuse to optimize text, but they must explain why
+		c.expectReason(res)
+		return
+	}
+
+	c.expectStatus(res, http.StatusOK, http.StatusCreated)
+	desc, _ := c.decodeDescriptor(res)
+	if desc.URL == "" || desc.Size <= 0 {
+		c.t.Errorf("expected a valid blob descriptor, got %+v", desc)
+	}
+}
+
+func testUploadCheck(c *conformance) {
+	hash := blossom.ComputeHash(conformanceBlob)
+	header := http.Header{}
+	header.Set("X-SHA-256", hash.Hex())
+	header.Set("X-Content-Type", "text/plain")
+	header.Set("X-Content-Length", strconv.Itoa(len(conformanceBlob)))
+
+	res := c.request(http.MethodHead, "/upload", nil, header, auth.ActionUpload, hash)
+	c.expectStatus(res, http.StatusOK)
+}
+
+func testUploadCheckMissingHash(c *conformance) {
+	header := http.Header{}
+	header.Set("X-Content-Type", "text/plain")
+	header.Set("X-Content-Length", strconv.Itoa(len(conformanceBlob)))
+
+	res := c.request(http.MethodHead, "/upload", nil, header, auth.ActionUpload)
+	c.expectStatus(res, http.StatusBadRequest)
+	c.expectReason(res)
+}
+
+func testNIP94(c *conformance) {
+	desc, fields := c.upload(conformanceBlob)
+
+	raw, ok := fields["nip94"]
+	if !ok {
+		c.t.Skip("the server doesn't return NIP-94 tags")
+	}
+
+	var tags nostr.Tags
+	if err := json.Unmarshal(raw, &tags); err != nil {
+		c.t.Fatalf("expected the 'nip94' field to be an array of tags: %v", err)
+	}
+
+	expected := map[string]string{"url": desc.URL, "x": desc.Hash.Hex(), "m": desc.Type}
+	for name, value := range expected {
+		tag := tags.Find(name)
+		if tag == nil {
+			c.t.Errorf("expected the %q NIP-94 tag", name)
+			continue
+		}
+		if tag[1] != value {
+			c.t.Errorf("expected the %q NIP-94 tag to be %q, got %q", name, value, tag[1])
+		}
+	}
+}
+
+func testReport(c *conformance) {
+	desc, _ := c.upload(conformanceBlob)
+
+	event := &nostr.Event{
+		Kind:      nostr.KindReporting,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"x", desc.Hash.Hex(), "spam"}},
+		Content:   "conformance report",
+	}
+	if err := c.signer.SignEvent(c.t.Context(), event); err != nil {
+		c.t.Fatalf("failed to sign the report: %v", err)
+	}
+
+	body, _ := json.Marshal(event)
+	res := c.request(http.MethodPut, "/report", body, nil, "")
+	c.skipUnimplemented(res)
+	c.expectStatus(res, http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusNoContent)
+}
+
+func testReportInvalidKind(c *conformance) {
+	event := &nostr.Event{
+		Kind:      nostr.KindTextNote,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"x", blossom.ComputeHash(conformanceBlob).Hex(), "spam"}},
+	}
+	if err := c.signer.SignEvent(c.t.Context(), event); err != nil {
+		c.t.Fatalf("failed to sign the event: %v", err)
+	}
+
+	body, _ := json.Marshal(event)
+	res := c.request(http.MethodPut, "/report", body, nil, "")
+	c.skipUnimplemented(res)
+	c.expectStatus(res, http.StatusBadRequest)
+	c.expectReason(res)
+}
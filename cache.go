@@ -0,0 +1,180 @@
+package blossy
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+// BlobCache caches the blobs served by the Download hook, so that hot blobs are served
+// without reaching the backing store (e.g. S3 or another remote service). See [WithBlobCache].
+// The blossy/cache package provides in-memory and on-disk implementations.
+//
+// Implementations must be safe for concurrent use.
+type BlobCache interface {
+	// Get returns the cached blob with the hash and its delivery options, or false if it's not cached.
+	Get(hash blossom.Hash) (blossom.Blob, []DeliveryOption, bool)
+
+	// Stat returns the type, the size and the delivery options of the cached blob with the hash,
+	// or false if it's not cached.
+	Stat(hash blossom.Hash) (mime string, size int64, opts []DeliveryOption, ok bool)
+
+	// Put caches the blob with the hash, and returns the blob to serve in its place.
+	// The delivery options are the ones the Download hook served the blob with (e.g. [LastModified]),
+	// which must be returned with the cached blob, so that its responses have the same headers.
+	// If the blob is not cached (e.g. it's too large), Put must return it without reading it.
+	// Otherwise Put reads the blob to the end and closes it.
+	Put(hash blossom.Hash, blob blossom.Blob, opts []DeliveryOption) blossom.Blob
+
+	// Delete removes the blob with the hash from the cache, if present.
+	Delete(hash blossom.Hash)
+}
+
+// Evict removes the blob with the hash from the blob cache, if configured (see [WithBlobCache]).
+// Blobs deleted with DELETE /<sha256> are evicted by the server, but those deleted by other means
+// (e.g. by a moderation tool or a retention policy) must be evicted with Evict, or the cache keeps serving them.
+func (s *Server) Evict(hash blossom.Hash) {
+	if cache := s.settings.Sys.cache; cache != nil {
+		cache.Delete(hash)
+	}
+}
+
+// download invokes the Download hook, through the blob cache if configured (see [WithBlobCache]).
+func (s *Server) download(r Request, hash blossom.Hash, ext string) (BlobDelivery, *blossom.Error) {
+	if derivation, ok := s.derivation(ext); ok {
+		return derivation.Download(r, hash)
+	}
+
+	cache := s.settings.Sys.cache
+	if cache == nil {
+		return s.On.Download(r, hash, ext)
+	}
+
+	if blob, opts, ok := cache.Get(hash); ok {
+		s.metrics.ObserveCacheLookup(endpointLabel(EndpointDownload), true)
+		return Serve(blob, opts...), nil
+	}
+	s.metrics.ObserveCacheLookup(endpointLabel(EndpointDownload), false)
+
+	if s.settings.Sys.flights != nil {
+		return s.downloadOnce(r, hash, ext)
+	}
+	return s.fetch(r, hash, ext)
+}
+
+// fetch invokes the Download hook, and caches the blob it serves, if cacheable.
+func (s *Server) fetch(r Request, hash blossom.Hash, ext string) (BlobDelivery, *blossom.Error) {
+	result, err := s.On.Download(r, hash, ext)
+	if err != nil {
+		return nil, err
+	}
+
+	served, ok := result.(servedBlob)
+	if !ok || served.Blob == nil || !served.cacheable() {
+		return result, nil
+	}
+
+	served.Blob = s.settings.Sys.cache.Put(hash, served.Blob, served.delivery.options())
+	return served, nil
+}
+
+// downloadOnce fetches the blob that is not cached, sharing a single invocation of the Download hook among the
+// concurrent requests of the same hash (see [WithSingleflight]). The first request fetches and caches the blob,
+// while the others wait for it and are then served from the cache.
+//
+// Only the cached blob is shared: if the first request failed (e.g. it was cancelled or not authorized upstream)
+// or the blob was not cacheable, the others invoke the hook with their own request.
+func (s *Server) downloadOnce(r Request, hash blossom.Hash, ext string) (BlobDelivery, *blossom.Error) {
+	flights := s.settings.Sys.flights
+	f, leader := flights.join(hash)
+	if leader {
+		defer flights.land(hash, f)
+		return s.fetch(r, hash, ext)
+	}
+
+	select {
+	case <-f.done:
+	case <-r.Context().Done():
+		return nil, WrapError(r.Context().Err())
+	}
+
+	if blob, opts, ok := s.settings.Sys.cache.Get(hash); ok {
+		return Serve(blob, opts...), nil
+	}
+	return s.fetch(r, hash, ext)
+}
+
+// flights are the fetches of the blobs in progress, shared by the concurrent requests of the same hash.
+type flights struct {
+	mu sync.Mutex
+	m  map[blossom.Hash]*flight
+}
+
+// flight is a fetch in progress, whose done channel is closed when it completes.
+type flight struct {
+	done chan struct{}
+}
+
+func newFlights() *flights {
+	return &flights{m: make(map[blossom.Hash]*flight)}
+}
+
+// join returns the flight of the hash, and whether the caller is its leader, which must fetch the blob and land it.
+func (f *flights) join(hash blossom.Hash) (*flight, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if fl, ok := f.m[hash]; ok {
+		return fl, false
+	}
+	fl := &flight{done: make(chan struct{})}
+	f.m[hash] = fl
+	return fl, true
+}
+
+// land completes the flight, waking up the requests waiting for it.
+func (f *flights) land(hash blossom.Hash, fl *flight) {
+	f.mu.Lock()
+	delete(f.m, hash)
+	f.mu.Unlock()
+
+	close(fl.done)
+}
+
+// check invokes the Check hook, through the blob cache if configured (see [WithBlobCache]).
+func (s *Server) check(r Request, hash blossom.Hash, ext string) (MetaDelivery, *blossom.Error) {
+	if derivation, ok := s.derivation(ext); ok {
+		return checkDerived(r, hash, derivation)
+	}
+
+	cache := s.settings.Sys.cache
+	if cache == nil {
+		return s.On.Check(r, hash, ext)
+	}
+
+	if mime, size, opts, ok := cache.Stat(hash); ok {
+		s.metrics.ObserveCacheLookup(endpointLabel(EndpointCheck), true)
+		return Found(mime, size, opts...), nil
+	}
+	s.metrics.ObserveCacheLookup(endpointLabel(EndpointCheck), false)
+	return s.On.Check(r, hash, ext)
+}
+
+// options returns the delivery options that reproduce the delivery, or nil if it has none.
+func (d delivery) options() []DeliveryOption {
+	if d == (delivery{}) {
+		return nil
+	}
+	return []DeliveryOption{func(o *delivery) { *o = d }}
+}
+
+// cacheable returns whether the blob can be cached, which is not the case when
+// the Download hook forbids it with [CacheControl].
+func (d delivery) cacheable() bool {
+	if d.cacheControl == nil {
+		return true
+	}
+	directives := strings.ToLower(*d.cacheControl)
+	return !strings.Contains(directives, "no-store") && !strings.Contains(directives, "private")
+}
@@ -0,0 +1,186 @@
+// Package cache provides [blossy.BlobCache] implementations, to serve hot blobs without reaching
+// the backing store of the server:
+//   - [Memory] keeps blobs in memory, and it's suited for small blobs such as avatars and thumbnails.
+//   - [Disk] keeps blobs on the local filesystem, and it's suited for a remote backing store like S3.
+//
+// Both evict the least recently used blobs when the cache exceeds its maximum size,
+// and can expire blobs after a time to live.
+//
+// Example:
+//
+//	server, err := blossy.NewServer(
+//	    blossy.WithBlobCache(cache.NewMemory(cache.WithMaxSize(512<<20))),
+//	)
+package cache
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+)
+
+type config struct {
+	maxSize     int64
+	maxBlobSize int64
+	ttl         time.Duration
+}
+
+type Option func(*config)
+
+// WithMaxSize sets the maximum size in bytes of all the cached blobs.
+// When it's exceeded, the least recently used blobs are evicted.
+func WithMaxSize(bytes int64) Option {
+	return func(c *config) {
+		c.maxSize = bytes
+	}
+}
+
+// WithMaxBlobSize sets the maximum size in bytes of a cached blob. Larger blobs are never cached.
+func WithMaxBlobSize(bytes int64) Option {
+	return func(c *config) {
+		c.maxBlobSize = bytes
+	}
+}
+
+// WithTTL sets the time to live of the cached blobs, after which they are evicted.
+// Blobs are immutable, so this only bounds how long a blob deleted from the backing store,
+// but not with DELETE /<sha256>, can still be served. If 0, blobs don't expire.
+func WithTTL(d time.Duration) Option {
+	return func(c *config) {
+		c.ttl = d
+	}
+}
+
+func (c config) validate() error {
+	if c.maxSize <= 0 {
+		return errors.New("cache: max size must be positive")
+	}
+	if c.maxBlobSize <= 0 || c.maxBlobSize > c.maxSize {
+		return errors.New("cache: max blob size must be positive and not exceed the max size")
+	}
+	if c.ttl < 0 {
+		return errors.New("cache: ttl must not be negative")
+	}
+	return nil
+}
+
+// entry is a cached blob.
+type entry struct {
+	hash    blossom.Hash
+	mime    string
+	size    int64
+	opts    []blossy.DeliveryOption
+	expires time.Time
+
+	// data is the content of the blob, for the memory cache.
+	data []byte
+}
+
+// lru is a set of entries bounded in total size, which evicts the least recently used ones.
+type lru struct {
+	maxSize int64
+	ttl     time.Duration
+
+	// onEvict is called for the evicted entries, with the lock held.
+	onEvict func(*entry)
+
+	mu    sync.Mutex
+	size  int64
+	order *list.List // of *entry, most recent first
+	items map[blossom.Hash]*list.Element
+}
+
+func newLRU(c config, onEvict func(*entry)) *lru {
+	return &lru{
+		maxSize: c.maxSize,
+		ttl:     c.ttl,
+		onEvict: onEvict,
+		order:   list.New(),
+		items:   make(map[blossom.Hash]*list.Element),
+	}
+}
+
+// get returns the entry with the hash, marking it as recently used.
+func (l *lru) get(hash blossom.Hash) (*entry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	elem, ok := l.items[hash]
+	if !ok {
+		return nil, false
+	}
+
+	e := elem.Value.(*entry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		l.remove(elem)
+		return nil, false
+	}
+
+	l.order.MoveToFront(elem)
+	return e, true
+}
+
+// add adds the entry, replacing the one with the same hash and evicting the least recently used ones if needed.
+func (l *lru) add(e *entry) {
+	if l.ttl > 0 {
+		e.expires = time.Now().Add(l.ttl)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.items[e.hash]; ok {
+		// the content is the same, so it must not be evicted
+		old := l.order.Remove(elem).(*entry)
+		l.size -= old.size
+	}
+
+	l.items[e.hash] = l.order.PushFront(e)
+	l.size += e.size
+
+	for l.size > l.maxSize {
+		l.remove(l.order.Back())
+	}
+}
+
+// delete removes the entry with the hash, if present.
+func (l *lru) delete(hash blossom.Hash) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.items[hash]; ok {
+		l.remove(elem)
+	}
+}
+
+// len returns the number of entries and their total size.
+func (l *lru) len() (int, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.items), l.size
+}
+
+func (l *lru) remove(elem *list.Element) {
+	e := l.order.Remove(elem).(*entry)
+	delete(l.items, e.hash)
+	l.size -= e.size
+	if l.onEvict != nil {
+		l.onEvict(e)
+	}
+}
+
+// failedBlob is returned by Put when reading the blob failed midway, so it can't be served anymore.
+type failedBlob struct {
+	err  error
+	mime string
+	size int64
+}
+
+func (b failedBlob) Read([]byte) (int, error) { return 0, b.err }
+func (b failedBlob) Close() error             { return nil }
+func (b failedBlob) Type() string             { return b.mime }
+func (b failedBlob) Size() int64              { return b.size }
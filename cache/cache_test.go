@@ -0,0 +1,225 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+)
+
+type testBlob struct {
+	*bytes.Reader
+	mime string
+	size int64
+}
+
+func (b *testBlob) Type() string { return b.mime }
+func (b *testBlob) Size() int64  { return b.size }
+func (b *testBlob) Close() error { return nil }
+
+func newBlob(data string) (blossom.Hash, *testBlob) {
+	hash := blossom.Hash(sha256.Sum256([]byte(data)))
+	return hash, &testBlob{Reader: bytes.NewReader([]byte(data)), mime: "text/plain", size: int64(len(data))}
+}
+
+func read(t *testing.T, blob blossom.Blob) string {
+	t.Helper()
+	defer blob.Close()
+
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return string(data)
+}
+
+func newDisk(t *testing.T, opts ...Option) *Disk {
+	t.Helper()
+	d, err := NewDisk(t.TempDir(), opts...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return d
+}
+
+func caches(t *testing.T, opts ...Option) map[string]blossy.BlobCache {
+	return map[string]blossy.BlobCache{
+		"memory": NewMemory(opts...),
+		"disk":   newDisk(t, opts...),
+	}
+}
+
+func TestPutGet(t *testing.T) {
+	for name, cache := range caches(t) {
+		t.Run(name, func(t *testing.T) {
+			hash, blob := newBlob("hello world")
+			if _, _, ok := cache.Get(hash); ok {
+				t.Fatal("expected a miss on an empty cache")
+			}
+
+			opts := []blossy.DeliveryOption{blossy.Attachment("hello.txt")}
+			if data := read(t, cache.Put(hash, blob, opts)); data != "hello world" {
+				t.Fatalf("expected Put to return the blob, got %q", data)
+			}
+
+			cached, cachedOpts, ok := cache.Get(hash)
+			if !ok {
+				t.Fatal("expected a hit after Put")
+			}
+			if len(cachedOpts) != 1 {
+				t.Errorf("expected the delivery options to be cached with the blob, got %d", len(cachedOpts))
+			}
+			if cached.Type() != "text/plain" || cached.Size() != 11 {
+				t.Errorf("expected text/plain of 11 bytes, got %s of %d bytes", cached.Type(), cached.Size())
+			}
+			if data := read(t, cached); data != "hello world" {
+				t.Errorf("expected the cached blob, got %q", data)
+			}
+
+			mime, size, _, ok := cache.Stat(hash)
+			if !ok || mime != "text/plain" || size != 11 {
+				t.Errorf("unexpected stat: %s, %d, %v", mime, size, ok)
+			}
+
+			cache.Delete(hash)
+			if _, _, ok := cache.Get(hash); ok {
+				t.Error("expected a miss after Delete")
+			}
+		})
+	}
+}
+
+func TestPutTooLarge(t *testing.T) {
+	for name, cache := range caches(t, WithMaxBlobSize(5)) {
+		t.Run(name, func(t *testing.T) {
+			hash, blob := newBlob("hello world")
+			returned := cache.Put(hash, blob, nil)
+			if returned != blob || blob.Len() != 11 {
+				t.Fatal("expected the original blob, unread")
+			}
+			if _, _, ok := cache.Get(hash); ok {
+				t.Error("expected the blob not to be cached")
+			}
+		})
+	}
+}
+
+func TestPutSizeMismatch(t *testing.T) {
+	for name, cache := range caches(t) {
+		t.Run(name, func(t *testing.T) {
+			hash, blob := newBlob("hello world")
+			blob.size = 5
+
+			if _, err := io.ReadAll(cache.Put(hash, blob, nil)); err == nil {
+				t.Error("expected an error reading a blob larger than declared")
+			}
+			if _, _, ok := cache.Get(hash); ok {
+				t.Error("expected the blob not to be cached")
+			}
+		})
+	}
+}
+
+func TestEviction(t *testing.T) {
+	for name, cache := range caches(t, WithMaxSize(25), WithMaxBlobSize(10)) {
+		t.Run(name, func(t *testing.T) {
+			h1, b1 := newBlob("aaaaaaaaaa")
+			h2, b2 := newBlob("bbbbbbbbbb")
+			h3, b3 := newBlob("cccccccccc")
+
+			read(t, cache.Put(h1, b1, nil))
+			read(t, cache.Put(h2, b2, nil))
+
+			// h1 becomes the most recently used
+			if _, _, _, ok := cache.Stat(h1); !ok {
+				t.Fatal("expected h1 to be cached")
+			}
+
+			read(t, cache.Put(h3, b3, nil))
+			if _, _, _, ok := cache.Stat(h2); ok {
+				t.Error("expected the least recently used blob to be evicted")
+			}
+			if _, _, _, ok := cache.Stat(h1); !ok {
+				t.Error("expected h1 to be cached")
+			}
+			if _, _, _, ok := cache.Stat(h3); !ok {
+				t.Error("expected h3 to be cached")
+			}
+		})
+	}
+}
+
+func TestTTL(t *testing.T) {
+	for name, cache := range caches(t, WithTTL(10*time.Millisecond)) {
+		t.Run(name, func(t *testing.T) {
+			hash, blob := newBlob("hello world")
+			read(t, cache.Put(hash, blob, nil))
+
+			time.Sleep(20 * time.Millisecond)
+			if _, _, ok := cache.Get(hash); ok {
+				t.Error("expected the blob to expire")
+			}
+		})
+	}
+}
+
+func TestDiskReplace(t *testing.T) {
+	d := newDisk(t)
+	hash, blob := newBlob("hello world")
+	read(t, d.Put(hash, blob, nil))
+
+	_, blob = newBlob("hello world")
+	read(t, d.Put(hash, blob, nil))
+
+	cached, _, ok := d.Get(hash)
+	if !ok {
+		t.Fatal("expected the blob to be cached after being put twice")
+	}
+	if data := read(t, cached); data != "hello world" {
+		t.Errorf("expected the cached blob, got %q", data)
+	}
+	if n, size := d.Len(); n != 1 || size != 11 {
+		t.Errorf("expected 1 blob of 11 bytes, got %d blobs of %d bytes", n, size)
+	}
+}
+
+func TestDiskClean(t *testing.T) {
+	dir := t.TempDir()
+	d, err := NewDisk(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	hash, blob := newBlob("hello world")
+	read(t, d.Put(hash, blob, nil))
+
+	other := filepath.Join(dir, "other.txt")
+	if err := os.WriteFile(other, []byte("keep me"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewDisk(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, hash.Hex())); !os.IsNotExist(err) {
+		t.Error("expected the blob of the previous run to be removed")
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Error("expected other files to be kept")
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	if _, err := NewDisk(t.TempDir(), WithMaxSize(10), WithMaxBlobSize(20)); err == nil {
+		t.Error("expected error for a max blob size larger than the max size, got nil")
+	}
+	if _, err := NewDisk(t.TempDir(), WithTTL(-time.Second)); err == nil {
+		t.Error("expected error for a negative ttl, got nil")
+	}
+}
@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+)
+
+const (
+	// DefaultDiskSize is the maximum size of the [Disk] cache, if not configured.
+	DefaultDiskSize = 10 << 30
+
+	// DefaultDiskBlobSize is the maximum size of a blob in the [Disk] cache, if not configured.
+	DefaultDiskBlobSize = 1 << 30
+)
+
+var _ blossy.BlobCache = (*Disk)(nil)
+
+// Disk is a [blossy.BlobCache] that keeps blobs on the local filesystem. Create one with [NewDisk].
+// The index of the cached blobs is kept in memory, so the cache starts empty.
+// It's safe for concurrent use.
+type Disk struct {
+	dir         string
+	maxBlobSize int64
+	lru         *lru
+}
+
+// NewDisk returns a [Disk] cache in the directory, creating it if it doesn't exist, and removing
+// the blobs cached by a previous run. By default, it holds up to [DefaultDiskSize] bytes,
+// and blobs up to [DefaultDiskBlobSize] bytes.
+func NewDisk(dir string, opts ...Option) (*Disk, error) {
+	c := config{
+		maxSize:     DefaultDiskSize,
+		maxBlobSize: DefaultDiskBlobSize,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("cache: failed to create directory: %w", err)
+	}
+
+	if err := clean(dir); err != nil {
+		return nil, fmt.Errorf("cache: failed to clean directory: %w", err)
+	}
+
+	d := &Disk{
+		dir:         dir,
+		maxBlobSize: c.maxBlobSize,
+	}
+	d.lru = newLRU(c, func(e *entry) { os.Remove(d.path(e.hash)) })
+	return d, nil
+}
+
+// clean removes the blobs and the temporary files of a previous run, leaving other files untouched.
+func clean(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			continue
+		}
+		if _, err := blossom.ParseHash(name); err == nil || strings.HasSuffix(name, ".tmp") {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Disk) path(hash blossom.Hash) string {
+	return filepath.Join(d.dir, hash.Hex())
+}
+
+func (d *Disk) Get(hash blossom.Hash) (blossom.Blob, []blossy.DeliveryOption, bool) {
+	e, ok := d.lru.get(hash)
+	if !ok {
+		return nil, nil, false
+	}
+
+	file, err := os.Open(d.path(hash))
+	if err != nil {
+		d.lru.delete(hash)
+		return nil, nil, false
+	}
+	return fileBlob{File: file, mime: e.mime, size: e.size}, e.opts, true
+}
+
+func (d *Disk) Stat(hash blossom.Hash) (string, int64, []blossy.DeliveryOption, bool) {
+	e, ok := d.lru.get(hash)
+	if !ok {
+		return "", 0, nil, false
+	}
+	return e.mime, e.size, e.opts, true
+}
+
+func (d *Disk) Put(hash blossom.Hash, blob blossom.Blob, opts []blossy.DeliveryOption) blossom.Blob {
+	size := blob.Size()
+	if size < 0 || size > d.maxBlobSize {
+		return blob
+	}
+	defer blob.Close()
+
+	mime := blob.Type()
+	file, err := d.write(hash, blob, size)
+	if err != nil {
+		return failedBlob{err: err, mime: mime, size: size}
+	}
+
+	d.lru.add(&entry{hash: hash, mime: mime, size: size, opts: opts})
+	return fileBlob{File: file, mime: mime, size: size}
+}
+
+// write writes the blob to its path, and returns the file opened for reading.
+func (d *Disk) write(hash blossom.Hash, blob io.Reader, size int64) (*os.File, error) {
+	tmp, err := os.CreateTemp(d.dir, "*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("cache: failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	n, err := io.Copy(tmp, io.LimitReader(blob, size+1))
+	if err != nil {
+		return nil, fmt.Errorf("cache: failed to write blob: %w", err)
+	}
+	if n != size {
+		return nil, fmt.Errorf("cache: blob size %d doesn't match its declared size of %d bytes", n, size)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("cache: failed to write blob: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), d.path(hash)); err != nil {
+		return nil, fmt.Errorf("cache: failed to write blob: %w", err)
+	}
+	return os.Open(d.path(hash))
+}
+
+func (d *Disk) Delete(hash blossom.Hash) {
+	d.lru.delete(hash)
+}
+
+// Len returns the number of cached blobs and their total size in bytes.
+func (d *Disk) Len() (int, int64) {
+	return d.lru.len()
+}
+
+// fileBlob is a cached blob, which is seekable to support range requests.
+type fileBlob struct {
+	*os.File
+	mime string
+	size int64
+}
+
+func (b fileBlob) Type() string { return b.mime }
+func (b fileBlob) Size() int64  { return b.size }
@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+)
+
+const (
+	// DefaultMemorySize is the maximum size of the [Memory] cache, if not configured.
+	DefaultMemorySize = 256 << 20
+
+	// DefaultMemoryBlobSize is the maximum size of a blob in the [Memory] cache, if not configured.
+	DefaultMemoryBlobSize = 8 << 20
+)
+
+var _ blossy.BlobCache = (*Memory)(nil)
+
+// Memory is a [blossy.BlobCache] that keeps blobs in memory. Create one with [NewMemory].
+// It's safe for concurrent use.
+type Memory struct {
+	maxBlobSize int64
+	lru         *lru
+}
+
+// NewMemory returns a [Memory] cache. By default, it holds up to [DefaultMemorySize] bytes,
+// and blobs up to [DefaultMemoryBlobSize] bytes. It panics if the options are invalid.
+func NewMemory(opts ...Option) *Memory {
+	c := config{
+		maxSize:     DefaultMemorySize,
+		maxBlobSize: DefaultMemoryBlobSize,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	if err := c.validate(); err != nil {
+		panic(err)
+	}
+
+	return &Memory{
+		maxBlobSize: c.maxBlobSize,
+		lru:         newLRU(c, nil),
+	}
+}
+
+func (m *Memory) Get(hash blossom.Hash) (blossom.Blob, []blossy.DeliveryOption, bool) {
+	e, ok := m.lru.get(hash)
+	if !ok {
+		return nil, nil, false
+	}
+	return memoryBlob{Reader: bytes.NewReader(e.data), mime: e.mime}, e.opts, true
+}
+
+func (m *Memory) Stat(hash blossom.Hash) (string, int64, []blossy.DeliveryOption, bool) {
+	e, ok := m.lru.get(hash)
+	if !ok {
+		return "", 0, nil, false
+	}
+	return e.mime, e.size, e.opts, true
+}
+
+func (m *Memory) Put(hash blossom.Hash, blob blossom.Blob, opts []blossy.DeliveryOption) blossom.Blob {
+	size := blob.Size()
+	if size < 0 || size > m.maxBlobSize {
+		return blob
+	}
+	defer blob.Close()
+
+	data, err := io.ReadAll(io.LimitReader(blob, size+1))
+	if err == nil && int64(len(data)) > size {
+		err = fmt.Errorf("cache: blob is larger than its declared size of %d bytes", size)
+	}
+	if err != nil {
+		return failedBlob{err: err, mime: blob.Type(), size: size}
+	}
+
+	e := &entry{hash: hash, mime: blob.Type(), size: int64(len(data)), opts: opts, data: data}
+	m.lru.add(e)
+	return memoryBlob{Reader: bytes.NewReader(data), mime: e.mime}
+}
+
+func (m *Memory) Delete(hash blossom.Hash) {
+	m.lru.delete(hash)
+}
+
+// Len returns the number of cached blobs and their total size in bytes.
+func (m *Memory) Len() (int, int64) {
+	return m.lru.len()
+}
+
+// memoryBlob is a cached blob, which is seekable to support range requests.
+type memoryBlob struct {
+	*bytes.Reader
+	mime string
+}
+
+func (b memoryBlob) Type() string { return b.mime }
+func (b memoryBlob) Close() error { return nil }
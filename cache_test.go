@@ -0,0 +1,105 @@
+package blossy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+// mapCache is a minimal [BlobCache] that keeps the blobs in memory.
+type mapCache struct {
+	mu    sync.Mutex
+	blobs map[blossom.Hash][]byte
+}
+
+func (c *mapCache) Get(hash blossom.Hash) (blossom.Blob, []DeliveryOption, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.blobs[hash]
+	if !ok {
+		return nil, nil, false
+	}
+	return blossom.BlobFromBytes(data), nil, true
+}
+
+func (c *mapCache) Stat(hash blossom.Hash) (string, int64, []DeliveryOption, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.blobs[hash]
+	return "application/octet-stream", int64(len(data)), nil, ok
+}
+
+func (c *mapCache) Put(hash blossom.Hash, blob blossom.Blob, opts []DeliveryOption) blossom.Blob {
+	defer blob.Close()
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		return blossom.BlobFromBytes(nil)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blobs[hash] = data
+	return blossom.BlobFromBytes(data)
+}
+
+func (c *mapCache) Delete(hash blossom.Hash) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.blobs, hash)
+}
+
+func TestEvict(t *testing.T) {
+	data := []byte("hello evict")
+	hash := blossom.ComputeHash(data)
+
+	tests := []struct {
+		name   string
+		cache  bool
+		evict  bool
+		status int // of the download after the blob is deleted from the storage
+	}{
+		{"no cache", false, false, http.StatusNotFound},
+		{"no cache evicted", false, true, http.StatusNotFound},
+		{"cached", true, false, http.StatusOK},
+		{"cached evicted", true, true, http.StatusNotFound},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			var opts []Option
+			if test.cache {
+				opts = append(opts, WithBlobCache(&mapCache{blobs: make(map[blossom.Hash][]byte)}))
+			}
+			server, ts := newTestServer(t, opts...)
+
+			var deleted atomic.Bool
+			server.On.Download = func(r Request, h blossom.Hash, ext string) (BlobDelivery, *blossom.Error) {
+				if deleted.Load() {
+					return nil, blossom.ErrNotFound("not found")
+				}
+				return Serve(blossom.BlobFromBytes(data)), nil
+			}
+
+			r, _ := http.NewRequest(http.MethodGet, ts.URL+"/"+hash.Hex(), nil)
+			if res := do(t, r); res.StatusCode != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+			}
+
+			// the blob is deleted without going through DELETE /<sha256>
+			deleted.Store(true)
+			if test.evict {
+				server.Evict(hash)
+			}
+
+			r, _ = http.NewRequest(http.MethodGet, ts.URL+"/"+hash.Hex(), nil)
+			if res := do(t, r); res.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d", test.status, res.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,569 @@
+// Package client implements a client for blossom servers, handling the authorization events,
+// the retries of failed requests and the parsing of blob descriptors.
+//
+// Example:
+//
+//	signer, err := auth.NewKeySigner(sk)
+//	if err != nil {
+//	    panic(err)
+//	}
+//
+//	c, err := client.New("https://cdn.example.com", client.WithSigner(signer))
+//	if err != nil {
+//	    panic(err)
+//	}
+//
+//	file, err := os.Open("cat.jpg")
+//	if err != nil {
+//	    panic(err)
+//	}
+//	defer file.Close()
+//
+//	desc, err := c.Upload(ctx, file, "image/jpeg")
+//	...
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/auth"
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+// ErrNoSigner is returned by [Client.Delete] and [Client.Report] when the client has no signer,
+// as deletions always require authorization and reports must be signed.
+var ErrNoSigner = errors.New("client: the request requires a signer")
+
+// Client talks to a blossom server. Create one with [New].
+// It's safe for concurrent use.
+type Client struct {
+	server *url.URL
+	signer auth.Signer
+	http   *http.Client
+
+	retries    int
+	backoff    time.Duration
+	expiration time.Duration
+}
+
+type Option func(*Client)
+
+// WithSigner sets the signer of the authorization events (kind 24242), such as an [auth.KeySigner]
+// or an [auth.RemoteSigner].
+// Without a signer, only the requests that don't require authorization succeed.
+func WithSigner(s auth.Signer) Option {
+	return func(c *Client) {
+		c.signer = s
+	}
+}
+
+// WithHTTPClient sets the http client used to reach the server.
+// By default, it's a client without timeouts, as blob transfers can be long:
+// bound them with the context of the calls instead.
+func WithHTTPClient(h *http.Client) Option {
+	return func(c *Client) {
+		c.http = h
+	}
+}
+
+// WithRetries sets how many times a failed request is retried, and the delay before the first retry,
+// which doubles after every attempt. Requests are retried on network errors and on 429, 502, 503 and 504 responses,
+// honoring the 'Retry-After' header of the server. By default, requests are retried twice, starting after 500ms.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = n
+		c.backoff = backoff
+	}
+}
+
+// WithAuthExpiration sets for how long the authorization events are valid. By default, it's 5 minutes.
+func WithAuthExpiration(d time.Duration) Option {
+	return func(c *Client) {
+		c.expiration = d
+	}
+}
+
+// New returns a [Client] for the blossom server at the provided URL (e.g. "https://cdn.example.com").
+func New(server string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(server, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("client: invalid server %q: %w", server, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("client: invalid server %q: must be an http or https URL", server)
+	}
+
+	c := &Client{
+		server:     u,
+		http:       &http.Client{},
+		retries:    2,
+		backoff:    500 * time.Millisecond,
+		expiration: 5 * time.Minute,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.http == nil {
+		return nil, errors.New("client: http client must not be nil")
+	}
+	if c.retries < 0 || c.backoff < 0 {
+		return nil, errors.New("client: retries and backoff must not be negative")
+	}
+	if c.expiration <= 0 {
+		return nil, errors.New("client: auth expiration must be positive")
+	}
+	return c, nil
+}
+
+// Server returns the URL of the blossom server.
+func (c *Client) Server() string {
+	return c.server.String()
+}
+
+// Upload uploads the blob with PUT /upload (BUD-02). The body is read twice from the start,
+// first to compute its hash, and then to send it.
+func (c *Client) Upload(ctx context.Context, body io.ReadSeeker, contentType string) (blossom.BlobDescriptor, error) {
+	return c.upload(ctx, "/upload", body, contentType)
+}
+
+// UploadMedia uploads the blob with PUT /media (BUD-05), so that the server optimizes it.
+// The descriptor returned describes the optimized blob, which usually has a different hash.
+func (c *Client) UploadMedia(ctx context.Context, body io.ReadSeeker, contentType string) (blossom.BlobDescriptor, error) {
+	return c.upload(ctx, "/media", body, contentType)
+}
+
+func (c *Client) upload(ctx context.Context, path string, body io.ReadSeeker, contentType string) (blossom.BlobDescriptor, error) {
+	hash, size, err := hashOf(body)
+	if err != nil {
+		return blossom.BlobDescriptor{}, fmt.Errorf("client: failed to hash the blob: %w", err)
+	}
+
+	header, err := c.authorize(ctx, auth.ActionUpload, &hash)
+	if err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+
+	res, err := c.do(ctx, func() (*http.Request, error) {
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		req, err := c.newRequest(ctx, http.MethodPut, path, io.NopCloser(body))
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = size
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		req.Header.Set("Content-Digest", hash.Hex())
+		req.Header.Set("X-SHA-256", hash.Hex())
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+	return decodeDescriptor(res)
+}
+
+// Mirror asks the server to mirror the blob at the URL with PUT /mirror (BUD-04).
+// The URL must end with the sha256 of the blob, optionally followed by an extension.
+func (c *Client) Mirror(ctx context.Context, blobURL string) (blossom.BlobDescriptor, error) {
+	u, err := url.Parse(blobURL)
+	if err != nil {
+		return blossom.BlobDescriptor{}, fmt.Errorf("client: invalid blob URL: %w", err)
+	}
+	hash, _, err := utils.ParseHashExt(u.Path)
+	if err != nil {
+		return blossom.BlobDescriptor{}, fmt.Errorf("client: invalid blob URL: %w", err)
+	}
+
+	payload, err := json.Marshal(map[string]string{"url": blobURL})
+	if err != nil {
+		return blossom.BlobDescriptor{}, fmt.Errorf("client: %w", err)
+	}
+
+	header, err := c.authorize(ctx, auth.ActionUpload, &hash)
+	if err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+
+	res, err := c.do(ctx, func() (*http.Request, error) {
+		req, err := c.newRequest(ctx, http.MethodPut, "/mirror", bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+	return decodeDescriptor(res)
+}
+
+// Get downloads the blob with GET /<sha256> (BUD-01). Reading the blob verifies its hash:
+// reading the end returns [utils.ErrHashMismatch] instead of [io.EOF] if the hash doesn't match.
+// The blob must be closed after use.
+//
+// The request is authorized only if the server requires it.
+func (c *Client) Get(ctx context.Context, hash blossom.Hash) (blossom.Blob, error) {
+	res, err := c.fetch(ctx, http.MethodGet, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	body := &verifiedBody{
+		Reader: utils.NewHashReader(res.Body, &hash),
+		Closer: res.Body,
+	}
+	return blossom.BlobFromStream(body, res.ContentLength, res.Header.Get("Content-Type")), nil
+}
+
+// Head returns the descriptor of the blob with HEAD /<sha256> (BUD-01), without downloading it.
+// The upload time of the descriptor is unknown, and left to zero.
+//
+// The request is authorized only if the server requires it.
+func (c *Client) Head(ctx context.Context, hash blossom.Hash) (blossom.BlobDescriptor, error) {
+	res, err := c.fetch(ctx, http.MethodHead, hash)
+	if err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+	res.Body.Close()
+
+	return blossom.BlobDescriptor{
+		URL:  c.server.JoinPath(hash.Hex()).String(),
+		Hash: hash,
+		Size: res.ContentLength,
+		Type: res.Header.Get("Content-Type"),
+	}, nil
+}
+
+// fetch sends a GET or HEAD request for the blob. If the server responds with 401 (Unauthorized)
+// and the client has a signer, the request is repeated with an authorization event.
+func (c *Client) fetch(ctx context.Context, method string, hash blossom.Hash) (*http.Response, error) {
+	send := func(header string) (*http.Response, error) {
+		return c.do(ctx, func() (*http.Request, error) {
+			req, err := c.newRequest(ctx, method, "/"+hash.Hex(), nil)
+			if err != nil {
+				return nil, err
+			}
+			if header != "" {
+				req.Header.Set("Authorization", header)
+			}
+			return req, nil
+		})
+	}
+
+	res, err := send("")
+	var berr *blossom.Error
+	if c.signer == nil || !errors.As(err, &berr) || berr.Code != http.StatusUnauthorized {
+		return res, err
+	}
+
+	header, err := c.authorize(ctx, auth.ActionGet, &hash)
+	if err != nil {
+		return nil, err
+	}
+	return send(header)
+}
+
+// Delete deletes the blob with DELETE /<sha256> (BUD-02).
+func (c *Client) Delete(ctx context.Context, hash blossom.Hash) error {
+	if c.signer == nil {
+		return ErrNoSigner
+	}
+
+	header, err := c.authorize(ctx, auth.ActionDelete, &hash)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.do(ctx, func() (*http.Request, error) {
+		req, err := c.newRequest(ctx, http.MethodDelete, "/"+hash.Hex(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", header)
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// Report reports the blobs to the server with PUT /report (BUD-09), signing a NIP-56 report (kind 1984)
+// with the reason (e.g. "illegal", "spam" or "malware") and the optional content.
+func (c *Client) Report(ctx context.Context, reason, content string, hashes ...blossom.Hash) error {
+	if c.signer == nil {
+		return ErrNoSigner
+	}
+	if len(hashes) == 0 {
+		return errors.New("client: the report must contain at least one blob")
+	}
+
+	event := &nostr.Event{
+		Kind:      nostr.KindReporting,
+		CreatedAt: nostr.Now(),
+		Content:   content,
+	}
+	for _, hash := range hashes {
+		event.Tags = append(event.Tags, nostr.Tag{"x", hash.Hex(), reason})
+	}
+
+	if err := c.signer.SignEvent(ctx, event); err != nil {
+		return fmt.Errorf("client: failed to sign the report: %w", err)
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("client: %w", err)
+	}
+
+	res, err := c.do(ctx, func() (*http.Request, error) {
+		req, err := c.newRequest(ctx, http.MethodPut, "/report", bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// List returns the blobs uploaded by the pubkey with GET /list/<pubkey> (BUD-02),
+// filtered and paginated by the query. To fetch the next page, set the cursor of the query to the hash of the last blob.
+func (c *Client) List(ctx context.Context, pubkey string, query blossy.ListQuery) ([]blossom.BlobDescriptor, error) {
+	params := query.Params()
+	path := "/list/" + pubkey
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+
+	header, err := c.authorize(ctx, auth.ActionList, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.do(ctx, func() (*http.Request, error) {
+		req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var descs []blossom.BlobDescriptor
+	if err := json.NewDecoder(res.Body).Decode(&descs); err != nil {
+		return nil, fmt.Errorf("client: invalid list response: %w", err)
+	}
+	return descs, nil
+}
+
+// Stats returns the number, the total size and the last upload time of the blobs of the pubkey,
+// with GET /list/<pubkey>/stats.
+func (c *Client) Stats(ctx context.Context, pubkey string) (blossy.ListStats, error) {
+	header, err := c.authorize(ctx, auth.ActionList, nil)
+	if err != nil {
+		return blossy.ListStats{}, err
+	}
+
+	res, err := c.do(ctx, func() (*http.Request, error) {
+		req, err := c.newRequest(ctx, http.MethodGet, "/list/"+pubkey+"/stats", nil)
+		if err != nil {
+			return nil, err
+		}
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return blossy.ListStats{}, err
+	}
+	defer res.Body.Close()
+
+	var stats blossy.ListStats
+	if err := json.NewDecoder(res.Body).Decode(&stats); err != nil {
+		return blossy.ListStats{}, fmt.Errorf("client: invalid stats response: %w", err)
+	}
+	return stats, nil
+}
+
+// authorize returns the value of the 'Authorization' header for the action, signed by the signer.
+// It returns an empty string if the client has no signer.
+func (c *Client) authorize(ctx context.Context, action auth.Action, hash *blossom.Hash) (string, error) {
+	if c.signer == nil {
+		return "", nil
+	}
+
+	var hashes []blossom.Hash
+	if hash != nil {
+		hashes = []blossom.Hash{*hash}
+	}
+
+	expiration := time.Now().Add(c.expiration)
+	header, err := auth.SignBlossomAuth(ctx, c.signer, action, hashes, []string{c.server.Hostname()}, expiration)
+	if err != nil {
+		return "", fmt.Errorf("client: %w", err)
+	}
+	return header, nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, method, c.server.String()+path, body)
+}
+
+// do sends the requests built by newRequest, retrying them according to [WithRetries].
+// It returns the response if its status is 2xx, or a [blossom.Error] with the status and reason of the server.
+func (c *Client) do(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	delay := c.backoff
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, fmt.Errorf("client: %w", err)
+		}
+
+		res, err := c.http.Do(req)
+		if err == nil && res.StatusCode >= 200 && res.StatusCode < 300 {
+			return res, nil
+		}
+
+		if err != nil {
+			err = fmt.Errorf("client: %w", err)
+		} else {
+			err = responseError(res)
+			res.Body.Close()
+		}
+
+		if attempt >= c.retries || !retryable(ctx, res) {
+			return nil, err
+		}
+
+		wait := delay
+		if after := retryAfter(res); after > 0 {
+			wait = after
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// retryable returns whether the request that got the response should be retried.
+// A nil response stands for a network error.
+func retryable(ctx context.Context, res *http.Response) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if res == nil {
+		return true
+	}
+
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryAfter returns the delay requested by the 'Retry-After' header of the response, if any.
+func retryAfter(res *http.Response) time.Duration {
+	if res == nil {
+		return 0
+	}
+
+	value := res.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// responseError converts the error response of the server into a [blossom.Error],
+// keeping its status code and reason.
+func responseError(res *http.Response) error {
+	reason := res.Header.Get("X-Reason")
+	if reason == "" {
+		reason = "server responded with " + res.Status
+	}
+	return fmt.Errorf("client: %w", &blossom.Error{Code: res.StatusCode, Reason: reason})
+}
+
+func decodeDescriptor(res *http.Response) (blossom.BlobDescriptor, error) {
+	defer res.Body.Close()
+
+	var desc blossom.BlobDescriptor
+	if err := json.NewDecoder(res.Body).Decode(&desc); err != nil {
+		return blossom.BlobDescriptor{}, fmt.Errorf("client: invalid blob descriptor: %w", err)
+	}
+	return desc, nil
+}
+
+// hashOf returns the sha256 hash and the size of the body, and seeks it back to the start.
+func hashOf(body io.ReadSeeker) (blossom.Hash, int64, error) {
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return blossom.Hash{}, 0, err
+	}
+
+	hasher := blossom.NewHasher()
+	size, err := io.Copy(hasher, body)
+	if err != nil {
+		return blossom.Hash{}, 0, err
+	}
+
+	var hash blossom.Hash
+	copy(hash[:], hasher.Sum(nil))
+	return hash, size, nil
+}
+
+// verifiedBody is a response body whose hash is verified while reading it.
+type verifiedBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,225 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/auth"
+	"github.com/pippellia-btc/blossy/stores/disk"
+)
+
+// newServer returns the URL of a blossy server storing blobs on disk.
+// Downloads are rejected without authorization if private is true.
+func newServer(t *testing.T, private bool) string {
+	store, err := disk.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create the store: %v", err)
+	}
+
+	server, err := blossy.NewServer(blossy.WithHostname("localhost"))
+	if err != nil {
+		t.Fatalf("failed to create the server: %v", err)
+	}
+	blossy.BindStore(server, store)
+
+	if private {
+		server.Reject.Download.Append(func(r blossy.Request, hash blossom.Hash, ext string) *blossom.Error {
+			if !r.IsAuthed() {
+				return blossom.ErrUnauthorized("authorization is required")
+			}
+			return nil
+		})
+	}
+
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+	return strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+}
+
+func newSigner(t *testing.T) auth.Signer {
+	signer, err := auth.NewKeySigner(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatalf("failed to create the signer: %v", err)
+	}
+	return signer
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New("cdn.example.com"); err == nil {
+		t.Error("expected error for a URL without scheme, got nil")
+	}
+	if _, err := New("https://cdn.example.com", WithRetries(-1, 0)); err == nil {
+		t.Error("expected error for negative retries, got nil")
+	}
+
+	c, err := New("https://cdn.example.com/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Server() != "https://cdn.example.com" {
+		t.Errorf("expected the trailing slash to be trimmed, got %s", c.Server())
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	signer := newSigner(t)
+	pubkey, _ := signer.GetPublicKey(ctx)
+
+	c, err := New(newServer(t, false), WithSigner(signer))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data := []byte("hello world")
+	desc, err := c.Upload(ctx, bytes.NewReader(data), "text/plain")
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	if desc.Hash != blossom.ComputeHash(data) || desc.Size != int64(len(data)) {
+		t.Fatalf("unexpected descriptor: %+v", desc)
+	}
+
+	head, err := c.Head(ctx, desc.Hash)
+	if err != nil {
+		t.Fatalf("failed to head: %v", err)
+	}
+	if head.Size != desc.Size || head.Type != "text/plain" {
+		t.Errorf("unexpected head descriptor: %+v", head)
+	}
+
+	blob, err := c.Get(ctx, desc.Hash)
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	got, err := io.ReadAll(blob)
+	blob.Close()
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("expected %q, got %q (%v)", data, got, err)
+	}
+
+	descs, err := c.List(ctx, pubkey, blossy.ListQuery{})
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	if len(descs) != 1 || descs[0].Hash != desc.Hash {
+		t.Errorf("expected the uploaded blob, got %+v", descs)
+	}
+
+	if err := c.Delete(ctx, desc.Hash); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+
+	var berr *blossom.Error
+	_, err = c.Head(ctx, desc.Hash)
+	if !errors.As(err, &berr) || berr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 after the deletion, got %v", err)
+	}
+}
+
+func TestGetAuthorization(t *testing.T) {
+	ctx := context.Background()
+	url := newServer(t, true)
+
+	c, _ := New(url, WithSigner(newSigner(t)))
+	desc, err := c.Upload(ctx, bytes.NewReader([]byte("secret")), "text/plain")
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+
+	blob, err := c.Get(ctx, desc.Hash)
+	if err != nil {
+		t.Fatalf("expected the request to be authorized after the 401, got %v", err)
+	}
+	blob.Close()
+
+	anonymous, _ := New(url)
+	var berr *blossom.Error
+	if _, err := anonymous.Get(ctx, desc.Hash); !errors.As(err, &berr) || berr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a signer, got %v", err)
+	}
+	if err := anonymous.Delete(ctx, desc.Hash); !errors.Is(err, ErrNoSigner) {
+		t.Errorf("expected ErrNoSigner, got %v", err)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var attempts atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			w.Header().Set("X-Reason", "try again")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", "5")
+	}))
+	t.Cleanup(ts.Close)
+
+	c, _ := New(ts.URL, WithRetries(2, time.Millisecond))
+	if _, err := c.Head(context.Background(), blossom.Hash{}); err != nil {
+		t.Fatalf("expected the third attempt to succeed, got %v", err)
+	}
+
+	attempts.Store(0)
+	c, _ = New(ts.URL, WithRetries(1, time.Millisecond))
+	var berr *blossom.Error
+	if _, err := c.Head(context.Background(), blossom.Hash{}); !errors.As(err, &berr) || berr.Reason != "try again" {
+		t.Fatalf("expected the error of the server, got %v", err)
+	}
+}
+
+func TestReport(t *testing.T) {
+	ctx := context.Background()
+	hash := blossom.ComputeHash([]byte("spam"))
+
+	var received blossy.Report
+	server, err := blossy.NewServer(blossy.WithHostname("localhost"))
+	if err != nil {
+		t.Fatalf("failed to create the server: %v", err)
+	}
+	server.On.Report = func(r blossy.Request, report blossy.Report) *blossom.Error {
+		received = report
+		return nil
+	}
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	c, err := New(ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Report(ctx, "spam", "", hash); !errors.Is(err, ErrNoSigner) {
+		t.Errorf("expected ErrNoSigner without a signer, got %v", err)
+	}
+
+	signer := newSigner(t)
+	c, err = New(ts.URL, WithSigner(signer))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Report(ctx, "spam", "buy now"); err == nil {
+		t.Error("expected an error for a report without blobs, got nil")
+	}
+
+	if err := c.Report(ctx, "spam", "buy now", hash); err != nil {
+		t.Fatalf("failed to report: %v", err)
+	}
+
+	pubkey, _ := signer.GetPublicKey(ctx)
+	if received.Pubkey != pubkey || len(received.Blobs) != 1 || received.Blobs[0] != (blossy.ReportedBlob{Hash: hash, Reason: "spam"}) {
+		t.Errorf("unexpected report: %+v", received)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/metrics"
+	"github.com/pippellia-btc/blossy/reports"
+)
+
+// adminAPI serves the operations reserved to the operators of the server. It has no authentication,
+// so it must listen on a private address:
+//
+//	GET  /metrics         the metrics in the Prometheus text format
+//	POST /reload          reloads the configuration, like SIGHUP
+//	GET  /reports         the blobs with open reports, the most reported first (limit=100 by default)
+//	POST /reports/<hash>  resolves the reports of the blob (status=removed|dismissed, note=...)
+//
+// Removed blobs are quarantined until the process restarts: they are not served, but they are not deleted.
+type adminAPI struct {
+	server   *blossy.Server
+	metrics  *metrics.Metrics
+	reports  reports.Store
+	takedown *reports.Takedown
+	log      *slog.Logger
+}
+
+// serve serves the admin API at the address, until the context is cancelled.
+func (a *adminAPI) serve(ctx context.Context, address string) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", a.metrics)
+	mux.HandleFunc("POST /reload", a.reload)
+	mux.HandleFunc("GET /reports", a.queue)
+	mux.HandleFunc("POST /reports/{hash}", a.resolve)
+
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	a.log.Info("serving the admin API", "address", address)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		a.log.Error("admin API stopped", "error", err)
+	}
+}
+
+func (a *adminAPI) reload(w http.ResponseWriter, r *http.Request) {
+	if err := a.server.Reload(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *adminAPI) queue(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	queue, err := a.reports.Queue(r.Context(), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queue)
+}
+
+func (a *adminAPI) resolve(w http.ResponseWriter, r *http.Request) {
+	hash, err := blossom.ParseHash(r.PathValue("hash"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := reports.Status(r.FormValue("status"))
+	if !status.Valid() || status == reports.StatusOpen {
+		http.Error(w, "status must be removed or dismissed", http.StatusBadRequest)
+		return
+	}
+
+	n, err := a.reports.Resolve(r.Context(), hash, status, r.FormValue("note"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if status == reports.StatusRemoved {
+		a.takedown.Quarantine(hash)
+	} else {
+		a.takedown.Release(hash)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"resolved": n})
+}
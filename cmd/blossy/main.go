@@ -0,0 +1,115 @@
+// Command blossy runs a blossom server, configured with a YAML file and environment variables
+// (see the blossy/config package), which stores the blobs on the local filesystem by default.
+//
+// Usage:
+//
+//	blossy [flags]
+//
+// The flags are:
+//
+//	-config path
+//	    the YAML (or JSON) configuration file. If empty, only the BLOSSY_* environment variables are used.
+//	-addr address
+//	    the address to listen on (or "unix:/path" for a unix socket), which overrides the one of the configuration.
+//	-dir path
+//	    the directory of the blobs, if the configuration doesn't set a storage backend (default ".blossom").
+//	-admin address
+//	    the private address of the admin API, serving the metrics, the reports and the reload (default "localhost:3336").
+//	    If empty, the admin API is disabled.
+//	-admins pubkeys
+//	    the comma separated hex pubkeys allowed to subscribe to the event stream at /events.
+//	-log-level level
+//	    one of debug, info, warn or error (default "info").
+//
+// The server stops gracefully on SIGINT and SIGTERM, and reloads the configuration on SIGHUP.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/config"
+	"github.com/pippellia-btc/blossy/metrics"
+	"github.com/pippellia-btc/blossy/reports"
+)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := run(ctx, os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "blossy:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("blossy", flag.ContinueOnError)
+	path := flags.String("config", "", "the YAML (or JSON) configuration file")
+	addr := flags.String("addr", "", "the address to listen on (or \"unix:/path\" for a unix socket), which overrides the one of the configuration")
+	dir := flags.String("dir", ".blossom", "the directory of the blobs, if the configuration doesn't set a storage backend")
+	admin := flags.String("admin", "localhost:3336", "the private address of the admin API. If empty, it's disabled")
+	admins := flags.String("admins", "", "the comma separated hex pubkeys allowed to subscribe to the event stream")
+	level := flags.String("log-level", "info", "one of debug, info, warn or error")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(*level)); err != nil {
+		return fmt.Errorf("invalid log level %q", *level)
+	}
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: l}))
+	slog.SetDefault(log)
+
+	c, err := config.Load(*path)
+	if err != nil {
+		return err
+	}
+	if c.Storage.Backend == "" {
+		c.Storage.Backend = "disk"
+		c.Storage.Dir = *dir
+	}
+	if *addr != "" {
+		c.Address = *addr
+	}
+	if c.Address == "" {
+		c.Address = config.DefaultAddress
+	}
+
+	m := metrics.New()
+	opts := []blossy.Option{
+		blossy.WithLogger(log),
+		blossy.WithMetrics(m),
+		blossy.WithInfoEndpoint(""),
+		blossy.WithLandingPage(""),
+	}
+	if *admins != "" {
+		opts = append(opts, blossy.WithEventStream("", strings.Split(*admins, ",")...))
+	}
+
+	server, err := c.NewServer(ctx, opts...)
+	if err != nil {
+		return err
+	}
+
+	// stores delete blobs on behalf of their owners, so the blobs removed by the operators are quarantined instead
+	queue := reports.NewMemory()
+	takedown := reports.NewTakedown(queue, func(ctx context.Context, hash blossom.Hash) error { return nil })
+	takedown.Bind(server)
+
+	if *admin != "" {
+		api := &adminAPI{server: server, metrics: m, reports: queue, takedown: takedown, log: log}
+		server.Background(func(ctx context.Context) { api.serve(ctx, *admin) })
+	}
+	return server.StartAndServe(ctx, c.Address)
+}
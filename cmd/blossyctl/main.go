@@ -0,0 +1,302 @@
+// Command blossyctl interacts with blossom servers from the command line, for scripting maintenance
+// and for integration testing.
+//
+// Usage:
+//
+//	blossyctl [flags] <command> [arguments]
+//
+// The commands are:
+//
+//	upload [-type type] [-media] <file>...   uploads the files, and prints their descriptors
+//	download [-o file] <hash>                downloads the blob to the file, or to the standard output
+//	mirror <url>...                          asks the server to mirror the blobs at the URLs
+//	delete <hash>...                         deletes the blobs
+//	list <pubkey>                            lists the blobs of the pubkey (hex or npub)
+//	report [-reason reason] [-content text] <hash>...   reports the blobs (BUD-09)
+//
+// The flags are:
+//
+//	-server url
+//	    the URL of the blossom server (default $BLOSSY_SERVER).
+//	-key key
+//	    the nsec or hex private key that signs the requests (default $BLOSSY_KEY).
+//	-bunker url
+//	    the bunker:// URL or NIP-05 identifier of a NIP-46 remote signer, used instead of the key (default $BLOSSY_BUNKER).
+//	-timeout duration
+//	    the timeout of the command (default 1m).
+//
+// Descriptors are printed as JSON, one per line.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/auth"
+	"github.com/pippellia-btc/blossy/client"
+)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if err := run(ctx, os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "blossyctl:", err)
+		os.Exit(1)
+	}
+}
+
+// command runs a subcommand with its arguments, writing its output to out.
+type command func(ctx context.Context, c *client.Client, args []string, out io.Writer) error
+
+var commands = map[string]command{
+	"upload":   upload,
+	"download": download,
+	"mirror":   mirror,
+	"delete":   deleteBlobs,
+	"list":     list,
+	"report":   report,
+}
+
+func run(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("blossyctl", flag.ContinueOnError)
+	server := flags.String("server", os.Getenv("BLOSSY_SERVER"), "the URL of the blossom server")
+	key := flags.String("key", os.Getenv("BLOSSY_KEY"), "the nsec or hex private key that signs the requests")
+	bunker := flags.String("bunker", os.Getenv("BLOSSY_BUNKER"), "the bunker URL or NIP-05 of a NIP-46 remote signer")
+	timeout := flags.Duration("timeout", time.Minute, "the timeout of the command")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("missing command: upload, download, mirror, delete, list or report")
+	}
+
+	name := flags.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q", name)
+	}
+	if *server == "" {
+		return errors.New("the server is not set: use -server or $BLOSSY_SERVER")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	var opts []client.Option
+	signer, err := newSigner(ctx, *key, *bunker)
+	if err != nil {
+		return err
+	}
+	if signer != nil {
+		opts = append(opts, client.WithSigner(signer))
+	}
+
+	c, err := client.New(*server, opts...)
+	if err != nil {
+		return err
+	}
+	return cmd(ctx, c, flags.Args()[1:], out)
+}
+
+// newSigner returns the signer of the remote bunker, or of the private key, or nil if neither is set.
+func newSigner(ctx context.Context, key, bunker string) (auth.Signer, error) {
+	switch {
+	case bunker != "":
+		return auth.NewRemoteSigner(ctx, bunker, "")
+
+	case key != "":
+		if prefix, value, err := nip19.Decode(key); err == nil && prefix == "nsec" {
+			key = value.(string)
+		}
+		return auth.NewKeySigner(key)
+
+	default:
+		return nil, nil
+	}
+}
+
+func upload(ctx context.Context, c *client.Client, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("upload", flag.ContinueOnError)
+	typ := flags.String("type", "", "the content type of the files, by default derived from their extension")
+	media := flags.Bool("media", false, "upload with PUT /media, so that the server can optimize the files (BUD-05)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("upload: missing files")
+	}
+
+	for _, path := range flags.Args() {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		contentType := *typ
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(path))
+		}
+
+		var desc blossom.BlobDescriptor
+		if *media {
+			desc, err = c.UploadMedia(ctx, file, contentType)
+		} else {
+			desc, err = c.Upload(ctx, file, contentType)
+		}
+		file.Close()
+
+		if err != nil {
+			return fmt.Errorf("upload %s: %w", path, err)
+		}
+		if err := printJSON(out, desc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func download(ctx context.Context, c *client.Client, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("download", flag.ContinueOnError)
+	output := flags.String("o", "", "the file to write the blob to, by default the standard output")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("download: expected one hash")
+	}
+
+	hash, err := blossom.ParseHash(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("download: %w", err)
+	}
+
+	blob, err := c.Get(ctx, hash)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	if *output == "" {
+		_, err = io.Copy(out, blob)
+		return err
+	}
+
+	// the blob is written to a temporary file, so that a partial or corrupted download doesn't replace the output
+	tmp, err := os.CreateTemp(filepath.Dir(*output), ".blossyctl-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, blob); err != nil {
+		tmp.Close()
+		return fmt.Errorf("download: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), *output)
+}
+
+func mirror(ctx context.Context, c *client.Client, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("mirror: missing URLs")
+	}
+
+	for _, url := range args {
+		desc, err := c.Mirror(ctx, url)
+		if err != nil {
+			return fmt.Errorf("mirror %s: %w", url, err)
+		}
+		if err := printJSON(out, desc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteBlobs(ctx context.Context, c *client.Client, args []string, out io.Writer) error {
+	hashes, err := parseHashes(args)
+	if err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+
+	for _, hash := range hashes {
+		if err := c.Delete(ctx, hash); err != nil {
+			return fmt.Errorf("delete %s: %w", hash, err)
+		}
+		fmt.Fprintln(out, hash)
+	}
+	return nil
+}
+
+func list(ctx context.Context, c *client.Client, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("list: expected one pubkey")
+	}
+
+	pubkey := args[0]
+	if prefix, value, err := nip19.Decode(pubkey); err == nil && prefix == "npub" {
+		pubkey = value.(string)
+	}
+
+	descs, err := c.List(ctx, pubkey, blossy.ListQuery{})
+	if err != nil {
+		return err
+	}
+	for _, desc := range descs {
+		if err := printJSON(out, desc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func report(ctx context.Context, c *client.Client, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	reason := flags.String("reason", "other", "the NIP-56 report type, e.g. illegal, spam or malware")
+	content := flags.String("content", "", "an optional description of the report")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	hashes, err := parseHashes(flags.Args())
+	if err != nil {
+		return fmt.Errorf("report: %w", err)
+	}
+	return c.Report(ctx, *reason, *content, hashes...)
+}
+
+func parseHashes(args []string) ([]blossom.Hash, error) {
+	if len(args) == 0 {
+		return nil, errors.New("missing hashes")
+	}
+
+	hashes := make([]blossom.Hash, len(args))
+	for i, arg := range args {
+		hash, err := blossom.ParseHash(arg)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = hash
+	}
+	return hashes, nil
+}
+
+func printJSON(out io.Writer, v any) error {
+	return json.NewEncoder(out).Encode(v)
+}
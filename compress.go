@@ -0,0 +1,299 @@
+package blossy
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+// DefaultCompressibleTypes are the content types compressed by [WithCompression], if not configured.
+var DefaultCompressibleTypes = []string{
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+	"text/*",
+}
+
+// DefaultCompressionMinSize is the minimum size in bytes of compressed responses, if not configured.
+const DefaultCompressionMinSize = 1024
+
+// Encoder returns a writer that compresses the data written to w. The writer is closed when the response is complete.
+type Encoder func(w io.Writer) (io.WriteCloser, error)
+
+type encoding struct {
+	name    string
+	encoder Encoder
+}
+
+type compression struct {
+	minSize int
+	types   []string
+
+	// encodings are the supported content encodings, in order of preference.
+	encodings []encoding
+}
+
+var gzipPool = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// pooledGzip is a gzip writer that returns to the pool when closed.
+type pooledGzip struct {
+	*gzip.Writer
+}
+
+func (g pooledGzip) Close() error {
+	err := g.Writer.Close()
+	gzipPool.Put(g.Writer)
+	return err
+}
+
+func gzipEncoder(w io.Writer) (io.WriteCloser, error) {
+	gz := gzipPool.Get().(*gzip.Writer)
+	gz.Reset(w)
+	return pooledGzip{gz}, nil
+}
+
+// negotiate returns the encoding preferred by the client among the supported ones, as per the 'Accept-Encoding' header.
+// The quality of an encoding is the one it's listed with, or the one of the '*' wildcard if it's not listed,
+// so that an explicit 'gzip;q=0' is honored even if the wildcard accepts all encodings.
+// If the client accepts none of them, it returns false.
+func (c *compression) negotiate(header string) (encoding, bool) {
+	listed := make(map[string]float64)
+	wildcard := 0.0
+
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		if name == "*" {
+			wildcard = q
+		} else {
+			listed[name] = q
+		}
+	}
+
+	var best encoding
+	var bestQ float64
+	for _, e := range c.encodings {
+		q, ok := listed[e.name]
+		if !ok {
+			q = wildcard
+		}
+		// ties are broken by the server preference, which is the order of the encodings
+		if q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+// compressible returns whether responses with the content type can be compressed.
+func (c *compression) compressible(contentType string) bool {
+	mediaType := utils.MediaType(contentType)
+	return slices.ContainsFunc(c.types, func(pattern string) bool { return utils.MatchMediaType(pattern, mediaType) })
+}
+
+// handler wraps the handler, compressing its responses when the client supports it.
+func (c *compression) handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, c: c}
+		cw.encoding, cw.accepted = c.negotiate(r.Header.Get("Accept-Encoding"))
+		defer cw.Close()
+
+		next(cw, r)
+	}
+}
+
+// compressWriter buffers the first bytes of a response to decide whether to compress it,
+// based on its size, its content type and its headers.
+type compressWriter struct {
+	http.ResponseWriter
+	c *compression
+
+	encoding encoding
+	accepted bool
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     io.WriteCloser // non-nil when compressing
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	if w.status == 0 {
+		w.status = code
+	}
+	if code == http.StatusNoContent || code == http.StatusNotModified || code < 200 {
+		// responses without a body
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.decided {
+		if w.enc != nil {
+			return w.enc.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	w.buf = append(w.buf, p...)
+	if len(w.buf) < max(w.c.minSize, 1) {
+		return len(p), nil
+	}
+
+	if err := w.decide(true); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ReadFrom preserves the optimizations (e.g. sendfile) of the underlying writer for responses that are not compressed.
+func (w *compressWriter) ReadFrom(r io.Reader) (int64, error) {
+	var read int64
+	if !w.decided {
+		head := make([]byte, max(w.c.minSize, 512))
+		n, err := io.ReadFull(r, head)
+		read += int64(n)
+
+		if _, werr := w.Write(head[:n]); werr != nil {
+			return read, werr
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return read, nil
+		}
+		if err != nil {
+			return read, err
+		}
+		if !w.decided {
+			if err := w.decide(true); err != nil {
+				return read, err
+			}
+		}
+	}
+
+	var n int64
+	var err error
+	if w.enc != nil {
+		n, err = io.Copy(w.enc, r)
+	} else {
+		n, err = io.Copy(w.ResponseWriter, r)
+	}
+	return read + n, err
+}
+
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(len(w.buf) >= w.c.minSize)
+	}
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Close completes the response, writing the buffered bytes and closing the encoder.
+func (w *compressWriter) Close() error {
+	if !w.decided {
+		if w.status == 0 && len(w.buf) == 0 {
+			// nothing was written
+			return nil
+		}
+		if err := w.decide(len(w.buf) >= w.c.minSize); err != nil {
+			return err
+		}
+	}
+
+	if w.enc != nil {
+		return w.enc.Close()
+	}
+	return nil
+}
+
+// decide whether to compress the response, writes its header and the buffered bytes.
+// The size of the response is large enough to be compressed if big is true.
+func (w *compressWriter) decide(big bool) error {
+	w.decided = true
+	header := w.Header()
+
+	if header.Get("Content-Type") == "" && len(w.buf) > 0 {
+		// detect the type from the uncompressed bytes, like the http server would
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+
+	compressible := w.c.compressible(header.Get("Content-Type"))
+	if compressible {
+		header.Add("Vary", "Accept-Encoding")
+	}
+
+	if compressible && big && w.accepted &&
+		w.status != http.StatusPartialContent &&
+		header.Get("Content-Encoding") == "" &&
+		header.Get("Content-Range") == "" {
+
+		enc, err := w.encoding.encoder(w.ResponseWriter)
+		if err == nil {
+			w.enc = enc
+			header.Set("Content-Encoding", w.encoding.name)
+			header.Del("Content-Length")
+			header.Del("Accept-Ranges")
+			if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				// the compressed representation is not byte-for-byte identical
+				header.Set("ETag", "W/"+etag)
+			}
+		}
+	}
+
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
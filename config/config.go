@@ -0,0 +1,318 @@
+// Package config builds a fully configured blossy server from a YAML (or JSON) file and environment variables,
+// so that the same binary can be deployed with different policies without recompiling.
+//
+// Example of a configuration file:
+//
+//	hostname: cdn.example.com
+//	address: localhost:3335
+//
+//	limits:
+//	  max_upload_size: 104857600 # 100 MiB
+//	  max_concurrent_uploads: 32
+//	  handler_timeout: 1m
+//
+//	cors:
+//	  allowed_origins: ["https://app.example.com"]
+//
+//	rate_limits:
+//	  - requests: 60
+//	    per: 1m
+//	    key: ip
+//	    endpoints: [upload, media, mirror]
+//
+//	storage:
+//	  backend: disk
+//	  dir: /var/lib/blossy
+//
+//	moderation:
+//	  blocked_types: ["application/x-msdownload"]
+//	  allowed_pubkeys: ["79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"]
+//	  blocklists: ["/etc/blossy/blocked.txt", "https://example.com/known-bad.txt"]
+//
+// Every field can be overridden by an environment variable named after its path, upper-cased and prefixed
+// with "BLOSSY_", for example BLOSSY_HOSTNAME, BLOSSY_LIMITS_MAX_UPLOAD_SIZE or BLOSSY_STORAGE_S3_SECRET_KEY.
+// Lists are comma separated. Rate limits can only be set in the file.
+//
+// The server is then created with [Config.NewServer]:
+//
+//	c, err := config.Load("blossy.yaml")
+//	if err != nil {
+//	    panic(err)
+//	}
+//
+//	server, err := c.NewServer(ctx)
+//	if err != nil {
+//	    panic(err)
+//	}
+//	server.StartAndServe(ctx, c.Address)
+//
+// Servers created from a loaded configuration read it again on SIGHUP (see [blossy.Server.Reload]),
+// applying the changes to the types, pubkeys, rate limits and blocklists without dropping requests.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/pippellia-btc/blossy"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultAddress is the address the server listens on, if not configured.
+	DefaultAddress = "localhost:3335"
+
+	// DefaultBlocklistReload is how often the blocklists are reloaded, if not configured.
+	DefaultBlocklistReload = 10 * time.Minute
+)
+
+// Config is the configuration of a blossy server. The zero value is a valid configuration,
+// which serves the blobs from memory without any limit.
+type Config struct {
+	// Hostname of the server. See [blossy.WithHostname].
+	Hostname string `yaml:"hostname"`
+
+	// Address the server listens on, or the path of a unix socket prefixed by "unix:".
+	// If empty, [DefaultAddress] is used. See [blossy.Server.StartAndServe].
+	Address string `yaml:"address"`
+
+	// TLS enables HTTPS with the certificate and key files. See [blossy.WithTLS].
+	TLS TLS `yaml:"tls"`
+
+	// HTTP2 tunes HTTP/2, and enables it over plain connections. See [blossy.WithH2C] and [blossy.WithHTTP2Config].
+	HTTP2 HTTP2 `yaml:"http2"`
+
+	Limits     Limits      `yaml:"limits"`
+	CORS       CORS        `yaml:"cors"`
+	RateLimits []RateLimit `yaml:"rate_limits"`
+	Storage    Storage     `yaml:"storage"`
+	Moderation Moderation  `yaml:"moderation"`
+
+	// path is the file the configuration was loaded from by [Load], which is empty if only the environment was used.
+	// It's nil if the configuration was not loaded, in which case the server can't be reloaded.
+	path *string
+}
+
+// TLS holds the paths of the PEM encoded certificate and private key. If both are empty, the server uses plain HTTP.
+type TLS struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// HTTP2 holds the HTTP/2 settings. Zero values keep the defaults.
+type HTTP2 struct {
+	// H2C enables cleartext HTTP/2, for reverse proxies that forward requests over HTTP/2 without TLS.
+	H2C bool `yaml:"h2c"`
+
+	MaxConcurrentStreams          int `yaml:"max_concurrent_streams"`
+	MaxReceiveBufferPerStream     int `yaml:"max_receive_buffer_per_stream"`
+	MaxReceiveBufferPerConnection int `yaml:"max_receive_buffer_per_connection"`
+}
+
+// Limits are the limits on the resources used by the requests. Zero values mean no limit.
+type Limits struct {
+	// MaxUploadSize is the maximum size in bytes of uploaded blobs. See [blossy.WithMaxUploadSize].
+	MaxUploadSize int64 `yaml:"max_upload_size"`
+
+	// MaxConcurrentUploads and UploadQueueWait limit the uploads in progress at once.
+	// See [blossy.WithMaxConcurrentUploads].
+	MaxConcurrentUploads int      `yaml:"max_concurrent_uploads"`
+	UploadQueueWait      Duration `yaml:"upload_queue_wait"`
+
+	// MaxConcurrentUploadsPerIP limits the uploads in progress at once from the same IP group.
+	// See [blossy.WithMaxConcurrentUploadsPerIP].
+	MaxConcurrentUploadsPerIP int `yaml:"max_concurrent_uploads_per_ip"`
+
+	// HandlerTimeout is the deadline of each request. See [blossy.WithHandlerTimeout].
+	HandlerTimeout Duration `yaml:"handler_timeout"`
+
+	// DownloadRate and DownloadRatePerConnection limit the bandwidth in bytes per second of all the downloads
+	// and of the downloads of each connection. See [blossy.WithDownloadRateLimit] and [blossy.WithPerConnectionRateLimit].
+	DownloadRate              int64 `yaml:"download_rate"`
+	DownloadRatePerConnection int64 `yaml:"download_rate_per_connection"`
+}
+
+// CORS is the CORS policy of the server. If all fields are empty, the default policy of BUD-01 is used.
+// See [blossy.CORSPolicy].
+type CORS struct {
+	// Disabled removes the CORS headers from the responses. See [blossy.WithoutCORS].
+	Disabled bool `yaml:"disabled"`
+
+	AllowedOrigins   []string `yaml:"allowed_origins"`
+	AllowedMethods   []string `yaml:"allowed_methods"`
+	AllowedHeaders   []string `yaml:"allowed_headers"`
+	ExposedHeaders   []string `yaml:"exposed_headers"`
+	MaxAge           Duration `yaml:"max_age"`
+	AllowCredentials bool     `yaml:"allow_credentials"`
+}
+
+// RateLimit limits the requests to the endpoints to Requests every Per, with bursts of up to Burst requests.
+// See [blossy.WithRateLimit].
+type RateLimit struct {
+	Requests int      `yaml:"requests"`
+	Per      Duration `yaml:"per"`
+
+	// Burst is the maximum number of requests in a burst. If 0, it's equal to Requests.
+	Burst int `yaml:"burst"`
+
+	// Key is what the requests are limited by: "ip" (the default) or "pubkey".
+	Key string `yaml:"key"`
+
+	// Endpoints are the names of the endpoints the limit applies to (e.g. "upload"). If empty, it applies to all.
+	Endpoints []string `yaml:"endpoints"`
+}
+
+// Storage is the backend the blobs are stored in, which is bound to the server with [blossy.BindStore].
+type Storage struct {
+	// Backend is one of "memory" (the default), "disk" or "s3".
+	Backend string `yaml:"backend"`
+
+	// Dir is the directory of the "disk" backend.
+	Dir string `yaml:"dir"`
+
+	// Journal records the uploads of the "disk" backend in a write-ahead journal,
+	// so that the uploads interrupted by a crash are reconciled at startup.
+	Journal bool `yaml:"journal"`
+
+	// S3 is the configuration of the "s3" backend.
+	S3 S3 `yaml:"s3"`
+}
+
+// S3 is the configuration of an S3-compatible object storage. See the blossy/stores/s3 package.
+type S3 struct {
+	Endpoint      string   `yaml:"endpoint"`
+	Region        string   `yaml:"region"`
+	Bucket        string   `yaml:"bucket"`
+	Prefix        string   `yaml:"prefix"`
+	AccessKey     string   `yaml:"access_key"`
+	SecretKey     string   `yaml:"secret_key"`
+	PathStyle     bool     `yaml:"path_style"`
+	PresignExpiry Duration `yaml:"presign_expiry"`
+}
+
+// Moderation are the rules on the content and the users of the server.
+type Moderation struct {
+	// AllowedTypes and BlockedTypes are the patterns of the content types of the blobs that can and can't be uploaded.
+	// See [blossy.WithAllowedTypes] and [blossy.WithBlockedTypes].
+	AllowedTypes []string `yaml:"allowed_types"`
+	BlockedTypes []string `yaml:"blocked_types"`
+
+	// AllowedPubkeys and DeniedPubkeys are the hex encoded pubkeys that can and can't store, delete and list blobs.
+	// See [blossy.WithAllowedPubkeys] and [blossy.WithDeniedPubkeys].
+	AllowedPubkeys []string `yaml:"allowed_pubkeys"`
+	DeniedPubkeys  []string `yaml:"denied_pubkeys"`
+
+	// RequiredAuth are the names of the endpoints whose requests must be authenticated. See [blossy.WithRequiredAuth].
+	RequiredAuth []string `yaml:"required_auth"`
+
+	// Blocklists are the files or http(s) URLs of the lists of blocked hashes, reloaded every BlocklistReload
+	// (by default [DefaultBlocklistReload]). See the blossy/blocklist package.
+	Blocklists      []string `yaml:"blocklists"`
+	BlocklistReload Duration `yaml:"blocklist_reload"`
+
+	// AllowedIPs and DeniedIPs are the networks in CIDR notation whose requests are allowed and denied.
+	// See [blossy.IPPolicy].
+	AllowedIPs []string `yaml:"allowed_ips"`
+	DeniedIPs  []string `yaml:"denied_ips"`
+}
+
+// Duration is a [time.Duration] written as a string like "90s" or "1h30m".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Load reads the configuration from the YAML (or JSON) file at the path, and then applies the overrides
+// of the environment variables (see [FromEnv]). If the path is empty, only the environment is used.
+func Load(path string) (Config, error) {
+	var c Config
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("config: %w", err)
+		}
+		if c, err = Parse(data); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if err := FromEnv(&c); err != nil {
+		return Config{}, err
+	}
+	c.path = &path
+	return c, c.Validate()
+}
+
+// Parse parses the YAML (or JSON) configuration. Unknown fields are rejected, so that typos are not silently ignored.
+func Parse(data []byte) (Config, error) {
+	var c Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return Config{}, fmt.Errorf("config: %w", err)
+	}
+	return c, nil
+}
+
+// Validate checks the fields that the options of the server don't check, like the names of the endpoints.
+func (c Config) Validate() error {
+	switch c.Storage.Backend {
+	case "", "memory", "s3":
+	case "disk":
+		if c.Storage.Dir == "" {
+			return errors.New("config: the disk storage requires a dir")
+		}
+	default:
+		return fmt.Errorf("config: unknown storage backend %q", c.Storage.Backend)
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return errors.New("config: tls requires both the cert and the key file")
+	}
+
+	for i, limit := range c.RateLimits {
+		if limit.Requests <= 0 || limit.Per <= 0 {
+			return fmt.Errorf("config: rate limit %d: requests and per must be positive", i)
+		}
+		if limit.Key != "" && limit.Key != "ip" && limit.Key != "pubkey" {
+			return fmt.Errorf("config: rate limit %d: unknown key %q", i, limit.Key)
+		}
+		if _, err := endpoints(limit.Endpoints); err != nil {
+			return fmt.Errorf("config: rate limit %d: %w", i, err)
+		}
+	}
+
+	if _, err := endpoints(c.Moderation.RequiredAuth); err != nil {
+		return fmt.Errorf("config: required auth: %w", err)
+	}
+	return nil
+}
+
+// endpoints converts the names to the endpoints of the server.
+func endpoints(names []string) ([]blossy.Endpoint, error) {
+	endpoints := make([]blossy.Endpoint, 0, len(names))
+	for _, name := range names {
+		e := blossy.Endpoint(name)
+		if !slices.Contains(blossy.Endpoints(), e) {
+			return nil, fmt.Errorf("unknown endpoint %q", name)
+		}
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, nil
+}
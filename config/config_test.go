@@ -0,0 +1,210 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+const file = `
+hostname: cdn.example.com
+http2:
+  h2c: true
+  max_concurrent_streams: 100
+limits:
+  max_upload_size: 1024
+  handler_timeout: 1m30s
+cors:
+  allowed_origins: ["https://app.example.com"]
+rate_limits:
+  - requests: 10
+    per: 1m
+    key: pubkey
+    endpoints: [upload, media]
+storage:
+  backend: memory
+moderation:
+  blocked_types: ["application/x-msdownload"]
+  required_auth: [upload]
+`
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(file))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if c.Hostname != "cdn.example.com" || c.Limits.MaxUploadSize != 1024 {
+		t.Errorf("expected the hostname and the max upload size to be set, got %+v", c)
+	}
+	if !c.HTTP2.H2C || c.HTTP2.MaxConcurrentStreams != 100 {
+		t.Errorf("expected h2c with 100 streams, got %+v", c.HTTP2)
+	}
+	if time.Duration(c.Limits.HandlerTimeout) != 90*time.Second {
+		t.Errorf("expected a handler timeout of 1m30s, got %v", time.Duration(c.Limits.HandlerTimeout))
+	}
+	if len(c.RateLimits) != 1 || c.RateLimits[0].Key != "pubkey" || time.Duration(c.RateLimits[0].Per) != time.Minute {
+		t.Errorf("expected a rate limit by pubkey, got %+v", c.RateLimits)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("expected the config to be valid, got %v", err)
+	}
+
+	if _, err := Parse([]byte("hostnme: cdn.example.com")); err == nil {
+		t.Error("expected an unknown field to be rejected")
+	}
+	if _, err := Parse(nil); err != nil {
+		t.Errorf("expected an empty file to be valid, got %v", err)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("BLOSSY_HOSTNAME", "env.example.com")
+	t.Setenv("BLOSSY_LIMITS_UPLOAD_QUEUE_WAIT", "2s")
+	t.Setenv("BLOSSY_STORAGE_S3_PATH_STYLE", "true")
+	t.Setenv("BLOSSY_MODERATION_ALLOWED_PUBKEYS", "aa, bb,")
+
+	path := filepath.Join(t.TempDir(), "blossy.yaml")
+	if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if c.Hostname != "env.example.com" {
+		t.Errorf("expected the environment to override the hostname, got %q", c.Hostname)
+	}
+	if c.Limits.MaxUploadSize != 1024 {
+		t.Errorf("expected the fields not in the environment to be kept, got %d", c.Limits.MaxUploadSize)
+	}
+	if time.Duration(c.Limits.UploadQueueWait) != 2*time.Second || !c.Storage.S3.PathStyle {
+		t.Errorf("expected the durations and booleans to be parsed, got %+v", c)
+	}
+	if len(c.Moderation.AllowedPubkeys) != 2 || c.Moderation.AllowedPubkeys[1] != "bb" {
+		t.Errorf("expected two pubkeys, got %v", c.Moderation.AllowedPubkeys)
+	}
+
+	t.Setenv("BLOSSY_LIMITS_MAX_UPLOAD_SIZE", "big")
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "BLOSSY_LIMITS_MAX_UPLOAD_SIZE") {
+		t.Errorf("expected an error naming the variable, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{name: "unknown backend", config: Config{Storage: Storage{Backend: "tape"}}},
+		{name: "disk without dir", config: Config{Storage: Storage{Backend: "disk"}}},
+		{name: "cert without key", config: Config{TLS: TLS{CertFile: "cert.pem"}}},
+		{name: "rate limit without period", config: Config{RateLimits: []RateLimit{{Requests: 10}}}},
+		{name: "rate limit by unknown key", config: Config{RateLimits: []RateLimit{{Requests: 10, Per: Duration(time.Second), Key: "country"}}}},
+		{name: "unknown endpoint", config: Config{Moderation: Moderation{RequiredAuth: []string{"uplod"}}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.config.Validate(); err == nil {
+				t.Error("expected an error, got nil")
+			}
+		})
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	blocked := blossom.ComputeHash([]byte("blocked"))
+	path := filepath.Join(t.TempDir(), "blocked.txt")
+	if err := os.WriteFile(path, []byte(blocked.Hex()+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := Config{
+		Hostname: "cdn.example.com",
+		Storage:  Storage{Backend: "disk", Dir: t.TempDir()},
+		Moderation: Moderation{
+			Blocklists:   []string{path},
+			RequiredAuth: []string{"upload"},
+		},
+	}
+
+	server, err := c.NewServer(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{method: http.MethodGet, path: "/" + blossom.ComputeHash([]byte("missing")).Hex(), status: http.StatusNotFound},
+		{method: http.MethodGet, path: "/" + blocked.Hex(), status: http.StatusUnavailableForLegalReasons},
+		{method: http.MethodPut, path: "/upload", status: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(test.method, test.path, strings.NewReader("data")))
+		if w.Code != test.status {
+			t.Errorf("%s %s: expected %d, got %d", test.method, test.path, test.status, w.Code)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blossy.yaml")
+	write := func(config string) {
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("hostname: cdn.example.com\nmoderation:\n  blocked_types: [text/plain]\n")
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	server, err := c.NewServer(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	upload := func() int {
+		r := httptest.NewRequest(http.MethodPut, "/upload", strings.NewReader("hello"))
+		r.Header.Set("Content-Type", "text/plain")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if status := upload(); status != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415 before the reload, got %d", status)
+	}
+
+	write("hostname: cdn.example.com\nmoderation:\n  blocked_types: [image/*]\n")
+	if err := server.Reload(t.Context()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status := upload(); status != http.StatusOK {
+		t.Errorf("expected 200 after the reload, got %d", status)
+	}
+
+	write("moderation:\n  blocked_types: [text/plain]\n  blocklists: [missing.txt]\n")
+	if err := server.Reload(t.Context()); err == nil {
+		t.Error("expected the reload of a missing blocklist to fail")
+	}
+	if status := upload(); status != http.StatusOK {
+		t.Errorf("expected a failed reload to keep the previous settings, got %d", status)
+	}
+}
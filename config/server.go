@@ -0,0 +1,301 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/blocklist"
+	"github.com/pippellia-btc/blossy/ratelimit"
+	"github.com/pippellia-btc/blossy/stores/disk"
+	"github.com/pippellia-btc/blossy/stores/memory"
+	"github.com/pippellia-btc/blossy/stores/s3"
+)
+
+// NewServer returns a server configured as described, with the storage backend bound to its hooks.
+// The blocklists are loaded immediately, and reloaded in the background while the server is serving.
+//
+// If the configuration was read with [Load], the server can be reloaded with [blossy.Server.Reload] (or SIGHUP),
+// which reads the same file and environment again and applies their types, pubkeys, rate limits and blocklists.
+// The other fields require a restart. The counters of the rate limits that didn't change are preserved.
+//
+// The provided options are applied after the ones derived from the configuration, so they can override them.
+// Hooks can be added to the returned server as usual.
+func (c Config) NewServer(ctx context.Context, opts ...blossy.Option) (*blossy.Server, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	store, err := c.Storage.open()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &policies{limiters: make(map[string]*ratelimit.Limiter)}
+	reloadable, err := p.options(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	options := append(c.options(), reloadable...)
+	if c.path != nil {
+		path := *c.path
+		options = append(options, blossy.WithReloader(func(ctx context.Context) ([]blossy.Option, error) {
+			c, err := Load(path)
+			if err != nil {
+				return nil, err
+			}
+			return p.options(ctx, c)
+		}))
+	}
+
+	server, err := blossy.NewServer(append(options, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	blossy.BindStore(server, store)
+	server.Background(p.run)
+	return server, nil
+}
+
+// options returns the options of the server derived from the configuration, except the ones of the [policies].
+// The configuration must be valid.
+func (c Config) options() []blossy.Option {
+	var opts []blossy.Option
+	if c.Hostname != "" {
+		opts = append(opts, blossy.WithHostname(c.Hostname))
+	}
+	if c.TLS.CertFile != "" {
+		opts = append(opts, blossy.WithTLS(c.TLS.CertFile, c.TLS.KeyFile))
+	}
+	if c.HTTP2.H2C {
+		opts = append(opts, blossy.WithH2C())
+	}
+	if h := c.HTTP2; h.MaxConcurrentStreams > 0 || h.MaxReceiveBufferPerStream > 0 || h.MaxReceiveBufferPerConnection > 0 {
+		opts = append(opts, blossy.WithHTTP2Config(&http.HTTP2Config{
+			MaxConcurrentStreams:          h.MaxConcurrentStreams,
+			MaxReceiveBufferPerStream:     h.MaxReceiveBufferPerStream,
+			MaxReceiveBufferPerConnection: h.MaxReceiveBufferPerConnection,
+		}))
+	}
+
+	l := c.Limits
+	if l.MaxUploadSize > 0 {
+		opts = append(opts, blossy.WithMaxUploadSize(l.MaxUploadSize))
+	}
+	if l.MaxConcurrentUploads > 0 {
+		opts = append(opts, blossy.WithMaxConcurrentUploads(l.MaxConcurrentUploads, time.Duration(l.UploadQueueWait)))
+	}
+	if l.MaxConcurrentUploadsPerIP > 0 {
+		opts = append(opts, blossy.WithMaxConcurrentUploadsPerIP(l.MaxConcurrentUploadsPerIP))
+	}
+	if l.HandlerTimeout > 0 {
+		opts = append(opts, blossy.WithHandlerTimeout(time.Duration(l.HandlerTimeout)))
+	}
+	if l.DownloadRate > 0 {
+		opts = append(opts, blossy.WithDownloadRateLimit(l.DownloadRate))
+	}
+	if l.DownloadRatePerConnection > 0 {
+		opts = append(opts, blossy.WithPerConnectionRateLimit(l.DownloadRatePerConnection))
+	}
+
+	if c.CORS.Disabled {
+		opts = append(opts, blossy.WithoutCORS())
+	} else if policy := c.CORS.policy(); policy != nil {
+		opts = append(opts, blossy.WithCORS(*policy))
+	}
+
+	m := c.Moderation
+	if len(m.RequiredAuth) > 0 {
+		required, _ := endpoints(m.RequiredAuth)
+		opts = append(opts, blossy.WithRequiredAuth(required...))
+	}
+	if len(m.AllowedIPs) > 0 || len(m.DeniedIPs) > 0 {
+		opts = append(opts, blossy.WithIPPolicy(blossy.IPPolicy{Allow: m.AllowedIPs, Deny: m.DeniedIPs}))
+	}
+	return opts
+}
+
+// policies builds the options that can be replaced with [blossy.Server.Reload], keeping the state
+// that must survive reloads: the limiters of the rate limits and the blocklist.
+type policies struct {
+	mu       sync.Mutex
+	limiters map[string]*ratelimit.Limiter
+	list     *blocklist.Blocklist
+	sources  []string
+	interval time.Duration
+}
+
+// options returns the options of the types, pubkeys, rate limits and blocklists of the configuration,
+// which must be valid. The blocklist is loaded again, and the limiters of unchanged rate limits are reused.
+func (p *policies) options(ctx context.Context, c Config) ([]blossy.Option, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var opts []blossy.Option
+	m := c.Moderation
+	if len(m.AllowedTypes) > 0 {
+		opts = append(opts, blossy.WithAllowedTypes(m.AllowedTypes))
+	}
+	if len(m.BlockedTypes) > 0 {
+		opts = append(opts, blossy.WithBlockedTypes(m.BlockedTypes))
+	}
+	if len(m.AllowedPubkeys) > 0 {
+		opts = append(opts, blossy.WithAllowedPubkeys(m.AllowedPubkeys))
+	}
+	if len(m.DeniedPubkeys) > 0 {
+		opts = append(opts, blossy.WithDeniedPubkeys(m.DeniedPubkeys))
+	}
+
+	limiters := make(map[string]*ratelimit.Limiter, len(c.RateLimits))
+	for _, limit := range c.RateLimits {
+		limiter, ok := p.limiters[limit.id()]
+		if !ok {
+			limiter = limit.limiter()
+		}
+		limiters[limit.id()] = limiter
+		opts = append(opts, limit.option(limiter))
+	}
+
+	list, err := p.blocklist(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	if list != nil {
+		opts = append(opts, blossy.WithBlocklist(list))
+	}
+
+	p.limiters = limiters
+	p.list = list
+	p.sources = m.Blocklists
+	p.interval = time.Duration(m.BlocklistReload)
+	return opts, nil
+}
+
+// blocklist returns the blocklist of the sources, reloading the current one if they didn't change.
+func (p *policies) blocklist(ctx context.Context, m Moderation) (*blocklist.Blocklist, error) {
+	if len(m.Blocklists) == 0 {
+		return nil, nil
+	}
+	if p.list != nil && slices.Equal(p.sources, m.Blocklists) {
+		return p.list, p.list.Reload(ctx)
+	}
+	return m.blocklist(ctx)
+}
+
+// run reloads the current blocklist every reload interval, until the context is cancelled.
+func (p *policies) run(ctx context.Context) {
+	for {
+		p.mu.Lock()
+		list, interval := p.list, p.interval
+		p.mu.Unlock()
+
+		if interval <= 0 {
+			interval = DefaultBlocklistReload
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-time.After(interval):
+			if list == nil {
+				continue
+			}
+			if err := list.Reload(ctx); err != nil {
+				slog.Error("blocklist: failed to reload", "error", err)
+			}
+		}
+	}
+}
+
+// policy returns the CORS policy, or nil if no field is set.
+func (c CORS) policy() *blossy.CORSPolicy {
+	policy := blossy.CORSPolicy{
+		AllowedOrigins:   c.AllowedOrigins,
+		AllowedMethods:   c.AllowedMethods,
+		AllowedHeaders:   c.AllowedHeaders,
+		ExposedHeaders:   c.ExposedHeaders,
+		MaxAge:           time.Duration(c.MaxAge),
+		AllowCredentials: c.AllowCredentials,
+	}
+
+	empty := len(policy.AllowedOrigins) == 0 && len(policy.AllowedMethods) == 0 && len(policy.AllowedHeaders) == 0 &&
+		len(policy.ExposedHeaders) == 0 && policy.MaxAge == 0 && !policy.AllowCredentials
+	if empty {
+		return nil
+	}
+	return &policy
+}
+
+// id identifies the rate limit among the ones of a configuration.
+func (l RateLimit) id() string {
+	return fmt.Sprintf("%d/%d/%d/%s/%s", l.Requests, l.Per, l.Burst, l.Key, strings.Join(l.Endpoints, ","))
+}
+
+// limiter returns a new limiter of the rate limit, which must be valid.
+func (l RateLimit) limiter() *ratelimit.Limiter {
+	burst := l.Burst
+	if burst <= 0 {
+		burst = l.Requests
+	}
+	return ratelimit.New(ratelimit.Per(l.Requests, time.Duration(l.Per)), burst)
+}
+
+// option returns the option of the rate limit, which must be valid.
+func (l RateLimit) option(limiter *ratelimit.Limiter) blossy.Option {
+	key := blossy.KeyByIP
+	if l.Key == "pubkey" {
+		key = blossy.KeyByPubkey
+	}
+
+	endpoints, _ := endpoints(l.Endpoints)
+	return blossy.WithRateLimit(limiter, key, endpoints...)
+}
+
+// open opens the storage backend.
+func (s Storage) open() (blossy.Store, error) {
+	switch s.Backend {
+	case "disk":
+		if s.Journal {
+			return disk.New(s.Dir, disk.WithJournal())
+		}
+		return disk.New(s.Dir)
+
+	case "s3":
+		return s3.New(s3.Config{
+			Endpoint:      s.S3.Endpoint,
+			Region:        s.S3.Region,
+			Bucket:        s.S3.Bucket,
+			Prefix:        s.S3.Prefix,
+			AccessKey:     s.S3.AccessKey,
+			SecretKey:     s.S3.SecretKey,
+			PathStyle:     s.S3.PathStyle,
+			PresignExpiry: time.Duration(s.S3.PresignExpiry),
+		})
+
+	default:
+		return memory.New(), nil
+	}
+}
+
+// blocklist loads the blocklists from their files or URLs.
+func (m Moderation) blocklist(ctx context.Context) (*blocklist.Blocklist, error) {
+	sources := make([]blocklist.Source, len(m.Blocklists))
+	for i, path := range m.Blocklists {
+		if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+			sources[i] = blocklist.URL(path)
+		} else {
+			sources[i] = blocklist.File(path)
+		}
+	}
+
+	return blocklist.New(ctx, sources)
+}
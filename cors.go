@@ -0,0 +1,173 @@
+package blossy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSPolicy configures the Cross-Origin Resource Sharing headers of the server responses,
+// which allow web clients on other origins to use the server. See [WithCORS].
+// Learn more here: https://developer.mozilla.org/en-US/docs/Web/HTTP/CORS
+type CORSPolicy struct {
+	// AllowedOrigins are the origins allowed to make cross-origin requests. They can be exact origins
+	// (e.g. "https://app.example.com"), origins with a wildcard subdomain (e.g. "https://*.example.com") or "*".
+	// If empty, all origins are allowed.
+	AllowedOrigins []string
+
+	// AllowedMethods are the methods allowed in cross-origin requests.
+	// If empty, "GET, HEAD, PUT, DELETE" are allowed, as required by BUD-01.
+	AllowedMethods []string
+
+	// AllowedHeaders are the request headers allowed in cross-origin requests.
+	// If empty, "Authorization, *" are allowed, as required by BUD-01.
+	AllowedHeaders []string
+
+	// ExposedHeaders are the response headers that web clients can read, besides the safelisted ones
+	// (e.g. "X-Reason"). If empty, no additional header is exposed.
+	ExposedHeaders []string
+
+	// MaxAge is how long the result of a preflight request can be cached. If 0, it's 24 hours.
+	MaxAge time.Duration
+
+	// AllowCredentials allows web clients to send credentials (e.g. cookies).
+	// Since credentials are not allowed with "*", the origin of the request is echoed instead.
+	AllowCredentials bool
+}
+
+// DefaultCORSPolicy returns the CORS policy required by BUD-01, which allows all origins.
+// Learn more here: https://github.com/hzrd149/blossom/blob/master/buds/01.md
+func DefaultCORSPolicy() CORSPolicy {
+	return CORSPolicy{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET", "HEAD", "PUT", "DELETE"},
+		AllowedHeaders: []string{"Authorization", "*"},
+		MaxAge:         24 * time.Hour,
+	}
+}
+
+// cors is a [CORSPolicy] whose headers are computed once.
+type cors struct {
+	origins     []string
+	anyOrigin   bool
+	credentials bool
+
+	methods string
+	headers string
+	exposed string
+	maxAge  string
+}
+
+func newCORS(p CORSPolicy) *cors {
+	defaults := DefaultCORSPolicy()
+	if len(p.AllowedOrigins) == 0 {
+		p.AllowedOrigins = defaults.AllowedOrigins
+	}
+	if len(p.AllowedMethods) == 0 {
+		p.AllowedMethods = defaults.AllowedMethods
+	}
+	if len(p.AllowedHeaders) == 0 {
+		p.AllowedHeaders = defaults.AllowedHeaders
+	}
+	if p.MaxAge == 0 {
+		p.MaxAge = defaults.MaxAge
+	}
+
+	return &cors{
+		origins:     p.AllowedOrigins,
+		anyOrigin:   slices.Contains(p.AllowedOrigins, "*"),
+		credentials: p.AllowCredentials,
+		methods:     strings.Join(p.AllowedMethods, ", "),
+		headers:     strings.Join(p.AllowedHeaders, ", "),
+		exposed:     strings.Join(p.ExposedHeaders, ", "),
+		maxAge:      strconv.Itoa(int(p.MaxAge.Seconds())),
+	}
+}
+
+func (p CORSPolicy) validate() error {
+	for _, origin := range p.AllowedOrigins {
+		if err := validateOrigin(origin); err != nil {
+			return fmt.Errorf("cors: invalid origin %q: %w", origin, err)
+		}
+	}
+	if p.MaxAge < 0 {
+		return errors.New("cors: max age must not be negative")
+	}
+	return nil
+}
+
+func validateOrigin(origin string) error {
+	if origin == "*" {
+		return nil
+	}
+
+	u, err := url.Parse(strings.Replace(origin, "*.", "wildcard.", 1))
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return errors.New("origin must be a scheme and a host, like https://example.com")
+	}
+	if strings.Contains(origin, "*") && !strings.HasPrefix(u.Host, "wildcard.") {
+		return errors.New("the wildcard must be the first label of the host, like https://*.example.com")
+	}
+	return nil
+}
+
+// allows returns whether the origin is allowed.
+func (c *cors) allows(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+	return slices.ContainsFunc(c.origins, func(pattern string) bool { return matchOrigin(pattern, origin) })
+}
+
+// matchOrigin returns whether the origin matches the pattern, which can have a wildcard subdomain.
+func matchOrigin(pattern, origin string) bool {
+	pattern = strings.TrimSuffix(strings.ToLower(pattern), "/")
+	origin = strings.ToLower(origin)
+
+	prefix, suffix, wildcard := strings.Cut(pattern, "*")
+	if !wildcard {
+		return pattern == origin
+	}
+	// the wildcard matches one or more subdomain labels
+	return len(origin) > len(prefix)+len(suffix) &&
+		strings.HasPrefix(origin, prefix) &&
+		strings.HasSuffix(origin, suffix) &&
+		!strings.ContainsAny(origin[len(prefix):len(origin)-len(suffix)], "/:")
+}
+
+// set sets the CORS headers of the response to the request.
+func (c *cors) set(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	header.Set("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+
+	origin := r.Header.Get("Origin")
+	switch {
+	case c.anyOrigin && !c.credentials:
+		header.Set("Access-Control-Allow-Origin", "*")
+
+	case origin != "" && c.allows(origin):
+		header.Set("Access-Control-Allow-Origin", origin)
+
+	default:
+		// without the allow origin header, browsers block the response
+		return
+	}
+
+	if c.credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if c.exposed != "" {
+		header.Set("Access-Control-Expose-Headers", c.exposed)
+	}
+	header.Set("Access-Control-Allow-Methods", c.methods)
+	header.Set("Access-Control-Allow-Headers", c.headers)
+	header.Set("Access-Control-Max-Age", c.maxAge)
+}
@@ -0,0 +1,158 @@
+package blossy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMatchOrigin(t *testing.T) {
+	tests := []struct {
+		pattern string
+		origin  string
+		want    bool
+	}{
+		{"https://app.example.com", "https://app.example.com", true},
+		{"https://app.example.com/", "https://app.example.com", true},
+		{"https://APP.example.com", "https://app.example.com", true},
+		{"https://app.example.com", "http://app.example.com", false},
+		{"https://app.example.com", "https://app.example.com.evil.com", false},
+		{"https://*.example.com", "https://media.example.com", true},
+		{"https://*.example.com", "https://a.b.example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://evil.com/.example.com", false},
+		{"https://*.example.com", "https://evil.com:443.example.com", false},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.origin), func(t *testing.T) {
+			if got := matchOrigin(test.pattern, test.origin); got != test.want {
+				t.Errorf("matchOrigin(%q, %q): expected %v, got %v", test.pattern, test.origin, test.want, got)
+			}
+		})
+	}
+}
+
+func TestCORS(t *testing.T) {
+	restricted := CORSPolicy{
+		AllowedOrigins: []string{"https://app.example.com", "https://*.nostr.com"},
+		ExposedHeaders: []string{"X-Reason"},
+		MaxAge:         time.Hour,
+	}
+
+	tests := []struct {
+		name    string
+		policy  *CORSPolicy // nil for the default policy
+		method  string
+		origin  string
+		headers map[string]string // empty values stand for missing headers
+	}{
+		{
+			name:   "default",
+			method: http.MethodGet,
+			origin: "https://any.com",
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":  "*",
+				"Access-Control-Allow-Methods": "GET, HEAD, PUT, DELETE",
+				"Access-Control-Allow-Headers": "Authorization, *",
+				"Access-Control-Max-Age":       "86400",
+			},
+		},
+		{
+			name:   "allowed origin",
+			policy: &restricted,
+			method: http.MethodGet,
+			origin: "https://app.example.com",
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":   "https://app.example.com",
+				"Access-Control-Expose-Headers": "X-Reason",
+			},
+		},
+		{
+			name:    "allowed wildcard origin",
+			policy:  &restricted,
+			method:  http.MethodGet,
+			origin:  "https://media.nostr.com",
+			headers: map[string]string{"Access-Control-Allow-Origin": "https://media.nostr.com"},
+		},
+		{
+			name:   "rejected origin",
+			policy: &restricted,
+			method: http.MethodGet,
+			origin: "https://app.example.com.evil.com",
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":  "",
+				"Access-Control-Allow-Methods": "",
+			},
+		},
+		{
+			name:   "preflight",
+			policy: &restricted,
+			method: http.MethodOptions,
+			origin: "https://app.example.com",
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":  "https://app.example.com",
+				"Access-Control-Allow-Methods": "GET, HEAD, PUT, DELETE",
+				"Access-Control-Allow-Headers": "Authorization, *",
+				"Access-Control-Max-Age":       "3600",
+				"Vary":                         "Origin, Access-Control-Request-Method, Access-Control-Request-Headers",
+			},
+		},
+		{
+			name:   "credentials",
+			policy: &CORSPolicy{AllowCredentials: true},
+			method: http.MethodGet,
+			origin: "https://app.example.com",
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Credentials": "true",
+			},
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			opts := []Option{WithHostname(testHostname)}
+			if test.policy != nil {
+				opts = append(opts, WithCORS(*test.policy))
+			}
+			server, err := NewServer(opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest(test.method, "/upload", nil)
+			r.Header.Set("Origin", test.origin)
+			if test.method == http.MethodOptions {
+				r.Header.Set("Access-Control-Request-Method", http.MethodPut)
+				r.Header.Set("Access-Control-Request-Headers", "Authorization, Content-Type")
+			}
+
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, r)
+			for name, value := range test.headers {
+				if got := w.Header().Get(name); got != value {
+					t.Errorf("expected the header %s to be %q, got %q", name, value, got)
+				}
+			}
+		})
+	}
+}
+
+func TestWithoutCORS(t *testing.T) {
+	server, err := NewServer(WithHostname(testHostname), WithoutCORS())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/upload", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+
+	if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf("expected no CORS headers, got %q", origin)
+	}
+}
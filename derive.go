@@ -0,0 +1,85 @@
+package blossy
+
+import (
+	"strings"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+// Derivation serves a representation of a blob other than its content, such as a .torrent file.
+// See [Server.Derive].
+type Derivation struct {
+	// Download serves the representation of the blob with the hash. It's required.
+	Download func(r Request, hash blossom.Hash) (BlobDelivery, *blossom.Error)
+
+	// Check returns the type and the size of the representation of the blob with the hash.
+	// If nil, HEAD requests are answered by calling Download and discarding the blob it serves,
+	// so it should be set when the representation is expensive to produce.
+	Check func(r Request, hash blossom.Hash) (MetaDelivery, *blossom.Error)
+}
+
+// Derive serves the derivation at GET /<sha256>.<ext>, in place of the blob. For example, a derivation
+// for the "torrent" extension can serve the .torrent file of the blob at /<sha256>.torrent.
+//
+// The requests go through the policies, the blocklist and the Reject hooks of the Download endpoint as usual,
+// but the derivation is called instead of the Download and Check hooks, and the blob cache is bypassed (see [WithBlobCache]).
+// The extension is case-insensitive.
+//
+// Derive is not safe for concurrent use, and it must be called before the server starts serving requests.
+// It panics if the Download function of the derivation is nil.
+func (s *Server) Derive(ext string, derivation Derivation) {
+	if derivation.Download == nil {
+		panic("blossy.Derive: the Download function of the derivation must not be nil")
+	}
+	if s.derivations == nil {
+		s.derivations = make(map[string]Derivation)
+	}
+	s.derivations[strings.ToLower(ext)] = derivation
+}
+
+// derivation returns the derivation of the extension, if any.
+func (s *Server) derivation(ext string) (Derivation, bool) {
+	if len(s.derivations) == 0 || ext == "" {
+		return Derivation{}, false
+	}
+	derivation, ok := s.derivations[strings.ToLower(ext)]
+	return derivation, ok
+}
+
+// checkDerived answers the check of a derived representation with the Check function of the derivation if set,
+// or with the type and size of the blob served by its Download function.
+func checkDerived(r Request, hash blossom.Hash, derivation Derivation) (MetaDelivery, *blossom.Error) {
+	if derivation.Check != nil {
+		return derivation.Check(r, hash)
+	}
+
+	result, err := derivation.Download(r, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	switch result := result.(type) {
+	case servedBlob:
+		if result.Blob == nil {
+			return nil, blossom.ErrNotFound("Blob not found")
+		}
+		defer result.Blob.Close()
+		return foundBlob{mime: result.Type(), size: result.Size(), delivery: result.delivery}, nil
+
+	case redirect:
+		return result, nil
+
+	case blockedBlob:
+		return result, nil
+
+	default:
+		return nil, blossom.ErrInternal("Unknown blob delivery type")
+	}
+}
+
+// BlobURL returns the URL of the blob with the descriptor, as returned to the clients in the blob descriptors:
+// it's built by the builder set with [WithURLBuilder], or derived from the hostname of the server and the type of the blob.
+// It returns an error if neither is set.
+func (s *Server) BlobURL(r Request, desc blossom.BlobDescriptor) (string, error) {
+	return s.deriveURL(r.Raw(), desc)
+}
@@ -0,0 +1,109 @@
+package blossy
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+// The blossom package provides the constructors of the most common errors, such as [blossom.ErrNotFound],
+// [blossom.ErrUnauthorized], [blossom.ErrForbidden], [blossom.ErrTooLarge], [blossom.ErrUnsupportedMedia],
+// [blossom.ErrPaymentRequired] and [blossom.ErrInternal]. The ones below complete the set used by the server.
+
+// ErrRateLimited returns a 429 Too Many Requests error, for Reject hooks that limit the rate of the requests.
+// Consider setting the 'Retry-After' header of the response with [ResponseHeader].
+func ErrRateLimited(reason string) *blossom.Error {
+	return &blossom.Error{Code: http.StatusTooManyRequests, Reason: reason}
+}
+
+// ErrTimeout returns a 504 Gateway Timeout error, for hooks whose backend (e.g. a store or an upstream server)
+// didn't respond in time.
+func ErrTimeout(reason string) *blossom.Error {
+	return &blossom.Error{Code: http.StatusGatewayTimeout, Reason: reason}
+}
+
+// quotaExceeded is the prefix of the reason of the errors returned by [ErrQuotaExceeded].
+const quotaExceeded = "storage quota exceeded"
+
+// ErrQuotaExceeded returns a 413 Content Too Large error, for Reject hooks that limit the storage of each pubkey.
+// The reason is prefixed with "storage quota exceeded", and the rejection is reported as [EventQuotaExceeded].
+func ErrQuotaExceeded(reason string) *blossom.Error {
+	if reason != "" {
+		reason = quotaExceeded + ": " + reason
+	} else {
+		reason = quotaExceeded
+	}
+	return &blossom.Error{Code: http.StatusRequestEntityTooLarge, Reason: reason}
+}
+
+// IsCode reports whether the error is, or wraps, a [blossom.Error] with the status code.
+//
+// Since [blossom.Error] values match with [errors.Is] only if both their code and reason are equal,
+// IsCode is the way to check the class of an error regardless of its reason.
+func IsCode(err error, code int) bool {
+	var e *blossom.Error
+	if errors.As(err, &e) {
+		return e != nil && e.Code == code
+	}
+	var v blossom.Error
+	if errors.As(err, &v) {
+		return v.Code == code
+	}
+	return false
+}
+
+// WrapError converts an error returned by a store or another backend into a [blossom.Error] that hooks can return.
+// Errors that are, or wrap, a [blossom.Error] are returned as they are. Otherwise:
+//   - [ErrBlobNotFound] and [fs.ErrNotExist] are mapped to 404 (Not Found).
+//   - [ErrSessionNotFound] is mapped to 404 (Not Found), and [ErrOffsetMismatch] to 409 (Conflict).
+//   - [fs.ErrPermission] is mapped to 403 (Forbidden).
+//   - [utils.ErrHashMismatch] is mapped to 400 (Bad Request).
+//   - [ErrBlobTooLarge] is mapped to 413 (Content Too Large).
+//   - [context.DeadlineExceeded] is mapped to 504 (Gateway Timeout).
+//   - Any other error is mapped to 500 (Internal Server Error).
+//
+// It returns nil if the error is nil.
+func WrapError(err error) *blossom.Error {
+	if err == nil {
+		return nil
+	}
+
+	var e *blossom.Error
+	if errors.As(err, &e) && e != nil {
+		return e
+	}
+	var v blossom.Error
+	if errors.As(err, &v) {
+		return &v
+	}
+
+	switch {
+	case errors.Is(err, ErrSessionNotFound):
+		return blossom.ErrNotFound("Upload session not found")
+
+	case errors.Is(err, ErrOffsetMismatch):
+		return &blossom.Error{Code: http.StatusConflict, Reason: err.Error()}
+
+	case errors.Is(err, ErrBlobNotFound) || errors.Is(err, fs.ErrNotExist):
+		return blossom.ErrNotFound("Blob not found")
+
+	case errors.Is(err, fs.ErrPermission):
+		return blossom.ErrForbidden(err.Error())
+
+	case errors.Is(err, utils.ErrHashMismatch):
+		return blossom.ErrBadRequest(err.Error())
+
+	case errors.Is(err, ErrBlobTooLarge):
+		return blossom.ErrTooLarge(err.Error())
+
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrTimeout(err.Error())
+
+	default:
+		return blossom.ErrInternal(err.Error())
+	}
+}
@@ -0,0 +1,218 @@
+package blossy
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+// DefaultEventsPath is the path of the event stream, if not configured. See [WithEventStream].
+const DefaultEventsPath = "/events"
+
+// eventBuffer is the number of events buffered for each subscriber of the stream.
+// Events are dropped for subscribers that fall behind.
+const eventBuffer = 64
+
+// eventKeepAlive is how often a comment is sent to the subscribers, so that proxies don't close idle streams.
+const eventKeepAlive = 30 * time.Second
+
+// EventType is the type of an [Event].
+type EventType string
+
+const (
+	// EventUpload is emitted when a blob is stored with PUT /upload, PUT /media or PUT /mirror.
+	EventUpload EventType = "upload"
+
+	// EventDelete is emitted when a blob is deleted with DELETE /<sha256>.
+	EventDelete EventType = "delete"
+
+	// EventReport is emitted when a report is accepted with PUT /report.
+	EventReport EventType = "report"
+
+	// EventReject is emitted when a request is rejected with a client error, other than 404 (Not Found).
+	EventReject EventType = "reject"
+
+	// EventQuotaExceeded is emitted when an upload is rejected with [ErrQuotaExceeded], for example by the quota package.
+	EventQuotaExceeded EventType = "quota_exceeded"
+)
+
+// Event describes an activity of the server, as sent by the event stream enabled with [WithEventStream]
+// and by the webhooks configured with [WithWebhooks].
+type Event struct {
+	Type      EventType `json:"type"`
+	Time      time.Time `json:"time"`
+	Endpoint  Endpoint  `json:"endpoint"`
+	RequestID int64     `json:"request_id"`
+	IP        string    `json:"ip"`
+
+	// Pubkey is the pubkey of the request, if authenticated.
+	Pubkey string `json:"pubkey,omitempty"`
+
+	// Hash is the hash of the blob of the request, if known.
+	Hash *blossom.Hash `json:"hash,omitempty"`
+
+	// Status and Reason are the status code and the reason of the response.
+	Status int    `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// eventStream broadcasts the events to its subscribers.
+type eventStream struct {
+	path   string
+	admins []string
+
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func newEventStream(path string, admins []string) *eventStream {
+	return &eventStream{
+		path:        path,
+		admins:      admins,
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+func (s *eventStream) subscribe() chan Event {
+	ch := make(chan Event, eventBuffer)
+	s.mu.Lock()
+	s.subscribers[ch] = struct{}{}
+	s.mu.Unlock()
+	return ch
+}
+
+func (s *eventStream) unsubscribe(ch chan Event) {
+	s.mu.Lock()
+	delete(s.subscribers, ch)
+	s.mu.Unlock()
+}
+
+// publish sends the event to all the subscribers, without blocking on the slow ones.
+func (s *eventStream) publish(e Event) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// eventOf returns the event of the response to a request to the endpoint, if any.
+func eventOf(e Endpoint, r *request, res Response, hash *blossom.Hash) (Event, bool) {
+	if e == "" {
+		return Event{}, false
+	}
+
+	event := Event{
+		Time:      time.Now(),
+		Endpoint:  e,
+		RequestID: r.id,
+		IP:        r.ip.String(),
+		Pubkey:    r.pubkey,
+		Hash:      hash,
+		Status:    res.Status,
+		Reason:    res.Reason,
+	}
+
+	method := r.raw.Method
+	switch {
+	case res.Status == http.StatusRequestEntityTooLarge && strings.HasPrefix(res.Reason, quotaExceeded):
+		event.Type = EventQuotaExceeded
+
+	case res.Status >= 400 && res.Status < 500 && res.Status != http.StatusNotFound:
+		event.Type = EventReject
+
+	case res.Status < 200 || res.Status >= 300 || method == http.MethodHead:
+		return Event{}, false
+
+	case e == EndpointUpload || e == EndpointMedia || e == EndpointMirror:
+		if res.Status != http.StatusOK {
+			// e.g. the creation of a resumable upload session
+			return Event{}, false
+		}
+		event.Type = EventUpload
+
+	case e == EndpointDelete:
+		event.Type = EventDelete
+
+	case e == EndpointReport:
+		event.Type = EventReport
+
+	default:
+		return Event{}, false
+	}
+	return event, true
+}
+
+// HandleEvents handles the event stream enabled with [WithEventStream], sending the events as Server-Sent Events,
+// whose name is the [EventType] and whose data is the [Event] encoded as JSON.
+func (s *Server) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	stream := s.settings.HTTP.events
+
+	pubkey, err := s.authenticate(r, nil)
+	if err != nil {
+		blossom.WriteError(w, blossom.ErrUnauthorized(err.Error()))
+		return
+	}
+	if pubkey == "" {
+		blossom.WriteError(w, blossom.ErrUnauthorized("authorization is required to subscribe to the events"))
+		return
+	}
+	if !slices.Contains(stream.admins, pubkey) {
+		blossom.WriteError(w, blossom.ErrForbidden("only admins can subscribe to the events"))
+		return
+	}
+
+	events := stream.subscribe()
+	defer stream.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		s.logger(r).Error("event stream: failed to flush", "error", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-keepAlive.C:
+			if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+				return
+			}
+
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				s.logger(r).Error("event stream: failed to encode event", "error", err)
+				continue
+			}
+			if _, err := w.Write([]byte("event: " + string(event.Type) + "\ndata: " + string(data) + "\n\n")); err != nil {
+				return
+			}
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -2,35 +2,30 @@ package main
 
 import (
 	"context"
-	"log/slog"
 	"os"
 	"os/signal"
-	"slices"
-	"sync"
+	"time"
 
 	"github.com/pippellia-btc/blossom"
 	"github.com/pippellia-btc/blossy"
 )
 
-// the list of banned IPs, protected by mu
-var (
-	blacklist []string
-	mu        sync.RWMutex
-)
-
 func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
 	defer cancel()
 
 	blossom, err := blossy.NewServer(
 		blossy.WithHostname("example.com"),
+		blossy.WithIPPolicy(blossy.IPPolicy{
+			// a single rejected request gets the IP banned for a day
+			Ban: &blossy.BanPolicy{Threshold: 1, Window: time.Minute, Duration: 24 * time.Hour},
+		}),
 	)
 	if err != nil {
 		panic(err)
 	}
 
 	blossom.Reject.Download.Append(IsWord)
-	blossom.Reject.Upload.Append(BadIP)
 
 	err = blossom.StartAndServe(ctx, "localhost:3335")
 	if err != nil {
@@ -38,25 +33,8 @@ func main() {
 	}
 }
 
-func BadIP(r blossy.Request, hints blossy.UploadHints) *blossom.Error {
-	mu.RLock()
-	defer mu.RUnlock()
-
-	if slices.Contains(blacklist, r.IP().Group()) {
-		return blossom.ErrForbidden("you shall not pass!")
-	}
-	return nil
-}
-
 func IsWord(r blossy.Request, hash blossom.Hash, ext string) *blossom.Error {
 	if ext == "docx" || ext == "doc" {
-		ip := r.IP().Group()
-		slog.Info("blacklisting", "IP", ip)
-
-		mu.Lock()
-		defer mu.Unlock()
-
-		blacklist = append(blacklist, ip)
 		return blossom.ErrUnsupportedMedia("We don't like Microsoft")
 	}
 	return nil
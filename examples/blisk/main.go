@@ -28,6 +28,7 @@ func main() {
 
 	blossom, err := blossy.NewServer(
 		blossy.WithHostname("example.com"),
+		blossy.WithHandlerTimeout(30*time.Second),
 	)
 	if err != nil {
 		panic(err)
@@ -45,10 +46,7 @@ func main() {
 }
 
 func LoadBlob(r blossy.Request, hash blossom.Hash, ext string) (blossy.BlobDelivery, *blossom.Error) {
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
-
-	file, err := store.Load(ctx, hash)
+	file, err := store.Load(r.Context(), hash)
 	if errors.Is(err, blisk.ErrNotFound) {
 		return nil, blossom.ErrNotFound("Blob not found")
 	}
@@ -56,7 +54,7 @@ func LoadBlob(r blossy.Request, hash blossom.Hash, ext string) (blossy.BlobDeliv
 		return nil, blossom.ErrInternal(err.Error())
 	}
 
-	blob, err := blossom.BlobFromFile(file)
+	blob, err := blossy.BlobFromFile(file)
 	if err != nil {
 		return nil, blossom.ErrInternal(err.Error())
 	}
@@ -64,10 +62,7 @@ func LoadBlob(r blossy.Request, hash blossom.Hash, ext string) (blossy.BlobDeliv
 }
 
 func LoadMeta(r blossy.Request, hash blossom.Hash, ext string) (blossy.MetaDelivery, *blossom.Error) {
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
-
-	meta, err := store.Info(ctx, hash)
+	meta, err := store.Info(r.Context(), hash)
 	if errors.Is(err, blisk.ErrNotFound) {
 		return nil, blossom.ErrNotFound("Blob not found")
 	}
@@ -79,10 +74,7 @@ func LoadMeta(r blossy.Request, hash blossom.Hash, ext string) (blossy.MetaDeliv
 }
 
 func SaveBlob(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
-
-	meta, err := store.Save(ctx, data, r.Pubkey())
+	meta, err := store.Save(r.Context(), data, r.Pubkey())
 	if err != nil {
 		return blossom.BlobDescriptor{}, blossom.ErrInternal(err.Error())
 	}
@@ -96,10 +88,7 @@ func SaveBlob(r blossy.Request, hints blossy.UploadHints, data io.Reader) (bloss
 }
 
 func DeleteBlob(r blossy.Request, hash blossom.Hash) *blossom.Error {
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
-
-	err := store.Delete(ctx, hash, r.Pubkey())
+	err := store.Delete(r.Context(), hash, r.Pubkey())
 	if errors.Is(err, blisk.ErrNotFound) {
 		return blossom.ErrNotFound("Blob not found")
 	}
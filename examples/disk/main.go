@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/stores/disk"
+)
+
+/*
+This example shows how to bind a storage backend to the server, which sets
+the On hooks for download, check, upload, delete and list in a single call.
+*/
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+	defer cancel()
+
+	store, err := disk.New(".blossom")
+	if err != nil {
+		panic(err)
+	}
+
+	blossom, err := blossy.NewServer(
+		blossy.WithHostname("example.com"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	blossy.BindStore(blossom, store)
+
+	err = blossom.StartAndServe(ctx, "localhost:3335")
+	if err != nil {
+		panic(err)
+	}
+}
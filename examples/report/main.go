@@ -9,20 +9,23 @@ import (
 
 	"github.com/pippellia-btc/blossom"
 	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/reports"
 )
 
 /*
 This example shows how to deal with BUD-09 reports.
 A report from one of the moderators will delete all the blobs it reference.
 A report from a non-moderator will be saved for the operator to review and take action manually.
+
+The reports are persisted with the reports package, whose moderation queue lists the most reported blobs.
+In production you would use reports.NewSQL instead of the in-memory store.
 */
 
 // a slice of pubkeys that act as moderators for the blossom server.
 var moderators []string
 
-// a slice of reports to be reviews manually by the server operator.
-// In production you would use a database instead.
-var toReview []blossy.Report
+// the reports to be reviewed manually by the server operator.
+var toReview = reports.NewMemory()
 
 func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
@@ -35,7 +38,8 @@ func main() {
 		panic(err)
 	}
 
-	blossom.On.Report = DeleteOrNotify
+	blossom.On.Report = DeleteModerated
+	reports.Bind(blossom, toReview)
 
 	err = blossom.StartAndServe(ctx, "localhost:3335")
 	if err != nil {
@@ -43,13 +47,18 @@ func main() {
 	}
 }
 
-func DeleteOrNotify(r blossy.Request, report blossy.Report) *blossom.Error {
-	if slices.Contains(moderators, report.Pubkey) {
-		hashes := report.Hashes()
-		slog.Info("deleting blobs", "hashes", hashes)
+// DeleteModerated is called after the report has been stored.
+func DeleteModerated(r blossy.Request, report blossy.Report) *blossom.Error {
+	if !slices.Contains(moderators, report.Pubkey) {
+		slog.Info("new report to review", "report", report)
+		return nil
 	}
 
-	toReview = append(toReview, report)
-	slog.Info("new report to review", "report", report)
+	for _, hash := range report.Hashes() {
+		slog.Info("deleting blob", "hash", hash)
+		if _, err := toReview.Resolve(r.Context(), hash, reports.StatusRemoved, "deleted by a moderator"); err != nil {
+			return blossom.ErrInternal(err.Error())
+		}
+	}
 	return nil
 }
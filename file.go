@@ -0,0 +1,86 @@
+package blossy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+// fileBlob is a [blossom.Blob] backed by a file. It exposes the methods of the file, so that
+// the server can recognize it and let the kernel copy it to the connection (e.g. with sendfile).
+type fileBlob struct {
+	*os.File
+	size int64
+	typ  string
+}
+
+func (b fileBlob) Size() int64  { return b.size }
+func (b fileBlob) Type() string { return b.typ }
+
+// BlobFromFile creates a Blob from the given file, detecting its size and content type.
+//
+// Unlike [blossom.BlobFromFile], the returned blob keeps the file accessible to the server, which then serves it
+// without copying it through userspace (e.g. with sendfile), and uses its modification time for
+// the 'Last-Modified' header and conditional requests. Stores that keep blobs in files should use it.
+func BlobFromFile(f *os.File) (blossom.Blob, error) {
+	if f == nil {
+		return nil, errors.New("file is nil")
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	typ, err := blossom.DetectType(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blob from file: %w", err)
+	}
+	return fileBlob{File: f, size: info.Size(), typ: typ}, nil
+}
+
+// fileBacked is implemented by blobs backed by a file, such as the ones created with [BlobFromFile].
+type fileBacked interface {
+	blossom.Blob
+	io.Seeker
+	Stat() (fs.FileInfo, error)
+}
+
+// serveFile writes the blob backed by a file to the response. The file is passed as is to the response writer,
+// which copies it to the connection with sendfile when possible.
+// Range requests are handled with [http.ServeContent] only if enabled with [WithRangeSupport].
+// The 'Last-Modified' header is the modification time of the file, unless modTime is not zero.
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, blob fileBacked, modTime time.Time) error {
+	info, err := blob.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	if modTime.IsZero() {
+		modTime = info.ModTime()
+	}
+
+	w.Header().Set("Content-Type", blob.Type())
+	if s.settings.HTTP.acceptRanges {
+		http.ServeContent(w, r, "", modTime, blob)
+		return nil
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(blob.Size(), 10))
+	w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+
+	written, err := io.Copy(w, blob)
+	if err != nil {
+		return err
+	}
+	if written != blob.Size() {
+		return fmt.Errorf("copied size mismatch: expected %d, wrote %d", blob.Size(), written)
+	}
+	return nil
+}
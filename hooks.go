@@ -4,6 +4,7 @@ import (
 	"io"
 	"log/slog"
 	"net/url"
+	"slices"
 
 	"github.com/pippellia-btc/blossom"
 )
@@ -12,12 +13,14 @@ import (
 type Hooks struct {
 	Reject RejectHooks
 	On     OnHooks
+	After  AfterHooks
 }
 
 func DefaultHooks() Hooks {
 	return Hooks{
 		Reject: RejectHooks{},
 		On:     NewOnHooks(),
+		After:  AfterHooks{},
 	}
 }
 
@@ -40,17 +43,39 @@ type RejectHooks struct {
 	Delete slice[func(r Request, hash blossom.Hash) *blossom.Error]
 
 	// Upload is invoked when processing the HEAD /upload and before processing every PUT /upload request.
+	// The [UploadHints.PreCheck] flag distinguishes the HEAD requests (BUD-06), for which no blob is uploaded,
+	// so that expensive checks can be skipped or made more lenient.
+	// As per BUD-06, return 401 (Unauthorized) when authorization is missing, 403 (Forbidden) when the pubkey
+	// is not allowed to upload, 413 (Content Too Large) for blobs that are too large, and 415 (Unsupported Media Type)
+	// for blobs with a type that is not accepted. The reason of the error is sent to the client in the 'X-Reason' header.
+	// Learn more here: https://github.com/hzrd149/blossom/blob/master/buds/06.md
 	Upload slice[func(r Request, hints UploadHints) *blossom.Error]
 
+	// UploadContent is invoked while the blob of a PUT /upload request is streamed to the On.Upload hook,
+	// as soon as its first bytes have been read (see [WithInspectionSize]), or all of them if the blob is smaller.
+	// It's useful to reject blobs by their magic numbers, which can't be trusted from the declared type.
+	// When it rejects the upload, reading the data in the On.Upload hook fails, and the server responds
+	// with the error and closes the connection, without reading the rest of the body.
+	UploadContent slice[func(r Request, hints UploadHints, head []byte) *blossom.Error]
+
 	// Mirror is invoked before processing a PUT /mirror request.
 	// The url has been previously validated to be a non-nil HTTPS URL with a valid blossom hash in its path.
 	Mirror slice[func(r Request, url *url.URL) *blossom.Error]
 
 	// Media is invoked when processing the HEAD /media and before processing every PUT /media request.
+	// As with the Upload hooks, the [UploadHints.PreCheck] flag distinguishes the HEAD requests.
 	Media slice[func(r Request, hints UploadHints) *blossom.Error]
 
+	// MediaContent is invoked while the blob of a PUT /media request is streamed to the On.Media hook,
+	// as soon as its first bytes have been read. It works like the UploadContent hooks.
+	MediaContent slice[func(r Request, hints UploadHints, head []byte) *blossom.Error]
+
 	// Report is invoked before processing a PUT /report request.
 	Report slice[func(r Request, report Report) *blossom.Error]
+
+	// List is invoked before processing a GET /list/<pubkey> request.
+	// The pubkey has been previously validated to be 64 lowercase hex characters.
+	List slice[func(r Request, pubkey string, query ListQuery) *blossom.Error]
 }
 
 // OnHooks defines functions invoked after specific blossom events occur.
@@ -78,6 +103,9 @@ type OnHooks struct {
 	// Upload handles the core logic for PUT /upload as per BUD-02.
 	// If the returned blob descriptor has an empty URL, the server will automatically derive it from the
 	// hostname, the hash and the type of the blob.
+	// If [WithUploadVerification] is used, reading the data returns an error when its hash doesn't match the hints.
+	// If [WithMaxUploadSize] is used, reading more than the maximum size returns [ErrBlobTooLarge].
+	// If [WithMetadataStripping] is used, the hook receives the images without their metadata.
 	// This hook is optional. If not specified, the endpoint will return the http status code 501 (Not Implemented).
 	// Learn more here: https://github.com/hzrd149/blossom/blob/master/buds/02.md
 	Upload func(r Request, hints UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error)
@@ -93,6 +121,10 @@ type OnHooks struct {
 	// Media handles the core logic for PUT /media as per BUD-05.
 	// If the returned blob descriptor has an empty URL, the server will automatically derive it from the
 	// hostname, the hash and the type of the blob.
+	// If [WithUploadVerification] is used, reading the data returns an error when its hash doesn't match the hints.
+	// If [WithMaxUploadSize] is used, reading more than the maximum size returns [ErrBlobTooLarge].
+	// If [WithMetadataStripping] is used, the hook receives the images without their metadata.
+	// If [WithMediaProcessor] is used, the hook receives the transformed blob and its hints.
 	// This hook is optional. If not specified, the endpoint will return the http status code 501 (Not Implemented).
 	// Learn more here: https://github.com/hzrd149/blossom/blob/master/buds/05.md
 	Media func(r Request, hints UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error)
@@ -101,6 +133,88 @@ type OnHooks struct {
 	// This hook is optional. If not specified, the endpoint will return the http status code 501 (Not Implemented).
 	// Learn more here: https://github.com/hzrd149/blossom/blob/master/buds/09.md
 	Report func(r Request, report Report) *blossom.Error
+
+	// List handles the core logic for GET /list/<pubkey> as per BUD-02.
+	// The pubkey has been previously validated to be 64 lowercase hex characters.
+	// If any of the returned blob descriptors has an empty URL, the server will automatically derive it from the
+	// hostname, the hash and the type of the blob.
+	// The hook must return the page of blobs selected by the query, for example with [ListQuery.Apply].
+	// When the page is full, the server links the next one in the 'Link' header of the response.
+	// This hook is optional. If not specified, the endpoint will return the http status code 501 (Not Implemented).
+	// Learn more here: https://github.com/hzrd149/blossom/blob/master/buds/02.md
+	List func(r Request, pubkey string, query ListQuery) ([]blossom.BlobDescriptor, *blossom.Error)
+
+	// Stats handles GET /list/<pubkey>/stats, returning the number, the total size and the last upload time
+	// of the blobs of the pubkey, for example to show the storage usage in client UIs.
+	// The requests go through the policies and the Reject hooks of the List endpoint, with an empty query.
+	// The pubkey has been previously validated to be 64 lowercase hex characters.
+	// This hook is optional. If not specified, the endpoint will return the http status code 501 (Not Implemented).
+	Stats func(r Request, pubkey string) (ListStats, *blossom.Error)
+
+	// NIP94 returns the NIP-94 metadata of a blob stored with PUT /upload, PUT /media or PUT /mirror,
+	// which is added to the 'nip94' field of the returned blob descriptor as per BUD-08.
+	// It's invoked after the corresponding hook succeeded, with the final descriptor. Return nil to omit the field.
+	// This hook is optional. If not specified, the 'nip94' field is omitted.
+	// Learn more here: https://github.com/hzrd149/blossom/blob/master/buds/08.md
+	NIP94 func(r Request, desc blossom.BlobDescriptor) *FileMetadata
+}
+
+// AfterHooks defines optional functions invoked after the response to a request has been written,
+// with its final status code, size and duration.
+//
+// They are invoked for every request to the corresponding endpoint, including the ones that have been
+// rejected or that failed. If the request failed before its authorization was parsed, the Request is not authenticated.
+//
+// AfterHooks are typically used to build audit logs and analytics.
+// They are invoked synchronously, so they should return quickly.
+type AfterHooks struct {
+	// Download is invoked after responding to a GET /<hash>.<ext> request.
+	Download slice[func(r Request, res Response)]
+
+	// Check is invoked after responding to a HEAD /<hash>.<ext> request.
+	Check slice[func(r Request, res Response)]
+
+	// Delete is invoked after responding to a DELETE /<hash> request.
+	Delete slice[func(r Request, res Response)]
+
+	// Upload is invoked after responding to a PUT /upload or HEAD /upload request.
+	Upload slice[func(r Request, res Response)]
+
+	// Mirror is invoked after responding to a PUT /mirror request.
+	Mirror slice[func(r Request, res Response)]
+
+	// Media is invoked after responding to a PUT /media or HEAD /media request.
+	Media slice[func(r Request, res Response)]
+
+	// Report is invoked after responding to a PUT /report request.
+	Report slice[func(r Request, res Response)]
+
+	// List is invoked after responding to a GET /list/<pubkey> request.
+	List slice[func(r Request, res Response)]
+}
+
+// of returns the hooks of the endpoint.
+func (a AfterHooks) of(e Endpoint) []func(r Request, res Response) {
+	switch e {
+	case EndpointDownload:
+		return a.Download.hooks
+	case EndpointCheck:
+		return a.Check.hooks
+	case EndpointDelete:
+		return a.Delete.hooks
+	case EndpointUpload:
+		return a.Upload.hooks
+	case EndpointMirror:
+		return a.Mirror.hooks
+	case EndpointMedia:
+		return a.Media.hooks
+	case EndpointReport:
+		return a.Report.hooks
+	case EndpointList:
+		return a.List.hooks
+	default:
+		return nil
+	}
 }
 
 func NewOnHooks() OnHooks {
@@ -111,29 +225,132 @@ func NewOnHooks() OnHooks {
 }
 
 func defaultDownload(r Request, hash blossom.Hash, ext string) (BlobDelivery, *blossom.Error) {
-	slog.Info("received GET request", "hash", hash.Hex(), "ext", ext, "ip", r.IP().Group())
+	slog.Info("received GET request", "request_id", r.ID(), "hash", hash.Hex(), "ext", ext, "ip", r.IP().Group())
 	return nil, blossom.ErrNotFound("The Download hook is not configured")
 }
 
 func defaultCheck(r Request, hash blossom.Hash, ext string) (MetaDelivery, *blossom.Error) {
-	slog.Info("received HEAD request", "hash", hash.Hex(), "ext", ext, "ip", r.IP().Group())
+	slog.Info("received HEAD request", "request_id", r.ID(), "hash", hash.Hex(), "ext", ext, "ip", r.IP().Group())
 	return nil, blossom.ErrNotFound("The Check hook is not configured")
 }
 
+// HookInfo describes a registered hook, as returned by the List method of the hooks.
+type HookInfo struct {
+	// Name of the hook, which is empty for the hooks added with Append or Prepend.
+	Name string
+
+	// Priority of the hook. Hooks with a lower priority run first.
+	Priority int
+}
+
 // Slice is an internal type used to simplify registration of hooks.
-type slice[T any] []T
+//
+// Hooks run in order of priority, the lowest first, and hooks with the same priority run in the order they were added.
+// Named hooks, registered with Register, can be removed and replaced by name, so that the hooks of different packages
+// (e.g. plugins) can coexist deterministically.
+type slice[T any] struct {
+	entries []hookEntry[T]
+
+	// hooks are the functions of the entries, in order of execution.
+	hooks []T
+}
+
+type hookEntry[T any] struct {
+	HookInfo
+	hook T
+}
 
 // Append adds hooks to the end of the slice, in the provided order.
+// They take the priority of the last hook, or 0 if the slice is empty.
 func (s *slice[T]) Append(hooks ...T) {
-	*s = append(*s, hooks...)
+	priority := 0
+	if n := len(s.entries); n > 0 {
+		priority = s.entries[n-1].Priority
+	}
+
+	for _, hook := range hooks {
+		s.entries = append(s.entries, hookEntry[T]{HookInfo: HookInfo{Priority: priority}, hook: hook})
+	}
+	s.sync()
 }
 
 // Prepend adds hooks to the start of the slice, in the provided order.
+// They take the priority of the first hook, or 0 if the slice is empty.
 func (s *slice[T]) Prepend(hooks ...T) {
-	*s = append(hooks, *s...)
+	priority := 0
+	if len(s.entries) > 0 {
+		priority = s.entries[0].Priority
+	}
+
+	entries := make([]hookEntry[T], 0, len(hooks)+len(s.entries))
+	for _, hook := range hooks {
+		entries = append(entries, hookEntry[T]{HookInfo: HookInfo{Priority: priority}, hook: hook})
+	}
+	s.entries = append(entries, s.entries...)
+	s.sync()
+}
+
+// Register adds a named hook with the priority, after all the hooks with a lower or equal priority.
+// If a hook with the same name is already registered, it is replaced. An empty name is like Append with the priority.
+func (s *slice[T]) Register(name string, priority int, hook T) {
+	if name != "" {
+		s.remove(name)
+	}
+
+	i := 0
+	for i < len(s.entries) && s.entries[i].Priority <= priority {
+		i++
+	}
+
+	entry := hookEntry[T]{HookInfo: HookInfo{Name: name, Priority: priority}, hook: hook}
+	s.entries = slices.Insert(s.entries, i, entry)
+	s.sync()
+}
+
+// Remove removes the hook registered with the name, and reports whether it was found.
+func (s *slice[T]) Remove(name string) bool {
+	if name == "" || !s.remove(name) {
+		return false
+	}
+	s.sync()
+	return true
+}
+
+func (s *slice[T]) remove(name string) bool {
+	i := slices.IndexFunc(s.entries, func(e hookEntry[T]) bool { return e.Name == name })
+	if i == -1 {
+		return false
+	}
+	s.entries = slices.Delete(s.entries, i, i+1)
+	return true
+}
+
+// List returns the name and priority of the hooks, in order of execution.
+func (s *slice[T]) List() []HookInfo {
+	infos := make([]HookInfo, len(s.entries))
+	for i, e := range s.entries {
+		infos[i] = e.HookInfo
+	}
+	return infos
+}
+
+// Len returns the number of hooks.
+func (s *slice[T]) Len() int {
+	return len(s.entries)
 }
 
 // Clear resets the slice, removing all registered hooks.
 func (s *slice[T]) Clear() {
-	*s = nil
+	s.entries = nil
+	s.hooks = nil
+}
+
+// sync rebuilds the hooks from the entries. It allocates a new slice, so that the hooks
+// previously returned are not modified.
+func (s *slice[T]) sync() {
+	hooks := make([]T, len(s.entries))
+	for i, e := range s.entries {
+		hooks[i] = e.hook
+	}
+	s.hooks = hooks
 }
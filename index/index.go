@@ -0,0 +1,575 @@
+// Package index records the metadata of the blobs stored by a blossy server in a SQL database, such as SQLite or Postgres:
+// their hash, size, type and first upload time, and which pubkeys uploaded them and when.
+//
+// The number of pubkeys that own a blob is its reference count. Blobs with no owners left are unreferenced,
+// and can be garbage collected from the storage with [Index.Unreferenced] and [Index.Drop].
+// [Index.Bind] keeps the index in sync with the hooks of a server, and uses it to answer the /list endpoint.
+//
+// Example:
+//
+//	db, err := sql.Open("sqlite3", "index.db")
+//	if err != nil {
+//	    panic(err)
+//	}
+//
+//	idx, err := index.New(ctx, db)
+//	if err != nil {
+//	    panic(err)
+//	}
+//
+//	blossy.BindStore(server, store)
+//	idx.Bind(server)
+package index
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+var (
+	// ErrNotFound is returned when the blob is not in the index, or it's not owned by the pubkey.
+	ErrNotFound = errors.New("index: blob not found")
+
+	// ErrReferenced is returned by [Index.Drop] when the blob is still owned by some pubkey.
+	ErrReferenced = errors.New("index: blob is still referenced")
+)
+
+// Blob is the metadata of a blob in the index.
+type Blob struct {
+	Hash blossom.Hash
+	Size int64
+
+	// Type is the media type of the blob, lowercase and without parameters (e.g. "text/plain"),
+	// or empty if the blob had no valid type when it was added.
+	Type string
+
+	// Uploaded is the unix time of the first upload of the blob.
+	Uploaded int64
+
+	// Refs is the number of pubkeys that own the blob.
+	Refs int
+}
+
+// Stats summarizes the content of the index.
+type Stats struct {
+	Blobs        int64 // number of blobs
+	Bytes        int64 // total size of the blobs
+	Owners       int64 // number of distinct pubkeys owning at least one blob
+	Unreferenced int64 // number of blobs with no owners
+}
+
+// Index records the metadata of the blobs in a SQL database. Create one with [New].
+// It's safe for concurrent use.
+type Index struct {
+	db           *sql.DB
+	prefix       string
+	placeholders bool
+}
+
+type Option func(*Index)
+
+// WithTablePrefix sets the prefix of the names of the tables used by the index. By default, it's "blossy_".
+func WithTablePrefix(prefix string) Option {
+	return func(i *Index) {
+		i.prefix = prefix
+	}
+}
+
+// WithNumberedPlaceholders makes the queries use numbered placeholders ($1, $2, ...) instead of '?',
+// as required by Postgres drivers.
+func WithNumberedPlaceholders() Option {
+	return func(i *Index) {
+		i.placeholders = true
+	}
+}
+
+var validPrefix = regexp.MustCompile(`^[A-Za-z0-9_]*$`)
+
+// New returns an [Index] using the database, creating its tables if they don't exist.
+// The driver of the database must be imported by the caller.
+func New(ctx context.Context, db *sql.DB, opts ...Option) (*Index, error) {
+	if db == nil {
+		return nil, errors.New("index: database must not be nil")
+	}
+
+	i := &Index{
+		db:     db,
+		prefix: "blossy_",
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+
+	if !validPrefix.MatchString(i.prefix) {
+		return nil, fmt.Errorf("index: invalid table prefix %q", i.prefix)
+	}
+
+	schema := []string{
+		`CREATE TABLE IF NOT EXISTS {blobs} (
+			hash TEXT PRIMARY KEY,
+			size BIGINT NOT NULL,
+			type TEXT NOT NULL,
+			uploaded BIGINT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS {owners} (
+			pubkey TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			uploaded BIGINT NOT NULL,
+			PRIMARY KEY (pubkey, hash)
+		)`,
+		`CREATE INDEX IF NOT EXISTS {owners}_hash ON {owners} (hash)`,
+		`CREATE TABLE IF NOT EXISTS {references} (
+			hash TEXT PRIMARY KEY,
+			seen BIGINT NOT NULL
+		)`,
+	}
+
+	for _, stmt := range schema {
+		if _, err := db.ExecContext(ctx, i.query(stmt)); err != nil {
+			return nil, fmt.Errorf("index: failed to create the schema: %w", err)
+		}
+	}
+	return i, nil
+}
+
+// query replaces the table names in the query, and the '?' placeholders with numbered ones if configured.
+func (i *Index) query(q string) string {
+	q = strings.NewReplacer(
+		"{blobs}", i.prefix+"blobs",
+		"{owners}", i.prefix+"owners",
+		"{references}", i.prefix+"references",
+	).Replace(q)
+	if !i.placeholders {
+		return q
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range q {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Add records that the pubkey uploaded the blob of the descriptor. The pubkey is empty for unauthenticated uploads.
+// If the descriptor has no upload time, the current time is used. Its type is normalized to the lowercase
+// media type without parameters, so that [Index.List] filters the types like [blossy.ListQuery.Match].
+// Adding a blob that the pubkey already owns is a no-op.
+func (i *Index) Add(ctx context.Context, pubkey string, desc blossom.BlobDescriptor) error {
+	uploaded := desc.Uploaded
+	if uploaded == 0 {
+		uploaded = time.Now().Unix()
+	}
+
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("index: failed to add the blob: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, i.query(`INSERT INTO {blobs} (hash, size, type, uploaded) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING`),
+		desc.Hash.Hex(), desc.Size, utils.MediaType(desc.Type), uploaded)
+	if err != nil {
+		return fmt.Errorf("index: failed to add the blob: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, i.query(`INSERT INTO {owners} (pubkey, hash, uploaded) VALUES (?, ?, ?) ON CONFLICT DO NOTHING`),
+		pubkey, desc.Hash.Hex(), uploaded)
+	if err != nil {
+		return fmt.Errorf("index: failed to add the owner: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("index: failed to add the blob: %w", err)
+	}
+	return nil
+}
+
+// Remove records that the pubkey no longer owns the blob, and returns the number of pubkeys that still own it.
+// The blob stays in the index, unreferenced, until it's dropped with [Index.Drop].
+// It returns [ErrNotFound] if the blob is not owned by the pubkey.
+func (i *Index) Remove(ctx context.Context, pubkey string, hash blossom.Hash) (refs int, err error) {
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("index: failed to remove the owner: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, i.query(`DELETE FROM {owners} WHERE pubkey = ? AND hash = ?`), pubkey, hash.Hex())
+	if err != nil {
+		return 0, fmt.Errorf("index: failed to remove the owner: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return 0, ErrNotFound
+	}
+
+	if err := tx.QueryRowContext(ctx, i.query(`SELECT COUNT(*) FROM {owners} WHERE hash = ?`), hash.Hex()).Scan(&refs); err != nil {
+		return 0, fmt.Errorf("index: failed to count the owners: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("index: failed to remove the owner: %w", err)
+	}
+	return refs, nil
+}
+
+// Get returns the metadata of the blob, or [ErrNotFound].
+func (i *Index) Get(ctx context.Context, hash blossom.Hash) (Blob, error) {
+	blob := Blob{Hash: hash}
+	err := i.db.QueryRowContext(ctx, i.query(`
+		SELECT b.size, b.type, b.uploaded, (SELECT COUNT(*) FROM {owners} o WHERE o.hash = b.hash)
+		FROM {blobs} b WHERE b.hash = ?`), hash.Hex()).Scan(&blob.Size, &blob.Type, &blob.Uploaded, &blob.Refs)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return Blob{}, ErrNotFound
+	}
+	if err != nil {
+		return Blob{}, fmt.Errorf("index: failed to get the blob: %w", err)
+	}
+	return blob, nil
+}
+
+// Blobs returns the metadata of all the blobs in the index, sorted by upload time, oldest first.
+func (i *Index) Blobs(ctx context.Context) ([]Blob, error) {
+	rows, err := i.db.QueryContext(ctx, i.query(`
+		SELECT b.hash, b.size, b.type, b.uploaded, (SELECT COUNT(*) FROM {owners} o WHERE o.hash = b.hash)
+		FROM {blobs} b ORDER BY b.uploaded, b.hash`))
+	if err != nil {
+		return nil, fmt.Errorf("index: failed to query the blobs: %w", err)
+	}
+	defer rows.Close()
+
+	var blobs []Blob
+	for rows.Next() {
+		var hex string
+		var blob Blob
+		if err := rows.Scan(&hex, &blob.Size, &blob.Type, &blob.Uploaded, &blob.Refs); err != nil {
+			return nil, fmt.Errorf("index: failed to scan the blob: %w", err)
+		}
+
+		blob.Hash, err = blossom.ParseHash(hex)
+		if err != nil {
+			return nil, fmt.Errorf("index: invalid hash in the index: %w", err)
+		}
+		blobs = append(blobs, blob)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("index: failed to query the blobs: %w", err)
+	}
+	return blobs, nil
+}
+
+// Owners returns the pubkeys that own the blob, sorted by upload time, oldest first.
+func (i *Index) Owners(ctx context.Context, hash blossom.Hash) ([]string, error) {
+	rows, err := i.db.QueryContext(ctx, i.query(`SELECT pubkey FROM {owners} WHERE hash = ? ORDER BY uploaded, pubkey`), hash.Hex())
+	if err != nil {
+		return nil, fmt.Errorf("index: failed to query the owners: %w", err)
+	}
+	defer rows.Close()
+
+	var owners []string
+	for rows.Next() {
+		var pubkey string
+		if err := rows.Scan(&pubkey); err != nil {
+			return nil, fmt.Errorf("index: failed to scan the owner: %w", err)
+		}
+		owners = append(owners, pubkey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("index: failed to query the owners: %w", err)
+	}
+	return owners, nil
+}
+
+// List returns the page of the descriptors of the blobs owned by the pubkey selected by the query,
+// sorted by upload time, newest first unless the query specifies otherwise. The URL of the descriptors is empty.
+func (i *Index) List(ctx context.Context, pubkey string, query blossy.ListQuery) ([]blossom.BlobDescriptor, error) {
+	q := `SELECT b.hash, b.size, b.type, o.uploaded FROM {owners} o JOIN {blobs} b ON b.hash = o.hash WHERE o.pubkey = ?`
+	args := []any{pubkey}
+
+	if !query.Since.IsZero() {
+		q += ` AND o.uploaded >= ?`
+		args = append(args, query.Since.Unix())
+	}
+	if !query.Until.IsZero() {
+		q += ` AND o.uploaded <= ?`
+		args = append(args, query.Until.Unix())
+	}
+
+	if len(query.Types) > 0 {
+		// the types are normalized by [Index.Add], and blobs without a valid type match no pattern
+		var conds []string
+		for _, pattern := range query.Types {
+			pattern = strings.ToLower(pattern)
+			switch prefix, ok := strings.CutSuffix(pattern, "/*"); {
+			case pattern == "*/*":
+				conds = append(conds, `b.type <> ''`)
+			case ok:
+				conds = append(conds, `b.type LIKE ? ESCAPE '\'`)
+				args = append(args, escapeLike(prefix)+"/%")
+			default:
+				conds = append(conds, `b.type = ?`)
+				args = append(args, pattern)
+			}
+		}
+		q += ` AND (` + strings.Join(conds, " OR ") + `)`
+	}
+
+	order, after := "DESC", "<"
+	if query.Order == blossy.Oldest {
+		order, after = "ASC", ">"
+	}
+
+	if query.Cursor != nil {
+		var uploaded int64
+		row := i.db.QueryRowContext(ctx, i.query(`SELECT uploaded FROM {owners} WHERE pubkey = ? AND hash = ?`), pubkey, query.Cursor.Hex())
+		err := row.Scan(&uploaded)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil // the cursor is not among the blobs, see [blossy.ListQuery.Apply]
+		}
+		if err != nil {
+			return nil, fmt.Errorf("index: failed to query the cursor: %w", err)
+		}
+
+		q += ` AND (o.uploaded ` + after + ` ? OR (o.uploaded = ? AND b.hash > ?))`
+		args = append(args, uploaded, uploaded, query.Cursor.Hex())
+	}
+
+	q += ` ORDER BY o.uploaded ` + order + `, b.hash`
+	if query.Limit > 0 {
+		q += ` LIMIT ?`
+		args = append(args, query.Limit)
+	}
+
+	rows, err := i.db.QueryContext(ctx, i.query(q), args...)
+	if err != nil {
+		return nil, fmt.Errorf("index: failed to list the blobs: %w", err)
+	}
+	defer rows.Close()
+
+	var descs []blossom.BlobDescriptor
+	for rows.Next() {
+		var hex string
+		var desc blossom.BlobDescriptor
+		if err := rows.Scan(&hex, &desc.Size, &desc.Type, &desc.Uploaded); err != nil {
+			return nil, fmt.Errorf("index: failed to scan the blob: %w", err)
+		}
+
+		desc.Hash, err = blossom.ParseHash(hex)
+		if err != nil {
+			return nil, fmt.Errorf("index: invalid hash in the index: %w", err)
+		}
+		descs = append(descs, desc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("index: failed to list the blobs: %w", err)
+	}
+	return descs, nil
+}
+
+// escapeLike escapes the metacharacters of a LIKE pattern, which uses '\' as escape character.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// PubkeyStats returns the number, the total size and the last upload time of the blobs owned by the pubkey.
+func (i *Index) PubkeyStats(ctx context.Context, pubkey string) (blossy.ListStats, error) {
+	q := `SELECT COUNT(*), COALESCE(SUM(b.size), 0), COALESCE(MAX(o.uploaded), 0)
+		FROM {owners} o JOIN {blobs} b ON b.hash = o.hash WHERE o.pubkey = ?`
+
+	var stats blossy.ListStats
+	err := i.db.QueryRowContext(ctx, i.query(q), pubkey).Scan(&stats.Blobs, &stats.Bytes, &stats.LastUpload)
+	if err != nil {
+		return blossy.ListStats{}, fmt.Errorf("index: failed to compute the stats: %w", err)
+	}
+	return stats, nil
+}
+
+// Unreferenced returns up to limit blobs that no pubkey owns, which can be deleted from the storage
+// and then dropped from the index with [Index.Drop].
+func (i *Index) Unreferenced(ctx context.Context, limit int) ([]blossom.Hash, error) {
+	rows, err := i.db.QueryContext(ctx, i.query(`
+		SELECT b.hash FROM {blobs} b
+		WHERE NOT EXISTS (SELECT 1 FROM {owners} o WHERE o.hash = b.hash)
+		ORDER BY b.uploaded LIMIT ?`), limit)
+	if err != nil {
+		return nil, fmt.Errorf("index: failed to query the unreferenced blobs: %w", err)
+	}
+	defer rows.Close()
+
+	var hashes []blossom.Hash
+	for rows.Next() {
+		var hex string
+		if err := rows.Scan(&hex); err != nil {
+			return nil, fmt.Errorf("index: failed to scan the blob: %w", err)
+		}
+
+		hash, err := blossom.ParseHash(hex)
+		if err != nil {
+			return nil, fmt.Errorf("index: invalid hash in the index: %w", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("index: failed to query the unreferenced blobs: %w", err)
+	}
+	return hashes, nil
+}
+
+// MarkReferenced records that a nostr event referencing the blob has been seen now, for example by a scanner of relays.
+// Hashes of blobs not in the index are ignored.
+func (i *Index) MarkReferenced(ctx context.Context, hash blossom.Hash) error {
+	_, err := i.db.ExecContext(ctx, i.query(`
+		INSERT INTO {references} (hash, seen) SELECT ?, ? WHERE EXISTS (SELECT 1 FROM {blobs} WHERE hash = ?)
+		ON CONFLICT (hash) DO UPDATE SET seen = excluded.seen`), hash.Hex(), time.Now().Unix(), hash.Hex())
+	if err != nil {
+		return fmt.Errorf("index: failed to mark the blob as referenced: %w", err)
+	}
+	return nil
+}
+
+// Referenced reports whether a nostr event referencing the blob has ever been seen (see [Index.MarkReferenced]).
+func (i *Index) Referenced(ctx context.Context, hash blossom.Hash) (bool, error) {
+	var exists bool
+	err := i.db.QueryRowContext(ctx, i.query(`SELECT EXISTS (SELECT 1 FROM {references} WHERE hash = ?)`), hash.Hex()).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("index: failed to query the references: %w", err)
+	}
+	return exists, nil
+}
+
+// Drop removes the unreferenced blob from the index.
+// It returns [ErrReferenced] if the blob is still owned by some pubkey, and [ErrNotFound] if it's not in the index.
+func (i *Index) Drop(ctx context.Context, hash blossom.Hash) error {
+	res, err := i.db.ExecContext(ctx, i.query(`
+		DELETE FROM {blobs} WHERE hash = ?
+		AND NOT EXISTS (SELECT 1 FROM {owners} o WHERE o.hash = ?)`), hash.Hex(), hash.Hex())
+	if err != nil {
+		return fmt.Errorf("index: failed to drop the blob: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n > 0 {
+		if _, err := i.db.ExecContext(ctx, i.query(`DELETE FROM {references} WHERE hash = ?`), hash.Hex()); err != nil {
+			return fmt.Errorf("index: failed to drop the references of the blob: %w", err)
+		}
+		return nil
+	}
+
+	if _, err := i.Get(ctx, hash); err != nil {
+		return err
+	}
+	return ErrReferenced
+}
+
+// Stats returns a summary of the content of the index.
+func (i *Index) Stats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	err := i.db.QueryRowContext(ctx, i.query(`
+		SELECT
+			(SELECT COUNT(*) FROM {blobs}),
+			(SELECT COALESCE(SUM(size), 0) FROM {blobs}),
+			(SELECT COUNT(DISTINCT pubkey) FROM {owners}),
+			(SELECT COUNT(*) FROM {blobs} b WHERE NOT EXISTS (SELECT 1 FROM {owners} o WHERE o.hash = b.hash))`,
+	)).Scan(&stats.Blobs, &stats.Bytes, &stats.Owners, &stats.Unreferenced)
+
+	if err != nil {
+		return Stats{}, fmt.Errorf("index: failed to compute the stats: %w", err)
+	}
+	return stats, nil
+}
+
+// Bind keeps the index in sync with the server:
+//   - The blobs stored by the Upload, Media and Mirror hooks are added to the index, owned by the pubkey of the request.
+//   - The blobs deleted by the Delete hook are removed from the pubkey of the request.
+//   - The List and Stats hooks are answered by the index.
+//
+// It must be called after the On hooks are set (e.g. after [blossy.BindStore]), as it wraps them.
+func (i *Index) Bind(s *blossy.Server) {
+	if upload := s.On.Upload; upload != nil {
+		s.On.Upload = func(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+			desc, err := upload(r, hints, data)
+			if err != nil {
+				return desc, err
+			}
+			return desc, i.record(r, desc)
+		}
+	}
+
+	if media := s.On.Media; media != nil {
+		s.On.Media = func(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+			desc, err := media(r, hints, data)
+			if err != nil {
+				return desc, err
+			}
+			return desc, i.record(r, desc)
+		}
+	}
+
+	if mirror := s.On.Mirror; mirror != nil {
+		s.On.Mirror = func(r blossy.Request, u *url.URL) (blossom.BlobDescriptor, *blossom.Error) {
+			desc, err := mirror(r, u)
+			if err != nil {
+				return desc, err
+			}
+			return desc, i.record(r, desc)
+		}
+	}
+
+	if del := s.On.Delete; del != nil {
+		s.On.Delete = func(r blossy.Request, hash blossom.Hash) *blossom.Error {
+			if err := del(r, hash); err != nil {
+				return err
+			}
+
+			_, err := i.Remove(r.Context(), r.Pubkey(), hash)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				// blobs stored before the index was bound are not in it
+				return blossom.ErrInternal(err.Error())
+			}
+			return nil
+		}
+	}
+
+	s.On.List = func(r blossy.Request, pubkey string, query blossy.ListQuery) ([]blossom.BlobDescriptor, *blossom.Error) {
+		descs, err := i.List(r.Context(), pubkey, query)
+		if err != nil {
+			return nil, blossom.ErrInternal(err.Error())
+		}
+		return descs, nil
+	}
+
+	s.On.Stats = func(r blossy.Request, pubkey string) (blossy.ListStats, *blossom.Error) {
+		stats, err := i.PubkeyStats(r.Context(), pubkey)
+		if err != nil {
+			return blossy.ListStats{}, blossom.ErrInternal(err.Error())
+		}
+		return stats, nil
+	}
+}
+
+func (i *Index) record(r blossy.Request, desc blossom.BlobDescriptor) *blossom.Error {
+	if err := i.Add(r.Context(), r.Pubkey(), desc); err != nil {
+		return blossom.ErrInternal(err.Error())
+	}
+	return nil
+}
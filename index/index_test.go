@@ -0,0 +1,285 @@
+package index
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/blossytest"
+)
+
+var (
+	alice = strings.Repeat("a", 64)
+	bob   = strings.Repeat("b", 64)
+
+	hash1 = blossom.ComputeHash([]byte("one"))
+	hash2 = blossom.ComputeHash([]byte("two"))
+)
+
+func newIndex(t *testing.T) *Index {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1) // every connection opens a different in-memory database
+	t.Cleanup(func() { db.Close() })
+
+	idx, err := New(t.Context(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+func TestIndex(t *testing.T) {
+	ctx := t.Context()
+	idx := newIndex(t)
+
+	add := func(pubkey string, hash blossom.Hash, size, uploaded int64) {
+		t.Helper()
+		desc := blossom.BlobDescriptor{Hash: hash, Size: size, Type: "text/plain", Uploaded: uploaded}
+		if err := idx.Add(ctx, pubkey, desc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	add(alice, hash1, 3, 100)
+	add(alice, hash1, 3, 150) // no-op
+	add(alice, hash2, 3, 200)
+	add(bob, hash1, 3, 300)
+
+	blob, err := idx.Get(ctx, hash1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob.Refs != 2 || blob.Uploaded != 100 || blob.Size != 3 {
+		t.Errorf("unexpected blob %+v", blob)
+	}
+
+	blobs, err := idx.Blobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blobs) != 2 || blobs[0].Hash != hash1 || blobs[1].Refs != 1 {
+		t.Errorf("expected all the blobs oldest first, got %+v", blobs)
+	}
+
+	owners, err := idx.Owners(ctx, hash1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(owners, []string{alice, bob}) {
+		t.Errorf("expected owners alice and bob, got %v", owners)
+	}
+
+	descs, err := idx.List(ctx, alice, blossy.ListQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(descs) != 2 || descs[0].Hash != hash2 || descs[1].Hash != hash1 {
+		t.Errorf("expected alice's blobs newest first, got %+v", descs)
+	}
+
+	descs, err = idx.List(ctx, alice, blossy.ListQuery{Since: time.Unix(150, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(descs) != 1 || descs[0].Hash != hash2 {
+		t.Errorf("expected only the blobs since 150, got %+v", descs)
+	}
+
+	refs, err := idx.Remove(ctx, alice, hash1)
+	if err != nil || refs != 1 {
+		t.Fatalf("expected 1 reference left, got %d (%v)", refs, err)
+	}
+	if _, err := idx.Remove(ctx, alice, hash1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound when removing twice, got %v", err)
+	}
+	if err := idx.Drop(ctx, hash1); !errors.Is(err, ErrReferenced) {
+		t.Errorf("expected ErrReferenced, got %v", err)
+	}
+
+	if _, err := idx.Remove(ctx, bob, hash1); err != nil {
+		t.Fatal(err)
+	}
+
+	unreferenced, err := idx.Unreferenced(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(unreferenced, []blossom.Hash{hash1}) {
+		t.Errorf("expected hash1 to be unreferenced, got %v", unreferenced)
+	}
+
+	stats, err := idx.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Stats{Blobs: 2, Bytes: 6, Owners: 1, Unreferenced: 1}
+	if stats != expected {
+		t.Errorf("expected stats %+v, got %+v", expected, stats)
+	}
+
+	if err := idx.Drop(ctx, hash1); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Drop(ctx, hash1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound when dropping twice, got %v", err)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	ctx := t.Context()
+	idx := newIndex(t)
+
+	if err := idx.Add(ctx, alice, blossom.BlobDescriptor{Hash: hash1, Size: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.MarkReferenced(ctx, hash1); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.MarkReferenced(ctx, hash1); err != nil {
+		t.Fatalf("expected marking twice to succeed, got %v", err)
+	}
+	if err := idx.MarkReferenced(ctx, hash2); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := idx.Referenced(ctx, hash1); err != nil || !ok {
+		t.Errorf("expected hash1 to be referenced, got %v (%v)", ok, err)
+	}
+	if ok, err := idx.Referenced(ctx, hash2); err != nil || ok {
+		t.Errorf("expected hash2 not in the index to be ignored, got %v (%v)", ok, err)
+	}
+
+	if _, err := idx.Remove(ctx, alice, hash1); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Drop(ctx, hash1); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := idx.Referenced(ctx, hash1); ok {
+		t.Error("expected the references to be dropped with the blob")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	idx := &Index{prefix: "x_", placeholders: true}
+	got := idx.query(`SELECT * FROM {owners} WHERE pubkey = ? AND hash = ?`)
+	expected := `SELECT * FROM x_owners WHERE pubkey = $1 AND hash = $2`
+	if got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestBind(t *testing.T) {
+	server := blossytest.NewTestServer(t)
+	idx := newIndex(t)
+	idx.Bind(server.Blossy)
+
+	signer := blossytest.NewSigner(t)
+	client := server.Client(t, signer)
+	pubkey := blossytest.Pubkey(t, signer)
+
+	desc, err := client.Upload(t.Context(), strings.NewReader("hello"), "text/plain")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	descs, err := client.List(t.Context(), pubkey, blossy.ListQuery{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(descs) != 1 || descs[0].Hash != desc.Hash {
+		t.Fatalf("expected the uploaded blob in the list, got %+v", descs)
+	}
+
+	stats, err := client.Stats(t.Context(), pubkey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.Blobs != 1 || stats.Bytes != 5 || stats.LastUpload == 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	if err := client.Delete(t.Context(), desc.Hash); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	blob, err := idx.Get(t.Context(), desc.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob.Refs != 0 {
+		t.Errorf("expected the blob to be unreferenced after the deletion, got %d refs", blob.Refs)
+	}
+
+	res := server.Do(t, server.NewRequest(t, http.MethodGet, "/list/"+pubkey, nil))
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", res.StatusCode)
+	}
+}
+
+func TestListPages(t *testing.T) {
+	ctx := t.Context()
+	idx := newIndex(t)
+
+	var all []blossom.BlobDescriptor
+	types := []string{
+		"image/png", "text/plain", "IMAGE/JPEG", "video/mp4", "image/png", "Text/Plain; charset=utf-8",
+		"x_y/data", "xzy/data", "not a type",
+	}
+	for i, mime := range types {
+		desc := blossom.BlobDescriptor{
+			Hash:     blossom.ComputeHash([]byte{byte(i)}),
+			Size:     1,
+			Type:     mime,
+			Uploaded: int64(100 + i/2*10), // pairs uploaded at the same time
+		}
+		if err := idx.Add(ctx, alice, desc); err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, desc)
+	}
+
+	queries := []blossy.ListQuery{
+		{Limit: 4},
+		{Order: blossy.Oldest, Limit: 4},
+		{Types: []string{"image/*"}, Limit: 2},
+		{Types: []string{"text/plain", "video/*"}, Order: blossy.Oldest},
+		{Types: []string{"TEXT/*", "Image/PNG"}},
+		{Types: []string{"x_y/*"}},
+		{Types: []string{"*/*"}, Limit: 3},
+	}
+	for _, query := range queries {
+		// page through the blobs, and compare each page with the one selected by the query
+		for page := 0; ; page++ {
+			expected := query.Apply(slices.Clone(all))
+			descs, err := idx.List(ctx, alice, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.EqualFunc(descs, expected, func(a, b blossom.BlobDescriptor) bool { return a.Hash == b.Hash }) {
+				t.Fatalf("query %+v, page %d: expected %v, got %v", query, page, expected, descs)
+			}
+			if query.Limit == 0 || len(descs) < query.Limit {
+				break
+			}
+			query.Cursor = &descs[len(descs)-1].Hash
+		}
+	}
+
+	missing := blossom.ComputeHash([]byte("missing"))
+	if descs, err := idx.List(ctx, alice, blossy.ListQuery{Cursor: &missing}); err != nil || len(descs) != 0 {
+		t.Errorf("expected an empty page for an unknown cursor, got %v %v", descs, err)
+	}
+}
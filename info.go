@@ -0,0 +1,126 @@
+package blossy
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+)
+
+// DefaultInfoPath is the path of the capability discovery endpoint, if not configured. See [WithInfoEndpoint].
+const DefaultInfoPath = "/.well-known/blossom"
+
+// Info describes the capabilities of the server, so that clients can discover them before uploading.
+// It's served as JSON by the endpoint enabled with [WithInfoEndpoint].
+type Info struct {
+	// Hostname of the server, if set with [WithHostname].
+	Hostname string `json:"hostname,omitempty"`
+
+	// BUDs are the numbers of the supported Blossom Upgrade Documents (e.g. "01", "02").
+	BUDs []string `json:"buds"`
+
+	// Endpoints are the endpoints the server handles.
+	Endpoints []Endpoint `json:"endpoints"`
+
+	// MaxUploadSize is the maximum size in bytes of uploaded blobs, or 0 if there is no limit.
+	MaxUploadSize int64 `json:"max_upload_size,omitempty"`
+
+	// AllowedTypes are the patterns of the content types of the blobs that can be uploaded. If empty, all are allowed.
+	AllowedTypes []string `json:"allowed_types,omitempty"`
+
+	// BlockedTypes are the patterns of the content types of the blobs that can't be uploaded.
+	BlockedTypes []string `json:"blocked_types,omitempty"`
+
+	// RequiredAuth are the endpoints whose requests must be authenticated.
+	RequiredAuth []Endpoint `json:"auth_required,omitempty"`
+
+	// Payment describes the payments required to use the server (BUD-07), if any.
+	Payment *PaymentInfo `json:"payment,omitempty"`
+}
+
+// PaymentInfo describes the payments required to use the server (BUD-07).
+type PaymentInfo struct {
+	// Endpoints are the endpoints that require a payment.
+	Endpoints []Endpoint `json:"endpoints"`
+
+	// Methods are the accepted payment methods (e.g. "lightning").
+	Methods []string `json:"methods"`
+
+	// PricePerMiB is the indicative price in msats of uploading a MiB, if known.
+	PricePerMiB int64 `json:"price_per_mib_msats,omitempty"`
+}
+
+// Info returns the capabilities of the server, derived from its options and from the hooks that are configured,
+// as modified by the functions registered with [Server.ExtendInfo].
+func (s *Server) Info() Info {
+	live := s.live.Load()
+	info := Info{
+		Hostname:      s.settings.Sys.hostname,
+		BUDs:          []string{"01"},
+		Endpoints:     []Endpoint{EndpointDownload, EndpointCheck},
+		MaxUploadSize: s.settings.Upload.maxSize,
+		AllowedTypes:  live.allowedTypes,
+		BlockedTypes:  live.blockedTypes,
+	}
+
+	for _, e := range Endpoints() {
+		if slices.Contains(s.settings.Policy.requiredAuth, e) && !slices.Contains(info.RequiredAuth, e) {
+			info.RequiredAuth = append(info.RequiredAuth, e)
+		}
+	}
+
+	if s.On.Upload != nil {
+		info.BUDs = append(info.BUDs, "02")
+		info.Endpoints = append(info.Endpoints, EndpointUpload)
+	}
+	if s.On.Delete != nil {
+		info.Endpoints = append(info.Endpoints, EndpointDelete)
+	}
+	if s.On.List != nil {
+		info.Endpoints = append(info.Endpoints, EndpointList)
+	}
+	if s.On.Mirror != nil {
+		info.BUDs = append(info.BUDs, "04")
+		info.Endpoints = append(info.Endpoints, EndpointMirror)
+	}
+	if s.On.Media != nil {
+		info.BUDs = append(info.BUDs, "05")
+		info.Endpoints = append(info.Endpoints, EndpointMedia)
+	}
+	if s.On.Upload != nil || s.On.Media != nil {
+		info.BUDs = append(info.BUDs, "06")
+	}
+
+	for _, extend := range s.infoExtensions {
+		extend(&info)
+	}
+
+	if info.Payment != nil && !slices.Contains(info.BUDs, "07") {
+		info.BUDs = append(info.BUDs, "07")
+	}
+	if s.On.NIP94 != nil {
+		info.BUDs = append(info.BUDs, "08")
+	}
+	if s.On.Report != nil {
+		info.BUDs = append(info.BUDs, "09")
+		info.Endpoints = append(info.Endpoints, EndpointReport)
+	}
+	slices.Sort(info.BUDs)
+	return info
+}
+
+// ExtendInfo registers functions that modify the [Info] of the server, for example to describe the payments
+// required by a Reject hook. They are called in order every time the info is requested.
+//
+// ExtendInfo is not safe for concurrent use, and it must be called before the server starts serving requests.
+func (s *Server) ExtendInfo(extensions ...func(info *Info)) {
+	s.infoExtensions = append(s.infoExtensions, extensions...)
+}
+
+// HandleInfo handles the capability discovery endpoint, enabled with [WithInfoEndpoint].
+func (s *Server) HandleInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(s.Info()); err != nil {
+		s.logger(r).Error("failed to encode the server info", "error", err)
+	}
+}
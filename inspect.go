@@ -0,0 +1,78 @@
+package blossy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+// DefaultInspectionSize is the number of bytes of an upload passed to the content Reject hooks, if not configured.
+// It's enough to recognize most formats from their magic numbers. See [WithInspectionSize].
+const DefaultInspectionSize = 512
+
+// errUploadRejected is returned by [inspectReader] when a content Reject hook rejected the upload.
+var errUploadRejected = errors.New("upload rejected")
+
+// inspectReader copies the first bytes of an upload body into a buffer while it's read, and passes them to
+// the check function as soon as they are available. If the check fails, reads return [errUploadRejected],
+// so that the Upload and Media hooks stop storing the blob.
+type inspectReader struct {
+	tee   io.Reader
+	r     io.Reader
+	head  bytes.Buffer
+	size  int
+	check func(head []byte) *blossom.Error
+
+	inspected bool
+	rejected  *blossom.Error
+}
+
+func (i *inspectReader) Read(p []byte) (int, error) {
+	if i.rejected != nil {
+		return 0, errUploadRejected
+	}
+	if i.inspected {
+		return i.r.Read(p)
+	}
+
+	// don't read past the inspected bytes, so that they are checked before the rest is consumed
+	n, err := i.tee.Read(p[:min(len(p), i.size-i.head.Len())])
+	if i.head.Len() >= i.size || errors.Is(err, io.EOF) {
+		i.inspected = true
+		if i.rejected = i.check(i.head.Bytes()); i.rejected != nil {
+			return n, errUploadRejected
+		}
+	}
+	return n, err
+}
+
+// inspectUpload wraps the body of an upload with an [inspectReader] that invokes the content Reject hooks,
+// if any is configured.
+func (s *Server) inspectUpload(req Request, hints UploadHints, data io.Reader,
+	hooks []func(r Request, hints UploadHints, head []byte) *blossom.Error) (io.Reader, *inspectReader) {
+	if len(hooks) == 0 {
+		return data, nil
+	}
+
+	size := s.settings.Upload.inspectSize
+	if size == 0 {
+		size = DefaultInspectionSize
+	}
+
+	reader := &inspectReader{
+		r:    data,
+		size: size,
+		check: func(head []byte) *blossom.Error {
+			for _, reject := range hooks {
+				if err := reject(req, hints, head); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	reader.tee = io.TeeReader(data, &reader.head)
+	return reader, reader
+}
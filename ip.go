@@ -1,26 +1,44 @@
 package blossy
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"net"
 	"net/http"
 	"strings"
 )
 
-const DefaultIPv6Prefix = 64
+const (
+	DefaultIPv4Prefix = 32
+	DefaultIPv6Prefix = 64
+)
 
 // IP is a wrapper around the standard library [net.IP].
 // It provides useful convenience methods such as [IP.Group] and [IP.GroupPrefix]
 // for grouping/normalizing IP addresses for rate-limiting purposes.
 type IP struct {
 	Raw net.IP
+
+	// prefixes used by [IP.Group]. If 0, the defaults are used.
+	v4, v6 int
 }
 
 // Group returns a stable value suitable for rate limiting or grouping.
 //   - IPv4 addresses: the full /32 address is returned.
 //   - IPv6 addresses: it uses the default /64 mask to return the network prefix,
 //     grouping all traffic from the same standard subnet block.
+//
+// The IPs of the requests use the prefixes configured with [WithIPGrouping] instead of the defaults.
 func (ip IP) Group() string {
-	return ip.GroupPrefix(DefaultIPv6Prefix)
+	v4, v6 := ip.v4, ip.v6
+	if v4 == 0 {
+		v4 = DefaultIPv4Prefix
+	}
+	if v6 == 0 {
+		v6 = DefaultIPv6Prefix
+	}
+	return ip.GroupPrefixes(v4, v6)
 }
 
 // GroupPrefix returns a stable value suitable for rate limiting or grouping.
@@ -33,13 +51,47 @@ func (ip IP) GroupPrefix(prefix int) string {
 	if prefix < 0 || prefix > 128 {
 		panic("blossy.IP.GroupPrefix: prefix must be between 0 and 128")
 	}
+	return ip.GroupPrefixes(DefaultIPv4Prefix, prefix)
+}
+
+// GroupPrefixes is like [IP.GroupPrefix], but IPv4 addresses are masked with the v4 prefix as well
+// (typically 24 or 32), so that addresses of the same IPv4 block are grouped together.
+//
+// It panics if v4 is outside the closed interval [0,32], or v6 is outside [0,128].
+func (ip IP) GroupPrefixes(v4, v6 int) string {
+	if v4 < 0 || v4 > 32 {
+		panic("blossy.IP.GroupPrefixes: IPv4 prefix must be between 0 and 32")
+	}
+	if v6 < 0 || v6 > 128 {
+		panic("blossy.IP.GroupPrefixes: IPv6 prefix must be between 0 and 128")
+	}
 	if len(ip.Raw) == 0 {
 		return ""
 	}
-	if ip.IsV4() {
-		return ip.Raw.String()
+	if ip4 := ip.Raw.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(v4, 32)).String()
+	}
+	return ip.Raw.Mask(net.CIDRMask(v6, 128)).String()
+}
+
+// WithGrouping returns a copy of the IP whose [IP.Group] uses the provided prefixes.
+//
+// It panics if v4 is outside the closed interval [0,32], or v6 is outside [0,128].
+func (ip IP) WithGrouping(v4, v6 int) IP {
+	if v4 < 0 || v4 > 32 || v6 < 0 || v6 > 128 {
+		panic("blossy.IP.WithGrouping: prefixes must be between 0 and 32 (IPv4) and 128 (IPv6)")
 	}
-	return ip.Raw.Mask(net.CIDRMask(prefix, 128)).String()
+	ip.v4, ip.v6 = v4, v6
+	return ip
+}
+
+// HashGroup returns the HMAC-SHA256 of the [IP.Group] with the key, hex encoded and truncated to 16 bytes.
+// It's a stable but non-reversible identifier for the group, suitable for privacy-preserving logging and metrics.
+// The key should be secret, as the IPv4 space is small enough to reverse unkeyed hashes by brute force.
+func (ip IP) HashGroup(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(ip.Group()))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
 }
 
 // IsV4 returns whether the IP is a valid IPv4 address.
@@ -51,6 +103,19 @@ func (ip IP) IsV6() bool { return len(ip.Raw) == net.IPv6len && ip.Raw.To4() ==
 // IsLoopback reports whether IP is a loopback address.
 func (ip IP) IsLoopback() bool { return ip.Raw.IsLoopback() }
 
+// IsPrivate reports whether IP is a private address, according to RFC 1918 (IPv4) and RFC 4193 (IPv6).
+func (ip IP) IsPrivate() bool { return ip.Raw.IsPrivate() }
+
+// In reports whether IP belongs to any of the networks.
+func (ip IP) In(nets ...*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip.Raw) {
+			return true
+		}
+	}
+	return false
+}
+
 // String returns the string form of the raw [net.IP] address ip.
 func (ip IP) String() string { return ip.Raw.String() }
 
@@ -64,6 +129,13 @@ func GetIP(r *http.Request) IP {
 	return IP{Raw: net.ParseIP(ip)}
 }
 
+// ip returns the IP address of the http request, grouped as configured with [WithIPGrouping].
+func (s *Server) ip(r *http.Request) IP {
+	ip := GetIP(r)
+	ip.v4, ip.v6 = s.settings.Sys.ipv4Prefix, s.settings.Sys.ipv6Prefix
+	return ip
+}
+
 func getIP(r *http.Request) string {
 	if tIP := r.Header.Get("True-Client-IP"); tIP != "" {
 		return tIP
@@ -0,0 +1,180 @@
+package ipfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+)
+
+// Announcer adds blobs to an IPFS node through the RPC API of Kubo, as raw blocks pinned on the node,
+// which then announces them to the IPFS network. Create one with [NewAnnouncer].
+type Announcer struct {
+	api   *url.URL
+	store blossy.Store
+	http  *http.Client
+	log   *slog.Logger
+}
+
+type AnnouncerOption func(*Announcer)
+
+// WithHTTPClient sets the http client used to reach the RPC API of the node.
+func WithHTTPClient(c *http.Client) AnnouncerOption {
+	return func(a *Announcer) {
+		a.http = c
+	}
+}
+
+// WithLogger sets the logger of the announcer. By default, it's [slog.Default].
+func WithLogger(l *slog.Logger) AnnouncerOption {
+	return func(a *Announcer) {
+		a.log = l
+	}
+}
+
+// NewAnnouncer returns an [Announcer] that reads the blobs from the store, and adds them to the node
+// with the RPC API at the URL (e.g. "http://127.0.0.1:5001").
+func NewAnnouncer(api string, store blossy.Store, opts ...AnnouncerOption) (*Announcer, error) {
+	u, err := url.Parse(strings.TrimSuffix(api, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("ipfs: invalid API URL %q: %w", api, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("ipfs: invalid API URL %q: must be an http or https URL", api)
+	}
+	if store == nil {
+		return nil, errors.New("ipfs: store must not be nil")
+	}
+
+	a := &Announcer{
+		api:   u,
+		store: store,
+		http:  &http.Client{},
+		log:   slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	if a.http == nil {
+		return nil, errors.New("ipfs: http client must not be nil")
+	}
+	if a.log == nil {
+		return nil, errors.New("ipfs: logger must not be nil")
+	}
+	return a, nil
+}
+
+// Announce adds the blob with the hash to the node, as a pinned raw block.
+func (a *Announcer) Announce(ctx context.Context, hash blossom.Hash) error {
+	blob, err := a.store.Get(ctx, hash)
+	if err != nil {
+		return fmt.Errorf("ipfs: failed to read blob %s: %w", hash.Hex(), err)
+	}
+	defer blob.Close()
+
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		part, err := form.CreateFormFile("file", hash.Hex())
+		if err == nil {
+			_, err = io.Copy(part, blob)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	endpoint := a.api.JoinPath("/api/v0/block/put")
+	endpoint.RawQuery = url.Values{
+		"cid-codec":       {"raw"},
+		"mhtype":          {"sha2-256"},
+		"pin":             {"true"},
+		"allow-big-block": {"true"},
+	}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), body)
+	if err != nil {
+		body.Close()
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	res, err := a.http.Do(req)
+	if err != nil {
+		body.Close()
+		return fmt.Errorf("ipfs: failed to add blob %s: %w", hash.Hex(), err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("ipfs: failed to add blob %s: node responded with %s: %s", hash.Hex(), res.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var added struct {
+		Key string `json:"Key"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&added); err != nil {
+		return fmt.Errorf("ipfs: invalid response of the node: %w", err)
+	}
+	if added.Key != CID(hash) {
+		return fmt.Errorf("ipfs: the node added blob %s as %s, expected %s", hash.Hex(), added.Key, CID(hash))
+	}
+	return nil
+}
+
+// Bind announces the blobs stored by the Upload, Media and Mirror hooks of the server, with jobs run
+// by its workers (see [blossy.WithWorkers]). Blobs that can't be enqueued are logged and skipped.
+//
+// It must be called after the On hooks are set (e.g. after [blossy.BindStore]), as it wraps them.
+func (a *Announcer) Bind(s *blossy.Server) {
+	announce := func(desc blossom.BlobDescriptor) {
+		err := s.Enqueue("ipfs-announce", func(ctx context.Context) error {
+			return a.Announce(ctx, desc.Hash)
+		})
+		if err != nil {
+			a.log.Warn("ipfs: failed to enqueue the announcement", "hash", desc.Hash.Hex(), "error", err)
+		}
+	}
+
+	if upload := s.On.Upload; upload != nil {
+		s.On.Upload = func(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+			desc, err := upload(r, hints, data)
+			if err == nil {
+				announce(desc)
+			}
+			return desc, err
+		}
+	}
+
+	if media := s.On.Media; media != nil {
+		s.On.Media = func(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+			desc, err := media(r, hints, data)
+			if err == nil {
+				announce(desc)
+			}
+			return desc, err
+		}
+	}
+
+	if mirror := s.On.Mirror; mirror != nil {
+		s.On.Mirror = func(r blossy.Request, u *url.URL) (blossom.BlobDescriptor, *blossom.Error) {
+			desc, err := mirror(r, u)
+			if err == nil {
+				announce(desc)
+			}
+			return desc, err
+		}
+	}
+}
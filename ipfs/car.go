@@ -0,0 +1,134 @@
+package ipfs
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+// WriteCAR writes the blobs with the hashes from the store to w as a CARv1 archive, whose roots are
+// the CIDs of the blobs, and returns the number of bytes written. The archive can be imported in
+// an IPFS node (e.g. with 'ipfs dag import'), or kept for archival.
+//
+// The hash of every blob is verified while it's written. If a blob is missing or doesn't match its hash,
+// WriteCAR stops and returns the error, leaving a truncated archive.
+func WriteCAR(ctx context.Context, w io.Writer, store blossy.Store, hashes ...blossom.Hash) (int64, error) {
+	if len(hashes) == 0 {
+		return 0, errors.New("ipfs: a CAR archive must have at least one blob")
+	}
+
+	cw := &countingWriter{w: w}
+	header := carHeader(hashes)
+	if _, err := cw.Write(binary.AppendUvarint(nil, uint64(len(header)))); err != nil {
+		return cw.n, err
+	}
+	if _, err := cw.Write(header); err != nil {
+		return cw.n, err
+	}
+
+	for _, hash := range hashes {
+		if err := ctx.Err(); err != nil {
+			return cw.n, err
+		}
+		if err := writeBlock(ctx, cw, store, hash); err != nil {
+			return cw.n, fmt.Errorf("ipfs: blob %s: %w", hash.Hex(), err)
+		}
+	}
+	return cw.n, nil
+}
+
+// writeBlock writes the section of the blob with the hash: the length of the CID and data, the CID and the data.
+func writeBlock(ctx context.Context, w io.Writer, store blossy.Store, hash blossom.Hash) error {
+	blob, err := store.Get(ctx, hash)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	var data io.Reader = utils.NewHashReader(blob, &hash)
+	size := blob.Size()
+	if size < 0 {
+		// the length of the section must be known before the data
+		buf, err := io.ReadAll(data)
+		if err != nil {
+			return err
+		}
+		data, size = bytes.NewReader(buf), int64(len(buf))
+	}
+
+	cid := cidBytes(hash)
+	section := binary.AppendUvarint(nil, uint64(len(cid))+uint64(size))
+	if _, err := w.Write(append(section, cid...)); err != nil {
+		return err
+	}
+
+	n, err := io.Copy(w, io.LimitReader(data, size))
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("expected %d bytes, got %d", size, n)
+	}
+
+	// read the end of the blob, so that its hash is verified
+	if extra, err := io.Copy(io.Discard, data); err != nil || extra > 0 {
+		return cmp.Or(err, fmt.Errorf("the blob is bigger than its size of %d bytes", size))
+	}
+	return nil
+}
+
+// carHeader returns the DAG-CBOR encoding of the CARv1 header {"roots": [<cids>], "version": 1}.
+func carHeader(hashes []blossom.Hash) []byte {
+	header := []byte{0xa2} // map with 2 entries, keys sorted by length as per DAG-CBOR
+	header = appendCBORText(header, "roots")
+	header = appendCBORHead(header, 4, uint64(len(hashes))) // array
+	for _, hash := range hashes {
+		header = append(header, 0xd8, 42) // tag 42: CID
+		cid := cidBytes(hash)
+		header = appendCBORHead(header, 2, uint64(len(cid)+1)) // bytes, with the multibase identity prefix
+		header = append(header, 0x00)
+		header = append(header, cid...)
+	}
+	header = appendCBORText(header, "version")
+	return appendCBORHead(header, 0, 1)
+}
+
+func appendCBORText(b []byte, s string) []byte {
+	return append(appendCBORHead(b, 3, uint64(len(s))), s...)
+}
+
+// appendCBORHead appends the head of a CBOR item of the major type with the argument, in its shortest form.
+func appendCBORHead(b []byte, major byte, arg uint64) []byte {
+	major <<= 5
+	switch {
+	case arg < 24:
+		return append(b, major|byte(arg))
+	case arg <= 0xff:
+		return append(b, major|24, byte(arg))
+	case arg <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(arg))
+	case arg <= 0xffffffff:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(arg))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, major|27), arg)
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
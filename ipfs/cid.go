@@ -0,0 +1,69 @@
+// Package ipfs bridges a blossy server with IPFS, as blossom and IPFS can address the same bytes:
+// the sha256 hash of a blob is the digest of its raw-leaf CIDv1 (e.g. bafkrei...).
+//
+//   - [CID] and [ParseCID] convert between blossom hashes and CIDs.
+//   - [Gateway] answers /ipfs/<cid> requests with the blobs of the server, as a trustless gateway for raw blocks.
+//   - [WriteCAR] exports a selection of blobs as a CAR archive, for archival or import in an IPFS node.
+//   - [Announcer] adds the uploaded blobs to an IPFS node (e.g. Kubo), which announces them to the network.
+//
+// A raw CID addresses the blob as a single block. IPFS nodes exchange blocks of up to a few MiB,
+// so bigger blobs can be served by the gateway and exported, but other nodes might not fetch them over bitswap.
+package ipfs
+
+import (
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+const (
+	cidVersion = 0x01
+	codecRaw   = 0x55
+	sha256Code = 0x12
+	sha256Size = 0x20
+)
+
+// prefix is the binary prefix of a raw CIDv1 with a sha2-256 multihash.
+var prefix = []byte{cidVersion, codecRaw, sha256Code, sha256Size}
+
+// ErrUnsupportedCID is returned by [ParseCID] for the valid CIDs that don't address a blob,
+// such as CIDv0 or the ones with a codec other than raw (e.g. dag-pb directories and chunked files).
+var ErrUnsupportedCID = errors.New("ipfs: only raw CIDv1 with a sha2-256 multihash are supported")
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// CID returns the raw-leaf CIDv1 of the blob with the hash, in its canonical base32 form (e.g. "bafkrei...").
+func CID(hash blossom.Hash) string {
+	return "b" + strings.ToLower(encoding.EncodeToString(cidBytes(hash)))
+}
+
+// cidBytes returns the binary form of the CID of the blob with the hash.
+func cidBytes(hash blossom.Hash) []byte {
+	return append(append([]byte{}, prefix...), hash[:]...)
+}
+
+// ParseCID returns the hash of the blob addressed by the base32 CID.
+// It returns [ErrUnsupportedCID] if the CID is valid but doesn't address a blob.
+func ParseCID(cid string) (blossom.Hash, error) {
+	if strings.HasPrefix(cid, "Qm") {
+		return blossom.Hash{}, ErrUnsupportedCID
+	}
+	if !strings.HasPrefix(cid, "b") {
+		return blossom.Hash{}, fmt.Errorf("ipfs: invalid CID %q: only the base32 encoding is supported", cid)
+	}
+
+	data, err := encoding.DecodeString(strings.ToUpper(cid[1:]))
+	if err != nil {
+		return blossom.Hash{}, fmt.Errorf("ipfs: invalid CID %q: %w", cid, err)
+	}
+	if len(data) != len(prefix)+len(blossom.Hash{}) || string(data[:len(prefix)]) != string(prefix) {
+		return blossom.Hash{}, ErrUnsupportedCID
+	}
+
+	var hash blossom.Hash
+	copy(hash[:], data[len(prefix):])
+	return hash, nil
+}
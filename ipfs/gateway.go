@@ -0,0 +1,119 @@
+package ipfs
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// rawType is the content type of the raw blocks of the trustless gateway specification.
+const rawType = "application/vnd.ipld.raw"
+
+// Gateway returns an [http.Handler] that answers GET and HEAD /ipfs/<cid> requests by rewriting them
+// to /<sha256> and forwarding them to next (typically a blossy server), so that the hooks, the authorization
+// and the policies of the server apply as for any other download. The other requests are forwarded unchanged.
+//
+// Following the trustless gateway specification, requests with the '?format=raw' query or the
+// 'Accept: application/vnd.ipld.raw' header are answered with the content type of a raw block,
+// while CAR responses are not supported (use [WriteCAR] to export blobs).
+//
+// Example:
+//
+//	server, err := blossy.NewServer(blossy.WithHostname("cdn.example.com"))
+//	if err != nil {
+//	    panic(err)
+//	}
+//	http.ListenAndServe(":3334", ipfs.Gateway(server))
+func Gateway(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, ok := strings.CutPrefix(r.URL.Path, "/ipfs/")
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Unsupported request", http.StatusMethodNotAllowed)
+			return
+		}
+
+		cid, rest, _ := strings.Cut(path, "/")
+		if rest != "" {
+			http.Error(w, "Raw blocks have no paths", http.StatusNotFound)
+			return
+		}
+
+		hash, err := ParseCID(cid)
+		if errors.Is(err, ErrUnsupportedCID) {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		format := responseFormat(r)
+		if format != "" && format != rawType {
+			http.Error(w, "Only raw blocks are supported", http.StatusNotAcceptable)
+			return
+		}
+
+		rewritten := r.Clone(r.Context())
+		rewritten.URL = &url.URL{Path: "/" + hash.Hex()}
+		rewritten.RequestURI = ""
+
+		w.Header().Set("X-Ipfs-Path", "/ipfs/"+cid)
+		if format == rawType {
+			w = &rawWriter{ResponseWriter: w}
+		}
+		next.ServeHTTP(w, rewritten)
+	})
+}
+
+// responseFormat returns the content type requested with the 'format' query or the 'Accept' header,
+// if it's one of the formats of the trustless gateway specification (application/vnd.ipld.*).
+func responseFormat(r *http.Request) string {
+	switch format := r.URL.Query().Get("format"); format {
+	case "":
+	case "raw":
+		return rawType
+	default:
+		return "application/vnd.ipld." + format
+	}
+
+	for accepted := range strings.SplitSeq(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err == nil && strings.HasPrefix(mediaType, "application/vnd.ipld.") {
+			return mediaType
+		}
+	}
+	return ""
+}
+
+// rawWriter serves the successful responses with the content type of a raw block.
+type rawWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *rawWriter) WriteHeader(code int) {
+	if !w.wroteHeader && (code == http.StatusOK || code == http.StatusPartialContent) {
+		w.Header().Set("Content-Type", rawType)
+		w.Header().Del("Content-Disposition")
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *rawWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *rawWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,229 @@
+package ipfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/stores/memory"
+)
+
+var ctx = context.Background()
+
+func TestCID(t *testing.T) {
+	hash := blossom.ComputeHash([]byte("hello world"))
+	cid := "bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e"
+
+	if got := CID(hash); got != cid {
+		t.Errorf("expected CID %s, got %s", cid, got)
+	}
+
+	parsed, err := ParseCID(cid)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed != hash {
+		t.Errorf("expected hash %s, got %s", hash, parsed)
+	}
+
+	tests := []struct {
+		cid string
+		err error
+	}{
+		{cid: "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o", err: ErrUnsupportedCID},
+		{cid: "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi", err: ErrUnsupportedCID}, // dag-pb
+		{cid: "zb2rhe5P4gXftAwvA4eXQ5HJwsER2owDyS9sKaQRRVQPn93bA"},
+		{cid: "b!!!"},
+	}
+	for _, test := range tests {
+		_, err := ParseCID(test.cid)
+		if err == nil || test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("ParseCID(%s): expected error %v, got %v", test.cid, test.err, err)
+		}
+	}
+}
+
+func newServer(t *testing.T, opts ...blossy.Option) (*blossy.Server, *memory.Store) {
+	t.Helper()
+	server, err := blossy.NewServer(append([]blossy.Option{blossy.WithHostname("localhost")}, opts...)...)
+	if err != nil {
+		t.Fatalf("failed to create the server: %v", err)
+	}
+	store := memory.New()
+	blossy.BindStore(server, store)
+	return server, store
+}
+
+func TestGateway(t *testing.T) {
+	server, store := newServer(t)
+	desc, err := store.Save(ctx, "", blossy.UploadHints{Type: "text/plain", Size: -1}, strings.NewReader("hello world"))
+	if err != nil {
+		t.Fatalf("failed to save the blob: %v", err)
+	}
+
+	gateway := httptest.NewServer(Gateway(server))
+	defer gateway.Close()
+
+	get := func(path string, header http.Header) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodGet, gateway.URL+path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return res, string(body)
+	}
+
+	res, body := get("/ipfs/"+CID(desc.Hash), nil)
+	if res.StatusCode != http.StatusOK || body != "hello world" {
+		t.Fatalf("expected the blob, got %d %q", res.StatusCode, body)
+	}
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("expected the type of the blob, got %q", ct)
+	}
+	if path := res.Header.Get("X-Ipfs-Path"); path != "/ipfs/"+CID(desc.Hash) {
+		t.Errorf("unexpected X-Ipfs-Path %q", path)
+	}
+
+	res, body = get("/ipfs/"+CID(desc.Hash)+"?format=raw", nil)
+	if res.StatusCode != http.StatusOK || body != "hello world" || res.Header.Get("Content-Type") != rawType {
+		t.Errorf("expected the raw block, got %d %q %q", res.StatusCode, res.Header.Get("Content-Type"), body)
+	}
+
+	res, _ = get("/ipfs/"+CID(desc.Hash), http.Header{"Accept": {"application/vnd.ipld.car"}})
+	if res.StatusCode != http.StatusNotAcceptable {
+		t.Errorf("expected 406 for CAR responses, got %d", res.StatusCode)
+	}
+
+	res, _ = get("/ipfs/"+CID(blossom.ComputeHash([]byte("missing"))), nil)
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing blob, got %d", res.StatusCode)
+	}
+
+	res, _ = get("/ipfs/QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o", nil)
+	if res.StatusCode != http.StatusNotImplemented {
+		t.Errorf("expected 501 for a CIDv0, got %d", res.StatusCode)
+	}
+
+	res, body = get("/"+desc.Hash.Hex(), nil)
+	if res.StatusCode != http.StatusOK || body != "hello world" {
+		t.Errorf("expected the other requests to be forwarded, got %d %q", res.StatusCode, body)
+	}
+}
+
+func TestWriteCAR(t *testing.T) {
+	store := memory.New()
+	var hashes []blossom.Hash
+	for _, data := range []string{"hello world", "second blob"} {
+		desc, err := store.Save(ctx, "", blossy.UploadHints{Size: -1}, strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to save the blob: %v", err)
+		}
+		hashes = append(hashes, desc.Hash)
+	}
+
+	var buf bytes.Buffer
+	n, err := WriteCAR(ctx, &buf, store, hashes...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("expected %d bytes written, got %d", buf.Len(), n)
+	}
+
+	r := bufio.NewReader(&buf)
+	headerLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		t.Fatalf("invalid header length: %v", err)
+	}
+	header := make([]byte, headerLen)
+	io.ReadFull(r, header)
+	if !bytes.HasPrefix(header, []byte{0xa2, 0x65, 'r', 'o', 'o', 't', 's', 0x82}) || !bytes.HasSuffix(header, []byte("version\x01")) {
+		t.Errorf("unexpected header %x", header)
+	}
+
+	for i, data := range []string{"hello world", "second blob"} {
+		sectionLen, err := binary.ReadUvarint(r)
+		if err != nil {
+			t.Fatalf("block %d: invalid section length: %v", i, err)
+		}
+		section := make([]byte, sectionLen)
+		if _, err := io.ReadFull(r, section); err != nil {
+			t.Fatalf("block %d: %v", i, err)
+		}
+		if cid := section[:36]; !bytes.Equal(cid, cidBytes(hashes[i])) {
+			t.Errorf("block %d: unexpected CID %x", i, cid)
+		}
+		if block := string(section[36:]); block != data {
+			t.Errorf("block %d: expected %q, got %q", i, data, block)
+		}
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Error("expected the archive to end after the blocks")
+	}
+
+	if _, err := WriteCAR(ctx, io.Discard, store, blossom.ComputeHash([]byte("missing"))); !errors.Is(err, blossy.ErrBlobNotFound) {
+		t.Errorf("expected ErrBlobNotFound, got %v", err)
+	}
+}
+
+func TestAnnouncer(t *testing.T) {
+	added := make(chan string, 1)
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v0/block/put" || r.URL.Query().Get("cid-codec") != "raw" || r.URL.Query().Get("pin") != "true" {
+			http.Error(w, "unexpected request "+r.URL.String(), http.StatusBadRequest)
+			return
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		cid := CID(blossom.ComputeHash(data))
+		w.Write([]byte(`{"Key":"` + cid + `","Size":` + "11" + `}`))
+		added <- cid
+	}))
+	defer node.Close()
+
+	server, store := newServer(t, blossy.WithWorkers(1))
+	announcer, err := NewAnnouncer(node.URL, store)
+	if err != nil {
+		t.Fatalf("failed to create the announcer: %v", err)
+	}
+	announcer.Bind(server)
+
+	serveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go server.StartAndServe(serveCtx, "127.0.0.1:0")
+
+	data := []byte("hello world")
+	hash := blossom.ComputeHash(data)
+	hints := blossy.UploadHints{Hash: &hash, Size: int64(len(data))}
+	if _, err := server.On.Upload(blossy.NewTestRequest(), hints, bytes.NewReader(data)); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+
+	select {
+	case cid := <-added:
+		if cid != CID(hash) {
+			t.Errorf("expected the node to add %s, got %s", CID(hash), cid)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the blob was not announced")
+	}
+}
@@ -0,0 +1,306 @@
+package blossy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+// IPPolicy is a firewall for the IP addresses of the requests. See [WithIPPolicy].
+type IPPolicy struct {
+	// Allow are the networks in CIDR notation (e.g. "10.0.0.0/8", "2001:db8::/32") whose requests are allowed.
+	// Single addresses (e.g. "203.0.113.7") are accepted as well. If empty, all addresses are allowed.
+	Allow []string
+
+	// Deny are the networks in CIDR notation whose requests are rejected, even if they are in Allow.
+	// Single addresses are accepted as well.
+	Deny []string
+
+	// Resolver resolves the country and the autonomous system of the addresses for the Geo policy.
+	// It's consulted on every request, so it should be fast, like a local GeoIP database.
+	Resolver GeoResolver
+
+	// Geo reports whether the requests of the address are allowed, based on its [GeoInfo].
+	// It requires the Resolver. See [DenyCountries], [AllowCountries] and [DenyASNs].
+	Geo GeoPolicy
+
+	// Ban temporarily bans the addresses whose requests are repeatedly rejected. If nil, no address is banned.
+	Ban *BanPolicy
+
+	// Endpoints are the endpoints the policy applies to. If empty, it applies to all endpoints.
+	Endpoints []Endpoint
+}
+
+// GeoInfo is the geographical and network information of an IP address.
+type GeoInfo struct {
+	// Country is the ISO 3166-1 alpha-2 code of the country (e.g. "US"), or empty if unknown.
+	Country string
+
+	// ASN is the number of the autonomous system, or 0 if unknown.
+	ASN uint32
+
+	// Organization is the name of the organization of the autonomous system, if known.
+	Organization string
+}
+
+// GeoResolver resolves the [GeoInfo] of IP addresses, for example with a MaxMind database.
+// Implementations must be safe for concurrent use.
+type GeoResolver interface {
+	Resolve(ip IP) (GeoInfo, error)
+}
+
+// GeoPolicy reports whether the requests of the IP address are allowed.
+// It must be safe for concurrent use.
+type GeoPolicy func(ip IP, info GeoInfo) bool
+
+// DenyCountries returns a [GeoPolicy] that rejects the addresses of the countries,
+// identified by their ISO 3166-1 alpha-2 code (e.g. "US"). Addresses of unknown countries are allowed.
+func DenyCountries(codes ...string) GeoPolicy {
+	codes = upper(codes)
+	return func(_ IP, info GeoInfo) bool {
+		return !slices.Contains(codes, strings.ToUpper(info.Country))
+	}
+}
+
+// AllowCountries returns a [GeoPolicy] that allows only the addresses of the countries,
+// identified by their ISO 3166-1 alpha-2 code (e.g. "US"). Addresses of unknown countries are rejected.
+func AllowCountries(codes ...string) GeoPolicy {
+	codes = upper(codes)
+	return func(_ IP, info GeoInfo) bool {
+		return slices.Contains(codes, strings.ToUpper(info.Country))
+	}
+}
+
+// DenyASNs returns a [GeoPolicy] that rejects the addresses of the autonomous systems,
+// for example the ones of hosting providers commonly used for abuse.
+func DenyASNs(asns ...uint32) GeoPolicy {
+	return func(_ IP, info GeoInfo) bool {
+		return !slices.Contains(asns, info.ASN)
+	}
+}
+
+func upper(codes []string) []string {
+	upper := make([]string, len(codes))
+	for i, code := range codes {
+		upper[i] = strings.ToUpper(code)
+	}
+	return upper
+}
+
+// BanPolicy bans an address for the Duration after Threshold of its requests have been rejected within the Window.
+// Rejected requests are the ones answered with a client error (4xx), except 404 (Not Found).
+// Addresses are grouped with [IP.Group], so that IPv6 clients can't avoid the ban by changing address in their subnet.
+type BanPolicy struct {
+	Threshold int
+	Window    time.Duration
+	Duration  time.Duration
+}
+
+func (p IPPolicy) validate() error {
+	for _, cidr := range slices.Concat(p.Allow, p.Deny) {
+		if _, err := parseNetwork(cidr); err != nil {
+			return fmt.Errorf("ip policy: %w", err)
+		}
+	}
+	if p.Geo != nil && p.Resolver == nil {
+		return errors.New("ip policy: the geo policy requires a resolver")
+	}
+	if b := p.Ban; b != nil {
+		if b.Threshold < 1 {
+			return errors.New("ip policy: ban threshold must be positive")
+		}
+		if b.Window <= 0 || b.Duration <= 0 {
+			return errors.New("ip policy: ban window and duration must be positive")
+		}
+	}
+	return nil
+}
+
+// parseNetwork parses a network in CIDR notation, or a single address.
+func parseNetwork(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q", s)
+		}
+		bits := 8 * len(ip)
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network %q: %w", s, err)
+	}
+	return network, nil
+}
+
+// firewall is an [IPPolicy] whose networks are parsed once.
+type firewall struct {
+	allow     []*net.IPNet
+	deny      []*net.IPNet
+	resolver  GeoResolver
+	geo       GeoPolicy
+	bans      *bans
+	endpoints []Endpoint
+}
+
+// newFirewall returns the firewall of the policy. Invalid networks are skipped, and reported by [IPPolicy.validate].
+func newFirewall(p IPPolicy) *firewall {
+	f := &firewall{
+		resolver:  p.Resolver,
+		geo:       p.Geo,
+		endpoints: p.Endpoints,
+	}
+	if len(f.endpoints) == 0 {
+		f.endpoints = Endpoints()
+	}
+
+	for _, cidr := range p.Allow {
+		if network, err := parseNetwork(cidr); err == nil {
+			f.allow = append(f.allow, network)
+		}
+	}
+	for _, cidr := range p.Deny {
+		if network, err := parseNetwork(cidr); err == nil {
+			f.deny = append(f.deny, network)
+		}
+	}
+	if p.Ban != nil {
+		f.bans = newBans(*p.Ban)
+	}
+	return f
+}
+
+func (f *firewall) appliesTo(e Endpoint) bool {
+	return slices.Contains(f.endpoints, e)
+}
+
+// checkIP returns an error if the requests of the IP are not allowed.
+// It might set the 'Retry-After' header when the IP is banned.
+func (s *Server) checkIP(w http.ResponseWriter, f *firewall, ip IP) *blossom.Error {
+	if f.bans != nil {
+		if wait, banned := f.bans.banned(ip.Group()); banned {
+			seconds := int64(wait.Round(time.Second) / time.Second)
+			w.Header().Set("Retry-After", strconv.FormatInt(max(seconds, 1), 10))
+			return blossom.ErrForbidden("IP address is temporarily banned")
+		}
+	}
+
+	if ip.In(f.deny...) || (len(f.allow) > 0 && !ip.In(f.allow...)) {
+		return blossom.ErrForbidden("IP address is not allowed")
+	}
+
+	if f.geo != nil {
+		info, err := f.resolver.Resolve(ip)
+		if err != nil {
+			// fail open, as an unavailable resolver shouldn't take the server down
+			s.log.Warn("ip policy: failed to resolve the IP address", "ip", ip.String(), "error", err)
+			return nil
+		}
+		if !f.geo(ip, info) {
+			return blossom.ErrForbidden("IP address is not allowed")
+		}
+	}
+	return nil
+}
+
+// observeIP records the status of the response to a request of the IP, banning it if needed.
+func (f *firewall) observeIP(e Endpoint, ip IP, status int) {
+	if f == nil || f.bans == nil || !f.appliesTo(e) {
+		return
+	}
+	if status < 400 || status >= 500 || status == http.StatusNotFound {
+		return
+	}
+	f.bans.reject(ip.Group())
+}
+
+// bans tracks the rejections of the IP groups, and the ones that are banned.
+type bans struct {
+	policy BanPolicy
+
+	mu        sync.Mutex
+	groups    map[string]*banState
+	lastSweep time.Time
+}
+
+type banState struct {
+	rejections  int
+	windowStart time.Time
+	until       time.Time
+}
+
+func newBans(p BanPolicy) *bans {
+	return &bans{
+		policy: p,
+		groups: make(map[string]*banState),
+	}
+}
+
+// banned returns whether the group is banned, and for how long.
+func (b *bans) banned(group string) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, ok := b.groups[group]
+	if !ok {
+		return 0, false
+	}
+	wait := state.until.Sub(time.Now())
+	return wait, wait > 0
+}
+
+// reject records a rejection of the group, banning it when the threshold is reached.
+// Rejections of banned groups are ignored, so that the ban is not extended while it lasts.
+func (b *bans) reject(group string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.sweep(now)
+
+	state, ok := b.groups[group]
+	if !ok {
+		state = &banState{windowStart: now}
+		b.groups[group] = state
+	}
+
+	if now.Before(state.until) {
+		return
+	}
+	if now.Sub(state.windowStart) > b.policy.Window {
+		state.rejections = 0
+		state.windowStart = now
+	}
+
+	state.rejections++
+	if state.rejections >= b.policy.Threshold {
+		state.rejections = 0
+		state.until = now.Add(b.policy.Duration)
+	}
+}
+
+// sweep removes the groups whose ban and window have expired, at most once per window.
+func (b *bans) sweep(now time.Time) {
+	if now.Sub(b.lastSweep) < b.policy.Window {
+		return
+	}
+	b.lastSweep = now
+
+	for group, state := range b.groups {
+		if now.After(state.until) && now.Sub(state.windowStart) > b.policy.Window {
+			delete(b.groups, group)
+		}
+	}
+}
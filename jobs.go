@@ -0,0 +1,170 @@
+package blossy
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultJobQueueSize is the maximum number of jobs waiting to be run, if not configured. See [WithJobQueueSize].
+	DefaultJobQueueSize = 1000
+
+	// DefaultJobAttempts is the number of times a failing job is run, if not configured. See [WithJobRetries].
+	DefaultJobAttempts = 3
+
+	// DefaultJobBackoff is the wait before the first retry of a failed job, if not configured. See [WithJobRetries].
+	DefaultJobBackoff = time.Second
+)
+
+var (
+	// ErrNoWorkers is returned by [Server.Enqueue] when the server has no workers. See [WithWorkers].
+	ErrNoWorkers = errors.New("the server has no workers to run jobs")
+
+	// ErrQueueFull is returned by [Server.Enqueue] when the job queue is full. See [WithJobQueueSize].
+	ErrQueueFull = errors.New("the job queue is full")
+)
+
+// Job is a task run out-of-band by the workers of the server, such as generating thumbnails, replicating
+// or scanning a blob after it was uploaded. The context is cancelled if the job doesn't finish in time
+// when the server shuts down.
+type Job func(ctx context.Context) error
+
+type queuedJob struct {
+	name string
+	run  Job
+}
+
+// jobQueue runs the enqueued jobs with a fixed number of workers, retrying the ones that fail.
+type jobQueue struct {
+	jobs     chan queuedJob
+	workers  int
+	attempts int
+	backoff  time.Duration
+
+	// drain is the maximum time the workers keep running the queued jobs after the server stopped.
+	drain time.Duration
+	log   *slog.Logger
+}
+
+// Enqueue queues the job to be run by the workers of the server (see [WithWorkers]), so that hooks can return
+// quickly while heavy work happens out-of-band. The name identifies the job in the logs.
+//
+// Failed jobs are retried as configured with [WithJobRetries]. When the server shuts down, the workers keep
+// running the queued jobs for up to the shutdown timeout (see [WithShutdownTimeout]), after which
+// the context of the jobs is cancelled.
+//
+// Example:
+//
+//	server.After.Upload.Append(func(r blossy.Request, res blossy.Response) {
+//	    if res.Status == http.StatusOK {
+//	        server.Enqueue("thumbnail", func(ctx context.Context) error { return thumbnail(ctx, hash) })
+//	    }
+//	})
+func (s *Server) Enqueue(name string, job Job) error {
+	if s.jobs == nil {
+		return ErrNoWorkers
+	}
+
+	select {
+	case s.jobs.jobs <- queuedJob{name: name, run: job}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// newJobQueue returns the job queue configured by the settings, or nil if there are no workers.
+func newJobQueue(sys systemSettings, drain time.Duration, log *slog.Logger) *jobQueue {
+	if sys.workers <= 0 {
+		return nil
+	}
+
+	return &jobQueue{
+		jobs:     make(chan queuedJob, cmp.Or(sys.jobQueueSize, DefaultJobQueueSize)),
+		workers:  sys.workers,
+		attempts: cmp.Or(sys.jobAttempts, DefaultJobAttempts),
+		backoff:  cmp.Or(sys.jobBackoff, DefaultJobBackoff),
+		drain:    drain,
+		log:      log,
+	}
+}
+
+// run runs the queued jobs with the workers until the context is cancelled, and then drains the queue.
+func (q *jobQueue) run(ctx context.Context) {
+	jobCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(q.drain, cancel)
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+	for range q.workers {
+		wg.Go(func() {
+			for {
+				select {
+				case job := <-q.jobs:
+					q.execute(jobCtx, job)
+
+				case <-ctx.Done():
+					q.drainQueue(jobCtx)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// drainQueue runs the jobs left in the queue, until it's empty.
+func (q *jobQueue) drainQueue(ctx context.Context) {
+	for {
+		select {
+		case job := <-q.jobs:
+			q.execute(ctx, job)
+		default:
+			return
+		}
+	}
+}
+
+// execute runs the job, retrying it with exponential backoff until it succeeds,
+// it runs out of attempts or the context is cancelled.
+func (q *jobQueue) execute(ctx context.Context, job queuedJob) {
+	backoff := q.backoff
+	for attempt := 1; ; attempt++ {
+		err := q.safeRun(ctx, job)
+		if err == nil {
+			return
+		}
+
+		if attempt >= q.attempts || ctx.Err() != nil {
+			q.log.Error("job failed", "job", job.name, "attempts", attempt, "error", err)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			q.log.Error("job failed", "job", job.name, "attempts", attempt, "error", err)
+			return
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+}
+
+// safeRun runs the job, converting its panics into errors so that they don't crash the server.
+func (q *jobQueue) safeRun(ctx context.Context, job queuedJob) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return job.run(ctx)
+}
@@ -0,0 +1,75 @@
+package blossy
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+// landingTemplate is the page served by [WithLandingPage] when no HTML is provided.
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{if .Info.Hostname}}{{.Info.Hostname}}{{else}}Blossom server{{end}}</title>
+</head>
+<body>
+<h1>{{if .Info.Hostname}}{{.Info.Hostname}}{{else}}Blossom server{{end}}</h1>
+<p>This is a <a href="https://github.com/hzrd149/blossom">Blossom</a> server, which stores blobs addressed by their sha256 hash.
+Blobs are downloaded with <code>GET /&lt;sha256&gt;</code>.</p>
+<p>Supported BUDs: {{range $i, $bud := .Info.BUDs}}{{if $i}}, {{end}}{{$bud}}{{end}}.</p>
+{{- if .Info.MaxUploadSize}}
+<p>Maximum upload size: {{.Info.MaxUploadSize}} bytes.</p>
+{{- end}}
+{{- if .InfoPath}}
+<p>The capabilities of the server are described at <a href="{{.InfoPath}}">{{.InfoPath}}</a>.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+// isBlobPath reports whether the path is the one of a blob, like /<sha256> or /<sha256>.<ext>.
+func isBlobPath(path string) bool {
+	_, _, err := utils.ParseHashExt(path)
+	return err == nil
+}
+
+// serveLanding serves the landing page configured with [WithLandingPage]: with 200 (OK) on the root path,
+// and with 404 (Not Found) on the other paths.
+func (s *Server) serveLanding(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.URL.Path == "/" {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+	}
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if page := s.settings.HTTP.landingPage; page != "" {
+		w.Write([]byte(page))
+		return
+	}
+
+	data := struct {
+		Info     Info
+		InfoPath string
+	}{
+		Info: s.Info(),
+	}
+	if p := s.settings.HTTP.infoPath; p != "" {
+		data.InfoPath = s.settings.HTTP.pathPrefix + p
+	}
+
+	var b strings.Builder
+	if err := landingTemplate.Execute(&b, data); err != nil {
+		s.logger(r).Error("failed to render the landing page", "error", err)
+		return
+	}
+	w.Write([]byte(b.String()))
+}
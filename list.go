@@ -0,0 +1,104 @@
+package blossy
+
+import (
+	"bytes"
+	"cmp"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+// Match returns whether the blob passes the filters of the query: its upload time and its type.
+// It ignores the order, the limit and the cursor.
+func (q ListQuery) Match(desc blossom.BlobDescriptor) bool {
+	uploaded := time.Unix(desc.Uploaded, 0)
+	if !q.Since.IsZero() && uploaded.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && uploaded.After(q.Until) {
+		return false
+	}
+	if len(q.Types) > 0 && !slices.ContainsFunc(q.Types, func(p string) bool { return utils.MatchMediaType(p, desc.Type) }) {
+		return false
+	}
+	return true
+}
+
+// Compare compares the blobs in the order of the query, by upload time then by hash.
+func (q ListQuery) Compare(a, b blossom.BlobDescriptor) int {
+	byTime := cmp.Compare(b.Uploaded, a.Uploaded)
+	if q.Order == Oldest {
+		byTime = -byTime
+	}
+	return cmp.Or(byTime, bytes.Compare(a.Hash[:], b.Hash[:]))
+}
+
+// Apply returns the page of the descriptors selected by the query: the ones that [ListQuery.Match] the filters,
+// sorted by [ListQuery.Compare], starting after the cursor and up to the limit. It sorts the provided slice in place.
+// If the cursor is not among the descriptors, for example because the blob has been deleted, the page is empty.
+//
+// Stores that can't filter, sort and paginate the blobs more efficiently can use it to implement [Store.List].
+func (q ListQuery) Apply(descs []blossom.BlobDescriptor) []blossom.BlobDescriptor {
+	slices.SortFunc(descs, q.Compare)
+
+	if q.Cursor != nil {
+		i := slices.IndexFunc(descs, func(d blossom.BlobDescriptor) bool { return d.Hash == *q.Cursor })
+		if i < 0 {
+			return nil
+		}
+		descs = descs[i+1:]
+	}
+
+	page := make([]blossom.BlobDescriptor, 0, min(len(descs), max(q.Limit, 0)))
+	for _, desc := range descs {
+		if q.Limit > 0 && len(page) == q.Limit {
+			break
+		}
+		if q.Match(desc) {
+			page = append(page, desc)
+		}
+	}
+	return page
+}
+
+// Params returns the query parameters of a GET /list/<pubkey> request with the query.
+func (q ListQuery) Params() url.Values {
+	params := url.Values{}
+	if !q.Since.IsZero() {
+		params.Set("since", strconv.FormatInt(q.Since.Unix(), 10))
+	}
+	if !q.Until.IsZero() {
+		params.Set("until", strconv.FormatInt(q.Until.Unix(), 10))
+	}
+	for _, t := range q.Types {
+		params.Add("type", t)
+	}
+	if q.Order != "" {
+		params.Set("order", string(q.Order))
+	}
+	if q.Limit > 0 {
+		params.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Cursor != nil {
+		params.Set("cursor", q.Cursor.Hex())
+	}
+	return params
+}
+
+// setNextLink sets the 'Link' header of a full page of a GET /list/<pubkey> response to the URL of the next page,
+// whose cursor is the last blob of the page.
+func (s *Server) setNextLink(w http.ResponseWriter, pubkey string, query ListQuery, descs []blossom.BlobDescriptor) {
+	if query.Limit <= 0 || len(descs) < query.Limit {
+		return
+	}
+
+	cursor := descs[len(descs)-1].Hash
+	query.Cursor = &cursor
+	next := s.settings.HTTP.pathPrefix + "/list/" + pubkey + "?" + query.Params().Encode()
+	w.Header().Set("Link", "<"+next+`>; rel="next"`)
+}
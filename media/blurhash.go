@@ -0,0 +1,150 @@
+package media
+
+import (
+	"image"
+	"math"
+	"strings"
+)
+
+// blurhashSamples is the maximum number of pixels sampled along each side of an image to compute its blurhash.
+// The blurhash only captures low frequencies, so sampling doesn't change it noticeably while bounding the cost.
+const blurhashSamples = 64
+
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// Blurhash returns the blurhash of the image with x horizontal and y vertical components, between 1 and 9.
+// Large images are sampled, so the result may differ slightly from other implementations.
+// Learn more here: https://github.com/woltapp/blurhash/blob/master/Algorithm.md
+func Blurhash(img image.Image, x, y int) string {
+	x, y = min(max(x, 1), 9), min(max(y, 1), 9)
+	pixels := sample(img)
+
+	factors := make([][3]float64, 0, x*y)
+	for j := range y {
+		for i := range x {
+			factors = append(factors, basis(pixels, i, j))
+		}
+	}
+
+	hash := &strings.Builder{}
+	encode83(hash, (x-1)+(y-1)*9, 1)
+
+	dc, ac := factors[0], factors[1:]
+	maxAC := 1.0
+	if len(ac) > 0 {
+		actual := 0.0
+		for _, f := range ac {
+			actual = max(actual, math.Abs(f[0]), math.Abs(f[1]), math.Abs(f[2]))
+		}
+
+		quantized := int(max(0, min(82, math.Floor(actual*166-0.5))))
+		maxAC = float64(quantized+1) / 166
+		encode83(hash, quantized, 1)
+	} else {
+		encode83(hash, 0, 1)
+	}
+
+	encode83(hash, encodeDC(dc), 4)
+	for _, f := range ac {
+		encode83(hash, encodeAC(f, maxAC), 2)
+	}
+	return hash.String()
+}
+
+// sample returns the linear RGB values of at most blurhashSamples x blurhashSamples pixels of the image.
+func sample(img image.Image) [][][3]float64 {
+	bounds := img.Bounds()
+	w, h := min(bounds.Dx(), blurhashSamples), min(bounds.Dy(), blurhashSamples)
+
+	pixels := make([][][3]float64, h)
+	for y := range h {
+		pixels[y] = make([][3]float64, w)
+		for x := range w {
+			px := bounds.Min.X + x*bounds.Dx()/w
+			py := bounds.Min.Y + y*bounds.Dy()/h
+			r, g, b, _ := img.At(px, py).RGBA()
+			pixels[y][x] = [3]float64{
+				srgbToLinear(int(r >> 8)),
+				srgbToLinear(int(g >> 8)),
+				srgbToLinear(int(b >> 8)),
+			}
+		}
+	}
+	return pixels
+}
+
+// basis returns the factor of the (i, j) component of the discrete cosine transform of the pixels.
+func basis(pixels [][][3]float64, i, j int) [3]float64 {
+	h := len(pixels)
+	if h == 0 {
+		return [3]float64{}
+	}
+	w := len(pixels[0])
+
+	normalization := 2.0
+	if i == 0 && j == 0 {
+		normalization = 1
+	}
+
+	var f [3]float64
+	for y := range h {
+		for x := range w {
+			b := normalization *
+				math.Cos(math.Pi*float64(i)*float64(x)/float64(w)) *
+				math.Cos(math.Pi*float64(j)*float64(y)/float64(h))
+
+			f[0] += b * pixels[y][x][0]
+			f[1] += b * pixels[y][x][1]
+			f[2] += b * pixels[y][x][2]
+		}
+	}
+
+	scale := 1 / float64(w*h)
+	return [3]float64{f[0] * scale, f[1] * scale, f[2] * scale}
+}
+
+func encodeDC(f [3]float64) int {
+	return linearToSrgb(f[0])<<16 + linearToSrgb(f[1])<<8 + linearToSrgb(f[2])
+}
+
+func encodeAC(f [3]float64, maxAC float64) int {
+	quantize := func(v float64) int {
+		return int(max(0, min(18, math.Floor(signPow(v/maxAC, 0.5)*9+9.5))))
+	}
+	return quantize(f[0])*19*19 + quantize(f[1])*19 + quantize(f[2])
+}
+
+func encode83(b *strings.Builder, value, length int) {
+	for i := 1; i <= length; i++ {
+		digit := (value / pow83(length-i)) % 83
+		b.WriteByte(base83[digit])
+	}
+}
+
+func pow83(n int) int {
+	p := 1
+	for range n {
+		p *= 83
+	}
+	return p
+}
+
+func srgbToLinear(v int) float64 {
+	c := float64(v) / 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func linearToSrgb(v float64) int {
+	c := max(0, min(1, v))
+	if c <= 0.0031308 {
+		return int(c*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(c, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
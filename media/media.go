@@ -0,0 +1,222 @@
+// Package media extracts the dimensions and the blurhash of images while they are being uploaded,
+// without buffering the blob in memory.
+//
+// An [Analyzer] wraps the upload stream: everything read through it is decoded concurrently,
+// and the result is available with [Analyzer.Info] once the blob has been read.
+// Supported formats are JPEG, PNG and GIF, as well as any format registered with the [image] package.
+// The dimensions of WebP images are always extracted, while their blurhash requires registering
+// a WebP decoder, for example with:
+//
+//	import _ "golang.org/x/image/webp"
+//
+// The [Recorder] plugs the analysis into the server, making it available to the NIP-94 output (BUD-08):
+//
+//	recorder := media.NewRecorder()
+//	server.On.Upload = recorder.Upload(func(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+//	    return store.Save(r.Context(), r.Pubkey(), hints, data)
+//	})
+//	server.On.NIP94 = recorder.NIP94
+//
+// The [Transcoder] re-encodes and downscales the images uploaded with PUT /media (BUD-05), see [blossy.WithMediaProcessor].
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	// DefaultMaxPixels is the maximum number of pixels of an image whose blurhash is computed, if not configured.
+	DefaultMaxPixels = 50_000_000
+
+	// sniffLen is the number of bytes used to detect the type of a blob.
+	sniffLen = 512
+)
+
+var (
+	// ErrNotImage is returned by [Analyzer.Info] when the blob is not an image of a supported format.
+	ErrNotImage = errors.New("media: not a supported image")
+
+	// errDetached is used to stop feeding the decoder once it's done.
+	errDetached = errors.New("media: decoder detached")
+)
+
+// Info contains the metadata extracted from an image.
+type Info struct {
+	// Type is the media type of the blob, as detected from its content.
+	Type string
+
+	// Width and Height are the dimensions of the image in pixels.
+	Width  int
+	Height int
+
+	// Blurhash is the blurhash of the image, or "" if it was not computed.
+	// Learn more here: https://blurha.sh
+	Blurhash string
+}
+
+type config struct {
+	xComponents int
+	yComponents int
+	maxPixels   int
+}
+
+func newConfig() config {
+	return config{
+		xComponents: 4,
+		yComponents: 3,
+		maxPixels:   DefaultMaxPixels,
+	}
+}
+
+type Option func(*config)
+
+// WithBlurhash sets the number of horizontal and vertical components of the blurhash, between 1 and 9.
+// More components capture more detail, at the cost of a longer hash. The default is 4x3.
+// Use WithBlurhash(0, 0) to only extract the dimensions.
+func WithBlurhash(x, y int) Option {
+	return func(c *config) {
+		c.xComponents = x
+		c.yComponents = y
+	}
+}
+
+// WithMaxPixels sets the maximum number of pixels (width x height) of an image whose blurhash is computed.
+// Larger images only have their dimensions extracted, which protects the server from decompression bombs.
+// The default is [DefaultMaxPixels].
+func WithMaxPixels(n int) Option {
+	return func(c *config) {
+		c.maxPixels = n
+	}
+}
+
+// Analyzer is an [io.Reader] that extracts the [Info] of the image read through it. Create one with [Analyze].
+// It must be read from a single goroutine, and [Analyzer.Info] or [Analyzer.Close] must be called after use.
+type Analyzer struct {
+	r  io.Reader
+	pw *io.PipeWriter
+
+	detached bool
+	done     chan struct{}
+	info     Info
+	err      error
+}
+
+// Analyze returns an [Analyzer] that reads from r and decodes the data concurrently.
+// It panics if the blurhash components are not between 1 and 9 (or both 0).
+func Analyze(r io.Reader, opts ...Option) *Analyzer {
+	c := newConfig()
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	if err := c.validate(); err != nil {
+		panic(err)
+	}
+
+	pr, pw := io.Pipe()
+	a := &Analyzer{
+		r:    r,
+		pw:   pw,
+		done: make(chan struct{}),
+	}
+
+	go a.decode(pr, c)
+	return a
+}
+
+func (c config) validate() error {
+	if c.xComponents == 0 && c.yComponents == 0 {
+		return nil
+	}
+	if c.xComponents < 1 || c.xComponents > 9 || c.yComponents < 1 || c.yComponents > 9 {
+		return errors.New("media: blurhash components must be between 1 and 9")
+	}
+	return nil
+}
+
+func (a *Analyzer) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if n > 0 && !a.detached {
+		if _, werr := a.pw.Write(p[:n]); werr != nil {
+			// the decoder needs no more data
+			a.detached = true
+		}
+	}
+
+	if err == io.EOF {
+		a.pw.Close()
+	}
+	return n, err
+}
+
+// Close stops the analysis, releasing its resources. It doesn't close the underlying reader.
+func (a *Analyzer) Close() error {
+	a.pw.CloseWithError(io.ErrUnexpectedEOF)
+	return nil
+}
+
+// Info waits for the analysis to finish, and returns the [Info] of the image.
+// It should be called after the blob has been read to the end, otherwise the analysis will only consider
+// the data read so far, and likely fail.
+// If the blob is not an image of a supported format, it returns [ErrNotImage], with the detected type.
+func (a *Analyzer) Info() (Info, error) {
+	a.Close()
+	<-a.done
+	return a.info, a.err
+}
+
+// decode extracts the info from the data written to the pipe, and closes it when done
+// to detach it from the analyzer.
+func (a *Analyzer) decode(pr *io.PipeReader, c config) {
+	defer close(a.done)
+	defer pr.CloseWithError(errDetached)
+
+	br := bufio.NewReaderSize(pr, sniffLen)
+	header, _ := br.Peek(sniffLen)
+
+	a.info.Type, _, _ = strings.Cut(http.DetectContentType(header), ";")
+	if !strings.HasPrefix(a.info.Type, "image/") {
+		a.err = ErrNotImage
+		return
+	}
+
+	// decoding the config consumes the header of the image, which is needed again to decode the image
+	head := &bytes.Buffer{}
+	config, _, err := image.DecodeConfig(io.TeeReader(br, head))
+	switch {
+	case err == nil:
+		a.info.Width, a.info.Height = config.Width, config.Height
+
+	case a.info.Type == "image/webp":
+		// there might be no WebP decoder registered
+		a.info.Width, a.info.Height, err = webpSize(header)
+		if err != nil {
+			a.err = ErrNotImage
+		}
+		return
+
+	default:
+		a.err = ErrNotImage
+		return
+	}
+
+	if c.xComponents == 0 || a.info.Width*a.info.Height > c.maxPixels {
+		return
+	}
+
+	img, _, err := image.Decode(io.MultiReader(head, br))
+	if err != nil {
+		// the dimensions are still valid
+		return
+	}
+	a.info.Blurhash = Blurhash(img, c.xComponents, c.yComponents)
+}
@@ -0,0 +1,214 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+)
+
+func gradient(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func encoded(t *testing.T, img image.Image, format string) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(buf, img)
+	case "jpeg":
+		err = jpeg.Encode(buf, img, nil)
+	case "gif":
+		err = gif.Encode(buf, img, nil)
+	}
+	if err != nil {
+		t.Fatalf("failed to encode %s: %v", format, err)
+	}
+	return buf.Bytes()
+}
+
+func TestAnalyze(t *testing.T) {
+	img := gradient(120, 80)
+
+	tests := []struct {
+		format string
+		mime   string
+	}{
+		{format: "png", mime: "image/png"},
+		{format: "jpeg", mime: "image/jpeg"},
+		{format: "gif", mime: "image/gif"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			data := encoded(t, img, test.format)
+			analyzer := Analyze(bytes.NewReader(data))
+
+			read, err := io.ReadAll(analyzer)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(read, data) {
+				t.Fatalf("the analyzer modified the data")
+			}
+
+			info, err := analyzer.Info()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if info.Type != test.mime {
+				t.Errorf("expected type %s, got %s", test.mime, info.Type)
+			}
+			if info.Width != 120 || info.Height != 80 {
+				t.Errorf("expected 120x80, got %dx%d", info.Width, info.Height)
+			}
+			if len(info.Blurhash) != 28 {
+				t.Errorf("expected a 4x3 blurhash of 28 characters, got %q", info.Blurhash)
+			}
+		})
+	}
+}
+
+func TestAnalyzeOptions(t *testing.T) {
+	data := encoded(t, gradient(120, 80), "png")
+
+	analyzer := Analyze(bytes.NewReader(data), WithBlurhash(0, 0))
+	io.Copy(io.Discard, analyzer)
+
+	info, err := analyzer.Info()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Width != 120 || info.Height != 80 || info.Blurhash != "" {
+		t.Errorf("expected 120x80 without blurhash, got %+v", info)
+	}
+
+	analyzer = Analyze(bytes.NewReader(data), WithMaxPixels(1000))
+	io.Copy(io.Discard, analyzer)
+
+	info, err = analyzer.Info()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Blurhash != "" {
+		t.Errorf("expected no blurhash above the max pixels, got %q", info.Blurhash)
+	}
+}
+
+func TestAnalyzeNotImage(t *testing.T) {
+	analyzer := Analyze(strings.NewReader("hello world"))
+	io.Copy(io.Discard, analyzer)
+
+	info, err := analyzer.Info()
+	if !errors.Is(err, ErrNotImage) {
+		t.Fatalf("expected ErrNotImage, got %v", err)
+	}
+	if info.Type != "text/plain" {
+		t.Errorf("expected type text/plain, got %s", info.Type)
+	}
+}
+
+func TestAnalyzePartialRead(t *testing.T) {
+	data := encoded(t, gradient(120, 80), "png")
+	analyzer := Analyze(bytes.NewReader(data))
+
+	// reading only the header is enough for the dimensions
+	if _, err := io.ReadFull(analyzer, make([]byte, 100)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	info, _ := analyzer.Info()
+	if info.Width != 120 || info.Height != 80 {
+		t.Errorf("expected 120x80, got %dx%d", info.Width, info.Height)
+	}
+	if info.Blurhash != "" {
+		t.Errorf("expected no blurhash from a partial read, got %q", info.Blurhash)
+	}
+}
+
+func TestWebpSize(t *testing.T) {
+	header := func(chunk string, data ...byte) []byte {
+		h := []byte("RIFF\x00\x00\x00\x00WEBP" + chunk + "\x00\x00\x00\x00")
+		return append(h, append(data, make([]byte, 30)...)...)
+	}
+
+	tests := []struct {
+		name   string
+		header []byte
+		width  int
+		height int
+	}{
+		{
+			name:   "lossy",
+			header: header("VP8 ", 0, 0, 0, 0x9d, 0x01, 0x2a, 0x40, 0x01, 0xf0, 0x00),
+			width:  320,
+			height: 240,
+		},
+		{
+			name:   "lossless",
+			header: header("VP8L", 0x2f, 0x3f, 0x00, 0x00, 0x00),
+			width:  64,
+			height: 1,
+		},
+		{
+			name:   "extended",
+			header: header("VP8X", 0, 0, 0, 0, 0x7f, 0x07, 0x00, 0x37, 0x04, 0x00),
+			width:  1920,
+			height: 1080,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w, h, err := webpSize(test.header)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if w != test.width || h != test.height {
+				t.Errorf("expected %dx%d, got %dx%d", test.width, test.height, w, h)
+			}
+		})
+	}
+
+	if _, _, err := webpSize([]byte("RIFF")); err == nil {
+		t.Error("expected error for a short header, got nil")
+	}
+}
+
+func TestBlurhash(t *testing.T) {
+	white := image.NewUniform(color.White)
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for y := range 10 {
+		for x := range 10 {
+			img.Set(x, y, white.C)
+		}
+	}
+
+	// a uniform image has no AC components, and its DC is the color itself (0xffffff)
+	if hash := Blurhash(img, 1, 1); hash != "00TSUA" {
+		t.Errorf("expected 00TSUA, got %s", hash)
+	}
+
+	hash := Blurhash(gradient(300, 200), 4, 3)
+	if len(hash) != 28 || hash[0] != 'L' {
+		t.Errorf("expected a 4x3 blurhash starting with L, got %s", hash)
+	}
+	if hash != Blurhash(gradient(300, 200), 4, 3) {
+		t.Error("expected the blurhash to be deterministic")
+	}
+}
@@ -0,0 +1,89 @@
+package media
+
+import (
+	"io"
+	"sync"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+)
+
+// maxPending is the maximum number of results the [Recorder] holds before discarding them,
+// which can only happen if they are never collected by the NIP94 hook.
+const maxPending = 10_000
+
+// UploadFunc is the signature of the upload hooks of the server, [blossy.OnHooks.Upload] and [blossy.OnHooks.Media].
+type UploadFunc = func(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error)
+
+// Recorder analyzes the uploaded images, and reports their metadata in the 'nip94' field
+// of the returned blob descriptors (BUD-08). Create one with [NewRecorder].
+// It's safe for concurrent use.
+type Recorder struct {
+	opts []Option
+
+	mu      sync.Mutex
+	pending map[int64]Info
+}
+
+// NewRecorder returns a [Recorder] that analyzes images with the provided options.
+func NewRecorder(opts ...Option) *Recorder {
+	return &Recorder{
+		opts:    opts,
+		pending: make(map[int64]Info),
+	}
+}
+
+// Wrap returns an upload hook that analyzes the blob while the next hook reads it.
+// The next hook receives an [*Analyzer] as data, so it can access the [Info] after reading the blob.
+//
+// It can wrap the Media hook too, as long as the stored blob is the uploaded one,
+// since the analysis considers the uploaded data.
+func (rec *Recorder) Wrap(next UploadFunc) UploadFunc {
+	return func(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+		analyzer := Analyze(data, rec.opts...)
+		defer analyzer.Close()
+
+		desc, err := next(r, hints, analyzer)
+		if err != nil {
+			return desc, err
+		}
+
+		info, aerr := analyzer.Info()
+		if aerr != nil {
+			return desc, nil
+		}
+
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+
+		if len(rec.pending) >= maxPending {
+			clear(rec.pending)
+		}
+		rec.pending[r.ID()] = info
+		return desc, nil
+	}
+}
+
+// NIP94 is a [blossy.OnHooks.NIP94] hook that returns the metadata recorded while the blob was uploaded,
+// or nil if the blob was not an image.
+func (rec *Recorder) NIP94(r blossy.Request, desc blossom.BlobDescriptor) *blossy.FileMetadata {
+	rec.mu.Lock()
+	info, ok := rec.pending[r.ID()]
+	delete(rec.pending, r.ID())
+	rec.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	meta := info.Metadata()
+	return &meta
+}
+
+// Metadata returns the NIP-94 metadata of the image, to be used in a [blossy.OnHooks.NIP94] hook.
+func (i Info) Metadata() blossy.FileMetadata {
+	return blossy.FileMetadata{
+		Width:    i.Width,
+		Height:   i.Height,
+		Blurhash: i.Blurhash,
+	}
+}
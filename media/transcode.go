@@ -0,0 +1,183 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+)
+
+// Transcoder is a [blossy.Transformer] that re-encodes images, optionally downscaling them,
+// to build BUD-05 servers with [blossy.WithMediaProcessor]:
+//
+//	server, err := blossy.NewServer(
+//	    blossy.WithMediaProcessor(media.Transcoder{Format: "image/jpeg", MaxWidth: 2048, MaxHeight: 2048}),
+//	)
+//
+// Re-encoding drops all the metadata of the image, such as EXIF (e.g. GPS coordinates) and color profiles.
+// Images are decoded in memory, and the transcoded blob is buffered, so that its hash and size are known
+// to the Media hook.
+type Transcoder struct {
+	// Format is the media type of the transcoded images, "image/jpeg" or "image/png".
+	// If empty, JPEG and PNG images keep their format, while other formats are transcoded to PNG.
+	Format string
+
+	// MaxWidth and MaxHeight bound the dimensions of the transcoded images, which are downscaled
+	// preserving their aspect ratio. If 0, the dimension is not bounded.
+	MaxWidth  int
+	MaxHeight int
+
+	// Quality is the quality of JPEG images, between 1 and 100. If 0, [jpeg.DefaultQuality] is used.
+	Quality int
+
+	// MaxPixels is the maximum number of pixels (width x height) of the images to transcode,
+	// which protects the server from decompression bombs. If 0, [DefaultMaxPixels] is used.
+	MaxPixels int
+}
+
+var _ blossy.Transformer = Transcoder{}
+
+func (t Transcoder) Transform(ctx context.Context, hints blossy.UploadHints, data io.Reader) (io.ReadCloser, blossy.UploadHints, error) {
+	img, format, err := t.decode(data)
+	if err != nil {
+		return nil, blossy.UploadHints{}, err
+	}
+
+	if w, h := fit(img.Bounds().Dx(), img.Bounds().Dy(), t.MaxWidth, t.MaxHeight); w != img.Bounds().Dx() || h != img.Bounds().Dy() {
+		img = downscale(img, w, h)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, blossy.UploadHints{}, err
+	}
+
+	buf := &bytes.Buffer{}
+	contentType := t.format(format)
+	switch contentType {
+	case "image/jpeg":
+		quality := t.Quality
+		if quality == 0 {
+			quality = jpeg.DefaultQuality
+		}
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
+
+	case "image/png":
+		err = png.Encode(buf, img)
+
+	default:
+		err = fmt.Errorf("media: unsupported transcoding format %q", contentType)
+	}
+
+	if err != nil {
+		return nil, blossy.UploadHints{}, fmt.Errorf("media: failed to encode the image: %w", err)
+	}
+
+	hash := blossom.Hash(sha256.Sum256(buf.Bytes()))
+	transcoded := blossy.UploadHints{
+		Hash: &hash,
+		Type: contentType,
+		Size: int64(buf.Len()),
+	}
+	return io.NopCloser(buf), transcoded, nil
+}
+
+// decode decodes the image, checking its dimensions before allocating its pixels.
+func (t Transcoder) decode(data io.Reader) (image.Image, string, error) {
+	maxPixels := t.MaxPixels
+	if maxPixels == 0 {
+		maxPixels = DefaultMaxPixels
+	}
+
+	head := &bytes.Buffer{}
+	config, _, err := image.DecodeConfig(io.TeeReader(data, head))
+	if err != nil {
+		return nil, "", errInvalidImage(err)
+	}
+
+	if config.Width*config.Height > maxPixels {
+		return nil, "", blossom.ErrTooLarge(fmt.Sprintf("the image exceeds the maximum of %d pixels", maxPixels))
+	}
+
+	img, format, err := image.Decode(io.MultiReader(head, data))
+	if err != nil {
+		return nil, "", errInvalidImage(err)
+	}
+	return img, format, nil
+}
+
+func errInvalidImage(err error) error {
+	if errors.Is(err, image.ErrFormat) {
+		return blossom.ErrUnsupportedMedia("the image format is not supported")
+	}
+	return blossom.ErrBadRequest(fmt.Sprintf("invalid image: %v", err))
+}
+
+// format returns the media type of the transcoded image, given the format name of the decoded one.
+func (t Transcoder) format(decoded string) string {
+	if t.Format != "" {
+		return t.Format
+	}
+	if decoded == "jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// fit returns the largest dimensions within the bounds that preserve the aspect ratio of w x h.
+// Images are never upscaled.
+func fit(w, h, maxW, maxH int) (int, int) {
+	if maxW > 0 && w > maxW {
+		h = max(1, h*maxW/w)
+		w = maxW
+	}
+	if maxH > 0 && h > maxH {
+		w = max(1, w*maxH/h)
+		h = maxH
+	}
+	return w, h
+}
+
+// downscale resizes the image to w x h by averaging the source pixels covered by each destination pixel.
+func downscale(img image.Image, w, h int) *image.RGBA {
+	src := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := range h {
+		y0, y1 := y*sh/h, max((y+1)*sh/h, y*sh/h+1)
+		for x := range w {
+			x0, x1 := x*sw/w, max((x+1)*sw/w, x*sw/w+1)
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					sum[0] += int(p[0])
+					sum[1] += int(p[1])
+					sum[2] += int(p[2])
+					sum[3] += int(p[3])
+				}
+			}
+
+			n := (y1 - y0) * (x1 - x0)
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0] = uint8(sum[0] / n)
+			d[1] = uint8(sum[1] / n)
+			d[2] = uint8(sum[2] / n)
+			d[3] = uint8(sum[3] / n)
+		}
+	}
+	return dst
+}
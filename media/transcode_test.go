@@ -0,0 +1,136 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"image"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+)
+
+func TestTranscode(t *testing.T) {
+	data := encoded(t, gradient(200, 100), "png")
+
+	tests := []struct {
+		name       string
+		transcoder Transcoder
+		mime       string
+		width      int
+		height     int
+	}{
+		{
+			name:       "same format",
+			transcoder: Transcoder{},
+			mime:       "image/png",
+			width:      200,
+			height:     100,
+		},
+		{
+			name:       "to jpeg",
+			transcoder: Transcoder{Format: "image/jpeg"},
+			mime:       "image/jpeg",
+			width:      200,
+			height:     100,
+		},
+		{
+			name:       "max width",
+			transcoder: Transcoder{MaxWidth: 50},
+			mime:       "image/png",
+			width:      50,
+			height:     25,
+		},
+		{
+			name:       "max width and height",
+			transcoder: Transcoder{MaxWidth: 100, MaxHeight: 20},
+			mime:       "image/png",
+			width:      40,
+			height:     20,
+		},
+		{
+			name:       "no upscaling",
+			transcoder: Transcoder{MaxWidth: 1000, MaxHeight: 1000},
+			mime:       "image/png",
+			width:      200,
+			height:     100,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			blob, hints, err := test.transcoder.Transform(context.Background(), blossy.UploadHints{}, bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer blob.Close()
+
+			transcoded, err := io.ReadAll(blob)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if hints.Type != test.mime {
+				t.Errorf("expected type %s, got %s", test.mime, hints.Type)
+			}
+			if hints.Size != int64(len(transcoded)) {
+				t.Errorf("expected size %d, got %d", len(transcoded), hints.Size)
+			}
+			if hints.Hash == nil || *hints.Hash != blossom.Hash(sha256.Sum256(transcoded)) {
+				t.Errorf("expected the hash of the transcoded blob, got %v", hints.Hash)
+			}
+
+			config, _, err := image.DecodeConfig(bytes.NewReader(transcoded))
+			if err != nil {
+				t.Fatalf("failed to decode the transcoded image: %v", err)
+			}
+			if config.Width != test.width || config.Height != test.height {
+				t.Errorf("expected %dx%d, got %dx%d", test.width, test.height, config.Width, config.Height)
+			}
+		})
+	}
+}
+
+func TestTranscodeErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		transcoder Transcoder
+		data       io.Reader
+		code       int
+	}{
+		{
+			name: "not an image",
+			data: strings.NewReader("hello world"),
+			code: 415,
+		},
+		{
+			name: "truncated image",
+			data: bytes.NewReader(encoded(t, gradient(200, 100), "png")[:100]),
+			code: 400,
+		},
+		{
+			name:       "too many pixels",
+			transcoder: Transcoder{MaxPixels: 100},
+			data:       bytes.NewReader(encoded(t, gradient(200, 100), "png")),
+			code:       413,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := test.transcoder.Transform(context.Background(), blossy.UploadHints{}, test.data)
+
+			var berr *blossom.Error
+			if !errors.As(err, &berr) {
+				t.Fatalf("expected a blossom error, got %v", err)
+			}
+			if berr.Code != test.code {
+				t.Errorf("expected code %d, got %d", test.code, berr.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errInvalidWebP = errors.New("media: invalid WebP header")
+
+// webpSize returns the dimensions of a WebP image from its header, supporting the lossy (VP8),
+// lossless (VP8L) and extended (VP8X) formats.
+// Learn more here: https://developers.google.com/speed/webp/docs/riff_container
+func webpSize(header []byte) (width, height int, err error) {
+	if len(header) < 30 || string(header[0:4]) != "RIFF" || string(header[8:12]) != "WEBP" {
+		return 0, 0, errInvalidWebP
+	}
+
+	chunk := header[20:]
+	switch string(header[12:16]) {
+	case "VP8 ":
+		// frame tag (3 bytes), start code (3 bytes), then 14-bit width and height
+		if chunk[3] != 0x9d || chunk[4] != 0x01 || chunk[5] != 0x2a {
+			return 0, 0, errInvalidWebP
+		}
+		width = int(binary.LittleEndian.Uint16(chunk[6:8]) & 0x3fff)
+		height = int(binary.LittleEndian.Uint16(chunk[8:10]) & 0x3fff)
+
+	case "VP8L":
+		// signature (1 byte), then 14-bit width - 1 and height - 1
+		if chunk[0] != 0x2f {
+			return 0, 0, errInvalidWebP
+		}
+		bits := binary.LittleEndian.Uint32(chunk[1:5])
+		width = int(bits&0x3fff) + 1
+		height = int(bits>>14&0x3fff) + 1
+
+	case "VP8X":
+		// flags (4 bytes), then 24-bit canvas width - 1 and height - 1
+		width = int(uint32(chunk[4])|uint32(chunk[5])<<8|uint32(chunk[6])<<16) + 1
+		height = int(uint32(chunk[7])|uint32(chunk[8])<<8|uint32(chunk[9])<<16) + 1
+
+	default:
+		return 0, 0, errInvalidWebP
+	}
+	return width, height, nil
+}
@@ -0,0 +1,378 @@
+// Package metrics records the operations of a blossy server and exposes them
+// in the Prometheus text exposition format, so they can be scraped by Prometheus
+// or any compatible collector.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DurationBuckets are the upper bounds (in seconds) of the request duration histogram.
+// They extend the usual Prometheus buckets to account for slow blob transfers.
+var DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Metrics holds the metrics of a blossy server. It's safe for concurrent use.
+// All methods are no-ops on a nil *Metrics.
+// Create one with [New], and expose it by registering it as an [http.Handler].
+type Metrics struct {
+	namespace string
+
+	requests   *counterVec
+	duration   *histogramVec
+	received   *counterVec
+	sent       *counterVec
+	rejections *counterVec
+	hookErrors *counterVec
+	cache      *counterVec
+	blocked    *counterVec
+	panics     *counterVec
+	retained   *counterVec
+	reclaimed  *counterVec
+	audited    *counterVec
+
+	families []family
+}
+
+// New returns a new Metrics, whose metric names are prefixed with "blossy_".
+func New() *Metrics {
+	return NewWithNamespace("blossy")
+}
+
+// NewWithNamespace returns a new Metrics, whose metric names are prefixed with the namespace.
+func NewWithNamespace(namespace string) *Metrics {
+	m := &Metrics{namespace: namespace}
+
+	m.requests = m.newCounterVec("requests_total",
+		"Total number of HTTP requests handled, by endpoint, method and status code.",
+		"endpoint", "method", "code")
+
+	m.duration = m.newHistogramVec("request_duration_seconds",
+		"Duration of the HTTP requests, by endpoint.",
+		DurationBuckets, "endpoint")
+
+	m.received = m.newCounterVec("received_bytes_total",
+		"Total number of bytes read from request bodies, by endpoint.",
+		"endpoint")
+
+	m.sent = m.newCounterVec("sent_bytes_total",
+		"Total number of bytes written to response bodies, by endpoint.",
+		"endpoint")
+
+	m.rejections = m.newCounterVec("rejections_total",
+		"Total number of requests rejected by the built-in policies or the Reject hooks, by endpoint and status code.",
+		"endpoint", "code")
+
+	m.hookErrors = m.newCounterVec("hook_errors_total",
+		"Total number of errors returned by the On hooks, by endpoint and status code.",
+		"endpoint", "code")
+
+	m.cache = m.newCounterVec("cache_lookups_total",
+		"Total number of lookups in the blob cache, by endpoint and result (hit or miss).",
+		"endpoint", "result")
+
+	m.blocked = m.newCounterVec("blocked_total",
+		"Total number of requests refused with 451 Unavailable For Legal Reasons, by endpoint.",
+		"endpoint")
+
+	m.panics = m.newCounterVec("panics_total",
+		"Total number of panics recovered while handling requests, by endpoint.",
+		"endpoint")
+
+	m.retained = m.newCounterVec("retention_deleted_blobs_total",
+		"Total number of blobs deleted by the retention policy, by rule.",
+		"rule")
+
+	m.reclaimed = m.newCounterVec("retention_reclaimed_bytes_total",
+		"Total number of bytes reclaimed by the retention policy, by rule.",
+		"rule")
+
+	m.audited = m.newCounterVec("audited_blobs_total",
+		"Total number of blobs verified by the integrity audit, by result (ok, corrupted, missing or failed).",
+		"result")
+
+	return m
+}
+
+// ObserveRequest records a handled request.
+func (m *Metrics) ObserveRequest(endpoint, method string, code int, duration time.Duration, received, sent int64) {
+	if m == nil {
+		return
+	}
+	m.requests.add(1, endpoint, method, strconv.Itoa(code))
+	m.duration.observe(duration.Seconds(), endpoint)
+	m.received.add(float64(received), endpoint)
+	m.sent.add(float64(sent), endpoint)
+}
+
+// ObserveRejection records a request rejected with the provided status code.
+// The reason of the rejection is not recorded, as it's free text of unbounded cardinality.
+func (m *Metrics) ObserveRejection(endpoint string, code int) {
+	if m == nil {
+		return
+	}
+	m.rejections.add(1, endpoint, strconv.Itoa(code))
+}
+
+// ObserveHookError records an On hook that returned an error with the provided status code.
+func (m *Metrics) ObserveHookError(endpoint string, code int) {
+	if m == nil {
+		return
+	}
+	m.hookErrors.add(1, endpoint, strconv.Itoa(code))
+}
+
+// ObserveCacheLookup records a lookup in the blob cache, which is a hit if the blob was cached.
+func (m *Metrics) ObserveCacheLookup(endpoint string, hit bool) {
+	if m == nil {
+		return
+	}
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.cache.add(1, endpoint, result)
+}
+
+// ObserveBlocked records a request refused because the blob is blocked or quarantined (451 Unavailable For Legal Reasons).
+// Blocked requests are not recorded as rejections or hook errors.
+func (m *Metrics) ObserveBlocked(endpoint string) {
+	if m == nil {
+		return
+	}
+	m.blocked.add(1, endpoint)
+}
+
+// ObservePanic records a panic recovered while handling a request to the endpoint.
+func (m *Metrics) ObservePanic(endpoint string) {
+	if m == nil {
+		return
+	}
+	m.panics.add(1, endpoint)
+}
+
+// ObserveRetention records the blobs deleted by a rule of the retention policy, and the bytes they reclaimed.
+func (m *Metrics) ObserveRetention(rule string, blobs int, bytes int64) {
+	if m == nil {
+		return
+	}
+	m.retained.add(float64(blobs), rule)
+	m.reclaimed.add(float64(bytes), rule)
+}
+
+// ObserveAudit records a blob verified by the integrity audit, with its result (ok, corrupted, missing or failed).
+func (m *Metrics) ObserveAudit(result string) {
+	if m == nil {
+		return
+	}
+	m.audited.add(1, result)
+}
+
+// ServeHTTP implements [http.Handler], serving the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := m.WriteTo(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// WriteTo writes the metrics to w in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, f := range m.families {
+		f.write(cw, m.namespace)
+	}
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, cw.w.(*bufio.Writer).Flush()
+}
+
+// family is a group of series sharing the same name, help and label names.
+type family interface {
+	write(w *countingWriter, namespace string)
+}
+
+func (m *Metrics) newCounterVec(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, series: make(map[string]*counter)}
+	m.families = append(m.families, c)
+	return c
+}
+
+func (m *Metrics) newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, buckets: buckets, labels: labels, series: make(map[string]*histogram)}
+	m.families = append(m.families, h)
+	return h
+}
+
+type counter struct {
+	values []string
+	value  float64
+}
+
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*counter
+}
+
+func (c *counterVec) add(delta float64, values ...string) {
+	key := strings.Join(values, "\xff")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.series[key]
+	if !ok {
+		s = &counter{values: values}
+		c.series[key] = s
+	}
+	s.value += delta
+}
+
+func (c *counterVec) write(w *countingWriter, namespace string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name := namespace + "_" + c.name
+	w.printf("# HELP %s %s\n", name, c.help)
+	w.printf("# TYPE %s counter\n", name)
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		w.printf("%s%s %s\n", name, formatLabels(c.labels, s.values), formatFloat(s.value))
+	}
+}
+
+type histogram struct {
+	values []string
+	counts []uint64 // cumulative counts are computed when writing
+	sum    float64
+	count  uint64
+}
+
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+func (h *histogramVec) observe(v float64, values ...string) {
+	key := strings.Join(values, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{values: values, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *histogramVec) write(w *countingWriter, namespace string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	name := namespace + "_" + h.name
+	w.printf("# HELP %s %s\n", name, h.help)
+	w.printf("# TYPE %s histogram\n", name)
+
+	labels := append(slices.Clone(h.labels), "le")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			values := append(slices.Clone(s.values), formatFloat(bound))
+			w.printf("%s_bucket%s %d\n", name, formatLabels(labels, values), cumulative)
+		}
+
+		values := append(slices.Clone(s.values), "+Inf")
+		w.printf("%s_bucket%s %d\n", name, formatLabels(labels, values), s.count)
+		w.printf("%s_sum%s %s\n", name, formatLabels(h.labels, s.values), formatFloat(s.sum))
+		w.printf("%s_count%s %d\n", name, formatLabels(h.labels, s.values), s.count)
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(names[i])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+// countingWriter counts the bytes written and remembers the first error.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) printf(format string, args ...any) {
+	if c.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(c.w, format, args...)
+	c.n += int64(n)
+	c.err = err
+}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteTo(t *testing.T) {
+	m := New()
+	m.ObserveRequest("upload", http.MethodPut, 200, 20*time.Millisecond, 1000, 200)
+	m.ObserveRequest("upload", http.MethodPut, 200, 2*time.Second, 500, 200)
+	m.ObserveRejection("upload", 429)
+	m.ObserveHookError("download", 404)
+	m.ObserveCacheLookup("download", true)
+	m.ObserveCacheLookup("download", true)
+	m.ObserveCacheLookup("check", false)
+	m.ObserveBlocked("download")
+	m.ObservePanic("upload")
+	m.ObserveRetention("max_age", 3, 4096)
+	m.ObserveAudit("corrupted")
+
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	output := b.String()
+
+	expected := []string{
+		"# TYPE blossy_requests_total counter",
+		`blossy_requests_total{endpoint="upload",method="PUT",code="200"} 2`,
+		"# TYPE blossy_request_duration_seconds histogram",
+		`blossy_request_duration_seconds_bucket{endpoint="upload",le="0.025"} 1`,
+		`blossy_request_duration_seconds_bucket{endpoint="upload",le="2.5"} 2`,
+		`blossy_request_duration_seconds_bucket{endpoint="upload",le="+Inf"} 2`,
+		`blossy_request_duration_seconds_count{endpoint="upload"} 2`,
+		`blossy_received_bytes_total{endpoint="upload"} 1500`,
+		`blossy_sent_bytes_total{endpoint="upload"} 400`,
+		`blossy_rejections_total{endpoint="upload",code="429"} 1`,
+		`blossy_hook_errors_total{endpoint="download",code="404"} 1`,
+		`blossy_cache_lookups_total{endpoint="download",result="hit"} 2`,
+		`blossy_cache_lookups_total{endpoint="check",result="miss"} 1`,
+		`blossy_blocked_total{endpoint="download"} 1`,
+		`blossy_panics_total{endpoint="upload"} 1`,
+		`blossy_retention_deleted_blobs_total{rule="max_age"} 3`,
+		`blossy_retention_reclaimed_bytes_total{rule="max_age"} 4096`,
+		`blossy_audited_blobs_total{result="corrupted"} 1`,
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(output, line+"\n") {
+			t.Errorf("expected line %q in output:\n%s", line, output)
+		}
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.ObserveRequest("upload", http.MethodPut, 200, time.Second, 10, 10)
+	m.ObserveRejection("upload", 403)
+	m.ObserveHookError("upload", 500)
+	m.ObserveCacheLookup("download", true)
+	m.ObserveBlocked("download")
+	m.ObservePanic("download")
+	m.ObserveRetention("max_age", 1, 10)
+}
+
+func TestServeHTTP(t *testing.T) {
+	m := New()
+	m.ObserveRejection("list", 401)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("unexpected content type %q", rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), `blossy_rejections_total{endpoint="list",code="401"} 1`) {
+		t.Errorf("unexpected body:\n%s", rec.Body.String())
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	got := formatLabels([]string{"a"}, []string{"x\"y\\z\n"})
+	want := `{a="x\"y\\z\n"}`
+	if got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,111 @@
+package blossy
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+// compoundTypes are the content types of the extensions made of several parts, which are not in the table
+// of the blossom package.
+var compoundTypes = map[string]string{
+	"tar.gz":  "application/x-compressed-tar",
+	"tar.bz2": "application/x-bzip-compressed-tar",
+	"tar.xz":  "application/x-xz-compressed-tar",
+	"tar.zst": "application/x-zstd-compressed-tar",
+}
+
+// compoundExts is the inverse of compoundTypes.
+var compoundExts = map[string]string{
+	"application/x-compressed-tar":      "tar.gz",
+	"application/x-bzip-compressed-tar": "tar.bz2",
+	"application/x-xz-compressed-tar":   "tar.xz",
+	"application/x-zstd-compressed-tar": "tar.zst",
+}
+
+// MimeForExt returns the content type of the extension (e.g. "avif" or "tar.gz"), which can have a leading dot.
+// If the extension is not known, it returns "application/octet-stream". See [Server.MimeForExt] for the table
+// of a server, which can be extended with [WithMimeTypes].
+func MimeForExt(ext string) string {
+	ext = normalizeExt(ext)
+	if mime, ok := compoundTypes[ext]; ok {
+		return mime
+	}
+	if i := strings.LastIndexByte(ext, '.'); i != -1 {
+		// an unknown compound extension has the type of its last part (e.g. "min.js")
+		ext = ext[i+1:]
+	}
+	return blossom.TypeFromExt(ext)
+}
+
+// ExtForMime returns the preferred extension of the content type, without the leading dot.
+// If the content type is not known, it returns "bin". See [Server.ExtForMime] for the table
+// of a server, which can be extended with [WithMimeTypes].
+func ExtForMime(mime string) string {
+	if ext, ok := compoundExts[utils.MediaType(mime)]; ok {
+		return ext
+	}
+	return blossom.ExtFromType(mime)
+}
+
+func normalizeExt(ext string) string {
+	return strings.ToLower(strings.TrimPrefix(ext, "."))
+}
+
+// mimeTable holds the extensions and the content types configured with [WithMimeTypes].
+type mimeTable struct {
+	types map[string]string // extension -> content type
+	exts  map[string]string // media type -> preferred extension
+}
+
+func newMimeTable(types map[string]string) *mimeTable {
+	t := &mimeTable{
+		types: make(map[string]string, len(types)),
+		exts:  make(map[string]string, len(types)),
+	}
+
+	for ext, mime := range types {
+		t.types[normalizeExt(ext)] = mime
+	}
+
+	// the preferred extension of a type is the shortest, then the first in alphabetical order
+	exts := make([]string, 0, len(t.types))
+	for ext := range t.types {
+		exts = append(exts, ext)
+	}
+	slices.SortFunc(exts, func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(a), len(b)), strings.Compare(a, b))
+	})
+
+	for _, ext := range exts {
+		mediaType := utils.MediaType(t.types[ext])
+		if _, ok := t.exts[mediaType]; !ok {
+			t.exts[mediaType] = ext
+		}
+	}
+	return t
+}
+
+// MimeForExt is like [MimeForExt], but the extensions configured with [WithMimeTypes] take precedence.
+func (s *Server) MimeForExt(ext string) string {
+	if t := s.settings.Sys.mimeTypes; t != nil {
+		if mime, ok := t.types[normalizeExt(ext)]; ok {
+			return mime
+		}
+	}
+	return MimeForExt(ext)
+}
+
+// ExtForMime is like [ExtForMime], but the content types configured with [WithMimeTypes] take precedence.
+// It's used to derive the URLs of the blob descriptors and the filenames of the served blobs.
+func (s *Server) ExtForMime(mime string) string {
+	if t := s.settings.Sys.mimeTypes; t != nil {
+		if ext, ok := t.exts[utils.MediaType(mime)]; ok {
+			return ext
+		}
+	}
+	return ExtForMime(mime)
+}
@@ -0,0 +1,201 @@
+package blossy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy/auth"
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+// ErrBlobTooLarge is returned when a blob exceeds the maximum allowed size.
+var ErrBlobTooLarge = errors.New("blob is too large")
+
+// mirrorClient is the http client used by [MirrorFetch].
+// The timeout is generous, as it must allow downloading large blobs.
+var mirrorClient = &http.Client{Timeout: 10 * time.Minute}
+
+// MirroredBlob is a blob being downloaded from a remote server by [MirrorFetch].
+// Reading it streams the remote blob, verifying its size and sha256 hash:
+// reading the end returns [utils.ErrHashMismatch] instead of [io.EOF] if the hash doesn't match,
+// and reading more than the maximum size returns [ErrBlobTooLarge].
+//
+// It must be closed after use.
+type MirroredBlob struct {
+	// Hash is the sha256 of the blob, as specified by the URL.
+	Hash blossom.Hash
+
+	// Type is the content type of the blob, from the 'Content-Type' header of the remote server,
+	// or from the extension of the URL if the remote server didn't specify one.
+	// If unknown, it will be an empty string.
+	Type string
+
+	// Size is the size in bytes of the blob, as reported by the remote server.
+	// If unknown, it will be -1.
+	Size int64
+
+	body   io.ReadCloser
+	reader io.Reader
+}
+
+func (b *MirroredBlob) Read(p []byte) (int, error) { return b.reader.Read(p) }
+func (b *MirroredBlob) Close() error               { return b.body.Close() }
+
+// Hints returns the [UploadHints] of the blob, which can be forwarded to a [Store].
+func (b *MirroredBlob) Hints() UploadHints {
+	hash := b.Hash
+	return UploadHints{Hash: &hash, Type: b.Type, Size: b.Size}
+}
+
+// MirrorFetch downloads the blob at the URL of a PUT /mirror request (BUD-04), returning
+// a [MirroredBlob] that streams and verifies it, without buffering it.
+// If maxSize is > 0, blobs larger than maxSize bytes are rejected with [ErrBlobTooLarge],
+// before the download if the remote server reports their size.
+//
+// Example of a Mirror hook that stores the blob:
+//
+//	server.On.Mirror = func(r blossy.Request, url *url.URL) (blossom.BlobDescriptor, *blossom.Error) {
+//	    blob, err := blossy.MirrorFetch(r.Context(), url, 100<<20)
+//	    if err != nil {
+//	        return blossom.BlobDescriptor{}, blossom.ErrBadRequest(err.Error())
+//	    }
+//	    defer blob.Close()
+//
+//	    desc, err := store.Save(r.Context(), r.Pubkey(), blob.Hints(), blob)
+//	    ...
+//	}
+//
+// IMPORTANT: the URL is chosen by the client, so the request can target any address reachable by the server.
+// Reject URLs pointing to private networks with a Reject.Mirror hook if that's a concern.
+//
+// To mirror blobs from servers that require authorization, use [Server.MirrorFetch] with [WithMirrorSigner].
+func MirrorFetch(ctx context.Context, u *url.URL, maxSize int64) (*MirroredBlob, error) {
+	return mirrorFetch(ctx, u, maxSize, nil, MimeForExt)
+}
+
+// MirrorFetch is like [MirrorFetch], but if the remote server responds with 401 (Unauthorized),
+// the download is repeated with an authorization event (kind 24242) signed by the signer of the server
+// (see [WithMirrorSigner]), so that blobs can be mirrored from servers that require authorization.
+// The event is bound to the hash of the blob and to the hostname of the remote server.
+// The type of the URL extension is resolved with [Server.MimeForExt], so it follows [WithMimeTypes].
+func (s *Server) MirrorFetch(ctx context.Context, u *url.URL, maxSize int64) (*MirroredBlob, error) {
+	return mirrorFetch(ctx, u, maxSize, s.settings.Sys.mirrorSigner, s.MimeForExt)
+}
+
+// mirrorAuthExpiration is the validity of the authorization events of the mirror fetches.
+const mirrorAuthExpiration = 5 * time.Minute
+
+func mirrorFetch(ctx context.Context, u *url.URL, maxSize int64, signer auth.Signer, mimeForExt func(string) string) (*MirroredBlob, error) {
+	hash, ext, err := utils.ParseHashExt(u.Path)
+	if err != nil {
+		return nil, fmt.Errorf("mirror: invalid blossom URL: %w", err)
+	}
+
+	res, err := mirrorGet(ctx, u, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusUnauthorized && signer != nil {
+		res.Body.Close()
+		expiration := time.Now().Add(mirrorAuthExpiration)
+		header, err := auth.SignBlossomAuth(ctx, signer, auth.ActionGet, []blossom.Hash{hash}, []string{u.Hostname()}, expiration)
+		if err != nil {
+			return nil, fmt.Errorf("mirror: %w", err)
+		}
+
+		if res, err = mirrorGet(ctx, u, header); err != nil {
+			return nil, err
+		}
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("mirror: remote server responded with status %d", res.StatusCode)
+	}
+	if maxSize > 0 && res.ContentLength > maxSize {
+		res.Body.Close()
+		return nil, fmt.Errorf("mirror: %w: %d bytes", ErrBlobTooLarge, res.ContentLength)
+	}
+
+	mediaType, err := mirrorType(res.Header.Get("Content-Type"), ext, mimeForExt)
+	if err != nil {
+		res.Body.Close()
+		return nil, fmt.Errorf("mirror: %w", err)
+	}
+
+	var reader io.Reader = res.Body
+	if maxSize > 0 {
+		reader = &sizeLimiter{r: reader, max: maxSize}
+	}
+
+	return &MirroredBlob{
+		Hash:   hash,
+		Type:   mediaType,
+		Size:   res.ContentLength,
+		body:   res.Body,
+		reader: utils.NewHashReader(reader, &hash),
+	}, nil
+}
+
+// mirrorGet sends the GET request of the blob at the URL, with the 'Authorization' header if not empty.
+func mirrorGet(ctx context.Context, u *url.URL, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("mirror: %w", err)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	res, err := mirrorClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("mirror: failed to fetch the blob: %w", err)
+	}
+	return res, nil
+}
+
+// mirrorType returns the media type of the 'Content-Type' header of a remote server,
+// falling back to the type of the URL extension, resolved with mimeForExt, when the header is missing or generic.
+func mirrorType(header, ext string, mimeForExt func(string) string) (string, error) {
+	if header != "" && header != "application/octet-stream" {
+		mediaType, _, err := mime.ParseMediaType(header)
+		if err != nil {
+			return "", fmt.Errorf("remote server responded with an invalid 'Content-Type': %w", err)
+		}
+		return mediaType, nil
+	}
+
+	if ext != "" {
+		if mediaType := mimeForExt(ext); mediaType != "application/octet-stream" {
+			return mediaType, nil
+		}
+	}
+	return header, nil
+}
+
+// sizeLimiter returns [ErrBlobTooLarge] when more than max bytes are read.
+type sizeLimiter struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (l *sizeLimiter) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max {
+		return n, ErrBlobTooLarge
+	}
+	return n, err
+}
+
+// exceeded returns whether more than max bytes have been read.
+func (l *sizeLimiter) exceeded() bool { return l.n > l.max }
@@ -0,0 +1,56 @@
+package blossy
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+// isMultipart reports whether the body of the request is a multipart form.
+func isMultipart(r *http.Request) bool {
+	media, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && media == "multipart/form-data"
+}
+
+// filePart returns the first part of the multipart body of the request that is a file,
+// skipping the other form fields without buffering them.
+func filePart(r *http.Request) (*multipart.Part, *blossom.Error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, blossom.ErrBadRequest("failed to parse multipart body: " + err.Error())
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, blossom.ErrBadRequest("multipart body doesn't contain a file")
+		}
+		if err != nil {
+			return nil, blossom.ErrBadRequest("failed to parse multipart body: " + err.Error())
+		}
+
+		if part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// partType returns the content type of the file part. If the part doesn't declare a specific one,
+// the type is derived from the extension of its filename, and it's empty if that's unknown too.
+func partType(part *multipart.Part) string {
+	typ := part.Header.Get("Content-Type")
+	if typ != "" && typ != "application/octet-stream" {
+		return typ
+	}
+
+	if byExt := mime.TypeByExtension(filepath.Ext(part.FileName())); byExt != "" {
+		return byExt
+	}
+	return typ
+}
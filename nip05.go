@@ -0,0 +1,80 @@
+package blossy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+// NIP05Path is the path of the NIP-05 identifiers, enabled with [WithNIP05] or [WithNIP05Names].
+// Learn more here: https://github.com/nostr-protocol/nips/blob/master/05.md
+const NIP05Path = "/.well-known/nostr.json"
+
+// NIP05Resolver returns the hex encoded pubkey of the name (the local part of a NIP-05 identifier like "bob"
+// in "bob@example.com") and optionally the relays where it can be found.
+// If the name is unknown, it returns an empty pubkey and a nil error.
+type NIP05Resolver func(ctx context.Context, name string) (pubkey string, relays []string, err error)
+
+// nip05Response is the JSON document served at [NIP05Path].
+type nip05Response struct {
+	Names  map[string]string   `json:"names"`
+	Relays map[string][]string `json:"relays,omitempty"`
+}
+
+// HandleNIP05 handles GET /.well-known/nostr.json?name=<name>, enabled with [WithNIP05] or [WithNIP05Names].
+// Names are case-insensitive, and unknown names are answered with an empty document, as NIP-05 requires.
+func (s *Server) HandleNIP05(w http.ResponseWriter, r *http.Request) {
+	response := nip05Response{Names: map[string]string{}}
+
+	if name := strings.ToLower(r.URL.Query().Get("name")); name != "" {
+		pubkey, relays, err := s.settings.HTTP.nip05(r.Context(), name)
+		if err != nil {
+			s.logger(r).Error("failed to resolve the NIP-05 name", "name", name, "error", err)
+			blossom.WriteError(w, blossom.ErrInternal("failed to resolve the name"))
+			return
+		}
+
+		if pubkey != "" {
+			response.Names[name] = pubkey
+			if len(relays) > 0 {
+				response.Relays = map[string][]string{pubkey: relays}
+			}
+		}
+	}
+
+	// NIP-05 requires the document to be readable by any web client
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.logger(r).Error("failed to encode the NIP-05 document", "error", err)
+	}
+}
+
+// isNIP05 reports whether the request is for the NIP-05 document, and the endpoint is enabled.
+func (s *Server) isNIP05(r *http.Request) bool {
+	return s.settings.HTTP.nip05 != nil && r.URL.Path == NIP05Path && r.Method == http.MethodGet
+}
+
+// staticNIP05 returns a [NIP05Resolver] of the names, whose keys must be lowercase.
+func staticNIP05(names map[string]string) NIP05Resolver {
+	return func(ctx context.Context, name string) (string, []string, error) {
+		return names[name], nil, nil
+	}
+}
+
+// validNIP05Name reports whether the name contains only the characters allowed by NIP-05.
+func validNIP05Name(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package blossy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+)
+
+// FileMetadata is the optional metadata of a blob, returned in the 'nip94' field of the blob descriptor
+// of PUT /upload, PUT /media and PUT /mirror responses, as per BUD-08. Empty fields are omitted.
+// Learn more here: https://github.com/hzrd149/blossom/blob/master/buds/08.md
+type FileMetadata struct {
+	// OriginalHash is the sha256 of the blob before the server transformed it (e.g. PUT /media).
+	// If nil, it is the hash of the blob.
+	OriginalHash *blossom.Hash
+
+	// Width and Height are the dimensions in pixels of images and videos.
+	Width  int
+	Height int
+
+	// Blurhash is a compact representation of the image, displayed while it loads.
+	Blurhash string
+
+	// Thumb is the URL of a thumbnail of the blob.
+	Thumb string
+
+	// Alt is the description of the blob for accessibility.
+	Alt string
+
+	// Summary is an excerpt of the blob content.
+	Summary string
+
+	// Extra are additional NIP-94 tags, appended as they are.
+	Extra nostr.Tags
+}
+
+// NIP94Tags returns the NIP-94 tags of the blob: the "url", "m", "x", "ox" and "size" tags
+// from the descriptor, and the tags of the metadata.
+// Learn more here: https://github.com/nostr-protocol/nips/blob/master/94.md
+func NIP94Tags(desc blossom.BlobDescriptor, meta FileMetadata) nostr.Tags {
+	original := desc.Hash
+	if meta.OriginalHash != nil {
+		original = *meta.OriginalHash
+	}
+
+	tags := nostr.Tags{
+		{"url", desc.URL},
+		{"m", desc.Type},
+		{"x", desc.Hash.Hex()},
+		{"ox", original.Hex()},
+		{"size", strconv.FormatInt(desc.Size, 10)},
+	}
+
+	if meta.Width > 0 && meta.Height > 0 {
+		tags = append(tags, nostr.Tag{"dim", strconv.Itoa(meta.Width) + "x" + strconv.Itoa(meta.Height)})
+	}
+	if meta.Blurhash != "" {
+		tags = append(tags, nostr.Tag{"blurhash", meta.Blurhash})
+	}
+	if meta.Thumb != "" {
+		tags = append(tags, nostr.Tag{"thumb", meta.Thumb})
+	}
+	if meta.Alt != "" {
+		tags = append(tags, nostr.Tag{"alt", meta.Alt})
+	}
+	if meta.Summary != "" {
+		tags = append(tags, nostr.Tag{"summary", meta.Summary})
+	}
+	return append(tags, meta.Extra...)
+}
+
+// writeDescriptor writes the blob descriptor as JSON, adding the 'nip94' field (BUD-08)
+// when the NIP94 hook returns metadata for it.
+// The original hash is the one of the blob before it was transformed, if known.
+func (s *Server) writeDescriptor(w http.ResponseWriter, r Request, desc blossom.BlobDescriptor, original *blossom.Hash) {
+	if state, ok := stateOf(r.Raw()); ok {
+		state.hash = &desc.Hash
+	}
+
+	var body any = desc
+	if s.On.NIP94 != nil {
+		if m := s.On.NIP94(r, desc); m != nil {
+			meta := *m
+			if meta.OriginalHash == nil {
+				meta.OriginalHash = original
+			}
+			withMeta, err := withNIP94(desc, NIP94Tags(desc, meta))
+			if err != nil {
+				s.logger(r.Raw()).Error("failed to add NIP-94 metadata", "error", err, "hash", desc.Hash)
+			} else {
+				body = withMeta
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		s.logger(r.Raw()).Error("failed to encode blob descriptor", "error", err, "hash", desc.Hash)
+	}
+}
+
+// withNIP94 returns the JSON fields of the descriptor with the 'nip94' field added.
+func withNIP94(desc blossom.BlobDescriptor, tags nostr.Tags) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(desc)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	fields["nip94"], err = json.Marshal(tags)
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
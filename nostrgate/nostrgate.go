@@ -0,0 +1,276 @@
+// Package nostrgate restricts a blossom server to the social graph of a pubkey,
+// by following its follow list (NIP-02) on a set of relays.
+//
+// A [Gate] maintains the set of allowed pubkeys: the root pubkey, the pubkeys it follows and,
+// with [WithDepth], the pubkeys they follow in turn. It can be plugged into the server as a Reject hook
+// (see [AllowFollowsOf]) or as a pubkey policy with [blossy.WithPubkeyPolicy].
+//
+// Example:
+//
+//	gate, err := nostrgate.New(operator, []string{"wss://relay.damus.io", "wss://nos.lol"})
+//	if err != nil {
+//	    panic(err)
+//	}
+//	go gate.Run(ctx)
+//
+//	server, err := blossy.NewServer(
+//	    blossy.WithPubkeyPolicy(gate.Allowed, blossy.EndpointUpload, blossy.EndpointMedia),
+//	)
+package nostrgate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+const (
+	// DefaultRefresh is how often the follow graph is refreshed, if not configured.
+	DefaultRefresh = 30 * time.Minute
+
+	// fetchTimeout is the maximum duration of a fetch from the relays.
+	fetchTimeout = 15 * time.Second
+
+	// batchSize is the maximum number of authors in a single filter.
+	batchSize = 500
+)
+
+// Gate maintains the set of pubkeys in the follow graph of a root pubkey. Create one with [New].
+// It's safe for concurrent use.
+type Gate struct {
+	root    string
+	relays  []string
+	depth   int
+	refresh time.Duration
+	pool    *nostr.SimplePool
+
+	mu      sync.RWMutex
+	allowed map[string]struct{}
+	updated time.Time
+}
+
+type Option func(*Gate)
+
+// WithDepth sets the depth of the follow graph: 1 allows the pubkeys followed by the root (default),
+// 2 also allows the pubkeys they follow. Must be 1 or 2.
+func WithDepth(depth int) Option {
+	return func(g *Gate) {
+		g.depth = depth
+	}
+}
+
+// WithRefresh sets how often the whole follow graph is refreshed.
+// Changes to the follow list of the root are applied as soon as they are received, regardless of this interval.
+// If not set, [DefaultRefresh] is used.
+func WithRefresh(d time.Duration) Option {
+	return func(g *Gate) {
+		g.refresh = d
+	}
+}
+
+// New returns a Gate for the follow graph of the root pubkey, fetched from the provided relays.
+// The gate is empty, except for the root, until [Gate.Refresh] or [Gate.Run] is called.
+func New(root string, relays []string, opts ...Option) (*Gate, error) {
+	g := &Gate{
+		root:    root,
+		relays:  relays,
+		depth:   1,
+		refresh: DefaultRefresh,
+		allowed: map[string]struct{}{root: {}},
+	}
+
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	if err := utils.ValidatePubkey(root); err != nil {
+		return nil, fmt.Errorf("nostrgate: invalid root pubkey: %w", err)
+	}
+	if len(relays) == 0 {
+		return nil, errors.New("nostrgate: at least one relay must be provided")
+	}
+	if g.depth < 1 || g.depth > 2 {
+		return nil, errors.New("nostrgate: depth must be 1 or 2")
+	}
+	if g.refresh < time.Minute {
+		return nil, errors.New("nostrgate: refresh interval must be at least 1 minute")
+	}
+
+	// relays are connected lazily, on the first fetch
+	g.pool = nostr.NewSimplePool(context.Background())
+	return g, nil
+}
+
+// AllowFollowsOf returns a Reject hook for uploads that only allows the pubkey and the pubkeys it follows,
+// as published on the provided relays. Unauthenticated uploads are rejected with 401 (Unauthorized),
+// and uploads from pubkeys outside the follow graph with 403 (Forbidden).
+//
+// The follow graph is kept up to date in the background for the lifetime of the program.
+// Use [New] and [Gate.Run] to control its lifetime instead.
+// It panics if the pubkey is invalid or no relay is provided.
+//
+// Example:
+//
+//	server.Reject.Upload.Append(nostrgate.AllowFollowsOf(operator, "wss://relay.damus.io"))
+func AllowFollowsOf(pubkey string, relays ...string) func(r blossy.Request, hints blossy.UploadHints) *blossom.Error {
+	gate, err := New(pubkey, relays)
+	if err != nil {
+		panic(err)
+	}
+
+	go gate.Run(context.Background())
+	return gate.RejectUpload
+}
+
+// Allowed reports whether the pubkey is in the follow graph. It can be used as a [blossy.PubkeyPolicy].
+func (g *Gate) Allowed(pubkey string) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	_, ok := g.allowed[pubkey]
+	return ok
+}
+
+// Size returns the number of pubkeys in the follow graph, including the root.
+func (g *Gate) Size() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return len(g.allowed)
+}
+
+// Updated returns the time of the last successful refresh, or the zero time if there was none.
+func (g *Gate) Updated() time.Time {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.updated
+}
+
+// RejectUpload is a Reject hook for uploads that only allows the pubkeys in the follow graph.
+func (g *Gate) RejectUpload(r blossy.Request, hints blossy.UploadHints) *blossom.Error {
+	if !r.IsAuthed() {
+		return blossom.ErrUnauthorized("authorization is required")
+	}
+	if !g.Allowed(r.Pubkey()) {
+		return blossom.ErrForbidden("pubkey is not in the follow graph of this server")
+	}
+	return nil
+}
+
+// Run refreshes the follow graph, and keeps it up to date until the context is cancelled,
+// subscribing to the follow list of the root and refreshing the whole graph periodically.
+// Failed refreshes keep the previous graph. It always returns the context error.
+func (g *Gate) Run(ctx context.Context) error {
+	g.Refresh(ctx)
+
+	since := nostr.Now()
+	filter := nostr.Filter{
+		Kinds:   []int{nostr.KindFollowList},
+		Authors: []string{g.root},
+		Since:   &since,
+	}
+	updates := g.pool.SubscribeMany(ctx, g.relays, filter)
+
+	ticker := time.NewTicker(g.refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-ticker.C:
+			g.Refresh(ctx)
+
+		case event, ok := <-updates:
+			if !ok {
+				// all relays closed the subscription, rely on the periodic refresh
+				updates = nil
+				continue
+			}
+			if event.CreatedAt.Time().After(g.Updated()) {
+				g.Refresh(ctx)
+			}
+		}
+	}
+}
+
+// Refresh fetches the follow graph from the relays, and replaces the current one.
+// If the follow list of the root is not found, the current graph is kept and an error is returned.
+func (g *Gate) Refresh(ctx context.Context) error {
+	lists := g.fetchFollowLists(ctx, []string{g.root})
+	rootList, ok := lists[g.root]
+	if !ok {
+		return errors.New("nostrgate: follow list of the root pubkey not found")
+	}
+
+	allowed := map[string]struct{}{g.root: {}}
+	follows := followsOf(rootList)
+	for _, pk := range follows {
+		allowed[pk] = struct{}{}
+	}
+
+	if g.depth > 1 {
+		for _, list := range g.fetchFollowLists(ctx, follows) {
+			for _, pk := range followsOf(list) {
+				allowed[pk] = struct{}{}
+			}
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.allowed = allowed
+	g.updated = time.Now()
+	return nil
+}
+
+// fetchFollowLists returns the latest follow list of each of the authors found on the relays.
+func (g *Gate) fetchFollowLists(ctx context.Context, authors []string) map[string]*nostr.Event {
+	lists := make(map[string]*nostr.Event, len(authors))
+
+	for start := 0; start < len(authors); start += batchSize {
+		batch := authors[start:min(start+batchSize, len(authors))]
+		filter := nostr.Filter{
+			Kinds:   []int{nostr.KindFollowList},
+			Authors: batch,
+		}
+
+		fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+		for event := range g.pool.FetchMany(fetchCtx, g.relays, filter) {
+			addLatest(lists, event.Event)
+		}
+		cancel()
+	}
+	return lists
+}
+
+// addLatest adds the event to the lists, if it's more recent than the one of the same author.
+func addLatest(lists map[string]*nostr.Event, event *nostr.Event) {
+	if event == nil || event.Kind != nostr.KindFollowList {
+		return
+	}
+	if current, ok := lists[event.PubKey]; ok && current.CreatedAt >= event.CreatedAt {
+		return
+	}
+	lists[event.PubKey] = event
+}
+
+// followsOf returns the valid pubkeys in the "p" tags of the follow list.
+func followsOf(list *nostr.Event) []string {
+	follows := make([]string, 0, len(list.Tags))
+	for _, tag := range list.Tags {
+		if len(tag) < 2 || tag[0] != "p" {
+			continue
+		}
+		if utils.ValidatePubkey(tag[1]) == nil {
+			follows = append(follows, tag[1])
+		}
+	}
+	return follows
+}
@@ -0,0 +1,100 @@
+package nostrgate
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossy"
+)
+
+const (
+	root  = "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+	alice = "c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5"
+	bob   = "f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9"
+)
+
+func TestNew(t *testing.T) {
+	relays := []string{"wss://relay.example.com"}
+
+	if _, err := New("invalid", relays); err == nil {
+		t.Error("expected error for invalid root, got nil")
+	}
+	if _, err := New(root, nil); err == nil {
+		t.Error("expected error for no relays, got nil")
+	}
+	if _, err := New(root, relays, WithDepth(3)); err == nil {
+		t.Error("expected error for depth 3, got nil")
+	}
+
+	gate, err := New(root, relays)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !gate.Allowed(root) || gate.Size() != 1 {
+		t.Errorf("expected only the root to be allowed before the first refresh")
+	}
+}
+
+func TestFollowsOf(t *testing.T) {
+	list := &nostr.Event{
+		Kind:   nostr.KindFollowList,
+		PubKey: root,
+		Tags: nostr.Tags{
+			{"p", alice},
+			{"p", "invalid"},
+			{"e", bob},
+			{"p"},
+			{"p", bob, "wss://relay.example.com", "bob"},
+		},
+	}
+
+	follows := followsOf(list)
+	if len(follows) != 2 || follows[0] != alice || follows[1] != bob {
+		t.Errorf("expected [alice bob], got %v", follows)
+	}
+}
+
+func TestAddLatest(t *testing.T) {
+	lists := make(map[string]*nostr.Event)
+	older := &nostr.Event{Kind: nostr.KindFollowList, PubKey: root, CreatedAt: 100}
+	newer := &nostr.Event{Kind: nostr.KindFollowList, PubKey: root, CreatedAt: 200}
+	other := &nostr.Event{Kind: nostr.KindTextNote, PubKey: alice, CreatedAt: 300}
+
+	addLatest(lists, newer)
+	addLatest(lists, older)
+	addLatest(lists, other)
+	addLatest(lists, nil)
+
+	if len(lists) != 1 || lists[root] != newer {
+		t.Errorf("expected only the newer follow list, got %v", lists)
+	}
+}
+
+func TestRejectUpload(t *testing.T) {
+	gate, err := New(root, []string{"wss://relay.example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	gate.allowed[alice] = struct{}{}
+
+	tests := []struct {
+		pubkey string
+		code   int
+	}{
+		{root, 0},
+		{alice, 0},
+		{bob, http.StatusForbidden},
+		{"", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		err := gate.RejectUpload(blossy.NewTestRequest(blossy.RequestPubkey(test.pubkey)), blossy.UploadHints{})
+		switch {
+		case test.code == 0 && err != nil:
+			t.Errorf("pubkey %q: expected no error, got %v", test.pubkey, err)
+		case test.code != 0 && (err == nil || err.Code != test.code):
+			t.Errorf("pubkey %q: expected code %d, got %v", test.pubkey, test.code, err)
+		}
+	}
+}
@@ -1,11 +1,22 @@
 package blossy
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
+	"io/fs"
 	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy/auth"
+	"github.com/pippellia-btc/blossy/metrics"
+	"github.com/pippellia-btc/blossy/ratelimit"
 	"github.com/pippellia-btc/blossy/utils"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Option func(*Server)
@@ -23,6 +34,87 @@ func WithHostname(hostname string) Option {
 	}
 }
 
+// WithHostnames sets the hostnames of a server that answers on several domains (virtual hosts).
+// The first is the primary hostname (see [WithHostname]), the others are its aliases.
+//
+// Authorization events bound to any of the hostnames are accepted, and the URLs of the blob descriptors
+// are derived from the Host header of the request when it matches one of them, falling back to the primary hostname.
+func WithHostnames(hostnames ...string) Option {
+	return func(s *Server) {
+		if len(hostnames) == 0 {
+			return
+		}
+		s.settings.Sys.hostname = hostnames[0]
+		s.settings.Sys.aliases = hostnames[1:]
+		s.settings.Auth.Aliases = hostnames[1:]
+	}
+}
+
+// WithURLBuilder sets the function that builds the URL of the blob descriptors returned by the Upload, Media,
+// Mirror and List endpoints, when the hooks leave it empty. By default, the URL is https://<hostname>/<sha256>.<ext>.
+// It can be used to point clients to a CDN, to sharded subdomains or to a different path.
+//
+// Example:
+//
+//	WithURLBuilder(func(desc blossom.BlobDescriptor) string {
+//	    return "https://cdn.example.com/" + desc.Hash.Hex()
+//	})
+func WithURLBuilder(build func(desc blossom.BlobDescriptor) string) Option {
+	return func(s *Server) {
+		s.settings.Sys.urlBuilder = build
+	}
+}
+
+// WithBlobCache caches the blobs served by the Download hook, so that hot blobs are served
+// without invoking the hook. The Check hook is also skipped for cached blobs, and blobs are removed
+// from the cache when they are deleted with DELETE /<sha256>. Blobs deleted from the storage in any other way
+// must be removed with [Server.Evict], or the cache keeps serving them.
+// The built-in policies and the Reject hooks are always applied, as they are before the cache.
+// Blobs served with a [CacheControl] that contains "no-store" or "private" are not cached.
+// See the blossy/cache package for in-memory and on-disk caches.
+func WithBlobCache(cache BlobCache) Option {
+	return func(s *Server) {
+		s.settings.Sys.cache = cache
+	}
+}
+
+// WithMimeTypes extends the table of the extensions and their content types (e.g. {"cbz": "application/vnd.comicbook+zip"}),
+// which is used to derive the URLs of the blob descriptors and the filenames of the served blobs.
+// The configured extensions take precedence over the defaults. When several extensions have the same type,
+// the shortest is the preferred one. See [Server.MimeForExt] and [Server.ExtForMime].
+func WithMimeTypes(types map[string]string) Option {
+	return func(s *Server) {
+		s.settings.Sys.mimeTypes = newMimeTable(types)
+	}
+}
+
+// WithSingleflight shares a single invocation of the Download hook among the concurrent requests of a blob
+// that is not cached, so that a burst of requests for a cold blob doesn't reach the backing store once per request.
+// The first request fetches and caches the blob, while the others wait and are then served from the cache.
+// Errors are never shared: if the first request fails, or the blob is not cached (e.g. it's too large),
+// the others invoke the hook themselves.
+//
+// It requires a blob cache (see [WithBlobCache]), and the Download hook must not depend on the request
+// other than for the hash, as its result is shared.
+func WithSingleflight() Option {
+	return func(s *Server) {
+		s.settings.Sys.flights = newFlights()
+	}
+}
+
+// WithIPGrouping sets the prefixes used by [IP.Group] to group the IPs of the requests,
+// which are the default key of rate limits (see [KeyByIP]) and bans (see [WithIPPolicy]).
+//
+// By default, IPv4 addresses are not grouped (/32), and IPv6 addresses are grouped by their /64 subnet.
+// Larger groups, like /24 for IPv4 and /48 for IPv6, make it harder for clients to evade limits
+// by switching address, at the cost of grouping unrelated clients that share the same block.
+func WithIPGrouping(v4, v6 int) Option {
+	return func(s *Server) {
+		s.settings.Sys.ipv4Prefix = v4
+		s.settings.Sys.ipv6Prefix = v6
+	}
+}
+
 // WithLogger sets the structured logger (*slog.Logger) used by the server for all logging operations.
 // If not set, a default logger will be used.
 func WithLogger(l *slog.Logger) Option {
@@ -31,6 +123,145 @@ func WithLogger(l *slog.Logger) Option {
 	}
 }
 
+// WithMetrics enables the recording of the server operations in the provided [metrics.Metrics]:
+// request counts, latencies, bytes received and sent, rejections and hook errors per endpoint.
+//
+// The metrics are not served by the blossom server itself. Expose them by mounting
+// the [metrics.Metrics] (which is an [http.Handler]) on a private address or path.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(s *Server) {
+		s.metrics = m
+	}
+}
+
+// WithTracerProvider enables OpenTelemetry tracing with the tracers of the provider.
+// The server records a span for each request, with the endpoint, the method, the status code,
+// the hash of the blob and the first 8 characters of the pubkey of authenticated requests.
+// The invocations of the On hooks and the calls to the store bound with [BindStore] are recorded as child spans,
+// and hooks can add their own spans as children of the context of the request ([Request.Context]).
+//
+// Requests carrying the trace context of the client continue its trace, using the global propagator
+// (see [go.opentelemetry.io/otel.SetTextMapPropagator]).
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(s *Server) {
+		s.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// WithRequestIDHeader makes the server echo the ID of every request (see [Request.ID])
+// in the 'X-Request-ID' response header, which is useful to correlate client reports with server logs.
+func WithRequestIDHeader() Option {
+	return func(s *Server) {
+		s.settings.HTTP.requestIDHeader = true
+	}
+}
+
+// WithHandlerTimeout sets a deadline of d on the context of each request ([Request.Context]),
+// so that hooks and stores that respect it give up on slow operations instead of holding the connection.
+// Server errors returned by the On hooks after the deadline has expired are converted into 504 (Gateway Timeout).
+//
+// The deadline includes the transfer of the blobs of uploads and downloads whose store is bound to the context
+// (e.g. an object storage), so it should be long enough for the largest blobs on slow connections.
+func WithHandlerTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.settings.HTTP.handlerTimeout = d
+	}
+}
+
+// WithDownloadRateLimit caps the bandwidth of all the downloads of blobs combined at bytesPerSec,
+// so that operators on metered connections can bound their egress costs.
+// Downloads that exceed it are slowed down rather than rejected.
+// See also [WithPerConnectionRateLimit].
+func WithDownloadRateLimit(bytesPerSec int64) Option {
+	return func(s *Server) {
+		s.settings.HTTP.egressRate = bytesPerSec
+		s.settings.HTTP.egress = nil
+		if bytesPerSec > 0 {
+			s.settings.HTTP.egress = newByteBucket(bytesPerSec)
+		}
+	}
+}
+
+// WithPerConnectionRateLimit caps the bandwidth of the downloads of blobs over each connection at bytesPerSec,
+// so that a single client can't use all the bandwidth available with [WithDownloadRateLimit].
+// The downloads sharing a connection (e.g. the parallel streams of HTTP/2) share the limit.
+//
+// Connections are tracked by the [http.Server] used by [Server.StartAndServe] and [Server.Serve].
+// When the server is mounted on another [http.Server], set its ConnContext to [Server.ConnContext],
+// otherwise every download gets its own limit.
+func WithPerConnectionRateLimit(bytesPerSec int64) Option {
+	return func(s *Server) {
+		s.settings.HTTP.egressPerConn = bytesPerSec
+	}
+}
+
+// WithWorkers enables the job queue of the server, whose jobs are run by n workers while the server is serving.
+// Hooks can then queue follow-up tasks (e.g. thumbnails, replication or scanning) with [Server.Enqueue].
+func WithWorkers(n int) Option {
+	return func(s *Server) { s.settings.Sys.workers = n }
+}
+
+// WithJobQueueSize sets the maximum number of jobs waiting to be run, after which [Server.Enqueue]
+// returns [ErrQueueFull]. The default is [DefaultJobQueueSize].
+func WithJobQueueSize(n int) Option {
+	return func(s *Server) { s.settings.Sys.jobQueueSize = n }
+}
+
+// WithJobRetries sets the number of times a failing job is run, and the wait before the first retry,
+// which doubles at every retry. The defaults are [DefaultJobAttempts] and [DefaultJobBackoff].
+func WithJobRetries(attempts int, backoff time.Duration) Option {
+	return func(s *Server) {
+		s.settings.Sys.jobAttempts = attempts
+		s.settings.Sys.jobBackoff = backoff
+	}
+}
+
+// WithEventStream enables a stream of the activity of the server at the provided path (the default is [DefaultEventsPath]),
+// so that dashboards and moderation bots can react to uploads, deletions, reports and rejections in real time.
+// The stream is sent as Server-Sent Events (see [Server.HandleEvents] and [Event]).
+//
+// Subscribing requires an authorization event (kind 24242 or 27519) signed by one of the admins' pubkeys.
+// Events are not stored: subscribers only receive the ones emitted while they are connected,
+// and slow subscribers miss the events they can't keep up with.
+// The stream ends when the deadline set by [WithHandlerTimeout] expires, and clients should reconnect.
+func WithEventStream(path string, admins ...string) Option {
+	return func(s *Server) {
+		if path == "" {
+			path = DefaultEventsPath
+		}
+		s.settings.HTTP.events = newEventStream(path, admins)
+	}
+}
+
+// WithWebhooks makes the server POST the events of the provided types to the URL, as JSON encoded [Event]s.
+// If no type is provided, all events are sent. It can be used multiple times to register several webhooks.
+//
+// Each delivery is signed with the secret: the 'X-Blossy-Signature' header is "sha256=" followed by the hex encoded
+// HMAC-SHA256 of the 'X-Blossy-Timestamp' header, a dot and the body. Receivers can check it with [VerifyWebhook].
+// Deliveries happen in the background and are retried with an exponential backoff on network errors,
+// 429 and 5xx responses. Events are dropped when too many deliveries to the same URL are in flight.
+// When the server stops, [Server.Serve] waits for the deliveries in flight for up to the shutdown timeout
+// (see [WithShutdownTimeout]), and then cancels them.
+//
+// Example:
+//
+//	WithWebhooks("https://hooks.example.com/blossom", secret, EventUpload, EventQuotaExceeded)
+func WithWebhooks(url, secret string, events ...EventType) Option {
+	return func(s *Server) {
+		s.settings.Sys.webhooks = append(s.settings.Sys.webhooks, newWebhook(url, secret, events))
+	}
+}
+
+// WithReloader sets the function that returns the options applied by [Server.Reload], which replaces the
+// allowed and blocked types, the pubkey rules, the rate limits and the blocklist of the server while it's serving.
+// When set, the server is also reloaded when the process receives SIGHUP.
+// See the blossy/config package for a reloader that reads a configuration file again.
+func WithReloader(reload Reloader) Option {
+	return func(s *Server) {
+		s.settings.Sys.reloader = reload
+	}
+}
+
 // WithRangeSupport enables support for HTTP range requests (RFC 7233).
 //
 // When enabled, the server advertises "Accept-Ranges: bytes" on HEAD requests
@@ -45,6 +276,603 @@ func WithRangeSupport() Option {
 	}
 }
 
+// WithCORS sets the CORS policy of the server, which by default is [DefaultCORSPolicy] as required by BUD-01.
+// Empty fields of the policy take the values of the default one.
+func WithCORS(policy CORSPolicy) Option {
+	return func(s *Server) {
+		s.settings.HTTP.corsPolicy = policy
+		s.settings.HTTP.cors = newCORS(policy)
+	}
+}
+
+// WithoutCORS disables the CORS headers, for example when they are set by a gateway in front of the server.
+// Without them, web clients on other origins can't use the server.
+func WithoutCORS() Option {
+	return func(s *Server) {
+		s.settings.HTTP.corsPolicy = CORSPolicy{}
+		s.settings.HTTP.cors = nil
+	}
+}
+
+// WithSecurityHeaders sets the security headers of the server responses, to protect the operators from stored XSS
+// via uploaded HTML or SVG. All responses carry 'X-Content-Type-Options: nosniff' and the 'Cross-Origin-Resource-Policy',
+// and the blobs with an active content type carry a restrictive 'Content-Security-Policy'.
+// Empty fields of the policy take the values of [DefaultSecurityPolicy].
+//
+// Example:
+//
+//	WithSecurityHeaders(blossy.DefaultSecurityPolicy())
+func WithSecurityHeaders(policy SecurityPolicy) Option {
+	return func(s *Server) {
+		policy = policy.withDefaults()
+		s.settings.HTTP.security = &policy
+	}
+}
+
+// WithContentPolicy sets how the blobs of potentially active content types (e.g. HTML and SVG) are delivered by
+// GET /<sha256> and HEAD /<sha256>, instead of trusting the type stored with them. The keys are media types
+// (e.g. "image/svg+xml"), type wildcards (e.g. "text/*") or "*/*", the most specific of which applies.
+// Types that are not in the policy are served as they are.
+//
+// Example:
+//
+//	WithContentPolicy(map[string]blossy.ContentAction{
+//	    "text/html":     blossy.ContentPlainText,
+//	    "image/svg+xml": blossy.ContentAttachment,
+//	})
+func WithContentPolicy(policy map[string]ContentAction) Option {
+	return func(s *Server) {
+		s.settings.HTTP.contentPolicy = make(map[string]ContentAction, len(policy))
+		for pattern, action := range policy {
+			s.settings.HTTP.contentPolicy[strings.ToLower(pattern)] = action
+		}
+	}
+}
+
+// WithPathPrefix mounts the server under the path prefix (e.g. "/blossom"), so that it can share
+// an [http.ServeMux] or a domain with other services. The prefix is removed from the path of the requests
+// before they are routed, and it's included in the URLs of the blob descriptors and of the upload sessions.
+// Requests outside the prefix are answered with 404 (Not Found).
+//
+// The paths of the other options, such as the one of [WithInfoEndpoint], are relative to the prefix.
+//
+// Example:
+//
+//	server, err := blossy.NewServer(blossy.WithHostname("example.com"), blossy.WithPathPrefix("/blossom"))
+//	...
+//	mux.Handle("/blossom/", server) // blobs are served at https://example.com/blossom/<sha256>
+func WithPathPrefix(prefix string) Option {
+	return func(s *Server) {
+		s.settings.HTTP.pathPrefix = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// WithRootHandler handles with the handler the GET and HEAD requests whose path is not the one of a blob
+// (i.e. not /<sha256>, optionally followed by an extension) and doesn't belong to any other endpoint,
+// starting with the root path "/". Without it, they are handled as downloads and rejected with 400 (Bad Request).
+//
+// The handler is responsible for the status code, so it should reply 404 (Not Found) to the unknown paths.
+// See [WithLandingPage] for a handler that serves an informational page.
+func WithRootHandler(h http.Handler) Option {
+	return func(s *Server) {
+		s.settings.HTTP.root = h
+	}
+}
+
+// WithLandingPage serves the HTML page with 200 (OK) on GET /, and with 404 (Not Found) on the other paths
+// that are not the ones of a blob, so that visitors with a browser learn what the server is.
+// If the HTML is empty, a page describing the server is generated from its [Info], linking to the
+// capability discovery endpoint if enabled (see [WithInfoEndpoint]).
+func WithLandingPage(html string) Option {
+	return func(s *Server) {
+		s.settings.HTTP.landingPage = html
+		s.settings.HTTP.root = http.HandlerFunc(s.serveLanding)
+	}
+}
+
+// WithNIP05 serves the NIP-05 identifiers of the domain at [NIP05Path], resolving their names with the resolver,
+// so that a deployment doesn't need a second web server for them. See [WithNIP05Names] for a static set of names.
+//
+// NIP-05 documents must be served at the root of the domain, so the path is not relative to [WithPathPrefix].
+// When the server is mounted on a mux under a prefix, the mux must route the path to the server as well.
+func WithNIP05(resolve NIP05Resolver) Option {
+	return func(s *Server) {
+		s.settings.HTTP.nip05 = resolve
+		s.settings.HTTP.nip05Names = nil
+	}
+}
+
+// WithNIP05Names serves the NIP-05 identifiers of the names, which map the local part of an identifier
+// (e.g. "bob" in "bob@example.com") to the hex encoded pubkey. Names are case-insensitive. See [WithNIP05].
+func WithNIP05Names(names map[string]string) Option {
+	lower := make(map[string]string, len(names))
+	for name, pubkey := range names {
+		lower[strings.ToLower(name)] = pubkey
+	}
+
+	return func(s *Server) {
+		s.settings.HTTP.nip05 = staticNIP05(lower)
+		s.settings.HTTP.nip05Names = lower
+	}
+}
+
+// WithInfoEndpoint serves the capabilities of the server (see [Server.Info]) as JSON on GET requests to the path,
+// so that clients can discover them before uploading: the supported BUDs, the maximum upload size,
+// the accepted content types and the payment requirements. If the path is empty, [DefaultInfoPath] is used.
+func WithInfoEndpoint(path string) Option {
+	return func(s *Server) {
+		if path == "" {
+			path = DefaultInfoPath
+		}
+		s.settings.HTTP.infoPath = path
+	}
+}
+
+// DefaultCacheControl is the 'Cache-Control' header of the served blobs, if not configured.
+// Blobs are addressed by their hash, so they can be cached forever.
+const DefaultCacheControl = "public, max-age=31536000, immutable"
+
+// WithCacheControl sets the 'Cache-Control' header of the blobs served by GET /<sha256> and HEAD /<sha256>,
+// which by default is [DefaultCacheControl], since blobs are immutable. Use an empty string to omit the header.
+// The Download and Check hooks can override it for a single response with [CacheControl].
+func WithCacheControl(directives string) Option {
+	return func(s *Server) {
+		s.settings.HTTP.cacheControl = directives
+	}
+}
+
+// WithContentDisposition sets the 'Content-Disposition' header of the blobs served by GET /<sha256> and HEAD /<sha256>
+// to "inline" or "attachment", with the filename <sha256>.<ext>. By default, the header is omitted.
+// The Download and Check hooks can override it for a single response with [Inline] and [Attachment],
+// for example to suggest a friendly filename.
+func WithContentDisposition(disposition string) Option {
+	return func(s *Server) {
+		s.settings.HTTP.disposition = disposition
+	}
+}
+
+// WithCompression enables the compression of responses for clients that support it ('Accept-Encoding'),
+// such as the JSON of GET /list, error bodies and text blobs.
+// Only responses of at least minSize bytes whose content type matches one of the patterns are compressed,
+// so that already compressed media (e.g. images and videos) is served as it is.
+// If no patterns are provided, [DefaultCompressibleTypes] are used.
+//
+// The server supports gzip, and other encodings can be added with [WithCompressionEncoder].
+// Responses to range requests are never compressed.
+func WithCompression(minSize int, patterns ...string) Option {
+	return func(s *Server) {
+		s.settings.HTTP.compress = true
+		s.settings.HTTP.compression.minSize = minSize
+		if len(patterns) > 0 {
+			s.settings.HTTP.compression.types = patterns
+		}
+	}
+}
+
+// WithCompressionEncoder adds a content encoding (e.g. "zstd" or "br") to the ones supported by [WithCompression],
+// which must be enabled separately. Added encodings are preferred over gzip, in the order they are added,
+// when the client accepts them equally. For example, with github.com/klauspost/compress/zstd:
+//
+//	blossy.WithCompressionEncoder("zstd", func(w io.Writer) (io.WriteCloser, error) {
+//	    return zstd.NewWriter(w)
+//	})
+func WithCompressionEncoder(name string, encoder Encoder) Option {
+	return func(s *Server) {
+		encodings := s.settings.HTTP.compression.encodings
+		gzip := len(encodings) - 1
+		s.settings.HTTP.compression.encodings = slices.Insert(encodings, gzip, encoding{name: strings.ToLower(name), encoder: encoder})
+	}
+}
+
+// WithUploadVerification enables the streaming sha256 verification of PUT /upload and PUT /media bodies.
+//
+// When enabled, the server hashes the body as it's read by the hook, without buffering it.
+// If the client declared the hash of the blob (with the 'Content-Digest' header, which must match the
+// 'x' tags of the auth event), reading the end of the body returns an error instead of [io.EOF]
+// when the hashes don't match, so that the hook can abort before committing the blob.
+// In that case the server responds with 400 (Bad Request), regardless of what the hook returned.
+//
+// Uploads declaring their hash with a structured digest (see [DigestStructured]) are always verified.
+func WithUploadVerification() Option {
+	return func(s *Server) {
+		s.settings.Upload.verify = true
+	}
+}
+
+// DigestScheme is the format of the header that declares the hash of an uploaded blob,
+// which the 'x' tags of the authorization event are matched against.
+type DigestScheme int
+
+const (
+	// DigestAny accepts both the [DigestStructured] and the [DigestLegacy] schemes. It's the default.
+	DigestAny DigestScheme = iota
+
+	// DigestStructured accepts only the structured 'Content-Digest' or 'Repr-Digest' headers of RFC 9530,
+	// e.g. "Content-Digest: sha-256=:<base64>:". The body of the upload is always verified against the digest.
+	DigestStructured
+
+	// DigestLegacy accepts only the hex encoded sha256 in the 'Content-Digest' header.
+	DigestLegacy
+)
+
+// WithDigestScheme sets which formats of the digest headers of PUT /upload and PUT /media are accepted.
+// Uploads declaring their hash with a different format are rejected with 400 (Bad Request).
+// By default, both formats are accepted (see [DigestAny]).
+func WithDigestScheme(scheme DigestScheme) Option {
+	return func(s *Server) {
+		s.settings.Upload.digest = scheme
+	}
+}
+
+// WithMultipartUploads enables uploads of blobs as multipart forms, which many web clients use to post files.
+// When the body of a PUT /upload or POST /upload request is a 'multipart/form-data' form, the first file
+// of the form is streamed to the On.Upload hook, and the other fields are ignored.
+// The type of the blob is the one declared by the file, or derived from the extension of its filename.
+// The size of the blob is unknown, but it's still limited while streaming by [WithMaxUploadSize].
+func WithMultipartUploads() Option {
+	return func(s *Server) {
+		s.settings.Upload.multipart = true
+	}
+}
+
+// WithInspectionSize sets the number of bytes of the uploaded blobs that are passed to the
+// UploadContent and MediaContent Reject hooks. The default is [DefaultInspectionSize].
+func WithInspectionSize(bytes int) Option {
+	return func(s *Server) {
+		s.settings.Upload.inspectSize = bytes
+	}
+}
+
+// WithResumableUploads enables resumable uploads, for large blobs over unreliable connections.
+// The state and the partial data of the uploads are kept in the store, and the sessions can be resumed
+// for ttl after their creation (the default is [DefaultSessionTTL]). Expired sessions are pruned
+// periodically while the server is serving.
+//
+// Clients create a session with POST /upload, declaring the blob like with HEAD /upload (BUD-06), then send the
+// blob in one or more PATCH /upload/<session> requests, resuming from the offset returned by HEAD /upload/<session>.
+// The completed blob is verified against the declared hash, and passed to the On.Upload hook.
+// See [Server.HandleUploadCreate] and [Server.HandleUploadSession] for the details of the protocol.
+//
+// Web clients also need the POST and PATCH methods in [CORSPolicy.AllowedMethods], and the 'Location',
+// 'Upload-Offset', 'Upload-Length' and 'Upload-Expires' headers in [CORSPolicy.ExposedHeaders].
+func WithResumableUploads(store SessionStore, ttl time.Duration) Option {
+	return func(s *Server) {
+		if ttl == 0 {
+			ttl = DefaultSessionTTL
+		}
+		s.settings.Upload.sessions = &resumable{store: store, ttl: ttl}
+	}
+}
+
+// WithMaxUploadSize sets the maximum size in bytes of the blobs uploaded with PUT /upload and PUT /media.
+//
+// Uploads whose declared size ('Content-Length', or 'X-Content-Length' for HEAD requests) exceeds the limit
+// are rejected with 413 (Content Too Large) before any of the Reject hooks is invoked.
+// Uploads of unknown size are limited while streaming: reading past the limit returns [ErrBlobTooLarge],
+// and the server responds with 413, regardless of what the hook returned.
+func WithMaxUploadSize(bytes int64) Option {
+	return func(s *Server) {
+		s.settings.Upload.maxSize = bytes
+	}
+}
+
+// WithMaxConcurrentUploads limits to n the uploads with PUT /upload, PUT /media and PUT /mirror in progress at once,
+// so that a burst of large uploads can't exhaust the memory or the disk bandwidth of the server.
+//
+// The limit is applied after the built-in policies and the Reject hooks. When it's reached, uploads wait for
+// another one to complete for at most the wait, and they are then rejected with 503 (Service Unavailable)
+// and a 'Retry-After' header. If the wait is 0, they are rejected immediately.
+func WithMaxConcurrentUploads(n int, wait time.Duration) Option {
+	return func(s *Server) {
+		l := s.settings.Upload.uploadLimiter()
+		l.slots = make(chan struct{}, max(n, 0))
+		l.wait = wait
+	}
+}
+
+// WithMaxConcurrentUploadsPerIP limits to n the uploads with PUT /upload, PUT /media and PUT /mirror
+// in progress at once from the same IP group (see [IP.Group]), so that a single client can't take all the
+// slots of [WithMaxConcurrentUploads]. Uploads exceeding the limit are rejected with 429 (Too Many Requests)
+// and a 'Retry-After' header.
+func WithMaxConcurrentUploadsPerIP(n int) Option {
+	return func(s *Server) {
+		s.settings.Upload.uploadLimiter().perIP = n
+	}
+}
+
+// WithMirrorSigner sets the signer of the authorization events (kind 24242) of [Server.MirrorFetch],
+// such as an [auth.KeySigner] holding a key of the operator. The events are only sent to the remote servers
+// that respond with 401 (Unauthorized), as they are valid for 5 minutes, bound to the blob and to the remote server.
+func WithMirrorSigner(signer auth.Signer) Option {
+	return func(s *Server) {
+		s.settings.Sys.mirrorSigner = signer
+	}
+}
+
+// WithAllowedTypes restricts the uploads with PUT /upload and PUT /media to blobs whose content type
+// matches one of the patterns, which can be media types (e.g. "image/png"), type wildcards (e.g. "image/*") or "*/*".
+//
+// The declared type is checked ('Content-Type', or 'X-Content-Type' for HEAD requests). If an upload doesn't declare it,
+// the type is detected from the first bytes of the body. Uploads whose type is not allowed are rejected
+// with 415 (Unsupported Media Type) before any of the Reject hooks is invoked.
+func WithAllowedTypes(patterns []string) Option {
+	return func(s *Server) {
+		s.settings.Upload.allowedTypes = patterns
+	}
+}
+
+// WithBlockedTypes rejects the uploads with PUT /upload and PUT /media of blobs whose content type
+// matches one of the patterns, which can be media types (e.g. "text/html"), type wildcards (e.g. "video/*") or "*/*".
+//
+// Both the declared type and the type detected from the first bytes of the body are checked, so that
+// blobs disguised with another type (e.g. html pages declared as images) are also rejected.
+// Uploads whose type is blocked are rejected with 415 (Unsupported Media Type) before any of the Reject hooks is invoked.
+func WithBlockedTypes(patterns []string) Option {
+	return func(s *Server) {
+		s.settings.Upload.blockedTypes = patterns
+	}
+}
+
+// WithMediaProcessor transforms the blobs uploaded with PUT /media with the [Transformer] before they are passed
+// to the Media hook, which receives the transformed blob and its hints (BUD-05).
+// Only blobs whose content type matches one of the patterns are transformed, by default "image/*".
+// The type is the declared one, or the one detected from the first bytes of the body if the upload doesn't declare it.
+//
+// When the hash of the uploaded blob is verified (see [WithUploadVerification]), it is reported as the
+// original hash in the NIP-94 metadata of the returned blob descriptor (see [OnHooks.NIP94]).
+func WithMediaProcessor(t Transformer, patterns ...string) Option {
+	return func(s *Server) {
+		if len(patterns) == 0 {
+			patterns = []string{"image/*"}
+		}
+		s.settings.Upload.processor = &mediaProcessor{transformer: t, types: patterns}
+	}
+}
+
+// WithMetadataStripping removes the metadata of JPEG, PNG and WebP images uploaded to the endpoints,
+// which can be [EndpointUpload] and [EndpointMedia]. If none are provided, it applies to both.
+// Metadata such as EXIF (e.g. GPS coordinates and camera details), XMP and IPTC is removed while the blob is streamed
+// to the hook, which receives the cleaned blob. Blobs in other formats are passed unchanged.
+//
+// The hook receives hints without the hash and the size, as they differ from the uploaded ones,
+// so the returned blob descriptor should have the hash of the cleaned blob.
+// When the hash of the uploaded blob is verified (see [WithUploadVerification]), it is reported as the
+// original hash in the NIP-94 metadata of the returned blob descriptor (see [OnHooks.NIP94]).
+// Learn more about the details in the [strip] package.
+func WithMetadataStripping(endpoints ...Endpoint) Option {
+	return func(s *Server) {
+		if len(endpoints) == 0 {
+			endpoints = []Endpoint{EndpointUpload, EndpointMedia}
+		}
+		s.settings.Upload.strip = endpoints
+	}
+}
+
+// WithAuthClockSkew sets the tolerance applied to the time bounds of authorization events,
+// to account for clients whose clock is not in sync, such as high-latency mobile clients.
+// If not set, [auth.DefaultClockSkew] is used.
+func WithAuthClockSkew(d time.Duration) Option {
+	return func(s *Server) {
+		s.settings.Auth.ClockSkew = d
+	}
+}
+
+// WithMaxAuthEventAge sets the maximum time elapsed since the creation of authorization events.
+// Older events are rejected with 401 (Unauthorized), even if they have not expired yet,
+// which limits the reuse of events with a long expiration.
+// If not set, events are accepted until they expire.
+func WithMaxAuthEventAge(d time.Duration) Option {
+	return func(s *Server) {
+		s.settings.Auth.MaxAge = d
+	}
+}
+
+// WithRequiredAuth requires the requests to the provided endpoints (all endpoints if none is provided)
+// to have a valid authorization event. Requests without one are rejected with 401 (Unauthorized),
+// before any of the Reject hooks is invoked, so that the hooks don't have to check [Request.IsAuthed].
+//
+// The option can be used multiple times, and the endpoints accumulate.
+//
+// Example:
+//
+//	WithRequiredAuth(EndpointUpload, EndpointMedia, EndpointDelete, EndpointList)
+func WithRequiredAuth(endpoints ...Endpoint) Option {
+	return func(s *Server) {
+		if len(endpoints) == 0 {
+			endpoints = Endpoints()
+		}
+		s.settings.Policy.requiredAuth = append(s.settings.Policy.requiredAuth, endpoints...)
+	}
+}
+
+// WithAllowedPubkeys restricts the provided endpoints to the listed pubkeys.
+// If no endpoint is provided, it applies to the endpoints that store, delete and list blobs:
+// [EndpointUpload], [EndpointMedia], [EndpointMirror], [EndpointDelete] and [EndpointList].
+//
+// Requests from other pubkeys are rejected with 403 (Forbidden), and unauthenticated ones with 401 (Unauthorized),
+// before any of the Reject hooks is invoked.
+func WithAllowedPubkeys(pubkeys []string, endpoints ...Endpoint) Option {
+	allowed := make(map[string]struct{}, len(pubkeys))
+	for _, pk := range pubkeys {
+		allowed[pk] = struct{}{}
+	}
+
+	policy := func(pubkey string) bool {
+		_, ok := allowed[pubkey]
+		return ok
+	}
+	return withPubkeyRule(policy, pubkeys, endpoints)
+}
+
+// WithDeniedPubkeys rejects the requests of the listed pubkeys to the provided endpoints with 403 (Forbidden),
+// before any of the Reject hooks is invoked. If no endpoint is provided, it applies to the endpoints that store,
+// delete and list blobs: [EndpointUpload], [EndpointMedia], [EndpointMirror], [EndpointDelete] and [EndpointList].
+//
+// Unauthenticated requests are not affected, so consider using it together with [WithRequiredAuth].
+func WithDeniedPubkeys(pubkeys []string, endpoints ...Endpoint) Option {
+	denied := make(map[string]struct{}, len(pubkeys))
+	for _, pk := range pubkeys {
+		denied[pk] = struct{}{}
+	}
+
+	policy := func(pubkey string) bool {
+		_, ok := denied[pubkey]
+		return !ok
+	}
+	return withPubkeyRule(policy, pubkeys, endpoints)
+}
+
+// WithPubkeyPolicy is like [WithAllowedPubkeys], but the pubkeys are allowed dynamically by the policy,
+// which can be backed for example by a database or a list that is periodically refreshed.
+//
+// Requests whose pubkey is not allowed are rejected with 403 (Forbidden), or 401 (Unauthorized) if they are
+// unauthenticated, before any of the Reject hooks is invoked.
+func WithPubkeyPolicy(policy PubkeyPolicy, endpoints ...Endpoint) Option {
+	return withPubkeyRule(policy, nil, endpoints)
+}
+
+func withPubkeyRule(policy PubkeyPolicy, pubkeys []string, endpoints []Endpoint) Option {
+	return func(s *Server) {
+		if len(endpoints) == 0 {
+			endpoints = restrictedEndpoints()
+		}
+		s.settings.Policy.pubkeyRules = append(s.settings.Policy.pubkeyRules, pubkeyRule{
+			policy:    policy,
+			endpoints: endpoints,
+			pubkeys:   pubkeys,
+		})
+	}
+}
+
+// WithRateLimit rate-limits the requests to the provided endpoints (all endpoints if none is provided),
+// grouping them with the key function (e.g. [KeyByIP], [KeyByPubkey]).
+// Requests exceeding the limit are rejected with 429 (Too Many Requests) and a 'Retry-After' header,
+// before any of the Reject hooks is invoked.
+//
+// The option can be used multiple times to apply different limits to different endpoints.
+//
+// Example:
+//
+//	WithRateLimit(ratelimit.New(ratelimit.Per(10, time.Minute), 5), KeyByIP, EndpointUpload, EndpointMedia)
+func WithRateLimit(limiter *ratelimit.Limiter, key KeyFunc, endpoints ...Endpoint) Option {
+	return func(s *Server) {
+		if len(endpoints) == 0 {
+			endpoints = Endpoints()
+		}
+		s.settings.Policy.rateLimits = append(s.settings.Policy.rateLimits, rateLimit{
+			limiter:   limiter,
+			key:       key,
+			endpoints: endpoints,
+		})
+	}
+}
+
+// WithBlocklist refuses to serve, store or mirror the blobs in the blocklist, replying with 451 Unavailable For Legal Reasons
+// before any of the Reject hooks is invoked:
+//   - Download and Check requests of blocked blobs are rejected.
+//   - Uploads (including HEAD /upload and /media) declaring the hash of a blocked blob are rejected.
+//     Otherwise, the body is hashed while it's read, and a blocked blob makes the hook read an error instead of
+//     the end of the body, so it must not be stored.
+//   - Mirror requests whose URL points to a blocked blob are rejected.
+//
+// Deleting blocked blobs is allowed. See the blossy/blocklist package for a blocklist loaded from files or URLs.
+func WithBlocklist(list Blocklist) Option {
+	return func(s *Server) {
+		s.settings.Policy.blocklist = list
+	}
+}
+
+// WithIPPolicy rejects the requests of IP addresses with 403 (Forbidden) according to the policy,
+// before any of the other built-in policies and the Reject hooks are invoked:
+//   - Addresses in the Deny networks, or outside of the Allow networks if any, are rejected.
+//   - Addresses whose [GeoInfo] is not allowed by the Geo policy are rejected.
+//     If the resolver fails, the error is logged and the request is allowed.
+//   - Addresses that are temporarily banned by the Ban policy are rejected with a 'Retry-After' header.
+//
+// The addresses are the ones returned by [GetIP], so the server should be behind a trusted reverse proxy.
+// If the option is used multiple times, the last policy is applied.
+//
+// Example:
+//
+//	WithIPPolicy(IPPolicy{
+//	    Deny: []string{"198.51.100.0/24"},
+//	    Ban:  &BanPolicy{Threshold: 20, Window: time.Minute, Duration: time.Hour},
+//	})
+func WithIPPolicy(policy IPPolicy) Option {
+	return func(s *Server) {
+		s.settings.Policy.ipPolicy = policy
+		s.settings.Policy.firewall = newFirewall(policy)
+	}
+}
+
+// WithTLS makes [Server.StartAndServe] serve HTTPS, using the PEM encoded certificate and private key files.
+// If the certificate is signed by a certificate authority, the certFile should be the concatenation
+// of the server's certificate, any intermediates, and the CA's certificate.
+func WithTLS(certFile, keyFile string) Option {
+	return func(s *Server) {
+		s.settings.HTTP.certFile = certFile
+		s.settings.HTTP.keyFile = keyFile
+	}
+}
+
+// WithTLSConfig makes [Server.StartAndServe] serve HTTPS using the provided TLS configuration.
+// Unless [WithTLS] is also used, the configuration must provide the certificates,
+// with its Certificates, GetCertificate or GetConfigForClient fields.
+//
+// Certificates can be obtained automatically via ACME, for example with golang.org/x/crypto/acme/autocert:
+//
+//	manager := &autocert.Manager{
+//	    Prompt:     autocert.AcceptTOS,
+//	    HostPolicy: autocert.HostWhitelist("cdn.example.com"),
+//	    Cache:      autocert.DirCache("certs"),
+//	}
+//	WithTLSConfig(manager.TLSConfig())
+func WithTLSConfig(config *tls.Config) Option {
+	return func(s *Server) {
+		s.settings.HTTP.tlsConfig = config
+	}
+}
+
+// WithH2C enables cleartext HTTP/2 (h2c) with prior knowledge on the plain HTTP connections of the server
+// used by [Server.StartAndServe] and [Server.Serve], for reverse proxies that forward requests over HTTP/2 without TLS.
+// HTTP/1 clients are still served. Connections over TLS negotiate HTTP/2 regardless of this option.
+func WithH2C() Option {
+	return func(s *Server) { s.settings.HTTP.h2c = true }
+}
+
+// WithHTTP2Config sets the HTTP/2 settings of the server used by [Server.StartAndServe] and [Server.Serve],
+// such as the maximum number of concurrent streams per connection and the flow-control windows,
+// which bound the memory that large blob transfers can hold on a single connection.
+// Zero fields keep the defaults of the [net/http] package.
+//
+// Example:
+//
+//	WithHTTP2Config(&http.HTTP2Config{
+//	    MaxConcurrentStreams:          100,
+//	    MaxReceiveBufferPerStream:     4 << 20,
+//	    MaxReceiveBufferPerConnection: 16 << 20,
+//	})
+func WithHTTP2Config(config *http.HTTP2Config) Option {
+	return func(s *Server) { s.settings.HTTP.http2 = config }
+}
+
+// WithHTTPServer sets a function that customizes the [http.Server] used by [Server.StartAndServe] and [Server.Serve],
+// for example to set ConnState callbacks, BaseContext or ErrorLog.
+// The function is called once per server, after the defaults and the other options have been applied,
+// so it can override them. The graceful shutdown of the server is preserved.
+//
+// Example:
+//
+//	WithHTTPServer(func(s *http.Server) {
+//	    s.ErrorLog = log.New(os.Stderr, "http: ", log.LstdFlags)
+//	    s.MaxHeaderBytes = 64 << 10
+//	})
+func WithHTTPServer(customize func(*http.Server)) Option {
+	return func(s *Server) {
+		s.settings.HTTP.customize = customize
+	}
+}
+
 // WithReadHeaderTimeout sets the maximum duration for reading the headers of an HTTP request.
 // It's used only in the http server used by [Server.StartAndServe]. Must be >= 1s.
 func WithReadHeaderTimeout(d time.Duration) Option {
@@ -63,10 +891,20 @@ func WithShutdownTimeout(d time.Duration) Option {
 	return func(s *Server) { s.settings.HTTP.shutdownTimeout = d }
 }
 
+// WithUnixSocketMode sets the permissions of the socket file created by [Server.StartAndServe]
+// when listening on a unix socket (e.g. 0660 to let a reverse proxy in the same group connect).
+// If not set, the permissions depend on the umask of the process.
+func WithUnixSocketMode(mode fs.FileMode) Option {
+	return func(s *Server) { s.settings.HTTP.socketMode = mode }
+}
+
 // settings holds the configurable parameters for the server.
 type settings struct {
-	Sys  systemSettings
-	HTTP httpSettings
+	Sys    systemSettings
+	HTTP   httpSettings
+	Auth   auth.Options
+	Upload uploadSettings
+	Policy policySettings
 }
 
 func newSettings() settings {
@@ -79,20 +917,196 @@ type systemSettings struct {
 	// hostname is the server hostname, used to derive the URL of a blob descriptor when it was not manually set.
 	// It is also used in validating authorization events (see auth package).
 	hostname string
+
+	// aliases are the other hostnames of the server. See [WithHostnames].
+	aliases []string
+
+	// mimeTypes extend the table of extensions and content types. If nil, the defaults are used.
+	mimeTypes *mimeTable
+
+	// mirrorSigner signs the authorization events of [Server.MirrorFetch]. If nil, mirror fetches are not authorized.
+	mirrorSigner auth.Signer
+
+	// urlBuilder builds the URLs of the blob descriptors. If nil, they are derived from the hostname.
+	urlBuilder func(desc blossom.BlobDescriptor) string
+
+	// cache caches the blobs served by the Download hook. If nil, blobs are not cached.
+	cache BlobCache
+
+	// flights share the fetches of the blobs that are not cached. If nil, each request invokes the Download hook.
+	flights *flights
+
+	// webhooks receive the events of the server. See [WithWebhooks].
+	webhooks []*webhook
+
+	// reloader returns the options applied by [Server.Reload]. If nil, the server can't be reloaded.
+	reloader Reloader
+
+	// ipv4Prefix and ipv6Prefix group the IPs of the requests. If 0, the defaults are used.
+	ipv4Prefix int
+	ipv6Prefix int
+
+	// workers run the jobs of [Server.Enqueue]. If 0, jobs are disabled.
+	// The size of the queue and the retries of the jobs use the defaults if 0.
+	workers      int
+	jobQueueSize int
+	jobAttempts  int
+	jobBackoff   time.Duration
 }
 
 type httpSettings struct {
 	// AcceptRanges enables support for HTTP range requests (RFC 7233).
 	acceptRanges bool
 
+	// requestIDHeader enables the 'X-Request-ID' response header.
+	requestIDHeader bool
+
+	// cacheControl is the 'Cache-Control' header of the served blobs. If empty, the header is omitted.
+	cacheControl string
+
+	// disposition is the 'Content-Disposition' of the served blobs ("inline" or "attachment"). If empty, the header is omitted.
+	disposition string
+
+	// handlerTimeout is the deadline of the context of each request. If 0, requests have no deadline.
+	handlerTimeout time.Duration
+
+	// egress limits the bandwidth of all downloads, at egressRate bytes per second. If nil, there is no limit.
+	egress     *byteBucket
+	egressRate int64
+
+	// egressPerConn is the bandwidth limit in bytes per second of the downloads of each connection. If 0, there is no limit.
+	egressPerConn int64
+
+	// events is the stream of the activity of the server. If nil, the stream is disabled.
+	events *eventStream
+
+	// infoPath is the path of the capability discovery endpoint. If empty, the endpoint is disabled.
+	infoPath string
+
+	// root handles the GET and HEAD requests whose path is not the one of a blob. If nil, they are handled as downloads.
+	// landingPage is the HTML served by the default root handler of [WithLandingPage].
+	root        http.Handler
+	landingPage string
+
+	// nip05 resolves the names of the NIP-05 identifiers. If nil, the NIP-05 endpoint is disabled.
+	// nip05Names are the names configured with [WithNIP05Names], kept for validation.
+	nip05      NIP05Resolver
+	nip05Names map[string]string
+
+	// pathPrefix is the path the server is mounted under, without the trailing slash. If empty, it's the root.
+	pathPrefix string
+
+	// security sets the security headers of the responses. If nil, they are omitted.
+	security *SecurityPolicy
+
+	// contentPolicy is how the blobs are delivered, by pattern of their content type. If nil, they are served as they are.
+	contentPolicy map[string]ContentAction
+
+	// cors sets the CORS headers of the responses, as configured by corsPolicy. If nil, no CORS header is set.
+	cors       *cors
+	corsPolicy CORSPolicy
+
+	// compress enables the compression of responses, as configured by compression.
+	compress    bool
+	compression compression
+
 	// settings for the default HTTP server, which is used when calling [Server.StartAndServe].
 	readHeaderTimeout time.Duration
 	idleTimeout       time.Duration
 	shutdownTimeout   time.Duration
+
+	// HTTP/2 settings for the default HTTP server. h2c enables cleartext HTTP/2, and http2 tunes its limits if not nil.
+	h2c   bool
+	http2 *http.HTTP2Config
+
+	// socketMode are the permissions of the unix socket file. If zero, they are left unchanged.
+	socketMode fs.FileMode
+
+	// TLS settings for the default HTTP server. If none is set, the server uses plain HTTP.
+	certFile  string
+	keyFile   string
+	tlsConfig *tls.Config
+
+	// customize is applied to the default HTTP server, after all the other settings.
+	customize func(*http.Server)
+}
+
+func (h httpSettings) useTLS() bool {
+	return h.certFile != "" || h.tlsConfig != nil
+}
+
+type uploadSettings struct {
+	// verify enables the streaming sha256 verification of upload bodies.
+	verify bool
+
+	// digest is the accepted format of the headers declaring the hash of upload bodies.
+	digest DigestScheme
+
+	// maxSize is the maximum size in bytes of uploaded blobs. If 0, there is no limit.
+	maxSize int64
+
+	// allowedTypes and blockedTypes are the patterns of the content types of uploaded blobs.
+	// If allowedTypes is empty, all types are allowed.
+	allowedTypes []string
+	blockedTypes []string
+
+	// processor transforms the blobs uploaded with PUT /media. If nil, blobs are not transformed.
+	processor *mediaProcessor
+
+	// multipart enables uploads of blobs as multipart forms.
+	multipart bool
+
+	// sessions stores the state of resumable uploads. If nil, resumable uploads are disabled.
+	sessions *resumable
+
+	// inspectSize is the number of bytes passed to the content Reject hooks. If 0, [DefaultInspectionSize] is used.
+	inspectSize int
+
+	// strip are the endpoints whose uploaded images have their metadata removed.
+	strip []Endpoint
+
+	// limiter limits the number of concurrent uploads. If nil, they are not limited.
+	limiter *uploadLimiter
+}
+
+// uploadLimiter returns the limiter of the concurrent uploads, creating it if needed.
+func (u *uploadSettings) uploadLimiter() *uploadLimiter {
+	if u.limiter == nil {
+		u.limiter = &uploadLimiter{active: make(map[string]int)}
+	}
+	return u.limiter
+}
+
+type policySettings struct {
+	// requiredAuth are the endpoints whose requests must be authenticated.
+	requiredAuth []Endpoint
+
+	// pubkeyRules are applied in order to the requests of their endpoints.
+	pubkeyRules []pubkeyRule
+
+	// rateLimits are applied in order to the requests of their endpoints.
+	rateLimits []rateLimit
+
+	// blocklist rejects the blocked blobs on every endpoint. If nil, no blob is blocked.
+	blocklist Blocklist
+
+	// ipPolicy is the policy of the firewall, kept for validation.
+	ipPolicy IPPolicy
+
+	// firewall rejects the requests of IP addresses. If nil, all addresses are allowed.
+	firewall *firewall
 }
 
 func newHTTPSettings() httpSettings {
 	return httpSettings{
+		compression: compression{
+			minSize:   DefaultCompressionMinSize,
+			types:     DefaultCompressibleTypes,
+			encodings: []encoding{{name: "gzip", encoder: gzipEncoder}},
+		},
+		cacheControl:      DefaultCacheControl,
+		cors:              newCORS(DefaultCORSPolicy()),
+		corsPolicy:        DefaultCORSPolicy(),
 		readHeaderTimeout: 5 * time.Second,
 		idleTimeout:       1 * time.Minute,
 		shutdownTimeout:   5 * time.Second,
@@ -108,16 +1122,221 @@ func (s *Server) validate() error {
 			return err
 		}
 	}
+	for _, alias := range s.settings.Sys.aliases {
+		if err := utils.ValidateHostname(alias); err != nil {
+			return err
+		}
+	}
+
+	if t := s.settings.Sys.mimeTypes; t != nil {
+		for ext, mime := range t.types {
+			if ext == "" || utils.MediaType(mime) == "" {
+				return fmt.Errorf("mime types: invalid mapping %q -> %q", ext, mime)
+			}
+		}
+	}
+	if s.settings.Sys.flights != nil && s.settings.Sys.cache == nil {
+		return errors.New("singleflight: a blob cache is required (see WithBlobCache)")
+	}
+	if s.settings.Sys.workers < 0 || s.settings.Sys.jobQueueSize < 0 {
+		return errors.New("jobs: workers and queue size must not be negative")
+	}
+	if s.settings.Sys.jobAttempts < 0 || s.settings.Sys.jobBackoff < 0 {
+		return errors.New("jobs: attempts and backoff must not be negative")
+	}
+
+	if p := s.settings.Sys.ipv4Prefix; p < 0 || p > 32 {
+		return errors.New("ip grouping: IPv4 prefix must be between 0 and 32")
+	}
+	if p := s.settings.Sys.ipv6Prefix; p < 0 || p > 128 {
+		return errors.New("ip grouping: IPv6 prefix must be between 0 and 128")
+	}
 
 	// http
 	if s.settings.HTTP.readHeaderTimeout < 1*time.Second {
 		return errors.New("http read header timeout must be greater than 1s to function reliably")
 	}
+	if err := s.settings.HTTP.corsPolicy.validate(); err != nil {
+		return err
+	}
+	if s.settings.HTTP.handlerTimeout < 0 {
+		return errors.New("http handler timeout must not be negative")
+	}
+	if s.settings.HTTP.egressRate < 0 || s.settings.HTTP.egressPerConn < 0 {
+		return errors.New("download rate limit must not be negative")
+	}
+	if e := s.settings.HTTP.events; e != nil {
+		if !strings.HasPrefix(e.path, "/") {
+			return fmt.Errorf("event stream: path %q must start with '/'", e.path)
+		}
+		if len(e.admins) == 0 {
+			return errors.New("event stream: at least one admin pubkey is required")
+		}
+		for _, pk := range e.admins {
+			if err := utils.ValidatePubkey(pk); err != nil {
+				return fmt.Errorf("event stream: invalid admin pubkey %q: %w", pk, err)
+			}
+		}
+	}
+	for _, w := range s.settings.Sys.webhooks {
+		if err := validateWebhook(w); err != nil {
+			return err
+		}
+	}
+	for name, pubkey := range s.settings.HTTP.nip05Names {
+		if !validNIP05Name(name) {
+			return fmt.Errorf("nip05: invalid name %q: must contain only a-z, 0-9, '-', '_' and '.'", name)
+		}
+		if err := utils.ValidatePubkey(pubkey); err != nil {
+			return fmt.Errorf("nip05: invalid pubkey of %q: %w", name, err)
+		}
+	}
+	if p := s.settings.HTTP.pathPrefix; p != "" && (!strings.HasPrefix(p, "/") || strings.ContainsAny(p, "?#")) {
+		return fmt.Errorf("path prefix: %q must start with '/' and must not contain a query or a fragment", p)
+	}
+	if p := s.settings.HTTP.infoPath; p != "" && !strings.HasPrefix(p, "/") {
+		return fmt.Errorf("info endpoint: path %q must start with '/'", p)
+	}
+	if s.settings.HTTP.compression.minSize < 0 {
+		return errors.New("compression: min size must not be negative")
+	}
+	for _, pattern := range s.settings.HTTP.compression.types {
+		if err := utils.ValidateTypePattern(pattern); err != nil {
+			return fmt.Errorf("compression: invalid type %q: %w", pattern, err)
+		}
+	}
+	for _, e := range s.settings.HTTP.compression.encodings {
+		if e.name == "" || e.encoder == nil {
+			return errors.New("compression: encodings must have a name and an encoder")
+		}
+	}
 	if s.settings.HTTP.idleTimeout < 10*time.Second {
 		return errors.New("http idle timeout must be greater than 10s to function reliably")
 	}
 	if s.settings.HTTP.shutdownTimeout < 1*time.Second {
 		return errors.New("http shutdown timeout should be greater than 1s to avoid abrupt disconnections")
 	}
+	if c := s.settings.HTTP.http2; c != nil {
+		if c.MaxConcurrentStreams < 0 || c.MaxReceiveBufferPerStream < 0 || c.MaxReceiveBufferPerConnection < 0 || c.MaxReadFrameSize < 0 {
+			return errors.New("http2: limits must not be negative")
+		}
+	}
+	if p := s.settings.HTTP.security; p != nil {
+		switch p.CrossOriginResourcePolicy {
+		case "same-origin", "same-site", "cross-origin":
+		default:
+			return fmt.Errorf("security: invalid cross-origin resource policy %q", p.CrossOriginResourcePolicy)
+		}
+	}
+	for pattern, action := range s.settings.HTTP.contentPolicy {
+		if err := utils.ValidateTypePattern(pattern); err != nil {
+			return fmt.Errorf("content policy: %q: %w", pattern, err)
+		}
+		switch action {
+		case ContentServe, ContentAttachment, ContentPlainText:
+		default:
+			return fmt.Errorf("content policy: %q: unknown action %q", pattern, action)
+		}
+	}
+	if d := s.settings.HTTP.disposition; d != "" && d != "inline" && d != "attachment" {
+		return fmt.Errorf("content disposition must be \"inline\" or \"attachment\", got %q", d)
+	}
+	if s.settings.HTTP.socketMode&^fs.ModePerm != 0 {
+		return errors.New("unix socket mode must only contain permission bits")
+	}
+	if (s.settings.HTTP.certFile == "") != (s.settings.HTTP.keyFile == "") {
+		return errors.New("tls: both the certificate and the key files must be provided")
+	}
+	if c := s.settings.HTTP.tlsConfig; c != nil && s.settings.HTTP.certFile == "" {
+		if len(c.Certificates) == 0 && c.GetCertificate == nil && c.GetConfigForClient == nil {
+			return errors.New("tls: the config must provide certificates when no certificate file is provided")
+		}
+	}
+
+	// auth
+	if s.settings.Auth.ClockSkew < 0 {
+		return errors.New("auth: clock skew must not be negative")
+	}
+	if s.settings.Auth.MaxAge < 0 {
+		return errors.New("auth: max event age must not be negative")
+	}
+
+	// upload
+	if d := s.settings.Upload.digest; d < DigestAny || d > DigestLegacy {
+		return errors.New("upload: invalid digest scheme")
+	}
+	if s.settings.Upload.maxSize < 0 {
+		return errors.New("max upload size must not be negative")
+	}
+	if r := s.settings.Upload.sessions; r != nil {
+		if r.store == nil {
+			return errors.New("resumable uploads: session store must not be nil")
+		}
+		if r.ttl < 0 {
+			return errors.New("resumable uploads: session TTL must not be negative")
+		}
+	}
+	if s.settings.Upload.inspectSize < 0 {
+		return errors.New("upload: inspection size must not be negative")
+	}
+	if l := s.settings.Upload.limiter; l != nil {
+		if l.slots != nil && cap(l.slots) == 0 {
+			return errors.New("upload: max concurrent uploads must be positive")
+		}
+		if l.wait < 0 {
+			return errors.New("upload: the wait for an upload slot must not be negative")
+		}
+		if l.perIP < 0 {
+			return errors.New("upload: max concurrent uploads per IP must not be negative")
+		}
+	}
+	for _, e := range s.settings.Upload.strip {
+		if e != EndpointUpload && e != EndpointMedia {
+			return fmt.Errorf("metadata stripping: unsupported endpoint %q", e)
+		}
+	}
+	if p := s.settings.Upload.processor; p != nil {
+		if p.transformer == nil {
+			return errors.New("media processor: transformer must not be nil")
+		}
+		for _, pattern := range p.types {
+			if err := utils.ValidateTypePattern(pattern); err != nil {
+				return fmt.Errorf("media processor: invalid type %q: %w", pattern, err)
+			}
+		}
+	}
+
+	// policy
+	if err := s.settings.Policy.ipPolicy.validate(); err != nil {
+		return err
+	}
+	return s.validateReloadable()
+}
+
+// validateReloadable validates the settings that can be replaced with [Server.Reload].
+func (s *Server) validateReloadable() error {
+	for _, pattern := range slices.Concat(s.settings.Upload.allowedTypes, s.settings.Upload.blockedTypes) {
+		if err := utils.ValidateTypePattern(pattern); err != nil {
+			return fmt.Errorf("upload: invalid type %q: %w", pattern, err)
+		}
+	}
+	for _, rule := range s.settings.Policy.pubkeyRules {
+		if rule.policy == nil {
+			return errors.New("pubkey policy: policy must not be nil")
+		}
+		for _, pk := range rule.pubkeys {
+			if err := utils.ValidatePubkey(pk); err != nil {
+				return fmt.Errorf("pubkey policy: invalid pubkey %q: %w", pk, err)
+			}
+		}
+	}
+	for _, rl := range s.settings.Policy.rateLimits {
+		if rl.limiter == nil {
+			return errors.New("rate limit: limiter must not be nil")
+		}
+		if rl.key == nil {
+			return errors.New("rate limit: key function must not be nil")
+		}
+	}
 	return nil
 }
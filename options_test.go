@@ -0,0 +1,42 @@
+package blossy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+const testPubkey = "abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789"
+
+func TestNewServerValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		isValid bool
+	}{
+		{"defaults", nil, true},
+		{"max upload size", []Option{WithMaxUploadSize(1 << 20)}, true},
+		{"negative max upload size", []Option{WithMaxUploadSize(-1)}, false},
+		{"type patterns", []Option{WithAllowedTypes([]string{"image/*", "video/mp4"}), WithBlockedTypes([]string{"*/*"})}, true},
+		{"invalid type pattern", []Option{WithAllowedTypes([]string{"image"})}, false},
+		{"pubkey lists", []Option{WithAllowedPubkeys([]string{testPubkey}), WithDeniedPubkeys([]string{testPubkey})}, true},
+		{"invalid pubkey", []Option{WithAllowedPubkeys([]string{"npub1"})}, false},
+		{"nil pubkey policy", []Option{WithPubkeyPolicy(nil)}, false},
+		{"cors origins", []Option{WithCORS(CORSPolicy{AllowedOrigins: []string{"https://app.example.com", "https://*.example.com"}})}, true},
+		{"cors origin with a path", []Option{WithCORS(CORSPolicy{AllowedOrigins: []string{"https://example.com/app"}})}, false},
+		{"cors origin with an inner wildcard", []Option{WithCORS(CORSPolicy{AllowedOrigins: []string{"https://app.*.com"}})}, false},
+		{"negative cors max age", []Option{WithCORS(CORSPolicy{MaxAge: -time.Second})}, false},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			_, err := NewServer(append([]Option{WithHostname(testHostname)}, test.opts...)...)
+			if test.isValid && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !test.isValid && err == nil {
+				t.Fatal("expected an error, got nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DecodeInvoice decodes the BOLT11 payment request, returning its payment hash, amount and expiration.
+// It verifies the checksum of the encoding, but not the signature of the invoice.
+func DecodeInvoice(pr string) (Invoice, error) {
+	s := strings.TrimPrefix(strings.ToLower(pr), "lightning:")
+
+	hrp, words, err := decodeBech32(s)
+	if err != nil {
+		return Invoice{}, fmt.Errorf("payments: invalid invoice: %w", err)
+	}
+
+	amount, err := parseAmount(hrp)
+	if err != nil {
+		return Invoice{}, fmt.Errorf("payments: invalid invoice: %w", err)
+	}
+
+	// 35 bits of timestamp, the tagged fields, and 520 bits of signature
+	const timestampWords, signatureWords = 7, 104
+	if len(words) < timestampWords+signatureWords {
+		return Invoice{}, errors.New("payments: invalid invoice: too short")
+	}
+
+	timestamp := wordsToInt(words[:timestampWords])
+	fields := words[timestampWords : len(words)-signatureWords]
+
+	invoice := Invoice{PaymentRequest: pr, Amount: amount}
+	expiry := int64(3600) // the default of BOLT11
+	var hasHash bool
+
+	for len(fields) > 0 {
+		if len(fields) < 3 {
+			return Invoice{}, errors.New("payments: invalid invoice: truncated tagged field")
+		}
+
+		tag, length := fields[0], int(fields[1])<<5|int(fields[2])
+		if len(fields) < 3+length {
+			return Invoice{}, errors.New("payments: invalid invoice: truncated tagged field")
+		}
+		value := fields[3 : 3+length]
+		fields = fields[3+length:]
+
+		switch tag {
+		case 1: // 'p', the payment hash
+			if length != 52 {
+				continue // readers must skip fields with unknown lengths
+			}
+			copy(invoice.PaymentHash[:], wordsToBytes(value))
+			hasHash = true
+
+		case 6: // 'x', the expiry in seconds
+			if length > 7 {
+				return Invoice{}, errors.New("payments: invalid invoice: expiry is too large")
+			}
+			expiry = wordsToInt(value)
+		}
+	}
+
+	if !hasHash {
+		return Invoice{}, errors.New("payments: invalid invoice: missing payment hash")
+	}
+
+	invoice.ExpiresAt = time.Unix(timestamp+expiry, 0)
+	return invoice, nil
+}
+
+// parseAmount returns the amount in msats of the human readable part of an invoice, or 0 if it has no amount.
+func parseAmount(hrp string) (int64, error) {
+	if !strings.HasPrefix(hrp, "ln") {
+		return 0, errors.New("human readable part must start with \"ln\"")
+	}
+
+	i := strings.IndexAny(hrp, "0123456789")
+	if i == -1 {
+		return 0, nil
+	}
+	amount := hrp[i:]
+
+	multiplier := amount[len(amount)-1]
+	if multiplier >= '0' && multiplier <= '9' {
+		multiplier = 0
+	} else {
+		amount = amount[:len(amount)-1]
+	}
+
+	// msats for each unit of the amount, which is a tenth of msat for the 'p' multiplier
+	var msats int64
+	switch multiplier {
+	case 0:
+		msats = 100_000_000_000 // one bitcoin
+	case 'm':
+		msats = 100_000_000
+	case 'u':
+		msats = 100_000
+	case 'n':
+		msats = 100
+	case 'p':
+		msats = 0
+	default:
+		return 0, fmt.Errorf("invalid amount multiplier %q", multiplier)
+	}
+
+	n, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid amount %q", hrp[i:])
+	}
+
+	if msats == 0 {
+		if n%10 != 0 {
+			return 0, fmt.Errorf("invalid amount %q: sub-millisatoshi precision", hrp[i:])
+		}
+		return n / 10, nil
+	}
+	if n > math.MaxInt64/msats {
+		return 0, fmt.Errorf("invalid amount %q: too large", hrp[i:])
+	}
+	return n * msats, nil
+}
+
+const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// decodeBech32 decodes the lowercase bech32 string into its human readable part and its 5-bit words,
+// without the checksum. Unlike BIP-173, the length of the string is not limited, as required by BOLT11.
+func decodeBech32(s string) (string, []byte, error) {
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+7 > len(s) {
+		return "", nil, errors.New("invalid bech32 separator position")
+	}
+	hrp := s[:sep]
+
+	words := make([]byte, 0, len(s)-sep-1)
+	for _, c := range s[sep+1:] {
+		w := strings.IndexRune(charset, c)
+		if w == -1 {
+			return "", nil, fmt.Errorf("invalid bech32 character %q", c)
+		}
+		words = append(words, byte(w))
+	}
+
+	if polymod(append(expandHRP(hrp), words...)) != 1 {
+		return "", nil, errors.New("invalid bech32 checksum")
+	}
+	return hrp, words[:len(words)-6], nil
+}
+
+func expandHRP(hrp string) []byte {
+	expanded := make([]byte, 0, 2*len(hrp)+1)
+	for i := range len(hrp) {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := range len(hrp) {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	return expanded
+}
+
+func polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	checksum := uint32(1)
+	for _, v := range values {
+		top := checksum >> 25
+		checksum = (checksum&0x1ffffff)<<5 ^ uint32(v)
+		for i := range 5 {
+			if (top>>i)&1 == 1 {
+				checksum ^= generator[i]
+			}
+		}
+	}
+	return checksum
+}
+
+// wordsToInt interprets the 5-bit words as a big-endian integer.
+func wordsToInt(words []byte) int64 {
+	var n int64
+	for _, w := range words {
+		n = n<<5 | int64(w)
+	}
+	return n
+}
+
+// wordsToBytes regroups the 5-bit words into bytes, dropping the incomplete trailing bits.
+func wordsToBytes(words []byte) []byte {
+	bytes := make([]byte, 0, len(words)*5/8)
+	var acc uint32
+	var bits uint
+	for _, w := range words {
+		acc = acc<<5 | uint32(w)
+		bits += 5
+		if bits >= 8 {
+			bits -= 8
+			bytes = append(bytes, byte(acc>>bits))
+		}
+	}
+	return bytes
+}
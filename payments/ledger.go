@@ -0,0 +1,52 @@
+package payments
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryLedger is a [Ledger] in memory. Create one with [NewMemoryLedger].
+// The balances are lost when the process exits, so it's only suitable for testing or for credits that are spent right away.
+type MemoryLedger struct {
+	mu       sync.Mutex
+	balances map[string]int64
+}
+
+// NewMemoryLedger returns an empty [MemoryLedger].
+func NewMemoryLedger() *MemoryLedger {
+	return &MemoryLedger{balances: make(map[string]int64)}
+}
+
+func (l *MemoryLedger) Balance(ctx context.Context, pubkey string) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.balances[pubkey], nil
+}
+
+func (l *MemoryLedger) Credit(ctx context.Context, pubkey string, amount int64) error {
+	if amount < 0 {
+		return ErrInvalidAmount
+	}
+	l.add(pubkey, amount)
+	return nil
+}
+
+func (l *MemoryLedger) Debit(ctx context.Context, pubkey string, amount int64) error {
+	if amount < 0 {
+		return ErrInvalidAmount
+	}
+	l.add(pubkey, -amount)
+	return nil
+}
+
+func (l *MemoryLedger) add(pubkey string, delta int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	balance := l.balances[pubkey] + delta
+	if balance == 0 {
+		delete(l.balances, pubkey)
+		return
+	}
+	l.balances[pubkey] = balance
+}
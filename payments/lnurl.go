@@ -0,0 +1,152 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LNURL is an [Invoicer] that requests invoices from a LNURL-pay service (LUD-06), such as the one
+// behind a Lightning address (LUD-16). It doesn't require running a node, but the invoices
+// are paid to the service. Create one with [NewLNURL].
+type LNURL struct {
+	endpoint string
+	client   *http.Client
+}
+
+type LNURLOption func(*LNURL)
+
+// WithLNURLClient sets the http client used to request the invoices.
+// By default, it's a client with a timeout of 10 seconds.
+func WithLNURLClient(c *http.Client) LNURLOption {
+	return func(l *LNURL) {
+		l.client = c
+	}
+}
+
+// NewLNURL returns a [LNURL] invoicer for the Lightning address (e.g. "name@example.com"),
+// or for the https URL of a LNURL-pay endpoint.
+func NewLNURL(address string, opts ...LNURLOption) (*LNURL, error) {
+	endpoint := address
+	if name, domain, ok := strings.Cut(address, "@"); ok {
+		if name == "" || domain == "" {
+			return nil, fmt.Errorf("payments: invalid lightning address %q", address)
+		}
+		endpoint = "https://" + domain + "/.well-known/lnurlp/" + name
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("payments: invalid LNURL endpoint: %w", err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("payments: invalid LNURL endpoint %q: must be a https URL", endpoint)
+	}
+
+	l := &LNURL{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	if l.client == nil {
+		return nil, errors.New("payments: LNURL http client must not be nil")
+	}
+	return l, nil
+}
+
+// CreateInvoice requests an invoice for the amount in msats to the LNURL-pay service,
+// and checks that it matches the requested amount.
+func (l *LNURL) CreateInvoice(ctx context.Context, amount int64, description string) (Invoice, error) {
+	var params struct {
+		Tag            string `json:"tag"`
+		Callback       string `json:"callback"`
+		MinSendable    int64  `json:"minSendable"`
+		MaxSendable    int64  `json:"maxSendable"`
+		CommentAllowed int    `json:"commentAllowed"`
+	}
+	if err := l.get(ctx, l.endpoint, &params); err != nil {
+		return Invoice{}, err
+	}
+
+	if params.Tag != "payRequest" {
+		return Invoice{}, fmt.Errorf("payments: LNURL endpoint is not a pay request: tag is %q", params.Tag)
+	}
+	if amount < params.MinSendable || amount > params.MaxSendable {
+		return Invoice{}, fmt.Errorf("payments: amount %d msats is outside the LNURL limits [%d, %d]", amount, params.MinSendable, params.MaxSendable)
+	}
+
+	callback, err := url.Parse(params.Callback)
+	if err != nil || callback.Scheme != "https" {
+		return Invoice{}, fmt.Errorf("payments: invalid LNURL callback %q", params.Callback)
+	}
+
+	query := callback.Query()
+	query.Set("amount", strconv.FormatInt(amount, 10))
+	if description != "" && len(description) <= params.CommentAllowed {
+		query.Set("comment", description)
+	}
+	callback.RawQuery = query.Encode()
+
+	var response struct {
+		PR string `json:"pr"`
+	}
+	if err := l.get(ctx, callback.String(), &response); err != nil {
+		return Invoice{}, err
+	}
+
+	invoice, err := DecodeInvoice(response.PR)
+	if err != nil {
+		return Invoice{}, err
+	}
+	if invoice.Amount != amount {
+		return Invoice{}, fmt.Errorf("payments: LNURL invoice is for %d msats instead of %d", invoice.Amount, amount)
+	}
+	return invoice, nil
+}
+
+// get fetches the JSON response of the url into v, handling the LNURL error responses.
+func (l *LNURL) get(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("payments: %w", err)
+	}
+
+	res, err := l.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("payments: LNURL request failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("payments: LNURL request failed with status %d", res.StatusCode)
+	}
+
+	var body struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+	raw, err := io.ReadAll(io.LimitReader(res.Body, 100_000))
+	if err != nil {
+		return fmt.Errorf("payments: failed to read the LNURL response: %w", err)
+	}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return fmt.Errorf("payments: invalid LNURL response: %w", err)
+	}
+	if strings.EqualFold(body.Status, "ERROR") {
+		return fmt.Errorf("payments: LNURL error: %s", body.Reason)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("payments: invalid LNURL response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,387 @@
+// Package payments makes uploads to a blossy server require a Lightning payment, as specified by BUD-07.
+//
+// Each pubkey has a balance of credits in a [Ledger], which is charged for every blob it uploads.
+// When the balance doesn't cover the price of an upload, the server responds with 402 (Payment Required)
+// and a Lightning invoice in the 'X-Lightning' header, created by an [Invoicer].
+// After paying it, the client retries the request with the preimage of the payment in the 'X-Lightning' header,
+// which credits the amount of the invoice to the pubkey that requested it.
+// Pubkeys can also buy credits by zapping the server, as verified by a [ZapVerifier].
+//
+// Amounts are in millisatoshis (msats).
+//
+// Example:
+//
+//	invoicer, err := payments.NewLNURL("payments@example.com")
+//	if err != nil {
+//	    panic(err)
+//	}
+//
+//	p := payments.New(invoicer, payments.PerMiB(10_000)) // 10 sats per MiB
+//	p.Bind(server)
+package payments
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+)
+
+var (
+	// ErrInvalidPreimage is returned by [Payments.Redeem] when the preimage is not 32 hex-encoded bytes.
+	ErrInvalidPreimage = errors.New("payments: preimage must be 32 hex-encoded bytes")
+
+	// ErrUnknownPayment is returned by [Payments.Redeem] when the preimage doesn't belong to any pending invoice,
+	// for example because it was already redeemed or the invoice expired long ago.
+	ErrUnknownPayment = errors.New("payments: no pending invoice for the preimage")
+
+	// ErrInvalidAmount is returned by the ledgers when crediting or debiting a negative amount.
+	ErrInvalidAmount = errors.New("payments: amount must not be negative")
+)
+
+// Invoice is a Lightning invoice created by an [Invoicer].
+type Invoice struct {
+	// PaymentRequest is the BOLT11 encoded invoice, sent to the client.
+	PaymentRequest string
+
+	// PaymentHash is the sha256 of the preimage revealed by the payment.
+	PaymentHash [32]byte
+
+	// Amount of the invoice in msats.
+	Amount int64
+
+	// ExpiresAt is when the invoice can no longer be paid. If zero, it's assumed to be one hour after its creation.
+	ExpiresAt time.Time
+}
+
+// Invoicer creates Lightning invoices, for example through a LND or CLN node, or a LNURL-pay service (see [NewLNURL]).
+// Implementations must be safe for concurrent use.
+type Invoicer interface {
+	CreateInvoice(ctx context.Context, amount int64, description string) (Invoice, error)
+}
+
+// Ledger persists the balance of credits of each pubkey, in msats.
+// Implementations must be safe for concurrent use.
+type Ledger interface {
+	// Balance returns the credits of the pubkey, which are zero for unknown pubkeys.
+	Balance(ctx context.Context, pubkey string) (int64, error)
+
+	// Credit adds the amount to the balance of the pubkey.
+	Credit(ctx context.Context, pubkey string, amount int64) error
+
+	// Debit subtracts the amount from the balance of the pubkey.
+	// The balance can become negative, in which case it must be paid back before uploading again.
+	Debit(ctx context.Context, pubkey string, amount int64) error
+}
+
+// PriceFunc returns the price in msats of uploading a blob of the provided size.
+// The size is -1 when it's not known in advance. A price of zero or less makes the upload free.
+type PriceFunc func(pubkey string, size int64) int64
+
+// PerMiB returns a [PriceFunc] that charges msats for every started MiB of the blob.
+// Blobs of unknown size are charged as one MiB.
+func PerMiB(msats int64) PriceFunc {
+	return func(pubkey string, size int64) int64 {
+		const MiB = 1 << 20
+		if size <= 0 {
+			return msats
+		}
+		return (size + MiB - 1) / MiB * msats
+	}
+}
+
+// Payments charges the uploads of each pubkey. Create one with [New].
+// It's safe for concurrent use.
+type Payments struct {
+	invoicer Invoicer
+	ledger   Ledger
+	price    PriceFunc
+
+	minInvoice int64
+	maxPending int
+
+	mu      sync.Mutex
+	pending map[[32]byte]pending
+}
+
+// pending is an invoice that has not been redeemed yet.
+type pending struct {
+	pubkey  string
+	amount  int64
+	expires time.Time // when the invoice can no longer be redeemed
+}
+
+const (
+	// invoiceExpiry is the expiry of the invoices that don't specify one.
+	invoiceExpiry = time.Hour
+
+	// redeemGrace is how long after its expiry an invoice can still be redeemed,
+	// for clients that paid it right before it expired.
+	redeemGrace = time.Hour
+)
+
+type Option func(*Payments)
+
+// WithLedger sets the ledger that persists the balances. By default, it's a [MemoryLedger].
+func WithLedger(l Ledger) Option {
+	return func(p *Payments) {
+		p.ledger = l
+	}
+}
+
+// WithMinInvoice sets the minimum amount in msats of the invoices, so that clients can pay for several uploads at once.
+// By default, it's 1000 msats (1 sat), the smallest amount most wallets can pay.
+func WithMinInvoice(msats int64) Option {
+	return func(p *Payments) {
+		p.minInvoice = msats
+	}
+}
+
+// WithMaxPending sets the maximum number of invoices waiting to be redeemed.
+// When reached, new invoices are refused with 503 (Service Unavailable). By default, it's 10000.
+func WithMaxPending(n int) Option {
+	return func(p *Payments) {
+		p.maxPending = n
+	}
+}
+
+// New returns [Payments] that charge the uploads according to the price, creating invoices with the invoicer.
+// It panics if the invoicer or the price is nil, or the options are invalid.
+func New(invoicer Invoicer, price PriceFunc, opts ...Option) *Payments {
+	if invoicer == nil {
+		panic("payments.New: invoicer must not be nil")
+	}
+	if price == nil {
+		panic("payments.New: price must not be nil")
+	}
+
+	p := &Payments{
+		invoicer:   invoicer,
+		price:      price,
+		ledger:     NewMemoryLedger(),
+		minInvoice: 1000,
+		maxPending: 10_000,
+		pending:    make(map[[32]byte]pending),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.ledger == nil {
+		panic("payments.New: ledger must not be nil")
+	}
+	if p.minInvoice <= 0 {
+		panic("payments.New: minimum invoice must be positive")
+	}
+	if p.maxPending <= 0 {
+		panic("payments.New: maximum pending invoices must be positive")
+	}
+	return p
+}
+
+// Ledger returns the ledger of the balances, for example to credit pubkeys that paid by other means.
+func (p *Payments) Ledger() Ledger {
+	return p.ledger
+}
+
+var _ blossy.Plugin = (*Payments)(nil)
+
+// Name returns "payments", the name of the payments as a [blossy.Plugin].
+func (p *Payments) Name() string { return "payments" }
+
+// Setup binds the payments to the server, so that they can be installed as a [blossy.Plugin]. See [Payments.Bind].
+func (p *Payments) Setup(s *blossy.Server) error {
+	p.Bind(s)
+	return nil
+}
+
+// Bind wires the payments into the server:
+//   - PUT /upload and PUT /media (and their HEAD requests) respond with 402 and an invoice when the balance of the pubkey
+//     doesn't cover the price of the blob, and redeem the preimage in the 'X-Lightning' header, if any.
+//   - The blobs stored by the Upload and Media hooks are charged to the pubkey, according to their actual size.
+//   - The payment requirements are added to the info of the server (see [blossy.Server.Info]).
+//
+// It must be called after the On hooks are set (e.g. after [blossy.BindStore]), as it wraps them.
+// Uploads require authorization, as the balance is tracked per pubkey.
+func (p *Payments) Bind(s *blossy.Server) {
+	s.Reject.Upload.Append(p.CheckPayment)
+	s.Reject.Media.Append(p.CheckPayment)
+	s.ExtendInfo(p.describe)
+
+	if upload := s.On.Upload; upload != nil {
+		s.On.Upload = func(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+			desc, err := upload(r, hints, data)
+			if err != nil {
+				return desc, err
+			}
+			return desc, p.Charge(r, desc)
+		}
+	}
+
+	if media := s.On.Media; media != nil {
+		s.On.Media = func(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+			desc, err := media(r, hints, data)
+			if err != nil {
+				return desc, err
+			}
+			return desc, p.Charge(r, desc)
+		}
+	}
+}
+
+// describe adds the payment requirements to the info of the server.
+func (p *Payments) describe(info *blossy.Info) {
+	info.Payment = &blossy.PaymentInfo{
+		Endpoints:   []blossy.Endpoint{blossy.EndpointUpload, blossy.EndpointMedia},
+		Methods:     []string{"lightning"},
+		PricePerMiB: p.price("", 1<<20),
+	}
+}
+
+// CheckPayment is a Reject hook for the Upload and Media endpoints.
+// It redeems the preimage in the 'X-Lightning' header of the request, if any, and then rejects the upload
+// with 402 (Payment Required) if the balance of the pubkey doesn't cover its price,
+// sending an invoice for the missing amount in the 'X-Lightning' header of the response.
+// Unauthenticated uploads are rejected with 401 (Unauthorized).
+func (p *Payments) CheckPayment(r blossy.Request, hints blossy.UploadHints) *blossom.Error {
+	if !r.IsAuthed() {
+		return blossom.ErrUnauthorized("authorization is required for paid uploads")
+	}
+
+	price := p.price(r.Pubkey(), hints.Size)
+	if price <= 0 {
+		return nil
+	}
+
+	if preimage := r.Raw().Header.Get("X-Lightning"); preimage != "" {
+		err := p.Redeem(r.Context(), preimage)
+		switch {
+		case errors.Is(err, ErrInvalidPreimage):
+			return blossom.ErrBadRequest("'X-Lightning' header is invalid: " + err.Error())
+
+		case err != nil && !errors.Is(err, ErrUnknownPayment):
+			// unknown payments might have been redeemed by a previous request, so the balance decides
+			return blossom.ErrInternal(err.Error())
+		}
+	}
+
+	balance, err := p.ledger.Balance(r.Context(), r.Pubkey())
+	if err != nil {
+		return blossom.ErrInternal(fmt.Sprintf("payments: failed to get the balance: %v", err))
+	}
+	if balance >= price {
+		return nil
+	}
+
+	invoice, rerr := p.invoice(r.Context(), r.Pubkey(), max(price-balance, p.minInvoice))
+	if rerr != nil {
+		return rerr
+	}
+
+	blossy.ResponseHeader(r).Set("X-Lightning", invoice.PaymentRequest)
+	return blossom.ErrPaymentRequired(fmt.Sprintf("payment of %d msats required: pay the invoice in the 'X-Lightning' header and retry with its preimage", invoice.Amount))
+}
+
+// invoice creates an invoice for the amount, to be credited to the pubkey when redeemed.
+func (p *Payments) invoice(ctx context.Context, pubkey string, amount int64) (Invoice, *blossom.Error) {
+	p.mu.Lock()
+	full := p.isFull()
+	p.mu.Unlock()
+
+	if full {
+		return Invoice{}, blossom.ErrUnavailable("too many pending invoices, try again later")
+	}
+
+	invoice, err := p.invoicer.CreateInvoice(ctx, amount, "blossom upload")
+	if err != nil {
+		return Invoice{}, blossom.ErrUnavailable(fmt.Sprintf("payments: failed to create the invoice: %v", err))
+	}
+
+	expires := invoice.ExpiresAt
+	if expires.IsZero() {
+		expires = time.Now().Add(invoiceExpiry)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending[invoice.PaymentHash] = pending{
+		pubkey:  pubkey,
+		amount:  invoice.Amount,
+		expires: expires.Add(redeemGrace),
+	}
+	return invoice, nil
+}
+
+// isFull returns whether the pending invoices reached the maximum, after removing the expired ones.
+// It must be called with the lock held.
+func (p *Payments) isFull() bool {
+	if len(p.pending) < p.maxPending {
+		return false
+	}
+
+	now := time.Now()
+	for hash, pending := range p.pending {
+		if now.After(pending.expires) {
+			delete(p.pending, hash)
+		}
+	}
+	return len(p.pending) >= p.maxPending
+}
+
+// Redeem credits the amount of the invoice paid with the hex-encoded preimage to the pubkey that requested it.
+// Every invoice can be redeemed only once.
+func (p *Payments) Redeem(ctx context.Context, preimage string) error {
+	raw, err := hex.DecodeString(preimage)
+	if err != nil || len(raw) != 32 {
+		return ErrInvalidPreimage
+	}
+	hash := sha256.Sum256(raw)
+
+	p.mu.Lock()
+	pending, ok := p.pending[hash]
+	if ok {
+		delete(p.pending, hash)
+	}
+	p.mu.Unlock()
+
+	if !ok || time.Now().After(pending.expires) {
+		return ErrUnknownPayment
+	}
+
+	if err := p.ledger.Credit(ctx, pending.pubkey, pending.amount); err != nil {
+		// put it back, so that the client can retry
+		p.mu.Lock()
+		p.pending[hash] = pending
+		p.mu.Unlock()
+		return fmt.Errorf("payments: failed to credit the payment: %w", err)
+	}
+	return nil
+}
+
+// Charge debits the price of the blob of the descriptor from the balance of the pubkey of the request.
+// It's meant to be called after a successful upload, and it's a no-op for unauthenticated requests.
+//
+// Concurrent uploads are checked against the same balance, so they can make it negative.
+func (p *Payments) Charge(r blossy.Request, desc blossom.BlobDescriptor) *blossom.Error {
+	if !r.IsAuthed() {
+		return nil
+	}
+
+	price := p.price(r.Pubkey(), desc.Size)
+	if price <= 0 {
+		return nil
+	}
+
+	if err := p.ledger.Debit(r.Context(), r.Pubkey(), price); err != nil {
+		return blossom.ErrInternal(fmt.Sprintf("payments: failed to charge the upload: %v", err))
+	}
+	return nil
+}
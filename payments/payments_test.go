@@ -0,0 +1,301 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/auth"
+	"github.com/pippellia-btc/blossy/blossytest"
+)
+
+// fakeInvoicer creates valid invoices and remembers their preimages, as if they were paid.
+type fakeInvoicer struct {
+	mu        sync.Mutex
+	preimages map[string]string // payment request -> preimage
+}
+
+func newFakeInvoicer() *fakeInvoicer {
+	return &fakeInvoicer{preimages: make(map[string]string)}
+}
+
+func (f *fakeInvoicer) CreateInvoice(ctx context.Context, amount int64, description string) (Invoice, error) {
+	preimage := make([]byte, 32)
+	rand.Read(preimage)
+	hash := sha256.Sum256(preimage)
+
+	pr := encodeInvoice("lnbc"+formatAmount(amount), time.Now().Unix(), hash, 600)
+	f.mu.Lock()
+	f.preimages[pr] = hex.EncodeToString(preimage)
+	f.mu.Unlock()
+
+	return DecodeInvoice(pr)
+}
+
+func (f *fakeInvoicer) pay(pr string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.preimages[pr]
+}
+
+func TestDecodeInvoice(t *testing.T) {
+	hash := sha256.Sum256([]byte("preimage"))
+
+	tests := []struct {
+		hrp    string
+		amount int64
+	}{
+		{hrp: "lnbc", amount: 0},
+		{hrp: "lnbc2500u", amount: 250_000_000},
+		{hrp: "lnbc20m", amount: 2_000_000_000},
+		{hrp: "lntb10n", amount: 1000},
+		{hrp: "lnbcrt1", amount: 100_000_000_000},
+		{hrp: "lnbc10p", amount: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.hrp, func(t *testing.T) {
+			pr := encodeInvoice(test.hrp, 1496314658, hash, 60)
+			invoice, err := DecodeInvoice(strings.ToUpper(pr))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if invoice.Amount != test.amount {
+				t.Errorf("expected amount %d, got %d", test.amount, invoice.Amount)
+			}
+			if invoice.PaymentHash != hash {
+				t.Errorf("expected payment hash %x, got %x", hash, invoice.PaymentHash)
+			}
+			if !invoice.ExpiresAt.Equal(time.Unix(1496314658+60, 0)) {
+				t.Errorf("expected expiration %v, got %v", time.Unix(1496314658+60, 0), invoice.ExpiresAt)
+			}
+		})
+	}
+
+	invalid := []string{
+		"",
+		"lnbc1",
+		encodeInvoice("bc2500u", 1496314658, hash, 60),
+		encodeInvoice("lnbc15p", 1496314658, hash, 60),
+		encodeInvoice("lnbc25x", 1496314658, hash, 60),
+		encodeInvoice("lnbc2500u", 1496314658, hash, 60) + "q",
+	}
+	for _, pr := range invalid {
+		if _, err := DecodeInvoice(pr); err == nil {
+			t.Errorf("expected an error for %q", pr)
+		}
+	}
+}
+
+func TestRedeem(t *testing.T) {
+	invoicer := newFakeInvoicer()
+	p := New(invoicer, PerMiB(1000))
+	ctx := t.Context()
+
+	if err := p.Redeem(ctx, "not hex"); !errors.Is(err, ErrInvalidPreimage) {
+		t.Errorf("expected ErrInvalidPreimage, got %v", err)
+	}
+	if err := p.Redeem(ctx, strings.Repeat("00", 32)); !errors.Is(err, ErrUnknownPayment) {
+		t.Errorf("expected ErrUnknownPayment, got %v", err)
+	}
+
+	invoice, rerr := p.invoice(ctx, "alice", 5000)
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	preimage := invoicer.pay(invoice.PaymentRequest)
+
+	if err := p.Redeem(ctx, preimage); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := p.Redeem(ctx, preimage); !errors.Is(err, ErrUnknownPayment) {
+		t.Errorf("expected ErrUnknownPayment when redeeming twice, got %v", err)
+	}
+
+	balance, _ := p.Ledger().Balance(ctx, "alice")
+	if balance != 5000 {
+		t.Errorf("expected balance 5000, got %d", balance)
+	}
+}
+
+func TestBind(t *testing.T) {
+	server := blossytest.NewTestServer(t)
+	invoicer := newFakeInvoicer()
+	p := New(invoicer, PerMiB(400))
+	p.Bind(server.Blossy)
+
+	signer := blossytest.NewSigner(t)
+	data := []byte("hello")
+	hash := blossom.ComputeHash(data)
+
+	upload := func(preimage string) *http.Response {
+		r := server.NewRequest(t, http.MethodPut, "/upload", bytes.NewReader(data))
+		r.Header.Set("Content-Digest", hash.Hex())
+		if preimage != "" {
+			r.Header.Set("X-Lightning", preimage)
+		}
+		blossytest.Authorize(t, r, signer, auth.ActionUpload, hash)
+		return server.Do(t, r)
+	}
+
+	res := upload("")
+	if res.StatusCode != http.StatusPaymentRequired {
+		t.Fatalf("expected 402, got %d (%s)", res.StatusCode, res.Header.Get("X-Reason"))
+	}
+
+	pr := res.Header.Get("X-Lightning")
+	invoice, err := DecodeInvoice(pr)
+	if err != nil {
+		t.Fatalf("expected an invoice in the 'X-Lightning' header: %v", err)
+	}
+	if invoice.Amount != 1000 {
+		t.Errorf("expected the invoice to be of the minimum amount, got %d msats", invoice.Amount)
+	}
+
+	res = upload(invoicer.pay(pr))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 after paying, got %d (%s)", res.StatusCode, res.Header.Get("X-Reason"))
+	}
+
+	balance, _ := p.Ledger().Balance(t.Context(), blossytest.Pubkey(t, signer))
+	if balance != 600 {
+		t.Errorf("expected balance 600 after the upload, got %d", balance)
+	}
+}
+
+func TestLNURL(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/lnurlp/alice":
+			json.NewEncoder(w).Encode(map[string]any{
+				"tag":            "payRequest",
+				"callback":       ts.URL + "/callback",
+				"minSendable":    1000,
+				"maxSendable":    1_000_000,
+				"commentAllowed": 0,
+			})
+
+		case "/callback":
+			amount := r.URL.Query().Get("amount")
+			if amount != "5000" {
+				json.NewEncoder(w).Encode(map[string]string{"status": "ERROR", "reason": "unexpected amount " + amount})
+				return
+			}
+			pr := encodeInvoice("lnbc50n", time.Now().Unix(), sha256.Sum256([]byte("x")), 600)
+			json.NewEncoder(w).Encode(map[string]string{"pr": pr})
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	address := "alice@" + strings.TrimPrefix(ts.URL, "https://")
+	lnurl, err := NewLNURL(address, WithLNURLClient(ts.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invoice, err := lnurl.CreateInvoice(t.Context(), 5000, "blossom upload")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if invoice.Amount != 5000 {
+		t.Errorf("expected amount 5000, got %d", invoice.Amount)
+	}
+
+	if _, err := lnurl.CreateInvoice(t.Context(), 2_000_000, ""); err == nil {
+		t.Error("expected an error for an amount over the limits")
+	}
+	if _, err := lnurl.CreateInvoice(t.Context(), 6000, ""); err == nil || !strings.Contains(err.Error(), "unexpected amount") {
+		t.Errorf("expected the LNURL error, got %v", err)
+	}
+
+	if _, err := NewLNURL("http://example.com/lnurlp"); err == nil {
+		t.Error("expected an error for a non https endpoint")
+	}
+}
+
+func TestCheckPaymentFree(t *testing.T) {
+	p := New(newFakeInvoicer(), func(pubkey string, size int64) int64 { return 0 })
+
+	if err := p.CheckPayment(blossy.NewTestRequest(), blossy.UploadHints{Size: 1}); err == nil || err.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for unauthenticated uploads, got %v", err)
+	}
+	if err := p.CheckPayment(blossy.NewTestRequest(blossy.RequestPubkey("alice")), blossy.UploadHints{Size: 1}); err != nil {
+		t.Errorf("expected free uploads to be accepted, got %v", err)
+	}
+}
+
+// encodeInvoice returns a BOLT11 invoice with the payment hash and expiry, and an empty signature.
+func encodeInvoice(hrp string, timestamp int64, hash [32]byte, expiry int64) string {
+	var words []byte
+	words = append(words, intToWords(timestamp, 7)...)
+
+	hashWords := bytesToWords(hash[:])
+	words = append(words, 1, byte(len(hashWords)>>5), byte(len(hashWords)&31))
+	words = append(words, hashWords...)
+
+	words = append(words, 6, 0, 2)
+	words = append(words, intToWords(expiry, 2)...)
+
+	words = append(words, make([]byte, 104)...)
+
+	values := append(expandHRP(hrp), words...)
+	mod := polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ 1
+	for i := range 6 {
+		words = append(words, byte(mod>>(5*(5-i))&31))
+	}
+
+	var b strings.Builder
+	b.WriteString(hrp + "1")
+	for _, w := range words {
+		b.WriteByte(charset[w])
+	}
+	return b.String()
+}
+
+func intToWords(n int64, length int) []byte {
+	words := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		words[i] = byte(n & 31)
+		n >>= 5
+	}
+	return words
+}
+
+func formatAmount(msats int64) string {
+	return strconv.FormatInt(msats*10, 10) + "p"
+}
+
+func bytesToWords(data []byte) []byte {
+	var words []byte
+	var acc uint32
+	var bits uint
+	for _, b := range data {
+		acc = acc<<8 | uint32(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			words = append(words, byte(acc>>bits&31))
+		}
+	}
+	if bits > 0 {
+		words = append(words, byte(acc<<(5-bits)&31))
+	}
+	return words
+}
@@ -0,0 +1,291 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/auth"
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+// ErrZapRedeemed is returned by [ZapVerifier.Redeem] when the zap receipt has already been redeemed.
+var ErrZapRedeemed = errors.New("payments: zap receipt already redeemed")
+
+// ZapVerifier credits the uploaders that paid with a Nostr zap (NIP-57) to the server pubkey.
+// Create one with [NewZapVerifier].
+//
+// Clients send the zap receipt (kind 9735) in the 'X-Zap-Receipt' header of the upload, as base64 encoded JSON.
+// The receipt must be signed by the zapper (the nostr pubkey of the LNURL service of the server),
+// and its zap request (kind 9734) must be signed by the uploader. If the zap request references an event,
+// it must be the authorization event of the upload.
+// Every receipt can be redeemed only once. It's safe for concurrent use.
+//
+// Example:
+//
+//	p := payments.New(invoicer, payments.PerMiB(10_000))
+//	p.Bind(server)
+//
+//	zaps, err := payments.NewZapVerifier(p.Ledger(), zapperPubkey, serverPubkey)
+//	if err != nil {
+//	    panic(err)
+//	}
+//	zaps.Bind(server)
+type ZapVerifier struct {
+	ledger    Ledger
+	zapper    string
+	recipient string
+
+	maxAge    time.Duration
+	minAmount int64
+
+	mu       sync.Mutex
+	redeemed map[[32]byte]time.Time // payment hash -> when it can be forgotten
+}
+
+// maxRedeemed is the maximum number of redeemed zaps remembered by a [ZapVerifier].
+const maxRedeemed = 100_000
+
+type ZapOption func(*ZapVerifier)
+
+// WithMaxZapAge sets the maximum age of the zap receipts that can be redeemed. By default, it's 24 hours.
+func WithMaxZapAge(d time.Duration) ZapOption {
+	return func(z *ZapVerifier) {
+		z.maxAge = d
+	}
+}
+
+// WithMinZap sets the minimum amount in msats of the zaps that can be redeemed. By default, it's 1000 msats (1 sat).
+func WithMinZap(msats int64) ZapOption {
+	return func(z *ZapVerifier) {
+		z.minAmount = msats
+	}
+}
+
+// NewZapVerifier returns a [ZapVerifier] that credits the zaps to the recipient pubkey, as receipted by the zapper pubkey,
+// to the ledger.
+func NewZapVerifier(ledger Ledger, zapper, recipient string, opts ...ZapOption) (*ZapVerifier, error) {
+	if ledger == nil {
+		return nil, errors.New("payments: ledger must not be nil")
+	}
+	if err := utils.ValidatePubkey(zapper); err != nil {
+		return nil, fmt.Errorf("payments: invalid zapper pubkey: %w", err)
+	}
+	if err := utils.ValidatePubkey(recipient); err != nil {
+		return nil, fmt.Errorf("payments: invalid recipient pubkey: %w", err)
+	}
+
+	z := &ZapVerifier{
+		ledger:    ledger,
+		zapper:    zapper,
+		recipient: recipient,
+		maxAge:    24 * time.Hour,
+		minAmount: 1000,
+		redeemed:  make(map[[32]byte]time.Time),
+	}
+	for _, opt := range opts {
+		opt(z)
+	}
+
+	if z.maxAge <= 0 {
+		return nil, errors.New("payments: maximum zap age must be positive")
+	}
+	if z.minAmount <= 0 {
+		return nil, errors.New("payments: minimum zap must be positive")
+	}
+	return z, nil
+}
+
+// Bind prepends [ZapVerifier.CheckZap] to the Reject hooks of the Upload and Media endpoints,
+// so that the zaps are credited before the balance is checked by [Payments.CheckPayment].
+func (z *ZapVerifier) Bind(s *blossy.Server) {
+	s.Reject.Upload.Prepend(z.CheckZap)
+	s.Reject.Media.Prepend(z.CheckZap)
+}
+
+// CheckZap is a Reject hook for the Upload and Media endpoints, which redeems the zap receipt
+// in the 'X-Zap-Receipt' header of the request, if any, crediting its amount to the uploader.
+// It rejects invalid receipts with 400 (Bad Request) or 402 (Payment Required),
+// and receipts in unauthenticated requests with 401 (Unauthorized).
+// Receipts that have already been redeemed are ignored, as the client might be retrying the upload.
+func (z *ZapVerifier) CheckZap(r blossy.Request, hints blossy.UploadHints) *blossom.Error {
+	header := r.Raw().Header.Get("X-Zap-Receipt")
+	if header == "" {
+		return nil
+	}
+	if !r.IsAuthed() {
+		return blossom.ErrUnauthorized("authorization is required to redeem zaps")
+	}
+
+	raw, err := utils.DecodeBase64(header)
+	if err != nil {
+		return blossom.ErrBadRequest("'X-Zap-Receipt' header is invalid: " + err.Error())
+	}
+
+	receipt := &nostr.Event{}
+	if err := json.Unmarshal(raw, receipt); err != nil {
+		return blossom.ErrBadRequest("'X-Zap-Receipt' header is invalid: " + err.Error())
+	}
+
+	var authID string
+	if event, err := auth.ExtractEvent(r.Raw()); err == nil {
+		authID = event.ID
+	}
+
+	_, err = z.Redeem(r.Context(), receipt, r.Pubkey(), authID)
+	switch {
+	case err == nil, errors.Is(err, ErrZapRedeemed):
+		return nil
+
+	case errors.Is(err, errInvalidZap):
+		return blossom.ErrPaymentRequired(err.Error())
+
+	default:
+		return blossom.ErrInternal(err.Error())
+	}
+}
+
+// Redeem verifies the zap receipt (see [ZapVerifier.Verify]) and credits its amount to the uploader.
+// It returns the credited amount in msats.
+func (z *ZapVerifier) Redeem(ctx context.Context, receipt *nostr.Event, uploader, authID string) (int64, error) {
+	amount, hash, err := z.verify(receipt, uploader, authID)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	z.mu.Lock()
+	if _, ok := z.redeemed[hash]; ok {
+		z.mu.Unlock()
+		return 0, ErrZapRedeemed
+	}
+	if len(z.redeemed) >= maxRedeemed {
+		for h, until := range z.redeemed {
+			if now.After(until) {
+				delete(z.redeemed, h)
+			}
+		}
+		if len(z.redeemed) >= maxRedeemed {
+			z.mu.Unlock()
+			return 0, errors.New("payments: too many redeemed zaps, try again later")
+		}
+	}
+
+	// receipts older than the maximum age are rejected, so they don't need to be remembered after that
+	z.redeemed[hash] = receipt.CreatedAt.Time().Add(z.maxAge)
+	z.mu.Unlock()
+
+	if err := z.ledger.Credit(ctx, uploader, amount); err != nil {
+		z.mu.Lock()
+		delete(z.redeemed, hash)
+		z.mu.Unlock()
+		return 0, fmt.Errorf("payments: failed to credit the zap: %w", err)
+	}
+	return amount, nil
+}
+
+// errInvalidZap wraps the reasons why a zap receipt is not valid.
+var errInvalidZap = errors.New("invalid zap receipt")
+
+// Verify checks that the zap receipt is a valid payment to the recipient by the uploader, and returns its amount in msats.
+// If the zap request references an event, it must be the one with authID, typically the authorization event of the upload.
+// It doesn't check whether the receipt has already been redeemed.
+func (z *ZapVerifier) Verify(receipt *nostr.Event, uploader, authID string) (int64, error) {
+	amount, _, err := z.verify(receipt, uploader, authID)
+	return amount, err
+}
+
+func (z *ZapVerifier) verify(receipt *nostr.Event, uploader, authID string) (int64, [32]byte, error) {
+	invalid := func(format string, args ...any) (int64, [32]byte, error) {
+		return 0, [32]byte{}, fmt.Errorf("%w: %s", errInvalidZap, fmt.Sprintf(format, args...))
+	}
+
+	if receipt.Kind != nostr.KindZap {
+		return invalid("kind must be %d", nostr.KindZap)
+	}
+	if receipt.PubKey != z.zapper {
+		return invalid("not signed by the zapper")
+	}
+	if err := checkEvent(receipt); err != nil {
+		return invalid("%v", err)
+	}
+
+	age := time.Since(receipt.CreatedAt.Time())
+	if age > z.maxAge {
+		return invalid("older than %v", z.maxAge)
+	}
+	if age < -time.Minute {
+		return invalid("created in the future")
+	}
+
+	if p := receipt.Tags.Find("p"); p == nil || p[1] != z.recipient {
+		return invalid("recipient must be %s", z.recipient)
+	}
+
+	bolt11 := receipt.Tags.Find("bolt11")
+	if bolt11 == nil {
+		return invalid("missing 'bolt11' tag")
+	}
+	invoice, err := DecodeInvoice(bolt11[1])
+	if err != nil {
+		return invalid("%v", err)
+	}
+	if invoice.Amount < z.minAmount {
+		return invalid("amount must be at least %d msats", z.minAmount)
+	}
+
+	description := receipt.Tags.Find("description")
+	if description == nil {
+		return invalid("missing 'description' tag")
+	}
+	request := &nostr.Event{}
+	if err := json.Unmarshal([]byte(description[1]), request); err != nil {
+		return invalid("invalid zap request: %v", err)
+	}
+
+	if request.Kind != nostr.KindZapRequest {
+		return invalid("zap request kind must be %d", nostr.KindZapRequest)
+	}
+	if request.PubKey != uploader {
+		return invalid("zap request not signed by the uploader")
+	}
+	if err := checkEvent(request); err != nil {
+		return invalid("invalid zap request: %v", err)
+	}
+	if p := request.Tags.Find("p"); p == nil || p[1] != z.recipient {
+		return invalid("zap request recipient must be %s", z.recipient)
+	}
+	if e := request.Tags.Find("e"); e != nil && e[1] != authID {
+		return invalid("zap request references an event other than the authorization")
+	}
+	if a := request.Tags.Find("amount"); a != nil {
+		requested, err := strconv.ParseInt(a[1], 10, 64)
+		if err != nil || requested != invoice.Amount {
+			return invalid("amount of the zap request doesn't match the invoice")
+		}
+	}
+
+	return invoice.Amount, invoice.PaymentHash, nil
+}
+
+// checkEvent verifies the ID and the signature of the event.
+func checkEvent(e *nostr.Event) error {
+	if !e.CheckID() {
+		return errors.New("event ID is not valid")
+	}
+	match, err := e.CheckSignature()
+	if err != nil {
+		return err
+	}
+	if !match {
+		return errors.New("event signature is not valid")
+	}
+	return nil
+}
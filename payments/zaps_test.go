@@ -0,0 +1,163 @@
+package payments
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy/auth"
+	"github.com/pippellia-btc/blossy/blossytest"
+)
+
+type zapper struct {
+	sk, pubkey string
+	recipient  string
+}
+
+func newZapper() zapper {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	recipient, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	return zapper{sk: sk, pubkey: pk, recipient: recipient}
+}
+
+// receipt returns a zap receipt for a zap request signed by the uploader.
+func (z zapper) receipt(t *testing.T, uploaderSK string, amount int64, tags ...nostr.Tag) *nostr.Event {
+	t.Helper()
+
+	request := &nostr.Event{
+		Kind:      nostr.KindZapRequest,
+		CreatedAt: nostr.Now(),
+		Tags:      append(nostr.Tags{{"p", z.recipient}, {"amount", strconv.FormatInt(amount, 10)}}, tags...),
+	}
+	if err := request.Sign(uploaderSK); err != nil {
+		t.Fatal(err)
+	}
+	description, _ := json.Marshal(request)
+
+	preimage := sha256.Sum256([]byte(request.ID))
+	bolt11 := encodeInvoice("lnbc"+formatAmount(amount), time.Now().Unix(), sha256.Sum256(preimage[:]), 600)
+
+	receipt := &nostr.Event{
+		Kind:      nostr.KindZap,
+		CreatedAt: nostr.Now(),
+		Tags: nostr.Tags{
+			{"p", z.recipient},
+			{"bolt11", bolt11},
+			{"description", string(description)},
+		},
+	}
+	if err := receipt.Sign(z.sk); err != nil {
+		t.Fatal(err)
+	}
+	return receipt
+}
+
+func TestZapVerify(t *testing.T) {
+	z := newZapper()
+	verifier, err := NewZapVerifier(NewMemoryLedger(), z.pubkey, z.recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uploaderSK := nostr.GeneratePrivateKey()
+	uploader, _ := nostr.GetPublicKey(uploaderSK)
+
+	receipt := z.receipt(t, uploaderSK, 21_000)
+	amount, err := verifier.Verify(receipt, uploader, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if amount != 21_000 {
+		t.Errorf("expected amount 21000, got %d", amount)
+	}
+
+	forged := *receipt
+	forged.Sign(nostr.GeneratePrivateKey())
+
+	other := z
+	other.recipient = uploader
+
+	tests := []struct {
+		name     string
+		receipt  *nostr.Event
+		uploader string
+		authID   string
+	}{
+		{name: "wrong uploader", receipt: receipt, uploader: z.recipient},
+		{name: "not signed by the zapper", receipt: &forged, uploader: uploader},
+		{name: "wrong recipient", receipt: other.receipt(t, uploaderSK, 21_000), uploader: uploader},
+		{name: "too small", receipt: z.receipt(t, uploaderSK, 100), uploader: uploader},
+		{name: "other event", receipt: z.receipt(t, uploaderSK, 21_000, nostr.Tag{"e", "abc"}), uploader: uploader, authID: "def"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := verifier.Verify(test.receipt, test.uploader, test.authID); !errors.Is(err, errInvalidZap) {
+				t.Errorf("expected an invalid zap, got %v", err)
+			}
+		})
+	}
+}
+
+func TestZapBind(t *testing.T) {
+	server := blossytest.NewTestServer(t)
+	p := New(newFakeInvoicer(), PerMiB(5000))
+	p.Bind(server.Blossy)
+
+	z := newZapper()
+	verifier, err := NewZapVerifier(p.Ledger(), z.pubkey, z.recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier.Bind(server.Blossy)
+
+	sk := nostr.GeneratePrivateKey()
+	signer, err := auth.NewKeySigner(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("hello")
+	hash := blossom.ComputeHash(data)
+
+	upload := func(receipt *nostr.Event) *http.Response {
+		r := server.NewRequest(t, http.MethodPut, "/upload", bytes.NewReader(data))
+		r.Header.Set("Content-Digest", hash.Hex())
+		blossytest.Authorize(t, r, signer, auth.ActionUpload, hash)
+
+		if receipt != nil {
+			raw, _ := json.Marshal(receipt)
+			r.Header.Set("X-Zap-Receipt", base64.StdEncoding.EncodeToString(raw))
+		}
+		return server.Do(t, r)
+	}
+
+	if res := upload(nil); res.StatusCode != http.StatusPaymentRequired {
+		t.Fatalf("expected 402 without a zap, got %d", res.StatusCode)
+	}
+
+	receipt := z.receipt(t, sk, 10_000)
+	if res := upload(receipt); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 with a zap, got %d (%s)", res.StatusCode, res.Header.Get("X-Reason"))
+	}
+	if res := upload(receipt); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 with the remaining credit, got %d (%s)", res.StatusCode, res.Header.Get("X-Reason"))
+	}
+	if res := upload(receipt); res.StatusCode != http.StatusPaymentRequired {
+		t.Fatalf("expected 402 after spending the zap, got %d", res.StatusCode)
+	}
+
+	balance, _ := p.Ledger().Balance(t.Context(), blossytest.Pubkey(t, signer))
+	if balance != 0 {
+		t.Errorf("expected the zap to be credited once, got balance %d", balance)
+	}
+}
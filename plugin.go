@@ -0,0 +1,61 @@
+package blossy
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Plugin is a reusable bundle of hooks and settings (e.g. quotas, payments or moderation),
+// that configures the server in one call with [Server.Install].
+//
+// Plugins can register their Reject and After hooks by name (e.g. with Reject.Upload.Register),
+// so that their order doesn't depend on the order of installation, and they can be removed by name.
+type Plugin interface {
+	// Name identifies the plugin. It must be unique among the plugins installed on a server.
+	Name() string
+
+	// Setup configures the server, for example registering hooks or background tasks.
+	// It's called once by [Server.Install].
+	Setup(s *Server) error
+}
+
+// NewPlugin returns a [Plugin] with the name, whose Setup calls the function.
+func NewPlugin(name string, setup func(s *Server) error) Plugin {
+	return plugin{name: name, setup: setup}
+}
+
+type plugin struct {
+	name  string
+	setup func(s *Server) error
+}
+
+func (p plugin) Name() string          { return p.name }
+func (p plugin) Setup(s *Server) error { return p.setup(s) }
+
+// Install sets up the plugins, in the provided order. It stops at the first plugin that fails,
+// or whose name is empty or already installed, and returns its error.
+//
+// Install is not safe for concurrent use, and it must be called before the server starts serving requests.
+func (s *Server) Install(plugins ...Plugin) error {
+	for _, p := range plugins {
+		name := p.Name()
+		if name == "" {
+			return errors.New("plugin: name is empty")
+		}
+		if slices.Contains(s.plugins, name) {
+			return fmt.Errorf("plugin %q: already installed", name)
+		}
+
+		if err := p.Setup(s); err != nil {
+			return fmt.Errorf("plugin %q: %w", name, err)
+		}
+		s.plugins = append(s.plugins, name)
+	}
+	return nil
+}
+
+// Plugins returns the names of the installed plugins, in order of installation.
+func (s *Server) Plugins() []string {
+	return slices.Clone(s.plugins)
+}
@@ -0,0 +1,182 @@
+package blossy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy/ratelimit"
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+// KeyFunc derives the key used to group requests, for example for rate-limiting purposes.
+type KeyFunc func(r Request) string
+
+// KeyByIP groups requests by their [IP.Group].
+func KeyByIP(r Request) string {
+	return r.IP().Group()
+}
+
+// KeyByPubkey groups authenticated requests by their pubkey, and unauthenticated ones by their [IP.Group].
+func KeyByPubkey(r Request) string {
+	if r.IsAuthed() {
+		return "pubkey:" + r.Pubkey()
+	}
+	return "ip:" + r.IP().Group()
+}
+
+// PubkeyPolicy reports whether the pubkey is allowed to use an endpoint.
+// It's called with an empty pubkey for unauthenticated requests.
+// It must be safe for concurrent use.
+type PubkeyPolicy func(pubkey string) bool
+
+// pubkeyRule applies a pubkey policy to the requests of some endpoints.
+type pubkeyRule struct {
+	policy    PubkeyPolicy
+	endpoints []Endpoint
+
+	// pubkeys of the static lists, kept for validation.
+	pubkeys []string
+}
+
+// restrictedEndpoints are the endpoints pubkey policies apply to by default:
+// the ones that store or delete blobs, and list them.
+func restrictedEndpoints() []Endpoint {
+	return []Endpoint{EndpointUpload, EndpointMedia, EndpointMirror, EndpointDelete, EndpointList}
+}
+
+// rateLimit applies a limiter to the requests of some endpoints.
+type rateLimit struct {
+	limiter   *ratelimit.Limiter
+	key       KeyFunc
+	endpoints []Endpoint
+}
+
+// checkPolicy enforces the built-in policies configured with options on the request,
+// before any of the Reject hooks is invoked.
+// It might set response headers, such as 'Retry-After', to accompany the returned error.
+func (s *Server) checkPolicy(w http.ResponseWriter, e Endpoint, r Request) *blossom.Error {
+	err := s.enforcePolicy(w, e, r)
+	if err != nil {
+		s.observeRejection(e, err)
+	}
+	return err
+}
+
+func (s *Server) enforcePolicy(w http.ResponseWriter, e Endpoint, r Request) *blossom.Error {
+	if f := s.settings.Policy.firewall; f != nil && f.appliesTo(e) {
+		if err := s.checkIP(w, f, r.IP()); err != nil {
+			return err
+		}
+	}
+
+	if !r.IsAuthed() && slices.Contains(s.settings.Policy.requiredAuth, e) {
+		return blossom.ErrUnauthorized("authorization is required")
+	}
+
+	live := s.live.Load()
+	for _, rule := range live.pubkeyRules {
+		if !slices.Contains(rule.endpoints, e) || rule.policy(r.Pubkey()) {
+			continue
+		}
+		if !r.IsAuthed() {
+			return blossom.ErrUnauthorized("authorization is required")
+		}
+		return blossom.ErrForbidden("pubkey is not allowed")
+	}
+
+	for _, rl := range live.rateLimits {
+		if !slices.Contains(rl.endpoints, e) {
+			continue
+		}
+
+		allowed, wait := rl.limiter.Allow(rl.key(r))
+		if !allowed {
+			seconds := int64(wait.Round(time.Second) / time.Second)
+			w.Header().Set("Retry-After", strconv.FormatInt(max(seconds, 1), 10))
+			return ErrRateLimited("rate limit exceeded, slow down")
+		}
+	}
+	return nil
+}
+
+// checkUpload enforces the built-in upload policies configured with options on the hints of an upload,
+// before any of the Reject hooks is invoked.
+// For PUT requests, the body must be provided, and the returned reader must be read instead of it,
+// as its first bytes might have been consumed to detect the type of the blob. For HEAD requests, the body is nil.
+func (s *Server) checkUpload(e Endpoint, hints UploadHints, body io.Reader) (io.Reader, *blossom.Error) {
+	body, err := s.enforceUpload(hints, body)
+	if err != nil {
+		s.observeRejection(e, err)
+	}
+	return body, err
+}
+
+func (s *Server) enforceUpload(hints UploadHints, body io.Reader) (io.Reader, *blossom.Error) {
+	if max := s.settings.Upload.maxSize; max > 0 && hints.Size > max {
+		return nil, s.errTooLarge()
+	}
+
+	live := s.live.Load()
+	if hints.Type != "" {
+		if err := live.checkType(hints.Type); err != nil {
+			return nil, err
+		}
+	}
+
+	if body == nil || !live.sniff(hints) {
+		return body, nil
+	}
+
+	buffered := bufio.NewReaderSize(body, 512)
+	head, _ := buffered.Peek(512) // read errors are left to the hook
+	if len(head) == 0 {
+		return buffered, nil
+	}
+
+	sniffed := http.DetectContentType(head)
+	if hints.Type != "" {
+		// the declared type is allowed, the sniffed one is only checked against the blocked types
+		if blocked(live.blockedTypes, sniffed) {
+			return nil, errUnsupportedType(sniffed)
+		}
+		return buffered, nil
+	}
+
+	if err := live.checkType(sniffed); err != nil {
+		return nil, err
+	}
+	return buffered, nil
+}
+
+// checkType returns an error if the content type is not allowed or it is blocked.
+func (l *reloadable) checkType(contentType string) *blossom.Error {
+	allowed := l.allowedTypes
+	if len(allowed) > 0 && !slices.ContainsFunc(allowed, func(p string) bool { return utils.MatchMediaType(p, contentType) }) {
+		return errUnsupportedType(contentType)
+	}
+	if blocked(l.blockedTypes, contentType) {
+		return errUnsupportedType(contentType)
+	}
+	return nil
+}
+
+func blocked(patterns []string, contentType string) bool {
+	return slices.ContainsFunc(patterns, func(p string) bool { return utils.MatchMediaType(p, contentType) })
+}
+
+func errUnsupportedType(contentType string) *blossom.Error {
+	if mediaType := utils.MediaType(contentType); mediaType != "" {
+		contentType = mediaType
+	}
+	return blossom.ErrUnsupportedMedia(fmt.Sprintf("content type %q is not allowed", contentType))
+}
+
+func (s *Server) errTooLarge() *blossom.Error {
+	return blossom.ErrTooLarge(fmt.Sprintf("the blob exceeds the maximum upload size of %d bytes", s.settings.Upload.maxSize))
+}
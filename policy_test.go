@@ -0,0 +1,199 @@
+package blossy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy/auth"
+)
+
+// storeUploads sets an Upload hook that reads the whole body, and returns a function reporting
+// how many blobs have been read successfully.
+func storeUploads(server *Server) func() int {
+	var stored int
+	server.On.Upload = func(r Request, hints UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+		b, err := io.ReadAll(data)
+		if err != nil {
+			return blossom.BlobDescriptor{}, blossom.ErrBadRequest(err.Error())
+		}
+		stored++
+		return blossom.BlobDescriptor{Hash: blossom.ComputeHash(b), Size: int64(len(b)), Type: hints.Type}, nil
+	}
+	return func() int { return stored }
+}
+
+func TestMaxUploadSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		streamed bool // without a 'Content-Length' header
+		status   int
+	}{
+		{"declared within", "0123456789", false, http.StatusOK},
+		{"declared over", "0123456789abcdef", false, http.StatusRequestEntityTooLarge},
+		{"streamed within", "0123456789", true, http.StatusOK},
+		{"streamed over", "0123456789abcdef", true, http.StatusRequestEntityTooLarge},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			server, ts := newTestServer(t, WithMaxUploadSize(10))
+			stored := storeUploads(server)
+
+			var body io.Reader = strings.NewReader(test.body)
+			if test.streamed {
+				body = io.MultiReader(body)
+			}
+			r, _ := http.NewRequest(http.MethodPut, ts.URL+"/upload", body)
+			authorize(t, r, nostr.GeneratePrivateKey(), auth.ActionUpload)
+
+			res := do(t, r)
+			if res.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d (%s)", test.status, res.StatusCode, res.Header.Get("X-Reason"))
+			}
+			if accepted := test.status == http.StatusOK; (stored() == 1) != accepted {
+				t.Errorf("expected the blob to be stored only if accepted, got %d blobs", stored())
+			}
+		})
+	}
+}
+
+func TestUploadTypes(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n" + strings.Repeat("\x00", 32)
+	html := "<!DOCTYPE html><html><body>hello</body></html>"
+
+	allowImages := WithAllowedTypes([]string{"image/*"})
+	blockHTML := WithBlockedTypes([]string{"text/html"})
+
+	tests := []struct {
+		name   string
+		option Option
+		body   string
+		mime   string
+		status int
+	}{
+		{"allowed", allowImages, png, "image/png", http.StatusOK},
+		{"not allowed", allowImages, "hello", "text/plain", http.StatusUnsupportedMediaType},
+		{"not allowed sniffed", allowImages, "hello", "", http.StatusUnsupportedMediaType},
+		{"blocked", blockHTML, html, "text/html", http.StatusUnsupportedMediaType},
+		{"blocked disguised", blockHTML, html, "image/png", http.StatusUnsupportedMediaType},
+		{"not blocked", blockHTML, png, "image/png", http.StatusOK},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			server, ts := newTestServer(t, test.option)
+			stored := storeUploads(server)
+
+			r, _ := http.NewRequest(http.MethodPut, ts.URL+"/upload", strings.NewReader(test.body))
+			if test.mime != "" {
+				r.Header.Set("Content-Type", test.mime)
+			}
+			authorize(t, r, nostr.GeneratePrivateKey(), auth.ActionUpload)
+
+			res := do(t, r)
+			if res.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d (%s)", test.status, res.StatusCode, res.Header.Get("X-Reason"))
+			}
+			if accepted := test.status == http.StatusOK; (stored() == 1) != accepted {
+				t.Errorf("expected the blob to be stored only if accepted, got %d blobs", stored())
+			}
+		})
+	}
+}
+
+func TestRequiredAuth(t *testing.T) {
+	data := []byte("hello")
+	hash := blossom.ComputeHash(data)
+
+	tests := []struct {
+		name     string
+		required []Endpoint
+		method   string
+		authed   bool
+		status   int
+	}{
+		{"download without auth", []Endpoint{EndpointDownload}, http.MethodGet, false, http.StatusUnauthorized},
+		{"download with auth", []Endpoint{EndpointDownload}, http.MethodGet, true, http.StatusOK},
+		{"other endpoint", []Endpoint{EndpointUpload}, http.MethodGet, false, http.StatusOK},
+		{"all endpoints", nil, http.MethodGet, false, http.StatusUnauthorized},
+		{"upload without auth", []Endpoint{EndpointUpload}, http.MethodPut, false, http.StatusUnauthorized},
+		{"upload with auth", []Endpoint{EndpointUpload}, http.MethodPut, true, http.StatusOK},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			server, ts := newTestServer(t, WithRequiredAuth(test.required...))
+			server.On.Download = func(r Request, hash blossom.Hash, ext string) (BlobDelivery, *blossom.Error) {
+				return Serve(blossom.BlobFromBytes(data)), nil
+			}
+			storeUploads(server)
+
+			r, _ := http.NewRequest(http.MethodGet, ts.URL+"/"+hash.Hex(), nil)
+			if test.method == http.MethodPut {
+				r, _ = http.NewRequest(http.MethodPut, ts.URL+"/upload", strings.NewReader("hello"))
+			}
+
+			if test.authed && test.method == http.MethodPut {
+				authorize(t, r, nostr.GeneratePrivateKey(), auth.ActionUpload)
+			}
+			if test.authed && test.method == http.MethodGet {
+				authorize(t, r, nostr.GeneratePrivateKey(), auth.ActionGet, hash)
+			}
+
+			res := do(t, r)
+			if res.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d (%s)", test.status, res.StatusCode, res.Header.Get("X-Reason"))
+			}
+		})
+	}
+}
+
+func TestPubkeyRules(t *testing.T) {
+	alice, bob := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	alicePK, _ := nostr.GetPublicKey(alice)
+
+	onlyAlice := WithAllowedPubkeys([]string{alicePK})
+	notAlice := WithDeniedPubkeys([]string{alicePK})
+	dynamic := WithPubkeyPolicy(func(pubkey string) bool { return pubkey == alicePK })
+
+	tests := []struct {
+		name   string
+		option Option
+		signer string // empty for anonymous requests
+		status int
+	}{
+		{"allowed", onlyAlice, alice, http.StatusOK},
+		{"not allowed", onlyAlice, bob, http.StatusForbidden},
+		{"not allowed anonymous", onlyAlice, "", http.StatusUnauthorized},
+		{"not denied", notAlice, bob, http.StatusOK},
+		{"denied", notAlice, alice, http.StatusForbidden},
+		{"allowed by policy", dynamic, alice, http.StatusOK},
+		{"denied by policy", dynamic, bob, http.StatusForbidden},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			server, ts := newTestServer(t, test.option)
+			stored := storeUploads(server)
+
+			r, _ := http.NewRequest(http.MethodPut, ts.URL+"/upload", strings.NewReader("hello"))
+			if test.signer != "" {
+				authorize(t, r, test.signer, auth.ActionUpload)
+			}
+
+			res := do(t, r)
+			if res.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d (%s)", test.status, res.StatusCode, res.Header.Get("X-Reason"))
+			}
+			if accepted := test.status == http.StatusOK; (stored() == 1) != accepted {
+				t.Errorf("expected the blob to be stored only if accepted, got %d blobs", stored())
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package blossy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy/strip"
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+// Transformer transforms the blobs uploaded with PUT /media before they are passed to the Media hook,
+// for example by resizing images, stripping their metadata or re-encoding them to another format (BUD-05).
+// See [WithMediaProcessor].
+type Transformer interface {
+	// Transform reads the uploaded blob from data, and returns the transformed blob with the hints describing it.
+	// The returned hints should set the Type, while the Hash and the Size can be unknown (nil and -1).
+	// The returned blob is passed to the Media hook, and it's closed by the server afterwards.
+	//
+	// Return a [*blossom.Error] to respond with its status code (e.g. 400 for invalid images),
+	// other errors are reported as 500 (Internal Server Error).
+	Transform(ctx context.Context, hints UploadHints, data io.Reader) (io.ReadCloser, UploadHints, error)
+}
+
+// TransformerFunc is an adapter to use ordinary functions as a [Transformer].
+type TransformerFunc func(ctx context.Context, hints UploadHints, data io.Reader) (io.ReadCloser, UploadHints, error)
+
+func (f TransformerFunc) Transform(ctx context.Context, hints UploadHints, data io.Reader) (io.ReadCloser, UploadHints, error) {
+	return f(ctx, hints, data)
+}
+
+type mediaProcessor struct {
+	transformer Transformer
+
+	// types are the patterns of the content types of the blobs to transform.
+	types []string
+}
+
+// applies returns whether the processor applies to a blob with the provided content type.
+func (p *mediaProcessor) applies(contentType string) bool {
+	return slices.ContainsFunc(p.types, func(pattern string) bool { return utils.MatchMediaType(pattern, contentType) })
+}
+
+// callMedia invokes the Media hook, transforming the blob first if a media processor is configured
+// and it applies to the blob (see [WithMediaProcessor]). It reports whether the blob was transformed.
+func (s *Server) callMedia(r Request, hints UploadHints, data io.Reader) (blossom.BlobDescriptor, bool, *blossom.Error) {
+	processor := s.settings.Upload.processor
+	if processor == nil {
+		desc, err := s.On.Media(r, hints, data)
+		return desc, false, err
+	}
+
+	contentType := hints.Type
+	if contentType == "" {
+		buffered := bufio.NewReaderSize(data, 512)
+		head, _ := buffered.Peek(512) // read errors are left to the transformer
+		contentType = http.DetectContentType(head)
+		data = buffered
+	}
+
+	if !processor.applies(contentType) {
+		desc, err := s.On.Media(r, hints, data)
+		return desc, false, err
+	}
+
+	blob, transformed, err := processor.transformer.Transform(r.Context(), hints, data)
+	if err != nil {
+		var berr *blossom.Error
+		if errors.As(err, &berr) {
+			return blossom.BlobDescriptor{}, false, berr
+		}
+
+		s.logger(r.Raw()).Error("handle media: failed to transform the blob", "error", err)
+		return blossom.BlobDescriptor{}, false, blossom.ErrInternal("failed to process the media")
+	}
+	defer blob.Close()
+
+	desc, berr := s.On.Media(r, transformed, blob)
+	return desc, true, berr
+}
+
+// stripMetadata removes the metadata of the images uploaded to the endpoint, if enabled with [WithMetadataStripping].
+// It returns the blob to pass to the hook with the hints describing it, and whether the blob is stripped.
+// The hash and the size of a stripped blob are unknown, as they differ from the uploaded ones.
+func (s *Server) stripMetadata(e Endpoint, hints UploadHints, data io.Reader) (io.Reader, UploadHints, bool) {
+	if !slices.Contains(s.settings.Upload.strip, e) {
+		return data, hints, false
+	}
+
+	buffered := bufio.NewReaderSize(data, 512)
+	head, _ := buffered.Peek(strip.HeaderLen) // read errors are left to the hook
+	if !strip.Supported(head) {
+		return buffered, hints, false
+	}
+
+	hints.Hash = nil
+	hints.Size = -1
+	return strip.NewReader(buffered), hints, true
+}
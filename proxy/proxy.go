@@ -0,0 +1,485 @@
+// Package proxy implements the hooks of a blossy server against one or more upstream blossom servers,
+// turning blossy into a blossom edge in front of them.
+//
+//   - GET /<sha256> streams the blob from the first upstream that has it. Cache hot blobs with [blossy.WithBlobCache].
+//   - HEAD /<sha256> asks all the upstreams in parallel, and responds with the first that has the blob.
+//   - PUT /upload and PUT /mirror fan out to all the upstreams, streaming the blob to them in parallel.
+//   - PUT /media is forwarded to the first upstream, as servers optimize media differently.
+//   - DELETE /<sha256> fans out to all the upstreams.
+//   - GET /list/<pubkey> is forwarded to the first upstream that responds.
+//
+// The 'Authorization' header of the client is forwarded to the upstreams, so they must accept authorization
+// events meant for the proxy (e.g. without 'server' tags restricting them to other domains).
+//
+// For servers that keep their own blobs and only fetch the missing ones from others, use a [Resolver] instead.
+//
+// Example:
+//
+//	p, err := proxy.New([]string{"https://blossom.primal.net", "https://cdn.satellite.earth"})
+//	if err != nil {
+//	    panic(err)
+//	}
+//
+//	server, err := blossy.NewServer(blossy.WithHostname("edge.example.com"))
+//	if err != nil {
+//	    panic(err)
+//	}
+//	p.Bind(server)
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+)
+
+// Proxy forwards the requests of a blossy server to upstream blossom servers. Create one with [New].
+// It's safe for concurrent use.
+type Proxy struct {
+	upstreams  []*url.URL
+	client     *http.Client
+	minUploads int
+}
+
+type Option func(*Proxy)
+
+// WithHTTPClient sets the http client used to reach the upstreams.
+// By default, it's a client without timeouts, as blob transfers can be long: they are bounded
+// by the context of the requests instead.
+func WithHTTPClient(c *http.Client) Option {
+	return func(p *Proxy) {
+		p.client = c
+	}
+}
+
+// WithMinUploads sets the minimum number of upstreams that must store an uploaded or mirrored blob
+// for the upload to succeed. By default, it's 1.
+func WithMinUploads(n int) Option {
+	return func(p *Proxy) {
+		p.minUploads = n
+	}
+}
+
+// New returns a [Proxy] to the upstream blossom servers, in order of preference.
+func New(upstreams []string, opts ...Option) (*Proxy, error) {
+	if len(upstreams) == 0 {
+		return nil, errors.New("proxy: at least one upstream is required")
+	}
+
+	p := &Proxy{
+		client:     &http.Client{},
+		minUploads: 1,
+	}
+
+	for _, upstream := range upstreams {
+		u, err := url.Parse(strings.TrimSuffix(upstream, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("proxy: invalid upstream %q: %w", upstream, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("proxy: invalid upstream %q: must be an http or https URL", upstream)
+		}
+		p.upstreams = append(p.upstreams, u)
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.client == nil {
+		return nil, errors.New("proxy: http client must not be nil")
+	}
+	if p.minUploads < 1 || p.minUploads > len(p.upstreams) {
+		return nil, fmt.Errorf("proxy: min uploads must be between 1 and the number of upstreams (%d)", len(p.upstreams))
+	}
+	return p, nil
+}
+
+// Bind sets the On hooks of the server for the Download, Check, Upload, Mirror, Media, Delete and List endpoints.
+// The Reject hooks are left untouched, and the On hooks can still be overwritten after this call.
+func (p *Proxy) Bind(s *blossy.Server) {
+	s.On.Download = p.Download
+	s.On.Check = p.Check
+	s.On.Upload = p.Upload
+	s.On.Mirror = p.Mirror
+	s.On.Media = p.Media
+	s.On.Delete = p.Delete
+	s.On.List = p.List
+}
+
+// Download streams the blob from the first upstream that has it, trying them in order.
+func (p *Proxy) Download(r blossy.Request, hash blossom.Hash, ext string) (blossy.BlobDelivery, *blossom.Error) {
+	var last *blossom.Error
+	for _, upstream := range p.upstreams {
+		req, err := p.newRequest(r, http.MethodGet, upstream, "/"+hash.Hex(), nil)
+		if err != nil {
+			return nil, blossom.ErrInternal(err.Error())
+		}
+
+		res, err := p.client.Do(req)
+		if err != nil {
+			last = errUpstream(err)
+			continue
+		}
+
+		if res.StatusCode != http.StatusOK {
+			last = upstreamError(res)
+			res.Body.Close()
+			continue
+		}
+
+		return blossy.Serve(&remoteBlob{
+			ReadCloser: res.Body,
+			mime:       res.Header.Get("Content-Type"),
+			size:       res.ContentLength,
+		}), nil
+	}
+	return nil, last
+}
+
+// Check asks all the upstreams in parallel whether they have the blob, and returns the first positive answer.
+func (p *Proxy) Check(r blossy.Request, hash blossom.Hash, ext string) (blossy.MetaDelivery, *blossom.Error) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	type result struct {
+		mime string
+		size int64
+		err  *blossom.Error
+	}
+
+	results := make(chan result, len(p.upstreams))
+	for _, upstream := range p.upstreams {
+		go func() {
+			req, err := p.newRequest(r, http.MethodHead, upstream, "/"+hash.Hex(), nil)
+			if err != nil {
+				results <- result{err: blossom.ErrInternal(err.Error())}
+				return
+			}
+			req = req.WithContext(ctx)
+
+			res, err := p.client.Do(req)
+			if err != nil {
+				results <- result{err: errUpstream(err)}
+				return
+			}
+			res.Body.Close()
+
+			if res.StatusCode != http.StatusOK {
+				results <- result{err: upstreamError(res)}
+				return
+			}
+			results <- result{mime: res.Header.Get("Content-Type"), size: res.ContentLength}
+		}()
+	}
+
+	var failure *blossom.Error
+	for range p.upstreams {
+		res := <-results
+		if res.err == nil {
+			return blossy.Found(res.mime, res.size), nil
+		}
+		if failure == nil || res.err.Code == http.StatusNotFound {
+			// not found is more informative than unreachable upstreams
+			failure = res.err
+		}
+	}
+	return nil, failure
+}
+
+// Upload streams the blob to all the upstreams in parallel.
+// It succeeds if at least the minimum number of upstreams stored it (see [WithMinUploads]),
+// returning the descriptor of the preferred one with an empty URL, so that the server derives its own.
+func (p *Proxy) Upload(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+	return p.fanOut(r, "/upload", hints, data)
+}
+
+// Mirror asks all the upstreams in parallel to mirror the blob at the URL.
+// It succeeds if at least the minimum number of upstreams stored it (see [WithMinUploads]),
+// returning the descriptor of the preferred one with an empty URL, so that the server derives its own.
+func (p *Proxy) Mirror(r blossy.Request, u *url.URL) (blossom.BlobDescriptor, *blossom.Error) {
+	body, err := json.Marshal(map[string]string{"url": u.String()})
+	if err != nil {
+		return blossom.BlobDescriptor{}, blossom.ErrInternal(err.Error())
+	}
+
+	hints := blossy.UploadHints{Type: "application/json", Size: int64(len(body))}
+	return p.fanOut(r, "/mirror", hints, bytes.NewReader(body))
+}
+
+// Media forwards the blob to the first upstream, returning its descriptor with an empty URL,
+// so that the server derives its own.
+func (p *Proxy) Media(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+	desc, err := p.put(r, p.upstreams[0], "/media", hints, data)
+	if err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+	desc.URL = ""
+	return desc, nil
+}
+
+// Delete deletes the blob from all the upstreams in parallel.
+// It succeeds if at least one upstream deleted the blob.
+func (p *Proxy) Delete(r blossy.Request, hash blossom.Hash) *blossom.Error {
+	errs := make(chan *blossom.Error, len(p.upstreams))
+	for _, upstream := range p.upstreams {
+		go func() {
+			req, err := p.newRequest(r, http.MethodDelete, upstream, "/"+hash.Hex(), nil)
+			if err != nil {
+				errs <- blossom.ErrInternal(err.Error())
+				return
+			}
+
+			res, err := p.client.Do(req)
+			if err != nil {
+				errs <- errUpstream(err)
+				return
+			}
+			defer res.Body.Close()
+
+			if res.StatusCode >= 300 {
+				errs <- upstreamError(res)
+				return
+			}
+			errs <- nil
+		}()
+	}
+
+	var first *blossom.Error
+	deleted := false
+	for range p.upstreams {
+		if err := <-errs; err == nil {
+			deleted = true
+		} else if first == nil {
+			first = err
+		}
+	}
+
+	if deleted {
+		return nil
+	}
+	return first
+}
+
+// List returns the blobs of the pubkey from the first upstream that responds, trying them in order.
+// The URLs of the descriptors are cleared, so that the server derives its own.
+func (p *Proxy) List(r blossy.Request, pubkey string, query blossy.ListQuery) ([]blossom.BlobDescriptor, *blossom.Error) {
+	params := query.Params()
+	path := "/list/" + pubkey
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+
+	var last *blossom.Error
+	for _, upstream := range p.upstreams {
+		req, err := p.newRequest(r, http.MethodGet, upstream, path, nil)
+		if err != nil {
+			return nil, blossom.ErrInternal(err.Error())
+		}
+
+		res, err := p.client.Do(req)
+		if err != nil {
+			last = errUpstream(err)
+			continue
+		}
+
+		if res.StatusCode != http.StatusOK {
+			last = upstreamError(res)
+			res.Body.Close()
+			continue
+		}
+
+		var descs []blossom.BlobDescriptor
+		err = json.NewDecoder(res.Body).Decode(&descs)
+		res.Body.Close()
+		if err != nil {
+			last = errUpstream(fmt.Errorf("invalid list response: %w", err))
+			continue
+		}
+
+		for i := range descs {
+			descs[i].URL = ""
+		}
+		return descs, nil
+	}
+	return nil, last
+}
+
+// fanOut streams the body to the path of all the upstreams in parallel with PUT requests.
+func (p *Proxy) fanOut(r blossy.Request, path string, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+	type result struct {
+		desc blossom.BlobDescriptor
+		err  *blossom.Error
+	}
+
+	results := make([]chan result, len(p.upstreams))
+	writers := make([]*io.PipeWriter, len(p.upstreams))
+
+	for i, upstream := range p.upstreams {
+		pr, pw := io.Pipe()
+		writers[i] = pw
+		results[i] = make(chan result, 1)
+
+		go func() {
+			desc, err := p.put(r, upstream, path, hints, pr)
+			// unblock the writes if the upstream didn't read the whole body
+			pr.CloseWithError(errUpstreamDone)
+			results[i] <- result{desc: desc, err: err}
+		}()
+	}
+
+	if err := broadcast(data, writers); err != nil {
+		for _, pw := range writers {
+			pw.CloseWithError(err)
+		}
+	} else {
+		for _, pw := range writers {
+			pw.Close()
+		}
+	}
+
+	var best *result
+	var first *blossom.Error
+	stored := 0
+
+	for i := range p.upstreams {
+		res := <-results[i]
+		if res.err != nil {
+			if first == nil {
+				first = res.err
+			}
+			continue
+		}
+
+		stored++
+		if best == nil {
+			best = &res
+		}
+	}
+
+	if stored == 0 {
+		return blossom.BlobDescriptor{}, first
+	}
+	if stored < p.minUploads {
+		return blossom.BlobDescriptor{}, errUpstream(fmt.Errorf("the blob was stored by %d upstreams, less than the minimum of %d", stored, p.minUploads))
+	}
+
+	best.desc.URL = ""
+	return best.desc, nil
+}
+
+var errUpstreamDone = errors.New("proxy: upstream request is done")
+
+// broadcast copies the data to all the writers, until the data ends or all the writers fail.
+// It returns the error reading the data, if any.
+func broadcast(data io.Reader, writers []*io.PipeWriter) error {
+	alive := make([]bool, len(writers))
+	for i := range alive {
+		alive[i] = true
+	}
+	remaining := len(writers)
+
+	buf := make([]byte, 32*1024)
+	for remaining > 0 {
+		n, err := data.Read(buf)
+		for i, w := range writers {
+			if n == 0 || !alive[i] {
+				continue
+			}
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				alive[i] = false
+				remaining--
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// put sends the body to the path of the upstream with a PUT request, and returns the blob descriptor.
+func (p *Proxy) put(r blossy.Request, upstream *url.URL, path string, hints blossy.UploadHints, body io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+	req, err := p.newRequest(r, http.MethodPut, upstream, path, body)
+	if err != nil {
+		return blossom.BlobDescriptor{}, blossom.ErrInternal(err.Error())
+	}
+
+	if hints.Type != "" {
+		req.Header.Set("Content-Type", hints.Type)
+	}
+	if hints.Size >= 0 {
+		req.ContentLength = hints.Size
+	}
+	if hints.Hash != nil {
+		req.Header.Set("Content-Digest", hints.Hash.Hex())
+		req.Header.Set("X-SHA-256", hints.Hash.Hex())
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return blossom.BlobDescriptor{}, errUpstream(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		return blossom.BlobDescriptor{}, upstreamError(res)
+	}
+
+	var desc blossom.BlobDescriptor
+	if err := json.NewDecoder(res.Body).Decode(&desc); err != nil {
+		return blossom.BlobDescriptor{}, errUpstream(fmt.Errorf("invalid blob descriptor: %w", err))
+	}
+	return desc, nil
+}
+
+// newRequest returns a request to the path of the upstream, forwarding the authorization of the client request.
+func (p *Proxy) newRequest(r blossy.Request, method string, upstream *url.URL, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(r.Context(), method, upstream.String()+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("proxy: %w", err)
+	}
+
+	if auth := r.Raw().Header.Get("Authorization"); auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	return req, nil
+}
+
+// upstreamError converts the error response of an upstream into a [blossom.Error],
+// keeping its status code and reason.
+func upstreamError(res *http.Response) *blossom.Error {
+	reason := res.Header.Get("X-Reason")
+	if reason == "" {
+		reason = "upstream responded with " + res.Status
+	}
+	return &blossom.Error{Code: res.StatusCode, Reason: reason}
+}
+
+// errUpstream returns a 502 (Bad Gateway) error, for upstreams that can't be reached or respond with invalid data.
+func errUpstream(err error) *blossom.Error {
+	return &blossom.Error{Code: http.StatusBadGateway, Reason: "proxy: " + err.Error()}
+}
+
+// remoteBlob is a blob streamed from an upstream.
+type remoteBlob struct {
+	io.ReadCloser
+	mime string
+	size int64
+}
+
+func (b *remoteBlob) Type() string { return b.mime }
+func (b *remoteBlob) Size() int64  { return b.size }
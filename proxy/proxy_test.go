@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+)
+
+// upstream is a minimal blossom server keeping blobs in memory.
+type upstream struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	blobs map[string][]byte
+	auth  []string
+	fail  bool
+}
+
+func newUpstream(t *testing.T) *upstream {
+	u := &upstream{blobs: make(map[string][]byte)}
+	u.Server = httptest.NewServer(http.HandlerFunc(u.handle))
+	t.Cleanup(u.Close)
+	return u
+}
+
+func (u *upstream) handle(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.auth = append(u.auth, r.Header.Get("Authorization"))
+
+	if u.fail {
+		w.Header().Set("X-Reason", "upstream is broken")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	hash := strings.TrimPrefix(r.URL.Path, "/")
+	switch {
+	case r.Method == http.MethodPut && r.URL.Path == "/upload":
+		data, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		u.blobs[hash] = data
+		json.NewEncoder(w).Encode(map[string]any{
+			"url":    u.URL + "/" + hash,
+			"sha256": hash,
+			"size":   len(data),
+			"type":   r.Header.Get("Content-Type"),
+		})
+
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/list/"):
+		w.Write([]byte(`[{"url":"` + u.URL + `/x","sha256":"` + strings.Repeat("a", 64) + `","size":1,"type":"text/plain","uploaded":1}]`))
+
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := u.blobs[hash]
+		if !ok {
+			w.Header().Set("X-Reason", "not found")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(data)
+
+	case r.Method == http.MethodDelete:
+		if _, ok := u.blobs[hash]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(u.blobs, hash)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (u *upstream) has(hash string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	_, ok := u.blobs[hash]
+	return ok
+}
+
+// newRequest returns a [blossy.Request] for calling the hooks directly, with an authorization to forward.
+func newRequest() blossy.Request {
+	return blossy.NewTestRequest(blossy.RequestHeader("Authorization", "Nostr test"))
+}
+
+func hashOf(data string) blossom.Hash {
+	return blossom.Hash(sha256.Sum256([]byte(data)))
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(nil); err == nil {
+		t.Error("expected error for no upstreams, got nil")
+	}
+	if _, err := New([]string{"ftp://example.com"}); err == nil {
+		t.Error("expected error for an invalid upstream, got nil")
+	}
+	if _, err := New([]string{"https://example.com"}, WithMinUploads(2)); err == nil {
+		t.Error("expected error for more min uploads than upstreams, got nil")
+	}
+}
+
+func TestUploadFanOut(t *testing.T) {
+	a, b := newUpstream(t), newUpstream(t)
+	p, err := New([]string{a.URL, b.URL}, WithMinUploads(2))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data := "hello world"
+	hints := blossy.UploadHints{Type: "text/plain", Size: int64(len(data))}
+
+	desc, berr := p.Upload(newRequest(), hints, strings.NewReader(data))
+	if berr != nil {
+		t.Fatalf("unexpected error: %v", berr)
+	}
+	if desc.URL != "" || desc.Size != int64(len(data)) {
+		t.Errorf("expected the descriptor without URL, got %+v", desc)
+	}
+
+	hash := hashOf(data).Hex()
+	if !a.has(hash) || !b.has(hash) {
+		t.Error("expected the blob to be stored by both upstreams")
+	}
+	if a.auth[0] != "Nostr test" {
+		t.Errorf("expected the authorization to be forwarded, got %q", a.auth[0])
+	}
+}
+
+func TestUploadMinUploads(t *testing.T) {
+	a, b := newUpstream(t), newUpstream(t)
+	b.fail = true
+
+	p, _ := New([]string{a.URL, b.URL})
+	if _, err := p.Upload(newRequest(), blossy.UploadHints{Size: -1}, strings.NewReader("hello")); err != nil {
+		t.Fatalf("expected one upload to be enough, got %v", err)
+	}
+
+	p, _ = New([]string{a.URL, b.URL}, WithMinUploads(2))
+	if _, err := p.Upload(newRequest(), blossy.UploadHints{Size: -1}, strings.NewReader("hello")); err == nil || err.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %v", err)
+	}
+
+	a.fail = true
+	if _, err := p.Upload(newRequest(), blossy.UploadHints{Size: -1}, strings.NewReader("hello")); err == nil || err.Reason != "upstream is broken" {
+		t.Fatalf("expected the upstream error, got %v", err)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	a, b := newUpstream(t), newUpstream(t)
+	hash := hashOf("hello world")
+	b.blobs[hash.Hex()] = []byte("hello world")
+
+	p, _ := New([]string{a.URL, b.URL})
+	delivery, err := p.Download(newRequest(), hash, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if delivery == nil {
+		t.Fatal("expected a delivery")
+	}
+
+	meta, err := p.Check(newRequest(), hash, "")
+	if err != nil || meta == nil {
+		t.Fatalf("expected the blob to be found, got %v", err)
+	}
+
+	if _, err := p.Download(newRequest(), hashOf("missing"), ""); err == nil || err.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %v", err)
+	}
+	if _, err := p.Check(newRequest(), hashOf("missing"), ""); err == nil || err.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %v", err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	a, b := newUpstream(t), newUpstream(t)
+	hash := hashOf("hello world")
+	a.blobs[hash.Hex()] = []byte("hello world")
+
+	p, _ := New([]string{a.URL, b.URL})
+	if err := p.Delete(newRequest(), hash); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.has(hash.Hex()) {
+		t.Error("expected the blob to be deleted")
+	}
+	if err := p.Delete(newRequest(), hash); err == nil || err.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	a, b := newUpstream(t), newUpstream(t)
+	a.fail = true
+
+	p, _ := New([]string{a.URL, b.URL})
+	descs, err := p.List(newRequest(), strings.Repeat("b", 64), blossy.ListQuery{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(descs) != 1 || descs[0].URL != "" {
+		t.Errorf("expected one descriptor without URL, got %+v", descs)
+	}
+}
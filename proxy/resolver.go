@@ -0,0 +1,343 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+var (
+	// ErrNotResolved is returned by [Resolver.Resolve] when none of the healthy servers has the blob.
+	ErrNotResolved = errors.New("proxy: no server has the blob")
+
+	// ErrNoHealthyServers is returned by [Resolver.Resolve] when all the servers are considered unhealthy.
+	ErrNoHealthyServers = errors.New("proxy: no healthy server")
+)
+
+// Resolver finds which of a list of known blossom servers has a blob, by probing them with HEAD requests
+// in parallel. It returns the URL of the blob on the server that responded first, which is then
+// typically downloaded with [blossy.MirrorFetch]. Create one with [NewResolver].
+//
+// Servers that fail repeatedly (network errors or 5xx responses) are skipped for a cooldown period,
+// and hashes that no server has are remembered for a while, so that missing blobs don't cause a storm of probes.
+// It's safe for concurrent use.
+//
+// Example of a Download hook that fetches missing blobs from other servers:
+//
+//	server.On.Download = func(r blossy.Request, hash blossom.Hash, ext string) (blossy.BlobDelivery, *blossom.Error) {
+//	    if blob, err := store.Get(r.Context(), hash); err == nil {
+//	        return blossy.Serve(blob), nil
+//	    }
+//
+//	    url, err := resolver.Resolve(r.Context(), hash)
+//	    if err != nil {
+//	        return nil, blossom.ErrNotFound("blob not found")
+//	    }
+//	    return blossy.Redirect(url.String(), http.StatusFound), nil
+//	}
+type Resolver struct {
+	servers []*url.URL
+	client  *http.Client
+
+	budget      time.Duration
+	parallelism int
+	negativeTTL time.Duration
+	maxFailures int
+	cooldown    time.Duration
+
+	mu     sync.Mutex
+	health []health
+	misses map[blossom.Hash]time.Time // when the negative result expires
+}
+
+// health tracks the recent failures of a server.
+type health struct {
+	failures int       // consecutive failures
+	until    time.Time // the server is skipped until this time
+}
+
+// maxMisses is the maximum number of negative results cached by a [Resolver].
+const maxMisses = 100_000
+
+type ResolverOption func(*Resolver)
+
+// WithResolverClient sets the http client used to probe the servers.
+// By default, it's a client without timeouts, as probes are bounded by [WithBudget].
+func WithResolverClient(c *http.Client) ResolverOption {
+	return func(r *Resolver) {
+		r.client = c
+	}
+}
+
+// WithBudget sets the maximum time a call to [Resolver.Resolve] spends probing the servers.
+// By default, it's 3 seconds.
+func WithBudget(d time.Duration) ResolverOption {
+	return func(r *Resolver) {
+		r.budget = d
+	}
+}
+
+// WithParallelism sets the maximum number of servers probed at the same time.
+// By default, all the servers are probed at once.
+func WithParallelism(n int) ResolverOption {
+	return func(r *Resolver) {
+		r.parallelism = n
+	}
+}
+
+// WithNegativeTTL sets for how long a hash that no server has is remembered, during which
+// [Resolver.Resolve] returns [ErrNotResolved] without probing. Use 0 to disable negative caching.
+// By default, it's 1 minute.
+func WithNegativeTTL(d time.Duration) ResolverOption {
+	return func(r *Resolver) {
+		r.negativeTTL = d
+	}
+}
+
+// WithHealthCheck sets after how many consecutive failures a server is considered unhealthy,
+// and for how long it's skipped before being probed again. By default, it's 3 failures and 30 seconds.
+func WithHealthCheck(maxFailures int, cooldown time.Duration) ResolverOption {
+	return func(r *Resolver) {
+		r.maxFailures = maxFailures
+		r.cooldown = cooldown
+	}
+}
+
+// NewResolver returns a [Resolver] probing the provided blossom servers.
+func NewResolver(servers []string, opts ...ResolverOption) (*Resolver, error) {
+	if len(servers) == 0 {
+		return nil, errors.New("proxy: at least one server is required")
+	}
+
+	r := &Resolver{
+		client:      &http.Client{},
+		budget:      3 * time.Second,
+		parallelism: len(servers),
+		negativeTTL: time.Minute,
+		maxFailures: 3,
+		cooldown:    30 * time.Second,
+		health:      make([]health, len(servers)),
+		misses:      make(map[blossom.Hash]time.Time),
+	}
+
+	for _, server := range servers {
+		u, err := url.Parse(strings.TrimSuffix(server, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("proxy: invalid server %q: %w", server, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("proxy: invalid server %q: must be an http or https URL", server)
+		}
+		r.servers = append(r.servers, u)
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if r.client == nil {
+		return nil, errors.New("proxy: http client must not be nil")
+	}
+	if r.budget <= 0 {
+		return nil, errors.New("proxy: budget must be positive")
+	}
+	if r.parallelism < 1 {
+		return nil, errors.New("proxy: parallelism must be at least 1")
+	}
+	if r.negativeTTL < 0 {
+		return nil, errors.New("proxy: negative TTL must not be negative")
+	}
+	if r.maxFailures < 1 || r.cooldown <= 0 {
+		return nil, errors.New("proxy: health check requires at least 1 failure and a positive cooldown")
+	}
+	return r, nil
+}
+
+// Resolve returns the URL of the blob on the first healthy server that confirms having it.
+// It returns [ErrNotResolved] if no server has it, [ErrNoHealthyServers] if all the servers are unhealthy,
+// or the error of the context if it's done before any server responded.
+func (r *Resolver) Resolve(ctx context.Context, hash blossom.Hash) (*url.URL, error) {
+	if r.missed(hash) {
+		return nil, ErrNotResolved
+	}
+
+	candidates := r.healthy()
+	if len(candidates) == 0 {
+		return nil, ErrNoHealthyServers
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.budget)
+	defer cancel()
+
+	type result struct {
+		server int
+		found  bool
+		err    error
+	}
+
+	results := make(chan result, len(candidates))
+	slots := make(chan struct{}, r.parallelism)
+
+	go func() {
+		for _, i := range candidates {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				results <- result{server: i, err: ctx.Err()}
+				continue
+			}
+
+			go func() {
+				defer func() { <-slots }()
+				found, err := r.probe(ctx, r.servers[i], hash)
+				results <- result{server: i, found: found, err: err}
+			}()
+		}
+	}()
+
+	unreachable := false
+	for range candidates {
+		res := <-results
+		switch {
+		case res.found:
+			r.record(res.server, nil)
+			return r.servers[res.server].JoinPath(hash.Hex()), nil
+
+		case res.err != nil && ctx.Err() != nil:
+			// probes interrupted by the budget or the caller are not the server's fault
+			unreachable = true
+
+		case res.err != nil:
+			r.record(res.server, res.err)
+			unreachable = true
+
+		default:
+			r.record(res.server, nil)
+		}
+	}
+
+	if err := ctx.Err(); err != nil && unreachable {
+		return nil, fmt.Errorf("proxy: failed to resolve the blob: %w", err)
+	}
+	if !unreachable {
+		// only cache negative results when all the probed servers responded
+		r.miss(hash)
+	}
+	return nil, ErrNotResolved
+}
+
+// probe returns whether the server has the blob. It returns an error only if the server failed.
+func (r *Resolver) probe(ctx context.Context, server *url.URL, hash blossom.Hash) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, server.JoinPath(hash.Hex()).String(), nil)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusOK:
+		return true, nil
+	case res.StatusCode >= 500:
+		return false, fmt.Errorf("server responded with %s", res.Status)
+	default:
+		return false, nil
+	}
+}
+
+// healthy returns the indexes of the servers that are not in cooldown, in order of preference.
+func (r *Resolver) healthy() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	indexes := make([]int, 0, len(r.servers))
+	for i, h := range r.health {
+		if now.Before(h.until) {
+			continue
+		}
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+// record updates the health of the server after a probe.
+func (r *Resolver) record(server int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	h := &r.health[server]
+	if err == nil {
+		h.failures = 0
+		return
+	}
+
+	h.failures++
+	if h.failures >= r.maxFailures {
+		h.failures = 0
+		h.until = time.Now().Add(r.cooldown)
+	}
+}
+
+// Healthy returns the servers that are currently considered healthy, in order of preference.
+func (r *Resolver) Healthy() []string {
+	indexes := r.healthy()
+	servers := make([]string, len(indexes))
+	for i, index := range indexes {
+		servers[i] = r.servers[index].String()
+	}
+	return servers
+}
+
+func (r *Resolver) missed(hash blossom.Hash) bool {
+	if r.negativeTTL == 0 {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	expiry, ok := r.misses[hash]
+	if !ok {
+		return false
+	}
+	if time.Now().After(expiry) {
+		delete(r.misses, hash)
+		return false
+	}
+	return true
+}
+
+func (r *Resolver) miss(hash blossom.Hash) {
+	if r.negativeTTL == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.misses) >= maxMisses {
+		// entries are cheap to recompute, so a full reset is preferable to tracking their order
+		clear(r.misses)
+	}
+	r.misses[hash] = time.Now().Add(r.negativeTTL)
+}
+
+// Forget removes the negative result of the hash, if any, for example after the blob
+// has been announced by a server.
+func (r *Resolver) Forget(hash blossom.Hash) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.misses, hash)
+}
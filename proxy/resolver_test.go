@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func (u *upstream) requests() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.auth)
+}
+
+func TestNewResolver(t *testing.T) {
+	if _, err := NewResolver(nil); err == nil {
+		t.Error("expected error for no servers, got nil")
+	}
+	if _, err := NewResolver([]string{"example.com"}); err == nil {
+		t.Error("expected error for an invalid server, got nil")
+	}
+	if _, err := NewResolver([]string{"https://example.com"}, WithParallelism(0)); err == nil {
+		t.Error("expected error for zero parallelism, got nil")
+	}
+	if _, err := NewResolver([]string{"https://example.com"}, WithHealthCheck(0, time.Second)); err == nil {
+		t.Error("expected error for zero failures, got nil")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	a, b := newUpstream(t), newUpstream(t)
+	hash := hashOf("hello world")
+	b.blobs[hash.Hex()] = []byte("hello world")
+
+	r, err := NewResolver([]string{a.URL, b.URL}, WithParallelism(1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	u, err := r.Resolve(context.Background(), hash)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := b.URL + "/" + hash.Hex(); u.String() != expected {
+		t.Errorf("expected %s, got %s", expected, u)
+	}
+}
+
+func TestResolveNegativeCache(t *testing.T) {
+	a := newUpstream(t)
+	hash := hashOf("missing")
+
+	r, _ := NewResolver([]string{a.URL})
+	for range 3 {
+		if _, err := r.Resolve(context.Background(), hash); !errors.Is(err, ErrNotResolved) {
+			t.Fatalf("expected ErrNotResolved, got %v", err)
+		}
+	}
+	if n := a.requests(); n != 1 {
+		t.Errorf("expected the negative result to be cached, got %d probes", n)
+	}
+
+	r.Forget(hash)
+	r.Resolve(context.Background(), hash)
+	if n := a.requests(); n != 2 {
+		t.Errorf("expected a new probe after Forget, got %d probes", n)
+	}
+}
+
+func TestResolveHealth(t *testing.T) {
+	a := newUpstream(t)
+	a.fail = true
+
+	r, _ := NewResolver([]string{a.URL}, WithHealthCheck(2, time.Hour))
+	hash := hashOf("hello world")
+
+	for range 2 {
+		if _, err := r.Resolve(context.Background(), hash); !errors.Is(err, ErrNotResolved) {
+			t.Fatalf("expected ErrNotResolved, got %v", err)
+		}
+	}
+	if _, err := r.Resolve(context.Background(), hash); !errors.Is(err, ErrNoHealthyServers) {
+		t.Fatalf("expected ErrNoHealthyServers, got %v", err)
+	}
+	if healthy := r.Healthy(); len(healthy) != 0 {
+		t.Errorf("expected no healthy servers, got %v", healthy)
+	}
+}
+
+func TestResolveBudget(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(slow.Close)
+
+	r, _ := NewResolver([]string{slow.URL}, WithBudget(50*time.Millisecond))
+	start := time.Now()
+
+	_, err := r.Resolve(context.Background(), hashOf("hello world"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the budget to be respected, took %v", elapsed)
+	}
+	if healthy := r.Healthy(); len(healthy) != 1 {
+		t.Errorf("expected timeouts to not affect the health, got %v", healthy)
+	}
+}
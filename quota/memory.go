@@ -0,0 +1,71 @@
+package quota
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+// Memory is a [Backend] in memory. Create one with [NewMemory].
+// The usage is lost when the process exits, so it should be rebuilt from the store on startup with [Memory.Add].
+type Memory struct {
+	mu    sync.RWMutex
+	blobs map[string]map[blossom.Hash]int64
+	usage map[string]int64
+}
+
+// NewMemory returns an empty [Memory] backend.
+func NewMemory() *Memory {
+	return &Memory{
+		blobs: make(map[string]map[blossom.Hash]int64),
+		usage: make(map[string]int64),
+	}
+}
+
+func (m *Memory) Usage(ctx context.Context, pubkey string) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.usage[pubkey], nil
+}
+
+func (m *Memory) Add(ctx context.Context, pubkey string, hash blossom.Hash, size int64) error {
+	if size < 0 {
+		return ErrInvalidSize
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	blobs, ok := m.blobs[pubkey]
+	if !ok {
+		blobs = make(map[blossom.Hash]int64)
+		m.blobs[pubkey] = blobs
+	}
+	if _, recorded := blobs[hash]; recorded {
+		return nil
+	}
+
+	blobs[hash] = size
+	m.usage[pubkey] += size
+	return nil
+}
+
+func (m *Memory) Remove(ctx context.Context, pubkey string, hash blossom.Hash) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	blobs := m.blobs[pubkey]
+	size, recorded := blobs[hash]
+	if !recorded {
+		return nil
+	}
+
+	delete(blobs, hash)
+	m.usage[pubkey] -= size
+	if len(blobs) == 0 {
+		delete(m.blobs, pubkey)
+		delete(m.usage, pubkey)
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+// Package quota limits the bytes that each pubkey can store on a blossy server.
+//
+// A [Quota] keeps track of the blobs stored by every pubkey in a [Backend], in memory with [NewMemory]
+// or in a SQL database with [NewSQL]. Uploads that would exceed the limit of the pubkey are rejected
+// with 413 (Content Too Large), with an 'X-Reason' header reporting the remaining space.
+//
+// Blobs are accounted for once per pubkey, so uploading the same blob twice doesn't consume more quota,
+// and blobs shared by several pubkeys are accounted for each of them.
+//
+// Example:
+//
+//	server, err := blossy.NewServer(blossy.WithHostname("cdn.example.com"))
+//	if err != nil {
+//	    panic(err)
+//	}
+//	blossy.BindStore(server, store)
+//
+//	q := quota.New(quota.NewMemory(), 100<<20) // 100 MiB per pubkey
+//	q.Bind(server)
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+)
+
+// ErrInvalidSize is returned by the backends when recording a blob with a negative size.
+var ErrInvalidSize = errors.New("quota: blob size must not be negative")
+
+// Backend persists the blobs stored by each pubkey, and their size.
+// Implementations must be safe for concurrent use.
+type Backend interface {
+	// Usage returns the total size in bytes of the blobs stored by the pubkey.
+	Usage(ctx context.Context, pubkey string) (int64, error)
+
+	// Add records that the pubkey stores the blob with the provided hash and size.
+	// It's a no-op if the blob is already recorded for the pubkey.
+	Add(ctx context.Context, pubkey string, hash blossom.Hash, size int64) error
+
+	// Remove records that the pubkey no longer stores the blob with the provided hash.
+	// It's a no-op if the blob is not recorded for the pubkey.
+	Remove(ctx context.Context, pubkey string, hash blossom.Hash) error
+}
+
+// Quota limits the bytes stored by each pubkey. Create one with [New].
+// It's safe for concurrent use.
+//
+// The limit is not strict: concurrent uploads of the same pubkey are checked against the same usage,
+// so they can exceed it by at most their combined size.
+type Quota struct {
+	backend Backend
+	limit   func(pubkey string) int64
+	log     *slog.Logger
+}
+
+type Option func(*Quota)
+
+// WithLimits sets a function returning the limit in bytes of each pubkey, for example to give
+// paying users more space. It replaces the limit provided to [New]. Return a negative value for no limit.
+func WithLimits(limit func(pubkey string) int64) Option {
+	return func(q *Quota) {
+		q.limit = limit
+	}
+}
+
+// WithLogger sets the logger of the quota, which reports the blobs that failed to be recorded. By default, it's [slog.Default].
+func WithLogger(l *slog.Logger) Option {
+	return func(q *Quota) {
+		q.log = l
+	}
+}
+
+// New returns a [Quota] that allows every pubkey to store up to limit bytes, as recorded by the backend.
+// It panics if the backend is nil or the options are invalid.
+func New(backend Backend, limit int64, opts ...Option) *Quota {
+	if backend == nil {
+		panic("quota.New: backend must not be nil")
+	}
+
+	q := &Quota{
+		backend: backend,
+		limit:   func(string) int64 { return limit },
+		log:     slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	if q.limit == nil {
+		panic("quota.New: limits function must not be nil")
+	}
+	if q.log == nil {
+		panic("quota.New: logger must not be nil")
+	}
+	return q
+}
+
+// Remaining returns the bytes the pubkey can still store, which are negative if the pubkey is over quota,
+// and whether the pubkey has a limit at all.
+func (q *Quota) Remaining(ctx context.Context, pubkey string) (remaining int64, limited bool, err error) {
+	limit := q.limit(pubkey)
+	if limit < 0 {
+		return 0, false, nil
+	}
+
+	usage, err := q.backend.Usage(ctx, pubkey)
+	if err != nil {
+		return 0, true, err
+	}
+	return limit - usage, true, nil
+}
+
+var _ blossy.Plugin = (*Quota)(nil)
+
+// Name returns "quota", the name of the quota as a [blossy.Plugin].
+func (q *Quota) Name() string { return "quota" }
+
+// Setup binds the quota to the server, so that it can be installed as a [blossy.Plugin]. See [Quota.Bind].
+func (q *Quota) Setup(s *blossy.Server) error {
+	q.Bind(s)
+	return nil
+}
+
+// Bind wires the quota into the server:
+//   - PUT /upload and PUT /media (and their HEAD requests) are rejected when the blob would exceed the quota.
+//     Uploads of unknown size are cut off with 413 (Content Too Large) as soon as they exceed the remaining space.
+//   - PUT /mirror is rejected when the pubkey has no space left, as the size of the blob is not known in advance.
+//   - The blobs stored by the Upload, Media and Mirror hooks are recorded once the server accepts them (in the After hooks),
+//     so that the uploads rejected after the hooks (e.g. for a hash mismatch) don't consume quota.
+//   - The blobs deleted by the Delete hook are removed.
+//
+// It must be called after the On hooks are set (e.g. after [blossy.BindStore]), as it wraps them.
+// Uploads, mirrors and deletions require authorization, as the quota is tracked per pubkey.
+func (q *Quota) Bind(s *blossy.Server) {
+	s.Reject.Upload.Append(q.CheckQuota)
+	s.Reject.Media.Append(q.CheckQuota)
+	s.Reject.Mirror.Append(q.CheckMirror)
+
+	if upload := s.On.Upload; upload != nil {
+		s.On.Upload = q.limitUpload(upload)
+	}
+
+	if media := s.On.Media; media != nil {
+		s.On.Media = q.limitUpload(media)
+	}
+
+	if mirror := s.On.Mirror; mirror != nil {
+		s.On.Mirror = func(r blossy.Request, u *url.URL) (blossom.BlobDescriptor, *blossom.Error) {
+			desc, err := mirror(r, u)
+			if err != nil {
+				return desc, err
+			}
+			r.Set(storedKey{}, desc)
+			return desc, nil
+		}
+	}
+
+	s.After.Upload.Append(q.recordAccepted)
+	s.After.Media.Append(q.recordAccepted)
+	s.After.Mirror.Append(q.recordAccepted)
+
+	if del := s.On.Delete; del != nil {
+		s.On.Delete = func(r blossy.Request, hash blossom.Hash) *blossom.Error {
+			if err := del(r, hash); err != nil {
+				return err
+			}
+			return q.Forget(r, hash)
+		}
+	}
+}
+
+// storedKey is the request value holding the descriptor of the blob stored by the On hook,
+// which is recorded by [Quota.recordAccepted] if the server accepts the upload.
+type storedKey struct{}
+
+// uploadFunc is the signature of the upload hooks of the server, [blossy.OnHooks.Upload] and [blossy.OnHooks.Media].
+type uploadFunc = func(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error)
+
+// limitUpload returns an upload hook that lets the next hook read at most the remaining space of the pubkey
+// from the blobs of unknown size, and that stores the descriptor of the uploaded blob in the request.
+func (q *Quota) limitUpload(next uploadFunc) uploadFunc {
+	return func(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+		var limiter *sizeLimiter
+		if hints.Size < 0 && r.IsAuthed() {
+			remaining, limited, err := q.Remaining(r.Context(), r.Pubkey())
+			if err != nil {
+				return blossom.BlobDescriptor{}, blossom.ErrInternal(fmt.Sprintf("quota: %v", err))
+			}
+			if limited {
+				limiter = &sizeLimiter{r: data, max: remaining}
+				data = limiter
+			}
+		}
+
+		desc, err := next(r, hints, data)
+		if limiter != nil && limiter.exceeded() {
+			return blossom.BlobDescriptor{}, blossy.ErrQuotaExceeded(fmt.Sprintf("%d bytes remaining", max(limiter.max, 0)))
+		}
+		if err != nil {
+			return desc, err
+		}
+
+		r.Set(storedKey{}, desc)
+		return desc, nil
+	}
+}
+
+// recordAccepted is an After hook that records the blob stored by the On hook, if the server accepted the upload.
+func (q *Quota) recordAccepted(r blossy.Request, res blossy.Response) {
+	if res.Status < 200 || res.Status > 299 {
+		return
+	}
+	desc, ok := r.Get(storedKey{}).(blossom.BlobDescriptor)
+	if !ok {
+		return
+	}
+	if err := q.Record(r, desc); err != nil {
+		q.log.Error("quota: failed to record the blob", "pubkey", r.Pubkey(), "hash", desc.Hash.Hex(), "error", err)
+	}
+}
+
+// errQuotaExceeded is returned by [sizeLimiter] when the blob exceeds the remaining space of the pubkey.
+var errQuotaExceeded = errors.New("quota: the blob exceeds the remaining space")
+
+// sizeLimiter is a reader that fails with [errQuotaExceeded] after more than max bytes have been read.
+type sizeLimiter struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (l *sizeLimiter) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max {
+		return n, errQuotaExceeded
+	}
+	return n, err
+}
+
+// exceeded returns whether more than max bytes have been read.
+func (l *sizeLimiter) exceeded() bool { return l.n > l.max }
+
+// CheckQuota is a Reject hook for the Upload and Media endpoints, rejecting the uploads that would exceed
+// the quota of the pubkey with 413 (Content Too Large). If the size of the blob is unknown,
+// only the uploads of pubkeys with no space left are rejected here, and [Quota.Bind] cuts off the others
+// when they exceed the remaining space.
+// Unauthenticated uploads are rejected with 401 (Unauthorized).
+func (q *Quota) CheckQuota(r blossy.Request, hints blossy.UploadHints) *blossom.Error {
+	if !r.IsAuthed() {
+		return blossom.ErrUnauthorized("authorization is required to track the storage quota")
+	}
+
+	remaining, limited, err := q.Remaining(r.Context(), r.Pubkey())
+	if err != nil {
+		return blossom.ErrInternal(fmt.Sprintf("quota: %v", err))
+	}
+	if !limited {
+		return nil
+	}
+
+	size := max(hints.Size, 1) // unknown sizes need at least one byte
+	if size > remaining {
+		return blossy.ErrQuotaExceeded(fmt.Sprintf("%d bytes remaining", max(remaining, 0)))
+	}
+	return nil
+}
+
+// CheckMirror is a Reject hook for the Mirror endpoint, rejecting the mirrors of pubkeys with no space left
+// with 413 (Content Too Large). Unauthenticated mirrors are rejected with 401 (Unauthorized).
+func (q *Quota) CheckMirror(r blossy.Request, u *url.URL) *blossom.Error {
+	return q.CheckQuota(r, blossy.UploadHints{Size: -1})
+}
+
+// Record records the blob of the descriptor as stored by the pubkey of the request.
+// It's meant to be called after the server accepted the upload, and it's a no-op for unauthenticated requests.
+func (q *Quota) Record(r blossy.Request, desc blossom.BlobDescriptor) *blossom.Error {
+	if !r.IsAuthed() {
+		return nil
+	}
+	if err := q.backend.Add(r.Context(), r.Pubkey(), desc.Hash, desc.Size); err != nil {
+		return blossom.ErrInternal(fmt.Sprintf("quota: failed to record the blob: %v", err))
+	}
+	return nil
+}
+
+// Forget removes the blob from the ones stored by the pubkey of the request.
+// It's meant to be called after a successful deletion, and it's a no-op for unauthenticated requests.
+func (q *Quota) Forget(r blossy.Request, hash blossom.Hash) *blossom.Error {
+	if !r.IsAuthed() {
+		return nil
+	}
+	if err := q.backend.Remove(r.Context(), r.Pubkey(), hash); err != nil {
+		return blossom.ErrInternal(fmt.Sprintf("quota: failed to forget the blob: %v", err))
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package quota
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/auth"
+	"github.com/pippellia-btc/blossy/blossytest"
+)
+
+var (
+	alice = strings.Repeat("a", 64)
+	bob   = strings.Repeat("b", 64)
+
+	hash1 = blossom.ComputeHash([]byte("one"))
+	hash2 = blossom.ComputeHash([]byte("two"))
+)
+
+func TestBackends(t *testing.T) {
+	backends := map[string]func(t *testing.T) Backend{
+		"memory": func(t *testing.T) Backend { return NewMemory() },
+		"sqlite": func(t *testing.T) Backend {
+			db, err := sql.Open("sqlite3", ":memory:")
+			if err != nil {
+				t.Fatal(err)
+			}
+			db.SetMaxOpenConns(1) // every connection opens a different in-memory database
+			t.Cleanup(func() { db.Close() })
+
+			backend, err := NewSQL(t.Context(), db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return backend
+		},
+	}
+
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := t.Context()
+			backend := newBackend(t)
+
+			mustAdd(t, backend, alice, hash1, 10)
+			mustAdd(t, backend, alice, hash1, 10) // no-op
+			mustAdd(t, backend, alice, hash2, 5)
+			mustAdd(t, backend, bob, hash1, 10)
+
+			assertUsage(t, backend, alice, 15)
+			assertUsage(t, backend, bob, 10)
+
+			if err := backend.Add(ctx, alice, hash2, -1); !errors.Is(err, ErrInvalidSize) {
+				t.Errorf("expected ErrInvalidSize, got %v", err)
+			}
+
+			if err := backend.Remove(ctx, alice, hash1); err != nil {
+				t.Fatal(err)
+			}
+			if err := backend.Remove(ctx, alice, hash1); err != nil {
+				t.Fatal(err)
+			}
+
+			assertUsage(t, backend, alice, 5)
+			assertUsage(t, backend, bob, 10)
+			assertUsage(t, backend, "unknown", 0)
+		})
+	}
+}
+
+func TestNewSQL(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := NewSQL(t.Context(), db, WithTable("blobs; DROP TABLE users")); err == nil {
+		t.Error("expected an error for an invalid table name")
+	}
+	if _, err := NewSQL(t.Context(), nil); err == nil {
+		t.Error("expected an error for a nil database")
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	backend := NewMemory()
+	mustAdd(t, backend, alice, hash1, 90)
+
+	q := New(backend, 100, WithLimits(func(pubkey string) int64 {
+		if pubkey == bob {
+			return -1
+		}
+		return 100
+	}))
+
+	tests := []struct {
+		name    string
+		request blossy.Request
+		size    int64
+		code    int
+	}{
+		{name: "unauthenticated", request: blossy.NewTestRequest(), size: 1, code: http.StatusUnauthorized},
+		{name: "within quota", request: blossy.NewTestRequest(blossy.RequestPubkey(alice)), size: 10},
+		{name: "over quota", request: blossy.NewTestRequest(blossy.RequestPubkey(alice)), size: 11, code: http.StatusRequestEntityTooLarge},
+		{name: "unknown size", request: blossy.NewTestRequest(blossy.RequestPubkey(alice)), size: -1},
+		{name: "unlimited", request: blossy.NewTestRequest(blossy.RequestPubkey(bob)), size: 1 << 40},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := q.CheckQuota(test.request, blossy.UploadHints{Size: test.size})
+			switch {
+			case test.code == 0 && err != nil:
+				t.Fatalf("expected no error, got %v", err)
+			case test.code != 0 && err == nil:
+				t.Fatalf("expected error %d, got nil", test.code)
+			case test.code != 0 && err.Code != test.code:
+				t.Fatalf("expected error %d, got %v", test.code, err)
+			}
+		})
+	}
+
+	mustAdd(t, backend, alice, hash2, 10)
+	err := q.CheckQuota(blossy.NewTestRequest(blossy.RequestPubkey(alice)), blossy.UploadHints{Size: -1})
+	if err == nil || err.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 with no space left, got %v", err)
+	}
+}
+
+func TestBind(t *testing.T) {
+	server := blossytest.NewTestServer(t)
+	New(NewMemory(), 8).Bind(server.Blossy)
+
+	signer := blossytest.NewSigner(t)
+	client := server.Client(t, signer)
+
+	desc, err := client.Upload(t.Context(), strings.NewReader("hello"), "text/plain")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = client.Upload(t.Context(), strings.NewReader("world"), "text/plain")
+	var blossomErr *blossom.Error
+	if !errors.As(err, &blossomErr) || blossomErr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %v", err)
+	}
+	if !strings.Contains(blossomErr.Reason, "3 bytes remaining") {
+		t.Errorf("expected the remaining space in the reason, got %q", blossomErr.Reason)
+	}
+
+	if err := client.Delete(t.Context(), desc.Hash); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := client.Upload(t.Context(), strings.NewReader("world"), "text/plain"); err != nil {
+		t.Fatalf("expected the upload to succeed after the deletion, got %v", err)
+	}
+}
+
+func TestBindAccepted(t *testing.T) {
+	data := []byte("hello")
+	other := blossom.ComputeHash([]byte("other"))
+
+	tests := []struct {
+		name   string
+		body   io.Reader
+		digest *blossom.Hash // declared with a structured 'Content-Digest', which the server verifies
+		status int
+		usage  int64
+	}{
+		{name: "declared size", body: bytes.NewReader(data), status: http.StatusOK, usage: 5},
+		{name: "unknown size", body: io.MultiReader(bytes.NewReader(data)), status: http.StatusOK, usage: 5},
+		{name: "unknown size over quota", body: io.MultiReader(strings.NewReader("hello world")), status: http.StatusRequestEntityTooLarge},
+		{name: "digest mismatch", body: bytes.NewReader(data), digest: &other, status: http.StatusBadRequest},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			server := blossytest.NewTestServer(t)
+
+			// the hook ignores the read errors, relying on the server to reject the invalid uploads after it
+			server.Blossy.On.Upload = func(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+				b, _ := io.ReadAll(data)
+				hash := blossom.ComputeHash(b)
+				if hints.Hash != nil {
+					hash = *hints.Hash
+				}
+				return blossom.BlobDescriptor{Hash: hash, Size: int64(len(b)), Type: "text/plain"}, nil
+			}
+
+			backend := NewMemory()
+			New(backend, 8).Bind(server.Blossy)
+
+			signer := blossytest.NewSigner(t)
+			r := server.NewRequest(t, http.MethodPut, "/upload", test.body)
+			if test.digest != nil {
+				r.Header.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(test.digest[:])+":")
+				blossytest.Authorize(t, r, signer, auth.ActionUpload, *test.digest)
+			} else {
+				blossytest.Authorize(t, r, signer, auth.ActionUpload)
+			}
+
+			res := server.Do(t, r)
+			if res.StatusCode != test.status {
+				t.Fatalf("expected status %d, got %d (%s)", test.status, res.StatusCode, res.Header.Get("X-Reason"))
+			}
+			assertUsage(t, backend, blossytest.Pubkey(t, signer), test.usage)
+		})
+	}
+}
+
+func mustAdd(t *testing.T, backend Backend, pubkey string, hash blossom.Hash, size int64) {
+	t.Helper()
+	if err := backend.Add(context.Background(), pubkey, hash, size); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertUsage(t *testing.T, backend Backend, pubkey string, expected int64) {
+	t.Helper()
+	usage, err := backend.Usage(context.Background(), pubkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != expected {
+		t.Errorf("expected usage %d for %s, got %d", expected, pubkey[:min(8, len(pubkey))], usage)
+	}
+}
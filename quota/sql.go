@@ -0,0 +1,111 @@
+package quota
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+// SQL is a [Backend] backed by a SQL database, such as SQLite or Postgres. Create one with [NewSQL].
+// The driver of the database must be imported by the caller.
+type SQL struct {
+	db           *sql.DB
+	table        string
+	placeholders bool
+
+	usage  string
+	add    string
+	remove string
+}
+
+type SQLOption func(*SQL)
+
+// WithTable sets the name of the table used by the backend. By default, it's "quota_blobs".
+func WithTable(name string) SQLOption {
+	return func(s *SQL) {
+		s.table = name
+	}
+}
+
+// WithNumberedPlaceholders makes the queries use numbered placeholders ($1, $2, ...) instead of '?',
+// as required by Postgres drivers.
+func WithNumberedPlaceholders() SQLOption {
+	return func(s *SQL) {
+		s.placeholders = true
+	}
+}
+
+var validTable = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// NewSQL returns a [SQL] backend using the database, creating its table if it doesn't exist.
+func NewSQL(ctx context.Context, db *sql.DB, opts ...SQLOption) (*SQL, error) {
+	if db == nil {
+		return nil, errors.New("quota: database must not be nil")
+	}
+
+	s := &SQL{
+		db:    db,
+		table: "quota_blobs",
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if !validTable.MatchString(s.table) {
+		return nil, fmt.Errorf("quota: invalid table name %q", s.table)
+	}
+
+	schema := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		pubkey TEXT NOT NULL,
+		hash TEXT NOT NULL,
+		size BIGINT NOT NULL,
+		PRIMARY KEY (pubkey, hash)
+	)`, s.table)
+
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return nil, fmt.Errorf("quota: failed to create the table: %w", err)
+	}
+
+	s.usage = fmt.Sprintf("SELECT COALESCE(SUM(size), 0) FROM %s WHERE pubkey = %s", s.table, s.param(1))
+	s.add = fmt.Sprintf("INSERT INTO %s (pubkey, hash, size) VALUES (%s, %s, %s) ON CONFLICT DO NOTHING",
+		s.table, s.param(1), s.param(2), s.param(3))
+	s.remove = fmt.Sprintf("DELETE FROM %s WHERE pubkey = %s AND hash = %s", s.table, s.param(1), s.param(2))
+	return s, nil
+}
+
+// param returns the placeholder of the i-th parameter of a query.
+func (s *SQL) param(i int) string {
+	if s.placeholders {
+		return fmt.Sprintf("$%d", i)
+	}
+	return "?"
+}
+
+func (s *SQL) Usage(ctx context.Context, pubkey string) (int64, error) {
+	var usage int64
+	if err := s.db.QueryRowContext(ctx, s.usage, pubkey).Scan(&usage); err != nil {
+		return 0, fmt.Errorf("quota: failed to query the usage: %w", err)
+	}
+	return usage, nil
+}
+
+func (s *SQL) Add(ctx context.Context, pubkey string, hash blossom.Hash, size int64) error {
+	if size < 0 {
+		return ErrInvalidSize
+	}
+	if _, err := s.db.ExecContext(ctx, s.add, pubkey, hash.Hex(), size); err != nil {
+		return fmt.Errorf("quota: failed to add the blob: %w", err)
+	}
+	return nil
+}
+
+func (s *SQL) Remove(ctx context.Context, pubkey string, hash blossom.Hash) error {
+	if _, err := s.db.ExecContext(ctx, s.remove, pubkey, hash.Hex()); err != nil {
+		return fmt.Errorf("quota: failed to remove the blob: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+// Package ratelimit provides token bucket rate limiters keyed by arbitrary strings,
+// such as the group of an IP address or a nostr pubkey.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// cleanupInterval is the minimum interval between two removals of idle buckets.
+const cleanupInterval = time.Minute
+
+// Limiter is a collection of token buckets, one per key.
+// Each bucket is refilled at the same rate and has the same burst capacity.
+// It's safe for concurrent use. Create one with [New].
+type Limiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu          sync.Mutex
+	buckets     map[string]*bucket
+	lastCleanup time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Per returns the rate of n events per duration d, to be used in [New].
+func Per(n int, d time.Duration) float64 {
+	return float64(n) / d.Seconds()
+}
+
+// New returns a Limiter that allows events at the given rate (events per second) for each key,
+// with bursts of at most burst events. Use [Per] to express the rate in other units.
+//
+// It panics if rate or burst are not positive.
+func New(rate float64, burst int) *Limiter {
+	if rate <= 0 {
+		panic("ratelimit.New: rate must be positive")
+	}
+	if burst <= 0 {
+		panic("ratelimit.New: burst must be positive")
+	}
+	return &Limiter{
+		rate:        rate,
+		burst:       float64(burst),
+		buckets:     make(map[string]*bucket),
+		lastCleanup: time.Now(),
+	}
+}
+
+// Allow reports whether an event for the key may happen now, consuming one token if so.
+// If not, it returns how long to wait before the next event is allowed.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	return l.AllowN(key, 1)
+}
+
+// AllowN reports whether n events for the key may happen now, consuming n tokens if so.
+// If not, it returns how long to wait before the n events are allowed.
+// If n is greater than the burst, the events are never allowed.
+func (l *Limiter) AllowN(key string, n int) (bool, time.Duration) {
+	now := time.Now()
+	cost := float64(n)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastCleanup) > cleanupInterval {
+		l.cleanup(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	elapsed := now.Sub(b.last).Seconds()
+	b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+	b.last = now
+
+	if b.tokens >= cost {
+		b.tokens -= cost
+		return true, 0
+	}
+
+	if cost > l.burst {
+		return false, time.Duration(math.MaxInt64)
+	}
+
+	missing := cost - b.tokens
+	return false, time.Duration(missing / l.rate * float64(time.Second))
+}
+
+// Size returns the number of keys currently tracked by the limiter.
+func (l *Limiter) Size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// cleanup removes the buckets that would be full by now, as they are
+// indistinguishable from new ones. It must be called with the lock held.
+func (l *Limiter) cleanup(now time.Time) {
+	for key, b := range l.buckets {
+		elapsed := now.Sub(b.last).Seconds()
+		if b.tokens+elapsed*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastCleanup = now
+}
@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	limiter := New(Per(1, time.Hour), 3)
+
+	for i := range 3 {
+		if ok, _ := limiter.Allow("alice"); !ok {
+			t.Fatalf("event %d: expected to be allowed", i)
+		}
+	}
+
+	ok, wait := limiter.Allow("alice")
+	if ok {
+		t.Fatal("expected event to be rate limited")
+	}
+	if wait <= 0 || wait > time.Hour {
+		t.Errorf("expected wait in (0, 1h], got %v", wait)
+	}
+
+	// keys are independent
+	if ok, _ := limiter.Allow("bob"); !ok {
+		t.Fatal("expected event for a different key to be allowed")
+	}
+}
+
+func TestAllowN(t *testing.T) {
+	limiter := New(Per(1, time.Hour), 3)
+
+	if ok, _ := limiter.AllowN("alice", 4); ok {
+		t.Fatal("expected event bigger than burst to be rate limited")
+	}
+	if ok, _ := limiter.AllowN("alice", 3); !ok {
+		t.Fatal("expected event equal to burst to be allowed")
+	}
+}
+
+func TestRefill(t *testing.T) {
+	limiter := New(Per(1, 10*time.Millisecond), 1)
+
+	if ok, _ := limiter.Allow("alice"); !ok {
+		t.Fatal("expected first event to be allowed")
+	}
+	if ok, _ := limiter.Allow("alice"); ok {
+		t.Fatal("expected second event to be rate limited")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if ok, _ := limiter.Allow("alice"); !ok {
+		t.Fatal("expected event to be allowed after the refill")
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	limiter := New(Per(1, time.Millisecond), 1)
+	limiter.Allow("alice")
+	limiter.Allow("bob")
+
+	time.Sleep(5 * time.Millisecond)
+	limiter.mu.Lock()
+	limiter.cleanup(time.Now())
+	limiter.mu.Unlock()
+
+	if size := limiter.Size(); size != 0 {
+		t.Errorf("expected idle buckets to be removed, got %d", size)
+	}
+}
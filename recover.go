@@ -0,0 +1,42 @@
+package blossy
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+// recovering returns a handler that recovers from the panics of the handler of the endpoint (e.g. a panicking hook),
+// so that the response, the metrics and the After hooks are still written.
+func (s *Server) recovering(e Endpoint, handle http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				s.handlePanic(w, r, e, v)
+			}
+		}()
+		handle(w, r)
+	}
+}
+
+// handlePanic logs the panic with its stack trace, records it in the metrics and responds with 500.
+// It panics again with [http.ErrAbortHandler], which is the way handlers abort a response on purpose.
+//
+// If the response was already being written when the panic occurred, the error can't be sent to the client,
+// which receives a truncated response.
+func (s *Server) handlePanic(w http.ResponseWriter, r *http.Request, e Endpoint, v any) {
+	if v == http.ErrAbortHandler {
+		panic(v)
+	}
+
+	s.metrics.ObservePanic(endpointLabel(e))
+	s.logger(r).Error("panic while handling the request",
+		"panic", v,
+		"endpoint", endpointLabel(e),
+		"method", r.Method,
+		"path", r.URL.Path,
+		"stack", string(debug.Stack()),
+	)
+	blossom.WriteError(w, blossom.ErrInternal("internal error"))
+}
@@ -0,0 +1,6 @@
+//go:build race
+
+package references
+
+// raceEnabled reports whether the tests are run with the race detector.
+const raceEnabled = true
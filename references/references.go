@@ -0,0 +1,272 @@
+// Package references scans the events published on nostr relays for references to the blobs of a blossy server,
+// so that blobs nobody links to can be pruned after a grace period, for example with [retention.Orphaned].
+//
+// An event references a blob if its content or tags contain a URL of the blob on the server's hostname,
+// or if it has an "x" tag (or an "imeta" tag with an "x" entry) with the blob's hash.
+// Since blobs are content addressed, x tags count as references even when the event links to another server.
+//
+// Example:
+//
+//	scanner, err := references.New(idx, "blossom.example.com", []string{"wss://relay.example.com"})
+//	if err != nil {
+//	    panic(err)
+//	}
+//	scanner.Bind(server) // follows the relays while the server is serving
+//
+//	policy := retention.New(idx.Blobs, deleteBlob, []retention.Rule{
+//	    retention.Orphaned(idx.Referenced, 7*24*time.Hour),
+//	})
+package references
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+)
+
+// Marker records that a blob is referenced by a nostr event. [index.Index] implements it.
+type Marker interface {
+	MarkReferenced(ctx context.Context, hash blossom.Hash) error
+}
+
+// DefaultKinds are the kinds of the events scanned by default:
+// metadata, notes, pictures, videos, file metadata and long-form articles.
+var DefaultKinds = []int{
+	nostr.KindProfileMetadata,
+	nostr.KindTextNote,
+	20, // picture
+	21, // video
+	22, // short video
+	nostr.KindFileMetadata,
+	nostr.KindArticle,
+}
+
+// Scanner follows nostr relays, marking the blobs referenced by their events. Create one with [New].
+type Scanner struct {
+	marker Marker
+	relays []string
+	urls   *regexp.Regexp
+
+	kinds    []int
+	lookback time.Duration
+	retry    time.Duration
+	log      *slog.Logger
+}
+
+type Option func(*Scanner)
+
+// WithKinds sets the kinds of the events to scan. By default, they are the [DefaultKinds].
+func WithKinds(kinds ...int) Option {
+	return func(s *Scanner) {
+		s.kinds = kinds
+	}
+}
+
+// WithLookback sets how far back in time the scanner requests events when it starts. By default, it's 24 hours.
+func WithLookback(d time.Duration) Option {
+	return func(s *Scanner) {
+		s.lookback = d
+	}
+}
+
+// WithRetryInterval sets how long the scanner waits before reconnecting to a relay. By default, it's one minute.
+func WithRetryInterval(d time.Duration) Option {
+	return func(s *Scanner) {
+		s.retry = d
+	}
+}
+
+// WithLogger sets the logger of the scanner. By default, it's [slog.Default].
+func WithLogger(l *slog.Logger) Option {
+	return func(s *Scanner) {
+		s.log = l
+	}
+}
+
+// New returns a [Scanner] that follows the relays, looking for URLs of blobs on the hostname (e.g. "blossom.example.com"),
+// and marks the referenced blobs with the marker.
+func New(marker Marker, hostname string, relays []string, opts ...Option) (*Scanner, error) {
+	if marker == nil {
+		return nil, errors.New("references: marker must not be nil")
+	}
+	if hostname == "" {
+		return nil, errors.New("references: hostname must not be empty")
+	}
+	if len(relays) == 0 {
+		return nil, errors.New("references: at least one relay is required")
+	}
+	for _, relay := range relays {
+		u, err := url.Parse(relay)
+		if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+			return nil, fmt.Errorf("references: invalid relay URL %q", relay)
+		}
+	}
+
+	s := &Scanner{
+		marker:   marker,
+		relays:   relays,
+		urls:     regexp.MustCompile(`https?://` + regexp.QuoteMeta(hostname) + `(?::\d+)?/([0-9a-f]{64})\b`),
+		kinds:    DefaultKinds,
+		lookback: 24 * time.Hour,
+		retry:    time.Minute,
+		log:      slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.lookback < 0 {
+		return nil, errors.New("references: lookback must not be negative")
+	}
+	if s.retry <= 0 {
+		return nil, errors.New("references: retry interval must be positive")
+	}
+	if s.log == nil {
+		return nil, errors.New("references: logger must not be nil")
+	}
+	return s, nil
+}
+
+// Hashes returns the hashes of the blobs referenced by the event, without duplicates.
+func (s *Scanner) Hashes(event *nostr.Event) []blossom.Hash {
+	var hashes []blossom.Hash
+	seen := make(map[blossom.Hash]bool)
+
+	add := func(hex string) {
+		hash, err := blossom.ParseHash(hex)
+		if err != nil || seen[hash] {
+			return
+		}
+		seen[hash] = true
+		hashes = append(hashes, hash)
+	}
+
+	addURLs := func(text string) {
+		for _, match := range s.urls.FindAllStringSubmatch(text, -1) {
+			add(match[1])
+		}
+	}
+
+	addURLs(event.Content)
+	for _, tag := range event.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+
+		switch tag[0] {
+		case "x":
+			add(tag[1])
+
+		case "imeta":
+			for _, entry := range tag[1:] {
+				if hex, ok := strings.CutPrefix(entry, "x "); ok {
+					add(hex)
+				}
+			}
+		}
+
+		for _, value := range tag[1:] {
+			addURLs(value)
+		}
+	}
+	return hashes
+}
+
+// Scan marks the blobs referenced by the event. It can be used to feed events from other sources than relays,
+// such as a relay running in the same process.
+func (s *Scanner) Scan(ctx context.Context, event *nostr.Event) error {
+	var errs []error
+	for _, hash := range s.Hashes(event) {
+		if err := s.marker.MarkReferenced(ctx, hash); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Bind follows the relays in the background of the server while it's serving (see [blossy.Server.Background]).
+func (s *Scanner) Bind(server *blossy.Server) {
+	server.Background(s.Run)
+}
+
+// Run follows all the relays until the context is cancelled, reconnecting to them when they disconnect.
+func (s *Scanner) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, relay := range s.relays {
+		wg.Go(func() { s.follow(ctx, relay) })
+	}
+	wg.Wait()
+}
+
+// follow scans the events of the relay until the context is cancelled.
+// After a disconnection, it resumes from the most recent event it has seen.
+func (s *Scanner) follow(ctx context.Context, relay string) {
+	since := nostr.Timestamp(time.Now().Add(-s.lookback).Unix())
+	for {
+		var err error
+		since, err = s.subscribe(ctx, relay, since)
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.log.Warn("references: disconnected from the relay", "relay", relay, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.retry):
+		}
+	}
+}
+
+// subscribe scans the events of the relay created after since, until the subscription ends.
+// It returns the creation time of the most recent event it has seen.
+func (s *Scanner) subscribe(ctx context.Context, relay string, since nostr.Timestamp) (nostr.Timestamp, error) {
+	r, err := nostr.RelayConnect(ctx, relay)
+	if err != nil {
+		return since, err
+	}
+	// Close races with the connection goroutines of go-nostr (v0.51.8), which -race reports. See TestRun.
+	defer r.Close()
+
+	filter := nostr.Filter{Kinds: s.kinds, Since: &since}
+	sub, err := r.Subscribe(ctx, nostr.Filters{filter})
+	if err != nil {
+		return since, err
+	}
+	defer sub.Unsub()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return since, ctx.Err()
+
+		case <-r.Context().Done():
+			return since, context.Cause(r.Context())
+
+		case reason := <-sub.ClosedReason:
+			return since, fmt.Errorf("subscription closed by the relay: %s", reason)
+
+		case event, ok := <-sub.Events:
+			if !ok {
+				return since, errors.New("subscription ended")
+			}
+
+			if err := s.Scan(ctx, event); err != nil {
+				s.log.Error("references: failed to mark the blobs", "relay", relay, "event", event.ID, "error", err)
+			}
+			if event.CreatedAt > since {
+				since = event.CreatedAt
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package references
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+)
+
+var (
+	hash1 = blossom.ComputeHash([]byte("one"))
+	hash2 = blossom.ComputeHash([]byte("two"))
+	hash3 = blossom.ComputeHash([]byte("three"))
+)
+
+type fakeMarker struct {
+	mu     sync.Mutex
+	marked []blossom.Hash
+}
+
+func (m *fakeMarker) MarkReferenced(ctx context.Context, hash blossom.Hash) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.marked = append(m.marked, hash)
+	return nil
+}
+
+func (m *fakeMarker) hashes() []blossom.Hash {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.marked)
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		hostname string
+		relays   []string
+	}{
+		{name: "no hostname", relays: []string{"wss://relay.example.com"}},
+		{name: "no relays", hostname: "example.com"},
+		{name: "http relay", hostname: "example.com", relays: []string{"https://relay.example.com"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := New(&fakeMarker{}, test.hostname, test.relays); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestHashes(t *testing.T) {
+	scanner, err := New(&fakeMarker{}, "blossom.example.com", []string{"wss://relay.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := &nostr.Event{
+		Content: "look https://blossom.example.com/" + hash1.Hex() + ".png and https://other.com/" + hash3.Hex(),
+		Tags: nostr.Tags{
+			{"x", hash2.Hex()},
+			{"imeta", "url https://blossom.example.com/" + hash1.Hex(), "x " + hash1.Hex()},
+			{"r", "https://blossom.example.com:8080/" + hash2.Hex()},
+			{"x", "not a hash"},
+		},
+	}
+
+	expected := []blossom.Hash{hash1, hash2}
+	if got := scanner.Hashes(event); !slices.Equal(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+// fakeRelay answers every subscription with the events, then keeps the connection open.
+func fakeRelay(t *testing.T, events ...*nostr.Event) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+
+		for {
+			_, msg, err := conn.Read(r.Context())
+			if err != nil {
+				return
+			}
+
+			var req []json.RawMessage
+			if err := json.Unmarshal(msg, &req); err != nil || len(req) < 2 || string(req[0]) != `"REQ"` {
+				continue
+			}
+
+			for _, event := range events {
+				raw, _ := json.Marshal([]any{"EVENT", req[1], event})
+				conn.Write(r.Context(), websocket.MessageText, raw)
+			}
+			raw, _ := json.Marshal([]any{"EOSE", req[1]})
+			conn.Write(r.Context(), websocket.MessageText, raw)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return strings.Replace(server.URL, "http://", "ws://", 1)
+}
+
+func TestRun(t *testing.T) {
+	if raceEnabled {
+		// go-nostr v0.51.8 races with itself when a relay connection is closed: Relay.close reads r.Connection
+		// while the goroutine watching the connection context sets it to nil. Closing the relay in any other way
+		// (cancelling its context, or through a SimplePool) goes through the same code, so it can't be avoided here.
+		t.Skip("go-nostr races when closing a relay connection")
+	}
+
+	event := &nostr.Event{
+		Kind:      nostr.KindTextNote,
+		CreatedAt: nostr.Now(),
+		Content:   "https://blossom.example.com/" + hash1.Hex(),
+	}
+	if err := event.Sign(nostr.GeneratePrivateKey()); err != nil {
+		t.Fatal(err)
+	}
+
+	marker := &fakeMarker{}
+	scanner, err := New(marker, "blossom.example.com", []string{fakeRelay(t, event)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		scanner.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(marker.hashes()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the scanner didn't mark the blob")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := marker.hashes(); !slices.Equal(got, []blossom.Hash{hash1}) {
+		t.Errorf("expected hash1 to be marked, got %v", got)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the scanner didn't stop after the context was cancelled")
+	}
+}
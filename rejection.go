@@ -0,0 +1,115 @@
+package blossy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+// Rejection is a structured rejection, with a machine-readable code that clients can react to programmatically
+// (e.g. "quota_exceeded", "payment_required"). Hooks return it with [Rejected].
+//
+// The response carries the reason in the 'X-Reason' header, as any other error, and the rejection as a JSON body:
+//
+//	{"code":"quota_exceeded","reason":"storage quota exceeded","retryable":false,"details":{"limit":1048576}}
+type Rejection struct {
+	// Status is the http status code of the response (e.g. 402, 413, 429).
+	Status int `json:"-"`
+
+	// Code identifies the kind of rejection, and it should be stable across versions.
+	Code string `json:"code"`
+
+	// Reason is the human-readable description of the rejection. If empty, the code is used.
+	Reason string `json:"reason,omitempty"`
+
+	// Retryable reports whether the same request might succeed later (e.g. after a rate limit window).
+	Retryable bool `json:"retryable"`
+
+	// Details are additional data about the rejection (e.g. the limit that was exceeded).
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// Rejected records the rejection in the request, and returns the corresponding [blossom.Error],
+// which the hook must return for the rejection to be written as the response.
+// If the request has not been routed by the server (e.g. it was created with [NewTestRequest]),
+// only the error is returned.
+//
+// Example:
+//
+//	func Quota(r blossy.Request, hints blossy.UploadHints) *blossom.Error {
+//	    if used(r.Pubkey())+hints.Size > limit {
+//	        return blossy.Rejected(r, blossy.Rejection{
+//	            Status:  http.StatusRequestEntityTooLarge,
+//	            Code:    "quota_exceeded",
+//	            Details: map[string]any{"limit": limit},
+//	        })
+//	    }
+//	    return nil
+//	}
+func Rejected(r Request, rejection Rejection) *blossom.Error {
+	if rejection.Reason == "" {
+		rejection.Reason = rejection.Code
+	}
+	if state, ok := stateOf(r.Raw()); ok {
+		state.rejection = &rejection
+	}
+	return &blossom.Error{Code: rejection.Status, Reason: rejection.Reason}
+}
+
+// rejectionWriter writes the rejection recorded in the state of the request as a JSON body,
+// in place of the empty body of the error with the same status code.
+type rejectionWriter struct {
+	http.ResponseWriter
+	state *requestState
+
+	// rejected is true once the rejection has been written. The rest of the body is discarded.
+	rejected bool
+}
+
+func (w *rejectionWriter) WriteHeader(code int) {
+	rejection := w.state.rejection
+	if rejection == nil || rejection.Status != code || w.rejected {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	body, err := json.Marshal(rejection)
+	if err != nil {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	w.rejected = true
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)+1))
+	w.ResponseWriter.WriteHeader(code)
+	w.ResponseWriter.Write(append(body, '\n'))
+}
+
+func (w *rejectionWriter) Write(p []byte) (int, error) {
+	if w.rejected {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// ReadFrom preserves the optimizations (e.g. sendfile) of the underlying writer, if any.
+func (w *rejectionWriter) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok && !w.rejected {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(struct{ io.Writer }{w}, r)
+}
+
+func (w *rejectionWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *rejectionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,93 @@
+package blossy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Reloader returns the options of the server to apply with [Server.Reload], typically by reading
+// a configuration file again. See [WithReloader].
+type Reloader func(ctx context.Context) ([]Option, error)
+
+// reloadable are the settings that [Server.Reload] replaces at once, while requests are being served.
+// Requests in flight keep using the settings they started with.
+type reloadable struct {
+	allowedTypes []string
+	blockedTypes []string
+	pubkeyRules  []pubkeyRule
+	rateLimits   []rateLimit
+	blocklist    Blocklist
+}
+
+// reloadable returns the reloadable part of the settings.
+func (s *settings) reloadable() *reloadable {
+	return &reloadable{
+		allowedTypes: s.Upload.allowedTypes,
+		blockedTypes: s.Upload.blockedTypes,
+		pubkeyRules:  s.Policy.pubkeyRules,
+		rateLimits:   s.Policy.rateLimits,
+		blocklist:    s.Policy.blocklist,
+	}
+}
+
+// sniff returns whether the type of an upload must be detected from its body.
+func (l *reloadable) sniff(hints UploadHints) bool {
+	return len(l.blockedTypes) > 0 || (len(l.allowedTypes) > 0 && hints.Type == "")
+}
+
+// Reload applies the options returned by the reloader configured with [WithReloader], replacing at once:
+//   - the allowed and blocked types ([WithAllowedTypes], [WithBlockedTypes]).
+//   - the pubkey rules ([WithAllowedPubkeys], [WithDeniedPubkeys], [WithPubkeyPolicy]).
+//   - the rate limits ([WithRateLimit]).
+//   - the blocklist ([WithBlocklist]).
+//
+// The other options are ignored, and changing them requires a restart. If the reloader fails or the options are invalid,
+// the current settings are kept and the error is returned. Requests in flight are not affected.
+//
+// If the server is started with [Server.StartAndServe] or [Server.Serve], Reload is also called on SIGHUP.
+func (s *Server) Reload(ctx context.Context) error {
+	reload := s.settings.Sys.reloader
+	if reload == nil {
+		return errors.New("reload: no reloader configured")
+	}
+
+	opts, err := reload(ctx)
+	if err != nil {
+		return err
+	}
+
+	next := &Server{log: s.log, settings: newSettings()}
+	for _, opt := range opts {
+		opt(next)
+	}
+
+	if err := next.validateReloadable(); err != nil {
+		return err
+	}
+
+	s.live.Store(next.settings.reloadable())
+	s.log.Info("reloaded the server settings")
+	return nil
+}
+
+// reloadOnHangup calls [Server.Reload] every time the process receives SIGHUP, until the context is cancelled.
+func (s *Server) reloadOnHangup(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-hangup:
+			if err := s.Reload(ctx); err != nil {
+				s.log.Error("failed to reload the server settings", "error", err)
+			}
+		}
+	}
+}
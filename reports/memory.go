@@ -0,0 +1,151 @@
+package reports
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+)
+
+// ErrInvalidStatus is returned by [Store.Resolve] when the status is not valid, or it's [StatusOpen].
+var ErrInvalidStatus = errors.New("reports: invalid resolution status")
+
+type key struct {
+	pubkey string
+	hash   blossom.Hash
+}
+
+// Memory is a [Store] in memory, useful for testing. Create one with [NewMemory].
+// The reports are lost when the process exits.
+type Memory struct {
+	mu      sync.RWMutex
+	entries []*Entry
+	seen    map[key]bool
+}
+
+// NewMemory returns an empty [Memory] store.
+func NewMemory() *Memory {
+	return &Memory{seen: make(map[key]bool)}
+}
+
+func (m *Memory) Add(ctx context.Context, report blossy.Report) error {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, blob := range report.Blobs {
+		k := key{pubkey: report.Pubkey, hash: blob.Hash}
+		if m.seen[k] {
+			continue
+		}
+
+		m.seen[k] = true
+		m.entries = append(m.entries, &Entry{
+			Hash:     blob.Hash,
+			Pubkey:   report.Pubkey,
+			Reason:   blob.Reason,
+			Content:  report.Content,
+			Reported: now,
+			Status:   StatusOpen,
+		})
+	}
+	return nil
+}
+
+func (m *Memory) List(ctx context.Context, filter Filter) ([]Entry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var entries []Entry
+	for _, e := range slices.Backward(m.entries) {
+		if filter.Limit > 0 && len(entries) >= filter.Limit {
+			break
+		}
+		if filter.Hash != (blossom.Hash{}) && e.Hash != filter.Hash {
+			continue
+		}
+		if filter.Pubkey != "" && e.Pubkey != filter.Pubkey {
+			continue
+		}
+		if filter.Status != "" && e.Status != filter.Status {
+			continue
+		}
+		entries = append(entries, *e)
+	}
+	return entries, nil
+}
+
+func (m *Memory) Count(ctx context.Context, hash blossom.Hash) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	count := 0
+	for _, e := range m.entries {
+		if e.Hash == hash && e.Status == StatusOpen {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *Memory) Queue(ctx context.Context, limit int) ([]Summary, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	summaries := make(map[blossom.Hash]*Summary)
+	for _, e := range m.entries {
+		if e.Status != StatusOpen {
+			continue
+		}
+
+		s, ok := summaries[e.Hash]
+		if !ok {
+			s = &Summary{Hash: e.Hash, First: e.Reported}
+			summaries[e.Hash] = s
+		}
+		s.Reports++
+		s.Last = e.Reported
+	}
+
+	queue := make([]Summary, 0, len(summaries))
+	for _, s := range summaries {
+		queue = append(queue, *s)
+	}
+
+	slices.SortFunc(queue, func(a, b Summary) int {
+		if c := cmp.Compare(b.Reports, a.Reports); c != 0 {
+			return c
+		}
+		return a.First.Compare(b.First)
+	})
+
+	if limit > 0 && len(queue) > limit {
+		queue = queue[:limit]
+	}
+	return queue, nil
+}
+
+func (m *Memory) Resolve(ctx context.Context, hash blossom.Hash, status Status, note string) (int, error) {
+	if !status.Valid() || status == StatusOpen {
+		return 0, ErrInvalidStatus
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	resolved := 0
+	for _, e := range m.entries {
+		if e.Hash == hash && e.Status == StatusOpen {
+			e.Status = status
+			e.Note = note
+			resolved++
+		}
+	}
+	return resolved, nil
+}
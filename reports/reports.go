@@ -0,0 +1,110 @@
+// Package reports persists the BUD-09 reports received by a blossy server, and provides a moderation queue to review them.
+//
+// Every reported blob is stored as a separate [Entry], deduplicated by reporter and blob,
+// so that repeated reports from the same pubkey don't inflate the report count of a blob.
+// Moderators can then review the most reported blobs with [Store.Queue], and resolve their reports with [Store.Resolve].
+// A [Takedown] can also delete or quarantine the reported blobs automatically, according to rules.
+//
+// Example:
+//
+//	store, err := reports.NewSQL(ctx, db)
+//	if err != nil {
+//	    panic(err)
+//	}
+//	reports.Bind(server, store)
+//
+//	queue, err := store.Queue(ctx, 10) // the 10 blobs with the most open reports
+//	...
+//	store.Resolve(ctx, hash, reports.StatusRemoved, "illegal content")
+package reports
+
+import (
+	"context"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+)
+
+// Status is the moderation status of a report.
+type Status string
+
+const (
+	StatusOpen      Status = "open"      // the report is waiting to be reviewed
+	StatusRemoved   Status = "removed"   // the blob has been removed
+	StatusDismissed Status = "dismissed" // the report has been reviewed, and no action was taken
+)
+
+// Valid reports whether the status is one of the known ones.
+func (s Status) Valid() bool {
+	switch s {
+	case StatusOpen, StatusRemoved, StatusDismissed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Entry is the report of a single blob by a pubkey.
+type Entry struct {
+	Hash     blossom.Hash
+	Pubkey   string // the reporter
+	Reason   string // the NIP-56 report type, e.g. "illegal" or "spam"
+	Content  string // the content of the report event
+	Reported time.Time
+
+	Status Status
+	Note   string // the note of the moderator who resolved the report, if any
+}
+
+// Summary is the number of open reports of a blob, as returned by [Store.Queue].
+type Summary struct {
+	Hash    blossom.Hash
+	Reports int
+	First   time.Time // the time of the oldest open report
+	Last    time.Time // the time of the newest open report
+}
+
+// Filter selects the entries returned by [Store.List]. The zero value selects all of them.
+type Filter struct {
+	Hash   blossom.Hash // if not zero, only the reports of this blob
+	Pubkey string       // if not empty, only the reports of this reporter
+	Status Status       // if not empty, only the reports with this status
+	Limit  int          // if positive, the maximum number of entries
+}
+
+// Store persists the reports and their moderation status.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Add stores an entry for every blob of the report. Blobs already reported by the same pubkey are ignored,
+	// even if their report has been resolved.
+	Add(ctx context.Context, report blossy.Report) error
+
+	// List returns the entries matching the filter, newest first.
+	List(ctx context.Context, filter Filter) ([]Entry, error)
+
+	// Count returns the number of open reports of the blob.
+	Count(ctx context.Context, hash blossom.Hash) (int, error)
+
+	// Queue returns up to limit blobs with open reports, the most reported first.
+	Queue(ctx context.Context, limit int) ([]Summary, error)
+
+	// Resolve sets the status and the note of all the open reports of the blob, and returns how many were resolved.
+	Resolve(ctx context.Context, hash blossom.Hash, status Status, note string) (int, error)
+}
+
+// Bind stores the reports received by the server in the store.
+// If the server already has a Report hook, it's called after the report is stored.
+func Bind(s *blossy.Server, store Store) {
+	next := s.On.Report
+	s.On.Report = func(r blossy.Request, report blossy.Report) *blossom.Error {
+		if err := store.Add(r.Context(), report); err != nil {
+			return blossom.ErrInternal(err.Error())
+		}
+
+		if next != nil {
+			return next(r, report)
+		}
+		return nil
+	}
+}
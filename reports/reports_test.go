@@ -0,0 +1,168 @@
+package reports
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/blossytest"
+)
+
+var (
+	alice = strings.Repeat("a", 64)
+	bob   = strings.Repeat("b", 64)
+
+	hash1 = blossom.ComputeHash([]byte("one"))
+	hash2 = blossom.ComputeHash([]byte("two"))
+)
+
+func report(pubkey string, blobs ...blossy.ReportedBlob) blossy.Report {
+	return blossy.Report{Pubkey: pubkey, Blobs: blobs, Content: "bad stuff"}
+}
+
+func newSQL(t *testing.T) *SQL {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1) // every connection opens a different in-memory database
+	t.Cleanup(func() { db.Close() })
+
+	store, err := NewSQL(t.Context(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewMemory() },
+		"sql":    func(t *testing.T) Store { return newSQL(t) },
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			testStore(t, newStore(t))
+		})
+	}
+}
+
+func testStore(t *testing.T, store Store) {
+	ctx := t.Context()
+
+	reports := []blossy.Report{
+		report(alice, blossy.ReportedBlob{Hash: hash1, Reason: "illegal"}),
+		report(bob, blossy.ReportedBlob{Hash: hash1, Reason: "spam"}, blossy.ReportedBlob{Hash: hash2, Reason: "spam"}),
+		report(alice, blossy.ReportedBlob{Hash: hash1, Reason: "malware"}), // duplicate
+	}
+	for _, r := range reports {
+		if err := store.Add(ctx, r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	count, err := store.Count(ctx, hash1)
+	if err != nil || count != 2 {
+		t.Fatalf("expected 2 reports of hash1, got %d (%v)", count, err)
+	}
+
+	entries, err := store.List(ctx, Filter{Pubkey: alice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Reason != "illegal" || entries[0].Status != StatusOpen || entries[0].Content != "bad stuff" {
+		t.Errorf("expected the first report of alice only, got %+v", entries)
+	}
+
+	entries, err = store.List(ctx, Filter{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Pubkey != bob || entries[1].Pubkey != bob {
+		t.Errorf("expected the 2 newest reports from bob, got %+v", entries)
+	}
+
+	queue, err := store.Queue(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 2 || queue[0].Hash != hash1 || queue[0].Reports != 2 || queue[1].Hash != hash2 {
+		t.Errorf("expected hash1 then hash2 in the queue, got %+v", queue)
+	}
+	if queue[0].First.After(queue[0].Last) {
+		t.Errorf("expected the first report before the last, got %+v", queue[0])
+	}
+
+	if _, err := store.Resolve(ctx, hash1, StatusOpen, ""); !errors.Is(err, ErrInvalidStatus) {
+		t.Errorf("expected ErrInvalidStatus, got %v", err)
+	}
+
+	resolved, err := store.Resolve(ctx, hash1, StatusRemoved, "illegal content")
+	if err != nil || resolved != 2 {
+		t.Fatalf("expected 2 resolved reports, got %d (%v)", resolved, err)
+	}
+
+	entries, err = store.List(ctx, Filter{Hash: hash1, Status: StatusRemoved})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Note != "illegal content" {
+		t.Errorf("expected the resolved reports with the note, got %+v", entries)
+	}
+
+	queue, err = store.Queue(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 1 || queue[0].Hash != hash2 {
+		t.Errorf("expected only hash2 left in the queue, got %+v", queue)
+	}
+}
+
+func TestBind(t *testing.T) {
+	server := blossytest.NewTestServer(t)
+	store := NewMemory()
+
+	called := false
+	server.Blossy.On.Report = func(r blossy.Request, report blossy.Report) *blossom.Error {
+		called = true
+		return nil
+	}
+	Bind(server.Blossy, store)
+
+	sk := nostr.GeneratePrivateKey()
+	pubkey, _ := nostr.GetPublicKey(sk)
+	event := nostr.Event{
+		Kind:      nostr.KindReporting,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"x", hash1.Hex(), "spam"}},
+		Content:   "spam",
+	}
+	if err := event.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := event.MarshalJSON()
+	res := server.Do(t, server.NewRequest(t, http.MethodPut, "/report", bytes.NewReader(body)))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d (%s)", res.StatusCode, res.Header.Get("X-Reason"))
+	}
+
+	entries, _ := store.List(t.Context(), Filter{})
+	if len(entries) != 1 || entries[0].Pubkey != pubkey || entries[0].Hash != hash1 || entries[0].Reason != "spam" {
+		t.Errorf("expected the report to be stored, got %+v", entries)
+	}
+	if !called {
+		t.Error("expected the previous Report hook to be called")
+	}
+}
@@ -0,0 +1,248 @@
+package reports
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+)
+
+// SQL is a [Store] backed by a SQL database, such as SQLite or Postgres. Create one with [NewSQL].
+// The driver of the database must be imported by the caller.
+type SQL struct {
+	db           *sql.DB
+	table        string
+	placeholders bool
+}
+
+type SQLOption func(*SQL)
+
+// WithTable sets the name of the table used by the store. By default, it's "blossy_reports".
+func WithTable(name string) SQLOption {
+	return func(s *SQL) {
+		s.table = name
+	}
+}
+
+// WithNumberedPlaceholders makes the queries use numbered placeholders ($1, $2, ...) instead of '?',
+// as required by Postgres drivers.
+func WithNumberedPlaceholders() SQLOption {
+	return func(s *SQL) {
+		s.placeholders = true
+	}
+}
+
+var validTable = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// NewSQL returns a [SQL] store using the database, creating its table if it doesn't exist.
+func NewSQL(ctx context.Context, db *sql.DB, opts ...SQLOption) (*SQL, error) {
+	if db == nil {
+		return nil, errors.New("reports: database must not be nil")
+	}
+
+	s := &SQL{
+		db:    db,
+		table: "blossy_reports",
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if !validTable.MatchString(s.table) {
+		return nil, fmt.Errorf("reports: invalid table name %q", s.table)
+	}
+
+	schema := []string{
+		`CREATE TABLE IF NOT EXISTS {table} (
+			hash TEXT NOT NULL,
+			pubkey TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			content TEXT NOT NULL,
+			reported BIGINT NOT NULL,
+			status TEXT NOT NULL,
+			note TEXT NOT NULL,
+			PRIMARY KEY (pubkey, hash)
+		)`,
+		`CREATE INDEX IF NOT EXISTS {table}_hash ON {table} (hash, status)`,
+	}
+
+	for _, stmt := range schema {
+		if _, err := db.ExecContext(ctx, s.query(stmt)); err != nil {
+			return nil, fmt.Errorf("reports: failed to create the schema: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// query replaces the table name in the query, and the '?' placeholders with numbered ones if configured.
+func (s *SQL) query(q string) string {
+	q = strings.ReplaceAll(q, "{table}", s.table)
+	if !s.placeholders {
+		return q
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range q {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func (s *SQL) Add(ctx context.Context, report blossy.Report) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("reports: failed to add the report: %w", err)
+	}
+	defer tx.Rollback()
+
+	// reports are timestamped in nanoseconds, to preserve their order when listed
+	now := time.Now().UnixNano()
+	insert := s.query(`INSERT INTO {table} (hash, pubkey, reason, content, reported, status, note)
+		VALUES (?, ?, ?, ?, ?, ?, '') ON CONFLICT DO NOTHING`)
+
+	for _, blob := range report.Blobs {
+		_, err := tx.ExecContext(ctx, insert, blob.Hash.Hex(), report.Pubkey, blob.Reason, report.Content, now, StatusOpen)
+		if err != nil {
+			return fmt.Errorf("reports: failed to add the report: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("reports: failed to add the report: %w", err)
+	}
+	return nil
+}
+
+func (s *SQL) List(ctx context.Context, filter Filter) ([]Entry, error) {
+	var conditions []string
+	var args []any
+
+	if filter.Hash != (blossom.Hash{}) {
+		conditions = append(conditions, "hash = ?")
+		args = append(args, filter.Hash.Hex())
+	}
+	if filter.Pubkey != "" {
+		conditions = append(conditions, "pubkey = ?")
+		args = append(args, filter.Pubkey)
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, filter.Status)
+	}
+
+	q := `SELECT hash, pubkey, reason, content, reported, status, note FROM {table}`
+	if len(conditions) > 0 {
+		q += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	q += " ORDER BY reported DESC, hash, pubkey"
+	if filter.Limit > 0 {
+		q += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.query(q), args...)
+	if err != nil {
+		return nil, fmt.Errorf("reports: failed to list the reports: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		var hex string
+		var reported int64
+		if err := rows.Scan(&hex, &e.Pubkey, &e.Reason, &e.Content, &reported, &e.Status, &e.Note); err != nil {
+			return nil, fmt.Errorf("reports: failed to scan the report: %w", err)
+		}
+
+		e.Hash, err = blossom.ParseHash(hex)
+		if err != nil {
+			return nil, fmt.Errorf("reports: invalid hash in the store: %w", err)
+		}
+		e.Reported = time.Unix(0, reported)
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reports: failed to list the reports: %w", err)
+	}
+	return entries, nil
+}
+
+func (s *SQL) Count(ctx context.Context, hash blossom.Hash) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, s.query(`SELECT COUNT(*) FROM {table} WHERE hash = ? AND status = ?`),
+		hash.Hex(), StatusOpen).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("reports: failed to count the reports: %w", err)
+	}
+	return count, nil
+}
+
+func (s *SQL) Queue(ctx context.Context, limit int) ([]Summary, error) {
+	q := `SELECT hash, COUNT(*), MIN(reported), MAX(reported) FROM {table} WHERE status = ?
+		GROUP BY hash ORDER BY COUNT(*) DESC, MIN(reported)`
+	args := []any{StatusOpen}
+	if limit > 0 {
+		q += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.query(q), args...)
+	if err != nil {
+		return nil, fmt.Errorf("reports: failed to query the queue: %w", err)
+	}
+	defer rows.Close()
+
+	var queue []Summary
+	for rows.Next() {
+		var sum Summary
+		var hex string
+		var first, last int64
+		if err := rows.Scan(&hex, &sum.Reports, &first, &last); err != nil {
+			return nil, fmt.Errorf("reports: failed to scan the queue: %w", err)
+		}
+
+		sum.Hash, err = blossom.ParseHash(hex)
+		if err != nil {
+			return nil, fmt.Errorf("reports: invalid hash in the store: %w", err)
+		}
+		sum.First = time.Unix(0, first)
+		sum.Last = time.Unix(0, last)
+		queue = append(queue, sum)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reports: failed to query the queue: %w", err)
+	}
+	return queue, nil
+}
+
+func (s *SQL) Resolve(ctx context.Context, hash blossom.Hash, status Status, note string) (int, error) {
+	if !status.Valid() || status == StatusOpen {
+		return 0, ErrInvalidStatus
+	}
+
+	res, err := s.db.ExecContext(ctx, s.query(`UPDATE {table} SET status = ?, note = ? WHERE hash = ? AND status = ?`),
+		status, note, hash.Hex(), StatusOpen)
+	if err != nil {
+		return 0, fmt.Errorf("reports: failed to resolve the reports: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("reports: failed to resolve the reports: %w", err)
+	}
+	return int(n), nil
+}
@@ -0,0 +1,229 @@
+package reports
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+)
+
+// Action is what a [Takedown] does with a reported blob.
+// Actions are ordered by severity: when rules disagree, the most severe action is taken.
+type Action int
+
+const (
+	ActionNone       Action = iota // keep serving the blob
+	ActionQuarantine               // stop serving the blob with 451 Unavailable For Legal Reasons, pending review
+	ActionDelete                   // delete the blob, and resolve its reports as removed
+)
+
+// RuleFunc decides what to do with a blob after it has been reported, and the report has been stored.
+// The store can be queried to make the decision, for example to count the reports of the blob.
+type RuleFunc func(ctx context.Context, store Store, report blossy.Report, hash blossom.Hash) (Action, error)
+
+// DistinctReporters returns a [RuleFunc] that takes the action when at least n distinct pubkeys have open reports of the blob.
+func DistinctReporters(n int, action Action) RuleFunc {
+	return func(ctx context.Context, store Store, report blossy.Report, hash blossom.Hash) (Action, error) {
+		count, err := store.Count(ctx, hash)
+		if err != nil {
+			return ActionNone, err
+		}
+		if count >= n {
+			return action, nil
+		}
+		return ActionNone, nil
+	}
+}
+
+// Moderators returns a [RuleFunc] that takes the action when the reporter is one of the pubkeys.
+func Moderators(pubkeys []string, action Action) RuleFunc {
+	return func(ctx context.Context, store Store, report blossy.Report, hash blossom.Hash) (Action, error) {
+		if slices.Contains(pubkeys, report.Pubkey) {
+			return action, nil
+		}
+		return ActionNone, nil
+	}
+}
+
+// Config is the declarative form of the most common takedown rules, for example loaded from a configuration file.
+type Config struct {
+	// Moderators are the pubkeys whose reports delete the blobs.
+	Moderators []string `json:"moderators"`
+
+	// DeleteThreshold is the number of distinct reporters that deletes a blob. Zero disables the rule.
+	DeleteThreshold int `json:"delete_threshold"`
+
+	// QuarantineThreshold is the number of distinct reporters that quarantines a blob. Zero disables the rule.
+	QuarantineThreshold int `json:"quarantine_threshold"`
+}
+
+// Rules returns the rules of the config.
+func (c Config) Rules() []RuleFunc {
+	var rules []RuleFunc
+	if len(c.Moderators) > 0 {
+		rules = append(rules, Moderators(c.Moderators, ActionDelete))
+	}
+	if c.DeleteThreshold > 0 {
+		rules = append(rules, DistinctReporters(c.DeleteThreshold, ActionDelete))
+	}
+	if c.QuarantineThreshold > 0 {
+		rules = append(rules, DistinctReporters(c.QuarantineThreshold, ActionQuarantine))
+	}
+	return rules
+}
+
+// DeleteFunc deletes the blob from the storage.
+type DeleteFunc func(ctx context.Context, hash blossom.Hash) error
+
+// Takedown applies rules to the reported blobs, deleting or quarantining them automatically. Create one with [NewTakedown].
+//
+// The set of quarantined blobs is kept in memory. After a restart, it can be restored with [Takedown.Quarantine],
+// for example from the blobs in the moderation queue.
+type Takedown struct {
+	store  Store
+	delete DeleteFunc
+	rules  []RuleFunc
+
+	// evict removes the deleted blobs from the blob cache of the bound server, if any. See [blossy.Server.Evict].
+	evict func(hash blossom.Hash)
+
+	mu          sync.RWMutex
+	quarantined map[blossom.Hash]bool
+}
+
+// NewTakedown returns a [Takedown] that applies the rules to the reports stored in the store,
+// deleting the blobs with the delete function. It panics if the store or the delete function are nil.
+func NewTakedown(store Store, delete DeleteFunc, rules ...RuleFunc) *Takedown {
+	if store == nil {
+		panic("reports.NewTakedown: store must not be nil")
+	}
+	if delete == nil {
+		panic("reports.NewTakedown: delete function must not be nil")
+	}
+
+	return &Takedown{
+		store:       store,
+		delete:      delete,
+		rules:       rules,
+		quarantined: make(map[blossom.Hash]bool),
+	}
+}
+
+var _ blossy.Plugin = (*Takedown)(nil)
+
+// Name returns "takedown", the name of the takedown as a [blossy.Plugin].
+func (t *Takedown) Name() string { return "takedown" }
+
+// Setup binds the takedown to the server, so that it can be installed as a [blossy.Plugin]. See [Takedown.Bind].
+func (t *Takedown) Setup(s *blossy.Server) error {
+	t.Bind(s)
+	return nil
+}
+
+// Bind stores the reports received by the server (see [Bind]) and applies the rules to them.
+// The Download and Check endpoints reply with 451 Unavailable For Legal Reasons for the quarantined blobs,
+// and the deleted blobs are evicted from the blob cache of the server.
+func (t *Takedown) Bind(s *blossy.Server) {
+	Bind(s, t.store)
+	t.evict = s.Evict
+
+	next := s.On.Report
+	s.On.Report = func(r blossy.Request, report blossy.Report) *blossom.Error {
+		if err := next(r, report); err != nil {
+			return err
+		}
+		if err := t.Apply(r.Context(), report); err != nil {
+			return blossom.ErrInternal(err.Error())
+		}
+		return nil
+	}
+
+	s.Reject.Download.Prepend(func(r blossy.Request, hash blossom.Hash, ext string) *blossom.Error {
+		return t.checkQuarantine(hash)
+	})
+	s.Reject.Check.Prepend(func(r blossy.Request, hash blossom.Hash, ext string) *blossom.Error {
+		return t.checkQuarantine(hash)
+	})
+}
+
+func (t *Takedown) checkQuarantine(hash blossom.Hash) *blossom.Error {
+	if t.IsQuarantined(hash) {
+		return blossy.ErrBlocked("The blob is quarantined pending review")
+	}
+	return nil
+}
+
+// Apply applies the rules to every blob of the stored report, taking the most severe action for each.
+func (t *Takedown) Apply(ctx context.Context, report blossy.Report) error {
+	var errs []error
+	for _, hash := range report.Hashes() {
+		action := ActionNone
+		for _, rule := range t.rules {
+			a, err := rule(ctx, t.store, report, hash)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			action = max(action, a)
+		}
+
+		switch action {
+		case ActionQuarantine:
+			t.Quarantine(hash)
+
+		case ActionDelete:
+			if err := t.Resolve(ctx, hash, StatusRemoved, "automatic takedown"); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Resolve resolves the reports of the blob, deleting it if the status is [StatusRemoved],
+// and releases it from the quarantine.
+func (t *Takedown) Resolve(ctx context.Context, hash blossom.Hash, status Status, note string) error {
+	if !status.Valid() || status == StatusOpen {
+		return ErrInvalidStatus
+	}
+
+	if status == StatusRemoved {
+		if err := t.delete(ctx, hash); err != nil {
+			return err
+		}
+		if t.evict != nil {
+			t.evict(hash)
+		}
+	}
+
+	if _, err := t.store.Resolve(ctx, hash, status, note); err != nil {
+		return err
+	}
+
+	t.Release(hash)
+	return nil
+}
+
+// Quarantine stops serving the blob until it's released.
+func (t *Takedown) Quarantine(hash blossom.Hash) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.quarantined[hash] = true
+}
+
+// Release serves the blob again, if it was quarantined.
+func (t *Takedown) Release(hash blossom.Hash) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.quarantined, hash)
+}
+
+// IsQuarantined reports whether the blob is quarantined.
+func (t *Takedown) IsQuarantined(hash blossom.Hash) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.quarantined[hash]
+}
@@ -0,0 +1,142 @@
+package reports
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/blossytest"
+	"github.com/pippellia-btc/blossy/cache"
+)
+
+var carol = strings.Repeat("c", 64)
+
+type deleter struct {
+	deleted []blossom.Hash
+}
+
+func (d *deleter) delete(ctx context.Context, hash blossom.Hash) error {
+	d.deleted = append(d.deleted, hash)
+	return nil
+}
+
+func TestTakedown(t *testing.T) {
+	ctx := t.Context()
+	store := NewMemory()
+	d := &deleter{}
+
+	config := Config{Moderators: []string{carol}, DeleteThreshold: 3, QuarantineThreshold: 2}
+	takedown := NewTakedown(store, d.delete, config.Rules()...)
+
+	add := func(r blossy.Report) {
+		t.Helper()
+		if err := store.Add(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+		if err := takedown.Apply(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	add(report(alice, blossy.ReportedBlob{Hash: hash1}))
+	if takedown.IsQuarantined(hash1) || len(d.deleted) > 0 {
+		t.Fatal("expected no action after a single report")
+	}
+
+	add(report(bob, blossy.ReportedBlob{Hash: hash1}))
+	if !takedown.IsQuarantined(hash1) {
+		t.Fatal("expected the blob to be quarantined after two reports")
+	}
+
+	add(report(carol, blossy.ReportedBlob{Hash: hash1}, blossy.ReportedBlob{Hash: hash2}))
+	if !slices.Equal(d.deleted, []blossom.Hash{hash1, hash2}) {
+		t.Fatalf("expected the blobs reported by the moderator to be deleted, got %v", d.deleted)
+	}
+	if takedown.IsQuarantined(hash1) {
+		t.Error("expected the deleted blob to be released from the quarantine")
+	}
+
+	entries, err := store.List(ctx, Filter{Hash: hash1, Status: StatusRemoved})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("expected the reports of the deleted blob to be resolved, got %+v", entries)
+	}
+}
+
+func TestTakedownBind(t *testing.T) {
+	server := blossytest.NewTestServer(t)
+	takedown := NewTakedown(NewMemory(), (&deleter{}).delete, DistinctReporters(1, ActionQuarantine))
+	takedown.Bind(server.Blossy)
+
+	client := server.Client(t, blossytest.NewSigner(t))
+	desc, err := client.Upload(t.Context(), strings.NewReader("hello"), "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := nostr.Event{
+		Kind:      nostr.KindReporting,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"x", desc.Hash.Hex(), "illegal"}},
+	}
+	if err := event.Sign(nostr.GeneratePrivateKey()); err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := event.MarshalJSON()
+	res := server.Do(t, server.NewRequest(t, http.MethodPut, "/report", bytes.NewReader(body)))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d (%s)", res.StatusCode, res.Header.Get("X-Reason"))
+	}
+
+	res = server.Do(t, server.NewRequest(t, http.MethodGet, "/"+desc.Hash.Hex(), nil))
+	if res.StatusCode != http.StatusUnavailableForLegalReasons {
+		t.Fatalf("expected 451 for the quarantined blob, got %d", res.StatusCode)
+	}
+
+	takedown.Release(desc.Hash)
+	res = server.Do(t, server.NewRequest(t, http.MethodGet, "/"+desc.Hash.Hex(), nil))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 after the release, got %d", res.StatusCode)
+	}
+}
+
+func TestTakedownEvict(t *testing.T) {
+	server := blossytest.NewTestServer(t, blossy.WithBlobCache(cache.NewMemory()))
+	signer := blossytest.NewSigner(t)
+	owner := blossytest.Pubkey(t, signer)
+
+	deleteBlob := func(ctx context.Context, hash blossom.Hash) error {
+		return server.Store.Delete(ctx, owner, hash)
+	}
+	takedown := NewTakedown(NewMemory(), deleteBlob, Moderators([]string{carol}, ActionDelete))
+	takedown.Bind(server.Blossy)
+
+	desc, err := server.Client(t, signer).Upload(t.Context(), strings.NewReader("hello"), "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first download caches the blob
+	res := server.Do(t, server.NewRequest(t, http.MethodGet, "/"+desc.Hash.Hex(), nil))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", res.StatusCode)
+	}
+
+	if err := takedown.Resolve(t.Context(), desc.Hash, StatusRemoved, "illegal"); err != nil {
+		t.Fatal(err)
+	}
+
+	res = server.Do(t, server.NewRequest(t, http.MethodGet, "/"+desc.Hash.Hex(), nil))
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for the deleted blob, got %d", res.StatusCode)
+	}
+}
@@ -6,9 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/pippellia-btc/blossom"
@@ -21,11 +25,12 @@ import (
 // contextual information, as well as the underlying raw http.Request.
 type Request interface {
 	// ID is the unique identifier of the request, useful for logging or tracking.
+	// It's the same ID the server uses in its logs, and optionally echoes in the 'X-Request-ID' header.
 	ID() int64
 
 	// IP address where the request comes from.
-	// For rate-limiting purposes you should use [IP.Group] or [IP.GroupPrefix]
-	// as a normalized representation of the IP.
+	// For rate-limiting purposes you should use [IP.Group], which groups it as configured
+	// with [WithIPGrouping], or [IP.GroupPrefix] as a normalized representation of the IP.
 	IP() IP
 
 	// Pubkey that signed a valid authorization event if present, otherwise "".
@@ -40,6 +45,15 @@ type Request interface {
 
 	// Raw returns the underlying [http.Request] as it was received.
 	Raw() *http.Request
+
+	// Set stores the value under the key for the lifetime of the request, so that hooks can pass data
+	// to the hooks that run after them (e.g. a Reject hook that detects the user tier, and the On hook that uses it).
+	// As with [context.WithValue], keys should be of unexported types to avoid collisions between packages.
+	// It's safe for concurrent use.
+	Set(key, value any)
+
+	// Get returns the value stored under the key with Set, or nil if there is none.
+	Get(key any) any
 }
 
 type request struct {
@@ -47,6 +61,9 @@ type request struct {
 	ip     IP
 	pubkey string
 	raw    *http.Request
+
+	// state is shared by all the copies of the request, and holds its values.
+	state *requestState
 }
 
 func (r request) ID() int64                { return r.id }
@@ -56,23 +73,127 @@ func (r request) IsAuthed() bool           { return r.pubkey != "" }
 func (r request) Context() context.Context { return r.raw.Context() }
 func (r request) Raw() *http.Request       { return r.raw }
 
+func (r request) Set(key, value any) {
+	if r.state == nil {
+		return
+	}
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+
+	if r.state.values == nil {
+		r.state.values = make(map[any]any)
+	}
+	r.state.values[key] = value
+}
+
+func (r request) Get(key any) any {
+	if r.state == nil {
+		return nil
+	}
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+	return r.state.values[key]
+}
+
+type ctxKey int
+
+const stateKey ctxKey = iota
+
+// requestState is shared by all the representations of a request during its lifetime.
+// It's created when the request is routed by the server, and stored in its context.
+type requestState struct {
+	id int64
+
+	// parsed is the request as parsed by its handler, if any.
+	parsed *request
+
+	// header is the header of the response.
+	header http.Header
+
+	// hash is the hash of the blob of the request, if known.
+	hash *blossom.Hash
+
+	// rejection is the structured rejection recorded with [Rejected], if any.
+	rejection *Rejection
+
+	// values are the values set with [Request.Set], guarded by mu.
+	mu     sync.Mutex
+	values map[any]any
+}
+
+// recordHash records the hash of the blob of the request in its state and in its span.
+func recordHash(r *http.Request, hash blossom.Hash) {
+	if state, ok := stateOf(r); ok {
+		state.hash = &hash
+	}
+	traceHash(r, hash)
+}
+
+func stateOf(r *http.Request) (*requestState, bool) {
+	state, ok := r.Context().Value(stateKey).(*requestState)
+	return state, ok
+}
+
+// ResponseHeader returns the header of the response to the request, which hooks can modify
+// to accompany their errors with additional headers (e.g. 'X-Lightning' for payments).
+// If the request has not been routed by the server (e.g. it was created with [NewTestRequest]),
+// it returns an empty header, and its changes are discarded.
+func ResponseHeader(r Request) http.Header {
+	if state, ok := stateOf(r.Raw()); ok && state.header != nil {
+		return state.header
+	}
+	return make(http.Header)
+}
+
+// newRequest returns the [request] with the provided pubkey, and records it in the state of the http request.
+// If the http request has not been routed (e.g. a handler was called directly), it assigns a new ID.
+func (s *Server) newRequest(r *http.Request, pubkey string) request {
+	req := request{
+		ip:     s.ip(r),
+		pubkey: pubkey,
+		raw:    r,
+	}
+
+	state, ok := stateOf(r)
+	if !ok {
+		req.id = s.nextRequest.Add(1)
+		req.state = &requestState{id: req.id}
+		return req
+	}
+
+	req.id = state.id
+	req.state = state
+	state.parsed = &req
+	return req
+}
+
+// authenticate validates the authorization event of the request against the server hostname and the hash,
+// and returns its pubkey. See [auth.Authenticate] for the details.
+func (s *Server) authenticate(r *http.Request, hash *blossom.Hash) (string, error) {
+	return auth.AuthenticateWith(r, s.Sys.hostname, hash, s.settings.Auth)
+}
+
+// logger returns the server logger, with the ID of the request as an attribute.
+func (s *Server) logger(r *http.Request) *slog.Logger {
+	if state, ok := stateOf(r); ok {
+		return s.log.With("request_id", state.id)
+	}
+	return s.log
+}
+
 func (s *Server) parseFetch(r *http.Request) (request, blossom.Hash, string, *blossom.Error) {
 	hash, ext, err := utils.ParseHashExt(r.URL.Path)
 	if err != nil {
 		return request{}, blossom.Hash{}, "", blossom.ErrBadRequest(err.Error())
 	}
+	recordHash(r, hash)
 
-	pubkey, err := auth.Authenticate(r, s.Sys.hostname, &hash)
+	pubkey, err := s.authenticate(r, &hash)
 	if err != nil {
 		return request{}, blossom.Hash{}, "", blossom.ErrUnauthorized(err.Error())
 	}
 
-	req := request{
-		id:     s.nextRequest.Add(1),
-		ip:     GetIP(r),
-		pubkey: pubkey,
-		raw:    r,
-	}
+	req := s.newRequest(r, pubkey)
 	return req, hash, ext, nil
 }
 
@@ -81,18 +202,14 @@ func (s *Server) parseDelete(r *http.Request) (request, blossom.Hash, *blossom.E
 	if err != nil {
 		return request{}, blossom.Hash{}, blossom.ErrBadRequest(err.Error())
 	}
+	recordHash(r, hash)
 
-	pubkey, err := auth.Authenticate(r, s.Sys.hostname, &hash)
+	pubkey, err := s.authenticate(r, &hash)
 	if err != nil {
 		return request{}, blossom.Hash{}, blossom.ErrUnauthorized(err.Error())
 	}
 
-	req := request{
-		id:     s.nextRequest.Add(1),
-		ip:     GetIP(r),
-		pubkey: pubkey,
-		raw:    r,
-	}
+	req := s.newRequest(r, pubkey)
 	return req, hash, nil
 }
 
@@ -102,7 +219,8 @@ func (s *Server) parseUpload(r *http.Request) (request, UploadHints, io.ReadClos
 		Size: -1, // stands for unknown
 	}
 
-	if cl := r.Header.Get("Content-Length"); cl != "" {
+	multipart := s.settings.Upload.multipart && isMultipart(r)
+	if cl := r.Header.Get("Content-Length"); cl != "" && !multipart {
 		size, err := strconv.ParseInt(cl, 10, 64)
 		if err != nil {
 			return request{}, UploadHints{}, nil, blossom.ErrBadRequest("'Content-Length' header is invalid: " + err.Error())
@@ -113,15 +231,16 @@ func (s *Server) parseUpload(r *http.Request) (request, UploadHints, io.ReadClos
 		hints.Size = size
 	}
 
-	if sha := r.Header.Get("Content-Digest"); sha != "" {
-		hash, err := blossom.ParseHash(sha)
-		if err != nil {
-			return request{}, UploadHints{}, nil, blossom.ErrBadRequest("'Content-Digest' header is invalid: " + err.Error())
-		}
-		hints.Hash = &hash
+	hash, rerr := s.parseDigest(r.Header)
+	if rerr != nil {
+		return request{}, UploadHints{}, nil, rerr
+	}
+	hints.Hash = hash
+	if hash != nil {
+		recordHash(r, *hash)
 	}
 
-	pubkey, err := auth.Authenticate(r, s.Sys.hostname, hints.Hash)
+	pubkey, err := s.authenticate(r, hints.Hash)
 	if errors.Is(err, auth.ErrMissingHash) {
 		return request{}, UploadHints{}, nil, blossom.ErrBadRequest("'Content-Digest' header is missing or empty")
 	}
@@ -129,13 +248,69 @@ func (s *Server) parseUpload(r *http.Request) (request, UploadHints, io.ReadClos
 		return request{}, UploadHints{}, nil, blossom.ErrUnauthorized(err.Error())
 	}
 
-	req := request{
-		id:     s.nextRequest.Add(1),
-		ip:     GetIP(r),
-		pubkey: pubkey,
-		raw:    r,
+	req := s.newRequest(r, pubkey)
+	if !multipart {
+		return req, hints, r.Body, nil
+	}
+
+	part, rerr := filePart(r)
+	if rerr != nil {
+		return request{}, UploadHints{}, nil, rerr
 	}
-	return req, hints, r.Body, nil
+	hints.Type = partType(part)
+	return req, hints, part, nil
+}
+
+// parseDigest returns the hash declared by the 'Content-Digest' or 'Repr-Digest' headers of an upload,
+// according to the accepted [DigestScheme]. It returns nil if no hash was declared.
+func (s *Server) parseDigest(h http.Header) (*blossom.Hash, *blossom.Error) {
+	name := "Content-Digest"
+	value := h.Get(name)
+	if value == "" {
+		name = "Repr-Digest"
+		value = h.Get(name)
+	}
+	if value == "" {
+		return nil, nil
+	}
+
+	scheme := s.settings.Upload.digest
+	structured := utils.IsStructuredDigest(value)
+
+	if !structured && (scheme == DigestStructured || name == "Repr-Digest") {
+		return nil, blossom.ErrBadRequest(fmt.Sprintf("'%s' header is invalid: must be of the form 'sha-256=:<base64>:' (RFC 9530)", name))
+	}
+	if structured && scheme == DigestLegacy {
+		return nil, blossom.ErrBadRequest(fmt.Sprintf("'%s' header is invalid: must be the hex encoded sha256 of the blob", name))
+	}
+
+	var hash blossom.Hash
+	var err error
+	if structured {
+		hash, err = utils.ParseDigest(value)
+	} else {
+		hash, err = blossom.ParseHash(value)
+	}
+	if err != nil {
+		return nil, blossom.ErrBadRequest(fmt.Sprintf("'%s' header is invalid: %v", name, err))
+	}
+	return &hash, nil
+}
+
+// verifyUpload returns whether the body of the upload must be verified against the declared hash.
+// Structured digests describe the body by definition, so they are always verified.
+func (s *Server) verifyUpload(r *http.Request, hints UploadHints) bool {
+	if s.settings.Upload.verify {
+		return true
+	}
+	if hints.Hash == nil {
+		return false
+	}
+	return utils.IsStructuredDigest(r.Header.Get("Content-Digest")) || r.Header.Get("Repr-Digest") != ""
+}
+
+func errDigestMismatch() *blossom.Error {
+	return blossom.ErrBadRequest("the sha256 of the body doesn't match the 'Content-Digest' header")
 }
 
 func (s *Server) parseUploadCheck(r *http.Request) (request, UploadHints, *blossom.Error) {
@@ -164,24 +339,21 @@ func (s *Server) parseUploadCheck(r *http.Request) (request, UploadHints, *bloss
 	if err != nil {
 		return request{}, UploadHints{}, blossom.ErrBadRequest("'X-SHA-256' header is invalid: " + err.Error())
 	}
+	recordHash(r, hash)
 
 	hints := UploadHints{
-		Hash: &hash,
-		Type: ct,
-		Size: size,
+		Hash:     &hash,
+		Type:     ct,
+		Size:     size,
+		PreCheck: true,
 	}
 
-	pubkey, err := auth.Authenticate(r, s.Sys.hostname, hints.Hash)
+	pubkey, err := s.authenticate(r, hints.Hash)
 	if err != nil {
 		return request{}, UploadHints{}, blossom.ErrUnauthorized(err.Error())
 	}
 
-	req := request{
-		id:     s.nextRequest.Add(1),
-		ip:     GetIP(r),
-		pubkey: pubkey,
-		raw:    r,
-	}
+	req := s.newRequest(r, pubkey)
 	return req, hints, nil
 }
 
@@ -212,20 +384,101 @@ func (s *Server) parseMirror(r *http.Request) (request, *url.URL, *blossom.Error
 		return request{}, nil, blossom.ErrBadRequest("invalid blossom URL: " + err.Error())
 	}
 
-	pubkey, err := auth.Authenticate(r, s.Sys.hostname, &hash)
+	pubkey, err := s.authenticate(r, &hash)
 	if err != nil {
 		return request{}, nil, blossom.ErrUnauthorized(err.Error())
 	}
 
-	req := request{
-		id:     s.nextRequest.Add(1),
-		ip:     GetIP(r),
-		pubkey: pubkey,
-		raw:    r,
-	}
+	req := s.newRequest(r, pubkey)
 	return req, url, nil
 }
 
+func (s *Server) parseList(r *http.Request) (request, string, ListQuery, *blossom.Error) {
+	pubkey := strings.TrimPrefix(r.URL.Path, "/list/")
+	if err := utils.ValidatePubkey(pubkey); err != nil {
+		return request{}, "", ListQuery{}, blossom.ErrBadRequest("invalid pubkey: " + err.Error())
+	}
+
+	var query ListQuery
+	params := r.URL.Query()
+
+	if since := params.Get("since"); since != "" {
+		unix, err := strconv.ParseInt(since, 10, 64)
+		if err != nil {
+			return request{}, "", ListQuery{}, blossom.ErrBadRequest("'since' query parameter is invalid: " + err.Error())
+		}
+		query.Since = time.Unix(unix, 0).UTC()
+	}
+
+	if until := params.Get("until"); until != "" {
+		unix, err := strconv.ParseInt(until, 10, 64)
+		if err != nil {
+			return request{}, "", ListQuery{}, blossom.ErrBadRequest("'until' query parameter is invalid: " + err.Error())
+		}
+		query.Until = time.Unix(unix, 0).UTC()
+	}
+
+	if !query.Since.IsZero() && !query.Until.IsZero() && query.Since.After(query.Until) {
+		return request{}, "", ListQuery{}, blossom.ErrBadRequest("'since' must not be after 'until'")
+	}
+
+	for _, value := range params["type"] {
+		for pattern := range strings.SplitSeq(value, ",") {
+			pattern = strings.TrimSpace(pattern)
+			if err := utils.ValidateTypePattern(pattern); err != nil {
+				return request{}, "", ListQuery{}, blossom.ErrBadRequest("'type' query parameter is invalid: " + err.Error())
+			}
+			query.Types = append(query.Types, pattern)
+		}
+	}
+
+	switch order := SortOrder(params.Get("order")); order {
+	case "", Newest, Oldest:
+		query.Order = order
+	default:
+		return request{}, "", ListQuery{}, blossom.ErrBadRequest("'order' query parameter must be 'asc' or 'desc'")
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return request{}, "", ListQuery{}, blossom.ErrBadRequest("'limit' query parameter must be a positive integer")
+		}
+		query.Limit = n
+	}
+
+	if cursor := params.Get("cursor"); cursor != "" {
+		hash, err := blossom.ParseHash(cursor)
+		if err != nil {
+			return request{}, "", ListQuery{}, blossom.ErrBadRequest("'cursor' query parameter is invalid: " + err.Error())
+		}
+		query.Cursor = &hash
+	}
+
+	pk, err := s.authenticate(r, nil)
+	if err != nil {
+		return request{}, "", ListQuery{}, blossom.ErrUnauthorized(err.Error())
+	}
+
+	req := s.newRequest(r, pk)
+	return req, pubkey, query, nil
+}
+
+func (s *Server) parseListStats(r *http.Request) (request, string, *blossom.Error) {
+	pubkey := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/list/"), "/stats")
+	if err := utils.ValidatePubkey(pubkey); err != nil {
+		return request{}, "", blossom.ErrBadRequest("invalid pubkey: " + err.Error())
+	}
+
+	pk, err := s.authenticate(r, nil)
+	if err != nil {
+		return request{}, "", blossom.ErrUnauthorized(err.Error())
+	}
+
+	req := s.newRequest(r, pk)
+	return req, pubkey, nil
+}
+
 func (s *Server) parseReport(r *http.Request) (request, Report, *blossom.Error) {
 	body, rerr := utils.ReadNoMore(r.Body, 100_000) // ~100 KB
 	if rerr != nil {
@@ -242,11 +495,7 @@ func (s *Server) parseReport(r *http.Request) (request, Report, *blossom.Error)
 		return request{}, Report{}, blossom.ErrBadRequest(err.Error())
 	}
 
-	req := request{
-		id:  s.nextRequest.Add(1),
-		ip:  GetIP(r),
-		raw: r,
-	}
+	req := s.newRequest(r, "")
 	return req, report, nil
 }
 
@@ -0,0 +1,107 @@
+package blossy
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy/auth"
+)
+
+var (
+	digestData       = []byte("hello digest")
+	digestHash       = blossom.ComputeHash(digestData)
+	digestStructured = "sha-256=:" + base64.StdEncoding.EncodeToString(digestHash[:]) + ":"
+)
+
+func TestParseDigest(t *testing.T) {
+	tests := []struct {
+		name    string
+		scheme  DigestScheme
+		header  string
+		value   string
+		want    *blossom.Hash
+		isValid bool
+	}{
+		{"no digest", DigestAny, "Content-Digest", "", nil, true},
+		{"legacy", DigestAny, "Content-Digest", digestHash.Hex(), &digestHash, true},
+		{"structured", DigestAny, "Content-Digest", digestStructured, &digestHash, true},
+		{"structured repr", DigestAny, "Repr-Digest", digestStructured, &digestHash, true},
+		{"legacy repr", DigestAny, "Repr-Digest", digestHash.Hex(), nil, false},
+		{"invalid base64", DigestAny, "Content-Digest", "sha-256=:not base64:", nil, false},
+		{"unsupported algorithm", DigestAny, "Content-Digest", "sha-512=:" + base64.StdEncoding.EncodeToString(digestHash[:]) + ":", nil, false},
+		{"structured only", DigestStructured, "Content-Digest", digestStructured, &digestHash, true},
+		{"structured only with legacy", DigestStructured, "Content-Digest", digestHash.Hex(), nil, false},
+		{"legacy only", DigestLegacy, "Content-Digest", digestHash.Hex(), &digestHash, true},
+		{"legacy only with structured", DigestLegacy, "Content-Digest", digestStructured, nil, false},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			server, err := NewServer(WithHostname(testHostname), WithDigestScheme(test.scheme))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := http.Header{}
+			if test.value != "" {
+				h.Set(test.header, test.value)
+			}
+
+			hash, rerr := server.parseDigest(h)
+			if !test.isValid {
+				if rerr == nil {
+					t.Fatalf("expected an error for %s: %s, got nil", test.header, test.value)
+				}
+				return
+			}
+
+			if rerr != nil {
+				t.Fatalf("unexpected error: %v", rerr)
+			}
+			if (hash == nil) != (test.want == nil) || hash != nil && *hash != *test.want {
+				t.Errorf("expected hash %v, got %v", test.want, hash)
+			}
+		})
+	}
+}
+
+func TestContentDigest(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		body   string
+		status int
+	}{
+		{"structured match", "Content-Digest", digestStructured, string(digestData), http.StatusOK},
+		{"structured mismatch", "Content-Digest", digestStructured, "another body", http.StatusBadRequest},
+		{"repr match", "Repr-Digest", digestStructured, string(digestData), http.StatusOK},
+		{"repr mismatch", "Repr-Digest", digestStructured, "another body", http.StatusBadRequest},
+		{"invalid", "Content-Digest", "sha-256=:not base64:", string(digestData), http.StatusBadRequest},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			// structured digests are verified even without WithUploadVerification
+			server, ts := newTestServer(t)
+			stored := storeUploads(server)
+
+			r, _ := http.NewRequest(http.MethodPut, ts.URL+"/upload", strings.NewReader(test.body))
+			r.Header.Set(test.header, test.value)
+			authorize(t, r, nostr.GeneratePrivateKey(), auth.ActionUpload, digestHash)
+
+			res := do(t, r)
+			if res.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d (%s)", test.status, res.StatusCode, res.Header.Get("X-Reason"))
+			}
+			if accepted := test.status == http.StatusOK; (stored() == 1) != accepted {
+				t.Errorf("expected the blob to be stored only if accepted, got %d blobs", stored())
+			}
+		})
+	}
+}
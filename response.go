@@ -0,0 +1,125 @@
+package blossy
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+// responseWriter wraps an [http.ResponseWriter], recording the status code and the number of bytes written.
+// It implements [io.ReaderFrom] and [http.Flusher] when the underlying writer does, and supports
+// [http.ResponseController] through Unwrap.
+type responseWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	return &responseWriter{ResponseWriter: w}
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// ReadFrom preserves the optimizations (e.g. sendfile) of the underlying writer, if any.
+func (w *responseWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	var n int64
+	var err error
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(w.ResponseWriter, r)
+	}
+	w.written += n
+	return n, err
+}
+
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Status returns the status code of the response. If nothing has been written, it returns 200,
+// which is what the http server sends in that case.
+func (w *responseWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Written returns the number of bytes written in the response body.
+func (w *responseWriter) Written() int64 {
+	return w.written
+}
+
+// countingReader wraps a request body, counting the number of bytes read.
+type countingReader struct {
+	r io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) Close() error {
+	return c.r.Close()
+}
+
+// endpointLabel returns the label of the endpoint for observability purposes.
+func endpointLabel(e Endpoint) string {
+	if e == "" {
+		return "other"
+	}
+	return string(e)
+}
+
+// observeRejection records that a request to the endpoint was rejected by a policy or a Reject hook.
+// Blocked blobs (see [ErrBlocked]) are recorded separately.
+func (s *Server) observeRejection(e Endpoint, err *blossom.Error) {
+	if err.Code == http.StatusUnavailableForLegalReasons {
+		s.metrics.ObserveBlocked(endpointLabel(e))
+		return
+	}
+	s.metrics.ObserveRejection(endpointLabel(e), err.Code)
+}
+
+// observeHookError records that an On hook of the endpoint returned an error.
+// Blocked blobs (see [ErrBlocked]) are recorded separately.
+func (s *Server) observeHookError(e Endpoint, err *blossom.Error) {
+	if err.Code == http.StatusUnavailableForLegalReasons {
+		s.metrics.ObserveBlocked(endpointLabel(e))
+		return
+	}
+	s.metrics.ObserveHookError(endpointLabel(e), err.Code)
+}
@@ -0,0 +1,391 @@
+package blossy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+// DefaultSessionTTL is how long an upload session can be resumed after its creation, if not configured.
+// See [WithResumableUploads].
+const DefaultSessionTTL = 24 * time.Hour
+
+var (
+	// ErrSessionNotFound is returned by a [SessionStore] when the upload session doesn't exist.
+	ErrSessionNotFound = errors.New("upload session not found")
+
+	// ErrOffsetMismatch is returned by a [SessionStore] when data is appended at an offset
+	// that is not the current one of the upload session.
+	ErrOffsetMismatch = errors.New("upload offset doesn't match the one of the session")
+)
+
+// UploadSession is the state of a resumable upload. See [WithResumableUploads].
+type UploadSession struct {
+	// ID is the random identifier of the session, which is also the capability to resume it.
+	ID string `json:"id"`
+
+	// Pubkey is the pubkey that created the session. It's empty if the request was not authenticated.
+	Pubkey string `json:"pubkey,omitempty"`
+
+	// Hash, Type and Length are the declared sha256, content type and size in bytes of the blob.
+	Hash   blossom.Hash `json:"hash"`
+	Type   string       `json:"type"`
+	Length int64        `json:"length"`
+
+	// Offset is the number of bytes of the blob uploaded so far.
+	Offset int64 `json:"offset"`
+
+	// Expires is the time after which the session can no longer be resumed.
+	Expires time.Time `json:"expires"`
+}
+
+// Complete reports whether all the bytes of the blob have been uploaded.
+func (u UploadSession) Complete() bool {
+	return u.Offset >= u.Length
+}
+
+// SessionStore stores the state and the partial data of resumable uploads.
+// The blossy/sessions package provides an implementation on the local filesystem.
+//
+// Implementations must be safe for concurrent use. The server never appends to the same session concurrently.
+type SessionStore interface {
+	// Create stores a new session, with no data. The offset of the session is ignored.
+	Create(ctx context.Context, session UploadSession) error
+
+	// Get returns the session with the provided id, with its current offset, or [ErrSessionNotFound].
+	Get(ctx context.Context, id string) (UploadSession, error)
+
+	// Append writes the data at the end of the session, and returns the new offset.
+	// It returns [ErrOffsetMismatch] if the offset is not the current one of the session.
+	// If writing fails midway, the bytes written are kept, and the returned offset accounts for them.
+	Append(ctx context.Context, id string, offset int64, data io.Reader) (int64, error)
+
+	// Open returns the data uploaded in the session, or [ErrSessionNotFound].
+	Open(ctx context.Context, id string) (io.ReadCloser, error)
+
+	// Delete removes the session and its data, or returns [ErrSessionNotFound].
+	Delete(ctx context.Context, id string) error
+
+	// Prune removes the sessions that expired before the provided time.
+	Prune(ctx context.Context, before time.Time) error
+}
+
+// resumable are the settings of the resumable uploads.
+type resumable struct {
+	store SessionStore
+	ttl   time.Duration
+
+	// busy are the IDs of the sessions that are being appended to.
+	busy sync.Map
+}
+
+func newSessionID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// parseSessionID returns the ID of the session in the path /upload/<session>, if valid.
+func parseSessionID(path string) (string, bool) {
+	id, ok := strings.CutPrefix(path, "/upload/")
+	if !ok || len(id) != 32 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", false
+	}
+	return id, true
+}
+
+// setSessionHeaders writes the state of the session in the headers of the response.
+func setSessionHeaders(w http.ResponseWriter, session UploadSession) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(session.Length, 10))
+	w.Header().Set("Upload-Expires", session.Expires.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// HandleUploadCreate handles the POST /upload endpoint, which creates a resumable upload session.
+// The blob is declared with the same headers of HEAD /upload (BUD-06): 'X-SHA-256', 'X-Content-Type'
+// and 'X-Content-Length', and the request is authorized as a PUT /upload.
+// It responds with 201 (Created) and the path of the session in the 'Location' header.
+func (s *Server) HandleUploadCreate(w http.ResponseWriter, r *http.Request) {
+	if s.On.Upload == nil {
+		// upload endpoint is optional
+		err := blossom.ErrNotImplemented("The Upload hook is not configured")
+		blossom.WriteError(w, err)
+		return
+	}
+
+	req, hints, err := s.parseUploadCheck(r)
+	if err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+	hints.PreCheck = false
+
+	if err = s.checkPolicy(w, EndpointUpload, req); err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	if _, err = s.checkUpload(EndpointUpload, hints, nil); err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	if err = s.checkBlocklist(EndpointUpload, *hints.Hash); err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	for _, reject := range s.Reject.Upload.hooks {
+		if err = reject(req, hints); err != nil {
+			s.observeRejection(EndpointUpload, err)
+			blossom.WriteError(w, err)
+			return
+		}
+	}
+
+	sessions := s.settings.Upload.sessions
+	session := UploadSession{
+		ID:      newSessionID(),
+		Pubkey:  req.Pubkey(),
+		Hash:    *hints.Hash,
+		Type:    hints.Type,
+		Length:  hints.Size,
+		Expires: time.Now().Add(sessions.ttl),
+	}
+
+	if err := sessions.store.Create(r.Context(), session); err != nil {
+		s.logger(r).Error("handle upload create: failed to create session", "error", err)
+		blossom.WriteError(w, WrapError(err))
+		return
+	}
+
+	w.Header().Set("Location", s.settings.HTTP.pathPrefix+"/upload/"+session.ID)
+	setSessionHeaders(w, session)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// HandleUploadSession handles the /upload/<session> endpoint of resumable uploads:
+//   - HEAD returns the state of the session in the 'Upload-Offset', 'Upload-Length' and 'Upload-Expires' headers.
+//   - PATCH appends its body to the session, at the offset in the 'Upload-Offset' header, which must be the current one.
+//     The body must have the 'application/offset+octet-stream' content type. When the blob is complete,
+//     its hash is verified and it's passed to the On.Upload hook, and the response contains the blob descriptor.
+//     Otherwise, the response is 204 (No Content) with the new offset.
+//   - DELETE aborts the session.
+//
+// The session ID is a random capability: requests to the session don't need to be authorized,
+// and the On.Upload hook receives the pubkey that created it. Every PATCH goes through the policies of the
+// Upload endpoint on behalf of that pubkey, and the blob is checked again against the type policies
+// and the blocklist before it's passed to the hook, as they may have changed since the session was created.
+func (s *Server) HandleUploadSession(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseSessionID(r.URL.Path)
+	if !ok {
+		blossom.WriteError(w, blossom.ErrNotFound("Upload session not found"))
+		return
+	}
+
+	session, err := s.getSession(r.Context(), id)
+	if err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		setSessionHeaders(w, session)
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodPatch:
+		s.appendSession(w, r, session)
+
+	case http.MethodDelete:
+		if err := s.settings.Upload.sessions.store.Delete(r.Context(), id); err != nil {
+			blossom.WriteError(w, WrapError(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		handleUnsupported(w, r)
+	}
+}
+
+// getSession returns the session with the provided id, or 404 (Not Found) if it doesn't exist or it's expired.
+func (s *Server) getSession(ctx context.Context, id string) (UploadSession, *blossom.Error) {
+	store := s.settings.Upload.sessions.store
+	session, err := store.Get(ctx, id)
+	if err != nil {
+		return UploadSession{}, WrapError(err)
+	}
+
+	if time.Now().After(session.Expires) {
+		store.Delete(ctx, id)
+		return UploadSession{}, WrapError(ErrSessionNotFound)
+	}
+	return session, nil
+}
+
+func (s *Server) appendSession(w http.ResponseWriter, r *http.Request, session UploadSession) {
+	if ct := r.Header.Get("Content-Type"); ct != "application/offset+octet-stream" {
+		blossom.WriteError(w, blossom.ErrUnsupportedMedia("'Content-Type' header must be 'application/offset+octet-stream'"))
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		blossom.WriteError(w, blossom.ErrBadRequest("'Upload-Offset' header is missing or invalid"))
+		return
+	}
+	if offset != session.Offset {
+		setSessionHeaders(w, session)
+		blossom.WriteError(w, WrapError(ErrOffsetMismatch))
+		return
+	}
+
+	req := s.newRequest(r, session.Pubkey)
+	if err := s.checkPolicy(w, EndpointUpload, req); err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	sessions := s.settings.Upload.sessions
+	if _, busy := sessions.busy.LoadOrStore(session.ID, struct{}{}); busy {
+		blossom.WriteError(w, &blossom.Error{Code: http.StatusConflict, Reason: "the upload session is being resumed by another request"})
+		return
+	}
+	defer sessions.busy.Delete(session.ID)
+
+	release, rerr := s.acquireUpload(w, req)
+	if rerr != nil {
+		s.observeRejection(EndpointUpload, rerr)
+		blossom.WriteError(w, rerr)
+		return
+	}
+	defer release()
+
+	data := io.LimitReader(r.Body, session.Length-offset)
+	session.Offset, err = sessions.store.Append(r.Context(), session.ID, offset, data)
+	setSessionHeaders(w, session)
+	if err != nil {
+		s.logger(r).Error("handle upload session: failed to append data", "error", err, "session", session.ID)
+		blossom.WriteError(w, WrapError(err))
+		return
+	}
+
+	if !session.Complete() {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.completeSession(w, req, session)
+}
+
+// completeSession passes the blob of the complete session to the On.Upload hook, after checking it against
+// the upload policies and the blocklist, and verifying its hash. The session is deleted unless the hook fails
+// with a server error, so that the client can retry the completion by sending an empty PATCH at the final offset.
+func (s *Server) completeSession(w http.ResponseWriter, req request, session UploadSession) {
+	store := s.settings.Upload.sessions.store
+	ctx := req.Context()
+
+	data, err := store.Open(ctx, session.ID)
+	if err != nil {
+		blossom.WriteError(w, WrapError(err))
+		return
+	}
+	defer data.Close()
+
+	hints := UploadHints{Hash: &session.Hash, Type: session.Type, Size: session.Length}
+	body, berr := s.checkUpload(EndpointUpload, hints, data)
+	if berr == nil {
+		berr = s.checkBlocklist(EndpointUpload, session.Hash)
+	}
+	if berr != nil {
+		s.deleteSession(req, session.ID)
+		blossom.WriteError(w, berr)
+		return
+	}
+
+	verifier := utils.NewHashReader(body, hints.Hash)
+	blob, inspector := s.inspectUpload(req, hints, verifier, s.Reject.UploadContent.hooks)
+	blob, blobHints, stripped := s.stripMetadata(EndpointUpload, hints, blob)
+
+	end := s.startHook(&req, EndpointUpload)
+	desc, berr := s.On.Upload(req, blobHints, blob)
+	berr = end(berr)
+
+	switch {
+	case inspector != nil && inspector.rejected != nil:
+		berr = inspector.rejected
+		s.observeRejection(EndpointUpload, berr)
+
+	case verifier.Mismatch():
+		berr = blossom.ErrBadRequest("the sha256 of the uploaded data doesn't match the one of the session")
+
+	case berr != nil:
+		s.observeHookError(EndpointUpload, berr)
+		if berr.Code >= 500 {
+			blossom.WriteError(w, berr)
+			return
+		}
+	}
+
+	s.deleteSession(req, session.ID)
+	if berr != nil {
+		blossom.WriteError(w, berr)
+		return
+	}
+
+	if desc.URL == "" {
+		// derive the URL if not set
+		url, err := s.deriveURL(req.raw, desc)
+		if err != nil {
+			s.logger(req.raw).Error("handle upload session: failed to derive URL", "error", err)
+			blossom.WriteError(w, blossom.ErrInternal(err.Error()))
+			return
+		}
+		desc.URL = url
+	}
+
+	var original *blossom.Hash
+	if stripped {
+		original = hints.Hash
+	}
+	s.writeDescriptor(w, req, desc, original)
+}
+
+// deleteSession deletes the session once its upload is completed or rejected.
+func (s *Server) deleteSession(req request, id string) {
+	if err := s.settings.Upload.sessions.store.Delete(req.Context(), id); err != nil {
+		s.logger(req.raw).Error("failed to delete completed upload session", "error", err, "session", id)
+	}
+}
+
+// pruneSessions periodically removes the expired upload sessions, until the context is cancelled.
+func (s *Server) pruneSessions(ctx context.Context) {
+	sessions := s.settings.Upload.sessions
+	ticker := time.NewTicker(min(sessions.ttl, time.Hour))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := sessions.store.Prune(ctx, time.Now()); err != nil {
+				s.log.Error("failed to prune expired upload sessions", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// Package retention deletes blobs from a blossy server according to configurable rules,
+// such as a maximum age, a maximum total size, or blobs that no nostr event references.
+//
+// A [Policy] periodically loads the metadata of the blobs from a [Source] (typically [index.Index.Blobs]),
+// applies its rules in order, and deletes the selected blobs with a user provided [DeleteFunc].
+// The deleted blobs and the reclaimed bytes are recorded in the metrics of the server, if configured.
+//
+// Example:
+//
+//	policy := retention.New(idx.Blobs, deleteBlob,
+//	    []retention.Rule{
+//	        retention.Unreferenced(time.Hour),
+//	        retention.MaxAge(90 * 24 * time.Hour),
+//	        retention.MaxTotalSize(100 << 30), // 100 GiB
+//	    },
+//	    retention.WithMetrics(m),
+//	)
+//	policy.Bind(server) // runs every hour while the server is serving
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/index"
+	"github.com/pippellia-btc/blossy/metrics"
+)
+
+// Source returns the metadata of the blobs the policy applies to, for example [index.Index.Blobs].
+type Source func(ctx context.Context) ([]index.Blob, error)
+
+// DeleteFunc deletes the blob from the storage, and from the index if any.
+type DeleteFunc func(ctx context.Context, hash blossom.Hash) error
+
+// Rule selects the blobs to delete.
+type Rule struct {
+	// Name identifies the rule in the logs and metrics. It should be a short snake_case string.
+	Name string
+
+	// Select returns the blobs to delete among the provided ones, which are sorted by upload time, oldest first.
+	Select func(ctx context.Context, blobs []index.Blob) ([]index.Blob, error)
+}
+
+// MaxAge returns a [Rule] that deletes the blobs first uploaded more than d ago.
+func MaxAge(d time.Duration) Rule {
+	return Rule{
+		Name: "max_age",
+		Select: func(ctx context.Context, blobs []index.Blob) ([]index.Blob, error) {
+			return filter(blobs, func(b index.Blob) bool { return olderThan(b, d) }), nil
+		},
+	}
+}
+
+// MaxTotalSize returns a [Rule] that deletes the oldest blobs until their total size is at most the provided bytes.
+func MaxTotalSize(bytes int64) Rule {
+	return Rule{
+		Name: "max_total_size",
+		Select: func(ctx context.Context, blobs []index.Blob) ([]index.Blob, error) {
+			var total int64
+			for _, b := range blobs {
+				total += b.Size
+			}
+
+			var selected []index.Blob
+			for _, b := range blobs {
+				if total <= bytes {
+					break
+				}
+				selected = append(selected, b)
+				total -= b.Size
+			}
+			return selected, nil
+		},
+	}
+}
+
+// Unreferenced returns a [Rule] that deletes the blobs that no pubkey owns anymore,
+// if they were first uploaded more than grace ago.
+func Unreferenced(grace time.Duration) Rule {
+	return Rule{
+		Name: "unreferenced",
+		Select: func(ctx context.Context, blobs []index.Blob) ([]index.Blob, error) {
+			return filter(blobs, func(b index.Blob) bool { return b.Refs == 0 && olderThan(b, grace) }), nil
+		},
+	}
+}
+
+// Orphaned returns a [Rule] that deletes the blobs not referenced by any nostr event,
+// as reported by the referenced function (for example by querying a relay for events with their URL or hash),
+// if they were first uploaded more than grace ago, to give uploaders the time to publish their events.
+func Orphaned(referenced func(ctx context.Context, hash blossom.Hash) (bool, error), grace time.Duration) Rule {
+	return Rule{
+		Name: "orphaned",
+		Select: func(ctx context.Context, blobs []index.Blob) ([]index.Blob, error) {
+			return filterErr(ctx, blobs, grace, func(b index.Blob) (bool, error) {
+				ok, err := referenced(ctx, b.Hash)
+				return !ok, err
+			})
+		},
+	}
+}
+
+// Unpaid returns a [Rule] that deletes the blobs whose storage has not been paid, as reported by the paid function,
+// if they were first uploaded more than grace ago.
+func Unpaid(paid func(ctx context.Context, blob index.Blob) (bool, error), grace time.Duration) Rule {
+	return Rule{
+		Name: "unpaid",
+		Select: func(ctx context.Context, blobs []index.Blob) ([]index.Blob, error) {
+			return filterErr(ctx, blobs, grace, func(b index.Blob) (bool, error) {
+				ok, err := paid(ctx, b)
+				return !ok, err
+			})
+		},
+	}
+}
+
+func olderThan(b index.Blob, d time.Duration) bool {
+	return time.Since(time.Unix(b.Uploaded, 0)) > d
+}
+
+func filter(blobs []index.Blob, keep func(index.Blob) bool) []index.Blob {
+	var selected []index.Blob
+	for _, b := range blobs {
+		if keep(b) {
+			selected = append(selected, b)
+		}
+	}
+	return selected
+}
+
+// filterErr selects the blobs older than grace for which the function returns true.
+// Blobs for which the function fails are not selected, and the errors are joined.
+func filterErr(ctx context.Context, blobs []index.Blob, grace time.Duration, selectBlob func(index.Blob) (bool, error)) ([]index.Blob, error) {
+	var selected []index.Blob
+	var errs []error
+	for _, b := range blobs {
+		if ctx.Err() != nil {
+			return selected, ctx.Err()
+		}
+		if !olderThan(b, grace) {
+			continue
+		}
+
+		ok, err := selectBlob(b)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("blob %s: %w", b.Hash.Hex(), err))
+			continue
+		}
+		if ok {
+			selected = append(selected, b)
+		}
+	}
+	return selected, errors.Join(errs...)
+}
+
+// Report summarizes a run of a [Policy].
+type Report struct {
+	Deleted   int   // number of blobs deleted
+	Reclaimed int64 // total size of the deleted blobs
+	Failed    int   // number of blobs whose deletion failed
+}
+
+// Policy deletes the blobs selected by its rules. Create one with [New].
+type Policy struct {
+	rules  []Rule
+	source Source
+	delete DeleteFunc
+
+	// evict removes the deleted blobs from the blob cache of the bound server, if any. See [blossy.Server.Evict].
+	evict func(hash blossom.Hash)
+
+	interval time.Duration
+	metrics  *metrics.Metrics
+	log      *slog.Logger
+}
+
+type Option func(*Policy)
+
+// WithInterval sets how often the policy runs when started with [Policy.Run] or [Policy.Bind]. By default, it's one hour.
+func WithInterval(d time.Duration) Option {
+	return func(p *Policy) {
+		p.interval = d
+	}
+}
+
+// WithMetrics records the deleted blobs and the reclaimed bytes of every rule in the metrics.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(p *Policy) {
+		p.metrics = m
+	}
+}
+
+// WithLogger sets the logger of the policy. By default, it's [slog.Default].
+func WithLogger(l *slog.Logger) Option {
+	return func(p *Policy) {
+		p.log = l
+	}
+}
+
+// New returns a [Policy] that applies the rules, in order, to the blobs of the source, deleting the selected ones.
+// Every rule sees only the blobs not deleted by the previous ones.
+// It panics if the source or the delete function is nil, or the options are invalid.
+func New(source Source, delete DeleteFunc, rules []Rule, opts ...Option) *Policy {
+	if source == nil {
+		panic("retention.New: source must not be nil")
+	}
+	if delete == nil {
+		panic("retention.New: delete function must not be nil")
+	}
+	for _, rule := range rules {
+		if rule.Name == "" || rule.Select == nil {
+			panic("retention.New: rules must have a name and a select function")
+		}
+	}
+
+	p := &Policy{
+		rules:    rules,
+		source:   source,
+		delete:   delete,
+		interval: time.Hour,
+		log:      slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.interval <= 0 {
+		panic("retention.New: interval must be positive")
+	}
+	if p.log == nil {
+		panic("retention.New: logger must not be nil")
+	}
+	return p
+}
+
+// Bind runs the policy in the background of the server while it's serving (see [blossy.Server.Background]),
+// evicting the deleted blobs from the blob cache of the server.
+func (p *Policy) Bind(s *blossy.Server) {
+	p.evict = s.Evict
+	s.Background(p.Run)
+}
+
+// Run applies the policy every interval, until the context is cancelled.
+// The first run happens after one interval, so that restarts don't trigger it.
+func (p *Policy) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			report, err := p.RunOnce(ctx)
+			if err != nil {
+				p.log.Error("retention: run failed", "error", err)
+			}
+			if report.Deleted > 0 || report.Failed > 0 {
+				p.log.Info("retention: run completed", "deleted", report.Deleted, "reclaimed_bytes", report.Reclaimed, "failed", report.Failed)
+			}
+		}
+	}
+}
+
+// RunOnce applies the policy once, returning what it deleted.
+// Errors of the rules and of the deletions don't stop the run, and are returned joined.
+func (p *Policy) RunOnce(ctx context.Context) (Report, error) {
+	blobs, err := p.source(ctx)
+	if err != nil {
+		return Report{}, fmt.Errorf("retention: failed to load the blobs: %w", err)
+	}
+
+	var report Report
+	var errs []error
+
+	for _, rule := range p.rules {
+		selected, err := rule.Select(ctx, blobs)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("retention: rule %s: %w", rule.Name, err))
+		}
+		if len(selected) == 0 {
+			continue
+		}
+
+		deleted := make(map[blossom.Hash]bool, len(selected))
+		var reclaimed int64
+		for _, b := range selected {
+			if ctx.Err() != nil {
+				return report, errors.Join(append(errs, ctx.Err())...)
+			}
+
+			if err := p.delete(ctx, b.Hash); err != nil {
+				report.Failed++
+				errs = append(errs, fmt.Errorf("retention: rule %s: failed to delete blob %s: %w", rule.Name, b.Hash.Hex(), err))
+				continue
+			}
+			if p.evict != nil {
+				p.evict(b.Hash)
+			}
+			deleted[b.Hash] = true
+			reclaimed += b.Size
+		}
+
+		report.Deleted += len(deleted)
+		report.Reclaimed += reclaimed
+		p.metrics.ObserveRetention(rule.Name, len(deleted), reclaimed)
+
+		blobs = filter(blobs, func(b index.Blob) bool { return !deleted[b.Hash] })
+	}
+	return report, errors.Join(errs...)
+}
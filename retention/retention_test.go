@@ -0,0 +1,297 @@
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/cache"
+	"github.com/pippellia-btc/blossy/index"
+	"github.com/pippellia-btc/blossy/metrics"
+)
+
+var (
+	hour = int64(time.Hour / time.Second)
+	now  = time.Now().Unix()
+
+	old    = blob("old", 100, now-48*hour, 1)
+	orphan = blob("orphan", 200, now-24*hour, 0)
+	recent = blob("recent", 300, now-2*hour, 1)
+	fresh  = blob("fresh", 400, now, 0)
+)
+
+func blob(data string, size, uploaded int64, refs int) index.Blob {
+	return index.Blob{Hash: blossom.ComputeHash([]byte(data)), Size: size, Uploaded: uploaded, Refs: refs}
+}
+
+// fakeStore holds the blobs in memory, deleting them with delete.
+type fakeStore struct {
+	mu    sync.Mutex
+	blobs []index.Blob
+	fail  blossom.Hash
+}
+
+func newFakeStore(blobs ...index.Blob) *fakeStore {
+	return &fakeStore{blobs: blobs}
+}
+
+func (s *fakeStore) source(ctx context.Context) ([]index.Blob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.blobs), nil
+}
+
+func (s *fakeStore) delete(ctx context.Context, hash blossom.Hash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hash == s.fail {
+		return errors.New("disk on fire")
+	}
+	s.blobs = slices.DeleteFunc(s.blobs, func(b index.Blob) bool { return b.Hash == hash })
+	return nil
+}
+
+func (s *fakeStore) hashes() []blossom.Hash {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hashes := make([]blossom.Hash, len(s.blobs))
+	for i, b := range s.blobs {
+		hashes[i] = b.Hash
+	}
+	return hashes
+}
+
+func hashes(blobs ...index.Blob) []blossom.Hash {
+	hashes := make([]blossom.Hash, len(blobs))
+	for i, b := range blobs {
+		hashes[i] = b.Hash
+	}
+	return hashes
+}
+
+func TestRules(t *testing.T) {
+	referenced := func(ctx context.Context, hash blossom.Hash) (bool, error) {
+		return hash == old.Hash, nil
+	}
+	paid := func(ctx context.Context, b index.Blob) (bool, error) {
+		return b.Size > 150, nil
+	}
+
+	tests := []struct {
+		name     string
+		rule     Rule
+		expected []index.Blob
+	}{
+		{name: "max age", rule: MaxAge(12 * time.Hour), expected: []index.Blob{old, orphan}},
+		{name: "max total size", rule: MaxTotalSize(700), expected: []index.Blob{old, orphan}},
+		{name: "max total size not exceeded", rule: MaxTotalSize(1000), expected: nil},
+		{name: "unreferenced", rule: Unreferenced(time.Hour), expected: []index.Blob{orphan}},
+		{name: "orphaned", rule: Orphaned(referenced, time.Hour), expected: []index.Blob{orphan, recent}},
+		{name: "unpaid", rule: Unpaid(paid, time.Hour), expected: []index.Blob{old}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			selected, err := test.rule.Select(t.Context(), []index.Blob{old, orphan, recent, fresh})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(hashes(selected...), hashes(test.expected...)) {
+				t.Errorf("expected %v, got %v", hashes(test.expected...), hashes(selected...))
+			}
+		})
+	}
+}
+
+func TestOrphanedError(t *testing.T) {
+	referenced := func(ctx context.Context, hash blossom.Hash) (bool, error) {
+		if hash == orphan.Hash {
+			return false, errors.New("relay unreachable")
+		}
+		return false, nil
+	}
+
+	selected, err := Orphaned(referenced, time.Hour).Select(t.Context(), []index.Blob{old, orphan, recent})
+	if err == nil {
+		t.Fatal("expected the error of the referenced function")
+	}
+	if !slices.Equal(hashes(selected...), hashes(old, recent)) {
+		t.Errorf("expected the blobs that didn't fail to be selected, got %v", hashes(selected...))
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	store := newFakeStore(old, orphan, recent, fresh)
+	m := metrics.New()
+
+	policy := New(store.source, store.delete,
+		[]Rule{Unreferenced(time.Hour), MaxTotalSize(400)},
+		WithMetrics(m),
+	)
+
+	report, err := policy.RunOnce(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// unreferenced deletes orphan, then max total size deletes old and recent from the remaining 800 bytes.
+	expected := Report{Deleted: 3, Reclaimed: 600}
+	if report != expected {
+		t.Errorf("expected report %+v, got %+v", expected, report)
+	}
+	if !slices.Equal(store.hashes(), hashes(fresh)) {
+		t.Errorf("expected only the fresh blob to remain, got %v", store.hashes())
+	}
+
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`blossy_retention_deleted_blobs_total{rule="unreferenced"} 1`,
+		`blossy_retention_reclaimed_bytes_total{rule="max_total_size"} 400`,
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("expected metrics to contain %q", line)
+		}
+	}
+}
+
+func TestRunOnceDeleteError(t *testing.T) {
+	store := newFakeStore(old, orphan, recent)
+	store.fail = old.Hash
+
+	policy := New(store.source, store.delete, []Rule{MaxAge(time.Hour)})
+	report, err := policy.RunOnce(t.Context())
+	if err == nil {
+		t.Fatal("expected the error of the deletion")
+	}
+
+	expected := Report{Deleted: 2, Reclaimed: 500, Failed: 1}
+	if report != expected {
+		t.Errorf("expected report %+v, got %+v", expected, report)
+	}
+}
+
+func newIndex(t *testing.T) *index.Index {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1) // every connection opens a different in-memory database
+	t.Cleanup(func() { db.Close() })
+
+	idx, err := index.New(t.Context(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+func TestIndexSource(t *testing.T) {
+	ctx := t.Context()
+	idx := newIndex(t)
+
+	desc := blossom.BlobDescriptor{Hash: old.Hash, Size: old.Size, Uploaded: old.Uploaded}
+	if err := idx.Add(ctx, strings.Repeat("a", 64), desc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idx.Remove(ctx, strings.Repeat("a", 64), desc.Hash); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := New(idx.Blobs, idx.Drop, []Rule{Unreferenced(time.Hour)})
+	report, err := policy.RunOnce(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Deleted != 1 {
+		t.Errorf("expected the unreferenced blob to be deleted, got %+v", report)
+	}
+	if _, err := idx.Get(ctx, desc.Hash); !errors.Is(err, index.ErrNotFound) {
+		t.Errorf("expected the blob to be dropped from the index, got %v", err)
+	}
+}
+
+func TestBind(t *testing.T) {
+	server, err := blossy.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newFakeStore(old, fresh)
+	policy := New(store.source, store.delete, []Rule{MaxAge(time.Hour)}, WithInterval(10*time.Millisecond))
+	policy.Bind(server)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(ctx, listener) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(store.hashes()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the policy didn't run while serving")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestBindEvict(t *testing.T) {
+	server, err := blossy.NewServer(blossy.WithBlobCache(cache.NewMemory()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newFakeStore(old, fresh)
+	server.On.Download = func(r blossy.Request, hash blossom.Hash, ext string) (blossy.BlobDelivery, *blossom.Error) {
+		if !slices.Contains(store.hashes(), hash) {
+			return nil, blossom.ErrNotFound("blob not found")
+		}
+		return blossy.Serve(blossom.BlobFromBytes([]byte("old"))), nil
+	}
+
+	policy := New(store.source, store.delete, []Rule{MaxAge(time.Hour)})
+	policy.Bind(server)
+
+	download := func() int {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+old.Hash.Hex(), nil))
+		return w.Code
+	}
+
+	// the first download caches the blob
+	if code := download(); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+
+	if _, err := policy.RunOnce(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if code := download(); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for the deleted blob, got %d", code)
+	}
+}
@@ -0,0 +1,427 @@
+// Package scanning sends the blobs stored by a blossy server to an external content scanner
+// (e.g. CSAM hash-matching or antivirus services), and quarantines or deletes them according to its verdict.
+//
+// After every successful upload, media upload or mirror, the [Scanner] POSTs a JSON [Job] with the metadata of the blob,
+// and optionally a URL to fetch it, to the scanner endpoint. The scanner replies either with:
+//   - 200 and a JSON [Verdict], which is applied immediately.
+//   - 202, meaning that the verdict will be POSTed later to the [Scanner.Handler], which must be mounted by the caller.
+//
+// If a secret is configured, the jobs and the verdicts are signed with HMAC-SHA256 in the 'X-Signature' header.
+//
+// Example:
+//
+//	scanner, err := scanning.New("https://scanner.internal/scan", deleteBlob,
+//	    scanning.WithSecret(secret),
+//	    scanning.WithFetchURL(presign),
+//	)
+//	if err != nil {
+//	    panic(err)
+//	}
+//	scanner.Bind(server)
+//	http.Handle("/scanner/verdict", scanner.Handler()) // on a private address
+package scanning
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+)
+
+// ErrUnknownVerdict is returned by [Scanner.Apply] when the action of the verdict is not known.
+var ErrUnknownVerdict = errors.New("scanning: unknown verdict")
+
+// Action is the outcome of a scan.
+type Action string
+
+const (
+	ActionClean      Action = "clean"      // the blob is served normally
+	ActionQuarantine Action = "quarantine" // the blob is not served, with 451 Unavailable For Legal Reasons
+	ActionDelete     Action = "delete"     // the blob is deleted
+)
+
+// Job is the body of the requests to the scanner endpoint.
+type Job struct {
+	Hash     blossom.Hash `json:"hash"`
+	Size     int64        `json:"size"`
+	Type     string       `json:"type"`
+	Uploaded int64        `json:"uploaded"`
+	Pubkey   string       `json:"pubkey,omitempty"` // the uploader, if authenticated
+	URL      string       `json:"url,omitempty"`    // where the scanner can fetch the blob, if configured
+}
+
+// Verdict is the result of a scan, returned by the scanner endpoint or POSTed to the [Scanner.Handler].
+type Verdict struct {
+	Hash   blossom.Hash `json:"hash"`
+	Action Action       `json:"verdict"`
+	Reason string       `json:"reason,omitempty"`
+}
+
+// DeleteFunc deletes the blob from the storage.
+type DeleteFunc func(ctx context.Context, hash blossom.Hash) error
+
+// FetchURLFunc returns the URL the scanner uses to fetch the blob, for example a presigned URL of an S3 bucket.
+type FetchURLFunc func(ctx context.Context, desc blossom.BlobDescriptor) (string, error)
+
+// Scanner sends the stored blobs to a content scanner, and applies its verdicts. Create one with [New].
+type Scanner struct {
+	endpoint string
+	delete   DeleteFunc
+	queue    chan Job
+
+	secret    []byte
+	workers   int
+	client    *http.Client
+	fetchURL  FetchURLFunc
+	onVerdict func(ctx context.Context, v Verdict)
+	log       *slog.Logger
+
+	mu          sync.RWMutex
+	quarantined map[blossom.Hash]string // hash -> reason
+}
+
+type Option func(*Scanner)
+
+// WithSecret signs the jobs and verifies the verdicts POSTed to the [Scanner.Handler] with HMAC-SHA256.
+// Without a secret, the handler accepts any verdict, so it must be mounted on a private address.
+func WithSecret(secret []byte) Option {
+	return func(s *Scanner) {
+		s.secret = secret
+	}
+}
+
+// WithWorkers sets the number of jobs sent concurrently to the scanner. By default, it's 4.
+func WithWorkers(n int) Option {
+	return func(s *Scanner) {
+		s.workers = n
+	}
+}
+
+// WithQueueSize sets the maximum number of jobs waiting to be sent. When the queue is full,
+// new jobs are dropped and logged. By default, it's 1000.
+func WithQueueSize(n int) Option {
+	return func(s *Scanner) {
+		s.queue = make(chan Job, n)
+	}
+}
+
+// WithFetchURL sets the function that returns the URL of the job. By default, it's the URL of the blob descriptor, if any.
+func WithFetchURL(f FetchURLFunc) Option {
+	return func(s *Scanner) {
+		s.fetchURL = f
+	}
+}
+
+// WithHTTPClient sets the http client used to send the jobs. By default, it has a timeout of 30 seconds.
+func WithHTTPClient(c *http.Client) Option {
+	return func(s *Scanner) {
+		s.client = c
+	}
+}
+
+// WithOnVerdict sets a function called after every verdict has been applied, for example to notify the operator.
+func WithOnVerdict(f func(ctx context.Context, v Verdict)) Option {
+	return func(s *Scanner) {
+		s.onVerdict = f
+	}
+}
+
+// WithLogger sets the logger of the scanner. By default, it's [slog.Default].
+func WithLogger(l *slog.Logger) Option {
+	return func(s *Scanner) {
+		s.log = l
+	}
+}
+
+// New returns a [Scanner] that sends the jobs to the endpoint, and deletes blobs with the delete function.
+func New(endpoint string, delete DeleteFunc, opts ...Option) (*Scanner, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("scanning: invalid endpoint %q", endpoint)
+	}
+	if delete == nil {
+		return nil, errors.New("scanning: delete function must not be nil")
+	}
+
+	s := &Scanner{
+		endpoint:    endpoint,
+		delete:      delete,
+		queue:       make(chan Job, 1000),
+		workers:     4,
+		client:      &http.Client{Timeout: 30 * time.Second},
+		log:         slog.Default(),
+		quarantined: make(map[blossom.Hash]string),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.workers <= 0 {
+		return nil, errors.New("scanning: the number of workers must be positive")
+	}
+	if cap(s.queue) <= 0 {
+		return nil, errors.New("scanning: the queue size must be positive")
+	}
+	if s.client == nil {
+		return nil, errors.New("scanning: http client must not be nil")
+	}
+	if s.log == nil {
+		return nil, errors.New("scanning: logger must not be nil")
+	}
+	return s, nil
+}
+
+// Bind submits the blobs stored by the Upload, Media and Mirror hooks of the server to the scanner,
+// replies with 451 Unavailable For Legal Reasons to the Download and Check requests of quarantined blobs,
+// and sends the jobs in the background while the server is serving (see [blossy.Server.Background]).
+//
+// It must be called after the On hooks are set (e.g. after [blossy.BindStore]), as it wraps them.
+func (s *Scanner) Bind(server *blossy.Server) {
+	if upload := server.On.Upload; upload != nil {
+		server.On.Upload = func(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+			desc, err := upload(r, hints, data)
+			if err == nil {
+				s.Submit(r.Context(), r.Pubkey(), desc)
+			}
+			return desc, err
+		}
+	}
+
+	if media := server.On.Media; media != nil {
+		server.On.Media = func(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+			desc, err := media(r, hints, data)
+			if err == nil {
+				s.Submit(r.Context(), r.Pubkey(), desc)
+			}
+			return desc, err
+		}
+	}
+
+	if mirror := server.On.Mirror; mirror != nil {
+		server.On.Mirror = func(r blossy.Request, u *url.URL) (blossom.BlobDescriptor, *blossom.Error) {
+			desc, err := mirror(r, u)
+			if err == nil {
+				s.Submit(r.Context(), r.Pubkey(), desc)
+			}
+			return desc, err
+		}
+	}
+
+	server.Reject.Download.Prepend(func(r blossy.Request, hash blossom.Hash, ext string) *blossom.Error {
+		return s.checkQuarantine(hash)
+	})
+	server.Reject.Check.Prepend(func(r blossy.Request, hash blossom.Hash, ext string) *blossom.Error {
+		return s.checkQuarantine(hash)
+	})
+
+	server.Background(s.Run)
+}
+
+func (s *Scanner) checkQuarantine(hash blossom.Hash) *blossom.Error {
+	s.mu.RLock()
+	reason, ok := s.quarantined[hash]
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+	if reason == "" {
+		reason = "The blob is quarantined"
+	}
+	return blossy.ErrBlocked(reason)
+}
+
+// Submit queues the blob to be sent to the scanner. If the queue is full, the blob is not scanned, and it's logged.
+func (s *Scanner) Submit(ctx context.Context, pubkey string, desc blossom.BlobDescriptor) {
+	job := Job{
+		Hash:     desc.Hash,
+		Size:     desc.Size,
+		Type:     desc.Type,
+		Uploaded: desc.Uploaded,
+		Pubkey:   pubkey,
+		URL:      desc.URL,
+	}
+
+	if s.fetchURL != nil {
+		url, err := s.fetchURL(ctx, desc)
+		if err != nil {
+			s.log.Error("scanning: failed to get the fetch URL", "hash", desc.Hash, "error", err)
+		}
+		job.URL = url
+	}
+
+	select {
+	case s.queue <- job:
+	default:
+		s.log.Warn("scanning: queue is full, the blob won't be scanned", "hash", desc.Hash)
+	}
+}
+
+// Run sends the queued jobs to the scanner with the configured number of workers, until the context is cancelled.
+func (s *Scanner) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range s.workers {
+		wg.Go(func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+
+				case job := <-s.queue:
+					if err := s.Scan(ctx, job); err != nil {
+						s.log.Error("scanning: failed to scan the blob", "hash", job.Hash, "error", err)
+					}
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// Scan sends the job to the scanner, and applies the verdict if the scanner replies with one.
+func (s *Scanner) Scan(ctx context.Context, job Job) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("scanning: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("scanning: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.secret != nil {
+		req.Header.Set("X-Signature", s.sign(body))
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("scanning: failed to send the job: %w", err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusAccepted:
+		// the verdict will be posted to the handler
+		return nil
+
+	case http.StatusOK:
+		var verdict Verdict
+		if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&verdict); err != nil {
+			return fmt.Errorf("scanning: invalid verdict: %w", err)
+		}
+		if verdict.Hash.IsZero() {
+			verdict.Hash = job.Hash
+		}
+		if verdict.Hash != job.Hash {
+			return fmt.Errorf("scanning: verdict for hash %s instead of %s", verdict.Hash, job.Hash)
+		}
+		return s.Apply(ctx, verdict)
+
+	default:
+		return fmt.Errorf("scanning: scanner responded with status %d", res.StatusCode)
+	}
+}
+
+// Apply applies the verdict: clean blobs are released from the quarantine, quarantined blobs are not served anymore,
+// and deleted blobs are deleted with the delete function.
+func (s *Scanner) Apply(ctx context.Context, v Verdict) error {
+	switch v.Action {
+	case ActionClean:
+		s.Release(v.Hash)
+
+	case ActionQuarantine:
+		s.mu.Lock()
+		s.quarantined[v.Hash] = v.Reason
+		s.mu.Unlock()
+
+	case ActionDelete:
+		if err := s.delete(ctx, v.Hash); err != nil {
+			return fmt.Errorf("scanning: failed to delete the blob: %w", err)
+		}
+		s.Release(v.Hash)
+
+	default:
+		return fmt.Errorf("%w %q", ErrUnknownVerdict, v.Action)
+	}
+
+	if s.onVerdict != nil {
+		s.onVerdict(ctx, v)
+	}
+	return nil
+}
+
+// Release serves the blob again, if it was quarantined.
+func (s *Scanner) Release(hash blossom.Hash) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.quarantined, hash)
+}
+
+// IsQuarantined reports whether the blob is quarantined.
+func (s *Scanner) IsQuarantined(hash blossom.Hash) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.quarantined[hash]
+	return ok
+}
+
+// Handler returns the [http.Handler] receiving the verdicts POSTed asynchronously by the scanner.
+// It replies with 204 once the verdict is applied, 401 if the signature is invalid, 400 for invalid verdicts,
+// and 500 if the verdict couldn't be applied.
+func (s *Scanner) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "failed to read the body", http.StatusBadRequest)
+			return
+		}
+
+		if s.secret != nil && !hmac.Equal([]byte(r.Header.Get("X-Signature")), []byte(s.sign(body))) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		var verdict Verdict
+		if err := json.Unmarshal(body, &verdict); err != nil || verdict.Hash.IsZero() {
+			http.Error(w, "invalid verdict", http.StatusBadRequest)
+			return
+		}
+
+		err = s.Apply(r.Context(), verdict)
+		if errors.Is(err, ErrUnknownVerdict) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// sign returns the signature of the body, in the form "sha256=<hex hmac>".
+func (s *Scanner) sign(body []byte) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,169 @@
+package scanning
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy/blossytest"
+)
+
+var hash1 = blossom.ComputeHash([]byte("one"))
+
+type deleter struct {
+	mu      sync.Mutex
+	deleted []blossom.Hash
+}
+
+func (d *deleter) delete(ctx context.Context, hash blossom.Hash) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.deleted = append(d.deleted, hash)
+	return nil
+}
+
+// fakeScanner replies to every job with the action, or with 202 if the action is empty.
+func fakeScanner(t *testing.T, secret []byte, action Action) (url string, jobs <-chan Job) {
+	t.Helper()
+	received := make(chan Job, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body bytes.Buffer
+		body.ReadFrom(r.Body)
+
+		s := Scanner{secret: secret}
+		if secret != nil && r.Header.Get("X-Signature") != s.sign(body.Bytes()) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		var job Job
+		if err := json.Unmarshal(body.Bytes(), &job); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received <- job
+
+		if action == "" {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		json.NewEncoder(w).Encode(Verdict{Action: action, Reason: "matched a known hash"})
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, received
+}
+
+func TestScan(t *testing.T) {
+	secret := []byte("secret")
+	url, jobs := fakeScanner(t, secret, ActionQuarantine)
+
+	d := &deleter{}
+	scanner, err := New(url, d.delete, WithSecret(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := scanner.Scan(t.Context(), Job{Hash: hash1, Size: 3, Type: "text/plain"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if job := <-jobs; job.Hash != hash1 || job.Size != 3 {
+		t.Errorf("unexpected job %+v", job)
+	}
+	if !scanner.IsQuarantined(hash1) {
+		t.Error("expected the blob to be quarantined")
+	}
+
+	if err := scanner.Apply(t.Context(), Verdict{Hash: hash1, Action: ActionDelete}); err != nil {
+		t.Fatal(err)
+	}
+	if scanner.IsQuarantined(hash1) || len(d.deleted) != 1 {
+		t.Errorf("expected the blob to be deleted and released, got %v", d.deleted)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	secret := []byte("secret")
+	scanner, err := New("https://scanner.example.com", (&deleter{}).delete, WithSecret(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(body string, signature string) int {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("X-Signature", signature)
+		w := httptest.NewRecorder()
+		scanner.Handler().ServeHTTP(w, r)
+		return w.Code
+	}
+
+	verdict := `{"hash":"` + hash1.Hex() + `","verdict":"quarantine"}`
+	if code := post(verdict, "sha256=00"); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 with an invalid signature, got %d", code)
+	}
+
+	invalid := `{"hash":"` + hash1.Hex() + `","verdict":"burn"}`
+	if code := post(invalid, scanner.sign([]byte(invalid))); code != http.StatusBadRequest {
+		t.Errorf("expected 400 with an unknown verdict, got %d", code)
+	}
+
+	if code := post(verdict, scanner.sign([]byte(verdict))); code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", code)
+	}
+	if !scanner.IsQuarantined(hash1) {
+		t.Error("expected the blob to be quarantined")
+	}
+}
+
+func TestBind(t *testing.T) {
+	url, jobs := fakeScanner(t, nil, ActionQuarantine)
+	scanner, err := New(url, (&deleter{}).delete)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := blossytest.NewTestServer(t)
+	scanner.Bind(server.Blossy)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go scanner.Run(ctx)
+
+	client := server.Client(t, blossytest.NewSigner(t))
+	desc, err := client.Upload(t.Context(), strings.NewReader("hello"), "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case job := <-jobs:
+		if job.Hash != desc.Hash {
+			t.Fatalf("expected a job for the uploaded blob, got %+v", job)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the blob was not sent to the scanner")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !scanner.IsQuarantined(desc.Hash) {
+		if time.Now().After(deadline) {
+			t.Fatal("the verdict was not applied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	res := server.Do(t, server.NewRequest(t, http.MethodGet, "/"+desc.Hash.Hex(), nil))
+	if res.StatusCode != http.StatusUnavailableForLegalReasons {
+		t.Errorf("expected 451 for the quarantined blob, got %d", res.StatusCode)
+	}
+	if reason := res.Header.Get("X-Reason"); reason != "matched a known hash" {
+		t.Errorf("expected the reason of the verdict, got %q", reason)
+	}
+}
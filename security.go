@@ -0,0 +1,172 @@
+package blossy
+
+import (
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+// DefaultContentSecurityPolicy is the 'Content-Security-Policy' of the blobs with active content types,
+// if not configured. It sandboxes the document and blocks scripts and requests, so that uploaded HTML and SVG
+// can't run code on the origin of the server (stored XSS), while still allowing inline styles and data images.
+const DefaultContentSecurityPolicy = "default-src 'none'; style-src 'unsafe-inline'; img-src data:; sandbox"
+
+// SecurityPolicy configures the security headers of the server responses, which protect the operators
+// from the active content (e.g. HTML and SVG with scripts) that users might upload. See [WithSecurityHeaders].
+type SecurityPolicy struct {
+	// ContentSecurityPolicy is the 'Content-Security-Policy' header of the blobs with an active content type.
+	// If empty, it's [DefaultContentSecurityPolicy].
+	ContentSecurityPolicy string
+
+	// ActiveTypes are the patterns of the content types that browsers can execute (e.g. "text/html", "image/svg+xml").
+	// If empty, HTML, XHTML, SVG and XML are considered active.
+	ActiveTypes []string
+
+	// CrossOriginResourcePolicy is the 'Cross-Origin-Resource-Policy' header of the responses ("same-origin",
+	// "same-site" or "cross-origin"). If empty, it's "cross-origin", since blobs are meant to be embedded by any site.
+	CrossOriginResourcePolicy string
+}
+
+// DefaultSecurityPolicy returns the security policy with the default values.
+func DefaultSecurityPolicy() SecurityPolicy {
+	return SecurityPolicy{
+		ContentSecurityPolicy:     DefaultContentSecurityPolicy,
+		ActiveTypes:               []string{"text/html", "application/xhtml+xml", "image/svg+xml", "application/xml", "text/xml"},
+		CrossOriginResourcePolicy: "cross-origin",
+	}
+}
+
+// withDefaults returns the policy with the empty fields set to the ones of the default policy.
+func (p SecurityPolicy) withDefaults() SecurityPolicy {
+	d := DefaultSecurityPolicy()
+	if p.ContentSecurityPolicy == "" {
+		p.ContentSecurityPolicy = d.ContentSecurityPolicy
+	}
+	if len(p.ActiveTypes) == 0 {
+		p.ActiveTypes = d.ActiveTypes
+	}
+	if p.CrossOriginResourcePolicy == "" {
+		p.CrossOriginResourcePolicy = d.CrossOriginResourcePolicy
+	}
+	return p
+}
+
+// isActive reports whether browsers can execute the content type, according to the policy.
+func (p *SecurityPolicy) isActive(contentType string) bool {
+	return slices.ContainsFunc(p.ActiveTypes, func(pattern string) bool {
+		return utils.MatchMediaType(pattern, contentType)
+	})
+}
+
+// set sets the security headers of every response.
+func (p *SecurityPolicy) set(w http.ResponseWriter) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cross-Origin-Resource-Policy", p.CrossOriginResourcePolicy)
+}
+
+// setBlob sets the 'Content-Security-Policy' header of the response of a blob, if its content type is active.
+func (p *SecurityPolicy) setBlob(w http.ResponseWriter, contentType string) {
+	if p.isActive(contentType) {
+		w.Header().Set("Content-Security-Policy", p.ContentSecurityPolicy)
+	}
+}
+
+// ContentAction is how the server delivers the blobs of a content type. See [WithContentPolicy].
+type ContentAction string
+
+const (
+	// ContentServe serves the blob with its content type, which is the default.
+	ContentServe ContentAction = "serve"
+
+	// ContentAttachment serves the blob with 'Content-Disposition: attachment', so that browsers download it
+	// instead of rendering it when it's opened, while it can still be embedded (e.g. an SVG in an <img> tag).
+	ContentAttachment ContentAction = "attachment"
+
+	// ContentPlainText serves the blob as 'text/plain; charset=utf-8', so that browsers display its source.
+	ContentPlainText ContentAction = "text"
+)
+
+// DefaultContentPolicy returns the content policy that serves HTML, XHTML and SVG blobs as attachments.
+func DefaultContentPolicy() map[string]ContentAction {
+	return map[string]ContentAction{
+		"text/html":             ContentAttachment,
+		"application/xhtml+xml": ContentAttachment,
+		"image/svg+xml":         ContentAttachment,
+	}
+}
+
+// contentAction returns the action of the content type in the policy. Exact media types take precedence
+// over type wildcards (e.g. "text/*"), which take precedence over "*/*".
+func contentAction(policy map[string]ContentAction, contentType string) ContentAction {
+	mediaType := utils.MediaType(contentType)
+	if mediaType == "" {
+		return ContentServe
+	}
+
+	main, _, _ := strings.Cut(mediaType, "/")
+	for _, pattern := range []string{mediaType, main + "/*", "*/*"} {
+		if action, ok := policy[pattern]; ok {
+			return action
+		}
+	}
+	return ContentServe
+}
+
+// plainText is the content type of the blobs served with [ContentPlainText].
+const plainText = "text/plain; charset=utf-8"
+
+// applyContentPolicy returns the blob and the delivery modified by the action of the content type of the blob.
+func (s *Server) applyContentPolicy(blob blossom.Blob, d delivery) (blossom.Blob, delivery) {
+	switch contentAction(s.settings.HTTP.contentPolicy, blob.Type()) {
+	case ContentAttachment:
+		d.disposition = "attachment"
+	case ContentPlainText:
+		blob = retype(blob, plainText)
+	}
+	return blob, d
+}
+
+// applyContentPolicyMeta is like applyContentPolicy, but for the metadata returned by the Check hook.
+func (s *Server) applyContentPolicyMeta(mime string, d delivery) (string, delivery) {
+	switch contentAction(s.settings.HTTP.contentPolicy, mime) {
+	case ContentAttachment:
+		d.disposition = "attachment"
+	case ContentPlainText:
+		mime = plainText
+	}
+	return mime, d
+}
+
+// retype returns the blob with the content type, preserving its ability to be served from a file or with ranges.
+func retype(blob blossom.Blob, typ string) blossom.Blob {
+	switch b := blob.(type) {
+	case fileBlob:
+		b.typ = typ
+		return b
+	case io.ReadSeeker:
+		return retypedSeeker{retypedBlob{Blob: blob, typ: typ}, b}
+	default:
+		return retypedBlob{Blob: blob, typ: typ}
+	}
+}
+
+// retypedBlob is a blob served with another content type.
+type retypedBlob struct {
+	blossom.Blob
+	typ string
+}
+
+func (b retypedBlob) Type() string { return b.typ }
+
+type retypedSeeker struct {
+	retypedBlob
+	seeker io.Seeker
+}
+
+func (b retypedSeeker) Seek(offset int64, whence int) (int64, error) {
+	return b.seeker.Seek(offset, whence)
+}
@@ -1,25 +1,62 @@
 package blossy
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
 	"reflect"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy/metrics"
+	"github.com/pippellia-btc/blossy/utils"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Server is the fundamental structure of the blossy package.
 // Create one with [NewServer].
 type Server struct {
 	log         *slog.Logger
+	metrics     *metrics.Metrics
+	tracer      trace.Tracer
 	nextRequest atomic.Int64
 
+	// live holds the settings that can be replaced with [Server.Reload].
+	live atomic.Pointer[reloadable]
+
+	// handler is the router wrapped in the middlewares.
+	handler     http.Handler
+	middlewares []Middleware
+
+	// background are the tasks run by [Server.Serve] while serving.
+	background []func(ctx context.Context)
+
+	// infoExtensions modify the [Info] of the server, in order.
+	infoExtensions []func(info *Info)
+
+	// plugins are the names of the plugins installed with [Server.Install].
+	plugins []string
+
+	// derivations are the representations of the blobs registered with [Server.Derive], by extension.
+	derivations map[string]Derivation
+
+	// jobs runs the jobs of [Server.Enqueue]. If nil, the server has no workers.
+	jobs *jobQueue
+
 	Hooks
 	settings
 }
@@ -41,6 +78,8 @@ func NewServer(opts ...Option) (*Server, error) {
 		settings: newSettings(),
 	}
 
+	server.handler = http.HandlerFunc(server.serve)
+
 	for _, opt := range opts {
 		opt(server)
 	}
@@ -48,6 +87,17 @@ func NewServer(opts ...Option) (*Server, error) {
 	if err := server.validate(); err != nil {
 		return nil, err
 	}
+	server.live.Store(server.settings.reloadable())
+
+	if server.settings.Upload.sessions != nil {
+		server.Background(server.pruneSessions)
+	}
+	if server.jobs = newJobQueue(server.settings.Sys, server.settings.HTTP.shutdownTimeout, server.log); server.jobs != nil {
+		server.Background(server.jobs.run)
+	}
+	for _, w := range server.settings.Sys.webhooks {
+		server.Background(func(ctx context.Context) { w.run(ctx, server.settings.HTTP.shutdownTimeout) })
+	}
 	return server, nil
 }
 
@@ -56,34 +106,162 @@ func (s *Server) TotalRequests() int {
 	return int(s.nextRequest.Load())
 }
 
-// deriveURL derives the URL for a blob descriptor.
-// If the server hostname is not set, it returns an error.
-func (s *Server) deriveURL(d blossom.BlobDescriptor) (string, error) {
+// deriveURL derives the URL for a blob descriptor, with the builder set by [WithURLBuilder] if any.
+// Otherwise, if the server hostname is not set, it returns an error.
+func (s *Server) deriveURL(r *http.Request, d blossom.BlobDescriptor) (string, error) {
+	if build := s.Sys.urlBuilder; build != nil {
+		url := build(d)
+		if url == "" {
+			return "", errors.New("the URL builder returned an empty URL")
+		}
+		return url, nil
+	}
+
 	if s.Sys.hostname == "" {
 		return "", errors.New("server hostname is not set")
 	}
-	return fmt.Sprintf("https://%s/%s.%s",
-		s.Sys.hostname,
+	return fmt.Sprintf("https://%s%s/%s.%s",
+		s.hostnameOf(r),
+		s.settings.HTTP.pathPrefix,
 		d.Hash.Hex(),
-		blossom.ExtFromType(d.Type),
+		s.ExtForMime(d.Type),
 	), nil
 }
 
+// hostnameOf returns the hostname of the Host header of the request, if it's one of the hostnames
+// of the server (see [WithHostnames]). Otherwise, it returns the primary hostname.
+func (s *Server) hostnameOf(r *http.Request) string {
+	if len(s.Sys.aliases) == 0 {
+		return s.Sys.hostname
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	if host == s.Sys.hostname || slices.Contains(s.Sys.aliases, host) {
+		return host
+	}
+	return s.Sys.hostname
+}
+
+// UnixPrefix is the prefix of the addresses of unix sockets accepted by [Server.StartAndServe] (e.g. "unix:/run/blossy.sock").
+const UnixPrefix = "unix:"
+
 // StartAndServe starts the blossom server, listens to the provided address and handles http requests.
+// If TLS is configured (see [WithTLS] and [WithTLSConfig]) it serves HTTPS, otherwise plain HTTP.
+// If a reloader is configured (see [WithReloader]), the server is reloaded when the process receives SIGHUP.
+//
+// If the address starts with [UnixPrefix], the server listens on a unix socket at the path that follows,
+// for example to sit behind a reverse proxy on the same machine. A stale socket file left by a previous run is replaced,
+// and the socket file is removed when the server stops. Its permissions can be set with [WithUnixSocketMode].
 //
 // It's a blocking operation, that stops only when the context gets cancelled.
 func (s *Server) StartAndServe(ctx context.Context, address string) error {
+	if address == "" {
+		address = ":http"
+		if s.settings.HTTP.useTLS() {
+			address = ":https"
+		}
+	}
+
+	if path, ok := strings.CutPrefix(address, UnixPrefix); ok {
+		listener, err := s.listenUnix(path)
+		if err != nil {
+			return err
+		}
+		return s.Serve(ctx, listener)
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// listenUnix listens on a unix socket at the path, removing the stale socket file of a previous run, if any.
+// The socket file is removed when the listener is closed.
+func (s *Server) listenUnix(path string) (net.Listener, error) {
+	if path == "" {
+		return nil, errors.New("unix socket: path is empty")
+	}
+
+	info, err := os.Lstat(path)
+	switch {
+	case err == nil:
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("unix socket: %s exists and it's not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("unix socket: %s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("unix socket: failed to remove the stale socket: %w", err)
+		}
+
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("unix socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode := s.settings.HTTP.socketMode; mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("unix socket: failed to set the permissions: %w", err)
+		}
+	}
+	return listener, nil
+}
+
+// Background registers tasks that [Server.Serve] (and [Server.StartAndServe]) runs in their own goroutines
+// while serving, such as periodic cleanups. The context of the tasks is cancelled when the server stops,
+// and the server waits for them to return before Serve returns.
+//
+// Background is not safe for concurrent use, and it must be called before the server starts serving requests.
+func (s *Server) Background(tasks ...func(ctx context.Context)) {
+	s.background = append(s.background, tasks...)
+}
+
+// Serve is like [Server.StartAndServe], but it handles http requests on connections accepted
+// from the provided listener, for example one inherited with systemd socket activation.
+// The listener is closed when Serve returns.
+//
+// It's a blocking operation, that stops only when the context gets cancelled.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
 	exitErr := make(chan error, 1)
-	server := &http.Server{
-		Addr:              address,
-		Handler:           s,
-		ReadHeaderTimeout: s.settings.HTTP.readHeaderTimeout,
-		IdleTimeout:       s.settings.HTTP.idleTimeout,
+	address := listener.Addr().String()
+	server := s.newHTTPServer(address)
+
+	tasksCtx, stopTasks := context.WithCancel(ctx)
+	var tasks sync.WaitGroup
+	for _, task := range s.background {
+		tasks.Go(func() { task(tasksCtx) })
+	}
+	if s.settings.Sys.reloader != nil {
+		tasks.Go(func() { s.reloadOnHangup(tasksCtx) })
 	}
+	defer tasks.Wait()
+	defer stopTasks()
 
 	go func() {
-		s.log.Info("serving the blossom server", "address", address)
-		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		var err error
+		if s.settings.HTTP.useTLS() {
+			s.log.Info("serving the blossom server with TLS", "address", address)
+			err = server.ServeTLS(listener, s.settings.HTTP.certFile, s.settings.HTTP.keyFile)
+		} else {
+			s.log.Info("serving the blossom server", "address", address)
+			err = server.Serve(listener)
+		}
+
+		if !errors.Is(err, http.ErrServerClosed) {
 			exitErr <- err
 		}
 	}()
@@ -102,46 +280,245 @@ func (s *Server) StartAndServe(ctx context.Context, address string) error {
 	}
 }
 
-// ServeHTTP implements the [http.Handler] interface, routing http requests to the appropriate [Hook].
+// newHTTPServer returns the [http.Server] used by [Server.Serve],
+// after applying the customizations of [WithHTTPServer], if any.
+func (s *Server) newHTTPServer(address string) *http.Server {
+	server := &http.Server{
+		Addr:              address,
+		Handler:           s,
+		ReadHeaderTimeout: s.settings.HTTP.readHeaderTimeout,
+		IdleTimeout:       s.settings.HTTP.idleTimeout,
+		TLSConfig:         s.settings.HTTP.tlsConfig,
+		HTTP2:             s.settings.HTTP.http2,
+	}
+
+	if s.settings.HTTP.h2c {
+		server.Protocols = new(http.Protocols)
+		server.Protocols.SetHTTP1(true)
+		server.Protocols.SetHTTP2(true)
+		server.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	if s.settings.HTTP.customize != nil {
+		s.settings.HTTP.customize(server)
+	}
+
+	if s.settings.HTTP.egressPerConn > 0 {
+		// wrap the ConnContext set by the customizer, if any, so that connections are always tracked
+		next := server.ConnContext
+		server.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+			if next != nil {
+				ctx = next(ctx, c)
+			}
+			return s.ConnContext(ctx, c)
+		}
+	}
+	return server
+}
+
+// Middleware wraps an [http.Handler] to add cross-cutting behavior, such as tracing or logging.
+type Middleware func(http.Handler) http.Handler
+
+// Use adds middlewares around the internal router of the server, which are applied
+// both by [Server.ServeHTTP] and [Server.StartAndServe].
+// Middlewares are applied in the order they are added: the first one is the outermost,
+// and sees the request before all the others.
+//
+// Use is not safe for concurrent use, and it must be called before the server starts serving requests.
+func (s *Server) Use(middlewares ...Middleware) {
+	s.middlewares = append(s.middlewares, middlewares...)
+
+	var handler http.Handler = http.HandlerFunc(s.serve)
+	for i := len(s.middlewares) - 1; i >= 0; i-- {
+		handler = s.middlewares[i](handler)
+	}
+	s.handler = handler
+}
+
+// ServeHTTP implements the [http.Handler] interface, routing http requests to the appropriate [Hook]
+// after passing them through the middlewares (see [Server.Use]).
+//
+// Panics of the hooks and the middlewares are recovered: they are logged with their stack trace,
+// recorded in the metrics (see [WithMetrics]), and answered with 500 (Internal Server Error).
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	setCORS(w)
+	defer func() {
+		if v := recover(); v != nil {
+			endpoint, _ := s.route(r)
+			s.handlePanic(w, r, endpoint, v)
+		}
+	}()
+	s.handler.ServeHTTP(w, r)
+}
+
+// serve assigns an ID to the request and routes it to the appropriate handler.
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if prefix := s.settings.HTTP.pathPrefix; prefix != "" && !s.isNIP05(r) {
+		var ok bool
+		if r, ok = stripPrefix(r, prefix); !ok {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	state := &requestState{id: s.nextRequest.Add(1), header: w.Header()}
+	r = r.WithContext(context.WithValue(r.Context(), stateKey, state))
+	w = &rejectionWriter{ResponseWriter: w, state: state}
+
+	r, cancel := s.withTimeout(r)
+	defer cancel()
+	if s.settings.HTTP.requestIDHeader {
+		w.Header().Set("X-Request-ID", strconv.FormatInt(state.id, 10))
+	}
+
+	if c := s.settings.HTTP.cors; c != nil {
+		c.set(w, r)
+	}
+	if p := s.settings.HTTP.security; p != nil {
+		p.set(w)
+	}
+	endpoint, handle := s.route(r)
+	after := s.After.of(endpoint)
+
+	handle = s.recovering(endpoint, handle)
+	if s.settings.HTTP.compress {
+		handle = s.settings.HTTP.compression.handler(handle)
+	}
+
+	r, span := s.startRequestSpan(endpoint, r)
+	firewall := s.settings.Policy.firewall
+	emits := s.settings.HTTP.events != nil || len(s.settings.Sys.webhooks) > 0
+	if s.metrics == nil && len(after) == 0 && span == nil && (firewall == nil || firewall.bans == nil) && !emits {
+		handle(w, r)
+		return
+	}
+
+	start := time.Now()
+	rw := newResponseWriter(w)
+	body := &countingReader{r: r.Body}
+	r.Body = body
+
+	handle(rw, r)
+
+	response := Response{
+		Status:   rw.Status(),
+		Written:  rw.Written(),
+		Duration: time.Since(start),
+		Reason:   rw.Header().Get("X-Reason"),
+	}
+	s.metrics.ObserveRequest(endpointLabel(endpoint), r.Method, response.Status, response.Duration, body.n, response.Written)
+
+	req := state.parsed
+	if req == nil {
+		// the request failed before being parsed
+		req = &request{id: state.id, ip: s.ip(r), raw: r, state: state}
+	}
+
+	endRequestSpan(span, *req, response)
+	firewall.observeIP(endpoint, req.ip, response.Status)
+	if emits {
+		if event, ok := eventOf(endpoint, req, response, state.hash); ok {
+			s.emit(event)
+		}
+	}
+	for _, hook := range after {
+		hook(*req, response)
+	}
+}
+
+// stripPrefix returns a shallow copy of the request without the prefix in its path,
+// or false if the path is not under the prefix. The prefix must start with a '/' and not end with one.
+func stripPrefix(r *http.Request, prefix string) (*http.Request, bool) {
+	path, ok := strings.CutPrefix(r.URL.Path, prefix)
+	if !ok || path != "" && path[0] != '/' {
+		return r, false
+	}
+	if path == "" {
+		path = "/"
+	}
+
+	stripped := new(http.Request)
+	*stripped = *r
+	stripped.URL = new(url.URL)
+	*stripped.URL = *r.URL
+	stripped.URL.Path = path
+	stripped.URL.RawPath = ""
+	return stripped, true
+}
 
+// route returns the [Endpoint] of the request and the function that handles it.
+// If the request doesn't belong to any endpoint, the returned endpoint is empty.
+func (s *Server) route(r *http.Request) (Endpoint, http.HandlerFunc) {
 	switch {
 	case r.URL.Path == "/upload" && r.Method == http.MethodPut:
-		s.HandleUpload(w, r)
+		return EndpointUpload, s.HandleUpload
 
 	case r.URL.Path == "/upload" && r.Method == http.MethodHead:
-		s.HandleUploadCheck(w, r)
+		return EndpointUpload, s.HandleUploadCheck
+
+	case r.URL.Path == "/upload" && r.Method == http.MethodPost && s.settings.Upload.multipart && isMultipart(r):
+		return EndpointUpload, s.HandleUpload
+
+	case r.URL.Path == "/upload" && r.Method == http.MethodPost && s.settings.Upload.sessions != nil:
+		return EndpointUpload, s.HandleUploadCreate
+
+	case strings.HasPrefix(r.URL.Path, "/upload/") && s.settings.Upload.sessions != nil:
+		return EndpointUpload, s.HandleUploadSession
 
 	case r.URL.Path == "/media" && r.Method == http.MethodPut:
-		s.HandleMedia(w, r)
+		return EndpointMedia, s.HandleMedia
 
 	case r.URL.Path == "/media" && r.Method == http.MethodHead:
-		s.HandleMediaCheck(w, r)
+		return EndpointMedia, s.HandleMediaCheck
 
 	case r.URL.Path == "/mirror" && r.Method == http.MethodPut:
-		s.HandleMirror(w, r)
+		return EndpointMirror, s.HandleMirror
 
 	case r.URL.Path == "/report" && r.Method == http.MethodPut:
-		s.HandleReport(w, r)
+		return EndpointReport, s.HandleReport
+
+	case strings.HasPrefix(r.URL.Path, "/list/") && strings.HasSuffix(r.URL.Path, "/stats") && r.Method == http.MethodGet:
+		return EndpointList, s.HandleListStats
+
+	case strings.HasPrefix(r.URL.Path, "/list/") && r.Method == http.MethodGet:
+		return EndpointList, s.HandleList
+
+	case s.settings.HTTP.events != nil && r.URL.Path == s.settings.HTTP.events.path && r.Method == http.MethodGet:
+		return "", s.HandleEvents
+
+	case s.isNIP05(r):
+		return "", s.HandleNIP05
+
+	case s.settings.HTTP.infoPath != "" && r.URL.Path == s.settings.HTTP.infoPath && r.Method == http.MethodGet:
+		return "", s.HandleInfo
+
+	case s.settings.HTTP.root != nil && (r.Method == http.MethodGet || r.Method == http.MethodHead) && !isBlobPath(r.URL.Path):
+		return "", s.settings.HTTP.root.ServeHTTP
 
 	case r.Method == http.MethodGet:
-		s.HandleDownload(w, r)
+		return EndpointDownload, s.HandleDownload
 
 	case r.Method == http.MethodHead:
-		s.HandleCheck(w, r)
+		return EndpointCheck, s.HandleCheck
 
 	case r.Method == http.MethodDelete:
-		s.HandleDelete(w, r)
+		return EndpointDelete, s.HandleDelete
 
 	case r.Method == http.MethodOptions:
-		w.WriteHeader(http.StatusOK)
+		return "", handleOptions
 
 	default:
-		http.Error(w, "Unsupported request", http.StatusMethodNotAllowed)
+		return "", handleUnsupported
 	}
 }
 
+func handleOptions(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleUnsupported(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "Unsupported request", http.StatusMethodNotAllowed)
+}
+
 // HandleDownload handles the GET /<sha256>.<ext> endpoint.
 func (s *Server) HandleDownload(w http.ResponseWriter, r *http.Request) {
 	req, hash, ext, err := s.parseFetch(r)
@@ -150,15 +527,29 @@ func (s *Server) HandleDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for _, reject := range s.Reject.Download {
+	if err = s.checkPolicy(w, EndpointDownload, req); err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	if err = s.checkBlocklist(EndpointDownload, hash); err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	for _, reject := range s.Reject.Download.hooks {
 		if err = reject(req, hash, ext); err != nil {
+			s.observeRejection(EndpointDownload, err)
 			blossom.WriteError(w, err)
 			return
 		}
 	}
 
-	result, err := s.On.Download(req, hash, ext)
+	end := s.startHook(&req, EndpointDownload)
+	result, err := s.download(req, hash, ext)
+	err = end(err)
 	if err != nil {
+		s.observeHookError(EndpointDownload, err)
 		blossom.WriteError(w, err)
 		return
 	}
@@ -167,33 +558,103 @@ func (s *Server) HandleDownload(w http.ResponseWriter, r *http.Request) {
 	case servedBlob:
 		blob := result.Blob
 		if blob == nil {
-			s.log.Error("handle download: blob is nil")
+			s.logger(r).Error("handle download: blob is nil")
 			blossom.WriteError(w, blossom.ErrNotFound("Blob not found"))
 			return
 		}
 		defer blob.Close()
 
+		blob, result.delivery = s.applyContentPolicy(blob, result.delivery)
+		s.setCacheControl(w, s.settings.HTTP.cacheControl, result.delivery)
+		s.setDeliveryHeaders(w, hash, ext, blob.Type(), result.delivery)
+		etag := `"` + hash.Hex() + `"`
+		w.Header().Set("ETag", etag)
+		if inm := r.Header.Get("If-None-Match"); inm != "" && utils.MatchETag(inm, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if notModifiedSince(r, result.lastModified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
 		var err error
-		if s.settings.HTTP.acceptRanges {
-			err = blossom.ServeBlob(w, r, blob)
+		out := s.throttle(w, r)
+		if file, ok := blob.(fileBacked); ok {
+			err = s.serveFile(out, r, file, result.lastModified)
+		} else if s.settings.HTTP.acceptRanges {
+			err = blossom.ServeBlob(out, r, blob)
 		} else {
-			err = blossom.WriteBlob(w, blob)
+			err = blossom.WriteBlob(out, blob)
 		}
 
 		if err != nil {
-			s.log.Error("failure in GET /<sha256>", "error", err, "hash", hash)
+			s.logger(r).Error("failure in GET /<sha256>", "error", err, "hash", hash)
 			return
 		}
 
 	case redirect:
+		s.setCacheControl(w, "", result.delivery)
 		http.Redirect(w, r, result.url, result.code)
 
+	case blockedBlob:
+		s.metrics.ObserveBlocked(endpointLabel(EndpointDownload))
+		blossom.WriteError(w, ErrBlocked(result.reason))
+
 	default:
-		s.log.Error("handle download: unknown blob delivery type", "type", reflect.TypeOf(result))
+		s.logger(r).Error("handle download: unknown blob delivery type", "type", reflect.TypeOf(result))
 		blossom.WriteError(w, blossom.ErrInternal("Unknown blob delivery type"))
 	}
 }
 
+// setCacheControl sets the 'Cache-Control' header of the response, unless the directives are empty.
+// The delivery can override the default directives with [CacheControl].
+func (s *Server) setCacheControl(w http.ResponseWriter, directives string, d delivery) {
+	if d.cacheControl != nil {
+		directives = *d.cacheControl
+	}
+	if directives != "" {
+		w.Header().Set("Cache-Control", directives)
+	}
+}
+
+// setDeliveryHeaders sets the 'Last-Modified', 'Content-Disposition' and security headers of the response of the blob.
+// The delivery can override the default disposition configured with [WithContentDisposition].
+func (s *Server) setDeliveryHeaders(w http.ResponseWriter, hash blossom.Hash, ext, contentType string, d delivery) {
+	if p := s.settings.HTTP.security; p != nil {
+		p.setBlob(w, contentType)
+	}
+	if !d.lastModified.IsZero() {
+		w.Header().Set("Last-Modified", d.lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	disposition, filename := s.settings.HTTP.disposition, ""
+	if d.disposition != "" {
+		disposition, filename = d.disposition, d.filename
+	}
+	if disposition == "" {
+		return
+	}
+
+	if filename == "" {
+		filename = hash.Hex() + "." + cmp.Or(ext, s.ExtForMime(contentType))
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+}
+
+// notModifiedSince reports whether the blob modified at t can be answered with 304 Not Modified,
+// according to the 'If-Modified-Since' header. As per RFC 9110, the header is ignored with 'If-None-Match'.
+func notModifiedSince(r *http.Request, t time.Time) bool {
+	if t.IsZero() || r.Header.Get("If-None-Match") != "" {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !t.Truncate(time.Second).After(since)
+}
+
 // HandleCheck handles the HEAD /<sha256>.<ext> endpoint.
 func (s *Server) HandleCheck(w http.ResponseWriter, r *http.Request) {
 	req, hash, ext, err := s.parseFetch(r)
@@ -202,21 +663,49 @@ func (s *Server) HandleCheck(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for _, reject := range s.Reject.Check {
+	if err = s.checkPolicy(w, EndpointCheck, req); err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	if err = s.checkBlocklist(EndpointCheck, hash); err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	for _, reject := range s.Reject.Check.hooks {
 		if err = reject(req, hash, ext); err != nil {
+			s.observeRejection(EndpointCheck, err)
 			blossom.WriteError(w, err)
 			return
 		}
 	}
 
-	result, err := s.On.Check(req, hash, ext)
+	end := s.startHook(&req, EndpointCheck)
+	result, err := s.check(req, hash, ext)
+	err = end(err)
 	if err != nil {
+		s.observeHookError(EndpointCheck, err)
 		blossom.WriteError(w, err)
 		return
 	}
 
 	switch result := result.(type) {
 	case foundBlob:
+		result.mime, result.delivery = s.applyContentPolicyMeta(result.mime, result.delivery)
+		s.setCacheControl(w, s.settings.HTTP.cacheControl, result.delivery)
+		s.setDeliveryHeaders(w, hash, ext, result.mime, result.delivery)
+		etag := `"` + hash.Hex() + `"`
+		w.Header().Set("ETag", etag)
+		if inm := r.Header.Get("If-None-Match"); inm != "" && utils.MatchETag(inm, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if notModifiedSince(r, result.lastModified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
 		if s.settings.HTTP.acceptRanges {
 			w.Header().Set("Accept-Ranges", "bytes")
 		}
@@ -225,10 +714,15 @@ func (s *Server) HandleCheck(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 
 	case redirect:
+		s.setCacheControl(w, "", result.delivery)
 		http.Redirect(w, r, result.url, result.code)
 
+	case blockedBlob:
+		s.metrics.ObserveBlocked(endpointLabel(EndpointCheck))
+		blossom.WriteError(w, ErrBlocked(result.reason))
+
 	default:
-		s.log.Error("handle check: unknown check result type", "type", reflect.TypeOf(result))
+		s.logger(r).Error("handle check: unknown check result type", "type", reflect.TypeOf(result))
 		blossom.WriteError(w, blossom.ErrInternal("Unknown check result type"))
 	}
 }
@@ -248,21 +742,33 @@ func (s *Server) HandleDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for _, reject := range s.Reject.Delete {
+	if err = s.checkPolicy(w, EndpointDelete, req); err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	for _, reject := range s.Reject.Delete.hooks {
 		if err = reject(req, hash); err != nil {
+			s.observeRejection(EndpointDelete, err)
 			blossom.WriteError(w, err)
 			return
 		}
 	}
 
-	if err = s.On.Delete(req, hash); err != nil {
+	end := s.startHook(&req, EndpointDelete)
+	err = s.On.Delete(req, hash)
+	err = end(err)
+	if err != nil {
+		s.observeHookError(EndpointDelete, err)
 		blossom.WriteError(w, err)
 		return
 	}
+
+	s.Evict(hash)
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// HandleUpload handles the PUT /upload endpoint.
+// HandleUpload handles the PUT /upload endpoint, and the POST /upload of multipart forms if enabled with [WithMultipartUploads].
 func (s *Server) HandleUpload(w http.ResponseWriter, r *http.Request) {
 	if s.On.Upload == nil {
 		// upload endpoint is optional
@@ -278,37 +784,113 @@ func (s *Server) HandleUpload(w http.ResponseWriter, r *http.Request) {
 	}
 	defer body.Close()
 
-	for _, reject := range s.Reject.Upload {
+	if err = s.checkPolicy(w, EndpointUpload, req); err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	data, err := s.checkUpload(EndpointUpload, hints, body)
+	if err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	if hints.Hash != nil {
+		if err = s.checkBlocklist(EndpointUpload, *hints.Hash); err != nil {
+			blossom.WriteError(w, err)
+			return
+		}
+	}
+
+	for _, reject := range s.Reject.Upload.hooks {
 		if err = reject(req, hints); err != nil {
+			s.observeRejection(EndpointUpload, err)
 			blossom.WriteError(w, err)
 			return
 		}
 	}
 
-	desc, err := s.On.Upload(req, hints, body)
+	release, err := s.acquireUpload(w, req)
 	if err != nil {
+		s.observeRejection(EndpointUpload, err)
+		blossom.WriteError(w, err)
+		return
+	}
+	defer release()
+
+	var limiter *sizeLimiter
+	if max := s.settings.Upload.maxSize; max > 0 {
+		limiter = &sizeLimiter{r: data, max: max}
+		data = limiter
+	}
+
+	var verifier *utils.HashReader
+	if s.verifyUpload(r, hints) {
+		verifier = utils.NewHashReader(data, hints.Hash)
+		data = verifier
+	}
+
+	data, blocker := s.wrapUpload(data)
+	data, inspector := s.inspectUpload(req, hints, data, s.Reject.UploadContent.hooks)
+
+	blob, blobHints, stripped := s.stripMetadata(EndpointUpload, hints, data)
+	end := s.startHook(&req, EndpointUpload)
+	desc, err := s.On.Upload(req, blobHints, blob)
+	err = end(err)
+	if inspector != nil && inspector.rejected != nil {
+		err = inspector.rejected
+		s.observeRejection(EndpointUpload, err)
+		w.Header().Set("Connection", "close") // don't wait for the rest of the body
+		blossom.WriteError(w, err)
+		return
+	}
+	if limiter != nil && limiter.exceeded() {
+		err = s.errTooLarge()
+		s.observeRejection(EndpointUpload, err)
+		blossom.WriteError(w, err)
+		return
+	}
+	if verifier != nil && verifier.Mismatch() {
+		err = errDigestMismatch()
+		s.observeRejection(EndpointUpload, err)
+		blossom.WriteError(w, err)
+		return
+	}
+	if blocker != nil && blocker.blocked {
+		err = errBlocked()
+		s.observeRejection(EndpointUpload, err)
+		blossom.WriteError(w, err)
+		return
+	}
+	if err != nil {
+		s.observeHookError(EndpointUpload, err)
 		blossom.WriteError(w, err)
 		return
 	}
 
 	if desc.URL == "" {
 		// derive the URL if not set
-		url, err := s.deriveURL(desc)
+		url, err := s.deriveURL(r, desc)
 		if err != nil {
-			s.log.Error("handle upload: failed to derive URL", "error", err)
+			s.logger(r).Error("handle upload: failed to derive URL", "error", err)
 			blossom.WriteError(w, blossom.ErrInternal(err.Error()))
 			return
 		}
 		desc.URL = url
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(desc); err != nil {
-		s.log.Error("failed to encode blob descriptor", "error", err, "hash", desc.Hash)
+	var original *blossom.Hash
+	if stripped && verifier != nil {
+		original = hints.Hash
 	}
+	s.writeDescriptor(w, req, desc, original)
 }
 
-// HandleUploadCheck handles the HEAD /upload endpoint.
+// HandleUploadCheck handles the HEAD /upload endpoint, which tells clients whether an upload would be accepted (BUD-06).
+// It responds with 200 (OK) if the upload would be accepted, otherwise with the error status code
+// and the reason in the 'X-Reason' header: 400 for missing or invalid headers, 401 for invalid authorization,
+// 413 and 415 for blobs exceeding the upload limits (see [WithMaxUploadSize] and [WithAllowedTypes]),
+// or the code returned by the Reject hooks.
 func (s *Server) HandleUploadCheck(w http.ResponseWriter, r *http.Request) {
 	if s.On.Upload == nil {
 		// upload endpoint is optional
@@ -323,8 +905,26 @@ func (s *Server) HandleUploadCheck(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for _, reject := range s.Reject.Upload {
+	if err = s.checkPolicy(w, EndpointUpload, req); err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	if _, err = s.checkUpload(EndpointUpload, hints, nil); err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	if hints.Hash != nil {
+		if err = s.checkBlocklist(EndpointUpload, *hints.Hash); err != nil {
+			blossom.WriteError(w, err)
+			return
+		}
+	}
+
+	for _, reject := range s.Reject.Upload.hooks {
 		if err = reject(req, hints); err != nil {
+			s.observeRejection(EndpointUpload, err)
 			blossom.WriteError(w, err)
 			return
 		}
@@ -347,34 +947,55 @@ func (s *Server) HandleMirror(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for _, reject := range s.Reject.Mirror {
+	if err = s.checkPolicy(w, EndpointMirror, req); err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	if hash, _, err := utils.ParseHashExt(url.Path); err == nil {
+		if err := s.checkBlocklist(EndpointMirror, hash); err != nil {
+			blossom.WriteError(w, err)
+			return
+		}
+	}
+
+	for _, reject := range s.Reject.Mirror.hooks {
 		if err = reject(req, url); err != nil {
+			s.observeRejection(EndpointMirror, err)
 			blossom.WriteError(w, err)
 			return
 		}
 	}
 
+	release, err := s.acquireUpload(w, req)
+	if err != nil {
+		s.observeRejection(EndpointMirror, err)
+		blossom.WriteError(w, err)
+		return
+	}
+	defer release()
+
+	end := s.startHook(&req, EndpointMirror)
 	desc, err := s.On.Mirror(req, url)
+	err = end(err)
 	if err != nil {
+		s.observeHookError(EndpointMirror, err)
 		blossom.WriteError(w, err)
 		return
 	}
 
 	if desc.URL == "" {
 		// derive the URL if not set
-		url, err := s.deriveURL(desc)
+		url, err := s.deriveURL(r, desc)
 		if err != nil {
-			s.log.Error("handle mirror: failed to derive URL", "error", err)
+			s.logger(r).Error("handle mirror: failed to derive URL", "error", err)
 			blossom.WriteError(w, blossom.ErrInternal(err.Error()))
 			return
 		}
 		desc.URL = url
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(desc); err != nil {
-		s.log.Error("failed to encode blob descriptor", "error", err, "hash", desc.Hash)
-	}
+	s.writeDescriptor(w, req, desc, nil)
 }
 
 // HandleMedia handles the PUT /media endpoint.
@@ -393,37 +1014,113 @@ func (s *Server) HandleMedia(w http.ResponseWriter, r *http.Request) {
 	}
 	defer body.Close()
 
-	for _, reject := range s.Reject.Media {
+	if err = s.checkPolicy(w, EndpointMedia, req); err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	data, err := s.checkUpload(EndpointMedia, hints, body)
+	if err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	if hints.Hash != nil {
+		if err = s.checkBlocklist(EndpointMedia, *hints.Hash); err != nil {
+			blossom.WriteError(w, err)
+			return
+		}
+	}
+
+	for _, reject := range s.Reject.Media.hooks {
 		if err = reject(req, hints); err != nil {
+			s.observeRejection(EndpointMedia, err)
 			blossom.WriteError(w, err)
 			return
 		}
 	}
 
-	desc, err := s.On.Media(req, hints, body)
+	release, err := s.acquireUpload(w, req)
 	if err != nil {
+		s.observeRejection(EndpointMedia, err)
+		blossom.WriteError(w, err)
+		return
+	}
+	defer release()
+
+	var limiter *sizeLimiter
+	if max := s.settings.Upload.maxSize; max > 0 {
+		limiter = &sizeLimiter{r: data, max: max}
+		data = limiter
+	}
+
+	var verifier *utils.HashReader
+	if s.verifyUpload(r, hints) {
+		verifier = utils.NewHashReader(data, hints.Hash)
+		data = verifier
+	}
+
+	data, blocker := s.wrapUpload(data)
+	data, inspector := s.inspectUpload(req, hints, data, s.Reject.MediaContent.hooks)
+
+	blob, blobHints, stripped := s.stripMetadata(EndpointMedia, hints, data)
+	end := s.startHook(&req, EndpointMedia)
+	desc, transformed, err := s.callMedia(req, blobHints, blob)
+	err = end(err)
+	if inspector != nil && inspector.rejected != nil {
+		err = inspector.rejected
+		s.observeRejection(EndpointMedia, err)
+		w.Header().Set("Connection", "close") // don't wait for the rest of the body
+		blossom.WriteError(w, err)
+		return
+	}
+	if limiter != nil && limiter.exceeded() {
+		err = s.errTooLarge()
+		s.observeRejection(EndpointMedia, err)
+		blossom.WriteError(w, err)
+		return
+	}
+	if verifier != nil && verifier.Mismatch() {
+		err = errDigestMismatch()
+		s.observeRejection(EndpointMedia, err)
+		blossom.WriteError(w, err)
+		return
+	}
+	if blocker != nil && blocker.blocked {
+		err = errBlocked()
+		s.observeRejection(EndpointMedia, err)
+		blossom.WriteError(w, err)
+		return
+	}
+	if err != nil {
+		s.observeHookError(EndpointMedia, err)
 		blossom.WriteError(w, err)
 		return
 	}
 
 	if desc.URL == "" {
 		// derive the URL if not set
-		url, err := s.deriveURL(desc)
+		url, err := s.deriveURL(r, desc)
 		if err != nil {
-			s.log.Error("handle media: failed to derive URL", "error", err)
+			s.logger(r).Error("handle media: failed to derive URL", "error", err)
 			blossom.WriteError(w, blossom.ErrInternal(err.Error()))
 			return
 		}
 		desc.URL = url
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(desc); err != nil {
-		s.log.Error("failed to encode blob descriptor", "error", err, "hash", desc.Hash)
+	var original *blossom.Hash
+	if (stripped || transformed) && verifier != nil {
+		original = hints.Hash
 	}
+	s.writeDescriptor(w, req, desc, original)
 }
 
-// HandleMediaCheck handles the HEAD /media endpoint.
+// HandleMediaCheck handles the HEAD /media endpoint, which tells clients whether an upload would be accepted (BUD-06).
+// It responds with 200 (OK) if the upload would be accepted, otherwise with the error status code
+// and the reason in the 'X-Reason' header: 400 for missing or invalid headers, 401 for invalid authorization,
+// 413 and 415 for blobs exceeding the upload limits (see [WithMaxUploadSize] and [WithAllowedTypes]),
+// or the code returned by the Reject hooks.
 func (s *Server) HandleMediaCheck(w http.ResponseWriter, r *http.Request) {
 	if s.On.Media == nil {
 		// media endpoint is optional
@@ -438,8 +1135,26 @@ func (s *Server) HandleMediaCheck(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for _, reject := range s.Reject.Media {
+	if err = s.checkPolicy(w, EndpointMedia, req); err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	if _, err = s.checkUpload(EndpointMedia, hints, nil); err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	if hints.Hash != nil {
+		if err = s.checkBlocklist(EndpointMedia, *hints.Hash); err != nil {
+			blossom.WriteError(w, err)
+			return
+		}
+	}
+
+	for _, reject := range s.Reject.Media.hooks {
 		if err = reject(req, hints); err != nil {
+			s.observeRejection(EndpointMedia, err)
 			blossom.WriteError(w, err)
 			return
 		}
@@ -462,25 +1177,134 @@ func (s *Server) HandleReport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for _, reject := range s.Reject.Report {
+	if err = s.checkPolicy(w, EndpointReport, req); err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	for _, reject := range s.Reject.Report.hooks {
 		if err = reject(req, report); err != nil {
+			s.observeRejection(EndpointReport, err)
 			blossom.WriteError(w, err)
 			return
 		}
 	}
 
-	if err = s.On.Report(req, report); err != nil {
+	end := s.startHook(&req, EndpointReport)
+	err = s.On.Report(req, report)
+	err = end(err)
+	if err != nil {
+		s.observeHookError(EndpointReport, err)
 		blossom.WriteError(w, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
-// setCORS sets CORS headers as required by BUD-01.
-func setCORS(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, PUT, DELETE")
-	w.Header().Set("Access-Control-Allow-Headers", "Authorization, *")
-	w.Header().Set("Access-Control-Max-Age", "86400")
-	w.Header().Set("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+// HandleList handles the GET /list/<pubkey> endpoint.
+func (s *Server) HandleList(w http.ResponseWriter, r *http.Request) {
+	if s.On.List == nil {
+		// list endpoint is optional
+		err := blossom.ErrNotImplemented("The List hook is not configured")
+		blossom.WriteError(w, err)
+		return
+	}
+
+	req, pubkey, query, err := s.parseList(r)
+	if err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	if err = s.checkPolicy(w, EndpointList, req); err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	for _, reject := range s.Reject.List.hooks {
+		if err = reject(req, pubkey, query); err != nil {
+			s.observeRejection(EndpointList, err)
+			blossom.WriteError(w, err)
+			return
+		}
+	}
+
+	end := s.startHook(&req, EndpointList)
+	descs, err := s.On.List(req, pubkey, query)
+	err = end(err)
+	if err != nil {
+		s.observeHookError(EndpointList, err)
+		blossom.WriteError(w, err)
+		return
+	}
+
+	if descs == nil {
+		// always encode an array, never null
+		descs = []blossom.BlobDescriptor{}
+	}
+	if query.Limit > 0 && len(descs) > query.Limit {
+		descs = descs[:query.Limit]
+	}
+	s.setNextLink(w, pubkey, query, descs)
+
+	for i := range descs {
+		if descs[i].URL == "" {
+			// derive the URL if not set
+			url, err := s.deriveURL(r, descs[i])
+			if err != nil {
+				s.logger(r).Error("handle list: failed to derive URL", "error", err)
+				blossom.WriteError(w, blossom.ErrInternal(err.Error()))
+				return
+			}
+			descs[i].URL = url
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(descs); err != nil {
+		s.logger(r).Error("failed to encode blob descriptors", "error", err, "pubkey", pubkey)
+	}
+}
+
+// HandleListStats handles the GET /list/<pubkey>/stats endpoint.
+func (s *Server) HandleListStats(w http.ResponseWriter, r *http.Request) {
+	if s.On.Stats == nil {
+		// stats endpoint is optional
+		err := blossom.ErrNotImplemented("The Stats hook is not configured")
+		blossom.WriteError(w, err)
+		return
+	}
+
+	req, pubkey, err := s.parseListStats(r)
+	if err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	if err = s.checkPolicy(w, EndpointList, req); err != nil {
+		blossom.WriteError(w, err)
+		return
+	}
+
+	for _, reject := range s.Reject.List.hooks {
+		if err = reject(req, pubkey, ListQuery{}); err != nil {
+			s.observeRejection(EndpointList, err)
+			blossom.WriteError(w, err)
+			return
+		}
+	}
+
+	end := s.startHook(&req, EndpointList)
+	stats, err := s.On.Stats(req, pubkey)
+	err = end(err)
+	if err != nil {
+		s.observeHookError(EndpointList, err)
+		blossom.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		s.logger(r).Error("failed to encode the stats", "error", err, "pubkey", pubkey)
+	}
 }
@@ -0,0 +1,673 @@
+package blossy
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy/auth"
+	"github.com/pippellia-btc/blossy/metrics"
+)
+
+const testHostname = "localhost"
+
+// newTestServer returns a server with the test hostname and the options, listening on a local address.
+// The listener is closed when the test ends.
+func newTestServer(t *testing.T, opts ...Option) (*Server, *httptest.Server) {
+	t.Helper()
+
+	server, err := NewServer(append([]Option{WithHostname(testHostname)}, opts...)...)
+	if err != nil {
+		t.Fatalf("failed to create the server: %v", err)
+	}
+
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+	return server, ts
+}
+
+// do sends the request, failing the test if the server can't be reached.
+// The body of the response is closed when the test ends.
+func do(t *testing.T, r *http.Request) *http.Response {
+	t.Helper()
+
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+// authorize sets the 'Authorization' header of the request to a blossom authorization event for the action,
+// signed with the secret key and bound to the hashes and the test hostname.
+func authorize(t *testing.T, r *http.Request, sk string, action auth.Action, hashes ...blossom.Hash) {
+	t.Helper()
+
+	event := nostr.Event{
+		Kind:      auth.KindBlossomAuth,
+		CreatedAt: nostr.Now(),
+		Tags: nostr.Tags{
+			{"t", string(action)},
+			{"expiration", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)},
+			{"server", testHostname},
+		},
+	}
+	for _, hash := range hashes {
+		event.Tags = append(event.Tags, nostr.Tag{"x", hash.Hex()})
+	}
+	if err := event.Sign(sk); err != nil {
+		t.Fatalf("failed to sign the authorization event: %v", err)
+	}
+
+	r.Header.Set("Authorization", "Nostr "+base64.StdEncoding.EncodeToString([]byte(event.String())))
+}
+
+func TestUploadVerification(t *testing.T) {
+	data := []byte("the declared blob")
+	hash := blossom.ComputeHash(data)
+
+	tests := []struct {
+		name   string
+		verify bool
+		body   string
+		status int
+		stored bool
+	}{
+		{"verified match", true, string(data), http.StatusOK, true},
+		{"verified mismatch", true, "another blob", http.StatusBadRequest, false},
+		{"unverified mismatch", false, "another blob", http.StatusOK, true},
+	}
+	rejection := `blossy_rejections_total{endpoint="upload",code="400"} 1`
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			m := metrics.New()
+			opts := []Option{WithMetrics(m)}
+			if test.verify {
+				opts = append(opts, WithUploadVerification())
+			}
+			server, ts := newTestServer(t, opts...)
+
+			// the hook trusts the declared hash, relying on the server to verify the body
+			stored := false
+			server.On.Upload = func(r Request, hints UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+				b, err := io.ReadAll(data)
+				if err != nil {
+					return blossom.BlobDescriptor{}, blossom.ErrBadRequest(err.Error())
+				}
+				stored = true
+				return blossom.BlobDescriptor{Hash: *hints.Hash, Size: int64(len(b)), Type: "text/plain"}, nil
+			}
+
+			r, _ := http.NewRequest(http.MethodPut, ts.URL+"/upload", strings.NewReader(test.body))
+			r.Header.Set("Content-Digest", hash.Hex())
+			authorize(t, r, nostr.GeneratePrivateKey(), auth.ActionUpload, hash)
+
+			res := do(t, r)
+			if res.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d (%s)", test.status, res.StatusCode, res.Header.Get("X-Reason"))
+			}
+			if stored != test.stored {
+				t.Errorf("expected stored to be %v, got %v", test.stored, stored)
+			}
+
+			var b strings.Builder
+			m.WriteTo(&b)
+			if rejected := strings.Contains(b.String(), rejection); rejected == test.stored {
+				t.Errorf("expected the rejection to be recorded to be %v, got %v", !test.stored, rejected)
+			}
+		})
+	}
+}
+
+func TestUse(t *testing.T) {
+	tests := []struct {
+		name   string
+		block  bool
+		status int
+		calls  []string
+	}{
+		{"through the router", false, http.StatusNotFound, []string{"outer in", "middle in", "hook", "middle out", "outer out"}},
+		{"short-circuited", true, http.StatusTeapot, []string{"outer in", "middle in", "middle out", "outer out"}},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			server, ts := newTestServer(t)
+
+			var mu sync.Mutex
+			var calls []string
+			record := func(call string) {
+				mu.Lock()
+				defer mu.Unlock()
+				calls = append(calls, call)
+			}
+
+			trace := func(name string) Middleware {
+				return func(next http.Handler) http.Handler {
+					return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						record(name + " in")
+						next.ServeHTTP(w, r)
+						record(name + " out")
+					})
+				}
+			}
+
+			server.Use(trace("outer"), trace("middle"))
+			server.Use(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.Header.Get("X-Block") != "" {
+						http.Error(w, "blocked by the middleware", http.StatusTeapot)
+						return
+					}
+					next.ServeHTTP(w, r)
+				})
+			})
+			server.On.Download = func(r Request, hash blossom.Hash, ext string) (BlobDelivery, *blossom.Error) {
+				record("hook")
+				return nil, blossom.ErrNotFound("not found")
+			}
+
+			r, _ := http.NewRequest(http.MethodGet, ts.URL+"/"+blossom.ComputeHash([]byte("missing")).Hex(), nil)
+			if test.block {
+				r.Header.Set("X-Block", "1")
+			}
+
+			res := do(t, r)
+			if res.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d", test.status, res.StatusCode)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !slices.Equal(calls, test.calls) {
+				t.Errorf("expected the calls %v, got %v", test.calls, calls)
+			}
+		})
+	}
+}
+
+// selfSignedCert writes a self-signed certificate for the test hostname and 127.0.0.1 to a temporary directory,
+// returning the paths of the certificate and key files, and a pool that trusts the certificate.
+func selfSignedCert(t *testing.T) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: testHostname},
+		DNSNames:     []string{testHostname},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+// freeAddress returns a local address that is free to listen on.
+func freeAddress(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// waitGet sends GET requests to the URL with the client until the server is reachable.
+func waitGet(t *testing.T, client *http.Client, url string) *http.Response {
+	t.Helper()
+
+	var err error
+	for range 50 {
+		var res *http.Response
+		if res, err = client.Get(url); err == nil {
+			t.Cleanup(func() { res.Body.Close() })
+			return res
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("failed to reach the server: %v", err)
+	return nil
+}
+
+func TestStartAndServeTLS(t *testing.T) {
+	certFile, keyFile, pool := selfSignedCert(t)
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		option Option
+	}{
+		{"certificate files", WithTLS(certFile, keyFile)},
+		{"tls config", WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{pair}})},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			server, err := NewServer(WithHostname(testHostname), test.option)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			address := freeAddress(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- server.StartAndServe(ctx, address) }()
+
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+			res := waitGet(t, client, "https://"+address+"/"+blossom.ComputeHash([]byte("missing")).Hex())
+			if res.TLS == nil {
+				t.Error("expected the response to be served over TLS")
+			}
+			if res.StatusCode != http.StatusNotFound {
+				t.Errorf("expected status %d, got %d", http.StatusNotFound, res.StatusCode)
+			}
+
+			cancel()
+			if err := <-done; err != nil {
+				t.Fatalf("expected a graceful shutdown, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNewHTTPServer(t *testing.T) {
+	tests := []struct {
+		name              string
+		opts              []Option
+		readHeaderTimeout time.Duration
+		maxHeaderBytes    int
+	}{
+		{"defaults", nil, 5 * time.Second, 0},
+		{"option", []Option{WithReadHeaderTimeout(10 * time.Second)}, 10 * time.Second, 0},
+		{
+			"customized",
+			[]Option{
+				WithHTTPServer(func(s *http.Server) {
+					s.ReadHeaderTimeout = 20 * time.Second
+					s.MaxHeaderBytes = 64 << 10
+				}),
+				WithReadHeaderTimeout(10 * time.Second),
+			},
+			20 * time.Second, 64 << 10,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			server, err := NewServer(append([]Option{WithHostname(testHostname)}, test.opts...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			hs := server.newHTTPServer(":3335")
+			if hs.Addr != ":3335" || hs.Handler != server {
+				t.Errorf("expected the address and the handler of the server, got %q %v", hs.Addr, hs.Handler)
+			}
+			if hs.ReadHeaderTimeout != test.readHeaderTimeout {
+				t.Errorf("expected read header timeout %v, got %v", test.readHeaderTimeout, hs.ReadHeaderTimeout)
+			}
+			if hs.MaxHeaderBytes != test.maxHeaderBytes {
+				t.Errorf("expected max header bytes %d, got %d", test.maxHeaderBytes, hs.MaxHeaderBytes)
+			}
+		})
+	}
+}
+
+func TestWithHTTPServer(t *testing.T) {
+	type baseKey struct{}
+
+	var conns atomic.Int32
+	server, err := NewServer(
+		WithHostname(testHostname),
+		WithHTTPServer(func(s *http.Server) {
+			s.ConnState = func(c net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					conns.Add(1)
+				}
+			}
+			s.BaseContext = func(net.Listener) context.Context {
+				return context.WithValue(context.Background(), baseKey{}, "base")
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bases := make(chan any, 1)
+	server.On.Download = func(r Request, hash blossom.Hash, ext string) (BlobDelivery, *blossom.Error) {
+		bases <- r.Context().Value(baseKey{})
+		return nil, blossom.ErrNotFound("not found")
+	}
+
+	address := freeAddress(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.StartAndServe(ctx, address) }()
+
+	res := waitGet(t, http.DefaultClient, "http://"+address+"/"+blossom.ComputeHash([]byte("missing")).Hex())
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, res.StatusCode)
+	}
+	if base := <-bases; base != "base" {
+		t.Errorf("expected the request context to derive from the BaseContext, got %v", base)
+	}
+	if conns.Load() == 0 {
+		t.Error("expected the ConnState callback to observe the connection")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("expected a graceful shutdown, got %v", err)
+	}
+}
+
+func TestServe(t *testing.T) {
+	certFile, keyFile, pool := selfSignedCert(t)
+	tests := []struct {
+		name   string
+		opts   []Option
+		scheme string
+		client *http.Client
+	}{
+		{"plain", nil, "http", http.DefaultClient},
+		{"tls", []Option{WithTLS(certFile, keyFile)}, "https", &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			server, err := NewServer(append([]Option{WithHostname(testHostname)}, test.opts...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			started, release := make(chan struct{}), make(chan struct{})
+			server.On.Download = func(r Request, hash blossom.Hash, ext string) (BlobDelivery, *blossom.Error) {
+				close(started)
+				<-release
+				return nil, blossom.ErrNotFound("not found")
+			}
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			address := listener.Addr().String()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- server.Serve(ctx, listener) }()
+
+			statuses := make(chan int, 1)
+			go func() {
+				res, err := test.client.Get(test.scheme + "://" + address + "/" + blossom.ComputeHash([]byte("missing")).Hex())
+				if err != nil {
+					t.Errorf("failed to reach the server: %v", err)
+					statuses <- 0
+					return
+				}
+				res.Body.Close()
+				statuses <- res.StatusCode
+			}()
+
+			// the in-flight request is completed before Serve returns
+			<-started
+			cancel()
+			select {
+			case err := <-done:
+				t.Fatalf("expected Serve to wait for the in-flight request, returned %v", err)
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			close(release)
+			if status := <-statuses; status != http.StatusNotFound {
+				t.Errorf("expected status %d, got %d", http.StatusNotFound, status)
+			}
+			if err := <-done; err != nil {
+				t.Fatalf("expected a graceful shutdown, got %v", err)
+			}
+			if conn, err := net.Dial("tcp", address); err == nil {
+				conn.Close()
+				t.Error("expected the listener to be closed")
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		header bool
+	}{
+		{"default", nil, false},
+		{"echoed", []Option{WithRequestIDHeader()}, true},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			var logs bytes.Buffer
+			opts := append([]Option{WithLogger(slog.New(slog.NewTextHandler(&logs, nil)))}, test.opts...)
+			server, ts := newTestServer(t, opts...)
+
+			ids := make(chan int64, 1)
+			server.On.Download = func(r Request, hash blossom.Hash, ext string) (BlobDelivery, *blossom.Error) {
+				ids <- r.ID()
+				return Serve(nil), nil // logged as an error of the request
+			}
+
+			var last int64
+			for range 3 {
+				r, _ := http.NewRequest(http.MethodGet, ts.URL+"/"+blossom.ComputeHash([]byte("missing")).Hex(), nil)
+				res := do(t, r)
+				id := <-ids
+
+				if id <= last {
+					t.Errorf("expected increasing request IDs, got %d after %d", id, last)
+				}
+				last = id
+
+				expected := ""
+				if test.header {
+					expected = strconv.FormatInt(id, 10)
+				}
+				if header := res.Header.Get("X-Request-ID"); header != expected {
+					t.Errorf("expected the 'X-Request-ID' header %q, got %q", expected, header)
+				}
+				if !strings.Contains(logs.String(), "request_id="+strconv.FormatInt(id, 10)) {
+					t.Errorf("expected the logs to contain the request ID %d, got\n%s", id, logs.String())
+				}
+			}
+		})
+	}
+}
+
+func TestAfterHooks(t *testing.T) {
+	data := []byte("hello after hooks")
+	hash := blossom.ComputeHash(data)
+	missing := blossom.ComputeHash([]byte("missing"))
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		status  int
+		written int64 // -1 stands for the size of the response body
+		reason  bool
+	}{
+		{"download", http.MethodGet, "/" + hash.Hex(), http.StatusOK, int64(len(data)), false},
+		{"check", http.MethodHead, "/" + hash.Hex(), http.StatusOK, 0, false},
+		{"not found", http.MethodGet, "/" + missing.Hex(), http.StatusNotFound, -1, true},
+		{"rejected", http.MethodPut, "/upload", http.StatusForbidden, -1, true},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			server, ts := newTestServer(t, WithRequestIDHeader())
+			server.On.Download = func(r Request, h blossom.Hash, ext string) (BlobDelivery, *blossom.Error) {
+				if h != hash {
+					return nil, blossom.ErrNotFound("not found")
+				}
+				return Serve(blossom.BlobFromBytes(data)), nil
+			}
+			server.On.Check = func(r Request, h blossom.Hash, ext string) (MetaDelivery, *blossom.Error) {
+				return Found("text/plain", int64(len(data))), nil
+			}
+			server.On.Upload = func(r Request, hints UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+				return blossom.BlobDescriptor{}, blossom.ErrInternal("unexpected upload")
+			}
+			server.Reject.Upload.Append(func(r Request, hints UploadHints) *blossom.Error {
+				return blossom.ErrForbidden("uploads are closed")
+			})
+
+			type observed struct {
+				id  int64
+				res Response
+			}
+			observations := make(chan observed, 1)
+			observe := func(r Request, res Response) { observations <- observed{id: r.ID(), res: res} }
+			server.After.Download.Append(observe)
+			server.After.Check.Append(observe)
+			server.After.Upload.Append(observe)
+
+			var body io.Reader
+			if test.method == http.MethodPut {
+				body = strings.NewReader("hello")
+			}
+			r, _ := http.NewRequest(test.method, ts.URL+test.path, body)
+			res := do(t, r)
+			content, _ := io.ReadAll(res.Body)
+
+			var o observed
+			select {
+			case o = <-observations:
+			case <-time.After(time.Second):
+				t.Fatal("expected the After hook to be invoked")
+			}
+
+			if o.res.Status != test.status || res.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d (response %d)", test.status, o.res.Status, res.StatusCode)
+			}
+			written := test.written
+			if written < 0 {
+				written = int64(len(content))
+			}
+			if o.res.Written != written {
+				t.Errorf("expected %d bytes written, got %d", written, o.res.Written)
+			}
+			if (o.res.Reason != "") != test.reason || o.res.Reason != res.Header.Get("X-Reason") {
+				t.Errorf("expected the reason of the response %q, got %q", res.Header.Get("X-Reason"), o.res.Reason)
+			}
+			if o.res.Duration <= 0 {
+				t.Errorf("expected a positive duration, got %v", o.res.Duration)
+			}
+			if id := strconv.FormatInt(o.id, 10); id != res.Header.Get("X-Request-ID") {
+				t.Errorf("expected the request %s, got %s", res.Header.Get("X-Request-ID"), id)
+			}
+		})
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	data := []byte("hello cache control")
+	hash := blossom.ComputeHash(data)
+
+	tests := []struct {
+		name     string
+		opts     []Option
+		delivery []DeliveryOption
+		method   string
+		expected string // empty for a missing header
+	}{
+		{"default download", nil, nil, http.MethodGet, DefaultCacheControl},
+		{"default check", nil, nil, http.MethodHead, DefaultCacheControl},
+		{"configured download", []Option{WithCacheControl("public, max-age=60")}, nil, http.MethodGet, "public, max-age=60"},
+		{"configured check", []Option{WithCacheControl("public, max-age=60")}, nil, http.MethodHead, "public, max-age=60"},
+		{"omitted", []Option{WithCacheControl("")}, nil, http.MethodGet, ""},
+		{"overridden download", nil, []DeliveryOption{CacheControl("no-store")}, http.MethodGet, "no-store"},
+		{"overridden check", nil, []DeliveryOption{CacheControl("no-store")}, http.MethodHead, "no-store"},
+		{"overridden to omit", nil, []DeliveryOption{CacheControl("")}, http.MethodGet, ""},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			server, ts := newTestServer(t, test.opts...)
+			server.On.Download = func(r Request, h blossom.Hash, ext string) (BlobDelivery, *blossom.Error) {
+				return Serve(blossom.BlobFromBytes(data), test.delivery...), nil
+			}
+			server.On.Check = func(r Request, h blossom.Hash, ext string) (MetaDelivery, *blossom.Error) {
+				return Found("text/plain", int64(len(data)), test.delivery...), nil
+			}
+
+			r, _ := http.NewRequest(test.method, ts.URL+"/"+hash.Hex(), nil)
+			res := do(t, r)
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+			}
+			if got := res.Header.Get("Cache-Control"); got != test.expected {
+				t.Errorf("expected Cache-Control %q, got %q", test.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+// Package sessions provides a [blossy.SessionStore] that keeps the resumable uploads on the local filesystem.
+//
+// Each session is stored in two files named after its ID: a JSON file with its state, and a file
+// with the data uploaded so far, whose size is the offset of the session.
+//
+// Example:
+//
+//	store, err := sessions.NewDisk("/var/lib/blossy/sessions")
+//	if err != nil {
+//	    panic(err)
+//	}
+//
+//	server, err := blossy.NewServer(
+//	    blossy.WithResumableUploads(store, 24*time.Hour),
+//	)
+package sessions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pippellia-btc/blossy"
+)
+
+// Disk is a [blossy.SessionStore] on the local filesystem. It's safe for concurrent use.
+type Disk struct {
+	dir string
+}
+
+// NewDisk returns a [Disk] store in the directory, creating it if it doesn't exist.
+// The sessions stored by a previous run are kept, so that they can be resumed after a restart.
+func NewDisk(dir string) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("sessions: failed to create directory: %w", err)
+	}
+	return &Disk{dir: dir}, nil
+}
+
+func (d *Disk) statePath(id string) string { return filepath.Join(d.dir, id+".json") }
+func (d *Disk) dataPath(id string) string  { return filepath.Join(d.dir, id+".part") }
+
+// validID guards against IDs that would escape the directory.
+func validID(id string) bool {
+	return id != "" && filepath.Base(id) == id && !strings.HasPrefix(id, ".")
+}
+
+func (d *Disk) Create(ctx context.Context, session blossy.UploadSession) error {
+	if !validID(session.ID) {
+		return fmt.Errorf("sessions: invalid ID %q", session.ID)
+	}
+
+	session.Offset = 0
+	state, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("sessions: failed to encode session: %w", err)
+	}
+
+	data, err := os.OpenFile(d.dataPath(session.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("sessions: failed to create session: %w", err)
+	}
+	data.Close()
+
+	if err := os.WriteFile(d.statePath(session.ID), state, 0o644); err != nil {
+		os.Remove(d.dataPath(session.ID))
+		return fmt.Errorf("sessions: failed to create session: %w", err)
+	}
+	return nil
+}
+
+func (d *Disk) Get(ctx context.Context, id string) (blossy.UploadSession, error) {
+	if !validID(id) {
+		return blossy.UploadSession{}, blossy.ErrSessionNotFound
+	}
+
+	state, err := os.ReadFile(d.statePath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return blossy.UploadSession{}, blossy.ErrSessionNotFound
+	}
+	if err != nil {
+		return blossy.UploadSession{}, fmt.Errorf("sessions: failed to read session: %w", err)
+	}
+
+	var session blossy.UploadSession
+	if err := json.Unmarshal(state, &session); err != nil {
+		return blossy.UploadSession{}, fmt.Errorf("sessions: failed to decode session: %w", err)
+	}
+
+	info, err := os.Stat(d.dataPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return blossy.UploadSession{}, blossy.ErrSessionNotFound
+	}
+	if err != nil {
+		return blossy.UploadSession{}, fmt.Errorf("sessions: failed to read session: %w", err)
+	}
+
+	session.Offset = info.Size()
+	return session, nil
+}
+
+func (d *Disk) Append(ctx context.Context, id string, offset int64, data io.Reader) (int64, error) {
+	if !validID(id) {
+		return 0, blossy.ErrSessionNotFound
+	}
+
+	file, err := os.OpenFile(d.dataPath(id), os.O_WRONLY|os.O_APPEND, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, blossy.ErrSessionNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("sessions: failed to open session: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("sessions: failed to open session: %w", err)
+	}
+	if info.Size() != offset {
+		return info.Size(), blossy.ErrOffsetMismatch
+	}
+
+	n, err := io.Copy(file, data)
+	if err != nil {
+		return offset + n, fmt.Errorf("sessions: failed to append data: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return offset + n, fmt.Errorf("sessions: failed to append data: %w", err)
+	}
+	return offset + n, nil
+}
+
+func (d *Disk) Open(ctx context.Context, id string) (io.ReadCloser, error) {
+	if !validID(id) {
+		return nil, blossy.ErrSessionNotFound
+	}
+
+	file, err := os.Open(d.dataPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, blossy.ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sessions: failed to open session: %w", err)
+	}
+	return file, nil
+}
+
+func (d *Disk) Delete(ctx context.Context, id string) error {
+	if !validID(id) {
+		return blossy.ErrSessionNotFound
+	}
+
+	err := os.Remove(d.statePath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return blossy.ErrSessionNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("sessions: failed to delete session: %w", err)
+	}
+
+	if err := os.Remove(d.dataPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("sessions: failed to delete session: %w", err)
+	}
+	return nil
+}
+
+func (d *Disk) Prune(ctx context.Context, before time.Time) error {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return fmt.Errorf("sessions: failed to read directory: %w", err)
+	}
+
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+
+		session, err := d.Get(ctx, id)
+		if errors.Is(err, blossy.ErrSessionNotFound) {
+			// the data is missing, so the session can't be resumed
+			os.Remove(d.statePath(id))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if session.Expires.Before(before) {
+			if err := d.Delete(ctx, id); err != nil && !errors.Is(err, blossy.ErrSessionNotFound) {
+				return err
+			}
+		}
+	}
+	return nil
+}
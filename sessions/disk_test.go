@@ -0,0 +1,115 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+)
+
+var ctx = context.Background()
+
+func newSession(id string, expires time.Time) blossy.UploadSession {
+	return blossy.UploadSession{
+		ID:      id,
+		Hash:    blossom.ComputeHash([]byte("hello blossom")),
+		Type:    "text/plain",
+		Length:  13,
+		Expires: expires,
+	}
+}
+
+func TestAppend(t *testing.T) {
+	store, err := NewDisk(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	session := newSession("abc", time.Now().Add(time.Hour))
+	if err := store.Create(ctx, session); err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	offset, err := store.Append(ctx, "abc", 0, strings.NewReader("hello "))
+	if err != nil || offset != 6 {
+		t.Fatalf("expected offset 6, got %d with error %v", offset, err)
+	}
+
+	offset, err = store.Append(ctx, "abc", 3, strings.NewReader("blossom"))
+	if !errors.Is(err, blossy.ErrOffsetMismatch) || offset != 6 {
+		t.Fatalf("expected ErrOffsetMismatch with offset 6, got %d with error %v", offset, err)
+	}
+
+	if _, err := store.Append(ctx, "abc", 6, strings.NewReader("blossom")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := store.Get(ctx, "abc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !got.Complete() || got.Hash != session.Hash || got.Type != session.Type {
+		t.Errorf("expected a complete session %v, got %v", session, got)
+	}
+
+	data, err := store.Open(ctx, "abc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer data.Close()
+
+	content, _ := io.ReadAll(data)
+	if string(content) != "hello blossom" {
+		t.Errorf("expected the appended data, got %q", content)
+	}
+
+	if err := store.Delete(ctx, "abc"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := store.Get(ctx, "abc"); !errors.Is(err, blossy.ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound after the deletion, got %v", err)
+	}
+}
+
+func TestInvalidID(t *testing.T) {
+	store, err := NewDisk(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	for _, id := range []string{"", "../abc", ".abc", "a/b"} {
+		if err := store.Create(ctx, newSession(id, time.Now().Add(time.Hour))); err == nil {
+			t.Errorf("expected the ID %q to be rejected", id)
+		}
+		if _, err := store.Get(ctx, id); !errors.Is(err, blossy.ErrSessionNotFound) {
+			t.Errorf("expected ErrSessionNotFound for the ID %q, got %v", id, err)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	store, err := NewDisk(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	now := time.Now()
+	store.Create(ctx, newSession("expired", now.Add(-time.Minute)))
+	store.Create(ctx, newSession("active", now.Add(time.Hour)))
+
+	if err := store.Prune(ctx, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := store.Get(ctx, "expired"); !errors.Is(err, blossy.ErrSessionNotFound) {
+		t.Errorf("expected the expired session to be pruned, got %v", err)
+	}
+	if _, err := store.Get(ctx, "active"); err != nil {
+		t.Errorf("expected the active session to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package blossy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+// ErrBlobNotFound is returned by a [Store] when the requested blob doesn't exist,
+// or it's not owned by the provided pubkey.
+var ErrBlobNotFound = errors.New("blob not found")
+
+// Store is a blob storage backend, which can be bound to the server hooks with [BindStore].
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the blob with the provided hash, or [ErrBlobNotFound].
+	Get(ctx context.Context, hash blossom.Hash) (blossom.Blob, error)
+
+	// Head returns the descriptor of the blob with the provided hash, or [ErrBlobNotFound].
+	// The URL of the returned descriptor can be empty.
+	Head(ctx context.Context, hash blossom.Hash) (blossom.BlobDescriptor, error)
+
+	// Save stores the data on behalf of the pubkey, and returns the descriptor of the resulting blob.
+	// The pubkey can be empty if the upload is not authenticated.
+	// The URL of the returned descriptor can be empty.
+	Save(ctx context.Context, pubkey string, hints UploadHints, data io.Reader) (blossom.BlobDescriptor, error)
+
+	// Delete removes the blob with the provided hash on behalf of the pubkey.
+	// It returns [ErrBlobNotFound] if the blob doesn't exist or it's not owned by the pubkey.
+	Delete(ctx context.Context, pubkey string, hash blossom.Hash) error
+
+	// List returns the descriptors of the blobs owned by the pubkey that match the query.
+	// The URL of the returned descriptors can be empty.
+	List(ctx context.Context, pubkey string, query ListQuery) ([]blossom.BlobDescriptor, error)
+}
+
+// RedirectStore is an optional interface of a [Store] that can redirect clients to another URL
+// to download blobs, for example a presigned URL of an object storage or a CDN.
+type RedirectStore interface {
+	Store
+
+	// RedirectURL returns the URL where the blob with the provided hash can be downloaded, or [ErrBlobNotFound].
+	// If the URL is empty, the blob is served with [Store.Get] instead.
+	RedirectURL(ctx context.Context, hash blossom.Hash) (string, error)
+}
+
+// BindStore binds the store to the server, setting the On hooks for the
+// Download, Check, Upload, Delete and List endpoints.
+// If the store is a [RedirectStore], downloads redirect clients to its URLs with 302 (Found).
+// Deletions require the request to be authenticated, so that the store can check ownership.
+//
+// Errors returned by the store are converted with [WrapError], so for example [ErrBlobNotFound]
+// is mapped to 404 (Not Found), and [context.DeadlineExceeded] to 504 (Gateway Timeout).
+//
+// The Reject hooks are left untouched, and the On hooks can still be overwritten after this call.
+func BindStore(s *Server, store Store) {
+	redirector, canRedirect := store.(RedirectStore)
+
+	s.On.Download = func(r Request, hash blossom.Hash, ext string) (BlobDelivery, *blossom.Error) {
+		if canRedirect {
+			ctx, end := s.traceStore(r.Context(), "RedirectURL", &hash)
+			url, err := redirector.RedirectURL(ctx, hash)
+			end(err)
+			if err != nil {
+				return nil, WrapError(err)
+			}
+			if url != "" {
+				return Redirect(url, http.StatusFound), nil
+			}
+		}
+
+		ctx, end := s.traceStore(r.Context(), "Get", &hash)
+		blob, err := store.Get(ctx, hash)
+		end(err)
+		if err != nil {
+			return nil, WrapError(err)
+		}
+		return Serve(blob), nil
+	}
+
+	s.On.Check = func(r Request, hash blossom.Hash, ext string) (MetaDelivery, *blossom.Error) {
+		ctx, end := s.traceStore(r.Context(), "Head", &hash)
+		desc, err := store.Head(ctx, hash)
+		end(err)
+		if err != nil {
+			return nil, WrapError(err)
+		}
+		return Found(desc.Type, desc.Size), nil
+	}
+
+	s.On.Upload = func(r Request, hints UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+		ctx, end := s.traceStore(r.Context(), "Save", hints.Hash)
+		desc, err := store.Save(ctx, r.Pubkey(), hints, data)
+		end(err)
+		if err != nil {
+			return blossom.BlobDescriptor{}, WrapError(err)
+		}
+		return desc, nil
+	}
+
+	s.On.Delete = func(r Request, hash blossom.Hash) *blossom.Error {
+		if !r.IsAuthed() {
+			return blossom.ErrUnauthorized("authorization is required to delete a blob")
+		}
+		ctx, end := s.traceStore(r.Context(), "Delete", &hash)
+		err := store.Delete(ctx, r.Pubkey(), hash)
+		end(err)
+		if err != nil {
+			return WrapError(err)
+		}
+		return nil
+	}
+
+	s.On.List = func(r Request, pubkey string, query ListQuery) ([]blossom.BlobDescriptor, *blossom.Error) {
+		ctx, end := s.traceStore(r.Context(), "List", nil)
+		descs, err := store.List(ctx, pubkey, query)
+		end(err)
+		if err != nil {
+			return nil, WrapError(err)
+		}
+		return descs, nil
+	}
+}
@@ -0,0 +1,382 @@
+// Package disk provides a [blossy.Store] that keeps blobs on the local filesystem.
+//
+// Blobs are stored in files named after their hash, sharded in directories by the first two
+// characters of the hash. Their metadata (type and owners) is stored in a JSON file alongside,
+// and kept in memory for fast lookups and listings.
+//
+// With [WithJournal], uploads are recorded in a write-ahead journal, so that after a crash the store
+// completes the blobs that were fully written, and cleans up the partially written ones.
+//
+// It's designed for small and medium deployments. For large ones, consider a store backed by a database,
+// such as https://github.com/pippellia-btc/blisk.
+package disk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+// Store is a [blossy.Store] on the local filesystem. Create one with [New].
+type Store struct {
+	dir string
+
+	mu    sync.RWMutex
+	index map[blossom.Hash]*meta
+
+	journaled bool
+	journal   *journal
+	recovery  Recovery
+}
+
+type Option func(*Store)
+
+// WithJournal records the uploads in a write-ahead journal in the directory of the store.
+// When the store is created, the uploads that were interrupted by a crash are reconciled with the index:
+// the ones whose blob was fully written are completed, and the temporary files are removed. See [Store.Recovered].
+func WithJournal() Option {
+	return func(s *Store) {
+		s.journaled = true
+	}
+}
+
+// meta is the metadata of a blob, persisted as JSON next to the blob.
+type meta struct {
+	Type string `json:"type"`
+	Size int64  `json:"size"`
+
+	// Owners maps the pubkeys that uploaded the blob to the unix time of their upload.
+	// Unauthenticated uploads are recorded under the empty pubkey.
+	Owners map[string]int64 `json:"owners"`
+}
+
+// uploaded returns the unix time of the first upload of the blob.
+func (m *meta) uploaded() int64 {
+	first := int64(0)
+	for _, unix := range m.Owners {
+		if first == 0 || unix < first {
+			first = unix
+		}
+	}
+	return first
+}
+
+// New returns a Store that keeps blobs in the provided directory, creating it if it doesn't exist.
+// It loads the metadata of all the existing blobs in memory.
+func New(dir string, opts ...Option) (*Store, error) {
+	for _, sub := range []string{"blobs", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("disk: failed to create directory: %w", err)
+		}
+	}
+
+	s := &Store{
+		dir:   dir,
+		index: make(map[blossom.Hash]*meta),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if err := s.load(); err != nil {
+		return nil, fmt.Errorf("disk: failed to load the index: %w", err)
+	}
+
+	if s.journaled {
+		j, err := openJournal(filepath.Join(dir, "journal"))
+		if err != nil {
+			return nil, fmt.Errorf("disk: failed to open the journal: %w", err)
+		}
+		s.journal = j
+
+		if err := s.recover(); err != nil {
+			j.close()
+			return nil, fmt.Errorf("disk: failed to recover: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Recovered returns the summary of the reconciliation of the uploads interrupted by a crash,
+// which is done by [New] with [WithJournal].
+func (s *Store) Recovered() Recovery {
+	return s.recovery
+}
+
+// Close closes the journal, if any. The store must not be used after Close.
+func (s *Store) Close() error {
+	return s.journal.close()
+}
+
+// load reads the metadata of all the blobs into the index.
+func (s *Store) load() error {
+	return filepath.WalkDir(filepath.Join(s.dir, "blobs"), func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+
+		hash, err := blossom.ParseHash(strings.TrimSuffix(d.Name(), ".json"))
+		if err != nil {
+			return nil // not a metadata file
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		m := &meta{}
+		if err := json.Unmarshal(data, m); err != nil {
+			return fmt.Errorf("invalid metadata for %s: %w", hash, err)
+		}
+		s.index[hash] = m
+		return nil
+	})
+}
+
+// Get returns the blob with the provided hash, or [blossy.ErrBlobNotFound].
+func (s *Store) Get(ctx context.Context, hash blossom.Hash) (blossom.Blob, error) {
+	s.mu.RLock()
+	_, ok := s.index[hash]
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, blossy.ErrBlobNotFound
+	}
+
+	file, err := os.Open(s.blobPath(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, blossy.ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return blossy.BlobFromFile(file)
+}
+
+// Head returns the descriptor of the blob with the provided hash, or [blossy.ErrBlobNotFound].
+func (s *Store) Head(ctx context.Context, hash blossom.Hash) (blossom.BlobDescriptor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.index[hash]
+	if !ok {
+		return blossom.BlobDescriptor{}, blossy.ErrBlobNotFound
+	}
+
+	return blossom.BlobDescriptor{
+		Hash:     hash,
+		Size:     m.Size,
+		Type:     m.Type,
+		Uploaded: m.uploaded(),
+	}, nil
+}
+
+// Save writes the data to a temporary file while hashing it, and then moves it in place.
+// If the hints contain a hash, it returns [utils.ErrHashMismatch] when the data doesn't match it.
+// If the hints don't contain a type, it's detected from the first bytes of the data.
+func (s *Store) Save(ctx context.Context, pubkey string, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, error) {
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, "tmp"), "upload-*")
+	if err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+
+	id, err := s.journal.begin(pubkey, filepath.Base(tmp.Name()))
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return blossom.BlobDescriptor{}, err
+	}
+	defer s.journal.done(id)
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+	defer tmp.Close()
+
+	reader := utils.NewHashReader(data, hints.Hash)
+	sniffer := &sniffer{}
+
+	size, err := io.Copy(io.MultiWriter(tmp, sniffer), contextReader{ctx: ctx, r: reader})
+	if err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+
+	hash, _ := reader.Sum()
+	mime := hints.Type
+	if mime == "" {
+		mime = http.DetectContentType(sniffer.buf)
+	}
+	if err := s.journal.written(id, hash, size, mime); err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, exists := s.index[hash]
+	if !exists {
+		if err := os.MkdirAll(filepath.Dir(s.blobPath(hash)), 0o755); err != nil {
+			return blossom.BlobDescriptor{}, err
+		}
+		if err := os.Rename(tmp.Name(), s.blobPath(hash)); err != nil {
+			return blossom.BlobDescriptor{}, err
+		}
+		m = &meta{Type: mime, Size: size, Owners: make(map[string]int64)}
+	}
+
+	updated := &meta{Type: m.Type, Size: m.Size, Owners: cloneOwners(m.Owners)}
+	if _, owned := updated.Owners[pubkey]; !owned {
+		updated.Owners[pubkey] = time.Now().Unix()
+	}
+
+	if err := s.writeMeta(hash, updated); err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+	s.index[hash] = updated
+
+	return blossom.BlobDescriptor{
+		Hash:     hash,
+		Size:     updated.Size,
+		Type:     updated.Type,
+		Uploaded: updated.Owners[pubkey],
+	}, nil
+}
+
+// Delete removes the ownership of the blob by the pubkey, deleting the blob when it has no more owners.
+// It returns [blossy.ErrBlobNotFound] if the blob doesn't exist or it's not owned by the pubkey.
+func (s *Store) Delete(ctx context.Context, pubkey string, hash blossom.Hash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.index[hash]
+	if !ok {
+		return blossy.ErrBlobNotFound
+	}
+	if _, owned := m.Owners[pubkey]; !owned {
+		return blossy.ErrBlobNotFound
+	}
+
+	updated := &meta{Type: m.Type, Size: m.Size, Owners: cloneOwners(m.Owners)}
+	delete(updated.Owners, pubkey)
+
+	if len(updated.Owners) > 0 {
+		if err := s.writeMeta(hash, updated); err != nil {
+			return err
+		}
+		s.index[hash] = updated
+		return nil
+	}
+
+	if err := os.Remove(s.metaPath(hash)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(s.blobPath(hash)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	delete(s.index, hash)
+	return nil
+}
+
+// List returns the page of the descriptors of the blobs owned by the pubkey selected by the query,
+// sorted by upload time, newest first unless the query specifies otherwise.
+func (s *Store) List(ctx context.Context, pubkey string, query blossy.ListQuery) ([]blossom.BlobDescriptor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var descs []blossom.BlobDescriptor
+	for hash, m := range s.index {
+		if unix, owned := m.Owners[pubkey]; owned {
+			descs = append(descs, blossom.BlobDescriptor{
+				Hash:     hash,
+				Size:     m.Size,
+				Type:     m.Type,
+				Uploaded: unix,
+			})
+		}
+	}
+	return query.Apply(descs), nil
+}
+
+func (s *Store) blobPath(hash blossom.Hash) string {
+	hex := hash.Hex()
+	return filepath.Join(s.dir, "blobs", hex[:2], hex)
+}
+
+func (s *Store) metaPath(hash blossom.Hash) string {
+	return s.blobPath(hash) + ".json"
+}
+
+// writeMeta atomically writes the metadata of the blob.
+func (s *Store) writeMeta(hash blossom.Hash, m *meta) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, "tmp"), "meta-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.metaPath(hash))
+}
+
+func cloneOwners(owners map[string]int64) map[string]int64 {
+	clone := make(map[string]int64, len(owners))
+	for pk, unix := range owners {
+		clone[pk] = unix
+	}
+	return clone
+}
+
+// sniffer keeps the first 512 bytes written to it, which is what [http.DetectContentType] needs.
+type sniffer struct {
+	buf []byte
+}
+
+func (s *sniffer) Write(p []byte) (int, error) {
+	if missing := 512 - len(s.buf); missing > 0 {
+		s.buf = append(s.buf, p[:min(missing, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// contextReader stops reading when the context is cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,223 @@
+package disk
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+var (
+	ctx   = context.Background()
+	alice = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	bob   = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+)
+
+func hashOf(data string) blossom.Hash {
+	sum := sha256.Sum256([]byte(data))
+	hash, _ := blossom.ParseHash(hex.EncodeToString(sum[:]))
+	return hash
+}
+
+func TestSave(t *testing.T) {
+	store, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	data := "hello blossom"
+	desc, err := store.Save(ctx, alice, blossy.UploadHints{Size: -1}, strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if desc.Hash != hashOf(data) {
+		t.Errorf("expected hash %s, got %s", hashOf(data), desc.Hash)
+	}
+	if desc.Size != int64(len(data)) {
+		t.Errorf("expected size %d, got %d", len(data), desc.Size)
+	}
+	if !strings.HasPrefix(desc.Type, "text/plain") {
+		t.Errorf("expected detected type text/plain, got %s", desc.Type)
+	}
+
+	head, err := store.Head(ctx, desc.Hash)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if head.Size != desc.Size || head.Type != desc.Type {
+		t.Errorf("expected head %v, got %v", desc, head)
+	}
+}
+
+func TestSaveHashMismatch(t *testing.T) {
+	store, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	wrong := hashOf("something else")
+	hints := blossy.UploadHints{Hash: &wrong, Size: -1}
+
+	_, err = store.Save(ctx, alice, hints, strings.NewReader("hello blossom"))
+	if !errors.Is(err, utils.ErrHashMismatch) {
+		t.Fatalf("expected ErrHashMismatch, got %v", err)
+	}
+
+	if _, err := store.Head(ctx, wrong); !errors.Is(err, blossy.ErrBlobNotFound) {
+		t.Fatalf("expected ErrBlobNotFound, got %v", err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	store, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	data := "shared blob"
+	hash := hashOf(data)
+	hints := blossy.UploadHints{Type: "text/plain", Size: -1}
+
+	if _, err := store.Save(ctx, alice, hints, strings.NewReader(data)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := store.Save(ctx, bob, hints, strings.NewReader(data)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := store.Delete(ctx, alice, hash); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.Delete(ctx, alice, hash); !errors.Is(err, blossy.ErrBlobNotFound) {
+		t.Fatalf("expected ErrBlobNotFound on second delete, got %v", err)
+	}
+
+	// bob still owns the blob
+	if _, err := store.Head(ctx, hash); err != nil {
+		t.Fatalf("expected blob to still exist, got %v", err)
+	}
+
+	if err := store.Delete(ctx, bob, hash); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := store.Head(ctx, hash); !errors.Is(err, blossy.ErrBlobNotFound) {
+		t.Fatalf("expected ErrBlobNotFound, got %v", err)
+	}
+}
+
+func TestListAndReload(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(dir)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	hints := blossy.UploadHints{Type: "text/plain", Size: -1}
+	for _, data := range []string{"one", "two", "three"} {
+		if _, err := store.Save(ctx, alice, hints, strings.NewReader(data)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := store.Save(ctx, bob, hints, strings.NewReader("four")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// reopening the store must load the same index
+	store, err = New(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+
+	descs, err := store.List(ctx, alice, blossy.ListQuery{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(descs) != 3 {
+		t.Fatalf("expected 3 blobs for alice, got %d", len(descs))
+	}
+
+	descs, err = store.List(ctx, bob, blossy.ListQuery{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(descs) != 1 || descs[0].Hash != hashOf("four") {
+		t.Fatalf("expected the blob \"four\" for bob, got %v", descs)
+	}
+}
+
+func TestJournalRecovery(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(dir, WithJournal())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	if _, err := store.Save(ctx, alice, blossy.UploadHints{Size: -1}, strings.NewReader("saved")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// an upload interrupted after its data was written and synced, but before it was moved in place
+	written := "written before the crash"
+	if err := os.WriteFile(filepath.Join(dir, "tmp", "upload-1"), []byte(written), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	id, _ := store.journal.begin(bob, "upload-1")
+	store.journal.written(id, hashOf(written), int64(len(written)), "text/plain")
+
+	// an upload interrupted while its data was being written
+	if err := os.WriteFile(filepath.Join(dir, "tmp", "upload-2"), []byte("partial"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store.journal.begin(alice, "upload-2")
+
+	// a record truncated by the crash
+	store.journal.file.WriteString(`{"id":3,"op":"beg`)
+	store.Close()
+
+	store, err = New(dir, WithJournal())
+	if err != nil {
+		t.Fatalf("failed to recover the store: %v", err)
+	}
+	defer store.Close()
+
+	expected := Recovery{Completed: 1, Discarded: 1, RemovedFiles: 1}
+	if r := store.Recovered(); r != expected {
+		t.Errorf("expected recovery %+v, got %+v", expected, r)
+	}
+
+	descs, err := store.List(ctx, bob, blossy.ListQuery{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(descs) != 1 || descs[0].Hash != hashOf(written) || descs[0].Type != "text/plain" {
+		t.Errorf("expected the completed upload to be owned by bob, got %v", descs)
+	}
+	if _, err := store.Get(ctx, hashOf("saved")); err != nil {
+		t.Errorf("expected the saved blob to survive the recovery, got %v", err)
+	}
+
+	tmp, err := os.ReadDir(filepath.Join(dir, "tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tmp) != 0 {
+		t.Errorf("expected the temporary files to be removed, got %d", len(tmp))
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("expected the journal to be truncated after the recovery, got %d bytes", info.Size())
+	}
+}
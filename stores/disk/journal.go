@@ -0,0 +1,321 @@
+package disk
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+// compactSize is the size of the journal after which it's truncated, as soon as no upload is in progress.
+const compactSize = 1 << 20
+
+// journal is a write-ahead log of the uploads, which records when they start, when their blob
+// is about to be moved in place and when they complete. Every record is synced to disk before
+// the corresponding step, so that after a crash the store can reconcile the uploads that didn't complete.
+// All methods are no-ops on a nil *journal.
+type journal struct {
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	nextID  uint64
+	pending map[uint64]struct{}
+}
+
+// entry is a record of the journal, persisted as a line of JSON.
+type entry struct {
+	ID uint64 `json:"id"`
+	Op string `json:"op"` // "begin", "written" or "done"
+
+	// begin
+	Pubkey string `json:"pubkey,omitempty"`
+	Tmp    string `json:"tmp,omitempty"`
+
+	// written
+	Hash *blossom.Hash `json:"hash,omitempty"`
+	Size int64         `json:"size,omitempty"`
+	Type string        `json:"type,omitempty"`
+}
+
+// openJournal opens the journal file, creating it if it doesn't exist.
+func openJournal(path string) (*journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &journal{
+		file:    file,
+		size:    info.Size(),
+		nextID:  1,
+		pending: make(map[uint64]struct{}),
+	}, nil
+}
+
+// begin records the start of an upload by the pubkey into the temporary file, returning its id.
+func (j *journal) begin(pubkey, tmp string) (uint64, error) {
+	if j == nil {
+		return 0, nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	id := j.nextID
+	if err := j.append(entry{ID: id, Op: "begin", Pubkey: pubkey, Tmp: tmp}); err != nil {
+		return 0, err
+	}
+
+	j.nextID++
+	j.pending[id] = struct{}{}
+	return id, nil
+}
+
+// written records that the upload has been fully written and synced, and that its blob is about to be moved in place.
+func (j *journal) written(id uint64, hash blossom.Hash, size int64, mime string) error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.append(entry{ID: id, Op: "written", Hash: &hash, Size: size, Type: mime})
+}
+
+// done records the end of the upload, whether it succeeded or not.
+// The journal is truncated when it's too big and no upload is in progress.
+func (j *journal) done(id uint64) error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	delete(j.pending, id)
+	if len(j.pending) == 0 && j.size >= compactSize {
+		return j.truncate()
+	}
+	return j.append(entry{ID: id, Op: "done"})
+}
+
+func (j *journal) append(e entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	n, err := j.file.Write(append(data, '\n'))
+	j.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("disk: failed to write the journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("disk: failed to sync the journal: %w", err)
+	}
+	return nil
+}
+
+func (j *journal) truncate() error {
+	if err := j.file.Truncate(0); err != nil {
+		return fmt.Errorf("disk: failed to truncate the journal: %w", err)
+	}
+	j.size = 0
+	return j.file.Sync()
+}
+
+// incomplete returns the uploads of the journal that have not been recorded as done, in order.
+// A truncated last line, left by a crash, is ignored.
+func (j *journal) incomplete() ([]upload, error) {
+	if _, err := j.file.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	var uploads []upload
+	byID := make(map[uint64]int)
+
+	scanner := bufio.NewScanner(j.file)
+	for scanner.Scan() {
+		var e entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+
+		switch e.Op {
+		case "begin":
+			byID[e.ID] = len(uploads)
+			uploads = append(uploads, upload{id: e.ID, pubkey: e.Pubkey, tmp: e.Tmp})
+
+		case "written":
+			if i, ok := byID[e.ID]; ok && e.Hash != nil {
+				uploads[i].hash = e.Hash
+				uploads[i].size = e.Size
+				uploads[i].mime = e.Type
+			}
+
+		case "done":
+			if i, ok := byID[e.ID]; ok {
+				uploads[i].done = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var pending []upload
+	for _, u := range uploads {
+		if !u.done {
+			pending = append(pending, u)
+		}
+	}
+	return pending, nil
+}
+
+func (j *journal) close() error {
+	if j == nil {
+		return nil
+	}
+	return j.file.Close()
+}
+
+// upload is an upload reconstructed from the journal.
+type upload struct {
+	id     uint64
+	pubkey string
+	tmp    string
+	done   bool
+
+	// set if the upload was fully written
+	hash *blossom.Hash
+	size int64
+	mime string
+}
+
+// Recovery summarizes the reconciliation of the uploads interrupted by a crash, done by [New] with [WithJournal].
+type Recovery struct {
+	// Completed is the number of uploads whose blob was fully written, and that have been completed.
+	Completed int
+
+	// Discarded is the number of uploads that were interrupted before their blob was fully written.
+	Discarded int
+
+	// RemovedFiles is the number of temporary files removed.
+	RemovedFiles int
+}
+
+// recover reconciles the uploads interrupted by a crash with the index, and cleans up the temporary files.
+// Uploads whose blob was fully written are completed, recording the pubkey as an owner, while the others are discarded.
+// It must be called after the index has been loaded, and before any upload.
+func (s *Store) recover() error {
+	uploads, err := s.journal.incomplete()
+	if err != nil {
+		return fmt.Errorf("failed to read the journal: %w", err)
+	}
+
+	for _, u := range uploads {
+		completed, err := s.reconcile(u)
+		if err != nil {
+			return fmt.Errorf("failed to reconcile upload %d: %w", u.id, err)
+		}
+		if completed {
+			s.recovery.Completed++
+		} else {
+			s.recovery.Discarded++
+		}
+	}
+
+	tmp, err := os.ReadDir(filepath.Join(s.dir, "tmp"))
+	if err != nil {
+		return err
+	}
+	for _, file := range tmp {
+		if err := os.RemoveAll(filepath.Join(s.dir, "tmp", file.Name())); err != nil {
+			return err
+		}
+		s.recovery.RemovedFiles++
+	}
+
+	return s.journal.truncate()
+}
+
+// reconcile completes the upload if its blob was fully written, reporting whether it did.
+func (s *Store) reconcile(u upload) (bool, error) {
+	if u.hash == nil {
+		return false, nil
+	}
+	hash := *u.hash
+
+	info, err := os.Stat(s.blobPath(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		// the crash happened before the blob was moved in place, so the temporary file still has the data
+		if err := s.moveTmp(u.tmp, hash); err != nil {
+			return false, nil
+		}
+		info, err = os.Stat(s.blobPath(hash))
+	}
+	if err != nil {
+		return false, err
+	}
+	if info.Size() != u.size {
+		return false, nil
+	}
+
+	m, exists := s.index[hash]
+	if !exists {
+		m = &meta{Type: u.mime, Size: u.size, Owners: make(map[string]int64)}
+	}
+	if _, owned := m.Owners[u.pubkey]; owned {
+		return true, nil
+	}
+
+	updated := &meta{Type: m.Type, Size: m.Size, Owners: cloneOwners(m.Owners)}
+	updated.Owners[u.pubkey] = time.Now().Unix()
+	if err := s.writeMeta(hash, updated); err != nil {
+		return false, err
+	}
+	s.index[hash] = updated
+	return true, nil
+}
+
+// moveTmp moves the temporary file in place of the blob with the hash, after checking its content.
+func (s *Store) moveTmp(tmp string, hash blossom.Hash) error {
+	if tmp == "" || filepath.Base(tmp) != tmp {
+		return errors.New("invalid temporary file")
+	}
+	path := filepath.Join(s.dir, "tmp", tmp)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), hash[:]) {
+		return errors.New("temporary file doesn't match the hash")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.blobPath(hash)), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(path, s.blobPath(hash))
+}
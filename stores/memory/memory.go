@@ -0,0 +1,156 @@
+// Package memory provides a [blossy.Store] that keeps blobs in memory.
+//
+// Blobs are lost when the process exits, so it's meant for tests, demos and small ephemeral caches.
+// For persistent storage, use the blossy/stores/disk package or a store backed by a database.
+package memory
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+// Store is a [blossy.Store] in memory. Create one with [New].
+type Store struct {
+	mu    sync.RWMutex
+	blobs map[blossom.Hash]*entry
+}
+
+type entry struct {
+	data []byte
+	mime string
+
+	// owners maps the pubkeys that uploaded the blob to the unix time of their upload.
+	// Unauthenticated uploads are recorded under the empty pubkey.
+	owners map[string]int64
+}
+
+// New returns an empty in-memory Store.
+func New() *Store {
+	return &Store{blobs: make(map[blossom.Hash]*entry)}
+}
+
+// Len returns the number of blobs in the store.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.blobs)
+}
+
+// Get returns the blob with the provided hash, or [blossy.ErrBlobNotFound].
+func (s *Store) Get(ctx context.Context, hash blossom.Hash) (blossom.Blob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, ok := s.blobs[hash]
+	if !ok {
+		return nil, blossy.ErrBlobNotFound
+	}
+	reader := io.NopCloser(bytes.NewReader(e.data))
+	return blossom.BlobFromStream(reader, int64(len(e.data)), e.mime), nil
+}
+
+// Head returns the descriptor of the blob with the provided hash, or [blossy.ErrBlobNotFound].
+func (s *Store) Head(ctx context.Context, hash blossom.Hash) (blossom.BlobDescriptor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, ok := s.blobs[hash]
+	if !ok {
+		return blossom.BlobDescriptor{}, blossy.ErrBlobNotFound
+	}
+	return e.descriptor(hash, e.first()), nil
+}
+
+// Save reads the data in memory while hashing it.
+// If the hints contain a hash, it returns [utils.ErrHashMismatch] when the data doesn't match it.
+// If the hints don't contain a type, it's detected from the first bytes of the data.
+func (s *Store) Save(ctx context.Context, pubkey string, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, error) {
+	reader := utils.NewHashReader(data, hints.Hash)
+	buf, err := io.ReadAll(reader)
+	if err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+
+	hash, _ := reader.Sum()
+	mime := hints.Type
+	if mime == "" {
+		mime = http.DetectContentType(buf)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, exists := s.blobs[hash]
+	if !exists {
+		e = &entry{data: buf, mime: mime, owners: make(map[string]int64)}
+		s.blobs[hash] = e
+	}
+	if _, owned := e.owners[pubkey]; !owned {
+		e.owners[pubkey] = time.Now().Unix()
+	}
+	return e.descriptor(hash, e.owners[pubkey]), nil
+}
+
+// Delete removes the ownership of the blob by the pubkey, deleting the blob when it has no more owners.
+// It returns [blossy.ErrBlobNotFound] if the blob doesn't exist or it's not owned by the pubkey.
+func (s *Store) Delete(ctx context.Context, pubkey string, hash blossom.Hash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.blobs[hash]
+	if !ok {
+		return blossy.ErrBlobNotFound
+	}
+	if _, owned := e.owners[pubkey]; !owned {
+		return blossy.ErrBlobNotFound
+	}
+
+	delete(e.owners, pubkey)
+	if len(e.owners) == 0 {
+		delete(s.blobs, hash)
+	}
+	return nil
+}
+
+// List returns the page of the descriptors of the blobs owned by the pubkey selected by the query,
+// sorted by upload time, newest first unless the query specifies otherwise.
+func (s *Store) List(ctx context.Context, pubkey string, query blossy.ListQuery) ([]blossom.BlobDescriptor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var descs []blossom.BlobDescriptor
+	for hash, e := range s.blobs {
+		if unix, owned := e.owners[pubkey]; owned {
+			descs = append(descs, e.descriptor(hash, unix))
+		}
+	}
+	return query.Apply(descs), nil
+}
+
+func (e *entry) descriptor(hash blossom.Hash, uploaded int64) blossom.BlobDescriptor {
+	return blossom.BlobDescriptor{
+		Hash:     hash,
+		Size:     int64(len(e.data)),
+		Type:     e.mime,
+		Uploaded: uploaded,
+	}
+}
+
+// first returns the unix time of the first upload of the blob.
+func (e *entry) first() int64 {
+	first := int64(0)
+	for _, unix := range e.owners {
+		if first == 0 || unix < first {
+			first = unix
+		}
+	}
+	return first
+}
@@ -0,0 +1,87 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+var (
+	ctx   = context.Background()
+	alice = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	bob   = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+)
+
+func TestSaveGet(t *testing.T) {
+	store := New()
+	data := "hello blossom"
+
+	desc, err := store.Save(ctx, alice, blossy.UploadHints{Size: -1}, strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if desc.Hash != blossom.ComputeHash([]byte(data)) || desc.Size != int64(len(data)) {
+		t.Fatalf("unexpected descriptor: %+v", desc)
+	}
+	if !strings.HasPrefix(desc.Type, "text/plain") {
+		t.Errorf("expected detected type text/plain, got %s", desc.Type)
+	}
+
+	blob, err := store.Get(ctx, desc.Hash)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, _ := io.ReadAll(blob)
+	if string(got) != data || blob.Type() != desc.Type {
+		t.Errorf("expected %q of type %s, got %q of type %s", data, desc.Type, got, blob.Type())
+	}
+}
+
+func TestSaveHashMismatch(t *testing.T) {
+	store := New()
+	wrong := blossom.ComputeHash([]byte("something else"))
+
+	_, err := store.Save(ctx, alice, blossy.UploadHints{Hash: &wrong, Size: -1}, strings.NewReader("hello"))
+	if !errors.Is(err, utils.ErrHashMismatch) {
+		t.Fatalf("expected ErrHashMismatch, got %v", err)
+	}
+	if store.Len() != 0 {
+		t.Errorf("expected the blob not to be stored")
+	}
+}
+
+func TestDeleteOwnership(t *testing.T) {
+	store := New()
+	hints := blossy.UploadHints{Size: -1}
+
+	desc, _ := store.Save(ctx, alice, hints, strings.NewReader("shared"))
+	store.Save(ctx, bob, hints, strings.NewReader("shared"))
+
+	if err := store.Delete(ctx, "", desc.Hash); !errors.Is(err, blossy.ErrBlobNotFound) {
+		t.Fatalf("expected ErrBlobNotFound for a non owner, got %v", err)
+	}
+	if err := store.Delete(ctx, alice, desc.Hash); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := store.Head(ctx, desc.Hash); err != nil {
+		t.Fatalf("expected the blob to be kept for bob, got %v", err)
+	}
+
+	descs, _ := store.List(ctx, alice, blossy.ListQuery{})
+	if len(descs) != 0 {
+		t.Errorf("expected no blobs for alice, got %d", len(descs))
+	}
+
+	if err := store.Delete(ctx, bob, desc.Hash); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := store.Get(ctx, desc.Hash); !errors.Is(err, blossy.ErrBlobNotFound) {
+		t.Errorf("expected the blob to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,279 @@
+// Package mirror provides a [blossy.Store] decorator that lazily populates a local store
+// with the blobs of upstream blossom servers, turning any blossy deployment into a read-through mirror.
+//
+// When a blob is not in the local store, it's downloaded from the first upstream that has it,
+// its hash is verified while it's written to the local store, and then it's served from there.
+// Uploads, deletions and listings only involve the local store.
+//
+// Example:
+//
+//	local, err := disk.New(".blossom")
+//	if err != nil {
+//	    panic(err)
+//	}
+//
+//	store, err := mirror.New(local, []string{"https://blossom.primal.net"}, mirror.WithMaxSize(100<<20))
+//	if err != nil {
+//	    panic(err)
+//	}
+//	blossy.BindStore(server, store)
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/auth"
+	"github.com/pippellia-btc/blossy/client"
+)
+
+// ErrTooLarge is returned when the blob of an upstream is bigger than the maximum size. See [WithMaxSize].
+var ErrTooLarge = errors.New("mirror: the blob is too large to be mirrored")
+
+// Store is a [blossy.Store] that fetches the blobs missing from the local store from the upstreams.
+// Create one with [New]. It's safe for concurrent use if the local store is.
+type Store struct {
+	blossy.Store // the local store
+
+	upstreams []*client.Client
+	http      *http.Client
+	signer    auth.Signer
+	owner     string
+	maxSize   int64
+
+	mu      sync.Mutex
+	fetches map[blossom.Hash]*fetch
+}
+
+// fetch is a download from the upstreams in progress, which concurrent misses of the same blob wait for.
+type fetch struct {
+	done chan struct{}
+	err  error
+}
+
+type Option func(*Store)
+
+// WithHTTPClient sets the http client used to reach the upstreams.
+func WithHTTPClient(c *http.Client) Option {
+	return func(s *Store) {
+		s.http = c
+	}
+}
+
+// WithSigner sets the signer of the authorization events (kind 24242) sent to the upstreams that
+// require authorization to download blobs, such as an [auth.KeySigner] holding a key of the operator.
+// The events are only sent to the upstreams that respond with 401 (Unauthorized).
+func WithSigner(signer auth.Signer) Option {
+	return func(s *Store) {
+		s.signer = signer
+	}
+}
+
+// WithOwner sets the pubkey that owns the mirrored blobs in the local store.
+// By default, it's empty, as if the blobs were uploaded without authentication.
+func WithOwner(pubkey string) Option {
+	return func(s *Store) {
+		s.owner = pubkey
+	}
+}
+
+// WithMaxSize sets the maximum size in bytes of the blobs that are mirrored. Bigger blobs are not
+// downloaded, and [ErrTooLarge] is returned. By default, there is no limit.
+func WithMaxSize(bytes int64) Option {
+	return func(s *Store) {
+		s.maxSize = bytes
+	}
+}
+
+// New returns a Store that keeps the blobs in the local store, and fetches the missing ones
+// from the upstream blossom servers, in order of preference.
+func New(local blossy.Store, upstreams []string, opts ...Option) (*Store, error) {
+	if local == nil {
+		return nil, errors.New("mirror: local store must not be nil")
+	}
+	if len(upstreams) == 0 {
+		return nil, errors.New("mirror: at least one upstream is required")
+	}
+
+	s := &Store{
+		Store:   local,
+		fetches: make(map[blossom.Hash]*fetch),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.maxSize < 0 {
+		return nil, errors.New("mirror: max size must not be negative")
+	}
+
+	var clientOpts []client.Option
+	if s.http != nil {
+		clientOpts = append(clientOpts, client.WithHTTPClient(s.http))
+	}
+	if s.signer != nil {
+		clientOpts = append(clientOpts, client.WithSigner(s.signer))
+	}
+
+	for _, upstream := range upstreams {
+		c, err := client.New(upstream, clientOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("mirror: %w", err)
+		}
+		s.upstreams = append(s.upstreams, c)
+	}
+	return s, nil
+}
+
+// Get returns the blob from the local store, fetching it from the upstreams if it's missing.
+// It returns [blossy.ErrBlobNotFound] if no upstream has the blob.
+func (s *Store) Get(ctx context.Context, hash blossom.Hash) (blossom.Blob, error) {
+	blob, err := s.Store.Get(ctx, hash)
+	if !errors.Is(err, blossy.ErrBlobNotFound) {
+		return blob, err
+	}
+
+	if err := s.fetchOnce(ctx, hash); err != nil {
+		return nil, err
+	}
+	return s.Store.Get(ctx, hash)
+}
+
+// Head returns the descriptor of the blob from the local store or, if it's missing, from the first upstream
+// that has it, without fetching it. It returns [blossy.ErrBlobNotFound] if no upstream has the blob.
+func (s *Store) Head(ctx context.Context, hash blossom.Hash) (blossom.BlobDescriptor, error) {
+	desc, err := s.Store.Head(ctx, hash)
+	if !errors.Is(err, blossy.ErrBlobNotFound) {
+		return desc, err
+	}
+
+	var errs []error
+	for _, upstream := range s.upstreams {
+		desc, err := upstream.Head(ctx, hash)
+		if err == nil {
+			desc.URL = ""
+			return desc, nil
+		}
+		if !isNotFound(err) {
+			errs = append(errs, fmt.Errorf("mirror: upstream %s: %w", upstream.Server(), err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return blossom.BlobDescriptor{}, errors.Join(errs...)
+	}
+	return blossom.BlobDescriptor{}, blossy.ErrBlobNotFound
+}
+
+// fetchOnce fetches the blob from the upstreams, sharing the download with the concurrent calls for the same blob.
+func (s *Store) fetchOnce(ctx context.Context, hash blossom.Hash) error {
+	s.mu.Lock()
+	f, ok := s.fetches[hash]
+	if ok {
+		s.mu.Unlock()
+		select {
+		case <-f.done:
+			if isCanceled(f.err) && ctx.Err() == nil {
+				// the request that was fetching the blob went away
+				return s.fetchOnce(ctx, hash)
+			}
+			return f.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	f = &fetch{done: make(chan struct{})}
+	s.fetches[hash] = f
+	s.mu.Unlock()
+
+	f.err = s.fetch(ctx, hash)
+
+	s.mu.Lock()
+	delete(s.fetches, hash)
+	s.mu.Unlock()
+	close(f.done)
+	return f.err
+}
+
+// fetch downloads the blob from the first upstream that has it, saving it in the local store.
+// The hash is verified while the blob is saved, so corrupted or malicious upstreams can't poison the local store.
+func (s *Store) fetch(ctx context.Context, hash blossom.Hash) error {
+	var errs []error
+	for _, upstream := range s.upstreams {
+		err := s.fetchFrom(ctx, upstream, hash)
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, ErrTooLarge) || ctx.Err() != nil {
+			return err
+		}
+		if !isNotFound(err) {
+			errs = append(errs, fmt.Errorf("mirror: upstream %s: %w", upstream.Server(), err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return blossy.ErrBlobNotFound
+}
+
+func (s *Store) fetchFrom(ctx context.Context, upstream *client.Client, hash blossom.Hash) error {
+	blob, err := upstream.Get(ctx, hash)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	if s.maxSize > 0 && blob.Size() > s.maxSize {
+		return ErrTooLarge
+	}
+
+	var data io.Reader = blob
+	if s.maxSize > 0 {
+		// the size reported by the upstream can be unknown or wrong
+		data = &limitedReader{r: blob, left: s.maxSize}
+	}
+
+	hints := blossy.UploadHints{Hash: &hash, Type: blob.Type(), Size: blob.Size()}
+	_, err = s.Store.Save(ctx, s.owner, hints, data)
+	return err
+}
+
+// limitedReader returns [ErrTooLarge] when more than the limit is read.
+type limitedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.left < 0 {
+		return 0, ErrTooLarge
+	}
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
+
+// isNotFound reports whether the error is a 404 (Not Found) response of an upstream.
+func isNotFound(err error) bool {
+	var berr *blossom.Error
+	return errors.As(err, &berr) && berr.Code == http.StatusNotFound
+}
+
+func isCanceled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
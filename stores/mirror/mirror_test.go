@@ -0,0 +1,145 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/blossytest"
+	"github.com/pippellia-btc/blossy/stores/memory"
+)
+
+var ctx = context.Background()
+
+func TestGet(t *testing.T) {
+	empty := blossytest.NewTestServer(t)
+	upstream := blossytest.NewTestServer(t)
+
+	data := "mirror me"
+	desc, err := upstream.Store.Save(ctx, "", blossy.UploadHints{Size: -1}, strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to save the blob upstream: %v", err)
+	}
+
+	local := memory.New()
+	store, err := New(local, []string{empty.URL, upstream.URL}, WithOwner("owner"))
+	if err != nil {
+		t.Fatalf("failed to create the store: %v", err)
+	}
+
+	head, err := store.Head(ctx, desc.Hash)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if head.Size != int64(len(data)) || local.Len() != 0 {
+		t.Errorf("expected the descriptor of the upstream without fetching the blob, got %v", head)
+	}
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Go(func() {
+			blob, err := store.Get(ctx, desc.Hash)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			blob.Close()
+		})
+	}
+	wg.Wait()
+
+	if local.Len() != 1 {
+		t.Fatalf("expected the blob to be persisted locally, got %d blobs", local.Len())
+	}
+	list, err := local.List(ctx, "owner", blossy.ListQuery{})
+	if err != nil || len(list) != 1 {
+		t.Errorf("expected the mirrored blob to be owned by the configured pubkey, got %v %v", list, err)
+	}
+
+	// the blob is now served locally
+	upstream.Store.Delete(ctx, "", desc.Hash)
+	if _, err := store.Get(ctx, desc.Hash); err != nil {
+		t.Errorf("expected the local copy to be served, got %v", err)
+	}
+
+	missing := blossom.ComputeHash([]byte("missing"))
+	if _, err := store.Get(ctx, missing); !errors.Is(err, blossy.ErrBlobNotFound) {
+		t.Errorf("expected ErrBlobNotFound, got %v", err)
+	}
+	if _, err := store.Head(ctx, missing); !errors.Is(err, blossy.ErrBlobNotFound) {
+		t.Errorf("expected ErrBlobNotFound, got %v", err)
+	}
+}
+
+func TestGetVerifiesHash(t *testing.T) {
+	upstream := blossytest.NewTestServer(t)
+	forged := blossom.ComputeHash([]byte("expected"))
+	upstream.Blossy.On.Download = func(r blossy.Request, hash blossom.Hash, ext string) (blossy.BlobDelivery, *blossom.Error) {
+		blob := blossom.BlobFromStream(io.NopCloser(strings.NewReader("something else")), 14, "text/plain")
+		return blossy.Serve(blob), nil
+	}
+
+	local := memory.New()
+	store, err := New(local, []string{upstream.URL})
+	if err != nil {
+		t.Fatalf("failed to create the store: %v", err)
+	}
+
+	if _, err := store.Get(ctx, forged); err == nil {
+		t.Error("expected a blob that doesn't match its hash to be refused")
+	}
+	if local.Len() != 0 {
+		t.Errorf("expected the forged blob not to be persisted, got %d blobs", local.Len())
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	upstream := blossytest.NewTestServer(t)
+	desc, err := upstream.Store.Save(ctx, "", blossy.UploadHints{Size: -1}, strings.NewReader("too large to mirror"))
+	if err != nil {
+		t.Fatalf("failed to save the blob upstream: %v", err)
+	}
+
+	store, err := New(memory.New(), []string{upstream.URL}, WithMaxSize(5))
+	if err != nil {
+		t.Fatalf("failed to create the store: %v", err)
+	}
+	if _, err := store.Get(ctx, desc.Hash); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestSigner(t *testing.T) {
+	upstream := blossytest.NewTestServer(t, blossy.WithRequiredAuth(blossy.EndpointDownload, blossy.EndpointCheck))
+	desc, err := upstream.Store.Save(ctx, "", blossy.UploadHints{Size: -1}, strings.NewReader("private blob"))
+	if err != nil {
+		t.Fatalf("failed to save the blob upstream: %v", err)
+	}
+
+	unsigned, err := New(memory.New(), []string{upstream.URL})
+	if err != nil {
+		t.Fatalf("failed to create the store: %v", err)
+	}
+	if _, err := unsigned.Get(ctx, desc.Hash); err == nil {
+		t.Error("expected the upstream to refuse the unauthorized download")
+	}
+
+	signed, err := New(memory.New(), []string{upstream.URL}, WithSigner(blossytest.NewSigner(t)))
+	if err != nil {
+		t.Fatalf("failed to create the store: %v", err)
+	}
+	blob, err := signed.Get(ctx, desc.Hash)
+	if err != nil {
+		t.Fatalf("expected the signed download to succeed, got %v", err)
+	}
+	defer blob.Close()
+
+	if data, _ := io.ReadAll(blob); string(data) != "private blob" {
+		t.Errorf("expected the blob, got %q", data)
+	}
+}
@@ -0,0 +1,440 @@
+// Package multi provides a [blossy.Store] that replicates blobs across several stores,
+// for example two S3 regions and a local disk, giving durability without an external orchestrator.
+//
+// Every blob is written to all the backends, and the upload succeeds when at least K of them
+// (the write quorum) stored it. The backends that failed are repaired in the background, by copying
+// the blob from one that has it. Reads go to the fastest backend first, according to the observed latencies,
+// and fall back to the others when it fails or doesn't have the blob.
+//
+// Example:
+//
+//	store, err := multi.New([]blossy.Store{east, west, local}, multi.WithQuorum(2))
+//	if err != nil {
+//	    panic(err)
+//	}
+//	blossy.BindStore(server, store)
+//	store.Bind(server) // repairs the under-replicated blobs while the server is serving
+package multi
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+// failurePenalty is the latency recorded for a backend that failed, so that it's tried after the healthy ones.
+const failurePenalty = 10 * time.Second
+
+// Store is a [blossy.Store] that replicates blobs across several backends. Create one with [New].
+type Store struct {
+	backends []blossy.Store
+	quorum   int
+
+	// latencies are the moving averages of the latencies of the backends, in nanoseconds.
+	latencies []atomic.Int64
+
+	mu      sync.Mutex
+	repairs map[repairKey]*repair
+
+	interval time.Duration
+	spoolDir string
+	log      *slog.Logger
+}
+
+// repairKey identifies a blob uploaded by a pubkey.
+type repairKey struct {
+	hash   blossom.Hash
+	pubkey string
+}
+
+// repair is a blob that is missing from some backends.
+type repair struct {
+	mime    string
+	size    int64
+	missing []int // indexes of the backends
+}
+
+type Option func(*Store)
+
+// WithQuorum sets the number of backends that must store a blob for the upload to succeed.
+// By default, it's the number of backends.
+func WithQuorum(k int) Option {
+	return func(s *Store) {
+		s.quorum = k
+	}
+}
+
+// WithRepairInterval sets how often the under-replicated blobs are repaired when started with [Store.Run]
+// or [Store.Bind]. By default, it's one minute.
+func WithRepairInterval(d time.Duration) Option {
+	return func(s *Store) {
+		s.interval = d
+	}
+}
+
+// WithSpoolDir sets the directory of the temporary files where uploads are written before being
+// copied to the backends. By default, it's [os.TempDir].
+func WithSpoolDir(dir string) Option {
+	return func(s *Store) {
+		s.spoolDir = dir
+	}
+}
+
+// WithLogger sets the logger of the store. By default, it's [slog.Default].
+func WithLogger(l *slog.Logger) Option {
+	return func(s *Store) {
+		s.log = l
+	}
+}
+
+// New returns a Store that replicates blobs across the backends.
+// It returns an error if there are no backends, or the options are invalid.
+func New(backends []blossy.Store, opts ...Option) (*Store, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("multi: at least one backend is required")
+	}
+	if slices.Contains(backends, nil) {
+		return nil, errors.New("multi: backends must not be nil")
+	}
+
+	s := &Store{
+		backends:  slices.Clone(backends),
+		quorum:    len(backends),
+		latencies: make([]atomic.Int64, len(backends)),
+		repairs:   make(map[repairKey]*repair),
+		interval:  time.Minute,
+		log:       slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.quorum < 1 || s.quorum > len(backends) {
+		return nil, fmt.Errorf("multi: quorum must be between 1 and %d, got %d", len(backends), s.quorum)
+	}
+	if s.interval <= 0 {
+		return nil, errors.New("multi: repair interval must be positive")
+	}
+	if s.log == nil {
+		return nil, errors.New("multi: logger must not be nil")
+	}
+	return s, nil
+}
+
+// observe records the latency of the backend.
+func (s *Store) observe(i int, d time.Duration) {
+	old := s.latencies[i].Load()
+	if old == 0 {
+		s.latencies[i].Store(int64(d))
+		return
+	}
+	s.latencies[i].Store((7*old + int64(d)) / 8)
+}
+
+// fastest returns the indexes of the backends, sorted by their latency.
+// Backends without observations come first, so that they are measured.
+func (s *Store) fastest() []int {
+	order := make([]int, len(s.backends))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Compare(s.latencies[a].Load(), s.latencies[b].Load())
+	})
+	return order
+}
+
+// read calls the function on the fastest backends, except the skipped one, until one succeeds.
+// It returns [blossy.ErrBlobNotFound] if no backend has the blob.
+func read[T any](s *Store, skip int, f func(blossy.Store) (T, error)) (T, error) {
+	var errs []error
+	for _, i := range s.fastest() {
+		if i == skip {
+			continue
+		}
+
+		start := time.Now()
+		v, err := f(s.backends[i])
+		switch {
+		case err == nil:
+			s.observe(i, time.Since(start))
+			return v, nil
+
+		case errors.Is(err, blossy.ErrBlobNotFound):
+			s.observe(i, time.Since(start))
+
+		default:
+			s.observe(i, failurePenalty)
+			errs = append(errs, fmt.Errorf("multi: backend %d: %w", i, err))
+		}
+	}
+
+	var zero T
+	if len(errs) > 0 {
+		return zero, errors.Join(errs...)
+	}
+	return zero, blossy.ErrBlobNotFound
+}
+
+// Get returns the blob from the fastest backend that has it, or [blossy.ErrBlobNotFound].
+func (s *Store) Get(ctx context.Context, hash blossom.Hash) (blossom.Blob, error) {
+	return read(s, -1, func(b blossy.Store) (blossom.Blob, error) { return b.Get(ctx, hash) })
+}
+
+// Head returns the descriptor of the blob from the fastest backend that has it, or [blossy.ErrBlobNotFound].
+func (s *Store) Head(ctx context.Context, hash blossom.Hash) (blossom.BlobDescriptor, error) {
+	return read(s, -1, func(b blossy.Store) (blossom.BlobDescriptor, error) { return b.Head(ctx, hash) })
+}
+
+// Save writes the data to a temporary file while hashing it, and then copies it to all the backends concurrently.
+// It succeeds if at least the quorum of backends stored the blob, in which case the others are repaired later.
+// If the hints contain a hash, it returns [utils.ErrHashMismatch] when the data doesn't match it.
+func (s *Store) Save(ctx context.Context, pubkey string, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, error) {
+	spool, err := os.CreateTemp(s.spoolDir, "blossy-multi-*")
+	if err != nil {
+		return blossom.BlobDescriptor{}, fmt.Errorf("multi: failed to create the spool file: %w", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	reader := utils.NewHashReader(data, hints.Hash)
+	size, err := io.Copy(spool, reader)
+	if err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+
+	hash, _ := reader.Sum()
+	hints = blossy.UploadHints{Hash: &hash, Type: hints.Type, Size: size}
+
+	descs := make([]blossom.BlobDescriptor, len(s.backends))
+	errs := make([]error, len(s.backends))
+
+	var wg sync.WaitGroup
+	for i, backend := range s.backends {
+		wg.Go(func() {
+			descs[i], errs[i] = backend.Save(ctx, pubkey, hints, io.NewSectionReader(spool, 0, size))
+		})
+	}
+	wg.Wait()
+
+	var stored []int
+	var missing []int
+	for i, err := range errs {
+		if err == nil {
+			stored = append(stored, i)
+		} else {
+			missing = append(missing, i)
+		}
+	}
+
+	if len(stored) < s.quorum {
+		for i, err := range errs {
+			if err != nil {
+				errs[i] = fmt.Errorf("multi: backend %d: %w", i, err)
+			}
+		}
+		return blossom.BlobDescriptor{}, fmt.Errorf("multi: the blob was stored by %d backends out of the %d required: %w",
+			len(stored), s.quorum, errors.Join(errs...))
+	}
+
+	desc := descs[stored[0]]
+	if len(missing) > 0 {
+		s.schedule(repairKey{hash: hash, pubkey: pubkey}, desc.Type, size, missing)
+	}
+	return desc, nil
+}
+
+// Delete removes the ownership of the blob by the pubkey from all the backends.
+// It returns [blossy.ErrBlobNotFound] if no backend has the blob owned by the pubkey.
+func (s *Store) Delete(ctx context.Context, pubkey string, hash blossom.Hash) error {
+	s.mu.Lock()
+	delete(s.repairs, repairKey{hash: hash, pubkey: pubkey})
+	s.mu.Unlock()
+
+	var errs []error
+	deleted := false
+	for i, backend := range s.backends {
+		err := backend.Delete(ctx, pubkey, hash)
+		switch {
+		case err == nil:
+			deleted = true
+		case errors.Is(err, blossy.ErrBlobNotFound):
+		default:
+			errs = append(errs, fmt.Errorf("multi: backend %d: %w", i, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	if !deleted {
+		return blossy.ErrBlobNotFound
+	}
+	return nil
+}
+
+// List returns the page selected by the query of the union of the descriptors of the backends,
+// sorted by upload time, newest first unless the query specifies otherwise.
+// Backends that fail are skipped, unless all of them fail.
+func (s *Store) List(ctx context.Context, pubkey string, query blossy.ListQuery) ([]blossom.BlobDescriptor, error) {
+	lists := make([][]blossom.BlobDescriptor, len(s.backends))
+	errs := make([]error, len(s.backends))
+
+	// the pages of the backends can't be merged, so the page is selected from the union of their blobs
+	filters := query
+	filters.Limit = 0
+	filters.Cursor = nil
+
+	var wg sync.WaitGroup
+	for i, backend := range s.backends {
+		wg.Go(func() {
+			lists[i], errs[i] = backend.List(ctx, pubkey, filters)
+		})
+	}
+	wg.Wait()
+
+	failed := 0
+	for i, err := range errs {
+		if err != nil {
+			failed++
+			errs[i] = fmt.Errorf("multi: backend %d: %w", i, err)
+		}
+	}
+	if failed == len(s.backends) {
+		return nil, errors.Join(errs...)
+	}
+
+	index := make(map[blossom.Hash]int)
+	var descs []blossom.BlobDescriptor
+	for _, list := range lists {
+		for _, desc := range list {
+			i, ok := index[desc.Hash]
+			if !ok {
+				index[desc.Hash] = len(descs)
+				descs = append(descs, desc)
+				continue
+			}
+			if desc.Uploaded < descs[i].Uploaded {
+				descs[i].Uploaded = desc.Uploaded
+			}
+		}
+	}
+
+	return query.Apply(descs), nil
+}
+
+// schedule records that the blob uploaded by the pubkey is missing from the backends.
+func (s *Store) schedule(key repairKey, mime string, size int64, missing []int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.repairs[key]
+	if !ok {
+		s.repairs[key] = &repair{mime: mime, size: size, missing: missing}
+		return
+	}
+	for _, i := range missing {
+		if !slices.Contains(r.missing, i) {
+			r.missing = append(r.missing, i)
+		}
+	}
+}
+
+// Pending returns the number of blobs waiting to be repaired.
+func (s *Store) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.repairs)
+}
+
+// Bind repairs the under-replicated blobs in the background of the server while it's serving
+// (see [blossy.Server.Background]).
+func (s *Store) Bind(server *blossy.Server) {
+	server.Background(s.Run)
+}
+
+// Run repairs the under-replicated blobs every interval, until the context is cancelled.
+func (s *Store) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			if err := s.Repair(ctx); err != nil {
+				s.log.Error("multi: repair failed", "error", err, "pending", s.Pending())
+			}
+		}
+	}
+}
+
+// Repair copies the under-replicated blobs to the backends that miss them, from one that has them.
+// The blobs that can't be repaired are retried on the next call, and the errors are returned joined.
+func (s *Store) Repair(ctx context.Context) error {
+	s.mu.Lock()
+	repairs := make(map[repairKey]repair, len(s.repairs))
+	for key, r := range s.repairs {
+		repairs[key] = repair{mime: r.mime, size: r.size, missing: slices.Clone(r.missing)}
+	}
+	s.mu.Unlock()
+
+	var errs []error
+	for key, r := range repairs {
+		if ctx.Err() != nil {
+			return errors.Join(append(errs, ctx.Err())...)
+		}
+
+		repaired, err := s.repair(ctx, key, r)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("multi: blob %s: %w", key.hash.Hex(), err))
+		}
+
+		s.mu.Lock()
+		if current, ok := s.repairs[key]; ok {
+			current.missing = slices.DeleteFunc(current.missing, func(i int) bool { return slices.Contains(repaired, i) })
+			if len(current.missing) == 0 {
+				delete(s.repairs, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// repair copies the blob to the backends that miss it, returning the ones that have been repaired.
+func (s *Store) repair(ctx context.Context, key repairKey, r repair) ([]int, error) {
+	var repaired []int
+	var errs []error
+	hints := blossy.UploadHints{Hash: &key.hash, Type: r.mime, Size: r.size}
+
+	for _, i := range r.missing {
+		blob, err := read(s, i, func(b blossy.Store) (blossom.Blob, error) { return b.Get(ctx, key.hash) })
+		if err != nil {
+			return repaired, fmt.Errorf("no backend has a copy: %w", err)
+		}
+
+		_, err = s.backends[i].Save(ctx, key.pubkey, hints, blob)
+		blob.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("backend %d: %w", i, err))
+			continue
+		}
+		repaired = append(repaired, i)
+	}
+	return repaired, errors.Join(errs...)
+}
@@ -0,0 +1,187 @@
+package multi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/stores/memory"
+)
+
+var (
+	ctx   = context.Background()
+	alice = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+)
+
+// flakyStore is a memory store whose operations fail while down is true.
+type flakyStore struct {
+	*memory.Store
+	down atomic.Bool
+	gets atomic.Int32
+}
+
+var errDown = errors.New("backend is down")
+
+func newFlakyStore() *flakyStore {
+	return &flakyStore{Store: memory.New()}
+}
+
+func (s *flakyStore) Get(ctx context.Context, hash blossom.Hash) (blossom.Blob, error) {
+	s.gets.Add(1)
+	if s.down.Load() {
+		return nil, errDown
+	}
+	return s.Store.Get(ctx, hash)
+}
+
+func (s *flakyStore) Save(ctx context.Context, pubkey string, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, error) {
+	if s.down.Load() {
+		return blossom.BlobDescriptor{}, errDown
+	}
+	return s.Store.Save(ctx, pubkey, hints, data)
+}
+
+func (s *flakyStore) List(ctx context.Context, pubkey string, query blossy.ListQuery) ([]blossom.BlobDescriptor, error) {
+	if s.down.Load() {
+		return nil, errDown
+	}
+	return s.Store.List(ctx, pubkey, query)
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(nil); err == nil {
+		t.Error("expected an error without backends")
+	}
+	if _, err := New([]blossy.Store{memory.New()}, WithQuorum(2)); err == nil {
+		t.Error("expected an error with a quorum bigger than the backends")
+	}
+}
+
+func TestSaveAndRepair(t *testing.T) {
+	a, b, c := newFlakyStore(), newFlakyStore(), newFlakyStore()
+	store, err := New([]blossy.Store{a, b, c}, WithQuorum(2), WithSpoolDir(t.TempDir()))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	c.down.Store(true)
+	desc, err := store.Save(ctx, alice, blossy.UploadHints{Size: -1}, strings.NewReader("replicated"))
+	if err != nil {
+		t.Fatalf("expected the quorum to be reached, got %v", err)
+	}
+	if desc.Hash != blossom.ComputeHash([]byte("replicated")) {
+		t.Errorf("unexpected hash %s", desc.Hash)
+	}
+	if a.Len() != 1 || b.Len() != 1 || c.Len() != 0 {
+		t.Errorf("expected the blob in a and b, got %d %d %d", a.Len(), b.Len(), c.Len())
+	}
+	if store.Pending() != 1 {
+		t.Fatalf("expected 1 pending repair, got %d", store.Pending())
+	}
+
+	if err := store.Repair(ctx); !errors.Is(err, errDown) {
+		t.Errorf("expected the repair to fail while c is down, got %v", err)
+	}
+
+	c.down.Store(false)
+	if err := store.Repair(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if store.Pending() != 0 {
+		t.Errorf("expected no pending repairs, got %d", store.Pending())
+	}
+
+	list, err := c.List(ctx, alice, blossy.ListQuery{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(list) != 1 || list[0].Hash != desc.Hash {
+		t.Errorf("expected c to be repaired with the blob owned by alice, got %v", list)
+	}
+
+	b.down.Store(true)
+	c.down.Store(true)
+	if _, err := store.Save(ctx, alice, blossy.UploadHints{Size: -1}, strings.NewReader("no quorum")); !errors.Is(err, errDown) {
+		t.Errorf("expected the save to fail without the quorum, got %v", err)
+	}
+}
+
+func TestGetFailover(t *testing.T) {
+	a, b := newFlakyStore(), newFlakyStore()
+	store, err := New([]blossy.Store{a, b}, WithSpoolDir(t.TempDir()))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	desc, err := store.Save(ctx, alice, blossy.UploadHints{Size: -1}, strings.NewReader("failover"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	a.down.Store(true)
+	blob, err := store.Get(ctx, desc.Hash)
+	if err != nil {
+		t.Fatalf("expected b to serve the blob, got %v", err)
+	}
+	blob.Close()
+
+	// a is now known to be slow, so b is tried first
+	a.gets.Store(0)
+	if blob, err = store.Get(ctx, desc.Hash); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	blob.Close()
+	if a.gets.Load() != 0 {
+		t.Errorf("expected the failing backend to be tried last, got %d gets", a.gets.Load())
+	}
+
+	if _, err := store.Get(ctx, blossom.ComputeHash([]byte("missing"))); !errors.Is(err, errDown) {
+		t.Errorf("expected the error of the failing backend, got %v", err)
+	}
+	a.down.Store(false)
+	if _, err := store.Get(ctx, blossom.ComputeHash([]byte("missing"))); !errors.Is(err, blossy.ErrBlobNotFound) {
+		t.Errorf("expected ErrBlobNotFound, got %v", err)
+	}
+}
+
+func TestListAndDelete(t *testing.T) {
+	a, b := newFlakyStore(), newFlakyStore()
+	store, err := New([]blossy.Store{a, b}, WithQuorum(1), WithSpoolDir(t.TempDir()))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	b.down.Store(true)
+	first, _ := store.Save(ctx, alice, blossy.UploadHints{Size: -1}, strings.NewReader("first"))
+	b.down.Store(false)
+	a.down.Store(true)
+	second, _ := store.Save(ctx, alice, blossy.UploadHints{Size: -1}, strings.NewReader("second"))
+
+	list, err := store.List(ctx, alice, blossy.ListQuery{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(list) != 1 || list[0].Hash != second.Hash {
+		t.Errorf("expected the blobs of the available backend, got %v", list)
+	}
+
+	a.down.Store(false)
+	if list, _ = store.List(ctx, alice, blossy.ListQuery{}); len(list) != 2 {
+		t.Errorf("expected the union of the backends, got %v", list)
+	}
+
+	if err := store.Delete(ctx, alice, first.Hash); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.Delete(ctx, alice, first.Hash); !errors.Is(err, blossy.ErrBlobNotFound) {
+		t.Errorf("expected ErrBlobNotFound, got %v", err)
+	}
+	if store.Pending() != 1 {
+		t.Errorf("expected the repair of the deleted blob to be dropped, got %d pending", store.Pending())
+	}
+}
@@ -0,0 +1,247 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// client is a minimal S3 client, implementing only the operations used by the [Store].
+type client struct {
+	http      *http.Client
+	endpoint  *url.URL
+	bucket    string
+	pathStyle bool
+	signer    signer
+}
+
+// apiError is an error response of the S3 API.
+type apiError struct {
+	Status  int    `xml:"-"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("s3: %s (status %d): %s", e.Code, e.Status, e.Message)
+}
+
+// isNotFound returns whether the error is an S3 error for a missing object.
+func isNotFound(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound
+}
+
+// objectURL returns the URL of the object with the provided key, with virtual-hosted or path style addressing.
+func (c *client) objectURL(key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	if c.pathStyle {
+		u.Path = "/" + c.bucket + "/" + key
+	} else {
+		u.Host = c.bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// do sends a signed request for the object with the provided key. The key can be empty for bucket operations.
+// Responses with a status code >= 300 are returned as an [apiError], after closing their body.
+func (c *client) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.objectURL(key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	if len(body) == 0 {
+		req.Body = http.NoBody
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	payloadHash := emptyHash
+	if len(body) > 0 {
+		payloadHash = hashHex(body)
+	}
+	c.signer.sign(req, payloadHash, time.Now())
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+		return nil, parseError(res.StatusCode, res.Body)
+	}
+	return res, nil
+}
+
+// parseError reads the S3 error from the body. HEAD responses have no body, so the status is always set.
+func parseError(status int, body io.Reader) error {
+	apiErr := &apiError{Status: status, Code: http.StatusText(status)}
+	data, _ := io.ReadAll(io.LimitReader(body, 64<<10))
+	if len(data) > 0 {
+		xml.Unmarshal(data, apiErr)
+	}
+	return apiErr
+}
+
+// doDiscard is like [client.do], but it discards the response body.
+// Some operations (e.g. copy, complete multipart upload) can fail after the 200 status has been sent,
+// in which case the error is in the body, so the body is checked for it.
+func (c *client) doDiscard(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (http.Header, error) {
+	res, err := c.do(ctx, method, key, query, header, body)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Contains(data, []byte("<Error>")) {
+		return nil, parseError(http.StatusInternalServerError, bytes.NewReader(data))
+	}
+	return res.Header, nil
+}
+
+func (c *client) putObject(ctx context.Context, key, contentType string, data []byte) error {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	_, err := c.doDiscard(ctx, http.MethodPut, key, nil, header, data)
+	return err
+}
+
+func (c *client) getObject(ctx context.Context, key string) (*http.Response, error) {
+	return c.do(ctx, http.MethodGet, key, nil, nil, nil)
+}
+
+func (c *client) headObject(ctx context.Context, key string) (http.Header, error) {
+	return c.doDiscard(ctx, http.MethodHead, key, nil, nil, nil)
+}
+
+func (c *client) deleteObject(ctx context.Context, key string) error {
+	_, err := c.doDiscard(ctx, http.MethodDelete, key, nil, nil, nil)
+	return err
+}
+
+// copyObject copies the object at src to dst, replacing its content type.
+// S3 limits copies to objects of at most 5 GiB.
+func (c *client) copyObject(ctx context.Context, src, dst, contentType string) error {
+	header := http.Header{}
+	header.Set("X-Amz-Copy-Source", (&url.URL{Path: "/" + c.bucket + "/" + src}).EscapedPath())
+	header.Set("X-Amz-Metadata-Directive", "REPLACE")
+	header.Set("Content-Type", contentType)
+	_, err := c.doDiscard(ctx, http.MethodPut, dst, nil, header, nil)
+	return err
+}
+
+func (c *client) createMultipartUpload(ctx context.Context, key string) (string, error) {
+	res, err := c.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("s3: failed to decode the multipart upload: %w", err)
+	}
+	if result.UploadID == "" {
+		return "", errors.New("s3: multipart upload ID is empty")
+	}
+	return result.UploadID, nil
+}
+
+// uploadPart uploads the part with the provided number (starting from 1), returning its ETag.
+func (c *client) uploadPart(ctx context.Context, key, uploadID string, number int, data []byte) (string, error) {
+	query := url.Values{
+		"partNumber": {strconv.Itoa(number)},
+		"uploadId":   {uploadID},
+	}
+	header, err := c.doDiscard(ctx, http.MethodPut, key, query, nil, data)
+	if err != nil {
+		return "", err
+	}
+	return header.Get("ETag"), nil
+}
+
+type completedPart struct {
+	Number int    `xml:"PartNumber"`
+	ETag   string `xml:"ETag"`
+}
+
+func (c *client) completeMultipartUpload(ctx context.Context, key, uploadID string, parts []completedPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.doDiscard(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, body)
+	return err
+}
+
+func (c *client) abortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	_, err := c.doDiscard(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil)
+	return err
+}
+
+// object is an entry of a bucket listing.
+type object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	Size         int64     `xml:"Size"`
+}
+
+// list returns up to max objects whose key starts with the prefix, and the token to fetch the next page,
+// which is empty when there are no more objects.
+func (c *client) list(ctx context.Context, prefix, token string, max int) ([]object, string, error) {
+	query := url.Values{
+		"list-type": {"2"},
+		"prefix":    {prefix},
+		"max-keys":  {strconv.Itoa(max)},
+	}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+
+	res, err := c.do(ctx, http.MethodGet, "", query, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+
+	var result struct {
+		Contents    []object `xml:"Contents"`
+		IsTruncated bool     `xml:"IsTruncated"`
+		NextToken   string   `xml:"NextContinuationToken"`
+	}
+	if err := xml.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, "", fmt.Errorf("s3: failed to decode the bucket listing: %w", err)
+	}
+	if !result.IsTruncated {
+		result.NextToken = ""
+	}
+	return result.Contents, result.NextToken, nil
+}
+
+// presign returns a presigned URL to GET the object with the provided key.
+func (c *client) presign(key string, expiry time.Duration) string {
+	return c.signer.presign(http.MethodGet, c.objectURL(key, nil), expiry, time.Now())
+}
@@ -0,0 +1,456 @@
+// Package s3 provides a [blossy.Store] backed by an S3-compatible object storage,
+// such as AWS S3, MinIO, Cloudflare R2 or Backblaze B2.
+//
+// Objects are stored in the bucket under the configured prefix, with the following layout:
+//   - blobs/<sha256>: the blob, with its content type
+//   - owners/<pubkey>/<sha256>: an empty marker for each owner of the blob, used for listing
+//   - refs/<sha256>/<pubkey>: an empty marker for each owner of the blob, used for deletion
+//   - tmp/<random>: the blobs being uploaded in multiple parts, before their hash is known
+//
+// Blobs that fit in a single part are uploaded with one request. Larger blobs are uploaded in parts
+// to a temporary object, and then copied to their final key, which S3 limits to 5 GiB.
+//
+// When [Config.PresignExpiry] is set, the store implements downloads by redirecting
+// clients to presigned URLs, so that blobs are served by the object storage directly.
+package s3
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+const (
+	// DefaultPartSize is the size of the parts of multipart uploads, if not configured.
+	DefaultPartSize = 16 << 20 // 16 MiB
+
+	// MinPartSize is the minimum size of the parts of multipart uploads allowed by S3.
+	MinPartSize = 5 << 20 // 5 MiB
+
+	// anonymous is the name of the owner of unauthenticated uploads.
+	anonymous = "_"
+)
+
+// Config is the configuration of a [Store].
+type Config struct {
+	// Endpoint is the URL of the S3 API, including the scheme.
+	// For example "https://s3.us-east-1.amazonaws.com" or "http://localhost:9000".
+	Endpoint string
+
+	// Region of the bucket. If empty, "us-east-1" is used, which is also what MinIO expects by default.
+	Region string
+
+	// Bucket is the name of the bucket, which must already exist.
+	Bucket string
+
+	// Prefix is prepended to the keys of all the objects, which allows sharing the bucket.
+	// For example "blossom/".
+	Prefix string
+
+	// AccessKey and SecretKey are the credentials used to sign the requests.
+	AccessKey string
+	SecretKey string
+
+	// PathStyle enables path-style addressing (endpoint/bucket/key) instead of virtual-hosted style
+	// (bucket.endpoint/key). It's required by MinIO and most self-hosted deployments.
+	PathStyle bool
+
+	// PartSize is the size of the parts of multipart uploads, which is also the amount of memory
+	// used to buffer each upload. If zero, [DefaultPartSize] is used. It must be at least [MinPartSize].
+	PartSize int64
+
+	// PresignExpiry is how long the presigned download URLs are valid for.
+	// If zero, downloads are served by the blossom server instead of redirecting to the object storage.
+	PresignExpiry time.Duration
+
+	// Client is the HTTP client used to send the requests. If nil, [http.DefaultClient] is used.
+	Client *http.Client
+}
+
+// Store is a [blossy.Store] backed by an S3-compatible object storage. Create one with [New].
+type Store struct {
+	client        *client
+	prefix        string
+	partSize      int64
+	presignExpiry time.Duration
+}
+
+// New returns a Store with the provided configuration.
+// It doesn't contact the object storage, so invalid credentials or buckets are reported on first use.
+func New(c Config) (*Store, error) {
+	endpoint, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("s3: invalid endpoint: %w", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" || endpoint.Host == "" {
+		return nil, errors.New("s3: invalid endpoint: must be an http or https URL")
+	}
+	if c.Bucket == "" {
+		return nil, errors.New("s3: bucket must not be empty")
+	}
+	if c.AccessKey == "" || c.SecretKey == "" {
+		return nil, errors.New("s3: access key and secret key must not be empty")
+	}
+	if c.PartSize == 0 {
+		c.PartSize = DefaultPartSize
+	}
+	if c.PartSize < MinPartSize {
+		return nil, fmt.Errorf("s3: part size must be at least %d bytes", MinPartSize)
+	}
+	if c.PresignExpiry < 0 || c.PresignExpiry > 7*24*time.Hour {
+		return nil, errors.New("s3: presign expiry must be between 0 and 7 days")
+	}
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
+	if c.Client == nil {
+		c.Client = http.DefaultClient
+	}
+
+	endpoint.Path = ""
+	endpoint.RawQuery = ""
+
+	return &Store{
+		client: &client{
+			http:      c.Client,
+			endpoint:  endpoint,
+			bucket:    c.Bucket,
+			pathStyle: c.PathStyle,
+			signer: signer{
+				accessKey: c.AccessKey,
+				secretKey: c.SecretKey,
+				region:    c.Region,
+			},
+		},
+		prefix:        c.Prefix,
+		partSize:      c.PartSize,
+		presignExpiry: c.PresignExpiry,
+	}, nil
+}
+
+// Get returns the blob with the provided hash, or [blossy.ErrBlobNotFound].
+// The blob is buffered to a temporary file, which is removed when the blob is closed.
+func (s *Store) Get(ctx context.Context, hash blossom.Hash) (blossom.Blob, error) {
+	res, err := s.client.getObject(ctx, s.blobKey(hash))
+	if isNotFound(err) {
+		return nil, blossy.ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	tmp, err := os.CreateTemp("", "blossy-s3-*")
+	if err != nil {
+		return nil, err
+	}
+	// the file remains readable until it's closed
+	os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, res.Body); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	return blossom.BlobFromFile(tmp)
+}
+
+// RedirectURL returns a presigned URL of the blob with the provided hash, or [blossy.ErrBlobNotFound].
+// It returns an empty URL when [Config.PresignExpiry] is not set.
+func (s *Store) RedirectURL(ctx context.Context, hash blossom.Hash) (string, error) {
+	if s.presignExpiry == 0 {
+		return "", nil
+	}
+
+	// check the blob exists, so that clients get a 404 instead of an S3 error
+	if _, err := s.Head(ctx, hash); err != nil {
+		return "", err
+	}
+	return s.client.presign(s.blobKey(hash), s.presignExpiry), nil
+}
+
+// Head returns the descriptor of the blob with the provided hash, or [blossy.ErrBlobNotFound].
+func (s *Store) Head(ctx context.Context, hash blossom.Hash) (blossom.BlobDescriptor, error) {
+	header, err := s.client.headObject(ctx, s.blobKey(hash))
+	if isNotFound(err) {
+		return blossom.BlobDescriptor{}, blossy.ErrBlobNotFound
+	}
+	if err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+
+	size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return blossom.BlobDescriptor{}, fmt.Errorf("s3: invalid content length: %w", err)
+	}
+	uploaded, _ := http.ParseTime(header.Get("Last-Modified"))
+
+	return blossom.BlobDescriptor{
+		Hash:     hash,
+		Size:     size,
+		Type:     header.Get("Content-Type"),
+		Uploaded: uploaded.Unix(),
+	}, nil
+}
+
+// Save uploads the data while hashing it, and records the pubkey as an owner of the resulting blob.
+// If the hints contain a hash, it returns [utils.ErrHashMismatch] when the data doesn't match it.
+// If the hints don't contain a type, it's detected from the first bytes of the data.
+func (s *Store) Save(ctx context.Context, pubkey string, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, error) {
+	reader := utils.NewHashReader(data, hints.Hash)
+	buf := make([]byte, s.partSize)
+
+	n, err := io.ReadFull(reader, buf)
+	switch {
+	case err == nil:
+		// the blob doesn't fit in a single part
+		return s.saveMultipart(ctx, pubkey, hints, reader, buf)
+
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		hash, _ := reader.Sum()
+		mime := hints.Type
+		if mime == "" {
+			mime = http.DetectContentType(buf[:n])
+		}
+
+		desc, err := s.Head(ctx, hash)
+		if errors.Is(err, blossy.ErrBlobNotFound) {
+			if err := s.client.putObject(ctx, s.blobKey(hash), mime, buf[:n]); err != nil {
+				return blossom.BlobDescriptor{}, err
+			}
+			desc = blossom.BlobDescriptor{Hash: hash, Size: int64(n), Type: mime}
+			err = nil
+		}
+		if err != nil {
+			return blossom.BlobDescriptor{}, err
+		}
+		return s.own(ctx, pubkey, desc)
+
+	default:
+		return blossom.BlobDescriptor{}, err
+	}
+}
+
+// saveMultipart uploads the first part in buf and the rest of the reader to a temporary object,
+// and then copies it to the key of the blob.
+func (s *Store) saveMultipart(ctx context.Context, pubkey string, hints blossy.UploadHints, reader *utils.HashReader, buf []byte) (blossom.BlobDescriptor, error) {
+	mime := hints.Type
+	if mime == "" {
+		mime = http.DetectContentType(buf)
+	}
+
+	tmp := s.prefix + "tmp/" + randomID()
+	uploadID, err := s.client.createMultipartUpload(ctx, tmp)
+	if err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+
+	completed := false
+	defer func() {
+		if !completed {
+			// use a fresh context, as the request one might be the reason of the failure
+			abortCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			s.client.abortMultipartUpload(abortCtx, tmp, uploadID)
+		}
+	}()
+
+	var parts []completedPart
+	var size int64
+	n := len(buf)
+
+	for n > 0 {
+		if err := ctx.Err(); err != nil {
+			return blossom.BlobDescriptor{}, err
+		}
+
+		etag, err := s.client.uploadPart(ctx, tmp, uploadID, len(parts)+1, buf[:n])
+		if err != nil {
+			return blossom.BlobDescriptor{}, err
+		}
+		parts = append(parts, completedPart{Number: len(parts) + 1, ETag: etag})
+		size += int64(n)
+
+		n, err = io.ReadFull(reader, buf)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			err = nil
+		}
+		if err != nil {
+			return blossom.BlobDescriptor{}, err
+		}
+	}
+
+	if err := s.client.completeMultipartUpload(ctx, tmp, uploadID, parts); err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+	completed = true
+	defer s.client.deleteObject(context.WithoutCancel(ctx), tmp)
+
+	hash, _ := reader.Sum()
+	desc, err := s.Head(ctx, hash)
+	if errors.Is(err, blossy.ErrBlobNotFound) {
+		if err := s.client.copyObject(ctx, tmp, s.blobKey(hash), mime); err != nil {
+			return blossom.BlobDescriptor{}, err
+		}
+		desc = blossom.BlobDescriptor{Hash: hash, Size: size, Type: mime}
+		err = nil
+	}
+	if err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+	return s.own(ctx, pubkey, desc)
+}
+
+// own records the pubkey as an owner of the blob, if it isn't already,
+// and returns the descriptor with the time of its upload.
+func (s *Store) own(ctx context.Context, pubkey string, desc blossom.BlobDescriptor) (blossom.BlobDescriptor, error) {
+	header, err := s.client.headObject(ctx, s.ownerKey(pubkey, desc.Hash))
+	if err == nil {
+		uploaded, _ := http.ParseTime(header.Get("Last-Modified"))
+		desc.Uploaded = uploaded.Unix()
+		return desc, nil
+	}
+	if !isNotFound(err) {
+		return blossom.BlobDescriptor{}, err
+	}
+
+	if err := s.client.putObject(ctx, s.refKey(desc.Hash, pubkey), "", nil); err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+	if err := s.client.putObject(ctx, s.ownerKey(pubkey, desc.Hash), "", nil); err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+	desc.Uploaded = time.Now().Unix()
+	return desc, nil
+}
+
+// Delete removes the ownership of the blob by the pubkey, deleting the blob when it has no more owners.
+// It returns [blossy.ErrBlobNotFound] if the blob doesn't exist or it's not owned by the pubkey.
+func (s *Store) Delete(ctx context.Context, pubkey string, hash blossom.Hash) error {
+	_, err := s.client.headObject(ctx, s.ownerKey(pubkey, hash))
+	if isNotFound(err) {
+		return blossy.ErrBlobNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.client.deleteObject(ctx, s.ownerKey(pubkey, hash)); err != nil {
+		return err
+	}
+	if err := s.client.deleteObject(ctx, s.refKey(hash, pubkey)); err != nil {
+		return err
+	}
+
+	refs, _, err := s.client.list(ctx, s.prefix+"refs/"+hash.Hex()+"/", "", 1)
+	if err != nil {
+		return err
+	}
+	if len(refs) > 0 {
+		return nil
+	}
+	return s.client.deleteObject(ctx, s.blobKey(hash))
+}
+
+// List returns the page of the descriptors of the blobs owned by the pubkey selected by the query,
+// sorted by upload time, newest first unless the query specifies otherwise.
+// It sends a request for each listed blob to fetch its size and type, so it's slow on large listings,
+// and even more so when the query filters the blobs by type, as every blob of the pubkey must be fetched.
+func (s *Store) List(ctx context.Context, pubkey string, query blossy.ListQuery) ([]blossom.BlobDescriptor, error) {
+	prefix := s.prefix + "owners/" + owner(pubkey) + "/"
+
+	var markers []blossom.BlobDescriptor
+	var token string
+
+	for {
+		objects, next, err := s.client.list(ctx, prefix, token, 1000)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range objects {
+			hash, err := blossom.ParseHash(strings.TrimPrefix(obj.Key, prefix))
+			if err != nil {
+				continue // not a marker written by the store
+			}
+			markers = append(markers, blossom.BlobDescriptor{Hash: hash, Uploaded: obj.LastModified.Unix()})
+		}
+
+		if next == "" {
+			break
+		}
+		token = next
+	}
+
+	if len(query.Types) == 0 {
+		// the page can be selected before fetching the descriptors
+		markers = query.Apply(markers)
+	} else {
+		// the types are only known after fetching the descriptors, but the upload times are
+		byTime := query
+		byTime.Types = nil
+		markers = slices.DeleteFunc(markers, func(m blossom.BlobDescriptor) bool { return !byTime.Match(m) })
+	}
+
+	descs := make([]blossom.BlobDescriptor, 0, len(markers))
+	for _, marker := range markers {
+		desc, err := s.Head(ctx, marker.Hash)
+		if errors.Is(err, blossy.ErrBlobNotFound) {
+			continue // deleted in the meantime
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		desc.Uploaded = marker.Uploaded
+		descs = append(descs, desc)
+	}
+
+	if len(query.Types) > 0 {
+		return query.Apply(descs), nil
+	}
+	return descs, nil
+}
+
+func (s *Store) blobKey(hash blossom.Hash) string {
+	return s.prefix + "blobs/" + hash.Hex()
+}
+
+func (s *Store) ownerKey(pubkey string, hash blossom.Hash) string {
+	return s.prefix + "owners/" + owner(pubkey) + "/" + hash.Hex()
+}
+
+func (s *Store) refKey(hash blossom.Hash, pubkey string) string {
+	return s.prefix + "refs/" + hash.Hex() + "/" + owner(pubkey)
+}
+
+// owner returns the name used in the keys for the owner with the provided pubkey.
+func owner(pubkey string) string {
+	if pubkey == "" {
+		return anonymous
+	}
+	return pubkey
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
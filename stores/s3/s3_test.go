@@ -0,0 +1,379 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+const (
+	pk1 = "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+	pk2 = "c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5"
+)
+
+// fakeS3 is an in-memory S3 server with path-style addressing, supporting only what the store uses.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	uploads map[string]map[int][]byte
+}
+
+type fakeObject struct {
+	data     []byte
+	mime     string
+	modified time.Time
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		objects: make(map[string]fakeObject),
+		uploads: make(map[string]map[int][]byte),
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), algorithm) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	query := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+
+	switch {
+	case r.Method == http.MethodGet && query.Get("list-type") == "2":
+		var keys []string
+		for k := range f.objects {
+			if strings.HasPrefix(k, query.Get("prefix")) {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+
+		fmt.Fprint(w, "<ListBucketResult><IsTruncated>false</IsTruncated>")
+		for _, k := range keys {
+			fmt.Fprintf(w, "<Contents><Key>%s</Key><LastModified>%s</LastModified></Contents>",
+				k, f.objects[k].modified.Format(time.RFC3339))
+		}
+		fmt.Fprint(w, "</ListBucketResult>")
+
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := fmt.Sprintf("upload-%d", len(f.uploads))
+		f.uploads[id] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		var n int
+		fmt.Sscan(query.Get("partNumber"), &n)
+		f.uploads[query.Get("uploadId")][n] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, n))
+
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		var complete struct {
+			Parts []completedPart `xml:"Part"`
+		}
+		xml.Unmarshal(body, &complete)
+
+		var data []byte
+		for _, part := range complete.Parts {
+			data = append(data, f.uploads[query.Get("uploadId")][part.Number]...)
+		}
+		delete(f.uploads, query.Get("uploadId"))
+		f.objects[key] = fakeObject{data: data, modified: time.Now()}
+
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		src, ok := f.objects[strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/bucket/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f.objects[key] = fakeObject{data: src.data, mime: r.Header.Get("Content-Type"), modified: time.Now()}
+		fmt.Fprint(w, "<CopyObjectResult></CopyObjectResult>")
+
+	case r.Method == http.MethodPut:
+		f.objects[key] = fakeObject{data: body, mime: r.Header.Get("Content-Type"), modified: time.Now()}
+
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		obj, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", obj.mime)
+		w.Header().Set("Content-Length", fmt.Sprint(len(obj.data)))
+		w.Header().Set("Last-Modified", obj.modified.UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var keys []string
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func newTestStore(t *testing.T, fake *fakeS3) *Store {
+	t.Helper()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	store, err := New(Config{
+		Endpoint:      server.URL,
+		Bucket:        "bucket",
+		Prefix:        "blossom/",
+		AccessKey:     "access",
+		SecretKey:     "secret",
+		PathStyle:     true,
+		PartSize:      MinPartSize,
+		PresignExpiry: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("failed to create the store: %v", err)
+	}
+	return store
+}
+
+func hashOf(data []byte) blossom.Hash {
+	sum := sha256.Sum256(data)
+	hash, _ := blossom.ParseHash(hex.EncodeToString(sum[:]))
+	return hash
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"invalid endpoint", Config{Endpoint: "localhost:9000", Bucket: "b", AccessKey: "a", SecretKey: "s"}},
+		{"missing bucket", Config{Endpoint: "http://localhost:9000", AccessKey: "a", SecretKey: "s"}},
+		{"missing credentials", Config{Endpoint: "http://localhost:9000", Bucket: "b"}},
+		{"small parts", Config{Endpoint: "http://localhost:9000", Bucket: "b", AccessKey: "a", SecretKey: "s", PartSize: 1024}},
+		{"long expiry", Config{Endpoint: "http://localhost:9000", Bucket: "b", AccessKey: "a", SecretKey: "s", PresignExpiry: 30 * 24 * time.Hour}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := New(test.config); err == nil {
+				t.Fatal("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestSave(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeS3()
+	store := newTestStore(t, fake)
+
+	data := []byte("hello blossom")
+	hash := hashOf(data)
+
+	desc, err := store.Save(ctx, pk1, blossy.UploadHints{Type: "text/plain", Size: -1}, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	if desc.Hash != hash || desc.Size != int64(len(data)) || desc.Type != "text/plain" {
+		t.Fatalf("unexpected descriptor: %+v", desc)
+	}
+
+	if _, err := store.Save(ctx, pk2, blossy.UploadHints{Size: -1}, bytes.NewReader(data)); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	expected := []string{
+		"blossom/blobs/" + hash.Hex(),
+		"blossom/owners/" + pk1 + "/" + hash.Hex(),
+		"blossom/owners/" + pk2 + "/" + hash.Hex(),
+		"blossom/refs/" + hash.Hex() + "/" + pk1,
+		"blossom/refs/" + hash.Hex() + "/" + pk2,
+	}
+	if keys := fake.keys(); !slices.Equal(keys, expected) {
+		t.Fatalf("expected keys %v, got %v", expected, keys)
+	}
+
+	head, err := store.Head(ctx, hash)
+	if err != nil {
+		t.Fatalf("failed to head: %v", err)
+	}
+	if head.Size != int64(len(data)) || head.Type != "text/plain" {
+		t.Fatalf("unexpected descriptor: %+v", head)
+	}
+}
+
+func TestSaveMismatch(t *testing.T) {
+	fake := newFakeS3()
+	store := newTestStore(t, fake)
+
+	wrong := hashOf([]byte("something else"))
+	hints := blossy.UploadHints{Hash: &wrong, Size: -1}
+
+	_, err := store.Save(context.Background(), pk1, hints, strings.NewReader("hello blossom"))
+	if !errors.Is(err, utils.ErrHashMismatch) {
+		t.Fatalf("expected %v, got %v", utils.ErrHashMismatch, err)
+	}
+	if keys := fake.keys(); len(keys) != 0 {
+		t.Fatalf("expected no objects, got %v", keys)
+	}
+}
+
+func TestSaveMultipart(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeS3()
+	store := newTestStore(t, fake)
+
+	data := bytes.Repeat([]byte("blossom!"), (2*MinPartSize+100)/8)
+	hash := hashOf(data)
+
+	desc, err := store.Save(ctx, pk1, blossy.UploadHints{Hash: &hash, Size: -1}, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	if desc.Hash != hash || desc.Size != int64(len(data)) {
+		t.Fatalf("unexpected descriptor: %+v", desc)
+	}
+
+	fake.mu.Lock()
+	blob := fake.objects["blossom/blobs/"+hash.Hex()]
+	uploads := len(fake.uploads)
+	fake.mu.Unlock()
+
+	if !bytes.Equal(blob.data, data) {
+		t.Fatalf("stored blob doesn't match the data")
+	}
+	if uploads != 0 {
+		t.Fatalf("expected no pending multipart uploads, got %d", uploads)
+	}
+	for _, key := range fake.keys() {
+		if strings.HasPrefix(key, "blossom/tmp/") {
+			t.Fatalf("expected temporary objects to be deleted, got %s", key)
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeS3()
+	store := newTestStore(t, fake)
+
+	data := []byte("hello blossom")
+	hash := hashOf(data)
+
+	store.Save(ctx, pk1, blossy.UploadHints{Size: -1}, bytes.NewReader(data))
+	store.Save(ctx, pk2, blossy.UploadHints{Size: -1}, bytes.NewReader(data))
+
+	if err := store.Delete(ctx, "", hash); !errors.Is(err, blossy.ErrBlobNotFound) {
+		t.Fatalf("expected %v, got %v", blossy.ErrBlobNotFound, err)
+	}
+
+	if err := store.Delete(ctx, pk1, hash); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if _, err := store.Head(ctx, hash); err != nil {
+		t.Fatalf("expected the blob to be kept for the other owner, got %v", err)
+	}
+
+	if err := store.Delete(ctx, pk2, hash); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if _, err := store.Head(ctx, hash); !errors.Is(err, blossy.ErrBlobNotFound) {
+		t.Fatalf("expected %v, got %v", blossy.ErrBlobNotFound, err)
+	}
+	if keys := fake.keys(); len(keys) != 0 {
+		t.Fatalf("expected no objects, got %v", keys)
+	}
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeS3()
+	store := newTestStore(t, fake)
+
+	first, second := []byte("first"), []byte("second")
+	store.Save(ctx, pk1, blossy.UploadHints{Size: -1}, bytes.NewReader(first))
+	store.Save(ctx, pk1, blossy.UploadHints{Size: -1}, bytes.NewReader(second))
+	store.Save(ctx, pk2, blossy.UploadHints{Size: -1}, bytes.NewReader(second))
+
+	fake.mu.Lock()
+	old := fake.objects["blossom/owners/"+pk1+"/"+hashOf(first).Hex()]
+	old.modified = time.Now().Add(-time.Hour)
+	fake.objects["blossom/owners/"+pk1+"/"+hashOf(first).Hex()] = old
+	fake.mu.Unlock()
+
+	descs, err := store.List(ctx, pk1, blossy.ListQuery{})
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	if len(descs) != 2 || descs[0].Hash != hashOf(second) || descs[1].Hash != hashOf(first) {
+		t.Fatalf("expected the two blobs newest first, got %+v", descs)
+	}
+
+	descs, err = store.List(ctx, pk1, blossy.ListQuery{Since: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	if len(descs) != 1 || descs[0].Hash != hashOf(second) {
+		t.Fatalf("expected only the newest blob, got %+v", descs)
+	}
+}
+
+func TestRedirectURL(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeS3()
+	store := newTestStore(t, fake)
+
+	data := []byte("hello blossom")
+	hash := hashOf(data)
+
+	if _, err := store.RedirectURL(ctx, hash); !errors.Is(err, blossy.ErrBlobNotFound) {
+		t.Fatalf("expected %v, got %v", blossy.ErrBlobNotFound, err)
+	}
+
+	store.Save(ctx, pk1, blossy.UploadHints{Size: -1}, bytes.NewReader(data))
+
+	url, err := store.RedirectURL(ctx, hash)
+	if err != nil {
+		t.Fatalf("failed to presign: %v", err)
+	}
+	if !strings.Contains(url, "/bucket/blossom/blobs/"+hash.Hex()+"?") || !strings.Contains(url, "X-Amz-Signature=") {
+		t.Fatalf("unexpected presigned URL: %s", url)
+	}
+}
@@ -0,0 +1,305 @@
+// Package strip removes the metadata of images while they are being read, without buffering them.
+//
+// It supports JPEG, PNG and WebP images, removing EXIF (e.g. GPS coordinates and camera details),
+// XMP, IPTC and textual metadata, while preserving the pixels and the color profile.
+// Data in other formats is read unchanged.
+package strip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// HeaderLen is the number of bytes needed by [Supported] to detect the format of the data.
+const HeaderLen = 12
+
+var (
+	ErrMalformedJPEG = errors.New("strip: malformed jpeg")
+	ErrMalformedPNG  = errors.New("strip: malformed png")
+	ErrMalformedWebP = errors.New("strip: malformed webp")
+)
+
+var (
+	jpegMagic = []byte{0xff, 0xd8, 0xff}
+	pngMagic  = []byte("\x89PNG\r\n\x1a\n")
+)
+
+type format int
+
+const (
+	formatUnknown format = iota
+	formatJPEG
+	formatPNG
+	formatWebP
+)
+
+func detect(head []byte) format {
+	switch {
+	case bytes.HasPrefix(head, jpegMagic):
+		return formatJPEG
+	case bytes.HasPrefix(head, pngMagic):
+		return formatPNG
+	case len(head) >= 12 && string(head[0:4]) == "RIFF" && string(head[8:12]) == "WEBP":
+		return formatWebP
+	default:
+		return formatUnknown
+	}
+}
+
+// Supported reports whether the data starting with head is in a format whose metadata can be stripped.
+// The head should be at least [HeaderLen] bytes long.
+func Supported(head []byte) bool {
+	return detect(head) != formatUnknown
+}
+
+// Reader reads data from an underlying reader, removing the metadata of images. Create one with [NewReader].
+//
+// The metadata chunks of WebP images are overwritten with zeros instead of being removed,
+// as the size of the image is declared in its first bytes. The resulting blob is valid, and it has the same size.
+type Reader struct {
+	r      *bufio.Reader
+	format format
+	step   func() error
+
+	pending []byte // bytes ready to be returned
+	copyN   int64  // bytes to copy from the underlying reader
+	zeroN   int64  // zero bytes to return
+	rest    bool   // whether the rest of the underlying reader must be copied unchanged
+	err     error
+}
+
+// NewReader returns a [Reader] that reads from r, removing the metadata of JPEG, PNG and WebP images.
+func NewReader(r io.Reader) *Reader {
+	s := &Reader{r: bufio.NewReader(r)}
+	s.step = s.start
+	return s
+}
+
+func (s *Reader) Read(p []byte) (int, error) {
+	for {
+		switch {
+		case len(p) == 0:
+			return 0, nil
+
+		case len(s.pending) > 0:
+			n := copy(p, s.pending)
+			s.pending = s.pending[n:]
+			return n, nil
+
+		case s.zeroN > 0:
+			n := int(min(int64(len(p)), s.zeroN))
+			clear(p[:n])
+			s.zeroN -= int64(n)
+			return n, nil
+
+		case s.copyN > 0:
+			n, err := s.r.Read(p[:min(int64(len(p)), s.copyN)])
+			s.copyN -= int64(n)
+			if err == io.EOF && s.copyN > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				s.err = err
+			}
+			return n, err
+
+		case s.rest:
+			return s.r.Read(p)
+
+		case s.err != nil:
+			return 0, s.err
+
+		default:
+			if err := s.step(); err != nil {
+				s.err = err
+			}
+		}
+	}
+}
+
+// start detects the format of the data.
+func (s *Reader) start() error {
+	head, _ := s.r.Peek(HeaderLen)
+	s.format = detect(head)
+
+	switch s.format {
+	case formatJPEG:
+		s.pending = []byte{0xff, 0xd8}
+		s.r.Discard(2)
+		s.step = s.jpegSegment
+
+	case formatPNG:
+		s.pending = pngMagic
+		s.r.Discard(len(pngMagic))
+		s.step = s.pngChunk
+
+	case formatWebP:
+		s.pending = append([]byte{}, head...)
+		s.r.Discard(HeaderLen)
+		s.step = s.webpChunk
+
+	default:
+		s.rest = true
+	}
+	return nil
+}
+
+// jpegSegment processes the next segment of a JPEG image, until the start of the scan.
+// Learn more here: https://www.w3.org/Graphics/JPEG/itu-t81.pdf (Annex B)
+func (s *Reader) jpegSegment() error {
+	marker, err := s.r.ReadByte()
+	if err != nil {
+		return unexpected(err)
+	}
+	if marker != 0xff {
+		return ErrMalformedJPEG
+	}
+
+	code, err := s.r.ReadByte()
+	for err == nil && code == 0xff {
+		// fill bytes
+		code, err = s.r.ReadByte()
+	}
+	if err != nil {
+		return unexpected(err)
+	}
+
+	switch {
+	case code == 0xda || code == 0xd9:
+		// start of scan or end of image: the rest is image data
+		s.pending = []byte{0xff, code}
+		s.rest = true
+		return nil
+
+	case code == 0x01 || (code >= 0xd0 && code <= 0xd8):
+		// markers without a length
+		s.pending = []byte{0xff, code}
+		return nil
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(s.r, length[:]); err != nil {
+		return unexpected(err)
+	}
+
+	size := int64(binary.BigEndian.Uint16(length[:]))
+	if size < 2 {
+		return ErrMalformedJPEG
+	}
+
+	if jpegMetadata(code) {
+		return discard(s.r, size-2)
+	}
+
+	s.pending = []byte{0xff, code, length[0], length[1]}
+	s.copyN = size - 2
+	return nil
+}
+
+// jpegMetadata returns whether the segment with the code contains metadata.
+// The JFIF (APP0), ICC profile (APP2) and Adobe (APP14) segments are kept, as they affect how the image is rendered.
+func jpegMetadata(code byte) bool {
+	switch {
+	case code == 0xfe:
+		// comment
+		return true
+	case code >= 0xe0 && code <= 0xef:
+		return code != 0xe0 && code != 0xe2 && code != 0xee
+	default:
+		return false
+	}
+}
+
+// pngChunk processes the next chunk of a PNG image.
+// Learn more here: https://www.w3.org/TR/png-3/#5Chunk-layout
+func (s *Reader) pngChunk() error {
+	var header [8]byte
+	n, err := io.ReadFull(s.r, header[:])
+	if n == 0 && err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		return unexpected(err)
+	}
+
+	length := int64(binary.BigEndian.Uint32(header[0:4]))
+	if length > 1<<31-1 {
+		return ErrMalformedPNG
+	}
+
+	switch string(header[4:8]) {
+	case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+		return discard(s.r, length+4)
+
+	case "IEND":
+		s.rest = true
+	}
+
+	s.pending = header[:]
+	s.copyN = length + 4 // data and crc
+	return nil
+}
+
+// webpChunk processes the next chunk of a WebP image.
+// Learn more here: https://developers.google.com/speed/webp/docs/riff_container
+func (s *Reader) webpChunk() error {
+	var header [8]byte
+	n, err := io.ReadFull(s.r, header[:])
+	if n == 0 && err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		return unexpected(err)
+	}
+
+	size := int64(binary.LittleEndian.Uint32(header[4:8]))
+	padded := size + size&1
+
+	switch string(header[0:4]) {
+	case "EXIF", "XMP ":
+		if err := discard(s.r, padded); err != nil {
+			return err
+		}
+		copy(header[0:4], "JUNK")
+		s.pending = header[:]
+		s.zeroN = padded
+		return nil
+
+	case "VP8X":
+		if size != 10 {
+			return ErrMalformedWebP
+		}
+		data := make([]byte, 8+padded)
+		copy(data, header[:])
+		if _, err := io.ReadFull(s.r, data[8:]); err != nil {
+			return unexpected(err)
+		}
+
+		// clear the EXIF and XMP flags
+		data[8] &^= 0x08 | 0x04
+		s.pending = data
+		return nil
+	}
+
+	s.pending = header[:]
+	s.copyN = padded
+	return nil
+}
+
+func discard(r *bufio.Reader, n int64) error {
+	if _, err := io.CopyN(io.Discard, r, n); err != nil {
+		return unexpected(err)
+	}
+	return nil
+}
+
+// unexpected converts [io.EOF] to [io.ErrUnexpectedEOF], as the data ended in the middle of the image.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,191 @@
+package strip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := range 16 {
+		for x := range 16 {
+			img.Set(x, y, color.RGBA{R: uint8(x * 16), G: uint8(y * 16), B: 64, A: 255})
+		}
+	}
+	return img
+}
+
+func jpegSegment(code byte, data string) []byte {
+	segment := []byte{0xff, code, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(data)+2))
+	return append(segment, data...)
+}
+
+func pngChunk(kind, data string) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, kind...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE([]byte(kind+data)))
+}
+
+func webpChunk(kind string, data []byte) []byte {
+	chunk := append([]byte(kind), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func webp(chunks ...[]byte) []byte {
+	body := []byte("WEBP")
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	riff := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	return append(riff, body...)
+}
+
+func stripped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	out, err := io.ReadAll(NewReader(iotest.OneByteReader(bytes.NewReader(data))))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return out
+}
+
+func TestJPEG(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	clean := buf.Bytes()
+
+	icc := jpegSegment(0xe2, "ICC_PROFILE\x00...")
+	original := append([]byte{}, clean[:2]...)
+	original = append(original, icc...)
+	original = append(original, jpegSegment(0xe1, "Exif\x00\x00GPS coordinates")...)
+	original = append(original, jpegSegment(0xfe, "a comment")...)
+	original = append(original, jpegSegment(0xed, "Photoshop 3.0\x00IPTC")...)
+	original = append(original, clean[2:]...)
+
+	expected := append(append(append([]byte{}, clean[:2]...), icc...), clean[2:]...)
+	if out := stripped(t, original); !bytes.Equal(out, expected) {
+		t.Fatalf("expected only the metadata to be removed")
+	}
+
+	if _, err := jpeg.Decode(bytes.NewReader(stripped(t, original))); err != nil {
+		t.Errorf("failed to decode the stripped image: %v", err)
+	}
+}
+
+func TestPNG(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	clean := buf.Bytes()
+
+	// insert the metadata chunks before IEND, which is the last 12 bytes
+	end := len(clean) - 12
+	original := append([]byte{}, clean[:end]...)
+	original = append(original, pngChunk("tEXt", "Author\x00Alice")...)
+	original = append(original, pngChunk("eXIf", "MM\x00*GPS coordinates")...)
+	original = append(original, clean[end:]...)
+
+	if out := stripped(t, original); !bytes.Equal(out, clean) {
+		t.Fatalf("expected only the metadata to be removed")
+	}
+}
+
+func TestWebP(t *testing.T) {
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x08 | 0x04 // EXIF and XMP flags
+	bitstream := []byte{0x2f, 0x01, 0x02, 0x03, 0x04}
+	exif := []byte("Exif GPS coordinates") // odd size
+
+	original := webp(
+		webpChunk("VP8X", vp8x),
+		webpChunk("VP8L", bitstream),
+		webpChunk("EXIF", exif[:len(exif)-1]),
+		webpChunk("XMP ", []byte("<x:xmpmeta/>")),
+	)
+
+	expected := webp(
+		webpChunk("VP8X", make([]byte, 10)),
+		webpChunk("VP8L", bitstream),
+		webpChunk("JUNK", make([]byte, len(exif)-1)),
+		webpChunk("JUNK", make([]byte, len("<x:xmpmeta/>"))),
+	)
+
+	if out := stripped(t, original); !bytes.Equal(out, expected) {
+		t.Fatalf("expected the metadata to be overwritten\nexpected %q\ngot      %q", expected, out)
+	}
+}
+
+func TestUnknown(t *testing.T) {
+	data := []byte("hello world, this is not an image")
+	if out := stripped(t, data); !bytes.Equal(out, data) {
+		t.Fatalf("expected the data to be unchanged, got %q", out)
+	}
+	if out := stripped(t, nil); len(out) != 0 {
+		t.Fatalf("expected no data, got %q", out)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{
+			name: "truncated jpeg",
+			data: append([]byte{0xff, 0xd8}, jpegSegment(0xe1, "Exif")[:5]...),
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "invalid jpeg marker",
+			data: []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x02, 0x12, 0x34},
+			err:  ErrMalformedJPEG,
+		},
+		{
+			name: "truncated png",
+			data: append(append([]byte{}, pngMagic...), pngChunk("tEXt", "Author")[:6]...),
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "invalid vp8x",
+			data: webp(webpChunk("VP8X", make([]byte, 4))),
+			err:  ErrMalformedWebP,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := io.ReadAll(NewReader(bytes.NewReader(test.data)))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestSupported(t *testing.T) {
+	if !Supported([]byte{0xff, 0xd8, 0xff, 0xe0}) || !Supported(pngMagic) || !Supported(webp()) {
+		t.Error("expected jpeg, png and webp to be supported")
+	}
+	if Supported([]byte("GIF89a")) || Supported(nil) {
+		t.Error("expected gif and empty data not to be supported")
+	}
+}
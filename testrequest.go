@@ -0,0 +1,97 @@
+package blossy
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+// TestRequestOption configures the [Request] returned by [NewTestRequest].
+type TestRequestOption func(*request)
+
+// RequestID sets the ID of the request. By default, it's 1.
+func RequestID(id int64) TestRequestOption {
+	return func(r *request) {
+		r.id = id
+	}
+}
+
+// RequestPubkey sets the pubkey of the request, as if it carried a valid authorization event signed by it.
+// By default, the request is not authenticated.
+func RequestPubkey(pubkey string) TestRequestOption {
+	return func(r *request) {
+		r.pubkey = pubkey
+	}
+}
+
+// RequestIP sets the IP address of the request. By default, it's 127.0.0.1.
+// It panics if the IP address is invalid.
+func RequestIP(ip string) TestRequestOption {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		panic("blossy.RequestIP: invalid IP address " + ip)
+	}
+
+	return func(r *request) {
+		r.ip = IP{Raw: parsed}
+	}
+}
+
+// RequestHeader sets the header of the underlying [http.Request].
+func RequestHeader(key, value string) TestRequestOption {
+	return func(r *request) {
+		r.raw.Header.Set(key, value)
+	}
+}
+
+// RequestContext sets the context of the underlying [http.Request].
+func RequestContext(ctx context.Context) TestRequestOption {
+	return func(r *request) {
+		r.raw = r.raw.WithContext(ctx)
+	}
+}
+
+// RequestValue sets the value under the key, as if a previous hook stored it with [Request.Set].
+func RequestValue(key, value any) TestRequestOption {
+	return func(r *request) {
+		r.Set(key, value)
+	}
+}
+
+// RequestRaw sets the underlying [http.Request], replacing the default GET / request.
+// As it replaces the headers and the context, use it before the other options.
+func RequestRaw(raw *http.Request) TestRequestOption {
+	return func(r *request) {
+		r.raw = raw
+	}
+}
+
+// NewTestRequest returns a [Request] for calling the hooks directly in unit tests,
+// without crafting http requests and authorization events.
+// By default, it's an unauthenticated GET / request from 127.0.0.1, with ID 1.
+//
+// Example:
+//
+//	r := blossy.NewTestRequest(blossy.RequestPubkey(pubkey), blossy.RequestIP("1.2.3.4"))
+//	if err := myRejectUpload(r, blossy.UploadHints{Size: 1 << 30}); err == nil {
+//	    t.Fatal("expected the upload to be rejected")
+//	}
+func NewTestRequest(opts ...TestRequestOption) Request {
+	raw, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		panic("blossy.NewTestRequest: " + err.Error())
+	}
+	raw.RemoteAddr = "127.0.0.1:1234"
+
+	r := &request{
+		id:    1,
+		ip:    IP{Raw: net.IPv4(127, 0, 0, 1)},
+		raw:   raw,
+		state: &requestState{},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.state.id = r.id
+	return *r
+}
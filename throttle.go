@@ -0,0 +1,127 @@
+package blossy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// throttleChunk is the maximum number of bytes written at once by a [throttledWriter],
+// so that concurrent downloads share the bandwidth fairly.
+const throttleChunk = 32 * 1024
+
+// byteBucket is a token bucket of bytes, refilled at a fixed rate, with a burst of one second of transfer.
+// It's safe for concurrent use.
+type byteBucket struct {
+	rate float64 // bytes per second
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newByteBucket(bytesPerSec int64) *byteBucket {
+	return &byteBucket{
+		rate:   float64(bytesPerSec),
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// take consumes n bytes, and returns how long to wait before writing them.
+// Tokens can go negative, so that concurrent writers are served in turn.
+func (b *byteBucket) take(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// throttledWriter is an [http.ResponseWriter] whose writes are limited by the buckets.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	buckets []*byteBucket
+}
+
+type connBucketKey struct{}
+
+// ConnContext returns the context of a new connection, carrying the bucket that limits the bandwidth
+// of its downloads as configured with [WithPerConnectionRateLimit]. It returns ctx as is if there is no such limit.
+//
+// The servers used by [Server.StartAndServe] and [Server.Serve] already use it. Set it as the ConnContext
+// of any other [http.Server] the server is mounted on, so that the limit is applied per connection.
+func (s *Server) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	rate := s.settings.HTTP.egressPerConn
+	if rate <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, connBucketKey{}, newByteBucket(rate))
+}
+
+// throttle wraps the response writer of a download with the rate limits configured with
+// [WithDownloadRateLimit] and [WithPerConnectionRateLimit], if any.
+// Downloads on connections not tracked by [Server.ConnContext] get a bucket of their own.
+func (s *Server) throttle(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	var buckets []*byteBucket
+	if b := s.settings.HTTP.egress; b != nil {
+		buckets = append(buckets, b)
+	}
+	if rate := s.settings.HTTP.egressPerConn; rate > 0 {
+		b, ok := r.Context().Value(connBucketKey{}).(*byteBucket)
+		if !ok {
+			b = newByteBucket(rate)
+		}
+		buckets = append(buckets, b)
+	}
+
+	if len(buckets) == 0 {
+		return w
+	}
+	return &throttledWriter{ResponseWriter: w, ctx: r.Context(), buckets: buckets}
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), throttleChunk)]
+
+		var wait time.Duration
+		for _, b := range t.buckets {
+			wait = max(wait, b.take(len(chunk)))
+		}
+
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-t.ctx.Done():
+				timer.Stop()
+				return written, t.ctx.Err()
+			}
+		}
+
+		n, err := t.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Unwrap returns the underlying [http.ResponseWriter], for [http.ResponseController].
+func (t *throttledWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
@@ -0,0 +1,72 @@
+package blossy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+func TestPerConnectionRateLimit(t *testing.T) {
+	const rate = 64 * 1024
+	data := make([]byte, rate) // the first download consumes the burst of its connection
+	hash := blossom.ComputeHash(data)
+
+	tests := []struct {
+		name      string
+		tracked   bool // whether the connections are tracked with ConnContext
+		reuse     bool // whether the second download reuses the connection of the first
+		throttled bool // whether the second download is throttled
+	}{
+		{"same connection", true, true, true},
+		{"new connection", true, false, false},
+		{"untracked connection", false, true, false},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			server, err := NewServer(WithHostname(testHostname), WithPerConnectionRateLimit(rate))
+			if err != nil {
+				t.Fatal(err)
+			}
+			server.On.Download = func(r Request, h blossom.Hash, ext string) (BlobDelivery, *blossom.Error) {
+				return Serve(blossom.BlobFromBytes(data)), nil
+			}
+
+			ts := httptest.NewUnstartedServer(server)
+			if test.tracked {
+				ts.Config = server.newHTTPServer("")
+			}
+			ts.Start()
+			t.Cleanup(ts.Close)
+
+			client := &http.Client{Transport: &http.Transport{DisableKeepAlives: !test.reuse}}
+			t.Cleanup(client.CloseIdleConnections)
+
+			download := func() time.Duration {
+				start := time.Now()
+				res, err := client.Get(ts.URL + "/" + hash.Hex())
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer res.Body.Close()
+
+				body, err := io.ReadAll(res.Body)
+				if err != nil || len(body) != len(data) {
+					t.Fatalf("expected the blob of %d bytes, got %d bytes (%v)", len(data), len(body), err)
+				}
+				return time.Since(start)
+			}
+
+			download()
+			elapsed := download()
+			if throttled := elapsed > 400*time.Millisecond; throttled != test.throttled {
+				t.Errorf("expected throttled to be %v, the second download took %v", test.throttled, elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package blossy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+// withTimeout returns the request with a deadline, as configured with [WithHandlerTimeout],
+// and the function that releases the resources of its context.
+func (s *Server) withTimeout(r *http.Request) (*http.Request, context.CancelFunc) {
+	d := s.settings.HTTP.handlerTimeout
+	if d <= 0 {
+		return r, func() {}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), d)
+	return r.WithContext(ctx), cancel
+}
+
+// startHook prepares the invocation of the On hook of the endpoint, tracing it if enabled (see [WithTracerProvider]).
+// The returned function must be called with the error returned by the hook, and it returns the error to respond with:
+// server errors caused by the deadline of the request (see [WithHandlerTimeout]) become 504 (Gateway Timeout).
+func (s *Server) startHook(r *request, e Endpoint) func(err *blossom.Error) *blossom.Error {
+	end := s.traceHook(r, e)
+	ctx := r.Context()
+
+	return func(err *blossom.Error) *blossom.Error {
+		if err != nil && err.Code >= 500 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = ErrTimeout("the request timed out")
+		}
+		end(err)
+		return err
+	}
+}
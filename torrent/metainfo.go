@@ -0,0 +1,147 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/url"
+	"strconv"
+)
+
+// Metainfo describes a single-file torrent of a blob (BEP 3), whose web seeds (BEP 19) are typically
+// the blossom URLs of the blob, so that BitTorrent clients can download it from the server without any seeder.
+type Metainfo struct {
+	// Name is the suggested name of the file, e.g. "<sha256>.mp4".
+	Name string
+
+	// Size is the size of the blob in bytes.
+	Size int64
+
+	// PieceLength is the number of bytes of each piece, except the last one which can be shorter.
+	PieceLength int64
+
+	// Pieces are the concatenated SHA-1 hashes of the pieces.
+	Pieces []byte
+
+	// WebSeeds are the URLs the blob can be downloaded from over HTTP.
+	WebSeeds []string
+
+	// Trackers are the announce URLs of the trackers, if any.
+	Trackers []string
+}
+
+// HashPieces reads the data until EOF, and returns the SHA-1 hashes of its pieces of the provided length,
+// and the number of bytes read.
+func HashPieces(data io.Reader, pieceLength int64) (pieces []byte, size int64, err error) {
+	if pieceLength <= 0 {
+		return nil, 0, errors.New("torrent: piece length must be positive")
+	}
+
+	buf := make([]byte, pieceLength)
+	for {
+		n, err := io.ReadFull(data, buf)
+		if n > 0 {
+			sum := sha1.Sum(buf[:n])
+			pieces = append(pieces, sum[:]...)
+			size += int64(n)
+		}
+
+		switch {
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			return pieces, size, nil
+		case err != nil:
+			return nil, 0, err
+		}
+	}
+}
+
+// InfoHash returns the SHA-1 hash of the bencoded info dictionary, which identifies the torrent.
+// It doesn't depend on the web seeds and the trackers.
+func (m *Metainfo) InfoHash() [20]byte {
+	return sha1.Sum(m.info())
+}
+
+// info returns the bencoded info dictionary, with its keys sorted as required by the specification.
+func (m *Metainfo) info() []byte {
+	b := []byte{'d'}
+	b = appendString(b, "length")
+	b = appendInt(b, m.Size)
+	b = appendString(b, "name")
+	b = appendString(b, m.Name)
+	b = appendString(b, "piece length")
+	b = appendInt(b, m.PieceLength)
+	b = appendString(b, "pieces")
+	b = appendString(b, string(m.Pieces))
+	return append(b, 'e')
+}
+
+// Bytes returns the content of the .torrent file.
+// The creation date is omitted, so that the file of a blob is always the same.
+func (m *Metainfo) Bytes() []byte {
+	b := []byte{'d'}
+	if len(m.Trackers) > 0 {
+		b = appendString(b, "announce")
+		b = appendString(b, m.Trackers[0])
+
+		// one tracker per tier, as per BEP 12
+		b = appendString(b, "announce-list")
+		b = append(b, 'l')
+		for _, tracker := range m.Trackers {
+			b = append(b, 'l')
+			b = appendString(b, tracker)
+			b = append(b, 'e')
+		}
+		b = append(b, 'e')
+	}
+
+	b = appendString(b, "created by")
+	b = appendString(b, "blossy")
+	b = appendString(b, "info")
+	b = append(b, m.info()...)
+
+	if len(m.WebSeeds) > 0 {
+		b = appendString(b, "url-list")
+		b = append(b, 'l')
+		for _, seed := range m.WebSeeds {
+			b = appendString(b, seed)
+		}
+		b = append(b, 'e')
+	}
+	return append(b, 'e')
+}
+
+// Magnet returns the magnet link of the torrent, with its web seeds and trackers.
+func (m *Metainfo) Magnet() string {
+	hash := m.InfoHash()
+
+	var b bytes.Buffer
+	b.WriteString("magnet:?xt=urn:btih:")
+	b.WriteString(hex.EncodeToString(hash[:]))
+	b.WriteString("&dn=")
+	b.WriteString(url.QueryEscape(m.Name))
+	b.WriteString("&xl=")
+	b.WriteString(strconv.FormatInt(m.Size, 10))
+	for _, seed := range m.WebSeeds {
+		b.WriteString("&ws=")
+		b.WriteString(url.QueryEscape(seed))
+	}
+	for _, tracker := range m.Trackers {
+		b.WriteString("&tr=")
+		b.WriteString(url.QueryEscape(tracker))
+	}
+	return b.String()
+}
+
+func appendString(b []byte, s string) []byte {
+	b = strconv.AppendInt(b, int64(len(s)), 10)
+	b = append(b, ':')
+	return append(b, s...)
+}
+
+func appendInt(b []byte, i int64) []byte {
+	b = append(b, 'i')
+	b = strconv.AppendInt(b, i, 10)
+	return append(b, 'e')
+}
@@ -0,0 +1,441 @@
+// Package torrent serves large blobs of a blossy server as BitTorrent torrents, whose web seeds are the
+// blossom URLs of the blobs, so that they can be shared over BitTorrent without any other infrastructure:
+// the server acts as a permanent seeder over HTTP (BEP 19), and peers exchange pieces among themselves.
+//
+// A [Generator] hashes the pieces of the blobs on demand, and caches the results. Once bound to a server,
+// GET /<sha256>.torrent returns the .torrent file of the blob, and GET /<sha256>.magnet its magnet link.
+//
+// Example:
+//
+//	gen, err := torrent.New(store,
+//	    torrent.WithMinSize(64<<20), // 64 MiB
+//	    torrent.WithTrackers("udp://tracker.opentrackr.org:1337/announce"),
+//	)
+//	if err != nil {
+//	    panic(err)
+//	}
+//	gen.Bind(server)
+package torrent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/utils"
+)
+
+// ErrTooSmall is returned by [Generator.Metainfo] for blobs smaller than the minimum size (see [WithMinSize]).
+var ErrTooSmall = errors.New("torrent: blob is too small")
+
+const (
+	// the piece length is chosen automatically so that torrents have about targetPieces pieces,
+	// within minAutoPiece and maxAutoPiece.
+	targetPieces = 1500
+	minAutoPiece = 256 << 10
+	maxAutoPiece = 16 << 20
+
+	minPieceLength = 16 << 10
+)
+
+// Generator generates the torrents of the blobs of a store. Create one with [New].
+type Generator struct {
+	store       blossy.Store
+	pieceLength int64
+	minSize     int64
+	trackers    []string
+	extForMime  func(mime string) string
+	log         *slog.Logger
+
+	mu        sync.Mutex
+	cache     map[blossom.Hash]*Metainfo
+	order     []blossom.Hash
+	cacheSize int
+	flights   map[blossom.Hash]*flight
+}
+
+// flight is a generation in progress, shared by the concurrent requests of the same blob.
+// Its result is set before done is closed, and only if the generation succeeded.
+type flight struct {
+	done chan struct{}
+	meta *Metainfo
+}
+
+type Option func(*Generator)
+
+// WithPieceLength sets the length of the pieces of the torrents, which must be a power of two of at least 16 KiB.
+// By default, it's chosen for each blob so that its torrent has about 1500 pieces, between 256 KiB and 16 MiB.
+func WithPieceLength(bytes int64) Option {
+	return func(g *Generator) {
+		g.pieceLength = bytes
+	}
+}
+
+// WithMinSize sets the size in bytes below which blobs are not served as torrents. By default, it's 1 MiB.
+func WithMinSize(bytes int64) Option {
+	return func(g *Generator) {
+		g.minSize = bytes
+	}
+}
+
+// WithTrackers sets the announce URLs of the trackers of the torrents. By default, torrents have no trackers,
+// and BitTorrent clients find peers through the DHT and the web seeds.
+func WithTrackers(urls ...string) Option {
+	return func(g *Generator) {
+		g.trackers = urls
+	}
+}
+
+// WithCacheSize sets how many torrents are kept in memory, so that the pieces of popular blobs are not hashed again.
+// By default, it's 128. Use 0 to disable the cache.
+func WithCacheSize(n int) Option {
+	return func(g *Generator) {
+		g.cacheSize = n
+	}
+}
+
+// WithLogger sets the logger of the generator. By default, it's [slog.Default].
+func WithLogger(l *slog.Logger) Option {
+	return func(g *Generator) {
+		g.log = l
+	}
+}
+
+// New returns a [Generator] of the torrents of the blobs in the store.
+func New(store blossy.Store, opts ...Option) (*Generator, error) {
+	if store == nil {
+		return nil, errors.New("torrent: store must not be nil")
+	}
+
+	g := &Generator{
+		store:      store,
+		minSize:    1 << 20,
+		cacheSize:  128,
+		extForMime: blossy.ExtForMime,
+		log:        slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	if g.pieceLength != 0 && (g.pieceLength < minPieceLength || g.pieceLength&(g.pieceLength-1) != 0) {
+		return nil, fmt.Errorf("torrent: piece length must be a power of two of at least %d bytes, got %d", minPieceLength, g.pieceLength)
+	}
+	if g.minSize < 0 {
+		return nil, errors.New("torrent: minimum size must not be negative")
+	}
+	if g.cacheSize < 0 {
+		return nil, errors.New("torrent: cache size must not be negative")
+	}
+	if g.log == nil {
+		return nil, errors.New("torrent: logger must not be nil")
+	}
+
+	g.cache = make(map[blossom.Hash]*Metainfo, g.cacheSize)
+	g.flights = make(map[blossom.Hash]*flight)
+	return g, nil
+}
+
+// Metainfo returns the torrent of the blob with the hash, with the provided web seeds and the trackers
+// of the generator. The name of the torrent is the hash and the extension of the blob, e.g. "<sha256>.mp4",
+// which follows the MIME types of the server once the generator is bound to it (see [Generator.Bind]).
+//
+// It returns [blossy.ErrBlobNotFound] if the blob doesn't exist, and [ErrTooSmall] if it's smaller than the minimum size.
+// The hash of the blob is verified while its pieces are hashed. Concurrent calls for the same blob share a single
+// generation, whose result is shared only if it succeeded: otherwise the waiting calls generate the torrent again.
+func (g *Generator) Metainfo(ctx context.Context, hash blossom.Hash, webSeeds ...string) (*Metainfo, error) {
+	generated, err := g.metainfo(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := *generated
+	meta.WebSeeds = webSeeds
+	meta.Trackers = g.trackers
+	return &meta, nil
+}
+
+// metainfo returns the cached torrent of the blob, or generates it once for all the concurrent calls.
+func (g *Generator) metainfo(ctx context.Context, hash blossom.Hash) (*Metainfo, error) {
+	for {
+		meta, f, leader := g.join(hash)
+		if meta != nil {
+			return meta, nil
+		}
+
+		if leader {
+			meta, err := g.generate(ctx, hash)
+			if err == nil {
+				g.put(hash, meta)
+			}
+			g.land(hash, f, meta)
+			return meta, err
+		}
+
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if f.meta != nil {
+			return f.meta, nil
+		}
+	}
+}
+
+// join returns the cached torrent of the blob if any, otherwise the flight of its generation,
+// and whether the caller is its leader, which must generate the torrent and land the flight.
+func (g *Generator) join(hash blossom.Hash) (*Metainfo, *flight, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if meta, ok := g.cache[hash]; ok {
+		return meta, nil, false
+	}
+	if f, ok := g.flights[hash]; ok {
+		return nil, f, false
+	}
+	f := &flight{done: make(chan struct{})}
+	g.flights[hash] = f
+	return nil, f, true
+}
+
+// land completes the flight with the generated torrent, nil if the generation failed.
+func (g *Generator) land(hash blossom.Hash, f *flight, meta *Metainfo) {
+	g.mu.Lock()
+	delete(g.flights, hash)
+	g.mu.Unlock()
+
+	f.meta = meta
+	close(f.done)
+}
+
+// generate hashes the pieces of the blob with the hash.
+func (g *Generator) generate(ctx context.Context, hash blossom.Hash) (*Metainfo, error) {
+	desc, err := g.store.Head(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if desc.Size < g.minSize {
+		return nil, fmt.Errorf("%w: %d bytes, the minimum is %d", ErrTooSmall, desc.Size, g.minSize)
+	}
+
+	blob, err := g.store.Get(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	pieceLength := g.pieceLengthFor(desc.Size)
+	data := utils.NewHashReader(&contextReader{ctx: ctx, r: blob}, &hash)
+	pieces, size, err := HashPieces(data, pieceLength)
+	if err != nil {
+		return nil, fmt.Errorf("torrent: failed to hash blob %s: %w", hash.Hex(), err)
+	}
+
+	return &Metainfo{
+		Name:        g.name(hash, desc.Type),
+		Size:        size,
+		PieceLength: pieceLength,
+		Pieces:      pieces,
+	}, nil
+}
+
+// outline returns the torrent of the blob with the descriptor if it's cached, or one whose pieces are zeroed otherwise.
+// The outline has the same size as the torrent, so that HEAD requests are answered without hashing the blob.
+func (g *Generator) outline(desc blossom.BlobDescriptor, webSeeds ...string) (*Metainfo, error) {
+	if desc.Size < g.minSize {
+		return nil, fmt.Errorf("%w: %d bytes, the minimum is %d", ErrTooSmall, desc.Size, g.minSize)
+	}
+
+	meta := &Metainfo{
+		Name:        g.name(desc.Hash, desc.Type),
+		Size:        desc.Size,
+		PieceLength: g.pieceLengthFor(desc.Size),
+	}
+	if cached, ok := g.cached(desc.Hash); ok {
+		*meta = *cached
+	} else {
+		count := (meta.Size + meta.PieceLength - 1) / meta.PieceLength
+		meta.Pieces = make([]byte, count*sha1.Size)
+	}
+
+	meta.WebSeeds = webSeeds
+	meta.Trackers = g.trackers
+	return meta, nil
+}
+
+// name returns the name of the torrent of the blob, which is its hash and the extension of its type.
+func (g *Generator) name(hash blossom.Hash, mime string) string {
+	return hash.Hex() + "." + g.extForMime(mime)
+}
+
+// pieceLengthFor returns the piece length of the torrent of a blob of the size.
+func (g *Generator) pieceLengthFor(size int64) int64 {
+	if g.pieceLength != 0 {
+		return g.pieceLength
+	}
+	return pieceLengthFor(size)
+}
+
+// pieceLengthFor returns the power of two piece length that gives about targetPieces pieces to a blob of the size.
+func pieceLengthFor(size int64) int64 {
+	length := int64(minAutoPiece)
+	for length < maxAutoPiece && size/length > targetPieces {
+		length *= 2
+	}
+	return length
+}
+
+func (g *Generator) cached(hash blossom.Hash) (*Metainfo, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	meta, ok := g.cache[hash]
+	return meta, ok
+}
+
+// put caches the torrent of the blob, evicting the oldest one if the cache is full.
+func (g *Generator) put(hash blossom.Hash, meta *Metainfo) {
+	if g.cacheSize == 0 {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.cache[hash]; ok {
+		return
+	}
+	if len(g.order) >= g.cacheSize {
+		delete(g.cache, g.order[0])
+		g.order = g.order[1:]
+	}
+	g.cache[hash] = meta
+	g.order = append(g.order, hash)
+}
+
+// Forget removes the torrent of the blob with the hash from the cache, for example after the blob is deleted.
+func (g *Generator) Forget(hash blossom.Hash) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.cache[hash]; !ok {
+		return
+	}
+	delete(g.cache, hash)
+	for i, h := range g.order {
+		if h == hash {
+			g.order = append(g.order[:i], g.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Bind serves the torrent of the blobs at GET /<sha256>.torrent, and their magnet link at GET /<sha256>.magnet,
+// with the URL of the blob as web seed (see [blossy.Server.BlobURL]). The requests go through the policies
+// and the Reject hooks of the Download endpoint of the server, and blobs smaller than the minimum size are not found.
+// HEAD requests are answered without hashing the blobs.
+//
+// The names of the torrents follow the MIME types of the server (see [blossy.WithMimeTypes]), so Bind must be called
+// before the generator is used. It also wraps the Delete hook of the server, to forget the torrents of the deleted blobs.
+func (g *Generator) Bind(s *blossy.Server) {
+	g.extForMime = s.ExtForMime
+
+	s.Derive("torrent", blossy.Derivation{
+		Download: func(r blossy.Request, hash blossom.Hash) (blossy.BlobDelivery, *blossom.Error) {
+			meta, err := g.forRequest(s, r, hash, false)
+			if err != nil {
+				return nil, err
+			}
+			return serve(meta.Bytes(), "application/x-bittorrent", blossy.Attachment(meta.Name+".torrent")), nil
+		},
+		Check: func(r blossy.Request, hash blossom.Hash) (blossy.MetaDelivery, *blossom.Error) {
+			meta, err := g.forRequest(s, r, hash, true)
+			if err != nil {
+				return nil, err
+			}
+			size := int64(len(meta.Bytes()))
+			return blossy.Found("application/x-bittorrent", size, blossy.Attachment(meta.Name+".torrent")), nil
+		},
+	})
+
+	s.Derive("magnet", blossy.Derivation{
+		Download: func(r blossy.Request, hash blossom.Hash) (blossy.BlobDelivery, *blossom.Error) {
+			meta, err := g.forRequest(s, r, hash, false)
+			if err != nil {
+				return nil, err
+			}
+			return serve([]byte(meta.Magnet()), "text/plain; charset=utf-8"), nil
+		},
+		Check: func(r blossy.Request, hash blossom.Hash) (blossy.MetaDelivery, *blossom.Error) {
+			meta, err := g.forRequest(s, r, hash, true)
+			if err != nil {
+				return nil, err
+			}
+			return blossy.Found("text/plain; charset=utf-8", int64(len(meta.Magnet()))), nil
+		},
+	})
+
+	if del := s.On.Delete; del != nil {
+		s.On.Delete = func(r blossy.Request, hash blossom.Hash) *blossom.Error {
+			err := del(r, hash)
+			if err == nil {
+				g.Forget(hash)
+			}
+			return err
+		}
+	}
+}
+
+// forRequest returns the torrent of the blob with the hash, with the URL of the blob on the server as web seed.
+// If outline is true, it returns its outline instead, which has the same size but doesn't require hashing the blob.
+func (g *Generator) forRequest(s *blossy.Server, r blossy.Request, hash blossom.Hash, outline bool) (*Metainfo, *blossom.Error) {
+	desc, err := g.store.Head(r.Context(), hash)
+	if err != nil {
+		return nil, blossy.WrapError(err)
+	}
+
+	seed, err := s.BlobURL(r, desc)
+	if err != nil {
+		g.log.Error("torrent: failed to build the URL of the blob", "hash", hash.Hex(), "error", err)
+		return nil, blossom.ErrInternal("Failed to build the URL of the blob")
+	}
+
+	var meta *Metainfo
+	if outline {
+		meta, err = g.outline(desc, seed)
+	} else {
+		meta, err = g.Metainfo(r.Context(), hash, seed)
+	}
+	if errors.Is(err, ErrTooSmall) {
+		return nil, blossom.ErrNotFound("Blob is too small to be served as a torrent")
+	}
+	if err != nil {
+		g.log.Error("torrent: failed to generate the torrent", "hash", hash.Hex(), "error", err)
+		return nil, blossy.WrapError(err)
+	}
+	return meta, nil
+}
+
+func serve(data []byte, mime string, opts ...blossy.DeliveryOption) blossy.BlobDelivery {
+	blob := blossom.BlobFromStream(io.NopCloser(bytes.NewReader(data)), int64(len(data)), mime)
+	return blossy.Serve(blob, opts...)
+}
+
+// contextReader stops reading when the context is cancelled, so that hashing large blobs can be interrupted.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
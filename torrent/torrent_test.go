@@ -0,0 +1,295 @@
+package torrent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/stores/memory"
+)
+
+var ctx = context.Background()
+
+func TestHashPieces(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 40<<10)
+	pieces, size, err := HashPieces(bytes.NewReader(data), 16<<10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if size != int64(len(data)) {
+		t.Errorf("expected size %d, got %d", len(data), size)
+	}
+
+	full := sha1.Sum(data[:16<<10])
+	last := sha1.Sum(data[32<<10:])
+	expected := append(append(append([]byte{}, full[:]...), full[:]...), last[:]...)
+	if !bytes.Equal(pieces, expected) {
+		t.Errorf("unexpected pieces %x", pieces)
+	}
+}
+
+func TestMetainfo(t *testing.T) {
+	meta := &Metainfo{
+		Name:        "a.txt",
+		Size:        3,
+		PieceLength: 16 << 10,
+		Pieces:      []byte("01234567890123456789"),
+		WebSeeds:    []string{"https://cdn.example.com/a.txt"},
+		Trackers:    []string{"udp://tracker.example.com:1337/announce"},
+	}
+
+	info := "d6:lengthi3e4:name5:a.txt12:piece lengthi16384e6:pieces20:01234567890123456789e"
+	if hash := meta.InfoHash(); hash != sha1.Sum([]byte(info)) {
+		t.Errorf("unexpected info hash %x", hash)
+	}
+
+	expected := "d8:announce39:udp://tracker.example.com:1337/announce" +
+		"13:announce-listll39:udp://tracker.example.com:1337/announceee" +
+		"10:created by6:blossy4:info" + info +
+		"8:url-listl29:https://cdn.example.com/a.txtee"
+	if got := string(meta.Bytes()); got != expected {
+		t.Errorf("unexpected torrent:\nexpected %s\ngot      %s", expected, got)
+	}
+
+	hash := meta.InfoHash()
+	magnet := meta.Magnet()
+	for _, part := range []string{
+		"xt=urn:btih:" + hex.EncodeToString(hash[:]),
+		"dn=a.txt",
+		"xl=3",
+		"ws=https%3A%2F%2Fcdn.example.com%2Fa.txt",
+		"tr=udp%3A%2F%2Ftracker.example.com%3A1337%2Fannounce",
+	} {
+		if !strings.Contains(magnet, part) {
+			t.Errorf("expected the magnet link to contain %q, got %s", part, magnet)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	store := memory.New()
+	for _, length := range []int64{1 << 10, 100 << 10} {
+		if _, err := New(store, WithPieceLength(length)); err == nil {
+			t.Errorf("expected piece length %d to be rejected", length)
+		}
+	}
+	if _, err := New(nil); err == nil {
+		t.Error("expected a nil store to be rejected")
+	}
+
+	tests := []struct {
+		size   int64
+		length int64
+	}{
+		{size: 1 << 20, length: 256 << 10},
+		{size: 1 << 30, length: 1 << 20},
+		{size: 1 << 40, length: 16 << 20},
+	}
+	for _, test := range tests {
+		if length := pieceLengthFor(test.size); length != test.length {
+			t.Errorf("pieceLengthFor(%d): expected %d, got %d", test.size, test.length, length)
+		}
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	store := memory.New()
+	data := bytes.Repeat([]byte("blossom"), 10<<10)
+	desc, err := store.Save(ctx, "", blossy.UploadHints{Type: "video/mp4", Size: -1}, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to save the blob: %v", err)
+	}
+	small, err := store.Save(ctx, "", blossy.UploadHints{Type: "text/plain", Size: -1}, strings.NewReader("small"))
+	if err != nil {
+		t.Fatalf("failed to save the blob: %v", err)
+	}
+
+	gen, err := New(store, WithMinSize(1<<10), WithPieceLength(16<<10))
+	if err != nil {
+		t.Fatalf("failed to create the generator: %v", err)
+	}
+
+	server, err := blossy.NewServer(blossy.WithHostname("cdn.example.com"))
+	if err != nil {
+		t.Fatalf("failed to create the server: %v", err)
+	}
+	blossy.BindStore(server, store)
+	gen.Bind(server)
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	get := func(path string) (*http.Response, []byte) {
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return res, body
+	}
+
+	res, body := get("/" + desc.Hash.Hex() + ".torrent")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected the torrent, got %d %s", res.StatusCode, body)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "application/x-bittorrent" {
+		t.Errorf("expected the torrent type, got %q", ct)
+	}
+
+	meta, err := gen.Metainfo(ctx, desc.Hash, "https://cdn.example.com/"+desc.Hash.Hex()+".mp4")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(body, meta.Bytes()) {
+		t.Errorf("unexpected torrent %q", body)
+	}
+	if meta.Name != desc.Hash.Hex()+".mp4" || meta.Size != int64(len(data)) || len(meta.Pieces) != 5*sha1.Size {
+		t.Errorf("unexpected metainfo %s %d %d", meta.Name, meta.Size, len(meta.Pieces))
+	}
+
+	res, body = get("/" + desc.Hash.Hex() + ".magnet")
+	if res.StatusCode != http.StatusOK || string(body) != meta.Magnet() {
+		t.Errorf("expected the magnet link, got %d %s", res.StatusCode, body)
+	}
+
+	res, _ = get("/" + small.Hash.Hex() + ".torrent")
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for a small blob, got %d", res.StatusCode)
+	}
+	if _, err := gen.Metainfo(ctx, small.Hash); !errors.Is(err, ErrTooSmall) {
+		t.Errorf("expected ErrTooSmall, got %v", err)
+	}
+
+	res, _ = get("/" + blossom.ComputeHash([]byte("missing")).Hex() + ".torrent")
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing blob, got %d", res.StatusCode)
+	}
+
+	// the torrent is cached until it's forgotten
+	if err := store.Delete(ctx, "", desc.Hash); err != nil {
+		t.Fatalf("failed to delete the blob: %v", err)
+	}
+	if _, err := gen.Metainfo(ctx, desc.Hash); err != nil {
+		t.Errorf("expected the cached torrent, got %v", err)
+	}
+	gen.Forget(desc.Hash)
+	if _, err := gen.Metainfo(ctx, desc.Hash); !errors.Is(err, blossy.ErrBlobNotFound) {
+		t.Errorf("expected ErrBlobNotFound, got %v", err)
+	}
+}
+
+// countingStore counts the blobs read from the store, and can block them until released.
+type countingStore struct {
+	blossy.Store
+	gets    atomic.Int32
+	release chan struct{}
+}
+
+func (c *countingStore) Get(ctx context.Context, hash blossom.Hash) (blossom.Blob, error) {
+	c.gets.Add(1)
+	if c.release != nil {
+		<-c.release
+	}
+	return c.Store.Get(ctx, hash)
+}
+
+func TestGeneratorHead(t *testing.T) {
+	store := &countingStore{Store: memory.New()}
+	data := bytes.Repeat([]byte("blossom"), 10<<10)
+	desc, err := store.Save(ctx, "", blossy.UploadHints{Type: "application/x-comic", Size: -1}, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to save the blob: %v", err)
+	}
+
+	gen, err := New(store, WithMinSize(1<<10))
+	if err != nil {
+		t.Fatalf("failed to create the generator: %v", err)
+	}
+	server, err := blossy.NewServer(
+		blossy.WithHostname("cdn.example.com"),
+		blossy.WithMimeTypes(map[string]string{"cbz": "application/x-comic"}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create the server: %v", err)
+	}
+	blossy.BindStore(server, store)
+	gen.Bind(server)
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	for _, ext := range []string{"torrent", "magnet"} {
+		res, err := http.Head(ts.URL + "/" + desc.Hash.Hex() + "." + ext)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		res.Body.Close()
+		if store.gets.Load() != 0 {
+			t.Fatalf("expected HEAD /<sha256>.%s not to read the blob", ext)
+		}
+
+		res, err = http.Get(ts.URL + "/" + desc.Hash.Hex() + "." + ext)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if int64(len(body)) != res.ContentLength {
+			t.Errorf("%s: expected the HEAD Content-Length %d to match the body, got %d bytes", ext, res.ContentLength, len(body))
+		}
+		store.gets.Store(0)
+	}
+
+	meta, err := gen.Metainfo(ctx, desc.Hash)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if meta.Name != desc.Hash.Hex()+".cbz" {
+		t.Errorf("expected the name to follow the MIME types of the server, got %s", meta.Name)
+	}
+}
+
+func TestGeneratorSingleflight(t *testing.T) {
+	store := &countingStore{Store: memory.New(), release: make(chan struct{})}
+	data := bytes.Repeat([]byte("blossom"), 10<<10)
+	desc, err := store.Save(ctx, "", blossy.UploadHints{Type: "video/mp4", Size: -1}, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to save the blob: %v", err)
+	}
+
+	gen, err := New(store, WithMinSize(1<<10))
+	if err != nil {
+		t.Fatalf("failed to create the generator: %v", err)
+	}
+
+	const calls = 10
+	var wg sync.WaitGroup
+	for range calls {
+		wg.Go(func() {
+			if _, err := gen.Metainfo(ctx, desc.Hash); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(store.release)
+	wg.Wait()
+
+	if n := store.gets.Load(); n != 1 {
+		t.Errorf("expected the blob to be hashed once, got %d", n)
+	}
+}
@@ -0,0 +1,103 @@
+package blossy
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+
+	"github.com/pippellia-btc/blossom"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the spans of the server.
+const tracerName = "github.com/pippellia-btc/blossy"
+
+// pubkeyPrefixLen is the length of the pubkey prefix recorded in the spans, which is enough to
+// tell users apart in traces without recording their full identity.
+const pubkeyPrefixLen = 8
+
+// startRequestSpan starts the span of an http request, continuing the trace of the client
+// if the request carries its context (e.g. the 'traceparent' header).
+// If tracing is not enabled (see [WithTracerProvider]), it returns the request and a nil span.
+func (s *Server) startRequestSpan(e Endpoint, r *http.Request) (*http.Request, trace.Span) {
+	if s.tracer == nil {
+		return r, nil
+	}
+
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := s.tracer.Start(ctx, "blossy."+endpointLabel(e),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("blossy.endpoint", endpointLabel(e)),
+			attribute.String("http.request.method", r.Method),
+		),
+	)
+	return r.WithContext(ctx), span
+}
+
+// endRequestSpan records the outcome of the request in its span, and ends it.
+func endRequestSpan(span trace.Span, r Request, res Response) {
+	if span == nil {
+		return
+	}
+
+	span.SetAttributes(attribute.Int("http.response.status_code", res.Status))
+	if pubkey := r.Pubkey(); pubkey != "" {
+		span.SetAttributes(attribute.String("blossy.pubkey_prefix", pubkey[:min(len(pubkey), pubkeyPrefixLen)]))
+	}
+	if res.Status >= 500 {
+		span.SetStatus(codes.Error, res.Reason)
+	}
+	span.End()
+}
+
+// traceHash records the hash of the blob in the span of the request, if any.
+func traceHash(r *http.Request, hash blossom.Hash) {
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("blossom.hash", hash.Hex()))
+}
+
+// traceHook starts a span for the invocation of the On hook of the endpoint, and replaces the context of the request
+// with the one of the span, so that the spans started by the hook (e.g. store calls) are its children.
+// The returned function ends the span, recording the error of the hook, if any.
+func (s *Server) traceHook(r *request, e Endpoint) func(err *blossom.Error) {
+	if s.tracer == nil {
+		return func(*blossom.Error) {}
+	}
+
+	ctx, span := s.tracer.Start(r.raw.Context(), "blossy.hook."+endpointLabel(e))
+	r.raw = r.raw.WithContext(ctx)
+
+	return func(err *blossom.Error) {
+		if err != nil {
+			span.SetAttributes(attribute.Int("blossom.error.code", err.Code))
+			span.SetStatus(codes.Error, err.Reason)
+		}
+		span.End()
+	}
+}
+
+// traceStore starts a span for the operation of a [Store] bound with [BindStore].
+// The returned function ends the span, recording the error of the store, unless the blob was not found.
+func (s *Server) traceStore(ctx context.Context, op string, hash *blossom.Hash) (context.Context, func(err error)) {
+	if s.tracer == nil {
+		return ctx, func(error) {}
+	}
+
+	ctx, span := s.tracer.Start(ctx, "blossy.store."+op)
+	if hash != nil {
+		span.SetAttributes(attribute.String("blossom.hash", hash.Hex()))
+	}
+
+	return ctx, func(err error) {
+		if err != nil && !errors.Is(err, ErrBlobNotFound) && !errors.Is(err, fs.ErrNotExist) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
@@ -2,50 +2,145 @@ package blossy
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/pippellia-btc/blossom"
 )
 
+// Endpoint identifies an endpoint of the blossom server.
+type Endpoint string
+
+const (
+	EndpointDownload Endpoint = "download" // GET /<sha256>.<ext>
+	EndpointCheck    Endpoint = "check"    // HEAD /<sha256>.<ext>
+	EndpointDelete   Endpoint = "delete"   // DELETE /<sha256>
+	EndpointUpload   Endpoint = "upload"   // PUT /upload and HEAD /upload
+	EndpointMirror   Endpoint = "mirror"   // PUT /mirror
+	EndpointMedia    Endpoint = "media"    // PUT /media and HEAD /media
+	EndpointReport   Endpoint = "report"   // PUT /report
+	EndpointList     Endpoint = "list"     // GET /list/<pubkey>
+)
+
+// Endpoints returns all the endpoints of the blossom server.
+func Endpoints() []Endpoint {
+	return []Endpoint{
+		EndpointDownload,
+		EndpointCheck,
+		EndpointDelete,
+		EndpointUpload,
+		EndpointMirror,
+		EndpointMedia,
+		EndpointReport,
+		EndpointList,
+	}
+}
+
 // BlobDelivery represents how a blob should be delivered to the client.
-// Use [Serve] to serve a [blossom.Blob] directly to the client or [Redirect] to redirect the client to another URL.
+// Use [Serve] to serve a [blossom.Blob] directly to the client, [Redirect] to redirect the client to another URL,
+// or [Blocked] to refuse serving it for legal reasons.
 type BlobDelivery interface {
 	sealBlob() // seal the interface
 }
 
 // MetaDelivery represents how blob metadata should be delivered to the client.
-// Use [Found] to return the metadata directly, or [Redirect] to redirect the client to another URL.
+// Use [Found] to return the metadata directly, [Redirect] to redirect the client to another URL,
+// or [Blocked] to refuse serving it for legal reasons.
 type MetaDelivery interface {
 	sealMeta() // seal the interface
 }
 
+// DeliveryOption customizes the response of a [BlobDelivery] or a [MetaDelivery].
+type DeliveryOption func(*delivery)
+
+type delivery struct {
+	// cacheControl overrides the 'Cache-Control' header configured with [WithCacheControl], if not nil.
+	cacheControl *string
+
+	// lastModified is the 'Last-Modified' header of the blob, if not zero.
+	lastModified time.Time
+
+	// disposition and filename override the 'Content-Disposition' header configured with [WithContentDisposition],
+	// if the disposition is not empty.
+	disposition string
+	filename    string
+}
+
+func newDelivery(opts []DeliveryOption) delivery {
+	var d delivery
+	for _, opt := range opts {
+		opt(&d)
+	}
+	return d
+}
+
+// CacheControl overrides the 'Cache-Control' header of the response, which is configured with [WithCacheControl].
+// Use an empty string to omit the header, for example for blobs that might be deleted soon.
+func CacheControl(directives string) DeliveryOption {
+	return func(d *delivery) {
+		d.cacheControl = &directives
+	}
+}
+
+// LastModified sets the 'Last-Modified' header of the response (e.g. to the upload time of the blob),
+// which lets browsers and caches revalidate the blob with 'If-Modified-Since'.
+// For blobs created with [BlobFromFile], it replaces the modification time of the file.
+func LastModified(t time.Time) DeliveryOption {
+	return func(d *delivery) {
+		d.lastModified = t
+	}
+}
+
+// Inline sets the 'Content-Disposition' header of the response to inline, so that browsers display the blob,
+// and suggest the filename (e.g. the alt text of its NIP-94 event) when it's saved.
+// If the filename is empty, it's derived from the hash and the extension of the blob.
+func Inline(filename string) DeliveryOption {
+	return func(d *delivery) {
+		d.disposition = "inline"
+		d.filename = filename
+	}
+}
+
+// Attachment sets the 'Content-Disposition' header of the response to attachment, so that browsers download
+// the blob with the filename instead of displaying it.
+// If the filename is empty, it's derived from the hash and the extension of the blob.
+func Attachment(filename string) DeliveryOption {
+	return func(d *delivery) {
+		d.disposition = "attachment"
+		d.filename = filename
+	}
+}
+
 type servedBlob struct {
 	blossom.Blob
+	delivery
 }
 
 func (servedBlob) sealBlob() {}
 
 // Serve creates a BlobDelivery that serves the blob directly to the client.
-func Serve(blob blossom.Blob) BlobDelivery {
-	return servedBlob{blob}
+func Serve(blob blossom.Blob, opts ...DeliveryOption) BlobDelivery {
+	return servedBlob{Blob: blob, delivery: newDelivery(opts)}
 }
 
 type foundBlob struct {
 	mime string
 	size int64
+	delivery
 }
 
 func (foundBlob) sealMeta() {}
 
 // Found creates a MetaDelivery that returns the blob metadata directly to the client.
-func Found(mime string, size int64) MetaDelivery {
-	return foundBlob{mime: mime, size: size}
+func Found(mime string, size int64, opts ...DeliveryOption) MetaDelivery {
+	return foundBlob{mime: mime, size: size, delivery: newDelivery(opts)}
 }
 
 // redirect can be used as both [BlobDelivery] and [MetaDelivery].
 type redirect struct {
 	url  string
 	code int
+	delivery
 }
 
 func (redirect) sealBlob() {}
@@ -54,11 +149,51 @@ func (redirect) sealMeta() {}
 // Redirect creates a response that redirects the client to the given URL.
 // It can be used as both [BlobDelivery] and [MetaDelivery].
 // Common status codes are http.StatusFound (302) or http.StatusMovedPermanently (301).
-func Redirect(url string, code int) redirect {
+// Redirects have no 'Cache-Control' header, unless it's set with [CacheControl].
+func Redirect(url string, code int, opts ...DeliveryOption) redirect {
 	if code == 0 {
 		code = http.StatusFound
 	}
-	return redirect{url: url, code: code}
+	return redirect{url: url, code: code, delivery: newDelivery(opts)}
+}
+
+// blockedBlob can be used as both [BlobDelivery] and [MetaDelivery].
+type blockedBlob struct {
+	reason string
+}
+
+func (blockedBlob) sealBlob() {}
+func (blockedBlob) sealMeta() {}
+
+// Blocked creates a response that refuses to deliver the blob with 451 Unavailable For Legal Reasons,
+// and the reason in the 'X-Reason' header. Unlike a 404, it tells clients that the blob has been taken down
+// or quarantined by the operator. It can be used as both [BlobDelivery] and [MetaDelivery].
+//
+// Cached blobs are served without calling the Download hook (see [WithBlobCache]), so blobs that
+// must never be served should rather be rejected in the Reject hooks with [ErrBlocked].
+func Blocked(reason string) blockedBlob {
+	return blockedBlob{reason: reason}
+}
+
+// ErrBlocked returns a 451 Unavailable For Legal Reasons error, for Reject hooks that refuse to serve blocked blobs.
+// Blocked requests are recorded separately from the other rejections in the metrics (see [WithMetrics]).
+func ErrBlocked(reason string) *blossom.Error {
+	return &blossom.Error{Code: http.StatusUnavailableForLegalReasons, Reason: reason}
+}
+
+// Response summarizes the response written by the server to a request.
+type Response struct {
+	// Status is the HTTP status code of the response.
+	Status int
+
+	// Written is the number of bytes written in the response body.
+	Written int64
+
+	// Duration is the time elapsed from the routing of the request to the end of the response.
+	Duration time.Duration
+
+	// Reason is the value of the 'X-Reason' header, which is set when the request failed.
+	Reason string
 }
 
 // UploadHints contains hints about the uploaded blob as reported by the client.
@@ -76,6 +211,58 @@ type UploadHints struct {
 	// Size is the size in bytes of the uploaded blob.
 	// If unknown, it will be -1.
 	Size int64
+
+	// PreCheck is true when the hints come from a HEAD /upload or HEAD /media request (BUD-06),
+	// where the client asks whether the upload would be accepted, without uploading the blob.
+	// Unlike the other fields, it is set by the server and can be trusted.
+	PreCheck bool
+}
+
+// ListQuery contains the filters of a GET /list/<pubkey> request, as specified by BUD-02,
+// and the page of blobs to return. Use [ListQuery.Apply] to apply it to a list of descriptors.
+type ListQuery struct {
+	// Since filters out blobs uploaded before this time.
+	// If unknown, it will be the zero time.
+	Since time.Time
+
+	// Until filters out blobs uploaded after this time.
+	// If unknown, it will be the zero time.
+	Until time.Time
+
+	// Types filters out blobs whose type doesn't match any of the patterns, which can be media types
+	// (e.g. "image/png"), type wildcards (e.g. "image/*") or "*/*". If empty, blobs of every type are listed.
+	Types []string
+
+	// Order is the sort order of the blobs by upload time. The zero value lists the newest first.
+	Order SortOrder
+
+	// Limit is the maximum number of blobs to return. If 0, all of them are returned.
+	Limit int
+
+	// Cursor is the hash of the last blob of the previous page, and the page starts right after it.
+	// If nil, the page starts from the first blob.
+	Cursor *blossom.Hash
+}
+
+// SortOrder is the order of the blobs of a GET /list/<pubkey> request, by upload time.
+// Blobs uploaded at the same time are sorted by hash.
+type SortOrder string
+
+const (
+	Newest SortOrder = "desc" // newest first, the default
+	Oldest SortOrder = "asc"  // oldest first
+)
+
+// ListStats summarizes the blobs of a pubkey, as returned by GET /list/<pubkey>/stats.
+type ListStats struct {
+	// Blobs is the number of blobs owned by the pubkey.
+	Blobs int64 `json:"blobs"`
+
+	// Bytes is the total size of the blobs owned by the pubkey.
+	Bytes int64 `json:"bytes"`
+
+	// LastUpload is the unix time of the last upload of the pubkey, or 0 if it has no blobs.
+	LastUpload int64 `json:"last_upload"`
 }
 
 // ReportedBlob represents a blob that was reported for the provided reason.
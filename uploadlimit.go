@@ -0,0 +1,117 @@
+package blossy
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+// uploadRetryAfter is the 'Retry-After' of the uploads refused because too many are in progress.
+const uploadRetryAfter = 5 * time.Second
+
+// uploadLimiter limits the number of uploads in progress, globally and per IP group.
+type uploadLimiter struct {
+	// slots has a buffer of the maximum number of concurrent uploads. If nil, they are not limited globally.
+	slots chan struct{}
+	wait  time.Duration
+
+	// perIP is the maximum number of concurrent uploads of each IP group. If 0, they are not limited per IP.
+	perIP int
+
+	mu     sync.Mutex
+	active map[string]int
+}
+
+// acquireUpload reserves a slot for the upload of the request, as configured with [WithMaxConcurrentUploads]
+// and [WithMaxConcurrentUploadsPerIP]. The returned function releases the slot, and must be called when the upload is done.
+// It might set the 'Retry-After' header to accompany the returned error.
+func (s *Server) acquireUpload(w http.ResponseWriter, r Request) (func(), *blossom.Error) {
+	l := s.settings.Upload.limiter
+	if l == nil {
+		return func() {}, nil
+	}
+
+	group := r.IP().Group()
+	if !l.acquireIP(group) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(uploadRetryAfter.Seconds())))
+		return nil, ErrRateLimited("too many concurrent uploads from this IP address")
+	}
+
+	if !l.acquire(r) {
+		l.releaseIP(group)
+		w.Header().Set("Retry-After", strconv.Itoa(int(uploadRetryAfter.Seconds())))
+		return nil, blossom.ErrUnavailable("too many uploads in progress, retry later")
+	}
+
+	return func() {
+		l.release()
+		l.releaseIP(group)
+	}, nil
+}
+
+// acquire reserves a global slot, waiting for one to be released for at most the configured wait,
+// or until the request is cancelled. It reports whether a slot was reserved.
+func (l *uploadLimiter) acquire(r Request) bool {
+	if l.slots == nil {
+		return true
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if l.wait <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (l *uploadLimiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// acquireIP reserves a slot for the IP group, and reports whether it was reserved.
+func (l *uploadLimiter) acquireIP(group string) bool {
+	if l.perIP <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[group] >= l.perIP {
+		return false
+	}
+	l.active[group]++
+	return true
+}
+
+func (l *uploadLimiter) releaseIP(group string) {
+	if l.perIP <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active[group]--
+	if l.active[group] <= 0 {
+		delete(l.active, group)
+	}
+}
@@ -1,9 +1,14 @@
 package utils
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"hash"
 	"io"
+	"mime"
 	"net/url"
 	"strings"
 
@@ -37,6 +42,20 @@ func ValidateBlossomURL(url *url.URL) error {
 	return err
 }
 
+// ValidatePubkey checks whether the provided string is a valid nostr pubkey,
+// which is 64 lowercase hex characters.
+func ValidatePubkey(pubkey string) error {
+	if len(pubkey) != 64 {
+		return errors.New("pubkey must be 64 hex characters")
+	}
+	for _, c := range pubkey {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return errors.New("pubkey must be lowercase hex")
+		}
+	}
+	return nil
+}
+
 // ValidateHostname checks whether the provided hostname is a valid hostname.
 func ValidateHostname(hostname string) error {
 	if hostname == "" {
@@ -56,6 +75,100 @@ func ValidateHostname(hostname string) error {
 	return nil
 }
 
+// MatchETag reports whether the value of an 'If-None-Match' header matches the provided etag,
+// using the weak comparison of RFC 7232. The etag must be quoted, e.g. "\"abc\"".
+func MatchETag(ifNoneMatch, etag string) bool {
+	for candidate := range strings.SplitSeq(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// MediaType returns the lowercase media type of a 'Content-Type' value, without parameters.
+// For example "Text/Plain; charset=utf-8" returns "text/plain".
+// It returns an empty string if the value is invalid.
+func MediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.Contains(mediaType, "/") {
+		return ""
+	}
+	return mediaType
+}
+
+// ValidateTypePattern checks whether the provided pattern can be used with [MatchMediaType].
+func ValidateTypePattern(pattern string) error {
+	if pattern == "*/*" {
+		return nil
+	}
+
+	main, sub, found := strings.Cut(pattern, "/")
+	if !found || main == "" || sub == "" || main == "*" {
+		return errors.New("type pattern must be of the form \"type/subtype\", \"type/*\" or \"*/*\"")
+	}
+	if sub != "*" && MediaType(pattern) == "" {
+		return errors.New("type pattern is not a valid media type")
+	}
+	return nil
+}
+
+// MatchMediaType reports whether the media type of the 'Content-Type' value matches the pattern,
+// which can be a media type (e.g. "image/png"), a type wildcard (e.g. "image/*") or "*/*".
+// The comparison is case-insensitive, and parameters are ignored.
+func MatchMediaType(pattern, contentType string) bool {
+	mediaType := MediaType(contentType)
+	if mediaType == "" {
+		return false
+	}
+
+	pattern = strings.ToLower(pattern)
+	if pattern == "*/*" {
+		return true
+	}
+	if main, found := strings.CutSuffix(pattern, "/*"); found {
+		return strings.HasPrefix(mediaType, main+"/")
+	}
+	return mediaType == pattern
+}
+
+// IsStructuredDigest reports whether the value of a 'Content-Digest' or 'Repr-Digest' header
+// uses the structured format of RFC 9530 (e.g. "sha-256=:<base64>:"), rather than a bare hex hash.
+func IsStructuredDigest(value string) bool {
+	return strings.Contains(value, "=:")
+}
+
+// ParseDigest parses the sha256 hash from the value of a 'Content-Digest' or 'Repr-Digest' header
+// in the structured format of RFC 9530, e.g. "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:".
+// Other algorithms in the value are ignored, but the sha-256 digest must be present.
+func ParseDigest(value string) (blossom.Hash, error) {
+	for member := range strings.SplitSeq(value, ",") {
+		key, digest, found := strings.Cut(strings.TrimSpace(member), "=")
+		if !found || strings.ToLower(key) != "sha-256" {
+			continue
+		}
+
+		digest, _, _ = strings.Cut(digest, ";") // ignore parameters
+		if len(digest) < 2 || digest[0] != ':' || digest[len(digest)-1] != ':' {
+			return blossom.Hash{}, errors.New("sha-256 digest must be a byte sequence enclosed in colons")
+		}
+
+		sum, err := base64.StdEncoding.DecodeString(digest[1 : len(digest)-1])
+		if err != nil {
+			return blossom.Hash{}, fmt.Errorf("sha-256 digest is not valid base64: %w", err)
+		}
+		if len(sum) != sha256.Size {
+			return blossom.Hash{}, fmt.Errorf("sha-256 digest must be %d bytes, got %d", sha256.Size, len(sum))
+		}
+		return blossom.ParseHash(hex.EncodeToString(sum))
+	}
+	return blossom.Hash{}, errors.New("no sha-256 digest found")
+}
+
 // ReadNoMore reads at most limit bytes from the reader.
 // If the reader contains more than limit bytes, it returns a "body too large" error.
 func ReadNoMore(r io.Reader, limit int) ([]byte, *blossom.Error) {
@@ -94,3 +207,57 @@ func DecodeBase64(s string) ([]byte, error) {
 		return base64.RawStdEncoding.DecodeString(s)
 	}
 }
+
+// ErrHashMismatch is returned by [HashReader] when the hash of the data doesn't match the expected one.
+var ErrHashMismatch = errors.New("sha256 of the data doesn't match the expected hash")
+
+// HashReader computes the sha256 hash of the data read through it, without buffering it.
+// If an expected hash is provided, it returns [ErrHashMismatch] instead of [io.EOF]
+// when the data has been fully read and its hash doesn't match the expected one.
+type HashReader struct {
+	r        io.Reader
+	h        hash.Hash
+	expected *blossom.Hash
+
+	sum      blossom.Hash
+	done     bool
+	mismatch bool
+}
+
+// NewHashReader returns a [HashReader] reading from r. The expected hash can be nil.
+func NewHashReader(r io.Reader, expected *blossom.Hash) *HashReader {
+	return &HashReader{r: r, h: sha256.New(), expected: expected}
+}
+
+func (r *HashReader) Read(p []byte) (int, error) {
+	if r.done {
+		if r.mismatch {
+			return 0, ErrHashMismatch
+		}
+		return 0, io.EOF
+	}
+
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+
+	if errors.Is(err, io.EOF) {
+		r.done = true
+		r.sum, _ = blossom.ParseHash(hex.EncodeToString(r.h.Sum(nil)))
+		if r.expected != nil && *r.expected != r.sum {
+			r.mismatch = true
+			return n, ErrHashMismatch
+		}
+	}
+	return n, err
+}
+
+// Sum returns the sha256 hash of the data, and whether the data has been fully read.
+// If the data has not been fully read, the returned hash is the zero value.
+func (r *HashReader) Sum() (blossom.Hash, bool) {
+	return r.sum, r.done
+}
+
+// Mismatch returns whether the data has been fully read and its hash doesn't match the expected one.
+func (r *HashReader) Mismatch() bool {
+	return r.mismatch
+}
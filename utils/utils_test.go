@@ -1,10 +1,16 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"strings"
 	"testing"
+
+	"github.com/pippellia-btc/blossom"
 )
 
 func TestParseHashExt(t *testing.T) {
@@ -97,6 +103,40 @@ func TestValidateBlossomURL(t *testing.T) {
 	}
 }
 
+func TestValidatePubkey(t *testing.T) {
+	tests := []struct {
+		pubkey  string
+		isValid bool
+	}{
+		// valid
+		{"abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789", true},
+		{"0000000000000000000000000000000000000000000000000000000000000000", true},
+
+		// invalid: empty
+		{"", false},
+
+		// invalid: wrong length
+		{"abcdef0123456789abcdef0123456789abcdef0123456789abcdef012345678", false},
+		{"abcdef0123456789abcdef0123456789abcdef0123456789abcdef01234567890", false},
+
+		// invalid: uppercase or non hex characters
+		{"ABCDEF0123456789abcdef0123456789abcdef0123456789abcdef0123456789", false},
+		{"xyzdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789", false},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("Case=%d", i), func(t *testing.T) {
+			err := ValidatePubkey(test.pubkey)
+			if test.isValid && err != nil {
+				t.Errorf("expected %q to be valid, got error: %v", test.pubkey, err)
+			}
+			if !test.isValid && err == nil {
+				t.Errorf("expected %q to be invalid, but got no error", test.pubkey)
+			}
+		})
+	}
+}
+
 func TestValidateHostname(t *testing.T) {
 	tests := []struct {
 		hostname string
@@ -149,6 +189,118 @@ func TestValidateHostname(t *testing.T) {
 	}
 }
 
+func TestMatchETag(t *testing.T) {
+	etag := `"aabbccdd"`
+	tests := []struct {
+		header string
+		match  bool
+	}{
+		{`"aabbccdd"`, true},
+		{`W/"aabbccdd"`, true},
+		{`"11223344", "aabbccdd"`, true},
+		{`*`, true},
+
+		{``, false},
+		{`"11223344"`, false},
+		{`aabbccdd`, false},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("Case=%d", i), func(t *testing.T) {
+			if got := MatchETag(test.header, etag); got != test.match {
+				t.Errorf("expected %v for header %q, got %v", test.match, test.header, got)
+			}
+		})
+	}
+}
+
+func TestMatchMediaType(t *testing.T) {
+	tests := []struct {
+		pattern     string
+		contentType string
+		match       bool
+	}{
+		{"image/png", "image/png", true},
+		{"image/png", "IMAGE/PNG", true},
+		{"image/*", "image/webp", true},
+		{"Image/*", "image/webp", true},
+		{"text/plain", "text/plain; charset=utf-8", true},
+		{"*/*", "application/pdf", true},
+
+		{"image/png", "image/jpeg", false},
+		{"image/*", "video/mp4", false},
+		{"image/*", "imagex/png", false},
+		{"*/*", "", false},
+		{"*/*", "invalid", false},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("Case=%d", i), func(t *testing.T) {
+			if got := MatchMediaType(test.pattern, test.contentType); got != test.match {
+				t.Errorf("expected %v for pattern %q and type %q, got %v", test.match, test.pattern, test.contentType, got)
+			}
+		})
+	}
+}
+
+func TestValidateTypePattern(t *testing.T) {
+	valid := []string{"image/png", "image/*", "*/*", "application/vnd.api+json"}
+	for _, pattern := range valid {
+		if err := ValidateTypePattern(pattern); err != nil {
+			t.Errorf("expected %q to be valid, got %v", pattern, err)
+		}
+	}
+
+	invalid := []string{"", "image", "image/", "/png", "*/png", "ima ge/png"}
+	for _, pattern := range invalid {
+		if err := ValidateTypePattern(pattern); err == nil {
+			t.Errorf("expected %q to be invalid, got nil", pattern)
+		}
+	}
+}
+
+func TestParseDigest(t *testing.T) {
+	// sha256 of "hello world"
+	expected := "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+	encoded := "uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek="
+
+	tests := []struct {
+		name    string
+		value   string
+		isValid bool
+	}{
+		{"simple", "sha-256=:" + encoded + ":", true},
+		{"uppercase algorithm", "SHA-256=:" + encoded + ":", true},
+		{"multiple algorithms", "sha-512=:AAAA:, sha-256=:" + encoded + ":", true},
+		{"with parameters", "sha-256=:" + encoded + ":;foo=bar", true},
+
+		{"empty", "", false},
+		{"only sha-512", "sha-512=:AAAA:", false},
+		{"missing colons", "sha-256=" + encoded, false},
+		{"hex", "sha-256=:" + expected + ":", false},
+		{"wrong length", "sha-256=:AAAA:", false},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d_%s", i, test.name), func(t *testing.T) {
+			hash, err := ParseDigest(test.value)
+			if !test.isValid {
+				if err == nil {
+					t.Fatalf("expected error, got hash %s", hash)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if hash.Hex() != expected {
+				t.Fatalf("expected hash %s, got %s", expected, hash)
+			}
+		})
+	}
+}
+
 func TestReadNoMore(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -236,3 +388,52 @@ func TestDecodeBase64(t *testing.T) {
 		})
 	}
 }
+
+func TestHashReader(t *testing.T) {
+	data := "hello blossom"
+	sum := sha256.Sum256([]byte(data))
+	correct, _ := blossom.ParseHash(hex.EncodeToString(sum[:]))
+	wrong, _ := blossom.ParseHash("1111111111111111111111111111111111111111111111111111111111111111")
+
+	tests := []struct {
+		name     string
+		expected *blossom.Hash
+		isValid  bool
+	}{
+		{"no expected hash", nil, true},
+		{"matching hash", &correct, true},
+		{"wrong hash", &wrong, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := NewHashReader(strings.NewReader(data), test.expected)
+			got, err := io.ReadAll(reader)
+
+			if !test.isValid {
+				if !errors.Is(err, ErrHashMismatch) {
+					t.Fatalf("expected ErrHashMismatch, got %v", err)
+				}
+				if !reader.Mismatch() {
+					t.Fatalf("expected mismatch to be reported")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(got) != data {
+				t.Errorf("expected data %q, got %q", data, got)
+			}
+
+			hash, done := reader.Sum()
+			if !done {
+				t.Fatalf("expected reader to be done")
+			}
+			if hash != correct {
+				t.Errorf("expected hash %s, got %s", correct, hash)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package blossy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// webhookAttempts is the number of attempts to deliver an event to a webhook.
+	webhookAttempts = 4
+
+	// webhookBackoff is the delay before the first retry, which doubles at every attempt.
+	webhookBackoff = time.Second
+
+	// webhookConcurrency is the maximum number of deliveries in flight to a webhook.
+	// Events emitted while all of them are busy are dropped.
+	webhookConcurrency = 32
+
+	// webhookTimeout is the timeout of each delivery attempt.
+	webhookTimeout = 10 * time.Second
+)
+
+// webhook delivers the events of the server to an external URL.
+type webhook struct {
+	url    string
+	secret []byte
+	events []EventType
+
+	client   *http.Client
+	inflight chan struct{}
+	backoff  time.Duration
+
+	// ctx is the context of the deliveries while the server is serving, nil otherwise.
+	// Once the server stopped, events are dropped.
+	mu         sync.Mutex
+	ctx        context.Context
+	stopped    bool
+	deliveries sync.WaitGroup
+}
+
+func newWebhook(url, secret string, events []EventType) *webhook {
+	return &webhook{
+		url:      url,
+		secret:   []byte(secret),
+		events:   events,
+		client:   &http.Client{Timeout: webhookTimeout},
+		inflight: make(chan struct{}, webhookConcurrency),
+		backoff:  webhookBackoff,
+	}
+}
+
+// wants returns whether the webhook is subscribed to the type of events.
+func (w *webhook) wants(t EventType) bool {
+	return len(w.events) == 0 || slices.Contains(w.events, t)
+}
+
+// run binds the deliveries to the context of the server while it's serving (see [Server.Background]).
+// When the server stops, it waits for the deliveries in flight for up to drain, after which they are cancelled.
+func (w *webhook) run(ctx context.Context, drain time.Duration) {
+	deliveryCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+
+	w.mu.Lock()
+	w.ctx, w.stopped = deliveryCtx, false
+	w.mu.Unlock()
+
+	<-ctx.Done()
+	timer := time.AfterFunc(drain, cancel)
+	defer timer.Stop()
+
+	w.mu.Lock()
+	w.stopped = true
+	w.mu.Unlock()
+	w.deliveries.Wait()
+}
+
+// send delivers the event in the background, without blocking the request that produced it.
+// If the server is not serving with [Server.Serve] (e.g. it's mounted on another http server),
+// the deliveries are not bound to its lifetime.
+func (w *webhook) send(e Event, log *slog.Logger) {
+	if !w.wants(e.Type) {
+		return
+	}
+
+	select {
+	case w.inflight <- struct{}{}:
+	default:
+		log.Warn("webhook: too many deliveries in flight, event dropped", "url", w.url, "type", e.Type)
+		return
+	}
+
+	w.mu.Lock()
+	if w.stopped {
+		w.mu.Unlock()
+		<-w.inflight
+		log.Warn("webhook: the server is stopped, event dropped", "url", w.url, "type", e.Type)
+		return
+	}
+	ctx := w.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	w.deliveries.Add(1)
+	w.mu.Unlock()
+
+	go func() {
+		defer w.deliveries.Done()
+		defer func() { <-w.inflight }()
+		if err := w.deliver(ctx, e); err != nil {
+			log.Error("webhook: failed to deliver event", "url", w.url, "type", e.Type, "error", err)
+		}
+	}()
+}
+
+// deliver posts the event to the URL, retrying with an exponential backoff on network and server errors,
+// until it succeeds, it runs out of attempts or the context is cancelled.
+func (w *webhook) deliver(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	backoff := w.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(ctx, e.Type, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt == webhookAttempts {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w (last attempt: %w)", ctx.Err(), err)
+		}
+		backoff *= 2
+	}
+}
+
+// post sends a single delivery attempt, and returns whether a failure is worth retrying.
+func (w *webhook) post(ctx context.Context, t EventType, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Blossy-Event", string(t))
+	req.Header.Set("X-Blossy-Timestamp", timestamp)
+	req.Header.Set("X-Blossy-Signature", "sha256="+signWebhook(w.secret, timestamp, body))
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return false, nil
+	case res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("unexpected status %d", res.StatusCode)
+	default:
+		return false, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+}
+
+// signWebhook returns the hex encoded HMAC-SHA256 of the timestamp and the body of a delivery.
+func signWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook verifies that a webhook delivery received by an external system was sent by a server configured
+// with the same secret (see [WithWebhooks]), and that it's not older than maxAge, to prevent replays.
+// The body is the one of the request, which must be read by the caller.
+func VerifyWebhook(r *http.Request, body []byte, secret string, maxAge time.Duration) error {
+	timestamp := r.Header.Get("X-Blossy-Timestamp")
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("webhook: 'X-Blossy-Timestamp' header is missing or invalid")
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > maxAge || age < -maxAge {
+		return errors.New("webhook: the delivery is too old")
+	}
+
+	signature, ok := strings.CutPrefix(r.Header.Get("X-Blossy-Signature"), "sha256=")
+	if !ok {
+		return errors.New("webhook: 'X-Blossy-Signature' header is missing or invalid")
+	}
+	expected := signWebhook([]byte(secret), timestamp, body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return errors.New("webhook: invalid signature")
+	}
+	return nil
+}
+
+// emit publishes the event to the event stream and to the webhooks, if configured.
+func (s *Server) emit(e Event) {
+	s.settings.HTTP.events.publish(e)
+	for _, w := range s.settings.Sys.webhooks {
+		w.send(e, s.log)
+	}
+}
+
+// validateWebhook checks the URL and the events of a webhook.
+func validateWebhook(w *webhook) error {
+	u, err := url.Parse(w.url)
+	if err != nil {
+		return fmt.Errorf("webhook: invalid URL %q: %w", w.url, err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+		return fmt.Errorf("webhook: invalid URL %q: must be an absolute HTTP(S) URL", w.url)
+	}
+	if len(w.secret) == 0 {
+		return errors.New("webhook: secret must not be empty")
+	}
+	for _, t := range w.events {
+		switch t {
+		case EventUpload, EventDelete, EventReport, EventReject, EventQuotaExceeded:
+		default:
+			return fmt.Errorf("webhook: unknown event type %q", t)
+		}
+	}
+	return nil
+}